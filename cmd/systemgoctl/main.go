@@ -0,0 +1,578 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/imunhatep/systemgo/rpc"
+	"github.com/imunhatep/systemgo/rpc/pb"
+	"github.com/imunhatep/systemgo/system"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+const usage = `usage: systemgoctl [-grpc address] [-token token] <command> [flags]
+
+The address may be a unix socket, "unix:/run/systemgo.sock".
+
+commands:
+  status [name]...  show the state, pid, runs, last exit code, memory and uptime of
+                    the services, all of them if none is named
+  logs [-f] [-n 20] [-level warn] <name>
+                    show the last lines of a service, -f follows its output after them
+  reload            read the configuration of the supervisor again and apply it,
+                    the changes are shown
+  plan -f <file>    show what applying the configuration file would do
+  diff -f <file>    compare the configuration hashes of the file with the running ones,
+                    fails if any differs
+  start <name>...   start services, each one is reported
+  stop <name>...    stop services, each one is reported
+  restart <name>... restart services, each one is reported
+  restart -dependents <name>
+                    restart a service and everything depending on it, the plan is
+                    shown before and after it ran
+  freeze <name>...  pause services with SIGSTOP, keeping their processes
+  thaw <name>...    resume frozen services with SIGCONT
+  show <name>       show the configuration a service runs with, "*" marks defaults
+  capabilities      show the features of /proc the supervisor can use
+  top               show the cpu time and peak memory of the services since the start
+  audit -f <file> [-since 1h]
+                    show the operations of the audit log, the ones that never finished too
+`
+
+func main() {
+	addr := flag.String("grpc", "127.0.0.1:7070", "address of the gRPC management API")
+	token := flag.String("token", "", "token of the management API")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout of a call")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if *token != "" {
+		opts = append(opts, rpc.TokenCredentials(*token))
+	}
+
+	conn, err := grpc.Dial(*addr, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	client := pb.NewSupervisorClient(conn)
+
+	switch flag.Arg(0) {
+	case "status":
+		err = statuses(ctx, client, flag.Args()[1:])
+	case "logs":
+		err = logs(ctx, client, flag.Args()[1:])
+	case "reload":
+		err = reload(ctx, client)
+	case "plan":
+		err = plan(ctx, client, flag.Args()[1:])
+	case "diff":
+		err = diff(ctx, client, flag.Args()[1:])
+	case "show":
+		err = show(ctx, client, flag.Args()[1:])
+	case "audit":
+		err = audit(flag.Args()[1:])
+	case "capabilities":
+		err = capabilities(ctx, client)
+	case "top":
+		err = top(ctx, client)
+	case "restart":
+		err = restart(ctx, client, flag.Args()[1:])
+	case "start", "stop":
+		err = batch(ctx, client, batchActions[flag.Arg(0)], flag.Args()[1:])
+	case "freeze":
+		err = each(ctx, client.Freeze, flag.Args()[1:])
+	case "thaw":
+		err = each(ctx, client.Thaw, flag.Args()[1:])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+var batchActions = map[string]pb.BatchAction{
+	"start":   pb.BatchAction_BATCH_ACTION_START,
+	"stop":    pb.BatchAction_BATCH_ACTION_STOP,
+	"restart": pb.BatchAction_BATCH_ACTION_RESTART,
+}
+
+func batch(ctx context.Context, client pb.SupervisorClient, action pb.BatchAction, names []string) error {
+	if len(names) == 0 {
+		return fmt.Errorf("a service name is required")
+	}
+
+	resp, err := client.Batch(ctx, &pb.BatchRequest{Action: action, Names: names})
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, result := range resp.GetResults() {
+		state := stateName(result.GetStatus().GetState())
+
+		if result.GetCode() != int32(codes.OK) {
+			failed += 1
+			fmt.Printf("%s: %s: %s\n", result.GetName(), codes.Code(result.GetCode()), result.GetError())
+			continue
+		}
+
+		fmt.Printf("%s: ok, %s\n", result.GetName(), state)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d services failed", failed, len(resp.GetResults()))
+	}
+
+	return nil
+}
+
+func restart(ctx context.Context, client pb.SupervisorClient, args []string) error {
+	flags := flag.NewFlagSet("restart", flag.ExitOnError)
+	dependents := flags.Bool("dependents", false, "restart the services depending on it too, in dependency order")
+	flags.Parse(args)
+
+	if !*dependents {
+		return batch(ctx, client, batchActions["restart"], flags.Args())
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("restart: a service name is required")
+	}
+
+	req := &pb.RestartTreeRequest{Name: flags.Arg(0), IncludeDependents: true, DryRun: true}
+	resp, err := client.RestartTree(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("plan:")
+	for i, action := range resp.GetActions() {
+		fmt.Printf("  %d. %s %s\n", i+1, action.GetAction(), action.GetService())
+	}
+
+	req.DryRun = false
+	if resp, err = client.RestartTree(ctx, req); err != nil {
+		return err
+	}
+
+	fmt.Println("done:")
+	failed := 0
+	for i, action := range resp.GetActions() {
+		if action.GetError() != "" {
+			failed += 1
+			fmt.Printf("  %d. %s %s: %s\n", i+1, action.GetAction(), action.GetService(), action.GetError())
+			continue
+		}
+
+		fmt.Printf("  %d. %s %s: ok\n", i+1, action.GetAction(), action.GetService())
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d actions failed", failed, len(resp.GetActions()))
+	}
+
+	return nil
+}
+
+// each calls the service call for every name, each one is reported
+func each(ctx context.Context, call func(ctx context.Context, req *pb.ServiceRequest, opts ...grpc.CallOption) (*pb.ServiceStatus, error), names []string) error {
+	if len(names) == 0 {
+		return fmt.Errorf("a service name is required")
+	}
+
+	failed := 0
+	for _, name := range names {
+		st, err := call(ctx, &pb.ServiceRequest{Name: name})
+		if err != nil {
+			failed += 1
+			fmt.Printf("%s: %s: %s\n", name, status.Code(err), status.Convert(err).Message())
+			continue
+		}
+
+		fmt.Printf("%s: ok, %s\n", name, stateName(st.GetState()))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d services failed", failed, len(names))
+	}
+
+	return nil
+}
+
+func top(ctx context.Context, client pb.SupervisorClient) error {
+	resp, err := client.GetUsage(ctx, &pb.GetUsageRequest{})
+	if err != nil {
+		return err
+	}
+
+	var usage []system.ServiceUsage
+	for _, u := range resp.GetServices() {
+		usage = append(usage, system.ServiceUsage{
+			Name:      u.GetName(),
+			Runs:      int(u.GetRuns()),
+			UserCPU:   u.GetUserCpu().AsDuration(),
+			SystemCPU: u.GetSystemCpu().AsDuration(),
+			PeakRSSKB: u.GetPeakRssKb(),
+		})
+	}
+
+	return system.WriteUsage(os.Stdout, usage)
+}
+
+func capabilities(ctx context.Context, client pb.SupervisorClient) error {
+	c, err := client.GetCapabilities(ctx, &pb.GetCapabilitiesRequest{})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("proc root: %s\n", c.GetProcRoot())
+	for _, capability := range []struct {
+		name      string
+		available bool
+	}{
+		{"processes", c.GetProcesses()},
+		{"memory", c.GetMemory()},
+		{"smaps_rollup", c.GetSmapsRollup()},
+		{"file descriptors", c.GetFileDescriptors()},
+		{"sockets", c.GetSockets()},
+	} {
+		state := "available"
+		if !capability.available {
+			state = "unavailable"
+		}
+		fmt.Printf("%s: %s\n", capability.name, state)
+	}
+
+	if len(c.GetUnavailable()) > 0 {
+		fmt.Printf("turned off: %s\n", strings.Join(c.GetUnavailable(), ", "))
+	}
+
+	return nil
+}
+
+func plan(ctx context.Context, client pb.SupervisorClient, args []string) error {
+	flags := flag.NewFlagSet("plan", flag.ExitOnError)
+	path := flags.String("f", "", "configuration file, json, yaml or toml")
+	flags.Parse(args)
+
+	if *path == "" {
+		return fmt.Errorf("plan: -f is required")
+	}
+
+	config, err := ioutil.ReadFile(*path)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Plan(ctx, &pb.PlanRequest{Format: strings.TrimPrefix(filepath.Ext(*path), "."), Config: config})
+	if err != nil {
+		return err
+	}
+
+	printPlan(resp)
+
+	return nil
+}
+
+func reload(ctx context.Context, client pb.SupervisorClient) error {
+	resp, err := client.Reload(ctx, &pb.ReloadRequest{})
+	if err != nil {
+		return err
+	}
+
+	printPlan(resp)
+
+	return nil
+}
+
+func printPlan(resp *pb.PlanResponse) {
+	for _, change := range resp.GetChanges() {
+		action := strings.ToLower(strings.TrimPrefix(change.GetAction().String(), "PLAN_ACTION_"))
+		action = strings.ReplaceAll(action, "_", "-")
+
+		line := change.GetName() + ": " + action
+		if len(change.GetFields()) > 0 {
+			line += " (" + strings.Join(change.GetFields(), ", ") + ")"
+		}
+		if len(change.GetDependents()) > 0 {
+			line += " (needed by " + strings.Join(change.GetDependents(), ", ") + ")"
+		}
+
+		fmt.Println(line)
+	}
+
+	if len(resp.GetStartSequence()) > 0 {
+		fmt.Printf("start sequence: %s\n", strings.Join(resp.GetStartSequence(), ", "))
+	}
+}
+
+// stateName is the state as the supervisor names it, "pending-restart"
+func stateName(state pb.State) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimPrefix(state.String(), "STATE_")), "_", "-")
+}
+
+func statuses(ctx context.Context, client pb.SupervisorClient, names []string) error {
+	var list []*pb.ServiceStatus
+	if len(names) == 0 {
+		resp, err := client.ListServices(ctx, &pb.ListServicesRequest{})
+		if err != nil {
+			return err
+		}
+		list = resp.GetServices()
+	}
+
+	for _, name := range names {
+		st, err := client.GetStatus(ctx, &pb.ServiceRequest{Name: name})
+		if err != nil {
+			return err
+		}
+		list = append(list, st)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVICE\tSTATE\tPID\tRUNS\tEXIT\tMEMORY\tUPTIME")
+	for _, st := range list {
+		pid, exit, memory, uptime := "-", "-", "-", "-"
+		if st.GetPid() > 0 {
+			pid = strconv.Itoa(int(st.GetPid()))
+			memory = fmt.Sprintf("%d KiB", st.GetMemoryKb())
+			uptime = time.Since(st.GetStartedAt().AsTime()).Round(time.Second).String()
+		}
+		if st.GetRuns() > 0 && st.GetPid() == 0 {
+			exit = strconv.Itoa(int(st.GetLastExitCode()))
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", st.GetName(), stateName(st.GetState()), pid, st.GetRuns(), exit, memory, uptime)
+	}
+
+	return tw.Flush()
+}
+
+// logs prints the last lines of a service, and with -f the lines it writes next
+// until interrupted
+func logs(ctx context.Context, client pb.SupervisorClient, args []string) error {
+	flags := flag.NewFlagSet("logs", flag.ExitOnError)
+	follow := flags.Bool("f", false, "follow the output after the last lines")
+	lines := flags.Int("n", 20, "number of last lines, all the kept ones if 0")
+	level := flags.String("level", "", "lowest level of the lines shown, like warn")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("logs: a service name is required")
+	}
+	name := flags.Arg(0)
+
+	// the follow starts first, lines written while the last ones are read are
+	// not lost and the ones read already are skipped
+	var stream pb.Supervisor_StreamLogsClient
+	if *follow {
+		followCtx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+
+		var err error
+		if stream, err = client.StreamLogs(followCtx, &pb.StreamLogsRequest{Service: name, Level: *level}); err != nil {
+			return err
+		}
+	}
+
+	resp, err := client.GetLogs(ctx, &pb.GetLogsRequest{Service: name, Lines: int32(*lines), Level: *level})
+	if err != nil {
+		return err
+	}
+
+	var last time.Time
+	for _, line := range resp.GetLines() {
+		printLine(line)
+		last = line.GetTime().AsTime()
+	}
+
+	if stream == nil {
+		return nil
+	}
+
+	for {
+		line, err := stream.Recv()
+		switch {
+		case err == io.EOF || status.Code(err) == codes.Canceled:
+			return nil
+		case err != nil:
+			return err
+		}
+
+		if !line.GetTime().AsTime().After(last) {
+			continue
+		}
+		printLine(line)
+	}
+}
+
+func printLine(line *pb.LogLine) {
+	fmt.Printf("%s [%s] %s: %s\n", line.GetTime().AsTime().Local().Format("2006-01-02T15:04:05.000"), line.GetStream(), line.GetLevel(), line.GetText())
+}
+
+// diff reports the definitions whose running configuration drifted from the file
+func diff(ctx context.Context, client pb.SupervisorClient, args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	path := flags.String("f", "", "configuration file, json, yaml or toml")
+	flags.Parse(args)
+
+	if *path == "" {
+		return fmt.Errorf("diff: -f is required")
+	}
+
+	config, err := ioutil.ReadFile(*path)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Plan(ctx, &pb.PlanRequest{Format: strings.TrimPrefix(filepath.Ext(*path), "."), Config: config})
+	if err != nil {
+		return err
+	}
+
+	drifted := 0
+	for _, change := range resp.GetChanges() {
+		switch change.GetAction() {
+		case pb.PlanAction_PLAN_ACTION_UNCHANGED:
+			fmt.Printf("%s: in sync, %s\n", change.GetName(), change.GetHash())
+		case pb.PlanAction_PLAN_ACTION_ADDED:
+			drifted += 1
+			fmt.Printf("%s: only in the file, %s\n", change.GetName(), change.GetHash())
+		case pb.PlanAction_PLAN_ACTION_REMOVED:
+			drifted += 1
+			fmt.Printf("%s: only running, %s\n", change.GetName(), change.GetRunningHash())
+		default:
+			drifted += 1
+			fmt.Printf("%s: drifted, running %s, file %s (%s)\n", change.GetName(), change.GetRunningHash(), change.GetHash(), strings.Join(change.GetFields(), ", "))
+		}
+	}
+
+	if drifted > 0 {
+		return fmt.Errorf("%d of %d definitions differ from %s", drifted, len(resp.GetChanges()), *path)
+	}
+
+	return nil
+}
+
+func show(ctx context.Context, client pb.SupervisorClient, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("show: a service name is required")
+	}
+
+	resp, err := client.GetConfig(ctx, &pb.ServiceRequest{Name: args[0]})
+	if err != nil {
+		return err
+	}
+
+	var settings map[string]json.RawMessage
+	if err := json.Unmarshal(resp.GetSettings(), &settings); err != nil {
+		return err
+	}
+
+	defaulted := make(map[string]bool)
+	for _, key := range resp.GetDefaulted() {
+		defaulted[key] = true
+	}
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		marker := " "
+		if defaulted[key] {
+			marker = "*"
+		}
+
+		fmt.Printf("%s %s: %s\n", marker, key, settings[key])
+	}
+
+	return nil
+}
+
+func audit(args []string) error {
+	flags := flag.NewFlagSet("audit", flag.ExitOnError)
+	path := flags.String("f", "", "audit log of the supervisor, its -audit file")
+	since := flags.Duration("since", 0, "show the operations of this long ago and after, all if 0")
+	flags.Parse(args)
+
+	if *path == "" {
+		return fmt.Errorf("audit: -f is required")
+	}
+
+	file, err := os.Open(*path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var from time.Time
+	if *since > 0 {
+		from = time.Now().Add(-*since)
+	}
+
+	records, err := system.ReadAudit(file, from)
+	if err != nil {
+		return err
+	}
+
+	// an operation is shown by its outcome, or as started if it never finished
+	finished := make(map[int64]bool)
+	for _, record := range records {
+		if record.Outcome != system.AUDIT_STARTED {
+			finished[record.ID] = true
+		}
+	}
+
+	for _, record := range records {
+		if record.Outcome == system.AUDIT_STARTED && finished[record.ID] {
+			continue
+		}
+
+		line := fmt.Sprintf("%s %s %s by %s", record.Time.Format(time.RFC3339), record.Operation, record.Target, record.Identity)
+		if record.Source != "" {
+			line += " from " + record.Source
+		}
+		line += ": " + record.Outcome
+		if record.Error != "" {
+			line += " (" + record.Error + ")"
+		}
+
+		fmt.Println(line)
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+// Package config builds the supervised tasks of the standalone supervisor from
+// unit files: a json, yaml or toml file, or a directory of them with drop-ins,
+// read as system.LoadConfig reads them
+package config
+
+import (
+	"github.com/imunhatep/systemgo/system"
+)
+
+// Load reads the unit files of path, a file or a directory of them, and returns
+// the services they define. Every problem of the files is reported at once, as
+// system.ConfigErrors
+func Load(path string) ([]system.Service, error) {
+	configs, err := system.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	services := make([]system.Service, len(configs))
+	for i, config := range configs {
+		services[i].ServiceConfig = config
+	}
+
+	return services, nil
+}
+
+// NewManager loads the unit files of path and returns a manager supervising the
+// services, validated together: names, dependencies, pipes and standbys. The
+// manager reloads from path
+func NewManager(path string) (*system.Manager, error) {
+	services, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	manager, err := system.NewServiceManager(services)
+	if err != nil {
+		return nil, err
+	}
+	manager.SetConfigPath(path)
+
+	return manager, nil
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/imunhatep/systemgo/system"
+)
+
+func writeUnits(t *testing.T, units map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range units {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("write %s: %s", name, err)
+		}
+	}
+
+	return dir
+}
+
+func TestLoadDirectoryOfUnits(t *testing.T) {
+	dir := writeUnits(t, map[string]string{
+		"web.yaml": `
+exec: /usr/bin/web
+params: ["-port", "8080"]
+restartDelay: 2s
+env: ["PORT=8080"]
+workingDir: /srv/web
+`,
+		"worker.toml": `
+exec = "/usr/bin/worker"
+restartPolicy = "on-failure"
+after = ["web"]
+`,
+	})
+
+	services, err := Load(dir)
+	if err != nil {
+		t.Fatalf("load: %s", err)
+	}
+	if len(services) != 2 {
+		t.Fatalf("%d services, want 2", len(services))
+	}
+
+	web, worker := &services[0], &services[1]
+	if web.Name != "web" || web.Exec != "/usr/bin/web" || !reflect.DeepEqual(web.Params, []string{"-port", "8080"}) {
+		t.Errorf("web %s %s %v, want the unit named after its file", web.Name, web.Exec, web.Params)
+	}
+	if web.RestartDelay != 2*time.Second || web.WorkingDir != "/srv/web" || !reflect.DeepEqual(web.Env, []string{"PORT=8080"}) {
+		t.Errorf("web restarts after %s in %q with %v", web.RestartDelay, web.WorkingDir, web.Env)
+	}
+	if worker.Name != "worker" || worker.RestartPolicy != system.RESTART_ON_FAILURE {
+		t.Errorf("worker %s restart policy %q", worker.Name, worker.RestartPolicy)
+	}
+
+	manager, err := NewManager(dir)
+	if err != nil {
+		t.Fatalf("manager: %s", err)
+	}
+	if manager.GetConfigPath() != dir {
+		t.Errorf("reloads from %q, want %q", manager.GetConfigPath(), dir)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	dir := writeUnits(t, map[string]string{
+		"a.yaml": "exec: \"true\"\nrestartPolcy: always\n",
+		"b.toml": "exec = \"true\"\nstopTimeot = \"1s\"\n",
+	})
+
+	_, err := Load(dir)
+	var errs system.ConfigErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("error %v, want a problem of each file", err)
+	}
+}
+
+func TestNewManagerValidatesTheServicesTogether(t *testing.T) {
+	dir := writeUnits(t, map[string]string{
+		"a.yaml": "exec: \"true\"\nafter: [b]\n",
+		"b.yaml": "exec: \"true\"\nafter: [a]\n",
+	})
+
+	if _, err := NewManager(dir); !errors.Is(err, system.ErrDependencyCycle) {
+		t.Fatalf("error %v, want %v", err, system.ErrDependencyCycle)
+	}
+}
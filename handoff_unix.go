@@ -0,0 +1,36 @@
+//go:build unix
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/imunhatep/systemgo/system"
+)
+
+// handOffOnSignal replaces the supervisor with its binary on SIGUSR2, as found
+// now: a binary installed over it meanwhile takes over the running tasks
+func handOffOnSignal(serviceMng *system.Manager) {
+	binary, err := os.Executable()
+	if err != nil {
+		log.Printf("[M] no handoff on SIGUSR2: %s", err)
+		return
+	}
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGUSR2)
+
+	go func() {
+		for range sigc {
+			err := serviceMng.Audit(system.Requester{Source: "SIGUSR2"}, "handoff", binary, func() error {
+				return serviceMng.Handoff(binary)
+			})
+			if err != nil {
+				log.Printf("[M] handoff failed: %s", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,8 @@
+package main
+
+import (
+	"github.com/imunhatep/systemgo/system"
+)
+
+// handOffOnSignal does nothing, Handoff is not supported on windows
+func handOffOnSignal(serviceMng *system.Manager) {}
@@ -2,24 +2,108 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
+	"github.com/imunhatep/systemgo/config"
+	"github.com/imunhatep/systemgo/rpc"
 	"github.com/imunhatep/systemgo/system"
-	"io/ioutil"
+	"github.com/imunhatep/systemgo/web"
+	"google.golang.org/grpc"
+	"io"
 	"log"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 )
 
 func main() {
-	runtime.GOMAXPROCS(*flag.Int("j", 2, "GOMAXPROCS"))
+	procs := flag.Int("j", 2, "GOMAXPROCS")
+	configPath := flag.String("f", "tasks.json", "json, yaml or toml file with defined tasks, or a directory of them")
+	lockFile := flag.String("lock", "", "lock file preventing a second supervisor from running, disabled if empty")
+	historyDir := flag.String("history", "", "directory to persist task history, disabled if empty")
+	runtimeRoot := flag.String("runtime-root", system.RUNTIME_ROOT, "directory the runtimeDir of tasks is created in")
+	stateRoot := flag.String("state-root", system.STATE_ROOT, "directory the stateDir of tasks is created in")
+	cgroupRoot := flag.String("cgroup-root", "", "delegated cgroup v2 directory the cgroups of tasks with cpuQuota or memoryLimit are created in, disabled if empty")
+	grpcAddr := flag.String("grpc", "", "address of the gRPC management API, unix:<path> for a unix socket, disabled if empty")
+	token := flag.String("token", "", "token required by the management APIs, gRPC and HTTP")
+	httpAddr := flag.String("http", "", "address of the HTTP endpoints (/healthz and the control API), disabled if empty")
+	heartbeat := flag.String("heartbeat", "", "file touched every -heartbeat-interval while healthy, disabled if empty")
+	heartbeatInterval := flag.Duration("heartbeat-interval", 10*time.Second, "interval of touching the heartbeat file")
+	initMode := flag.Bool("init", false, "run as the init of a container: reap orphans and stop on SIGTERM within -shutdown-timeout")
+	mainTask := flag.String("main", "", "task whose end stops the supervisor, which exits with its exit code")
+	outputBudget := flag.Int("output-budget", system.OUTPUT_BUDGET, "bytes of output lines all tasks may buffer before the oldest are dropped")
+	readyLine := flag.Bool("ready-line", false, "print a JSON line to stdout once all tasks are running or ready")
+	readyTimeout := flag.Duration("ready-timeout", 0, "time tasks get to become ready before the ready line reports a failure, no limit if 0")
+	failFast := flag.Bool("fail-fast", false, "stop all tasks once a task that is not optional fails")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 0, "time tasks get to stop on shutdown before they are killed, 8s with -init")
+	auditFile := flag.String("audit", "", "file the operations of the management API are appended to, disabled if empty")
+	logForward := flag.String("log-forward", "", "collector the lines of tasks are forwarded to, tcp://, tls://, udp:// or unix:// address, disabled if empty")
+	logForwardFormat := flag.String("log-forward-format", system.FORWARD_TEXT, "format of forwarded lines, text or json")
+	secretsDir := flag.String("secrets", system.SECRET_DIR, "directory of the 0600 files env values secret://file/<name> are read from")
+	logLevel := flag.String("log-level", system.LEVEL_INFO, "least level of the supervisor log, debug, info, warn or error")
+	logFormat := flag.String("log-format", "text", "format of the supervisor log, text or json")
+	schema := flag.Bool("schema", false, "print the JSON Schema of configuration files and exit")
+	flag.Parse()
 
-	taskList := readConfig(*flag.String("f", "tasks.json", "JSON file with defined tasks"))
-	serviceMng := system.NewServiceManager(taskList)
+	if *schema {
+		os.Stdout.Write(system.ConfigSchema())
+		return
+	}
+
+	setLogger(*logLevel, *logFormat)
+	runtime.GOMAXPROCS(*procs)
+	system.RegisterSecretProvider(system.SECRET_PROVIDER_FILE, system.FileSecrets{Dir: *secretsDir})
+
+	serviceMng, err := config.NewManager(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	serviceMng.SetHistoryDir(*historyDir)
+	serviceMng.SetRuntimeRoot(*runtimeRoot)
+	serviceMng.SetStateRoot(*stateRoot)
+	serviceMng.SetCgroupRoot(*cgroupRoot)
+	serviceMng.SetLockFile(*lockFile)
+	serviceMng.SetHeartbeat(*heartbeat, *heartbeatInterval)
+	serviceMng.SetMainService(*mainTask)
+	serviceMng.SetShutdownTimeout(*shutdownTimeout)
+	serviceMng.Init = *initMode
+	serviceMng.FailFast = *failFast
+	serviceMng.SetOutputBudget(*outputBudget)
+
+	if *logForward != "" {
+		defaults := serviceMng.GetDefaults()
+		defaults.LogForward = system.LogForward{Address: *logForward, Format: *logForwardFormat}
+		if err := serviceMng.SetDefaults(defaults); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *auditFile != "" {
+		audit, err := system.OpenAuditLog(*auditFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer audit.Close()
+
+		serviceMng.SetAuditLog(audit)
+	}
+
+	if *grpcAddr != "" {
+		server := serveGrpc(*grpcAddr, *token, serviceMng)
+		defer server.Stop()
+	}
+
+	if *httpAddr != "" {
+		server := serveHttp(*httpAddr, *token, serviceMng)
+		defer server.Close()
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -27,16 +111,67 @@ func main() {
 	wg.Add(1)
 
 	go func() {
-		serviceMng.Run(ctx)
+		if err := serviceMng.Run(ctx); err != nil {
+			log.Println(err)
+		}
 		wg.Done()
 	}()
 
+	reloadOnHangup(serviceMng)
+	handOffOnSignal(serviceMng)
+
+	if *readyLine || os.Getenv("NOTIFY_SOCKET") != "" {
+		go announceReady(ctx, serviceMng, *readyLine, *readyTimeout)
+	}
+
 	sigChan := make(chan bool)
 	handleSig(&wg, sigChan)
 	<-sigChan
 
+	system.SdNotify("STOPPING=1")
+
 	cancel()
 	wg.Wait()
+
+	if code := serviceMng.Wait().ExitCode(); code != 0 {
+		os.Exit(code)
+	}
+}
+
+func announceReady(ctx context.Context, serviceMng *system.Manager, readyLine bool, timeout time.Duration) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var w io.Writer
+	if readyLine {
+		w = os.Stdout
+	}
+
+	if err := serviceMng.AnnounceReady(ctx, w); err != nil {
+		log.Printf("[M] not ready: %s", err)
+	}
+}
+
+// reloadOnHangup reads the configuration again on SIGHUP and applies it, tasks
+// whose process did not change keep running
+func reloadOnHangup(serviceMng *system.Manager) {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGHUP)
+
+	go func() {
+		for range sigc {
+			err := serviceMng.Audit(system.Requester{Source: "SIGHUP"}, "reload", serviceMng.GetConfigPath(), func() error {
+				_, err := serviceMng.Reload()
+				return err
+			})
+			if err != nil {
+				log.Printf("[M] reload failed: %s", err)
+			}
+		}
+	}()
 }
 
 func handleSig(wg *sync.WaitGroup, sigChan chan<- bool) {
@@ -64,16 +199,91 @@ func handleSig(wg *sync.WaitGroup, sigChan chan<- bool) {
 	fmt.Println("awaiting signal")
 }
 
-func readConfig(path string) []system.Service {
-	dat, err := ioutil.ReadFile(path)
+func serveGrpc(addr, token string, serviceMng *system.Manager) *grpc.Server {
+	network := "tcp"
+	if strings.HasPrefix(addr, "unix:") {
+		network, addr = "unix", strings.TrimPrefix(addr, "unix:")
+	}
+
+	listener := listen(serviceMng, "grpc", network, addr)
+
+	var opts []grpc.ServerOption
+	if token != "" {
+		opts = rpc.WithAuth(rpc.TokenAuth(token))
+	}
+	if network == "unix" {
+		opts = append(opts, grpc.Creds(rpc.PeerCredentials()))
+	}
+
+	server := rpc.NewServer(serviceMng, opts...)
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	log.Printf("[G] listening on %s", listener.Addr())
+
+	return server
+}
+
+// listen takes the listener of name from the supervisor that handed off to this
+// one, or listens on addr, and passes it on to the next one
+func listen(serviceMng *system.Manager, name, network, addr string) net.Listener {
+	listener, err := system.InheritedListener(name)
+	if listener == nil && err == nil {
+		if network == "unix" {
+			os.Remove(addr)
+		}
+		listener, err = net.Listen(network, addr)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	var tasks []system.Service
-	if err = json.Unmarshal(dat, &tasks); err != nil {
-		log.Fatal(err)
+	if err := serviceMng.PassOnHandoff(name, listener); err != nil {
+		log.Println(err)
+	}
+
+	return listener
+}
+
+func serveHttp(addr, token string, serviceMng *system.Manager) *http.Server {
+	listener := listen(serviceMng, "http", "tcp", addr)
+
+	server := &http.Server{Handler: web.NewHandler(serviceMng, token)}
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			log.Println(err)
+		}
+	}()
+
+	log.Printf("[W] listening on %s", listener.Addr())
+
+	return server
+}
+
+// setLogger logs the supervisor from level, as text lines of the log package or
+// as JSON lines on stderr
+func setLogger(level, format string) {
+	level, ok := system.ParseLevel(level)
+	if !ok {
+		log.Fatalf("unknown log level %q", level)
 	}
 
-	return tasks
+	switch format {
+	case "text":
+		system.SetLogger(system.NewStdLogger(level))
+	case "json":
+		slogLevel := map[string]slog.Level{
+			system.LEVEL_DEBUG: slog.LevelDebug,
+			system.LEVEL_WARN:  slog.LevelWarn,
+			system.LEVEL_ERROR: slog.LevelError,
+			system.LEVEL_FATAL: slog.LevelError,
+		}[level]
+		handler := slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slogLevel})
+		system.SetLogger(system.SlogLogger(slog.New(handler)))
+	default:
+		log.Fatalf("unknown log format %q, text or json", format)
+	}
 }
@@ -0,0 +1,136 @@
+// Package procfs parses the files of /proc the supervisor reads about its processes
+package procfs
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+)
+
+// DEFAULT_ROOT is where procfs is mounted
+const DEFAULT_ROOT = "/proc"
+
+// FS reads the proc files below Root, a copy of /proc like a fixture tree works
+// the same
+type FS struct {
+	Root string
+}
+
+// NewFS returns the proc filesystem at root, DEFAULT_ROOT if empty
+func NewFS(root string) FS {
+	if root == "" {
+		root = DEFAULT_ROOT
+	}
+
+	return FS{Root: root}
+}
+
+// Path joins the elements to the root, like Path("1", "stat")
+func (fs FS) Path(elem ...string) string {
+	return filepath.Join(append([]string{fs.Root}, elem...)...)
+}
+
+// Stat reads /proc/<pid>/stat
+func (fs FS) Stat(pid int) (Stat, error) {
+	data, err := ioutil.ReadFile(fs.Path(strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return Stat{}, err
+	}
+
+	stat, err := ParseStat(data)
+	if err != nil {
+		return Stat{}, fmt.Errorf("pid %d: %w", pid, err)
+	}
+
+	return stat, nil
+}
+
+// Statm reads /proc/<pid>/statm
+func (fs FS) Statm(pid int) (Statm, error) {
+	data, err := ioutil.ReadFile(fs.Path(strconv.Itoa(pid), "statm"))
+	if err != nil {
+		return Statm{}, err
+	}
+
+	statm, err := ParseStatm(data)
+	if err != nil {
+		return Statm{}, fmt.Errorf("pid %d: %w", pid, err)
+	}
+
+	return statm, nil
+}
+
+// Status reads /proc/<pid>/status
+func (fs FS) Status(pid int) (Status, error) {
+	data, err := ioutil.ReadFile(fs.Path(strconv.Itoa(pid), "status"))
+	if err != nil {
+		return Status{}, err
+	}
+
+	status, err := ParseStatus(data)
+	if err != nil {
+		return Status{}, fmt.Errorf("pid %d: %w", pid, err)
+	}
+
+	return status, nil
+}
+
+// VMStat reads /proc/vmstat
+func (fs FS) VMStat() (VMStat, error) {
+	data, err := ioutil.ReadFile(fs.Path("vmstat"))
+	if err != nil {
+		return VMStat{}, err
+	}
+
+	return ParseVMStat(data)
+}
+
+// SmapsRollup reads /proc/<pid>/smaps_rollup, kernels before 4.14 do not have it
+func (fs FS) SmapsRollup(pid int) (SmapsRollup, error) {
+	data, err := ioutil.ReadFile(fs.Path(strconv.Itoa(pid), "smaps_rollup"))
+	if err != nil {
+		return SmapsRollup{}, err
+	}
+
+	rollup, err := ParseSmapsRollup(data)
+	if err != nil {
+		return SmapsRollup{}, fmt.Errorf("pid %d: %w", pid, err)
+	}
+
+	return rollup, nil
+}
+
+// eachLine calls f with the lines of data, the last one may miss its newline
+func eachLine(data []byte, f func(line []byte) error) error {
+	for len(data) > 0 {
+		line := data
+		if end := bytes.IndexByte(data, '\n'); end >= 0 {
+			line, data = data[:end], data[end+1:]
+		} else {
+			data = nil
+		}
+
+		if err := f(line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// kilobytes parses the value of a "Key:   1234 kB" line, the unit is optional
+func kilobytes(file string, line, value []byte) (uint64, error) {
+	fields := bytes.Fields(value)
+	if len(fields) == 0 || len(fields) > 2 || (len(fields) == 2 && string(fields[1]) != "kB") {
+		return 0, fmt.Errorf("invalid %s line: %q", file, line)
+	}
+
+	kb, err := strconv.ParseUint(string(fields[0]), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s line: %q", file, line)
+	}
+
+	return kb, nil
+}
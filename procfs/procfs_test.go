@@ -0,0 +1,207 @@
+package procfs
+
+import (
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+// fixture is a proc tree of an init, a kernel thread, a process named
+// "my (weird) app" with memory near the uint64 limit and one truncated
+var fixture = NewFS("testdata/proc")
+
+func TestStatOfTheFixture(t *testing.T) {
+	for _, test := range []struct {
+		pid  int
+		want Stat
+	}{
+		{1, Stat{PID: 1, Comm: "init", State: 'S', PPID: 0, PGRP: 1, UTime: 12, STime: 34, StartTime: 5, VSize: 169000000, RSS: 2500}},
+		{2, Stat{PID: 2, Comm: "kthreadd", State: 'S', STime: 3, StartTime: 2}},
+		{4242, Stat{PID: 4242, Comm: "my (weird) app", State: 'R', PPID: 1, PGRP: 4242, UTime: 100, STime: 200, StartTime: 900, VSize: math.MaxUint64, RSS: math.MaxUint64}},
+	} {
+		stat, err := fixture.Stat(test.pid)
+		if err != nil {
+			t.Errorf("%d: %s", test.pid, err)
+			continue
+		}
+		if stat != test.want {
+			t.Errorf("%d: %+v, want %+v", test.pid, stat, test.want)
+		}
+	}
+
+	if _, err := fixture.Stat(999); err == nil || !strings.Contains(err.Error(), "pid 999") {
+		t.Errorf("truncated stat: %v, want an error of pid 999", err)
+	}
+	if _, err := fixture.Stat(3); !os.IsNotExist(err) {
+		t.Errorf("stat of a process gone: %v, want it not to exist", err)
+	}
+}
+
+func TestParseStat(t *testing.T) {
+	fields := " S 1 10 10 0 -1 4194304 0 0 0 0 7 8 0 0 20 0 1 0 99 4096 3"
+
+	for _, test := range []struct {
+		name string
+		data string
+		comm string
+		rss  uint64
+		err  string
+	}{
+		// the regression: the fields were split on spaces, shifting them by two
+		{"parentheses and spaces", "10 (my (weird) app)" + fields, "my (weird) app", 3, ""},
+		{"closing parenthesis", "10 (a) S (b)" + fields, "a) S (b", 3, ""},
+		{"empty", "10 ()" + fields, "", 3, ""},
+		{"newline", "10 (two\nlines)" + fields, "two\nlines", 3, ""},
+		{"negative rss", "10 (exiting)" + strings.TrimSuffix(fields, " 3") + " -1", "exiting", 0, ""},
+		{"no command", "10 init S 1", "", 0, "no command name"},
+		{"truncated", "10 (web) S 1 10 10 0", "", 0, "7 fields, expected 24"},
+		{"truncated in the command", "10 (my (weird", "", 0, "no command name"},
+		{"invalid pid", "x (web)" + fields, "", 0, "invalid stat pid"},
+		{"invalid state", "10 (web) SS" + fields[2:], "", 0, "invalid stat state"},
+		{"rss over uint64", "10 (web)" + strings.TrimSuffix(fields, " 3") + " 18446744073709551616", "", 0, "invalid stat field 24"},
+	} {
+		stat, err := ParseStat([]byte(test.data))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if stat.PID != 10 || stat.Comm != test.comm || stat.State != 'S' || stat.PPID != 1 || stat.UTime != 7 || stat.StartTime != 99 || stat.RSS != test.rss {
+			t.Errorf("%s: %+v, want %q with the fields after it", test.name, stat, test.comm)
+		}
+	}
+}
+
+func TestStatusOfTheFixture(t *testing.T) {
+	for _, test := range []struct {
+		pid  int
+		want Status
+	}{
+		{1, Status{Name: "init", State: 'S', Threads: 1, VmRSS: 10000, VmHWM: 12000}},
+		// kernel threads have no memory lines
+		{2, Status{Name: "kthreadd", State: 'S', Threads: 1}},
+		{4242, Status{Name: "my (weird) app", State: 'R', PPID: 1, Threads: 3, VmRSS: math.MaxUint64, VmHWM: math.MaxUint64}},
+	} {
+		status, err := fixture.Status(test.pid)
+		if err != nil {
+			t.Errorf("%d: %s", test.pid, err)
+			continue
+		}
+		if status != test.want {
+			t.Errorf("%d: %+v, want %+v", test.pid, status, test.want)
+		}
+	}
+
+	if _, err := fixture.Status(999); err == nil || !strings.Contains(err.Error(), `invalid status line: "VmRSS:"`) {
+		t.Errorf("truncated status: %v", err)
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"no unit", "VmRSS:\t1024\n", ""},
+		{"no newline at the end", "Name:\tweb\nVmRSS:\t1024 kB", ""},
+		{"unknown lines", "Name:\tweb\nSeccomp:\t2\nno colon here\n", ""},
+		{"another unit", "VmRSS:\t1 MB\n", `invalid status line: "VmRSS:\t1 MB"`},
+		{"over uint64", "VmRSS:\t18446744073709551616 kB\n", "invalid status line"},
+		{"no state", "State:\n", "invalid status line"},
+		{"invalid ppid", "PPid:\tone\n", "invalid status line"},
+	} {
+		_, err := ParseStatus([]byte(test.data))
+		if test.err == "" && err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestSmapsRollupOfTheFixture(t *testing.T) {
+	rollup, err := fixture.SmapsRollup(1)
+	if err != nil {
+		t.Fatalf("1: %s", err)
+	}
+	want := SmapsRollup{Rss: 10000, Pss: 6000, SharedClean: 3000, SharedDirty: 500, PrivateClean: 1500, PrivateDirty: 5000, Swap: 16, SwapPss: 8}
+	if rollup != want {
+		t.Errorf("1: %+v, want %+v", rollup, want)
+	}
+	if uss := rollup.USS(); uss != 6500 {
+		t.Errorf("uss %d, want 6500", uss)
+	}
+
+	// a kernel thread has an empty file
+	if rollup, err := fixture.SmapsRollup(2); err != nil || rollup != (SmapsRollup{}) {
+		t.Errorf("2: %+v %v, want no memory", rollup, err)
+	}
+
+	if rollup, err := fixture.SmapsRollup(4242); err != nil || rollup.Pss != math.MaxUint64 || rollup.USS() != math.MaxUint64 {
+		t.Errorf("4242: %+v %v, want the memory near the limit", rollup, err)
+	}
+
+	if _, err := fixture.SmapsRollup(999); err == nil || !strings.Contains(err.Error(), `invalid smaps_rollup line: "Rss:"`) {
+		t.Errorf("truncated smaps_rollup: %v", err)
+	}
+	if _, err := ParseSmapsRollup([]byte("00400000-00500000 ---p 00000000 00:00 0 [rollup]\n")); err == nil {
+		t.Errorf("a smaps_rollup without memory lines parsed")
+	}
+}
+
+func TestStatmOfTheFixture(t *testing.T) {
+	statm, err := fixture.Statm(1)
+	if err != nil {
+		t.Fatalf("1: %s", err)
+	}
+	if statm != (Statm{Size: 42250, Resident: 2500, Shared: 1800, Text: 300, Data: 900}) {
+		t.Errorf("1: %+v", statm)
+	}
+	if resident := statm.ResidentBytes(4096); resident != 2500*4096 {
+		t.Errorf("resident %d bytes, want %d", resident, 2500*4096)
+	}
+
+	statm, err = fixture.Statm(4242)
+	if err != nil {
+		t.Fatalf("4242: %s", err)
+	}
+	if resident := statm.ResidentBytes(4096); resident != math.MaxUint64 {
+		t.Errorf("resident %d bytes, want it capped at the uint64 limit", resident)
+	}
+
+	if _, err := fixture.Statm(999); err == nil || !strings.Contains(err.Error(), "2 fields, expected 7") {
+		t.Errorf("truncated statm: %v", err)
+	}
+}
+
+func TestParseVMStat(t *testing.T) {
+	vmstat, err := ParseVMStat([]byte("nr_free_pages 1000\noom_kill 3\npgfault 1"))
+	if err != nil || !vmstat.HasOOMKill || vmstat.OOMKill != 3 {
+		t.Errorf("%+v %v, want 3 oom kills", vmstat, err)
+	}
+
+	if vmstat, err := ParseVMStat([]byte("nr_free_pages 1000\n")); err != nil || vmstat.HasOOMKill {
+		t.Errorf("%+v %v, want oom kills not counted", vmstat, err)
+	}
+	if _, err := ParseVMStat([]byte("oom_kill many\n")); err == nil {
+		t.Errorf("an invalid oom_kill line parsed")
+	}
+}
+
+func TestNewFS(t *testing.T) {
+	if fs := NewFS(""); fs.Root != DEFAULT_ROOT {
+		t.Errorf("root %q, want %q", fs.Root, DEFAULT_ROOT)
+	}
+	if path := fixture.Path("1", "stat"); path != "testdata/proc/1/stat" {
+		t.Errorf("path %q", path)
+	}
+}
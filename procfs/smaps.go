@@ -0,0 +1,74 @@
+package procfs
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// SmapsRollup is /proc/<pid>/smaps_rollup, the sums of the mappings of the
+// process in kB
+type SmapsRollup struct {
+	Rss            uint64
+	Pss            uint64
+	SharedClean    uint64
+	SharedDirty    uint64
+	PrivateClean   uint64
+	PrivateDirty   uint64
+	PrivateHugetlb uint64
+	Swap           uint64
+	SwapPss        uint64
+}
+
+// USS is the memory no other process maps, freed if the process exits
+func (r SmapsRollup) USS() uint64 {
+	return r.PrivateClean + r.PrivateDirty + r.PrivateHugetlb
+}
+
+// ParseSmapsRollup parses a smaps_rollup file, its first line is the range of
+// the mappings and lines it does not know are skipped
+func ParseSmapsRollup(data []byte) (SmapsRollup, error) {
+	var rollup SmapsRollup
+	fields := map[string]*uint64{
+		"Rss":             &rollup.Rss,
+		"Pss":             &rollup.Pss,
+		"Shared_Clean":    &rollup.SharedClean,
+		"Shared_Dirty":    &rollup.SharedDirty,
+		"Private_Clean":   &rollup.PrivateClean,
+		"Private_Dirty":   &rollup.PrivateDirty,
+		"Private_Hugetlb": &rollup.PrivateHugetlb,
+		"Swap":            &rollup.Swap,
+		"SwapPss":         &rollup.SwapPss,
+	}
+
+	found := false
+	err := eachLine(data, func(line []byte) error {
+		colon := bytes.IndexByte(line, ':')
+		if colon < 0 {
+			return nil
+		}
+
+		to, ok := fields[string(line[:colon])]
+		if !ok {
+			return nil
+		}
+
+		kb, err := kilobytes("smaps_rollup", line, line[colon+1:])
+		if err != nil {
+			return err
+		}
+
+		*to, found = kb, true
+
+		return nil
+	})
+	if err != nil {
+		return SmapsRollup{}, err
+	}
+
+	// a process that exited leaves an empty file
+	if !found && len(bytes.TrimSpace(data)) > 0 {
+		return SmapsRollup{}, fmt.Errorf("invalid smaps_rollup: no memory lines")
+	}
+
+	return rollup, nil
+}
@@ -0,0 +1,139 @@
+package procfs
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Stat is /proc/<pid>/stat up to rss, times are in clock ticks (USER_HZ) and
+// RSS in pages
+type Stat struct {
+	PID int
+
+	// Comm is the command name without its parentheses, it may contain spaces
+	// and parentheses itself: "my (weird) app"
+	Comm      string
+	State     byte
+	PPID      int
+	PGRP      int
+	UTime     uint64
+	STime     uint64
+	StartTime uint64
+	VSize     uint64
+	RSS       uint64
+}
+
+// STAT_FIELDS is the number of fields of stat up to rss
+const STAT_FIELDS = 24
+
+// ParseStat parses a stat file. The command name may contain anything the
+// process set, so it spans from the first "(" to the last ")" and the fields
+// are counted after it
+func ParseStat(data []byte) (Stat, error) {
+	start, end := bytes.IndexByte(data, '('), bytes.LastIndexByte(data, ')')
+	if start < 0 || end < start {
+		return Stat{}, fmt.Errorf("invalid stat: no command name")
+	}
+
+	pid, err := strconv.Atoi(string(bytes.TrimSpace(data[:start])))
+	if err != nil {
+		return Stat{}, fmt.Errorf("invalid stat pid: %q", data[:start])
+	}
+
+	// the fields after the command start with the 3rd, state
+	fields := bytes.Fields(data[end+1:])
+	if len(fields)+2 < STAT_FIELDS {
+		return Stat{}, fmt.Errorf("invalid stat: %d fields, expected %d", len(fields)+2, STAT_FIELDS)
+	}
+
+	field := func(n int) []byte {
+		return fields[n-3]
+	}
+
+	if len(field(3)) != 1 {
+		return Stat{}, fmt.Errorf("invalid stat state: %q", field(3))
+	}
+
+	stat := Stat{PID: pid, Comm: string(data[start+1 : end]), State: field(3)[0]}
+	if stat.PPID, err = strconv.Atoi(string(field(4))); err != nil {
+		return Stat{}, fmt.Errorf("invalid stat ppid: %q", field(4))
+	}
+
+	if stat.PGRP, err = strconv.Atoi(string(field(5))); err != nil {
+		return Stat{}, fmt.Errorf("invalid stat pgrp: %q", field(5))
+	}
+
+	for _, value := range []struct {
+		n  int
+		to *uint64
+	}{
+		{14, &stat.UTime},
+		{15, &stat.STime},
+		{22, &stat.StartTime},
+		{23, &stat.VSize},
+		{24, &stat.RSS},
+	} {
+		if *value.to, err = strconv.ParseUint(string(field(value.n)), 10, 64); err != nil {
+			// rss is a signed long, negative while a process is being torn down
+			if value.n == 24 && bytes.HasPrefix(field(24), []byte("-")) {
+				*value.to = 0
+				continue
+			}
+
+			return Stat{}, fmt.Errorf("invalid stat field %d: %q", value.n, field(value.n))
+		}
+	}
+
+	return stat, nil
+}
+
+// Statm is /proc/<pid>/statm, in pages
+type Statm struct {
+	Size     uint64
+	Resident uint64
+	Shared   uint64
+	Text     uint64
+	Data     uint64
+}
+
+// ParseStatm parses a statm file
+func ParseStatm(data []byte) (Statm, error) {
+	fields := bytes.Fields(data)
+	if len(fields) < 7 {
+		return Statm{}, fmt.Errorf("invalid statm: %d fields, expected 7", len(fields))
+	}
+
+	var statm Statm
+	for i, to := range []*uint64{&statm.Size, &statm.Resident, &statm.Shared, &statm.Text, nil, &statm.Data} {
+		value, err := strconv.ParseUint(string(fields[i]), 10, 64)
+		if err != nil {
+			return Statm{}, fmt.Errorf("invalid statm field %d: %q", i+1, fields[i])
+		}
+
+		// the 5th, lib, is always 0 since linux 2.6
+		if to != nil {
+			*to = value
+		}
+	}
+
+	return statm, nil
+}
+
+// ResidentBytes is Resident in bytes, math.MaxUint64 if it does not fit
+func (s Statm) ResidentBytes(pageSize int) uint64 {
+	return pagesToBytes(s.Resident, pageSize)
+}
+
+func pagesToBytes(pages uint64, pageSize int) uint64 {
+	if pageSize <= 0 {
+		return 0
+	}
+
+	if pages > math.MaxUint64/uint64(pageSize) {
+		return math.MaxUint64
+	}
+
+	return pages * uint64(pageSize)
+}
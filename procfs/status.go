@@ -0,0 +1,65 @@
+package procfs
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// Status is the part of /proc/<pid>/status the supervisor reads, memory in kB.
+// Kernel threads and zombies have no memory lines, their memory is zero
+type Status struct {
+	Name    string
+	State   byte
+	PPID    int
+	Threads int
+	VmRSS   uint64
+	VmHWM   uint64
+	VmSwap  uint64
+}
+
+// ParseStatus parses a status file, lines it does not know are skipped
+func ParseStatus(data []byte) (Status, error) {
+	var status Status
+
+	err := eachLine(data, func(line []byte) error {
+		colon := bytes.IndexByte(line, ':')
+		if colon < 0 {
+			return nil
+		}
+
+		key, value := string(line[:colon]), line[colon+1:]
+
+		var err error
+		switch key {
+		case "Name":
+			status.Name = string(bytes.TrimSpace(value))
+		case "State":
+			if value = bytes.TrimSpace(value); len(value) == 0 {
+				return fmt.Errorf("invalid status line: %q", line)
+			}
+			status.State = value[0]
+		case "PPid":
+			if status.PPID, err = strconv.Atoi(string(bytes.TrimSpace(value))); err != nil {
+				return fmt.Errorf("invalid status line: %q", line)
+			}
+		case "Threads":
+			if status.Threads, err = strconv.Atoi(string(bytes.TrimSpace(value))); err != nil {
+				return fmt.Errorf("invalid status line: %q", line)
+			}
+		case "VmRSS":
+			status.VmRSS, err = kilobytes("status", line, value)
+		case "VmHWM":
+			status.VmHWM, err = kilobytes("status", line, value)
+		case "VmSwap":
+			status.VmSwap, err = kilobytes("status", line, value)
+		}
+
+		return err
+	})
+	if err != nil {
+		return Status{}, err
+	}
+
+	return status, nil
+}
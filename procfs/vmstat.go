@@ -0,0 +1,40 @@
+package procfs
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// VMStat is the part of /proc/vmstat the supervisor reads
+type VMStat struct {
+	// OOMKill counts the processes killed by the out of memory killer since boot,
+	// HasOOMKill is false for kernels before 4.13 not counting them
+	OOMKill    uint64
+	HasOOMKill bool
+}
+
+// ParseVMStat parses a vmstat file, lines it does not know are skipped
+func ParseVMStat(data []byte) (VMStat, error) {
+	var vmstat VMStat
+
+	err := eachLine(data, func(line []byte) error {
+		fields := bytes.Fields(line)
+		if len(fields) != 2 || string(fields[0]) != "oom_kill" {
+			return nil
+		}
+
+		count, err := strconv.ParseUint(string(fields[1]), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid vmstat line: %q", line)
+		}
+		vmstat.OOMKill, vmstat.HasOOMKill = count, true
+
+		return nil
+	})
+	if err != nil {
+		return VMStat{}, err
+	}
+
+	return vmstat, nil
+}
@@ -0,0 +1,108 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/imunhatep/systemgo/system"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// requester is who made the call for the audit log: authenticated or local, from
+// the credentials of the unix socket peer or the remote address
+func requester(ctx context.Context) system.Requester {
+	var r system.Requester
+	if authenticated, _ := ctx.Value(authenticatedKey{}).(bool); authenticated {
+		r.Identity = AUTHENTICATED
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return r
+	}
+
+	switch {
+	case p.AuthInfo != nil && p.AuthInfo.AuthType() == PEER_CREDENTIALS:
+		r.Source = p.AuthInfo.(PeerInfo).String()
+	case p.Addr != nil:
+		r.Source = p.Addr.String()
+	}
+
+	return r
+}
+
+// PEER_CREDENTIALS is the auth type of the peers of a unix socket
+const PEER_CREDENTIALS = "peercred"
+
+// PeerInfo are the credentials of the process on the other end of a unix socket
+type PeerInfo struct {
+	credentials.CommonAuthInfo
+	Pid, Uid, Gid int
+}
+
+func (p PeerInfo) AuthType() string {
+	return PEER_CREDENTIALS
+}
+
+func (p PeerInfo) String() string {
+	return fmt.Sprintf("unix pid=%d uid=%d gid=%d", p.Pid, p.Uid, p.Gid)
+}
+
+type peerCredentials struct{}
+
+// PeerCredentials are server credentials reading the credentials of the peers of
+// a unix socket for the audit log, connections over other networks carry none
+func PeerCredentials() credentials.TransportCredentials {
+	return peerCredentials{}
+}
+
+func (peerCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	unix, ok := conn.(*net.UnixConn)
+	if !ok {
+		return conn, nil, nil
+	}
+
+	raw, err := unix.SyscallConn()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var info *PeerInfo
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		info, credErr = peerCredentialsOf(fd)
+	})
+	if err == nil {
+		err = credErr
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read peer credentials: %w", err)
+	}
+
+	// the platform does not tell the peer
+	if info == nil {
+		return conn, nil, nil
+	}
+	info.SecurityLevel = credentials.NoSecurity
+
+	return conn, *info, nil
+}
+
+func (peerCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("peer credentials are server side only")
+}
+
+func (peerCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: PEER_CREDENTIALS}
+}
+
+func (c peerCredentials) Clone() credentials.TransportCredentials {
+	return c
+}
+
+func (peerCredentials) OverrideServerName(string) error {
+	return nil
+}
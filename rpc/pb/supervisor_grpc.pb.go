@@ -0,0 +1,890 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: pb/supervisor.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Supervisor_ListServices_FullMethodName    = "/systemgo.v1.Supervisor/ListServices"
+	Supervisor_GetStatus_FullMethodName       = "/systemgo.v1.Supervisor/GetStatus"
+	Supervisor_Start_FullMethodName           = "/systemgo.v1.Supervisor/Start"
+	Supervisor_Stop_FullMethodName            = "/systemgo.v1.Supervisor/Stop"
+	Supervisor_Restart_FullMethodName         = "/systemgo.v1.Supervisor/Restart"
+	Supervisor_Freeze_FullMethodName          = "/systemgo.v1.Supervisor/Freeze"
+	Supervisor_Thaw_FullMethodName            = "/systemgo.v1.Supervisor/Thaw"
+	Supervisor_RestartTree_FullMethodName     = "/systemgo.v1.Supervisor/RestartTree"
+	Supervisor_Batch_FullMethodName           = "/systemgo.v1.Supervisor/Batch"
+	Supervisor_GetProcesses_FullMethodName    = "/systemgo.v1.Supervisor/GetProcesses"
+	Supervisor_GetJournal_FullMethodName      = "/systemgo.v1.Supervisor/GetJournal"
+	Supervisor_GetSamples_FullMethodName      = "/systemgo.v1.Supervisor/GetSamples"
+	Supervisor_GetConfig_FullMethodName       = "/systemgo.v1.Supervisor/GetConfig"
+	Supervisor_Plan_FullMethodName            = "/systemgo.v1.Supervisor/Plan"
+	Supervisor_Reload_FullMethodName          = "/systemgo.v1.Supervisor/Reload"
+	Supervisor_WatchEvents_FullMethodName     = "/systemgo.v1.Supervisor/WatchEvents"
+	Supervisor_StreamLogs_FullMethodName      = "/systemgo.v1.Supervisor/StreamLogs"
+	Supervisor_GetLogs_FullMethodName         = "/systemgo.v1.Supervisor/GetLogs"
+	Supervisor_GetUsage_FullMethodName        = "/systemgo.v1.Supervisor/GetUsage"
+	Supervisor_GetCapabilities_FullMethodName = "/systemgo.v1.Supervisor/GetCapabilities"
+)
+
+// SupervisorClient is the client API for Supervisor service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Supervisor manages services of a running systemgo process
+type SupervisorClient interface {
+	ListServices(ctx context.Context, in *ListServicesRequest, opts ...grpc.CallOption) (*ListServicesResponse, error)
+	GetStatus(ctx context.Context, in *ServiceRequest, opts ...grpc.CallOption) (*ServiceStatus, error)
+	Start(ctx context.Context, in *ServiceRequest, opts ...grpc.CallOption) (*ServiceStatus, error)
+	Stop(ctx context.Context, in *ServiceRequest, opts ...grpc.CallOption) (*ServiceStatus, error)
+	Restart(ctx context.Context, in *ServiceRequest, opts ...grpc.CallOption) (*ServiceStatus, error)
+	// Freeze pauses the process of a service with SIGSTOP, Thaw resumes it
+	Freeze(ctx context.Context, in *ServiceRequest, opts ...grpc.CallOption) (*ServiceStatus, error)
+	Thaw(ctx context.Context, in *ServiceRequest, opts ...grpc.CallOption) (*ServiceStatus, error)
+	// RestartTree restarts a service, optionally with everything depending on it,
+	// returning the ordered plan of its actions
+	RestartTree(ctx context.Context, in *RestartTreeRequest, opts ...grpc.CallOption) (*RestartTreeResponse, error)
+	// Batch starts, stops or restarts services at once, reporting every one of them
+	Batch(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error)
+	// GetProcesses lists the running process of a service and its descendants
+	GetProcesses(ctx context.Context, in *ServiceRequest, opts ...grpc.CallOption) (*GetProcessesResponse, error)
+	// GetJournal returns the most recent supervisor events of a service
+	GetJournal(ctx context.Context, in *GetJournalRequest, opts ...grpc.CallOption) (*GetJournalResponse, error)
+	// GetSamples returns resource usage samples of a service
+	GetSamples(ctx context.Context, in *GetSamplesRequest, opts ...grpc.CallOption) (*GetSamplesResponse, error)
+	// GetConfig returns the configuration a service runs with, defaults included
+	GetConfig(ctx context.Context, in *ServiceRequest, opts ...grpc.CallOption) (*GetConfigResponse, error)
+	// Plan tells what applying a configuration would do, without touching any service
+	Plan(ctx context.Context, in *PlanRequest, opts ...grpc.CallOption) (*PlanResponse, error)
+	// Reload reads the configuration of the supervisor again and applies it,
+	// returning what it changed
+	Reload(ctx context.Context, in *ReloadRequest, opts ...grpc.CallOption) (*PlanResponse, error)
+	// WatchEvents streams service state changes, messages and the events of their
+	// runs until the client goes away, its headers are sent once it is subscribed
+	WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error)
+	// StreamLogs follows the output of a service
+	StreamLogs(ctx context.Context, in *StreamLogsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[LogLine], error)
+	// GetLogs returns the last captured lines of a service
+	GetLogs(ctx context.Context, in *GetLogsRequest, opts ...grpc.CallOption) (*GetLogsResponse, error)
+	// GetUsage returns the cpu time and peak memory of the services since the
+	// supervisor started, the most cpu first
+	GetUsage(ctx context.Context, in *GetUsageRequest, opts ...grpc.CallOption) (*GetUsageResponse, error)
+	// GetCapabilities tells the features of /proc the supervisor found usable
+	GetCapabilities(ctx context.Context, in *GetCapabilitiesRequest, opts ...grpc.CallOption) (*Capabilities, error)
+}
+
+type supervisorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSupervisorClient(cc grpc.ClientConnInterface) SupervisorClient {
+	return &supervisorClient{cc}
+}
+
+func (c *supervisorClient) ListServices(ctx context.Context, in *ListServicesRequest, opts ...grpc.CallOption) (*ListServicesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListServicesResponse)
+	err := c.cc.Invoke(ctx, Supervisor_ListServices_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *supervisorClient) GetStatus(ctx context.Context, in *ServiceRequest, opts ...grpc.CallOption) (*ServiceStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ServiceStatus)
+	err := c.cc.Invoke(ctx, Supervisor_GetStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *supervisorClient) Start(ctx context.Context, in *ServiceRequest, opts ...grpc.CallOption) (*ServiceStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ServiceStatus)
+	err := c.cc.Invoke(ctx, Supervisor_Start_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *supervisorClient) Stop(ctx context.Context, in *ServiceRequest, opts ...grpc.CallOption) (*ServiceStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ServiceStatus)
+	err := c.cc.Invoke(ctx, Supervisor_Stop_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *supervisorClient) Restart(ctx context.Context, in *ServiceRequest, opts ...grpc.CallOption) (*ServiceStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ServiceStatus)
+	err := c.cc.Invoke(ctx, Supervisor_Restart_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *supervisorClient) Freeze(ctx context.Context, in *ServiceRequest, opts ...grpc.CallOption) (*ServiceStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ServiceStatus)
+	err := c.cc.Invoke(ctx, Supervisor_Freeze_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *supervisorClient) Thaw(ctx context.Context, in *ServiceRequest, opts ...grpc.CallOption) (*ServiceStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ServiceStatus)
+	err := c.cc.Invoke(ctx, Supervisor_Thaw_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *supervisorClient) RestartTree(ctx context.Context, in *RestartTreeRequest, opts ...grpc.CallOption) (*RestartTreeResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RestartTreeResponse)
+	err := c.cc.Invoke(ctx, Supervisor_RestartTree_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *supervisorClient) Batch(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchResponse)
+	err := c.cc.Invoke(ctx, Supervisor_Batch_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *supervisorClient) GetProcesses(ctx context.Context, in *ServiceRequest, opts ...grpc.CallOption) (*GetProcessesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetProcessesResponse)
+	err := c.cc.Invoke(ctx, Supervisor_GetProcesses_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *supervisorClient) GetJournal(ctx context.Context, in *GetJournalRequest, opts ...grpc.CallOption) (*GetJournalResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetJournalResponse)
+	err := c.cc.Invoke(ctx, Supervisor_GetJournal_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *supervisorClient) GetSamples(ctx context.Context, in *GetSamplesRequest, opts ...grpc.CallOption) (*GetSamplesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetSamplesResponse)
+	err := c.cc.Invoke(ctx, Supervisor_GetSamples_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *supervisorClient) GetConfig(ctx context.Context, in *ServiceRequest, opts ...grpc.CallOption) (*GetConfigResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetConfigResponse)
+	err := c.cc.Invoke(ctx, Supervisor_GetConfig_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *supervisorClient) Plan(ctx context.Context, in *PlanRequest, opts ...grpc.CallOption) (*PlanResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PlanResponse)
+	err := c.cc.Invoke(ctx, Supervisor_Plan_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *supervisorClient) Reload(ctx context.Context, in *ReloadRequest, opts ...grpc.CallOption) (*PlanResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PlanResponse)
+	err := c.cc.Invoke(ctx, Supervisor_Reload_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *supervisorClient) WatchEvents(ctx context.Context, in *WatchEventsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Supervisor_ServiceDesc.Streams[0], Supervisor_WatchEvents_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchEventsRequest, Event]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Supervisor_WatchEventsClient = grpc.ServerStreamingClient[Event]
+
+func (c *supervisorClient) StreamLogs(ctx context.Context, in *StreamLogsRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[LogLine], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Supervisor_ServiceDesc.Streams[1], Supervisor_StreamLogs_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamLogsRequest, LogLine]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Supervisor_StreamLogsClient = grpc.ServerStreamingClient[LogLine]
+
+func (c *supervisorClient) GetLogs(ctx context.Context, in *GetLogsRequest, opts ...grpc.CallOption) (*GetLogsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetLogsResponse)
+	err := c.cc.Invoke(ctx, Supervisor_GetLogs_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *supervisorClient) GetUsage(ctx context.Context, in *GetUsageRequest, opts ...grpc.CallOption) (*GetUsageResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetUsageResponse)
+	err := c.cc.Invoke(ctx, Supervisor_GetUsage_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *supervisorClient) GetCapabilities(ctx context.Context, in *GetCapabilitiesRequest, opts ...grpc.CallOption) (*Capabilities, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Capabilities)
+	err := c.cc.Invoke(ctx, Supervisor_GetCapabilities_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SupervisorServer is the server API for Supervisor service.
+// All implementations must embed UnimplementedSupervisorServer
+// for forward compatibility.
+//
+// Supervisor manages services of a running systemgo process
+type SupervisorServer interface {
+	ListServices(context.Context, *ListServicesRequest) (*ListServicesResponse, error)
+	GetStatus(context.Context, *ServiceRequest) (*ServiceStatus, error)
+	Start(context.Context, *ServiceRequest) (*ServiceStatus, error)
+	Stop(context.Context, *ServiceRequest) (*ServiceStatus, error)
+	Restart(context.Context, *ServiceRequest) (*ServiceStatus, error)
+	// Freeze pauses the process of a service with SIGSTOP, Thaw resumes it
+	Freeze(context.Context, *ServiceRequest) (*ServiceStatus, error)
+	Thaw(context.Context, *ServiceRequest) (*ServiceStatus, error)
+	// RestartTree restarts a service, optionally with everything depending on it,
+	// returning the ordered plan of its actions
+	RestartTree(context.Context, *RestartTreeRequest) (*RestartTreeResponse, error)
+	// Batch starts, stops or restarts services at once, reporting every one of them
+	Batch(context.Context, *BatchRequest) (*BatchResponse, error)
+	// GetProcesses lists the running process of a service and its descendants
+	GetProcesses(context.Context, *ServiceRequest) (*GetProcessesResponse, error)
+	// GetJournal returns the most recent supervisor events of a service
+	GetJournal(context.Context, *GetJournalRequest) (*GetJournalResponse, error)
+	// GetSamples returns resource usage samples of a service
+	GetSamples(context.Context, *GetSamplesRequest) (*GetSamplesResponse, error)
+	// GetConfig returns the configuration a service runs with, defaults included
+	GetConfig(context.Context, *ServiceRequest) (*GetConfigResponse, error)
+	// Plan tells what applying a configuration would do, without touching any service
+	Plan(context.Context, *PlanRequest) (*PlanResponse, error)
+	// Reload reads the configuration of the supervisor again and applies it,
+	// returning what it changed
+	Reload(context.Context, *ReloadRequest) (*PlanResponse, error)
+	// WatchEvents streams service state changes, messages and the events of their
+	// runs until the client goes away, its headers are sent once it is subscribed
+	WatchEvents(*WatchEventsRequest, grpc.ServerStreamingServer[Event]) error
+	// StreamLogs follows the output of a service
+	StreamLogs(*StreamLogsRequest, grpc.ServerStreamingServer[LogLine]) error
+	// GetLogs returns the last captured lines of a service
+	GetLogs(context.Context, *GetLogsRequest) (*GetLogsResponse, error)
+	// GetUsage returns the cpu time and peak memory of the services since the
+	// supervisor started, the most cpu first
+	GetUsage(context.Context, *GetUsageRequest) (*GetUsageResponse, error)
+	// GetCapabilities tells the features of /proc the supervisor found usable
+	GetCapabilities(context.Context, *GetCapabilitiesRequest) (*Capabilities, error)
+	mustEmbedUnimplementedSupervisorServer()
+}
+
+// UnimplementedSupervisorServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedSupervisorServer struct{}
+
+func (UnimplementedSupervisorServer) ListServices(context.Context, *ListServicesRequest) (*ListServicesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListServices not implemented")
+}
+func (UnimplementedSupervisorServer) GetStatus(context.Context, *ServiceRequest) (*ServiceStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatus not implemented")
+}
+func (UnimplementedSupervisorServer) Start(context.Context, *ServiceRequest) (*ServiceStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Start not implemented")
+}
+func (UnimplementedSupervisorServer) Stop(context.Context, *ServiceRequest) (*ServiceStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stop not implemented")
+}
+func (UnimplementedSupervisorServer) Restart(context.Context, *ServiceRequest) (*ServiceStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Restart not implemented")
+}
+func (UnimplementedSupervisorServer) Freeze(context.Context, *ServiceRequest) (*ServiceStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Freeze not implemented")
+}
+func (UnimplementedSupervisorServer) Thaw(context.Context, *ServiceRequest) (*ServiceStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Thaw not implemented")
+}
+func (UnimplementedSupervisorServer) RestartTree(context.Context, *RestartTreeRequest) (*RestartTreeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestartTree not implemented")
+}
+func (UnimplementedSupervisorServer) Batch(context.Context, *BatchRequest) (*BatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Batch not implemented")
+}
+func (UnimplementedSupervisorServer) GetProcesses(context.Context, *ServiceRequest) (*GetProcessesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetProcesses not implemented")
+}
+func (UnimplementedSupervisorServer) GetJournal(context.Context, *GetJournalRequest) (*GetJournalResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetJournal not implemented")
+}
+func (UnimplementedSupervisorServer) GetSamples(context.Context, *GetSamplesRequest) (*GetSamplesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSamples not implemented")
+}
+func (UnimplementedSupervisorServer) GetConfig(context.Context, *ServiceRequest) (*GetConfigResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetConfig not implemented")
+}
+func (UnimplementedSupervisorServer) Plan(context.Context, *PlanRequest) (*PlanResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Plan not implemented")
+}
+func (UnimplementedSupervisorServer) Reload(context.Context, *ReloadRequest) (*PlanResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Reload not implemented")
+}
+func (UnimplementedSupervisorServer) WatchEvents(*WatchEventsRequest, grpc.ServerStreamingServer[Event]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchEvents not implemented")
+}
+func (UnimplementedSupervisorServer) StreamLogs(*StreamLogsRequest, grpc.ServerStreamingServer[LogLine]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamLogs not implemented")
+}
+func (UnimplementedSupervisorServer) GetLogs(context.Context, *GetLogsRequest) (*GetLogsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLogs not implemented")
+}
+func (UnimplementedSupervisorServer) GetUsage(context.Context, *GetUsageRequest) (*GetUsageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUsage not implemented")
+}
+func (UnimplementedSupervisorServer) GetCapabilities(context.Context, *GetCapabilitiesRequest) (*Capabilities, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCapabilities not implemented")
+}
+func (UnimplementedSupervisorServer) mustEmbedUnimplementedSupervisorServer() {}
+func (UnimplementedSupervisorServer) testEmbeddedByValue()                    {}
+
+// UnsafeSupervisorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SupervisorServer will
+// result in compilation errors.
+type UnsafeSupervisorServer interface {
+	mustEmbedUnimplementedSupervisorServer()
+}
+
+func RegisterSupervisorServer(s grpc.ServiceRegistrar, srv SupervisorServer) {
+	// If the following call pancis, it indicates UnimplementedSupervisorServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Supervisor_ServiceDesc, srv)
+}
+
+func _Supervisor_ListServices_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListServicesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SupervisorServer).ListServices(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Supervisor_ListServices_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SupervisorServer).ListServices(ctx, req.(*ListServicesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Supervisor_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ServiceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SupervisorServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Supervisor_GetStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SupervisorServer).GetStatus(ctx, req.(*ServiceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Supervisor_Start_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ServiceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SupervisorServer).Start(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Supervisor_Start_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SupervisorServer).Start(ctx, req.(*ServiceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Supervisor_Stop_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ServiceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SupervisorServer).Stop(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Supervisor_Stop_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SupervisorServer).Stop(ctx, req.(*ServiceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Supervisor_Restart_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ServiceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SupervisorServer).Restart(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Supervisor_Restart_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SupervisorServer).Restart(ctx, req.(*ServiceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Supervisor_Freeze_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ServiceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SupervisorServer).Freeze(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Supervisor_Freeze_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SupervisorServer).Freeze(ctx, req.(*ServiceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Supervisor_Thaw_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ServiceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SupervisorServer).Thaw(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Supervisor_Thaw_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SupervisorServer).Thaw(ctx, req.(*ServiceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Supervisor_RestartTree_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestartTreeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SupervisorServer).RestartTree(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Supervisor_RestartTree_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SupervisorServer).RestartTree(ctx, req.(*RestartTreeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Supervisor_Batch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SupervisorServer).Batch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Supervisor_Batch_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SupervisorServer).Batch(ctx, req.(*BatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Supervisor_GetProcesses_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ServiceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SupervisorServer).GetProcesses(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Supervisor_GetProcesses_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SupervisorServer).GetProcesses(ctx, req.(*ServiceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Supervisor_GetJournal_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetJournalRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SupervisorServer).GetJournal(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Supervisor_GetJournal_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SupervisorServer).GetJournal(ctx, req.(*GetJournalRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Supervisor_GetSamples_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSamplesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SupervisorServer).GetSamples(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Supervisor_GetSamples_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SupervisorServer).GetSamples(ctx, req.(*GetSamplesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Supervisor_GetConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ServiceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SupervisorServer).GetConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Supervisor_GetConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SupervisorServer).GetConfig(ctx, req.(*ServiceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Supervisor_Plan_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PlanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SupervisorServer).Plan(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Supervisor_Plan_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SupervisorServer).Plan(ctx, req.(*PlanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Supervisor_Reload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReloadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SupervisorServer).Reload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Supervisor_Reload_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SupervisorServer).Reload(ctx, req.(*ReloadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Supervisor_WatchEvents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchEventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SupervisorServer).WatchEvents(m, &grpc.GenericServerStream[WatchEventsRequest, Event]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Supervisor_WatchEventsServer = grpc.ServerStreamingServer[Event]
+
+func _Supervisor_StreamLogs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamLogsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SupervisorServer).StreamLogs(m, &grpc.GenericServerStream[StreamLogsRequest, LogLine]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Supervisor_StreamLogsServer = grpc.ServerStreamingServer[LogLine]
+
+func _Supervisor_GetLogs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetLogsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SupervisorServer).GetLogs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Supervisor_GetLogs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SupervisorServer).GetLogs(ctx, req.(*GetLogsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Supervisor_GetUsage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUsageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SupervisorServer).GetUsage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Supervisor_GetUsage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SupervisorServer).GetUsage(ctx, req.(*GetUsageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Supervisor_GetCapabilities_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCapabilitiesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SupervisorServer).GetCapabilities(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Supervisor_GetCapabilities_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SupervisorServer).GetCapabilities(ctx, req.(*GetCapabilitiesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Supervisor_ServiceDesc is the grpc.ServiceDesc for Supervisor service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Supervisor_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "systemgo.v1.Supervisor",
+	HandlerType: (*SupervisorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListServices",
+			Handler:    _Supervisor_ListServices_Handler,
+		},
+		{
+			MethodName: "GetStatus",
+			Handler:    _Supervisor_GetStatus_Handler,
+		},
+		{
+			MethodName: "Start",
+			Handler:    _Supervisor_Start_Handler,
+		},
+		{
+			MethodName: "Stop",
+			Handler:    _Supervisor_Stop_Handler,
+		},
+		{
+			MethodName: "Restart",
+			Handler:    _Supervisor_Restart_Handler,
+		},
+		{
+			MethodName: "Freeze",
+			Handler:    _Supervisor_Freeze_Handler,
+		},
+		{
+			MethodName: "Thaw",
+			Handler:    _Supervisor_Thaw_Handler,
+		},
+		{
+			MethodName: "RestartTree",
+			Handler:    _Supervisor_RestartTree_Handler,
+		},
+		{
+			MethodName: "Batch",
+			Handler:    _Supervisor_Batch_Handler,
+		},
+		{
+			MethodName: "GetProcesses",
+			Handler:    _Supervisor_GetProcesses_Handler,
+		},
+		{
+			MethodName: "GetJournal",
+			Handler:    _Supervisor_GetJournal_Handler,
+		},
+		{
+			MethodName: "GetSamples",
+			Handler:    _Supervisor_GetSamples_Handler,
+		},
+		{
+			MethodName: "GetConfig",
+			Handler:    _Supervisor_GetConfig_Handler,
+		},
+		{
+			MethodName: "Plan",
+			Handler:    _Supervisor_Plan_Handler,
+		},
+		{
+			MethodName: "Reload",
+			Handler:    _Supervisor_Reload_Handler,
+		},
+		{
+			MethodName: "GetLogs",
+			Handler:    _Supervisor_GetLogs_Handler,
+		},
+		{
+			MethodName: "GetUsage",
+			Handler:    _Supervisor_GetUsage_Handler,
+		},
+		{
+			MethodName: "GetCapabilities",
+			Handler:    _Supervisor_GetCapabilities_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchEvents",
+			Handler:       _Supervisor_WatchEvents_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StreamLogs",
+			Handler:       _Supervisor_StreamLogs_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "pb/supervisor.proto",
+}
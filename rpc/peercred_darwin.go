@@ -0,0 +1,23 @@
+package rpc
+
+import "golang.org/x/sys/unix"
+
+// peerCredentialsOf reads LOCAL_PEERCRED and LOCAL_PEERPID of a unix socket
+func peerCredentialsOf(fd uintptr) (*PeerInfo, error) {
+	cred, err := unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	if err != nil {
+		return nil, err
+	}
+
+	pid, err := unix.GetsockoptInt(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERPID)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &PeerInfo{Pid: pid, Uid: int(cred.Uid)}
+	if cred.Ngroups > 0 {
+		info.Gid = int(cred.Groups[0])
+	}
+
+	return info, nil
+}
@@ -0,0 +1,13 @@
+package rpc
+
+import "syscall"
+
+// peerCredentialsOf reads SO_PEERCRED of a unix socket
+func peerCredentialsOf(fd uintptr) (*PeerInfo, error) {
+	cred, err := syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PeerInfo{Pid: int(cred.Pid), Uid: int(cred.Uid), Gid: int(cred.Gid)}, nil
+}
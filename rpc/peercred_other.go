@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package rpc
+
+// peerCredentialsOf tells no peer, the audit log names the socket instead
+func peerCredentialsOf(fd uintptr) (*PeerInfo, error) {
+	return nil, nil
+}
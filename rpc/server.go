@@ -0,0 +1,614 @@
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative pb/supervisor.proto
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/imunhatep/systemgo/rpc/pb"
+	"github.com/imunhatep/systemgo/system"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var states = map[system.State]pb.State{
+	system.StateNew:        pb.State_STATE_NEW,
+	system.StateRunning:    pb.State_STATE_RUNNING,
+	system.StateStopping:   pb.State_STATE_STOPPING,
+	system.StateFinished:   pb.State_STATE_FINISHED,
+	system.StateRestarting: pb.State_STATE_RESTARTING,
+	system.StateStopped:    pb.State_STATE_STOPPED,
+	system.StateReady:      pb.State_STATE_READY,
+	system.StateFailed:     pb.State_STATE_FAILED,
+	system.StateListening:  pb.State_STATE_LISTENING,
+	system.StateStopFailed: pb.State_STATE_STOP_FAILED,
+	system.StateFrozen:     pb.State_STATE_FROZEN,
+	system.StateNotReady:   pb.State_STATE_NOT_READY,
+
+	system.StatePendingRestart: pb.State_STATE_PENDING_RESTART,
+	system.StateScheduled:      pb.State_STATE_SCHEDULED,
+}
+
+var stopReasons = map[system.StopReason]pb.StopReason{
+	system.StopReasonUnknown:            pb.StopReason_STOP_REASON_UNSPECIFIED,
+	system.StopReasonCrashed:            pb.StopReason_STOP_REASON_CRASHED,
+	system.StopReasonCompleted:          pb.StopReason_STOP_REASON_COMPLETED,
+	system.StopReasonOperatorStop:       pb.StopReason_STOP_REASON_OPERATOR_STOP,
+	system.StopReasonSupervisorShutdown: pb.StopReason_STOP_REASON_SUPERVISOR_SHUTDOWN,
+	system.StopReasonLivenessFailed:     pb.StopReason_STOP_REASON_LIVENESS_FAILED,
+	system.StopReasonMemoryLimit:        pb.StopReason_STOP_REASON_MEMORY_LIMIT,
+	system.StopReasonWatchdogTimeout:    pb.StopReason_STOP_REASON_WATCHDOG_TIMEOUT,
+	system.StopReasonFileChanged:        pb.StopReason_STOP_REASON_FILE_CHANGED,
+	system.StopReasonIdle:               pb.StopReason_STOP_REASON_IDLE,
+	system.StopReasonOutputTrigger:      pb.StopReason_STOP_REASON_OUTPUT_TRIGGER,
+	system.StopReasonHookFailed:         pb.StopReason_STOP_REASON_HOOK_FAILED,
+	system.StopReasonFailurePattern:     pb.StopReason_STOP_REASON_FAILURE_PATTERN,
+}
+
+var planActions = map[system.PlanAction]pb.PlanAction{
+	system.PlanUnchanged: pb.PlanAction_PLAN_ACTION_UNCHANGED,
+	system.PlanAdded:     pb.PlanAction_PLAN_ACTION_ADDED,
+	system.PlanRemoved:   pb.PlanAction_PLAN_ACTION_REMOVED,
+	system.PlanRestart:   pb.PlanAction_PLAN_ACTION_RESTART_REQUIRED,
+	system.PlanUpdate:    pb.PlanAction_PLAN_ACTION_UPDATE,
+}
+
+type server struct {
+	pb.UnimplementedSupervisorServer
+
+	manager *system.Manager
+}
+
+// NewServer returns a grpc server exposing the manager as the Supervisor service
+func NewServer(manager *system.Manager, opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(opts...)
+	pb.RegisterSupervisorServer(s, &server{manager: manager})
+
+	return s
+}
+
+func (s *server) ListServices(ctx context.Context, req *pb.ListServicesRequest) (*pb.ListServicesResponse, error) {
+	resp := new(pb.ListServicesResponse)
+	for _, st := range s.manager.ListServices() {
+		resp.Services = append(resp.Services, toStatus(st))
+	}
+	resp.StartSequence = s.manager.StartSequence()
+
+	return resp, nil
+}
+
+func (s *server) GetStatus(ctx context.Context, req *pb.ServiceRequest) (*pb.ServiceStatus, error) {
+	return s.status(req.GetName())
+}
+
+func (s *server) Start(ctx context.Context, req *pb.ServiceRequest) (*pb.ServiceStatus, error) {
+	err := s.manager.Audit(requester(ctx), "start", req.GetName(), func() error {
+		return s.manager.Start(req.GetName())
+	})
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	return s.status(req.GetName())
+}
+
+func (s *server) Stop(ctx context.Context, req *pb.ServiceRequest) (*pb.ServiceStatus, error) {
+	err := s.manager.Audit(requester(ctx), "stop", req.GetName(), func() error {
+		return s.manager.Stop(req.GetName())
+	})
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	return s.status(req.GetName())
+}
+
+func (s *server) Restart(ctx context.Context, req *pb.ServiceRequest) (*pb.ServiceStatus, error) {
+	err := s.manager.Audit(requester(ctx), "restart", req.GetName(), func() error {
+		return s.manager.Restart(req.GetName())
+	})
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	return s.status(req.GetName())
+}
+
+func (s *server) RestartTree(ctx context.Context, req *pb.RestartTreeRequest) (*pb.RestartTreeResponse, error) {
+	if req.GetDryRun() {
+		plan, err := s.manager.PlanRestartTree(req.GetName(), req.GetIncludeDependents())
+		if err != nil {
+			return nil, toError(err)
+		}
+
+		return toTreeResponse(plan), nil
+	}
+
+	var plan []system.TreeAction
+	err := s.manager.Audit(requester(ctx), "restart-tree", req.GetName(), func() error {
+		var err error
+		plan, err = s.manager.RestartTree(req.GetName(), req.GetIncludeDependents())
+		return err
+	})
+	if err != nil && plan == nil {
+		return nil, toError(err)
+	}
+
+	return toTreeResponse(plan), nil
+}
+
+func toTreeResponse(plan []system.TreeAction) *pb.RestartTreeResponse {
+	resp := new(pb.RestartTreeResponse)
+	for _, action := range plan {
+		resp.Actions = append(resp.Actions, &pb.TreeAction{Action: action.Action, Service: action.Service, Error: action.Error})
+	}
+
+	return resp
+}
+
+func (s *server) Freeze(ctx context.Context, req *pb.ServiceRequest) (*pb.ServiceStatus, error) {
+	err := s.manager.Audit(requester(ctx), "freeze", req.GetName(), func() error {
+		return s.manager.Freeze(req.GetName())
+	})
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	return s.status(req.GetName())
+}
+
+func (s *server) Thaw(ctx context.Context, req *pb.ServiceRequest) (*pb.ServiceStatus, error) {
+	err := s.manager.Audit(requester(ctx), "thaw", req.GetName(), func() error {
+		return s.manager.Thaw(req.GetName())
+	})
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	return s.status(req.GetName())
+}
+
+func (s *server) Batch(ctx context.Context, req *pb.BatchRequest) (*pb.BatchResponse, error) {
+	var operation string
+	var batch func(names ...string) map[string]error
+	switch req.GetAction() {
+	case pb.BatchAction_BATCH_ACTION_START:
+		operation, batch = "start", s.manager.StartAll
+	case pb.BatchAction_BATCH_ACTION_STOP:
+		operation, batch = "stop", s.manager.StopAll
+	case pb.BatchAction_BATCH_ACTION_RESTART:
+		operation, batch = "restart", s.manager.RestartAll
+	default:
+		return nil, status.Error(codes.InvalidArgument, "unknown batch action")
+	}
+
+	var results map[string]error
+	s.manager.Audit(requester(ctx), operation, strings.Join(req.GetNames(), ","), func() error {
+		results = batch(req.GetNames()...)
+		return batchError(results)
+	})
+
+	resp := new(pb.BatchResponse)
+	for _, name := range req.GetNames() {
+		err, ok := results[name]
+		if !ok {
+			continue
+		}
+		delete(results, name)
+
+		result := &pb.BatchResult{Name: name}
+		if err != nil {
+			result.Code = int32(status.Code(toError(err)))
+			result.Error = err.Error()
+		}
+
+		if st, statusErr := s.status(name); statusErr == nil {
+			result.Status = st
+		}
+
+		resp.Results = append(resp.Results, result)
+	}
+
+	return resp, nil
+}
+
+func (s *server) GetUsage(ctx context.Context, req *pb.GetUsageRequest) (*pb.GetUsageResponse, error) {
+	resp := new(pb.GetUsageResponse)
+	for _, usage := range s.manager.Usage() {
+		resp.Services = append(resp.Services, &pb.ServiceUsage{
+			Name:      usage.Name,
+			Runs:      int32(usage.Runs),
+			UserCpu:   durationpb.New(usage.UserCPU),
+			SystemCpu: durationpb.New(usage.SystemCPU),
+			PeakRssKb: usage.PeakRSSKB,
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *server) GetProcesses(ctx context.Context, req *pb.ServiceRequest) (*pb.GetProcessesResponse, error) {
+	tree, err := s.manager.GetProcessTree(req.GetName())
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	resp := new(pb.GetProcessesResponse)
+	for _, info := range tree {
+		resp.Processes = append(resp.Processes, &pb.ProcInfo{
+			Pid:          int32(info.PID),
+			Ppid:         int32(info.PPID),
+			Command:      info.Command,
+			RssKb:        info.RssKB,
+			MemoryKb:     info.MemoryKB,
+			MemoryMetric: info.MemoryMetric,
+		})
+
+		resp.TotalMemoryKb += info.MemoryKB
+		resp.MemoryMetric = info.MemoryMetric
+	}
+
+	return resp, nil
+}
+
+func (s *server) GetJournal(ctx context.Context, req *pb.GetJournalRequest) (*pb.GetJournalResponse, error) {
+	entries, err := s.manager.GetJournal(req.GetName(), int(req.GetLimit()))
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	resp := new(pb.GetJournalResponse)
+	for _, entry := range entries {
+		resp.Entries = append(resp.Entries, &pb.JournalEntry{
+			Time:     timestamppb.New(entry.Time),
+			Type:     entry.Type,
+			Pid:      int32(entry.PID),
+			ExitCode: int32(entry.ExitCode),
+			Reason:   stopReasons[entry.Reason],
+			Delay:    durationpb.New(entry.Delay),
+			Message:  entry.Message,
+			Count:    int32(entry.Count),
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *server) GetSamples(ctx context.Context, req *pb.GetSamplesRequest) (*pb.GetSamplesResponse, error) {
+	var since time.Time
+	if req.GetSince() != nil {
+		since = req.GetSince().AsTime()
+	}
+
+	samples, err := s.manager.GetSamples(req.GetName(), since)
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	resp := new(pb.GetSamplesResponse)
+	for _, sample := range samples {
+		resp.Samples = append(resp.Samples, &pb.Sample{
+			Time:        timestamppb.New(sample.Time),
+			Incarnation: int32(sample.Incarnation),
+			RssBytes:    sample.RSSBytes,
+			CpuPercent:  sample.CPUPercent,
+			MemoryBytes: sample.MemoryBytes,
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *server) GetConfig(ctx context.Context, req *pb.ServiceRequest) (*pb.GetConfigResponse, error) {
+	config, err := s.manager.GetConfig(req.GetName())
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	settings, err := json.Marshal(config.Settings)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.GetConfigResponse{Name: config.Name, Settings: settings, Defaulted: config.Defaulted}, nil
+}
+
+func (s *server) Plan(ctx context.Context, req *pb.PlanRequest) (*pb.PlanResponse, error) {
+	configs, err := system.ParseConfig(req.GetFormat(), req.GetConfig())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	plan, err := s.manager.Plan(configs)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return toPlanResponse(plan), nil
+}
+
+func (s *server) Reload(ctx context.Context, req *pb.ReloadRequest) (*pb.PlanResponse, error) {
+	var plan system.Plan
+	err := s.manager.Audit(requester(ctx), "reload", s.manager.GetConfigPath(), func() error {
+		var err error
+		plan, err = s.manager.Reload()
+		return err
+	})
+
+	switch {
+	case errors.Is(err, system.ErrNoConfigPath):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case err != nil:
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return toPlanResponse(plan), nil
+}
+
+func toPlanResponse(plan system.Plan) *pb.PlanResponse {
+	resp := &pb.PlanResponse{StartSequence: plan.StartSequence}
+	for _, change := range plan.Changes {
+		resp.Changes = append(resp.Changes, &pb.PlanChange{
+			Name:   change.Name,
+			Action: planActions[change.Action],
+			Fields: change.Fields,
+
+			RunningHash: change.RunningHash,
+			Hash:        change.Hash,
+			Dependents:  change.Dependents,
+		})
+	}
+
+	return resp
+}
+
+func (s *server) WatchEvents(req *pb.WatchEventsRequest, stream pb.Supervisor_WatchEventsServer) error {
+	watched := make(map[string]bool)
+	for _, name := range req.GetServices() {
+		if _, err := s.manager.GetService(name); err != nil {
+			return toError(err)
+		}
+		watched[name] = true
+	}
+
+	events := s.manager.Subscribe()
+	defer s.manager.Unsubscribe(events)
+
+	// the headers tell the client it sees every event from now on
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+
+			if len(watched) > 0 && !watched[event.Service] {
+				continue
+			}
+
+			var delay *durationpb.Duration
+			if event.Delay > 0 {
+				delay = durationpb.New(event.Delay)
+			}
+
+			err := stream.Send(&pb.Event{
+				Service:  event.Service,
+				Type:     event.Type,
+				State:    states[event.State],
+				Pid:      int32(event.PID),
+				ExitCode: int32(event.ExitCode),
+				Time:     timestamppb.New(event.Time),
+				Stderr:   event.Stderr,
+
+				StopReason: stopReasons[event.StopReason],
+				Level:      event.Level,
+				Message:    event.Message,
+
+				Incarnation: int32(event.Incarnation),
+				Labels:      event.Labels,
+				CorePath:    event.CorePath,
+				Delay:       delay,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *server) StreamLogs(req *pb.StreamLogsRequest, stream pb.Supervisor_StreamLogsServer) error {
+	if _, ok := system.ParseLevel(req.GetLevel()); req.GetLevel() != "" && !ok {
+		return status.Errorf(codes.InvalidArgument, "unknown level %q", req.GetLevel())
+	}
+
+	lines, stop, err := s.manager.FollowOutput(req.GetService(), int(req.GetIncarnation()))
+	if err != nil {
+		return toError(err)
+	}
+	defer stop()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case line, ok := <-lines:
+			if !ok {
+				return nil
+			}
+
+			if !system.LevelAtLeast(line.Level, req.GetLevel()) {
+				continue
+			}
+
+			if err := stream.Send(toLogLine(line)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *server) GetLogs(ctx context.Context, req *pb.GetLogsRequest) (*pb.GetLogsResponse, error) {
+	if _, ok := system.ParseLevel(req.GetLevel()); req.GetLevel() != "" && !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown level %q", req.GetLevel())
+	}
+
+	lines, err := s.manager.GetRecentOutput(req.GetService(), 0)
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	resp := new(pb.GetLogsResponse)
+	for _, line := range lines {
+		if system.LevelAtLeast(line.Level, req.GetLevel()) {
+			resp.Lines = append(resp.Lines, toLogLine(line))
+		}
+	}
+
+	if n := int(req.GetLines()); n > 0 && n < len(resp.Lines) {
+		resp.Lines = resp.Lines[len(resp.Lines)-n:]
+	}
+
+	return resp, nil
+}
+
+func toLogLine(line system.LogLine) *pb.LogLine {
+	return &pb.LogLine{
+		Service: line.Service,
+		Stream:  line.Stream,
+		Level:   line.Level,
+		Text:    line.Text,
+		Time:    timestamppb.New(line.Time),
+
+		Incarnation: int32(line.Incarnation),
+		Labels:      line.Labels,
+	}
+}
+
+func (s *server) GetCapabilities(ctx context.Context, req *pb.GetCapabilitiesRequest) (*pb.Capabilities, error) {
+	c := s.manager.Capabilities()
+
+	return &pb.Capabilities{
+		ProcRoot:        c.Root,
+		Processes:       c.Processes,
+		Memory:          c.Memory,
+		SmapsRollup:     c.SmapsRollup,
+		FileDescriptors: c.FileDescriptors,
+		Sockets:         c.Sockets,
+		Unavailable:     c.Unavailable(),
+	}, nil
+}
+
+func (s *server) status(name string) (*pb.ServiceStatus, error) {
+	// a template has no state of its own, its instances are listed by ListServices
+	if system.IsTemplate(name) {
+		return &pb.ServiceStatus{Name: name, Template: name}, nil
+	}
+
+	st, err := s.manager.GetStatus(name)
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	return toStatus(st), nil
+}
+
+func toStatus(st system.ServiceStatus) *pb.ServiceStatus {
+	status := &pb.ServiceStatus{
+		Name:         st.Name,
+		State:        states[st.State],
+		Pid:          int32(st.PID),
+		Runs:         int32(st.Runs),
+		LastExitCode: int32(st.LastExitCode),
+		MemoryKb:     st.MemoryKB,
+		MemoryMetric: st.MemoryMetric,
+		Template:     st.Template,
+		ReplicaOf:    st.ReplicaOf,
+		Replica:      int32(st.Replica),
+
+		LastStopReason: stopReasons[st.LastStopReason],
+		ForcedKills:    int32(st.ForcedKills),
+		Incarnation:    int32(st.Incarnation),
+		Labels:         st.Labels,
+		OutputBuffered: int64(st.OutputBuffered),
+		OutputDropped:  st.OutputDropped,
+		LastCoreDumped: st.LastCoreDumped,
+		LastCorePath:   st.LastCorePath,
+	}
+
+	if !st.StartedAt.IsZero() {
+		status.StartedAt = timestamppb.New(st.StartedAt)
+	}
+
+	if len(st.StateDurations) > 0 {
+		status.StateDurations = make(map[string]*durationpb.Duration, len(st.StateDurations))
+		for state, d := range st.StateDurations {
+			status.StateDurations[state.String()] = durationpb.New(d)
+		}
+	}
+
+	status.StartLatency = toLatency(st.StartLatency)
+	status.ReadyLatency = toLatency(st.ReadyLatency)
+	status.Role = st.Role
+	status.Type = st.Type
+	status.ProbeError = st.ProbeError
+	status.ConfigHash = st.ConfigHash
+	status.RestartDeferred = st.RestartDeferred
+	status.OutputLevels = st.OutputLevels
+
+	return status
+}
+
+func toLatency(stats system.LatencyStats) *pb.Latency {
+	if stats.Last == 0 {
+		return nil
+	}
+
+	return &pb.Latency{Last: durationpb.New(stats.Last), P50: durationpb.New(stats.P50), P95: durationpb.New(stats.P95)}
+}
+
+// batchError names the services a batch failed for, nil if it failed for none
+func batchError(results map[string]error) error {
+	var failed []string
+	for name, err := range results {
+		if err != nil {
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	sort.Strings(failed)
+
+	return fmt.Errorf("failed for %s", strings.Join(failed, ","))
+}
+
+func toError(err error) error {
+	switch {
+	case errors.Is(err, system.ErrServiceNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, system.ErrAlreadyRunning), errors.Is(err, system.ErrNotRunning),
+		errors.Is(err, system.ErrFrozen), errors.Is(err, system.ErrNotFrozen), errors.Is(err, system.ErrNoProcess),
+		errors.Is(err, system.ErrProcUnavailable), errors.Is(err, system.ErrExternal), errors.Is(err, system.ErrLocked):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, system.ErrManagerNotStarted), errors.Is(err, system.ErrShuttingDown):
+		return status.Error(codes.Unavailable, err.Error())
+	}
+
+	return status.Error(codes.Internal, err.Error())
+}
@@ -0,0 +1,205 @@
+package rpc
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/imunhatep/systemgo/rpc/pb"
+	"github.com/imunhatep/systemgo/system"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// serve runs a manager of the services and its server over a bufconn, the
+// client dials it with opts
+func serve(t *testing.T, services []system.Service, serverOpts []grpc.ServerOption, opts ...grpc.DialOption) pb.SupervisorClient {
+	t.Helper()
+
+	manager, err := system.NewServiceManager(services)
+	if err != nil {
+		t.Fatalf("manager: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		manager.Run(ctx)
+		close(done)
+	}()
+
+	listener := bufconn.Listen(1 << 20)
+	server := NewServer(manager, serverOpts...)
+	go server.Serve(listener)
+
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }
+	opts = append(opts, grpc.WithContextDialer(dialer), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.Dial("passthrough:///bufconn", opts...)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+
+	t.Cleanup(func() {
+		conn.Close()
+		server.Stop()
+		cancel()
+		<-done
+	})
+
+	return pb.NewSupervisorClient(conn)
+}
+
+func sleeper(name string) system.Service {
+	return system.Service{ServiceConfig: system.ServiceConfig{
+		Name:          name,
+		Exec:          "sleep",
+		Params:        []string{"30"},
+		RestartPolicy: system.RESTART_ALWAYS,
+		StopTimeout:   time.Second,
+	}}
+}
+
+// running waits for the service to run
+func running(t *testing.T, client pb.SupervisorClient, name string) *pb.ServiceStatus {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		st, err := client.GetStatus(context.Background(), &pb.ServiceRequest{Name: name})
+		if err == nil && st.GetState() == pb.State_STATE_RUNNING {
+			return st
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s is not running: %v %v", name, st, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatchEventsOfARestart(t *testing.T) {
+	client := serve(t, []system.Service{sleeper("web"), sleeper("worker")}, nil)
+	before := running(t, client, "web")
+	running(t, client, "worker")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := client.WatchEvents(ctx, &pb.WatchEventsRequest{Services: []string{"web"}})
+	if err != nil {
+		t.Fatalf("watch: %s", err)
+	}
+	// the stream is subscribed once its headers are sent
+	if _, err := stream.Header(); err != nil {
+		t.Fatalf("headers: %s", err)
+	}
+
+	if _, err := client.Restart(ctx, &pb.ServiceRequest{Name: "web"}); err != nil {
+		t.Fatalf("restart: %s", err)
+	}
+
+	want := []pb.State{pb.State_STATE_FINISHED, pb.State_STATE_RUNNING}
+	for len(want) > 0 {
+		event, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("%s not seen before %s", want[0], err)
+		}
+		if event.GetService() != "web" {
+			t.Fatalf("event of %s, only web is watched", event.GetService())
+		}
+		if event.GetType() != system.EVENT_STATE {
+			continue
+		}
+
+		switch event.GetState() {
+		case want[0]:
+			want = want[1:]
+		case pb.State_STATE_RUNNING:
+			t.Fatalf("running again before the run finished")
+		}
+	}
+
+	after := running(t, client, "web")
+	if after.GetPid() == before.GetPid() || after.GetRuns() != before.GetRuns()+1 {
+		t.Errorf("pid %d in run %d after the restart, want a new run after pid %d", after.GetPid(), after.GetRuns(), before.GetPid())
+	}
+}
+
+func TestWatchEventsOfAMissingService(t *testing.T) {
+	client := serve(t, []system.Service{sleeper("web")}, nil)
+
+	stream, err := client.WatchEvents(context.Background(), &pb.WatchEventsRequest{Services: []string{"cron"}})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("watching a missing service: %v, want %s", err, codes.NotFound)
+	}
+}
+
+func TestStreamLogs(t *testing.T) {
+	client := serve(t, []system.Service{{ServiceConfig: system.ServiceConfig{
+		Name:        "ticker",
+		Exec:        "/bin/sh",
+		Params:      []string{"-c", "while true; do echo tick; sleep 0.05; done"},
+		StopTimeout: time.Second,
+	}}}, nil)
+	running(t, client, "ticker")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := client.StreamLogs(ctx, &pb.StreamLogsRequest{Service: "ticker"})
+	if err != nil {
+		t.Fatalf("stream logs: %s", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		line, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("line %d: %s", i, err)
+		}
+		if line.GetText() != "tick" {
+			t.Errorf("line %q, want tick", line.GetText())
+		}
+	}
+}
+
+func TestTokenAuth(t *testing.T) {
+	auth := WithAuth(TokenAuth("secret"))
+
+	for _, test := range []struct {
+		opts []grpc.DialOption
+		want codes.Code
+	}{
+		{nil, codes.Unauthenticated},
+		{[]grpc.DialOption{TokenCredentials("guess")}, codes.Unauthenticated},
+		{[]grpc.DialOption{TokenCredentials("secret")}, codes.OK},
+	} {
+		client := serve(t, []system.Service{sleeper("web")}, auth, test.opts...)
+
+		_, err := client.ListServices(context.Background(), &pb.ListServicesRequest{})
+		if status.Code(err) != test.want {
+			t.Errorf("list: %v, want %s", err, test.want)
+		}
+
+		stream, err := client.WatchEvents(context.Background(), &pb.WatchEventsRequest{})
+		if err == nil && test.want != codes.OK {
+			_, err = stream.Recv()
+		}
+		if status.Code(err) != test.want {
+			t.Errorf("watch: %v, want %s", err, test.want)
+		}
+	}
+}
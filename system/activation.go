@@ -0,0 +1,231 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// activations: ACTIVATION_ON_DEMAND starts the process on the first connection,
+// ACTIVATION_SOCKET starts it at once. Either passes the sockets the supervisor
+// listens on, they stay open while the process restarts
+const (
+	ACTIVATION_ON_DEMAND = "on-demand"
+	ACTIVATION_SOCKET    = "socket"
+)
+
+var ErrInvalidActivation = errors.New("invalid activation")
+
+// IDLE_CHECK_INTERVAL is the interval the connections of a service with an
+// IdleTimeout are counted at
+const IDLE_CHECK_INTERVAL = time.Second
+
+// activation holds the sockets of an on-demand service, the process is started once
+// a connection is pending on any of them and gets them passed as LISTEN_FDS.
+// lastActive is the Monotonic reading of the clock a connection was last seen at
+type activation struct {
+	listeners  []net.Listener
+	triggered  chan struct{}
+	watched    []*os.File
+	watchers   sync.WaitGroup
+	lastActive time.Duration
+}
+
+// ValidateActivation checks the activation, the sockets passed are the Ports
+func ValidateActivation(config ServiceConfig) error {
+	switch {
+	case config.Activation == "":
+		return nil
+	case config.Activation != ACTIVATION_ON_DEMAND && config.Activation != ACTIVATION_SOCKET:
+		return fmt.Errorf("%w: %q, expected %s or %s", ErrInvalidActivation, config.Activation, ACTIVATION_ON_DEMAND, ACTIVATION_SOCKET)
+	case len(config.Ports) == 0:
+		return fmt.Errorf("%w: %s activation has no ports to listen on", ErrInvalidActivation, config.Activation)
+	case config.IdleTimeout > 0 && config.Activation != ACTIVATION_ON_DEMAND:
+		return fmt.Errorf("%w: idleTimeout stops on-demand services only", ErrInvalidActivation)
+	case runtime.GOOS == "windows":
+		return fmt.Errorf("%w: %s activation", ErrUnsupportedPlatform, config.Activation)
+	}
+
+	return nil
+}
+
+func (s *Service) isOnDemand() bool {
+	return s.Activation == ACTIVATION_ON_DEMAND
+}
+
+// passesSockets reports whether the process gets the sockets of the supervisor
+func (s *Service) passesSockets() bool {
+	return s.isOnDemand() || s.Activation == ACTIVATION_SOCKET
+}
+
+// isListening reports whether the service waits for a connection to start
+func (s *Service) isListening() bool {
+	return s.activation != nil && s.activation.triggered != nil
+}
+
+func (s *Service) activationTriggered() <-chan struct{} {
+	if !s.isListening() {
+		return nil
+	}
+
+	return s.activation.triggered
+}
+
+// listen opens the service sockets unless they are open already
+func (s *Service) listen() error {
+	if s.activation != nil {
+		return nil
+	}
+
+	if err := s.checkPorts(); err != nil {
+		return err
+	}
+
+	a := new(activation)
+	for _, address := range s.Ports {
+		listener, err := net.Listen(listenNetwork(address), address)
+		if err != nil {
+			a.close()
+			return err
+		}
+
+		a.listeners = append(a.listeners, listener)
+	}
+
+	s.activation = a
+
+	return nil
+}
+
+// arm waits for a connection on the service sockets, without accepting it
+func (s *Service) arm() error {
+	if err := s.listen(); err != nil {
+		return err
+	}
+
+	// listeners can not be polled, duplicates of them are watched instead
+	a := s.activation
+	watched, err := a.files()
+	if err != nil {
+		return err
+	}
+
+	a.watched = watched
+	a.triggered = make(chan struct{}, 1)
+	for _, file := range a.watched {
+		a.watchers.Add(1)
+
+		go func(file *os.File, triggered chan<- struct{}) {
+			defer a.watchers.Done()
+
+			if waitPending(file) == nil {
+				select {
+				case triggered <- struct{}{}:
+				default:
+				}
+			}
+		}(file, a.triggered)
+	}
+
+	s.log().infof("waiting for a connection on %v", s.Ports)
+	s.setState(StateListening)
+
+	return nil
+}
+
+// deactivate closes the service sockets
+func (s *Service) deactivate() {
+	if s.activation == nil {
+		return
+	}
+
+	s.activation.close()
+	s.activation = nil
+}
+
+func (s *Service) handleActivation(out, err chan<- string) {
+	s.log().infof("activated by a connection")
+	s.startProcess(out, err)
+}
+
+// checkIdle stops the process of an on-demand service once it had no connections for IdleTimeout,
+// connections are not counted without the net tables of /proc
+func (s *Service) checkIdle() {
+	if s.activation == nil || !s.isOnDemand() || s.IdleTimeout <= 0 || !procCapabilities().Sockets {
+		return
+	}
+
+	connections := 0
+	for _, address := range s.Ports {
+		inodes, err := connectionInodes(address)
+		if err != nil {
+			s.log().warnf("unable to count connections on %s: %s", address, err)
+			return
+		}
+
+		connections += len(inodes)
+	}
+
+	if connections > 0 {
+		s.activation.lastActive = s.getClock().Monotonic()
+		return
+	}
+
+	if s.getClock().Monotonic()-s.activation.lastActive < s.IdleTimeout {
+		return
+	}
+
+	s.log().infof("idle for %s, stopping", s.IdleTimeout)
+	if err := s.stopRunning(StopReasonIdle); err != nil {
+		s.log().errorf("%s", err)
+	}
+}
+
+// disarm stops waiting for connections, the sockets are kept open
+func (a *activation) disarm() {
+	closeFiles(a.watched)
+	a.watchers.Wait()
+
+	a.watched = nil
+	a.triggered = nil
+}
+
+func (a *activation) close() {
+	a.disarm()
+
+	for _, listener := range a.listeners {
+		listener.Close()
+	}
+}
+
+// files duplicates the sockets to be passed to a process
+func (a *activation) files() ([]*os.File, error) {
+	var files []*os.File
+	for _, listener := range a.listeners {
+		filer, ok := listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			closeFiles(files)
+			return nil, fmt.Errorf("unable to pass %s listener", listener.Addr().Network())
+		}
+
+		file, err := filer.File()
+		if err != nil {
+			closeFiles(files)
+			return nil, err
+		}
+
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
+}
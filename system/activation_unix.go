@@ -0,0 +1,25 @@
+//go:build unix
+
+package system
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// waitPending blocks until a connection is pending on the listening socket or until
+// the file is closed, the connection stays in the backlog for the process to accept it
+func waitPending(file *os.File) error {
+	raw, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	// returning false waits for the socket to become readable, readiness may be
+	// left over from connections accepted by a previous process so it is polled
+	return raw.Read(func(fd uintptr) bool {
+		pending, err := unix.Poll([]unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}, 0)
+		return err == nil && pending > 0
+	})
+}
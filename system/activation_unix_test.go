@@ -0,0 +1,122 @@
+//go:build unix
+
+package system
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// the test binary run with ECHO_CHILD echoes the lines of connections accepted
+// on the socket passed to it
+const ECHO_CHILD = "SYSTEMGO_TEST_ECHO_CHILD"
+
+func TestEchoChild(t *testing.T) {
+	if os.Getenv(ECHO_CHILD) == "" || os.Getenv("LISTEN_FDS") != "1" {
+		t.Skip("run by the activation tests as the supervised process")
+	}
+
+	listener, err := net.FileListener(os.NewFile(3, "listener"))
+	if err != nil {
+		os.Exit(2)
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			os.Exit(2)
+		}
+
+		go func(conn net.Conn) {
+			defer conn.Close()
+
+			lines := bufio.NewScanner(conn)
+			for lines.Scan() {
+				conn.Write(append(lines.Bytes(), '\n'))
+			}
+		}(conn)
+	}
+}
+
+// echoed sends the line on a new connection and returns the connection once
+// the line came back
+func echoed(t *testing.T, address, line string) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := conn.Write([]byte(line + "\n")); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || reply != line+"\n" {
+		t.Fatalf("echo of %q: %q, %v", line, reply, err)
+	}
+
+	return conn
+}
+
+func TestOnDemandActivation(t *testing.T) {
+	config := ServiceConfig{
+		Name:        "echo",
+		Exec:        os.Args[0],
+		Params:      []string{"-test.run=^TestEchoChild$"},
+		Env:         []string{ECHO_CHILD + "=1"},
+		Activation:  ACTIVATION_ON_DEMAND,
+		Ports:       []string{freePort(t)},
+		IdleTimeout: 10 * time.Second,
+		StopTimeout: time.Second,
+	}
+	m, clock := runManager(t, config)
+
+	eventually(t, 5*time.Second, "the listening", func() bool { return status(t, m, config.Name).State == StateListening })
+	if pid := status(t, m, config.Name).PID; pid != 0 {
+		t.Fatalf("pid %d before any connection", pid)
+	}
+
+	// the line is sent before the process runs, the connection waits in the
+	// backlog of the socket held by the supervisor until the process accepts it
+	conn := echoed(t, config.Ports[0], "first")
+	eventually(t, 5*time.Second, "the first run", func() bool {
+		got := status(t, m, config.Name)
+		return got.State == StateRunning && got.Runs == 1
+	})
+
+	if !procCapabilities().Sockets {
+		conn.Close()
+		t.Skip("idle connections are counted in /proc")
+	}
+
+	// an open connection keeps the process running past the idle timeout
+	for i := 0; i < 3; i++ {
+		clock.Advance(config.IdleTimeout / 2)
+		time.Sleep(50 * time.Millisecond)
+	}
+	if state := status(t, m, config.Name).State; state != StateRunning {
+		t.Fatalf("state %s with a connection open", state)
+	}
+
+	conn.Close()
+	advanceUntil(t, clock, config.IdleTimeout, "the idle stop", func() bool {
+		return status(t, m, config.Name).State == StateListening
+	})
+
+	service, err := m.GetService(config.Name)
+	if err != nil {
+		t.Fatalf("service: %s", err)
+	}
+	if history := service.History(); len(history) != 1 || history[0].StopReason != StopReasonIdle {
+		t.Fatalf("history %+v, want a run stopped for idling", history)
+	}
+
+	echoed(t, config.Ports[0], "second").Close()
+	eventually(t, 5*time.Second, "the second run", func() bool { return status(t, m, config.Name).Runs == 2 })
+}
@@ -0,0 +1,8 @@
+package system
+
+import "os"
+
+// waitPending has no listening sockets to pass to a process on windows
+func waitPending(file *os.File) error {
+	return ErrUnsupportedPlatform
+}
@@ -0,0 +1,254 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrGroupChange = errors.New("templates and replicated services are not changed by Apply")
+
+// Apply changes the services to the configuration as planned by Plan: added
+// services start, removed ones stop and services with changed configuration are
+// replaced, or updated in place if only their live fields changed, like
+// restartPolicy or stopTimeout. Unchanged services keep running untouched. A replaced service keeps
+// its runs, history, incarnation, journal, backoff and start limit, so one waiting for its restart
+// delay still waits for it with the new configuration instead of starting at once.
+// It is launched like an added one unless it is disabled, one stopped by the
+// operator stays stopped and a scheduled one waits for its next run
+func (m *Manager) Apply(configs []ServiceConfig) (Plan, error) {
+	plan, err := m.Plan(configs)
+	if err != nil {
+		return plan, err
+	}
+
+	defaults := m.GetDefaults()
+	byName := make(map[string]ServiceConfig)
+	list := make([]ServiceConfig, 0, len(configs))
+	for _, config := range configs {
+		config = defaults.apply(config)
+		if err := validateConfig(config); err != nil {
+			return plan, fmt.Errorf("%s: %w", config.Name, err)
+		}
+
+		byName[config.Name] = config
+		list = append(list, config)
+	}
+
+	if err := validateDependencies(list); err != nil {
+		return plan, err
+	}
+
+	if err := validatePipes(list); err != nil {
+		return plan, err
+	}
+
+	if err := validateStandbys(list); err != nil {
+		return plan, err
+	}
+
+	if err := validateExternals(list); err != nil {
+		return plan, err
+	}
+
+	m.scaling.Lock()
+	defer m.scaling.Unlock()
+
+	m.mu.Lock()
+	for _, change := range plan.Changes {
+		if change.Action == PlanUnchanged {
+			continue
+		}
+
+		config, isNew := byName[change.Name]
+		if m.findGroup(change.Name) != nil || (isNew && (IsTemplate(config.Name) || config.Replicas > 0)) {
+			m.mu.Unlock()
+			return plan, fmt.Errorf("%s: %w", change.Name, ErrGroupChange)
+		}
+	}
+
+	// the loops of replaced services end before the new ones begin, holding a
+	// slot keeps the manager from finishing as if all services were done
+	if m.isRunning {
+		m.holdRun()
+		defer m.releaseRun()
+	}
+
+	var retired, removed []*Service
+	replaced := make(map[*Service]*Service)
+	updated := make(map[*Service]ServiceConfig)
+	for _, change := range plan.Changes {
+		switch change.Action {
+		case PlanAdded:
+			m.insert(NewService(byName[change.Name]))
+
+		case PlanRemoved:
+			service := m.find(change.Name)
+			m.remove(service)
+			m.pipes.forget(service.Name)
+			m.standbys.forget(service.Name)
+			retired = append(retired, service)
+			removed = append(removed, service)
+
+		case PlanRestart:
+			old := m.find(change.Name)
+			service := NewService(byName[change.Name])
+			m.replace(old, service)
+			replaced[service] = old
+			retired = append(retired, old)
+
+		case PlanUpdate:
+			updated[m.find(change.Name)] = byName[change.Name]
+		}
+	}
+
+	// states are read before the services are stopped, stopping them ends a
+	// restart delay and holds them
+	kept := make(map[*Service]State)
+	for _, old := range replaced {
+		switch state := old.Status().State; {
+		case old.intentOf() == intentHeld:
+			kept[old] = StateStopped
+		case state == StateRestarting || state == StatePendingRestart:
+			kept[old] = StateRestarting
+		}
+	}
+	m.mu.Unlock()
+
+	for _, service := range retired {
+		m.retire(service, 0)
+	}
+	for _, service := range removed {
+		service.unlockState()
+	}
+
+	for service, config := range updated {
+		service.update(config)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for service, old := range replaced {
+		service.inherit(old, kept[old])
+		if !m.isRunning || m.isShuttingDown() {
+			continue
+		}
+
+		m.prepare(service)
+		if service.Disabled {
+			continue
+		}
+
+		// a stopped service is held by its loop until started
+		intent := intentStartNow
+		switch {
+		case kept[old] == StateStopped:
+			intent = intentHeld
+		case kept[old] == StateRestarting || service.isTimed():
+			intent = intentSupervise
+		}
+		m.launch(service, intent)
+	}
+
+	for _, change := range plan.Changes {
+		if change.Action != PlanUnchanged {
+			managerLog.infof("%s: %s", change.Name, change.Action)
+		}
+	}
+
+	return plan, nil
+}
+
+// find returns the service of the name, must be called holding m.mu
+func (m *Manager) find(name string) *Service {
+	for _, service := range m.services {
+		if service.Name == name {
+			return service
+		}
+	}
+
+	return nil
+}
+
+// replace puts the service in place of old without launching it, must be called holding m.mu
+func (m *Manager) replace(old, service *Service) {
+	for i := range m.services {
+		if m.services[i] == old {
+			m.services[i] = service
+		}
+	}
+
+	service.outputBudget = m.outputBudget
+	service.scheduler = m.scheduler
+	service.standbys = &m.standbys
+}
+
+// config returns the configuration of the service, read by others than its
+// supervision loop, which may update it
+func (s *Service) config() ServiceConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.ServiceConfig
+}
+
+// update applies the configuration by the supervision loop, which reads it
+// without locking, or at once if no loop runs
+func (s *Service) update(config ServiceConfig) {
+	if err := s.sendRequest(request{command: commandUpdate, config: &config}); err != nil {
+		s.setConfig(config)
+	}
+}
+
+func (s *Service) setConfig(config ServiceConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ServiceConfig = config
+}
+
+// inherit takes over the runs, history, incarnation, journal and planned restart,
+// with its backoff and start limit, of the service it replaces once the
+// supervision loop of old has ended. The state is kept unless StateNew
+func (s *Service) inherit(old *Service, state State) {
+	now := old.getClock().Monotonic()
+
+	old.mu.RLock()
+	history := make([]ProcessRecord, len(old.history))
+	copy(history, old.history)
+	lastExit, lastExitRestored := old.lastExit, old.lastExitRestored
+	runs, incarnation, forcedKills, store, stateFile, stoppedAt := old.runs, old.incarnation, old.forcedKills, old.store, old.stateFile, old.stoppedAt
+	durations := old.stateTime.read(old.state, now)
+	startLatency, readyLatency := old.startLatency.clone(), old.readyLatency.clone()
+	restartDelay, failures, restartRefused := old.restartDelay, old.failures, old.restartRefused
+	restartTimes := append([]time.Duration(nil), old.restartTimes...)
+	old.mu.RUnlock()
+
+	// the lock of the state file goes with it
+	old.mu.Lock()
+	stateLock, stateLockErr := old.stateLock, old.stateLockErr
+	old.stateLock = nil
+	old.mu.Unlock()
+
+	s.mu.Lock()
+	s.history = history
+	s.lastExit, s.lastExitRestored = lastExit, lastExitRestored
+	s.runs = runs
+	s.incarnation = incarnation
+	s.forcedKills = forcedKills
+	s.store = store
+	s.stateFile = stateFile
+	s.stateLock, s.stateLockErr = stateLock, stateLockErr
+	s.stoppedAt = stoppedAt
+	s.stateTime = stateClock{durations: durations}
+	s.startLatency, s.readyLatency = startLatency, readyLatency
+	s.restartDelay, s.failures, s.restartTimes, s.restartRefused = restartDelay, failures, restartTimes, restartRefused
+	s.restartsKept = true
+	if state != StateNew {
+		s.state = state
+	}
+	s.mu.Unlock()
+
+	s.journal.entries = old.journal.last(0)
+}
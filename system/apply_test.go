@@ -0,0 +1,192 @@
+package system
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// runManager runs the services on a fake clock until the test ends
+func runManager(t *testing.T, configs ...ServiceConfig) (*Manager, *FakeClock) {
+	t.Helper()
+
+	clock := NewFakeClock(time.Now())
+
+	return runManagerOn(t, clock, configs...), clock
+}
+
+// runManagerOn runs the services on the clock until the test ends
+func runManagerOn(t *testing.T, clock Clock, configs ...ServiceConfig) *Manager {
+	t.Helper()
+
+	services := make([]Service, len(configs))
+	for i, config := range configs {
+		services[i] = Service{ServiceConfig: config}
+	}
+
+	m, err := NewServiceManager(services)
+	if err != nil {
+		t.Fatalf("manager: %s", err)
+	}
+
+	m.SetClock(clock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	eventually(t, 5*time.Second, "the manager to run", func() bool {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		return m.isRunning
+	})
+
+	return m
+}
+
+// status reads the status of the named service, the one replacing it after Apply
+func status(t *testing.T, m *Manager, name string) ServiceStatus {
+	t.Helper()
+
+	status, err := m.GetStatus(name)
+	if err != nil {
+		t.Fatalf("status: %s", err)
+	}
+
+	return status
+}
+
+// reload applies the configurations, changed by an environment variable so
+// that the services are replaced
+func reload(t *testing.T, m *Manager, configs ...ServiceConfig) {
+	t.Helper()
+
+	for i := range configs {
+		configs[i].Env = append(configs[i].Env, "RELOADED=1")
+	}
+
+	plan, err := m.Apply(configs)
+	if err != nil {
+		t.Fatalf("apply: %s", err)
+	}
+
+	for _, change := range plan.Changes {
+		if change.Action != PlanRestart {
+			t.Fatalf("%s: %s, want %s", change.Name, change.Action, PlanRestart)
+		}
+	}
+}
+
+// stays fails the test if the status of the service changes within a while
+func stays(t *testing.T, m *Manager, name string, want ServiceStatus) {
+	t.Helper()
+
+	time.Sleep(100 * time.Millisecond)
+	if got := status(t, m, name); got.State != want.State || got.Runs != want.Runs {
+		t.Fatalf("%s after the reload with %d runs, want %s with %d", got.State, got.Runs, want.State, want.Runs)
+	}
+}
+
+func sleeper(name string) ServiceConfig {
+	return ServiceConfig{Name: name, Exec: "sleep", Params: []string{"30"}, StopTimeout: time.Second}
+}
+
+func TestReloadKeepsAStoppedServiceStopped(t *testing.T) {
+	config := sleeper("stopped")
+	m, _ := runManager(t, config)
+	eventually(t, 5*time.Second, "the first run", func() bool { return status(t, m, config.Name).State == StateRunning })
+
+	if err := m.Stop(config.Name); err != nil {
+		t.Fatalf("stop: %s", err)
+	}
+	eventually(t, 5*time.Second, "the stop", func() bool { return status(t, m, config.Name).State == StateStopped })
+
+	reload(t, m, config)
+	stays(t, m, config.Name, ServiceStatus{State: StateStopped, Runs: 1})
+
+	if err := m.Start(config.Name); err != nil {
+		t.Fatalf("start: %s", err)
+	}
+	eventually(t, 5*time.Second, "the run after the start", func() bool { return status(t, m, config.Name).Runs == 2 })
+}
+
+func TestReloadDoesNotLaunchADisabledService(t *testing.T) {
+	config := sleeper("disabled")
+	m, _ := runManager(t, config)
+	eventually(t, 5*time.Second, "the first run", func() bool { return status(t, m, config.Name).State == StateRunning })
+
+	config.Disabled = true
+	reload(t, m, config)
+	stays(t, m, config.Name, ServiceStatus{State: StateNew, Runs: 1})
+}
+
+func TestReloadLetsAScheduledServiceWait(t *testing.T) {
+	config := ServiceConfig{Name: "hourly", Exec: "true", Schedule: "@every 1h"}
+	m, clock := runManager(t, config)
+	eventually(t, 5*time.Second, "the schedule", func() bool { return status(t, m, config.Name).State == StateScheduled })
+
+	reload(t, m, config)
+	stays(t, m, config.Name, ServiceStatus{State: StateScheduled, Runs: 0})
+
+	advanceUntil(t, clock, time.Hour, "the scheduled run", func() bool { return status(t, m, config.Name).Runs == 1 })
+}
+
+func TestReloadOfTheOnlyServiceKeepsTheManager(t *testing.T) {
+	config := sleeper("only")
+	m, _ := runManager(t, config)
+	eventually(t, 5*time.Second, "the first run", func() bool { return status(t, m, config.Name).State == StateRunning })
+
+	reload(t, m, config)
+	eventually(t, 5*time.Second, "the run after the reload", func() bool {
+		status := status(t, m, config.Name)
+		return status.Runs == 2 && status.State == StateRunning
+	})
+}
+
+func failing(name string) ServiceConfig {
+	return ServiceConfig{
+		Name:               name,
+		Exec:               "false",
+		RestartPolicy:      RESTART_ALWAYS,
+		RestartDelay:       10 * time.Second,
+		RestartBackoff:     2,
+		StartLimitBurst:    3,
+		StartLimitInterval: time.Hour,
+	}
+}
+
+func TestReloadDuringBackoff(t *testing.T) {
+	config := failing("crasher")
+	m, clock := runManager(t, config)
+
+	restartingAfter := func(runs int) func() bool {
+		return func() bool {
+			status := status(t, m, config.Name)
+			return status.Runs == runs && status.State == StateRestarting
+		}
+	}
+	eventually(t, 5*time.Second, "the first restart delay", restartingAfter(1))
+
+	reload(t, m, config)
+	stays(t, m, config.Name, ServiceStatus{State: StateRestarting, Runs: 1})
+	advanceUntil(t, clock, 10*time.Second, "the second run", restartingAfter(2))
+
+	// the second failure in a row doubles the delay to 20s
+	clock.Advance(10 * time.Second)
+	stays(t, m, config.Name, ServiceStatus{State: StateRestarting, Runs: 2})
+	advanceUntil(t, clock, 10*time.Second, "the third run", restartingAfter(3))
+
+	// the restarts before the reload count for the start limit of three
+	advanceUntil(t, clock, 40*time.Second, "the start limit", func() bool {
+		status := status(t, m, config.Name)
+		return status.Runs == 4 && status.State == StateFailed
+	})
+}
@@ -0,0 +1,139 @@
+package system
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// outcomes of audited operations, a record without its outcome is of an operation
+// the supervisor did not survive
+const (
+	AUDIT_STARTED = "started"
+	AUDIT_OK      = "ok"
+	AUDIT_FAILED  = "failed"
+)
+
+// AUDIT_LOCAL is the identity of operations not requested through an authenticated API
+const AUDIT_LOCAL = "local"
+
+// Requester is who asked for an operation and where from, like the peer address
+type Requester struct {
+	Identity string
+	Source   string
+}
+
+// AuditRecord is a line of the audit log, an operation is recorded as started
+// before it runs and again with its outcome, both with the same ID
+type AuditRecord struct {
+	ID        int64     `json:"id"`
+	Time      time.Time `json:"time"`
+	Operation string    `json:"operation"`
+	Target    string    `json:"target"`
+	Identity  string    `json:"identity"`
+	Source    string    `json:"source,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// auditLog appends records as JSON lines, files are synced after every record
+type auditLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	nextID int64
+}
+
+// OpenAuditLog opens the file for appending records, creating it if needed
+func OpenAuditLog(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+}
+
+// SetAuditLog records the audited operations to w, it must be called before Run
+func (m *Manager) SetAuditLog(w io.Writer) {
+	m.audit.mu.Lock()
+	defer m.audit.mu.Unlock()
+
+	m.audit.w = w
+	m.audit.nextID = time.Now().UnixNano()
+}
+
+// Audit runs the operation on the target, recording it before it runs and its
+// outcome after, the operation runs even if the record can not be written
+func (m *Manager) Audit(requester Requester, operation, target string, run func() error) error {
+	if requester.Identity == "" {
+		requester.Identity = AUDIT_LOCAL
+	}
+
+	record := AuditRecord{Operation: operation, Target: target, Identity: requester.Identity, Source: requester.Source, Outcome: AUDIT_STARTED}
+	record = m.audit.write(record)
+
+	err := run()
+
+	record.Outcome = AUDIT_OK
+	if err != nil {
+		record.Outcome = AUDIT_FAILED
+		record.Error = err.Error()
+	}
+	m.audit.write(record)
+
+	return err
+}
+
+func (a *auditLog) write(record AuditRecord) AuditRecord {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.w == nil {
+		return record
+	}
+
+	if record.ID == 0 {
+		a.nextID += 1
+		record.ID = a.nextID
+	}
+	record.Time = time.Now()
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		managerLog.warnf("failed to audit %s %s: %s", record.Operation, record.Target, err)
+		return record
+	}
+
+	if _, err := a.w.Write(append(data, '\n')); err != nil {
+		managerLog.warnf("failed to audit %s %s: %s", record.Operation, record.Target, err)
+		return record
+	}
+
+	if file, ok := a.w.(*os.File); ok {
+		if err := file.Sync(); err != nil {
+			managerLog.warnf("failed to sync the audit log: %s", err)
+		}
+	}
+
+	return record
+}
+
+// ReadAudit returns the records of the log written since then, all of them if
+// since is zero, lines that are not records are skipped
+func ReadAudit(r io.Reader, since time.Time) ([]AuditRecord, error) {
+	var records []AuditRecord
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var record AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil || record.Operation == "" {
+			continue
+		}
+
+		if record.Time.Before(since) {
+			continue
+		}
+
+		records = append(records, record)
+	}
+
+	return records, scanner.Err()
+}
@@ -0,0 +1,53 @@
+package system
+
+import "sync"
+
+// StartAll starts the services concurrently, the result has an entry for every
+// name, nil for the ones started
+func (m *Manager) StartAll(names ...string) map[string]error {
+	return m.batch(names, m.Start)
+}
+
+// StopAll stops the services concurrently, the result has an entry for every
+// name, nil for the ones stopped
+func (m *Manager) StopAll(names ...string) map[string]error {
+	return m.batch(names, m.Stop)
+}
+
+// RestartAll restarts the services concurrently, the result has an entry for
+// every name, nil for the ones restarted
+func (m *Manager) RestartAll(names ...string) map[string]error {
+	return m.batch(names, m.Restart)
+}
+
+// batch runs fn for every name at once, a failure does not hold back the rest
+func (m *Manager) batch(names []string, fn func(name string) error) map[string]error {
+	var unique []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+
+	results := make(map[string]error, len(unique))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, name := range unique {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+
+			err := fn(name)
+
+			mu.Lock()
+			results[name] = err
+			mu.Unlock()
+		}(name)
+	}
+	wg.Wait()
+
+	return results
+}
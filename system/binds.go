@@ -0,0 +1,305 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BIND_WINDOW is the time a bound dependency has to stay up before the dependents
+// it stopped start again, a flapping dependency stops them once and starts them
+// once it settles
+const BIND_WINDOW = time.Second
+
+var ErrDependencyCycle = errors.New("dependency cycle")
+
+// binder tracks the services stopped because a dependency they are bound to went down
+type binder struct {
+	mu     sync.Mutex
+	held   map[string]bool
+	starts map[string]Timer
+}
+
+// forget drops the service from the held ones, an operator start or stop wins
+// over the dependencies
+func (b *binder) forget(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.held, name)
+	if timer := b.starts[name]; timer != nil {
+		timer.Stop()
+		delete(b.starts, name)
+	}
+}
+
+// validateDependencies refuses BindsTo, PartOf and After forming a cycle, names
+// of no service are left alone as the service may be added later
+func validateDependencies(configs []ServiceConfig) error {
+	edges := make(map[string][]string)
+	for _, config := range configs {
+		edges[config.Name] = config.orderedAfter()
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	marks := make(map[string]int)
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch marks[name] {
+		case visiting:
+			return fmt.Errorf("%w: %v", ErrDependencyCycle, append(path, name))
+		case visited:
+			return nil
+		}
+
+		marks[name] = visiting
+		for _, dependency := range edges[name] {
+			if err := visit(dependency, append(path, name)); err != nil {
+				return err
+			}
+		}
+		marks[name] = visited
+
+		return nil
+	}
+
+	for _, config := range configs {
+		if err := visit(config.Name, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isUp reports whether the service can be depended on: ready, or running if it
+// has no readiness check, or listening for connections. A passive standby is not
+func (s *Service) isUp() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.role() == ROLE_PASSIVE {
+		return false
+	}
+
+	switch s.state {
+	case StateReady, StateListening:
+		return true
+	case StateRunning:
+		return !s.hasReadinessCheck()
+	}
+
+	return false
+}
+
+// isDown reports whether the service stops or stopped, for now or for good
+func (s *Service) isDown() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	switch s.state {
+	case StateStopping, StateFinished, StateRestarting, StateStopped, StateFailed, StateStopFailed, StateNotReady, StatePendingRestart:
+		return true
+	}
+
+	return false
+}
+
+// propagate passes a state change of the service to the services bound to it
+// or to its group
+func (m *Manager) propagate(name string) {
+	m.mu.Lock()
+	group := ""
+	if service := m.find(name); service != nil {
+		group = service.group
+	}
+
+	var dependents []*Service
+	for _, service := range m.services {
+		if contains(service.BindsTo, name) || (group != "" && contains(service.BindsTo, group)) {
+			dependents = append(dependents, service)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, dependent := range dependents {
+		m.bind(dependent)
+	}
+}
+
+// bind stops the service as soon as one of its BindsTo dependencies is down, and
+// starts it again once they all stayed up for BIND_WINDOW
+func (m *Manager) bind(dependent *Service) {
+	up, down := m.dependenciesUp(dependent)
+
+	m.binds.mu.Lock()
+	defer m.binds.mu.Unlock()
+
+	if m.binds.held == nil {
+		m.binds.held = make(map[string]bool)
+		m.binds.starts = make(map[string]Timer)
+	}
+
+	switch {
+	case down != "":
+		if timer := m.binds.starts[dependent.Name]; timer != nil {
+			timer.Stop()
+			delete(m.binds.starts, dependent.Name)
+		}
+
+		if m.binds.held[dependent.Name] {
+			return
+		}
+
+		// a service stopped already, by an operator while the propagation of an
+		// earlier transition was on its way, is not started with the dependency
+		if dependent.isSupervised() && dependent.getState() == StateStopped {
+			return
+		}
+
+		m.binds.held[dependent.Name] = true
+		if !dependent.isSupervised() {
+			managerLog.with(FIELD_SERVICE, dependent.Name).warnf("%s is down, not starting", down)
+			return
+		}
+
+		managerLog.with(FIELD_SERVICE, dependent.Name).warnf("%s is down, stopping", down)
+		go func() {
+			if err := dependent.send(commandStop); err != nil && err != ErrNotRunning {
+				managerLog.with(FIELD_SERVICE, dependent.Name).errorf("%s", err)
+			}
+		}()
+
+	case up && m.binds.held[dependent.Name]:
+		if timer := m.binds.starts[dependent.Name]; timer != nil {
+			timer.Reset(BIND_WINDOW)
+			return
+		}
+
+		name := dependent.Name
+		m.binds.starts[name] = m.Clock().AfterFunc(BIND_WINDOW, func() {
+			m.release(name)
+		})
+	}
+}
+
+// holdIfDown holds the service instead of starting it while one of its BindsTo
+// dependencies is down, it starts once they are all up
+func (m *Manager) holdIfDown(service *Service) bool {
+	if _, down := m.dependenciesUp(service); down == "" {
+		return false
+	}
+
+	m.bind(service)
+
+	m.binds.mu.Lock()
+	defer m.binds.mu.Unlock()
+
+	return m.binds.held[service.Name]
+}
+
+// release starts a held service if its dependencies are still up, the service
+// is looked up again as Apply may have replaced it
+func (m *Manager) release(name string) {
+	m.mu.Lock()
+	dependent := m.find(name)
+	m.mu.Unlock()
+
+	if dependent == nil {
+		m.binds.forget(name)
+		return
+	}
+
+	if up, _ := m.dependenciesUp(dependent); !up {
+		return
+	}
+
+	m.binds.mu.Lock()
+	held := m.binds.held[dependent.Name]
+	delete(m.binds.held, dependent.Name)
+	delete(m.binds.starts, dependent.Name)
+	m.binds.mu.Unlock()
+
+	if !held {
+		return
+	}
+
+	managerLog.with(FIELD_SERVICE, dependent.Name).infof("dependencies are up, starting")
+	if err := m.start(dependent); err != nil {
+		managerLog.with(FIELD_SERVICE, dependent.Name).errorf("%s", err)
+	}
+}
+
+// dependenciesUp reports whether all BindsTo dependencies of the service are up,
+// or the first one that is down, a group is up once all of its members are
+func (m *Manager) dependenciesUp(service *Service) (bool, string) {
+	up := true
+	for _, name := range service.BindsTo {
+		dependencies, err := m.GetServices(name)
+		if err != nil {
+			continue
+		}
+
+		for _, dependency := range dependencies {
+			if dependency.isDown() {
+				return false, dependency.Name
+			}
+
+			up = up && dependency.isUp()
+		}
+	}
+
+	return up, ""
+}
+
+// partOf returns the services PartOf the service or group, directly or through
+// others, each one once, in the order they are reached
+func (m *Manager) partOf(name string) []*Service {
+	roots, err := m.GetServices(name)
+	if err != nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seen := make(map[*Service]bool)
+	queue := make([]*Service, 0, len(roots))
+	for _, root := range roots {
+		seen[root] = true
+		queue = append(queue, root)
+	}
+
+	var parts []*Service
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+
+		for _, service := range m.services {
+			if seen[service] || !(contains(service.PartOf, parent.Name) || (parent.group != "" && contains(service.PartOf, parent.group))) {
+				continue
+			}
+
+			seen[service] = true
+			parts = append(parts, service)
+			queue = append(queue, service)
+		}
+	}
+
+	return parts
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
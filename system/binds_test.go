@@ -0,0 +1,224 @@
+package system
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// bound is a sleeping task stopped while one of deps is down
+func bound(name string, deps ...string) ServiceConfig {
+	config := sleeper(name)
+	config.BindsTo = deps
+
+	return config
+}
+
+// killed kills the process of the service the way a crash would
+func killed(t *testing.T, m *Manager, name string) {
+	t.Helper()
+
+	pid := status(t, m, name).PID
+	process, err := os.FindProcess(pid)
+	if err != nil || pid == 0 {
+		t.Fatalf("process of %s: %v", name, err)
+	}
+	if err := process.Kill(); err != nil {
+		t.Fatalf("kill %s: %s", name, err)
+	}
+}
+
+// running waits for each of the services to run
+func running(t *testing.T, m *Manager, names ...string) {
+	t.Helper()
+
+	for _, name := range names {
+		eventually(t, 5*time.Second, name+" running", func() bool { return status(t, m, name).State == StateRunning })
+	}
+}
+
+// armed waits for the start of the held service to be timed, advancing the
+// clock earlier would not bring it closer
+func armed(t *testing.T, m *Manager, name string) {
+	t.Helper()
+
+	eventually(t, 5*time.Second, "the start of "+name+" timed", func() bool {
+		m.binds.mu.Lock()
+		defer m.binds.mu.Unlock()
+
+		return m.binds.starts[name] != nil
+	})
+}
+
+// windowUntil moves the clock by a tenth of BIND_WINDOW at a time until cond
+// holds, a start timed after a move is not missed
+func windowUntil(t *testing.T, clock *FakeClock, what string, cond func() bool) {
+	t.Helper()
+
+	eventually(t, 5*time.Second, what, func() bool {
+		if cond() {
+			return true
+		}
+		clock.Advance(BIND_WINDOW / 10)
+
+		return false
+	})
+}
+
+// runsOf returns the runs of each service
+func runsOf(t *testing.T, m *Manager, names ...string) []int {
+	runs := make([]int, len(names))
+	for i, name := range names {
+		runs[i] = status(t, m, name).Runs
+	}
+
+	return runs
+}
+
+func TestBindsToChain(t *testing.T) {
+	m, clock := runManager(t, sleeper("db"), bound("app", "db"), bound("web", "app"))
+	running(t, m, "db", "app", "web")
+
+	// a crash of the root stops the whole chain
+	killed(t, m, "db")
+	for _, name := range []string{"app", "web"} {
+		eventually(t, 5*time.Second, name+" stopped", func() bool { return status(t, m, name).State == StateStopped })
+	}
+	if state := status(t, m, "db").State; state != StateFailed {
+		t.Fatalf("db %s, want failed", state)
+	}
+
+	// the chain starts in order once each dependency stayed up for the window
+	if err := m.Start("db"); err != nil {
+		t.Fatalf("start: %s", err)
+	}
+	running(t, m, "db")
+	armed(t, m, "app")
+	clock.Advance(BIND_WINDOW / 2)
+	if state := status(t, m, "app").State; state != StateStopped {
+		t.Fatalf("app %s within the window of db", state)
+	}
+
+	windowUntil(t, clock, "app started", func() bool { return status(t, m, "app").State == StateRunning })
+	armed(t, m, "web")
+	clock.Advance(BIND_WINDOW / 2)
+	if state := status(t, m, "web").State; state != StateStopped {
+		t.Fatalf("web %s within the window of app", state)
+	}
+	windowUntil(t, clock, "web started", func() bool { return status(t, m, "web").State == StateRunning })
+
+	if runs := runsOf(t, m, "db", "app", "web"); runs[0] != 2 || runs[1] != 2 || runs[2] != 2 {
+		t.Fatalf("runs %v, want one restart of each", runs)
+	}
+}
+
+func TestBindsToDiamond(t *testing.T) {
+	m, clock := runManager(t, sleeper("db"), bound("left", "db"), bound("right", "db"), bound("top", "left", "right"))
+	running(t, m, "db", "left", "right", "top")
+
+	if err := m.Stop("db"); err != nil {
+		t.Fatalf("stop: %s", err)
+	}
+	for _, name := range []string{"left", "right", "top"} {
+		eventually(t, 5*time.Second, name+" stopped", func() bool { return status(t, m, name).State == StateStopped })
+	}
+
+	if err := m.Start("db"); err != nil {
+		t.Fatalf("start: %s", err)
+	}
+	windowUntil(t, clock, "both sides started", func() bool {
+		return status(t, m, "left").State == StateRunning && status(t, m, "right").State == StateRunning
+	})
+
+	// top is held until both of its dependencies are up, and started once
+	windowUntil(t, clock, "top started", func() bool { return status(t, m, "top").State == StateRunning })
+	clock.Advance(10 * BIND_WINDOW)
+	stays(t, m, "top", ServiceStatus{State: StateRunning, Runs: 2})
+
+	if runs := runsOf(t, m, "left", "right", "top"); runs[0] != 2 || runs[1] != 2 || runs[2] != 2 {
+		t.Fatalf("runs %v, want one restart of each", runs)
+	}
+}
+
+func TestBindsToFlappingRoot(t *testing.T) {
+	db := sleeper("db")
+	db.RestartPolicy = RESTART_ALWAYS
+	db.RestartDelay = BIND_WINDOW / 10
+	m, clock := runManager(t, db, bound("app", "db"), bound("web", "app"))
+	running(t, m, "db", "app", "web")
+
+	// db crashes over and over within the window, app is stopped once and not
+	// started in between
+	for i := 0; i < 5; i++ {
+		killed(t, m, "db")
+		advanceUntil(t, clock, db.RestartDelay, "db restarted", func() bool {
+			return status(t, m, "db").State == StateRunning && status(t, m, "db").Runs == i+2
+		})
+
+		for _, name := range []string{"app", "web"} {
+			if state := status(t, m, name).State; state == StateRunning {
+				t.Fatalf("crash %d: %s running while db flaps", i, name)
+			}
+		}
+	}
+	if runs := runsOf(t, m, "app", "web"); runs[0] != 1 || runs[1] != 1 {
+		t.Fatalf("runs %v while db flaps, want no start", runs)
+	}
+
+	windowUntil(t, clock, "app started", func() bool { return status(t, m, "app").State == StateRunning })
+	windowUntil(t, clock, "web started", func() bool { return status(t, m, "web").State == StateRunning })
+	clock.Advance(10 * BIND_WINDOW)
+	stays(t, m, "app", ServiceStatus{State: StateRunning, Runs: 2})
+	stays(t, m, "web", ServiceStatus{State: StateRunning, Runs: 2})
+}
+
+func TestBindsToOperatorWins(t *testing.T) {
+	m, clock := runManager(t, sleeper("db"), bound("app", "db"))
+	running(t, m, "db", "app")
+
+	if err := m.Stop("db"); err != nil {
+		t.Fatalf("stop: %s", err)
+	}
+	eventually(t, 5*time.Second, "app stopped", func() bool { return status(t, m, "app").State == StateStopped })
+
+	// app stopped by the operator meanwhile stays stopped when db comes back
+	if err := m.Stop("app"); err != nil {
+		t.Fatalf("stop: %s", err)
+	}
+	if err := m.Start("db"); err != nil {
+		t.Fatalf("start: %s", err)
+	}
+	running(t, m, "db")
+	clock.Advance(10 * BIND_WINDOW)
+	stays(t, m, "app", ServiceStatus{State: StateStopped, Runs: 1})
+}
+
+func TestPartOfRestart(t *testing.T) {
+	child := sleeper("child")
+	child.PartOf = []string{"parent"}
+	grandchild := sleeper("grandchild")
+	grandchild.PartOf = []string{"child"}
+	m, _ := runManager(t, sleeper("parent"), child, grandchild, sleeper("other"))
+	running(t, m, "parent", "child", "grandchild", "other")
+
+	if err := m.Restart("parent"); err != nil {
+		t.Fatalf("restart: %s", err)
+	}
+	eventually(t, 5*time.Second, "the group restarted", func() bool {
+		runs := runsOf(t, m, "parent", "child", "grandchild")
+		return runs[0] == 2 && runs[1] == 2 && runs[2] == 2
+	})
+	running(t, m, "parent", "child", "grandchild")
+
+	// a restart of a part is not passed up
+	if err := m.Restart("child"); err != nil {
+		t.Fatalf("restart: %s", err)
+	}
+	eventually(t, 5*time.Second, "the part restarted", func() bool {
+		runs := runsOf(t, m, "child", "grandchild")
+		return runs[0] == 3 && runs[1] == 3
+	})
+	if runs := runsOf(t, m, "parent", "other"); runs[0] != 2 || runs[1] != 1 {
+		t.Fatalf("runs %v of parent and other, want 2 and 1", runs)
+	}
+}
@@ -0,0 +1,355 @@
+package system
+
+import "sync"
+
+// OUTPUT_BUDGET is the default limit of output bytes buffered by all services of a manager
+const OUTPUT_BUDGET = 16 << 20
+
+// OUTPUT_LINE_OVERHEAD is counted for every buffered line on top of its text
+const OUTPUT_LINE_OVERHEAD = 64
+
+// OutputUsage is the output buffered by a service: its lines waiting to be
+// printed or followed, its recent lines and the stderr tails of its runs
+type OutputUsage struct {
+	BufferedBytes int   `json:"bufferedBytes"`
+	DroppedLines  int64 `json:"droppedLines"`
+}
+
+// budgeted is a buffer of output counted against the budget
+type budgeted interface {
+	// trim drops the oldest line of the buffer, it reports whether there was
+	// one. It is called without the budget locked
+	trim() bool
+}
+
+// budgetShare is what a buffer counts against the budget, to the usage of its service
+type budgetShare struct {
+	usage *OutputUsage
+	bytes int
+}
+
+// outputBudget limits the bytes of output buffered by all services, once it is
+// exceeded the oldest lines of the largest buffer of the service buffering the
+// most are dropped
+type outputBudget struct {
+	mu     sync.Mutex
+	limit  int
+	used   int
+	shares map[budgeted]*budgetShare
+}
+
+func newOutputBudget(limit int) *outputBudget {
+	budget := new(outputBudget)
+	budget.limit = limit
+	budget.shares = make(map[budgeted]*budgetShare)
+
+	return budget
+}
+
+func (b *outputBudget) setLimit(limit int) {
+	b.mu.Lock()
+	b.limit = limit
+	b.mu.Unlock()
+
+	b.keep()
+}
+
+// usage returns a copy of usage, which is guarded by the budget
+func (b *outputBudget) usage(usage *OutputUsage) OutputUsage {
+	if b == nil {
+		return *usage
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return *usage
+}
+
+// newQueue returns a queue of a service, maxLines limits its lines if positive
+func (b *outputBudget) newQueue(usage *OutputUsage, maxLines int) *lineQueue {
+	queue := new(lineQueue)
+	queue.budget = b
+	queue.maxLines = maxLines
+	queue.ready = make(chan struct{}, 1)
+
+	b.join(queue, usage)
+
+	return queue
+}
+
+// join counts the buffer against the budget for usage
+func (b *outputBudget) join(buffer budgeted, usage *OutputUsage) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.shares[buffer] = &budgetShare{usage: usage}
+}
+
+// leave stops counting the buffer, what it holds is no longer counted
+func (b *outputBudget) leave(buffer budgeted) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.count(buffer, -b.bytes(buffer))
+	delete(b.shares, buffer)
+}
+
+// charge counts the bytes the buffer grew by, negative ones it shrank by
+func (b *outputBudget) charge(buffer budgeted, bytes int) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.count(buffer, bytes)
+}
+
+// dropped counts a line of bytes dropped by the buffer
+func (b *outputBudget) dropped(buffer budgeted, bytes int) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.drop(buffer, bytes)
+}
+
+// count must be called holding b.mu, buffers that left are not counted
+func (b *outputBudget) count(buffer budgeted, bytes int) {
+	share := b.shares[buffer]
+	if share == nil {
+		return
+	}
+
+	share.bytes += bytes
+	share.usage.BufferedBytes += bytes
+	b.used += bytes
+}
+
+// drop must be called holding b.mu
+func (b *outputBudget) drop(buffer budgeted, bytes int) {
+	if share := b.shares[buffer]; share != nil {
+		b.count(buffer, -bytes)
+		share.usage.DroppedLines += 1
+	}
+}
+
+// bytes must be called holding b.mu
+func (b *outputBudget) bytes(buffer budgeted) int {
+	if share := b.shares[buffer]; share != nil {
+		return share.bytes
+	}
+
+	return 0
+}
+
+// keep trims buffers until the budget is kept, the caller holds no lock of a
+// buffer. Nothing waits on the consumers of the lines
+func (b *outputBudget) keep() {
+	if b == nil {
+		return
+	}
+
+	for {
+		b.mu.Lock()
+		var victim budgeted
+		if b.used > b.limit {
+			victim = b.largest()
+		}
+		b.mu.Unlock()
+
+		if victim == nil || !victim.trim() {
+			return
+		}
+	}
+}
+
+// largest returns the largest buffer of the service buffering the most, must be called holding b.mu
+func (b *outputBudget) largest() budgeted {
+	var victim budgeted
+	var most *budgetShare
+	for buffer, share := range b.shares {
+		switch {
+		case share.bytes == 0:
+			continue
+		case most == nil:
+		case share.usage.BufferedBytes > most.usage.BufferedBytes:
+		case share.usage == most.usage && share.bytes > most.bytes:
+		default:
+			continue
+		}
+
+		victim, most = buffer, share
+	}
+
+	return victim
+}
+
+// lineQueue buffers lines for a single consumer, pushing never blocks
+type lineQueue struct {
+	budget   *outputBudget
+	maxLines int
+
+	// guarded by budget.mu
+	lines  []LogLine
+	closed bool
+
+	ready chan struct{}
+}
+
+func lineSize(line LogLine) int {
+	return len(line.Text) + OUTPUT_LINE_OVERHEAD
+}
+
+// push appends the line, the caller keeps the budget once it holds no lock of a
+// buffer, see keep
+func (q *lineQueue) push(line LogLine) {
+	b := q.budget
+
+	b.mu.Lock()
+	if q.closed {
+		b.mu.Unlock()
+		return
+	}
+
+	q.lines = append(q.lines, line)
+	b.count(q, lineSize(line))
+
+	if q.maxLines > 0 && len(q.lines) > q.maxLines {
+		q.dropOldest()
+	}
+	b.mu.Unlock()
+
+	q.signal()
+}
+
+// pop waits for the oldest line until the queue is closed and empty, or until stop is closed
+func (q *lineQueue) pop(stop <-chan struct{}) (LogLine, bool) {
+	b := q.budget
+
+	for {
+		b.mu.Lock()
+		if len(q.lines) > 0 {
+			line := q.shift()
+			b.mu.Unlock()
+
+			return line, true
+		}
+
+		if q.closed {
+			delete(b.shares, q)
+			b.mu.Unlock()
+
+			return LogLine{}, false
+		}
+		b.mu.Unlock()
+
+		select {
+		case <-q.ready:
+		case <-stop:
+			return LogLine{}, false
+		}
+	}
+}
+
+// close ends the queue once its lines are popped
+func (q *lineQueue) close() {
+	q.budget.mu.Lock()
+	q.closed = true
+	q.budget.mu.Unlock()
+
+	q.signal()
+}
+
+// discard drops the lines and ignores lines pushed later, for queues without a consumer
+func (q *lineQueue) discard() {
+	b := q.budget
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q.closed = true
+	for len(q.lines) > 0 {
+		q.shift()
+	}
+	delete(b.shares, q)
+}
+
+func (q *lineQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// shift removes the oldest line, must be called holding budget.mu
+func (q *lineQueue) shift() LogLine {
+	line := q.lines[0]
+	q.lines[0] = LogLine{}
+	q.lines = q.lines[1:]
+	q.budget.count(q, -lineSize(line))
+
+	return line
+}
+
+// dropOldest drops the oldest line, must be called holding budget.mu
+func (q *lineQueue) dropOldest() {
+	line := q.lines[0]
+	q.lines[0] = LogLine{}
+	q.lines = q.lines[1:]
+	q.budget.drop(q, lineSize(line))
+}
+
+func (q *lineQueue) trim() bool {
+	q.budget.mu.Lock()
+	defer q.budget.mu.Unlock()
+
+	if len(q.lines) == 0 {
+		return false
+	}
+	q.dropOldest()
+
+	return true
+}
+
+// SetOutputBudget limits the bytes of output all services buffer for printing,
+// followers, their recent lines and stderr tails, and that the collectors of
+// LogForward buffer, OUTPUT_BUDGET if not set
+func (m *Manager) SetOutputBudget(limit int) {
+	if limit <= 0 {
+		limit = OUTPUT_BUDGET
+	}
+
+	m.outputBudget.setLimit(limit)
+}
+
+// OutputUsage returns the output buffered by the service and the lines it had dropped
+func (s *Service) OutputUsage() OutputUsage {
+	return s.getOutputBudget().usage(&s.outputUsage)
+}
+
+// getOutputBudget returns the budget of the manager, a service run on its own
+// gets a budget of OUTPUT_BUDGET
+func (s *Service) getOutputBudget() *outputBudget {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.outputBudget == nil {
+		s.outputBudget = newOutputBudget(OUTPUT_BUDGET)
+	}
+
+	return s.outputBudget
+}
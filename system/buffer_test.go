@@ -0,0 +1,200 @@
+package system
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func textLine(text string) LogLine {
+	return LogLine{Service: "web", Stream: STREAM_STDOUT, Text: text, Time: time.Now()}
+}
+
+// TestOutputBudgetCountsEveryBuffer fills the recent lines, a stderr tail, a
+// collector and a follower of a service, all of them count and all of them
+// are trimmed over the budget
+func TestOutputBudgetCountsEveryBuffer(t *testing.T) {
+	budget := newOutputBudget(1 << 20)
+	var usage OutputUsage
+
+	var output outputFollowers
+	output.count(budget, &usage)
+	queue := budget.newQueue(&usage, 0)
+
+	tail := newTailBuffer(1 << 10)
+	tail.count(budget, &usage)
+
+	f := makeForwarder(LogForward{Address: "tcp://127.0.0.1:1"}, nil)
+	f.budget = budget
+	budget.join(f, &f.usage)
+
+	line := textLine(strings.Repeat("x", 36))
+	for i := 0; i < 10; i++ {
+		output.Send(line)
+		queue.push(line)
+		tail.Write([]byte(line.Text + "\n"))
+		f.send(line)
+	}
+
+	encoded := len(f.config.encode(line)) + OUTPUT_LINE_OVERHEAD
+	service := 20*lineSize(line) + 370
+	if got := budget.usage(&usage); got.BufferedBytes != service || got.DroppedLines != 0 {
+		t.Errorf("usage %+v, want %d bytes of the recent lines, the queue and the tail", got, service)
+	}
+	if stats := f.stats(); stats.BufferedBytes != 10*encoded || stats.Buffered != 10 {
+		t.Errorf("collector %+v, want %d bytes of 10 lines", stats, 10*encoded)
+	}
+
+	// the largest buffers lose their oldest lines until the budget is kept,
+	// leaving the service and the collector about the same share
+	limit := 10 * encoded
+	budget.setLimit(limit)
+	got, stats := budget.usage(&usage), f.stats()
+	if total := got.BufferedBytes + stats.BufferedBytes; total > limit || total < limit-2*encoded {
+		t.Errorf("%d bytes buffered, want up to the budget of %d", total, limit)
+	}
+	if share := got.BufferedBytes - stats.BufferedBytes; share > encoded || share < -encoded {
+		t.Errorf("%d bytes of the service and %d of the collector, want a fair share", got.BufferedBytes, stats.BufferedBytes)
+	}
+	if got.DroppedLines == 0 || stats.Dropped == 0 || stats.Dropped+int64(stats.Buffered) != 10 {
+		t.Errorf("usage %+v and collector %+v, want the oldest lines of both dropped", got, stats)
+	}
+
+	budget.setLimit(0)
+	if got, stats := budget.usage(&usage), f.stats(); got.BufferedBytes != 0 || got.DroppedLines != 30 || stats.Buffered != 0 || stats.Dropped != 10 {
+		t.Errorf("usage %+v and collector %+v, want every line dropped", got, stats)
+	}
+
+	// the recent lines left are the newest and count again
+	output.Send(textLine("last"))
+	if recent := output.Recent(0); len(recent) != 1 || recent[0].Text != "last" {
+		t.Errorf("recent lines %v, want the last one", recent)
+	}
+	if tail.String() != "" {
+		t.Errorf("tail %q, want it trimmed", tail.String())
+	}
+
+	tail.release()
+	output.release()
+	f.budget.leave(f)
+	if pop, ok := queue.pop(closedChannel()); ok {
+		t.Errorf("queue popped %+v, want it emptied", pop)
+	}
+	queue.discard()
+	if budget.used != 0 || len(budget.shares) != 0 {
+		t.Errorf("%d bytes of %d buffers left counted", budget.used, len(budget.shares))
+	}
+}
+
+func closedChannel() chan struct{} {
+	closed := make(chan struct{})
+	close(closed)
+
+	return closed
+}
+
+// TestOutputBudgetFairShare floods a budget from a noisy service, the lines of
+// a quiet one are kept
+func TestOutputBudgetFairShare(t *testing.T) {
+	budget := newOutputBudget(100 * lineSize(textLine("x")))
+	var noisy, quiet OutputUsage
+
+	var noisyOutput, quietOutput outputFollowers
+	noisyOutput.count(budget, &noisy)
+	quietOutput.count(budget, &quiet)
+	noisyOutput.setKeep(1000)
+
+	for i := 0; i < 10; i++ {
+		quietOutput.Send(textLine("x"))
+	}
+	for i := 0; i < 1000; i++ {
+		noisyOutput.Send(textLine("x"))
+		budget.keep()
+	}
+
+	if got := budget.usage(&quiet); got.BufferedBytes != 10*lineSize(textLine("x")) || got.DroppedLines != 0 {
+		t.Errorf("quiet usage %+v, want its 10 lines kept", got)
+	}
+	if got := budget.usage(&noisy); got.DroppedLines != 910 || len(noisyOutput.Recent(0)) != 90 {
+		t.Errorf("noisy usage %+v with %d lines, want the rest of the budget", got, len(noisyOutput.Recent(0)))
+	}
+}
+
+// residentKB reads the resident memory of the test process
+func residentKB(t *testing.T) uint64 {
+	t.Helper()
+
+	status, err := procFS().Status(os.Getpid())
+	if err != nil {
+		t.Fatalf("status: %s", err)
+	}
+
+	return status.VmRSS
+}
+
+// TestOutputBudgetUnderFlood runs a service writing 1KB lines as fast as they
+// are read, with a console that never prints, a follower that never reads,
+// a collector that is never reachable and every recent line and stderr byte
+// kept: the supervisor stays within the budget and a constant
+func TestOutputBudgetUnderFlood(t *testing.T) {
+	if !procCapabilities().Memory {
+		t.Skip("the resident memory is read from /proc")
+	}
+
+	const budget = 8 << 20
+
+	service := NewService(ServiceConfig{
+		Name:           "flood",
+		Exec:           "yes",
+		Params:         []string{strings.Repeat("x", 1023)},
+		CombineOutput:  true,
+		RecentLines:    1 << 20,
+		StderrTailSize: 256 << 20,
+		StopTimeout:    time.Second,
+	})
+	service.outputBudget = newOutputBudget(budget)
+	service.forwarder = newForwarder(LogForward{Address: "tcp://127.0.0.1:1", Buffer: 1 << 20}, func(string) {}, service.outputBudget)
+	defer service.forwarder.close()
+
+	_, stop := service.FollowOutput(0)
+	defer stop()
+
+	before := residentKB(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := make(chan string)
+	go service.Run(ctx, out, nil)
+	defer func() {
+		cancel()
+		service.Wait()
+	}()
+
+	var peak uint64
+	start := time.Now()
+	for time.Since(start) < 3*time.Second {
+		time.Sleep(50 * time.Millisecond)
+		if rss := residentKB(t); rss > peak {
+			peak = rss
+		}
+		if used := service.OutputUsage().BufferedBytes + service.forwarder.stats().BufferedBytes; used > budget+2*lineSize(textLine(strings.Repeat("x", 1023))) {
+			t.Fatalf("%d bytes buffered over the budget of %d", used, budget)
+		}
+	}
+
+	status := service.Status()
+	var lines int64
+	for _, count := range status.OutputLevels {
+		lines += count
+	}
+	t.Logf("%d MB/s of output, %d lines dropped, rss from %d KB to a peak of %d KB", lines*1024/(1<<20)/3, status.OutputDropped, before, peak)
+
+	if status.OutputDropped == 0 {
+		t.Errorf("no line dropped, want the flood over the budget")
+	}
+	// the heap may grow to twice the lines held before it is collected
+	if !raceEnabled && peak > before+2*budget/1024+64<<10 {
+		t.Errorf("rss grew from %d KB to %d KB, want it within the budget of %d KB and 64 MB", before, peak, budget/1024)
+	}
+}
@@ -0,0 +1,60 @@
+package system
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// joinCgroup creates the cgroup of the service with its limits and has the
+// process started in it, the returned file is to be closed once it has started
+func (s *Service) joinCgroup(running *process) (*os.File, error) {
+	dir := s.cgroupDir()
+	if dir == "" {
+		if s.CPUQuota > 0 {
+			s.log().warnf("cpuQuota is not kept without a cgroup root")
+		}
+
+		return nil, nil
+	}
+
+	if err := writeCgroup(s.cgroupRoot, "cgroup.subtree_control", "+cpu +memory"); err != nil {
+		return nil, fmt.Errorf("cgroup root: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("cgroup: %w", err)
+	}
+
+	cpu := "max " + strconv.Itoa(CGROUP_CPU_PERIOD)
+	if s.CPUQuota > 0 {
+		cpu = fmt.Sprintf("%d %d", int64(s.CPUQuota*CGROUP_CPU_PERIOD), CGROUP_CPU_PERIOD)
+	}
+
+	// the kernel keeps the limit only if the service is to be stopped above it
+	memory := "max"
+	if s.MemoryLimit > 0 && s.getMemoryLimitAction() == MEMORY_LIMIT_STOP {
+		memory = strconv.FormatInt(s.MemoryLimit, 10)
+	}
+
+	for file, value := range map[string]string{"cpu.max": cpu, "memory.max": memory} {
+		if err := writeCgroup(dir, file, value); err != nil {
+			return nil, fmt.Errorf("cgroup: %w", err)
+		}
+	}
+
+	cgroup, err := os.Open(dir)
+	if err != nil {
+		return nil, fmt.Errorf("cgroup: %w", err)
+	}
+
+	if running.cmd.SysProcAttr == nil {
+		running.cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	running.cmd.SysProcAttr.UseCgroupFD = true
+	running.cmd.SysProcAttr.CgroupFD = int(cgroup.Fd())
+	running.cgroup = dir
+
+	return cgroup, nil
+}
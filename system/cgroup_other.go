@@ -0,0 +1,15 @@
+//go:build !linux
+
+package system
+
+import "os"
+
+// joinCgroup has no cgroups to start the process in, the MemoryLimit is
+// watched on the process alone
+func (s *Service) joinCgroup(running *process) (*os.File, error) {
+	if s.CPUQuota > 0 {
+		s.log().warnf("cpuQuota is not kept, cgroups are %s", ErrUnsupportedPlatform)
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,281 @@
+package system
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CLOCK_JUMP_THRESHOLD is how far the wall clock has to move apart from the
+// monotonic one between two ticks of the manager to be reported as a jump
+const CLOCK_JUMP_THRESHOLD = time.Second
+
+// Clock is the time supervision runs on. Restart delays, probes, timeouts and the
+// scheduler only compare Monotonic readings and wait on timers, so a step of the
+// wall clock (NTP, a VM migration) does not move them, Now stamps the records,
+// history and events
+type Clock interface {
+	// Now is the wall clock time
+	Now() time.Time
+
+	// Monotonic is the time elapsed since an arbitrary point, only the difference
+	// of two readings means something
+	Monotonic() time.Duration
+
+	// After sends the wall clock time on the channel once d has elapsed
+	After(d time.Duration) <-chan time.Time
+
+	// NewTimer and AfterFunc behave like the ones of the time package, the timer
+	// of AfterFunc has no channel
+	NewTimer(d time.Duration) Timer
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a timer of a Clock
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// SystemClock is the clock of the time package, its Monotonic readings are of the
+// monotonic clock of the os
+var SystemClock Clock = systemClock{start: time.Now()}
+
+type systemClock struct {
+	start time.Time
+}
+
+func (c systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (c systemClock) Monotonic() time.Duration {
+	return time.Since(c.start)
+}
+
+func (c systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (c systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+func (c systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return systemTimer{time.AfterFunc(d, f)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// FakeClock is a Clock moved by hand to check the supervision deterministically,
+// Advance moves both the wall and the monotonic time and fires the timers due,
+// Jump steps the wall clock alone like NTP does
+type FakeClock struct {
+	mu      sync.Mutex
+	wall    time.Time
+	elapsed time.Duration
+	timers  []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	at    time.Duration
+	c     chan time.Time
+	f     func()
+}
+
+// NewFakeClock returns a clock standing at the wall clock time
+func NewFakeClock(wall time.Time) *FakeClock {
+	c := new(FakeClock)
+	c.wall = wall.Round(0)
+
+	return c
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.wall
+}
+
+func (c *FakeClock) Monotonic() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.elapsed
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	return c.add(d, make(chan time.Time, 1), nil)
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	return c.add(d, nil, f)
+}
+
+func (c *FakeClock) add(d time.Duration, ch chan time.Time, f func()) *fakeTimer {
+	c.mu.Lock()
+	t := &fakeTimer{clock: c, at: c.elapsed + d, c: ch, f: f}
+	c.timers = append(c.timers, t)
+	c.mu.Unlock()
+
+	if d <= 0 {
+		c.Advance(0)
+	}
+
+	return t
+}
+
+// Advance moves the clock forward by d, firing the timers due in order
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.elapsed += d
+	c.wall = c.wall.Add(d)
+
+	var due []*fakeTimer
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at <= c.elapsed {
+			due = append(due, t)
+		} else {
+			pending = append(pending, t)
+		}
+	}
+	c.timers = pending
+	wall := c.wall
+	c.mu.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool { return due[i].at < due[j].at })
+	for _, t := range due {
+		if t.f != nil {
+			go t.f()
+			continue
+		}
+
+		select {
+		case t.c <- wall:
+		default:
+		}
+	}
+}
+
+// Jump steps the wall clock by d, backwards if negative, the monotonic time and
+// the timers stay
+func (c *FakeClock) Jump(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.wall = c.wall.Add(d)
+}
+
+// Timers returns the number of timers not fired yet, to wait for the supervision
+// to have armed the ones expected before advancing
+func (c *FakeClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.timers)
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	return t.remove()
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	active := t.remove()
+	t.at = t.clock.elapsed + d
+	t.clock.timers = append(t.clock.timers, t)
+	t.clock.mu.Unlock()
+
+	if d <= 0 {
+		t.clock.Advance(0)
+	}
+
+	return active
+}
+
+// remove takes the timer off the clock, must be called holding the clock mu
+func (t *fakeTimer) remove() bool {
+	for i, pending := range t.clock.timers {
+		if pending == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// clockWatch notices the wall clock moving apart from the monotonic one, the
+// readings of a Clock are compared without their monotonic part
+type clockWatch struct {
+	wall time.Time
+	mono time.Duration
+}
+
+// check returns how far the wall clock jumped since the last check, zero below
+// CLOCK_JUMP_THRESHOLD
+func (w *clockWatch) check(clock Clock) time.Duration {
+	wall, mono := clock.Now().Round(0), clock.Monotonic()
+	last, lastMono := w.wall, w.mono
+	w.wall, w.mono = wall, mono
+
+	if last.IsZero() {
+		return 0
+	}
+
+	jump := wall.Sub(last) - (mono - lastMono)
+	if jump < CLOCK_JUMP_THRESHOLD && jump > -CLOCK_JUMP_THRESHOLD {
+		return 0
+	}
+
+	return jump
+}
+
+// SetClock makes the manager and its services run on the clock, SystemClock by
+// default, it must be called before Run
+func (m *Manager) SetClock(clock Clock) {
+	m.scheduler.setClock(clock)
+}
+
+// Clock returns the clock the manager runs on
+func (m *Manager) Clock() Clock {
+	return m.scheduler.getClock()
+}
+
+// watchClock reports a jump of the wall clock, scheduling is not affected by it
+// but the times of records taken across it are off
+func (m *Manager) watchClock() {
+	jump := m.clockWatch.check(m.Clock())
+	if jump == 0 {
+		return
+	}
+
+	m.warn(fmt.Sprintf("wall clock jumped by %s, restarts and timeouts are not affected", jump.Round(time.Millisecond)))
+}
+
+// getClock returns the clock of the scheduler of the service
+func (s *Service) getClock() Clock {
+	return s.getScheduler().getClock()
+}
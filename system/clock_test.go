@@ -0,0 +1,132 @@
+package system
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRestartDelayAcrossClockJumps steps the wall clock in the restart delay,
+// the restart still comes after the delay on the monotonic clock
+func TestRestartDelayAcrossClockJumps(t *testing.T) {
+	for _, jump := range []time.Duration{-45 * time.Minute, time.Hour} {
+		t.Run(jump.String(), func(t *testing.T) {
+			service := NewService(ServiceConfig{
+				Name:          "crasher",
+				Exec:          "false",
+				RestartPolicy: RESTART_ALWAYS,
+				RestartDelay:  10 * time.Second,
+			})
+			clock := onFakeClock(service)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			go service.Run(ctx, nil, nil)
+			eventually(t, 5*time.Second, "the restart delay", func() bool {
+				status := service.Status()
+				return status.Runs == 1 && status.State == StateRestarting
+			})
+
+			clock.Jump(jump)
+			clock.Advance(9 * time.Second)
+			time.Sleep(50 * time.Millisecond)
+			if runs := service.Status().Runs; runs != 1 {
+				t.Fatalf("restarted 9s into the delay after a jump of %s", jump)
+			}
+			advanceUntil(t, clock, time.Second, "the restart", func() bool { return service.Status().Runs == 2 })
+
+			cancel()
+			service.Wait()
+		})
+	}
+}
+
+func TestProbeIntervalAcrossClockJump(t *testing.T) {
+	service := NewService(ServiceConfig{
+		Name:     "web",
+		Exec:     "sleep",
+		Params:   []string{"30"},
+		Liveness: Probe{Path: "/nonexistent/systemgo", Interval: 10 * time.Second},
+	})
+	clock := onFakeClock(service)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go service.Run(ctx, nil, nil)
+	eventually(t, 5*time.Second, "the start", func() bool { return service.Status().State == StateRunning })
+
+	clock.Jump(time.Hour)
+	time.Sleep(50 * time.Millisecond)
+	if history := service.History(); len(history) != 0 {
+		t.Fatalf("probed %d times on a jump of the wall clock alone", len(history))
+	}
+
+	// the probe is armed with a jitter once the process runs
+	eventually(t, 5*time.Second, "the failed probe", func() bool {
+		clock.Advance(time.Second)
+		return len(service.History()) == 1
+	})
+	if record := service.History()[0]; record.StopReason != StopReasonLivenessFailed {
+		t.Errorf("stop reason %s, want %s", record.StopReason, StopReasonLivenessFailed)
+	}
+
+	cancel()
+	service.Wait()
+}
+
+func TestClockWatch(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		advance time.Duration
+		jump    time.Duration
+		want    time.Duration
+	}{
+		{"no jump", time.Minute, 0, 0},
+		{"drift below the threshold", time.Minute, CLOCK_JUMP_THRESHOLD / 2, 0},
+		{"step forward", time.Minute, time.Hour, time.Hour},
+		{"step backwards", time.Minute, -45 * time.Minute, -45 * time.Minute},
+	} {
+		clock := NewFakeClock(time.Now())
+
+		var watch clockWatch
+		if jump := watch.check(clock); jump != 0 {
+			t.Errorf("%s: first check reports %s", test.name, jump)
+		}
+
+		clock.Advance(test.advance)
+		clock.Jump(test.jump)
+		if jump := watch.check(clock); jump != test.want {
+			t.Errorf("%s: jump %s, want %s", test.name, jump, test.want)
+		}
+		if jump := watch.check(clock); jump != 0 {
+			t.Errorf("%s: jump %s reported twice", test.name, jump)
+		}
+	}
+}
+
+func TestManagerReportsClockJump(t *testing.T) {
+	m, clock := runManager(t, sleeper("web"))
+	events := m.Subscribe()
+	defer m.Unsubscribe(events)
+
+	clock.Jump(time.Hour)
+
+	// the manager checks the clock every second
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if event.Type == EVENT_MESSAGE && strings.Contains(event.Message, "wall clock jumped by 1h0m0s") {
+				if event.Level != EVENT_LEVEL_WARN {
+					t.Errorf("the jump is reported at level %s", event.Level)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatalf("the jump of the wall clock was not reported")
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+var (
+	ErrInvalidCmdFactory = errors.New("invalid command factory")
+	ErrCmdStarted        = errors.New("the command of the factory is already started")
+)
+
+// CmdFactory builds the command of a run of a service, it is called at every
+// start and restart and must return a command not started yet
+type CmdFactory func() (*exec.Cmd, error)
+
+// Option sets the configuration of a service built from a CmdFactory
+type Option func(*Service)
+
+// WithConfig runs the service with the restart policy, env, labels and other
+// settings of config, its name, exec, script, interpreter and params are not used
+func WithConfig(config ServiceConfig) Option {
+	return func(s *Service) {
+		config.Name = s.Name
+		config.Exec, config.Script, config.Interpreter, config.Params = "", "", "", nil
+		s.ServiceConfig = config
+	}
+}
+
+// WithRestartDelay restarts the service the delay after it exited
+func WithRestartDelay(delay time.Duration) Option {
+	return func(s *Service) {
+		s.RestartDelay = delay
+	}
+}
+
+// WithEnv adds "KEY=value" variables to the environment of the command
+func WithEnv(env ...string) Option {
+	return func(s *Service) {
+		s.Env = append(s.Env, env...)
+	}
+}
+
+// NewServiceFromCmdFactory supervises the commands built by factory, for the
+// SysProcAttr, ExtraFiles or Stdin no configuration covers. The supervisor starts,
+// stops and restarts them, records their history and reads their output as for
+// any service: Stdout and Stderr the factory left nil are read line by line, set
+// ones are written to as they are and not read. The environment of the service
+// is added to the Env of the command, the one of the supervisor if it is nil.
+// Files of the command are the factory's, they are not closed
+func NewServiceFromCmdFactory(name string, factory CmdFactory, opts ...Option) *Service {
+	service := NewService(ServiceConfig{Name: name})
+	for _, opt := range opts {
+		opt(service)
+	}
+	service.cmdFactory = factory
+
+	return service
+}
+
+// validateCmdFactories refuses services with a factory set to run something
+// else, or whose stdin or stdout the supervisor would need for a pipe
+func validateCmdFactories(services []Service) error {
+	factories := make(map[string]bool)
+	for i := range services {
+		service := &services[i]
+		if service.cmdFactory == nil {
+			continue
+		}
+		factories[service.Name] = true
+
+		switch {
+		case service.Exec != "" || service.Script != "" || service.Interpreter != "":
+			return fmt.Errorf("%s: %w: exec, script and interpreter are not used with a factory", service.Name, ErrInvalidCmdFactory)
+		case IsTemplate(service.Name) || service.Replicas > 0:
+			return fmt.Errorf("%s: %w: templates and replicas are built from their configuration", service.Name, ErrInvalidCmdFactory)
+		case service.passesSockets():
+			return fmt.Errorf("%s: %w: the command of a factory is not passed the sockets of an activation", service.Name, ErrInvalidCmdFactory)
+		case service.PipeTo != "":
+			return fmt.Errorf("%s: %w: the stdout of a factory command is not piped, set it in the factory", service.Name, ErrInvalidCmdFactory)
+		case service.isExternal():
+			return fmt.Errorf("%s: %w: a service with a probe has no command", service.Name, ErrInvalidCmdFactory)
+		}
+	}
+
+	for i := range services {
+		if service := &services[i]; factories[service.PipeTo] {
+			return fmt.Errorf("%s: %w: the stdin of the factory command of %s is not piped, set it in the factory", service.Name, ErrInvalidCmdFactory, service.PipeTo)
+		}
+	}
+
+	return nil
+}
+
+// newFactoryProcess builds the process of a run from the factory, a command
+// already started or run is refused
+func (s *Service) newFactoryProcess() (*process, error) {
+	cmd, err := s.cmdFactory()
+	if err != nil {
+		return nil, fmt.Errorf("command factory: %w", err)
+	}
+
+	if cmd == nil {
+		return nil, fmt.Errorf("%w: the factory returned no command", ErrInvalidCmdFactory)
+	}
+
+	if cmd.Process != nil || cmd.ProcessState != nil {
+		return nil, ErrCmdStarted
+	}
+
+	env, secrets, err := s.processEnv(cmd.Env)
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, stderr := cmd.Stdout, cmd.Stderr
+	running := newCmdProcess(s.Name, cmd)
+	if running.cmd.Dir == "" {
+		running.cmd.Dir = s.WorkingDir
+	}
+	running.cmd.Env = env
+	running.secrets = secrets
+
+	// the writers of the factory are kept, there is nothing to read for them
+	if stdout != nil {
+		running.cmd.Stdout = stdout
+		running.outWriter.Close()
+		running.Out = nil
+	}
+
+	if stderr != nil {
+		running.cmd.Stderr = stderr
+		running.errWriter.Close()
+		running.Err = nil
+	}
+
+	return running, nil
+}
@@ -0,0 +1,214 @@
+package system
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// printed reports whether the service printed a line ending with text
+func printed(service *Service, text string) bool {
+	for _, line := range service.RecentOutput(0) {
+		if strings.HasSuffix(line.Text, text) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// supervised starts the service until the test ends
+func supervised(t *testing.T, service *Service) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		service.Wait()
+	})
+
+	if err := service.Start(ctx, nil, nil); err != nil {
+		t.Fatalf("start: %s", err)
+	}
+}
+
+func TestCmdFactoryAtEveryStart(t *testing.T) {
+	var calls int32
+	service := NewServiceFromCmdFactory("counted", func() (*exec.Cmd, error) {
+		n := atomic.AddInt32(&calls, 1)
+		return exec.Command("/bin/sh", "-c", `echo "run $0 $SYSTEMGO_INCARNATION"; echo "failing $0" >&2; exit 3`, strings.Repeat("x", int(n))), nil
+	}, WithConfig(ServiceConfig{RestartPolicy: RESTART_ALWAYS, StopTimeout: time.Second}), WithRestartDelay(time.Millisecond))
+	supervised(t, service)
+
+	// restarted as any service, output read and runs recorded
+	eventually(t, 5*time.Second, "three runs", func() bool { return len(service.History()) >= 3 })
+	if n := atomic.LoadInt32(&calls); n < 3 {
+		t.Fatalf("factory called %d times for 3 runs", n)
+	}
+	if !printed(service, "run xx 2") {
+		t.Fatalf("output %+v, want the second command read with its incarnation", service.RecentOutput(0))
+	}
+
+	record := service.History()[1]
+	if record.ExitCode != 3 || record.Incarnation != 2 || record.Stderr != "failing xx\n" {
+		t.Fatalf("record %+v, want the second run", record)
+	}
+}
+
+func TestCmdFactoryWritersAndStdinKept(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	service := NewServiceFromCmdFactory("filter", func() (*exec.Cmd, error) {
+		file, err := os.Create(out)
+		if err != nil {
+			return nil, err
+		}
+
+		cmd := exec.Command("/bin/sh", "-c", `read line; echo "stdin $line"; echo "stderr $line" >&2; exec sleep 30`)
+		cmd.Stdin = strings.NewReader("prepared\n")
+		cmd.Stdout = file
+		return cmd, nil
+	}, WithConfig(ServiceConfig{StopTimeout: time.Second}))
+	supervised(t, service)
+
+	// stdout goes to the file of the factory, stderr left nil is read
+	eventually(t, 5*time.Second, "stderr read", func() bool { return printed(service, "stderr prepared") })
+	eventually(t, 5*time.Second, "stdout written", func() bool {
+		data, _ := ioutil.ReadFile(out)
+		return string(data) == "stdin prepared\n"
+	})
+	if printed(service, "stdin prepared") {
+		t.Fatal("stdout of the factory read as output")
+	}
+}
+
+func TestCmdFactoryExtraFilesRoundTrip(t *testing.T) {
+	requests, request, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe: %s", err)
+	}
+	response, responses, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe: %s", err)
+	}
+	defer func() {
+		for _, file := range []*os.File{requests, request, response, responses} {
+			file.Close()
+		}
+	}()
+
+	service := NewServiceFromCmdFactory("passed", func() (*exec.Cmd, error) {
+		cmd := exec.Command("/bin/sh", "-c", `read line <&3; echo "pong $line" >&4; exec sleep 30`)
+		cmd.ExtraFiles = []*os.File{requests, responses}
+		return cmd, nil
+	}, WithConfig(ServiceConfig{StopTimeout: time.Second}))
+	supervised(t, service)
+
+	if _, err := request.WriteString("ping\n"); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+
+	answered := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(response).ReadString('\n')
+		answered <- line
+	}()
+	select {
+	case line := <-answered:
+		if line != "pong ping\n" {
+			t.Fatalf("answer %q, want pong ping", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no answer on the descriptor passed")
+	}
+
+	// the files are the factory's, still open once the process runs
+	if _, err := requests.Stat(); err != nil {
+		t.Fatalf("file of the factory closed: %s", err)
+	}
+}
+
+func TestCmdFactoryEnv(t *testing.T) {
+	for name, base := range map[string][]string{"own env": {"FROM_CMD=cmd"}, "no env": nil} {
+		t.Run(name, func(t *testing.T) {
+			env := filepath.Join(t.TempDir(), "env")
+			service := NewServiceFromCmdFactory("env", func() (*exec.Cmd, error) {
+				cmd := exec.Command("/bin/sh", "-c", `echo "${FROM_CMD:-none} $FROM_OPTION ${HOME:+home}" > "$0"; exec sleep 30`, env)
+				cmd.Env = base
+				return cmd, nil
+			}, WithConfig(ServiceConfig{StopTimeout: time.Second}), WithEnv("FROM_OPTION=option"))
+			supervised(t, service)
+
+			// the env of the command replaces the one of the supervisor
+			want := "none option home\n"
+			if base != nil {
+				want = "cmd option \n"
+			}
+			eventually(t, 5*time.Second, "the env written", func() bool {
+				data, _ := ioutil.ReadFile(env)
+				return string(data) == want
+			})
+		})
+	}
+}
+
+func TestCmdFactoryRefusedCommands(t *testing.T) {
+	started := exec.Command("true")
+	if err := started.Run(); err != nil {
+		t.Fatalf("run: %s", err)
+	}
+
+	for _, c := range []struct {
+		name    string
+		factory CmdFactory
+		want    error
+	}{
+		{"started", func() (*exec.Cmd, error) { return started, nil }, ErrCmdStarted},
+		{"no command", func() (*exec.Cmd, error) { return nil, nil }, ErrInvalidCmdFactory},
+		{"factory error", func() (*exec.Cmd, error) { return nil, os.ErrNotExist }, os.ErrNotExist},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			service := NewServiceFromCmdFactory("refused", c.factory, WithRestartDelay(time.Hour))
+			onFakeClock(service)
+
+			err := <-startAsync(t, service)
+			if !errors.Is(err, c.want) {
+				t.Fatalf("start: %v, want %v", err, c.want)
+			}
+			if status := service.Status(); status.PID != 0 || status.State == StateRunning {
+				t.Fatalf("status %+v, want the start failed", status)
+			}
+		})
+	}
+}
+
+func TestValidateCmdFactories(t *testing.T) {
+	factory := func() (*exec.Cmd, error) { return exec.Command("true"), nil }
+	with := func(config ServiceConfig) Service {
+		return Service{ServiceConfig: config, cmdFactory: factory}
+	}
+
+	if err := validateCmdFactories([]Service{with(ServiceConfig{Name: "built"}), {ServiceConfig: ServiceConfig{Name: "plain", PipeTo: "other"}}}); err != nil {
+		t.Fatalf("factory service: %s", err)
+	}
+
+	for name, services := range map[string][]Service{
+		"exec":      {with(ServiceConfig{Name: "built", Exec: "true"})},
+		"script":    {with(ServiceConfig{Name: "built", Script: "echo"})},
+		"template":  {with(ServiceConfig{Name: "built@"})},
+		"replicas":  {with(ServiceConfig{Name: "built", Replicas: 2})},
+		"pipe from": {with(ServiceConfig{Name: "built", PipeTo: "sink"})},
+		"pipe to":   {{ServiceConfig: ServiceConfig{Name: "source", PipeTo: "built"}}, with(ServiceConfig{Name: "built"})},
+	} {
+		if err := validateCmdFactories(services); !errors.Is(err, ErrInvalidCmdFactory) {
+			t.Errorf("%s: %v, want ErrInvalidCmdFactory", name, err)
+		}
+	}
+}
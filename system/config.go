@@ -0,0 +1,520 @@
+package system
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// DROPIN_SUFFIX names directories of fragments merged onto the service of the
+// same name, like "web.service.d"
+const DROPIN_SUFFIX = ".service.d"
+
+// CONFIG_APPEND suffixed to a list key of a drop-in ("Env+") appends to the list
+// instead of replacing it
+const CONFIG_APPEND = "+"
+
+var configExtensions = []string{".json", ".yaml", ".yml", ".toml"}
+
+type configDocument map[string]interface{}
+
+// LoadConfigDir reads services from every json, yaml and toml file of dir in lexical
+// order, a file defines a single service, named after the file if it has no name,
+// a list of them or a "services" list.
+// Fragments in <name>.service.d are merged onto the definitions afterwards, scalars
+// and lists replace the defined values, maps are merged and "Key+" appends to a list.
+// A "defaults" map next to "services" holds ManagerDefaults applied to every
+// service, defaults of later files are merged onto the earlier ones. Every problem
+// found is reported, as ConfigErrors
+func LoadConfigDir(dir string) ([]ServiceConfig, error) {
+	paths, err := configFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs ConfigErrors
+	var documents []configDocument
+	definedIn := make(map[string]string)
+	defaultsDocument := make(configDocument)
+
+	for _, path := range paths {
+		source, err := readConfigFile(path)
+		if err != nil {
+			errs = errs.add(err)
+			continue
+		}
+		errs = append(errs, source.check("")...)
+
+		if err := defaultsDocument.merge(source.defaults); err != nil {
+			errs = append(errs, source.errorAt(source.defaultsAt, "", err))
+		}
+
+		for i, document := range source.documents {
+			name, _ := document.get("Name").(string)
+			if name == "" && len(source.documents) == 1 && document.get("Name") == nil {
+				// a file of a single service names it, "worker@.yaml" is the template "worker@"
+				name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+				document.set("Name", name)
+			}
+			if name == "" {
+				errs = append(errs, source.errorAt(source.origins[i], "", errors.New("service without a name")))
+				continue
+			}
+
+			if first, ok := definedIn[name]; ok {
+				errs = append(errs, source.errorAt(source.nameAt(i), name, fmt.Errorf("%w, defined in %s and %s", ErrServiceExists, first, path)))
+				continue
+			}
+
+			definedIn[name] = path
+			documents = append(documents, document)
+		}
+	}
+
+	errs = append(errs, applyDropins(dir, documents, len(errs) == 0)...)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	defaults, err := decodeDefaults(defaultsDocument)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", dir, err)
+	}
+
+	configs := make([]ServiceConfig, 0, len(documents))
+	for _, document := range documents {
+		name := document.get("Name").(string)
+
+		config, err := document.decode()
+		if err != nil {
+			errs = append(errs, &ConfigError{File: definedIn[name], Service: name, Path: name, Err: err})
+			continue
+		}
+
+		configs = append(configs, defaults.apply(config))
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return configs, nil
+}
+
+// LoadConfigFile reads services from a json, yaml or toml file, like a file of
+// LoadConfigDir
+func LoadConfigFile(path string) ([]ServiceConfig, error) {
+	source, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return source.configs()
+}
+
+// LoadConfig reads services from a configuration file, or from a directory of
+// them with LoadConfigDir
+func LoadConfig(path string) ([]ServiceConfig, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return LoadConfigDir(path)
+	}
+
+	return LoadConfigFile(path)
+}
+
+// configFiles lists config files of dir sorted by name
+func configFiles(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if entry.IsDir() || !isConfigFile(entry.Name()) {
+			continue
+		}
+
+		paths = append(paths, filepath.Join(dir, entry.Name()))
+	}
+
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+func isConfigFile(name string) bool {
+	extension := filepath.Ext(name)
+	for _, known := range configExtensions {
+		if extension == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// applyDropins merges fragments of every <name>.service.d directory onto the
+// document of the service, fragments for services not defined are an error once
+// every definition has been read
+func applyDropins(dir string, documents []configDocument, complete bool) ConfigErrors {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return ConfigErrors{}.add(err)
+	}
+
+	byName := make(map[string]configDocument)
+	for _, document := range documents {
+		byName[document.get("Name").(string)] = document
+	}
+
+	var errs ConfigErrors
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasSuffix(entry.Name(), DROPIN_SUFFIX) {
+			continue
+		}
+
+		name := strings.TrimSuffix(entry.Name(), DROPIN_SUFFIX)
+		document, ok := byName[name]
+		if !ok && complete {
+			errs = append(errs, &ConfigError{File: filepath.Join(dir, entry.Name()), Err: fmt.Errorf("drop-in for a service not defined: %w", ErrServiceNotFound)})
+		}
+
+		paths, err := configFiles(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			errs = errs.add(err)
+			continue
+		}
+
+		for _, path := range paths {
+			source, err := readConfigFile(path)
+			if err != nil {
+				errs = errs.add(err)
+				continue
+			}
+			problems := source.check(name)
+			errs = append(errs, problems...)
+
+			if len(source.defaults) > 0 {
+				errs = append(errs, source.errorAt(source.defaultsAt, name, errors.New("defaults are not allowed in drop-ins")))
+			}
+
+			if document == nil || len(problems) > 0 {
+				continue
+			}
+
+			for i, fragment := range source.documents {
+				if err := document.merge(fragment); err != nil {
+					errs = append(errs, source.errorAt(source.origins[i], name, err))
+				}
+			}
+
+			// the drop-in must not rename the service it applies to
+			document.set("Name", name)
+		}
+	}
+
+	return errs
+}
+
+// configSource is a parsed configuration file, origins are the paths of its
+// documents and defaultsAt the one of its defaults
+type configSource struct {
+	path       string
+	documents  []configDocument
+	origins    []configPath
+	defaults   configDocument
+	defaultsAt configPath
+	positions  configPositions
+}
+
+// readConfigFile parses a file, its problems are returned as ConfigErrors
+func readConfigFile(path string) (*configSource, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := parseSource(filepath.Ext(path), data)
+	if err != nil {
+		return nil, ConfigErrors{}.add(err).inFile(path)
+	}
+	source.path = path
+
+	return source, nil
+}
+
+// ParseConfig reads services from a single document, format is "json", "yaml" or
+// "toml", its problems are returned as ConfigErrors
+func ParseConfig(format string, data []byte) ([]ServiceConfig, error) {
+	source, err := parseSource(format, data)
+	if err != nil {
+		return nil, ConfigErrors{}.add(err)
+	}
+
+	return source.configs()
+}
+
+// configs checks and decodes the services of a single source with its defaults
+func (s *configSource) configs() ([]ServiceConfig, error) {
+	if errs := s.check(""); len(errs) > 0 {
+		return nil, errs
+	}
+
+	defaults, err := decodeDefaults(s.defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	configs := make([]ServiceConfig, 0, len(s.documents))
+	for _, document := range s.documents {
+		config, err := document.decode()
+		if err != nil {
+			return nil, err
+		}
+
+		configs = append(configs, defaults.apply(config))
+	}
+
+	return configs, nil
+}
+
+// check returns the problems of the keys of the documents and the defaults, the
+// fragments of a drop-in are checked for the service named dropin
+func (s *configSource) check(dropin string) ConfigErrors {
+	var errs ConfigErrors
+
+	for i, document := range s.documents {
+		name, _ := document.get("Name").(string)
+		if dropin != "" {
+			name = dropin
+		}
+
+		at := s.origins[i]
+		if name != "" {
+			at = at.named(name)
+		}
+
+		check := configCheck{positions: s.positions, service: name}
+		check.document(at, document, reflect.TypeOf(ServiceConfig{}), dropin != "")
+		errs = append(errs, check.errs...)
+	}
+
+	if s.defaults != nil && dropin == "" {
+		check := configCheck{positions: s.positions}
+		check.document(s.defaultsAt, s.defaults, reflect.TypeOf(ManagerDefaults{}), true)
+		errs = append(errs, check.errs...)
+	}
+
+	sortConfigErrors(errs)
+
+	return errs.inFile(s.path)
+}
+
+// nameAt is the path of the name of the document i, shown as the document
+func (s *configSource) nameAt(i int) configPath {
+	key, _ := s.documents[i].key("Name")
+	name, _ := s.documents[i][key].(string)
+
+	return configPath{raw: s.origins[i].key(key).raw, shown: s.origins[i].named(name).shown}
+}
+
+func (s *configSource) errorAt(at configPath, service string, err error) *ConfigError {
+	position := s.positions[at.raw]
+
+	return &ConfigError{File: s.path, Line: position.line, Column: position.column, Service: service, Path: at.shown, Err: err}
+}
+
+// parseSource reads the documents of data in the format, a document that does not
+// parse fails with a ConfigError at its position
+func parseSource(format string, data []byte) (*configSource, error) {
+	var value interface{}
+	var err error
+
+	switch strings.TrimPrefix(format, ".") {
+	case "json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&value)
+	case "yaml", "yml":
+		err = yaml.Unmarshal(data, &value)
+	case "toml":
+		var table map[string]interface{}
+		err = toml.Unmarshal(data, &table)
+		value = table
+	default:
+		return nil, fmt.Errorf("unknown config format: %s", format)
+	}
+
+	if err != nil {
+		return nil, syntaxError(data, err)
+	}
+
+	source := &configSource{positions: parsePositions(format, data)}
+
+	value, source.defaults, source.defaultsAt, err = splitDefaults(value)
+	if err != nil {
+		return nil, source.errorAt(source.defaultsAt, "", err)
+	}
+
+	source.documents, source.origins, err = configDocuments(value, configPath{})
+	if err != nil {
+		return nil, err
+	}
+
+	return source, nil
+}
+
+// splitDefaults takes the "defaults" map out of a document holding services
+func splitDefaults(value interface{}) (interface{}, configDocument, configPath, error) {
+	document, ok := value.(map[string]interface{})
+	if !ok {
+		return value, nil, configPath{}, nil
+	}
+
+	key, ok := configDocument(document).key("Defaults")
+	if !ok {
+		return value, nil, configPath{}, nil
+	}
+
+	at := configPath{}.key(key)
+	defaults, ok := document[key].(map[string]interface{})
+	if !ok {
+		return nil, nil, at, fmt.Errorf("defaults must be a map, got %s", describeValue(document[key]))
+	}
+
+	delete(document, key)
+	if len(document) == 0 {
+		return nil, defaults, at, nil
+	}
+
+	return document, defaults, at, nil
+}
+
+// configDocuments returns the services of a parsed value with the paths they
+// are found at
+func configDocuments(value interface{}, at configPath) ([]configDocument, []configPath, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil, nil
+	case map[string]interface{}:
+		document := configDocument(v)
+		key, _ := document.key("Services")
+		if list, ok := listItems(document[key]); ok && len(document) == 1 {
+			return configDocuments(list, at.key(key))
+		}
+
+		return []configDocument{document}, []configPath{at}, nil
+	case []interface{}:
+		documents := make([]configDocument, 0, len(v))
+		origins := make([]configPath, 0, len(v))
+		for i, item := range v {
+			document, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, nil, &ConfigError{Path: at.index(i).shown, Err: fmt.Errorf("a service must be a map, got %s", describeValue(item))}
+			}
+
+			documents = append(documents, document)
+			origins = append(origins, at.index(i))
+		}
+
+		return documents, origins, nil
+	}
+
+	return nil, nil, fmt.Errorf("expected a service or a list of services, got %s", describeValue(value))
+}
+
+// key returns the key of the document matching name, keys are matched
+// ignoring case as json does when decoding into ServiceConfig
+func (d configDocument) key(name string) (string, bool) {
+	if _, ok := d[name]; ok {
+		return name, true
+	}
+
+	for key := range d {
+		if strings.EqualFold(key, name) {
+			return key, true
+		}
+	}
+
+	return name, false
+}
+
+func (d configDocument) get(name string) interface{} {
+	key, _ := d.key(name)
+	return d[key]
+}
+
+func (d configDocument) set(name string, value interface{}) {
+	key, _ := d.key(name)
+	d[key] = value
+}
+
+// merge applies a drop-in fragment onto the document
+func (d configDocument) merge(fragment configDocument) error {
+	for name, value := range fragment {
+		if strings.HasSuffix(name, CONFIG_APPEND) {
+			name = strings.TrimSuffix(name, CONFIG_APPEND)
+
+			appended, ok := value.([]interface{})
+			if !ok {
+				return fmt.Errorf("%s%s: only lists can be appended to", name, CONFIG_APPEND)
+			}
+
+			list, _ := d.get(name).([]interface{})
+			if d.get(name) != nil && list == nil {
+				return fmt.Errorf("%s%s: %s is not a list", name, CONFIG_APPEND, name)
+			}
+
+			d.set(name, append(append([]interface{}{}, list...), appended...))
+			continue
+		}
+
+		fragmentMap, isMap := value.(map[string]interface{})
+		baseMap, baseIsMap := d.get(name).(map[string]interface{})
+		if isMap && baseIsMap {
+			merged := make(map[string]interface{}, len(baseMap)+len(fragmentMap))
+			for key, item := range baseMap {
+				merged[key] = item
+			}
+			for key, item := range fragmentMap {
+				merged[key] = item
+			}
+
+			d.set(name, merged)
+			continue
+		}
+
+		d.set(name, value)
+	}
+
+	return nil
+}
+
+// decode converts the document into a ServiceConfig through json, so every format
+// accepts the same keys and durations
+func (d configDocument) decode() (ServiceConfig, error) {
+	var config ServiceConfig
+
+	data, err := json.Marshal(map[string]interface{}(d))
+	if err != nil {
+		return config, err
+	}
+
+	err = json.Unmarshal(data, &config)
+
+	// keys written in the document are set on purpose, zero or not
+	config.Explicit = append(explicitKeys(d), config.Explicit...)
+
+	return config, err
+}
@@ -0,0 +1,148 @@
+package system
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigDir writes the files, paths relative to a new directory
+func writeConfigDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("mkdir: %s", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("write %s: %s", name, err)
+		}
+	}
+
+	return dir
+}
+
+func namesOf(configs []ServiceConfig) []string {
+	names := make([]string, 0, len(configs))
+	for _, config := range configs {
+		names = append(names, config.Name)
+	}
+
+	return names
+}
+
+func TestLoadConfigDirOrder(t *testing.T) {
+	dir := writeConfigDir(t, map[string]string{
+		"b.yaml": `
+- name: x
+  exec: /bin/x
+- name: y
+  exec: /bin/y
+`,
+		"a.json":    `{"name": "z", "exec": "/bin/z"}`,
+		"c.toml":    `exec = "/bin/c"`,
+		"notes.txt": `not a service`,
+	})
+
+	configs, err := LoadConfigDir(dir)
+	if err != nil {
+		t.Fatalf("load: %s", err)
+	}
+	if names := namesOf(configs); !reflect.DeepEqual(names, []string{"z", "x", "y", "c"}) {
+		t.Errorf("services %v, want the files in lexical order [z x y c]", names)
+	}
+}
+
+func TestLoadConfigDirDuplicate(t *testing.T) {
+	dir := writeConfigDir(t, map[string]string{
+		"a.yaml": "name: web\nexec: /bin/web\n",
+		"b.json": `{"services": [{"name": "web", "exec": "/bin/other"}]}`,
+	})
+
+	_, err := LoadConfigDir(dir)
+	if !errors.Is(err, ErrServiceExists) {
+		t.Fatalf("load: %v, want %v", err, ErrServiceExists)
+	}
+	if message := err.Error(); !strings.Contains(message, "a.yaml") || !strings.Contains(message, "b.json") {
+		t.Errorf("%q, want both files named", message)
+	}
+}
+
+func TestDropinMerge(t *testing.T) {
+	dir := writeConfigDir(t, map[string]string{
+		"web.yaml": `
+exec: /bin/web
+params: ["-a"]
+restartDelay: 1s
+env: ["A=1"]
+labels: {team: app, tier: web}
+`,
+		"web.service.d/10-ops.yaml": `
+name: renamed
+restartDelay: 5s
+env+: ["B=2"]
+labels: {tier: edge}
+`,
+		"web.service.d/20-more.json": `{"params": ["-b"], "Env+": ["C=3"]}`,
+	})
+
+	configs, err := LoadConfigDir(dir)
+	if err != nil {
+		t.Fatalf("load: %s", err)
+	}
+	if len(configs) != 1 {
+		t.Fatalf("services %v, want web alone", namesOf(configs))
+	}
+
+	web := configs[0]
+	if web.Name != "web" {
+		t.Errorf("a drop-in renamed web to %s", web.Name)
+	}
+	// scalars and lists replace, maps merge, "key+" appends in file order
+	if web.RestartDelay != 5*time.Second {
+		t.Errorf("restart delay %s, want the 5s of the drop-in", web.RestartDelay)
+	}
+	if !reflect.DeepEqual(web.Params, []string{"-b"}) {
+		t.Errorf("params %v, want the list of the drop-in [-b]", web.Params)
+	}
+	if !reflect.DeepEqual(web.Env, []string{"A=1", "B=2", "C=3"}) {
+		t.Errorf("env %v, want the appended [A=1 B=2 C=3]", web.Env)
+	}
+	if want := map[string]string{"team": "app", "tier": "edge"}; !reflect.DeepEqual(web.Labels, want) {
+		t.Errorf("labels %v, want the merged %v", web.Labels, want)
+	}
+}
+
+func TestDropinErrors(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		dropin map[string]string
+		want   error
+	}{
+		{"undefined service", map[string]string{"cron.service.d/10.yaml": "restartDelay: 1s\n"}, ErrServiceNotFound},
+		{"append to a scalar", map[string]string{"web.service.d/10.yaml": "exec+: [\"/bin/other\"]\n"}, nil},
+		{"append a scalar", map[string]string{"web.service.d/10.yaml": "env+: B=2\n"}, nil},
+	} {
+		files := map[string]string{"web.yaml": "exec: /bin/web\nenv: [\"A=1\"]\n"}
+		for name, content := range test.dropin {
+			files[name] = content
+		}
+
+		_, err := LoadConfigDir(writeConfigDir(t, files))
+		var errs ConfigErrors
+		if !errors.As(err, &errs) {
+			t.Errorf("%s: %v, want ConfigErrors", test.name, err)
+			continue
+		}
+		if test.want != nil && !errors.Is(err, test.want) {
+			t.Errorf("%s: %v, want %v", test.name, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package system
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigError is a problem of a configuration at a key, Line and Column are 0
+// where they are not known, like the keys of toml files
+type ConfigError struct {
+	File    string
+	Line    int
+	Column  int
+	Service string
+
+	// Path of the key as written, like "services[3].web.stopTimeout"
+	Path string
+	Err  error
+}
+
+func (e *ConfigError) Error() string {
+	var parts []string
+
+	location := e.File
+	if e.Line > 0 {
+		location = strings.TrimPrefix(fmt.Sprintf("%s:%d", location, e.Line), ":")
+		if e.Column > 0 {
+			location = fmt.Sprintf("%s:%d", location, e.Column)
+		}
+	}
+	if location != "" {
+		parts = append(parts, location)
+	}
+
+	if e.Path != "" {
+		parts = append(parts, e.Path)
+	}
+
+	return strings.Join(append(parts, e.Err.Error()), ": ")
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ConfigErrors are all the problems found in a configuration, a line each
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (e ConfigErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+
+	return errs
+}
+
+// add appends err, the problems of ConfigErrors one by one
+func (e ConfigErrors) add(err error) ConfigErrors {
+	var list ConfigErrors
+	var one *ConfigError
+
+	switch {
+	case err == nil:
+		return e
+	case errors.As(err, &list):
+		return append(e, list...)
+	case errors.As(err, &one):
+		return append(e, one)
+	}
+
+	return append(e, &ConfigError{Err: err})
+}
+
+// inFile sets the file of the problems not having one
+func (e ConfigErrors) inFile(path string) ConfigErrors {
+	for _, err := range e {
+		if err.File == "" {
+			err.File = path
+		}
+	}
+
+	return e
+}
+
+func (e ConfigErrors) orNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+// configPosition is where a key or a list item is written
+type configPosition struct {
+	line   int
+	column int
+}
+
+// configPositions maps the raw paths of keys and list items of a parsed document
+// to their position, paths are built with joinConfigPath
+type configPositions map[string]configPosition
+
+func joinConfigPath(path, segment string) string {
+	if path == "" {
+		return segment
+	}
+
+	return path + "\x00" + segment
+}
+
+// configPath is a key of a document, raw is looked up in the positions and shown
+// is the path printed
+type configPath struct {
+	raw   string
+	shown string
+}
+
+func (p configPath) key(key string) configPath {
+	shown := key
+	if p.shown != "" {
+		shown = p.shown + "." + key
+	}
+
+	return configPath{raw: joinConfigPath(p.raw, key), shown: shown}
+}
+
+func (p configPath) index(i int) configPath {
+	return configPath{raw: joinConfigPath(p.raw, strconv.Itoa(i)), shown: fmt.Sprintf("%s[%d]", p.shown, i)}
+}
+
+// named shows the name of the service after the path of its document, keeping raw
+func (p configPath) named(name string) configPath {
+	shown := name
+	if p.shown != "" {
+		shown = p.shown + "." + name
+	}
+
+	return configPath{raw: p.raw, shown: shown}
+}
+
+// parsePositions finds where the keys of the document are written, toml does
+// not tell
+func parsePositions(format string, data []byte) configPositions {
+	switch strings.TrimPrefix(format, ".") {
+	case "json":
+		return jsonPositions(data)
+	case "yaml", "yml":
+		return yamlPositions(data)
+	}
+
+	return nil
+}
+
+func yamlPositions(data []byte) configPositions {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil
+	}
+
+	positions := make(configPositions)
+
+	var walk func(path string, node *yaml.Node)
+	walk = func(path string, node *yaml.Node) {
+		if node.Kind == yaml.AliasNode && node.Alias != nil {
+			node = node.Alias
+		}
+
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, child := range node.Content {
+				walk(path, child)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key, value := node.Content[i], node.Content[i+1]
+				if key.Tag == "!!merge" {
+					walk(path, value)
+					continue
+				}
+
+				child := joinConfigPath(path, key.Value)
+				positions[child] = configPosition{line: key.Line, column: key.Column}
+				walk(child, value)
+			}
+		case yaml.SequenceNode:
+			for i, item := range node.Content {
+				child := joinConfigPath(path, strconv.Itoa(i))
+				positions[child] = configPosition{line: item.Line, column: item.Column}
+				walk(child, item)
+			}
+		}
+	}
+	walk("", &root)
+
+	return positions
+}
+
+func jsonPositions(data []byte) configPositions {
+	positions := make(configPositions)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	// the next token starts after the separators following the offset
+	next := func() configPosition {
+		offset := int(decoder.InputOffset())
+		for offset < len(data) && strings.IndexByte(" \t\r\n,:", data[offset]) >= 0 {
+			offset++
+		}
+
+		return offsetPosition(data, offset)
+	}
+
+	var walk func(path string) error
+	walk = func(path string) error {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		switch token {
+		case json.Delim('{'):
+			for decoder.More() {
+				at := next()
+				key, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+
+				child := joinConfigPath(path, fmt.Sprint(key))
+				positions[child] = at
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+			_, err = decoder.Token()
+		case json.Delim('['):
+			for i := 0; decoder.More(); i++ {
+				child := joinConfigPath(path, strconv.Itoa(i))
+				positions[child] = next()
+				if err := walk(child); err != nil {
+					return err
+				}
+			}
+			_, err = decoder.Token()
+		}
+
+		return err
+	}
+	walk("")
+
+	return positions
+}
+
+// offsetPosition returns the line and the column of the byte offset, both from 1
+func offsetPosition(data []byte, offset int) configPosition {
+	if offset > len(data) {
+		offset = len(data)
+	}
+
+	line := bytes.Count(data[:offset], []byte("\n")) + 1
+	column := offset - bytes.LastIndexByte(data[:offset], '\n')
+
+	return configPosition{line: line, column: column}
+}
+
+var yamlLineError = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// syntaxError returns the error a document failed to parse with at its position
+func syntaxError(data []byte, err error) *ConfigError {
+	var jsonErr *json.SyntaxError
+	var tomlErr toml.ParseError
+
+	switch {
+	case errors.As(err, &jsonErr):
+		at := offsetPosition(data, int(jsonErr.Offset))
+		return &ConfigError{Line: at.line, Column: at.column, Err: jsonErr}
+	case errors.As(err, &tomlErr) && tomlErr.Message != "":
+		return &ConfigError{Line: tomlErr.Position.Line, Column: tomlErr.Position.Col, Err: errors.New(tomlErr.Message)}
+	}
+
+	if match := yamlLineError.FindStringSubmatch(err.Error()); match != nil {
+		line, _ := strconv.Atoi(match[1])
+		return &ConfigError{Line: line, Err: errors.New(match[2])}
+	}
+
+	return &ConfigError{Err: err}
+}
+
+// sortConfigErrors orders the problems of a file by their position, the ones
+// without a position keep their order at the end
+func sortConfigErrors(errs ConfigErrors) {
+	sort.SliceStable(errs, func(i, j int) bool {
+		a, b := errs[i], errs[j]
+		switch {
+		case a.Line == 0 || b.Line == 0:
+			return a.Line != 0 && b.Line == 0
+		case a.Line != b.Line:
+			return a.Line < b.Line
+		}
+
+		return a.Column < b.Column
+	})
+}
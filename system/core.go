@@ -0,0 +1,186 @@
+package system
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORE_KEEP is the default number of core dumps kept per service in CoreDir
+const CORE_KEEP = 3
+
+// CORE_SUFFIX ends the names of core dumps moved to CoreDir, "<service>.<run>.core"
+const CORE_SUFFIX = ".core"
+
+// CORE_PATTERN_PATH is the core_pattern sysctl under the proc root
+const CORE_PATTERN_PATH = "sys/kernel/core_pattern"
+
+func (s *Service) getCoreKeep() int {
+	if s.CoreKeep > 0 {
+		return s.CoreKeep
+	}
+
+	return CORE_KEEP
+}
+
+// collectCore finds the core dump of a process that dumped one, moves it to
+// CoreDir if set and applies the retention of the service to the dumps there
+func (s *Service) collectCore(record *ProcessRecord, p *process) {
+	path, err := findCore(p, record.PID)
+	if err != nil {
+		s.log().warnf("core dumped, not found: %s", err)
+		return
+	}
+
+	if s.CoreDir != "" {
+		moved := filepath.Join(s.CoreDir, fmt.Sprintf("%s.%d%s", s.Name, record.Incarnation, CORE_SUFFIX))
+		if err := os.MkdirAll(s.CoreDir, 0755); err != nil {
+			s.log().warnf("failed to keep core dump: %s", err)
+		} else if err := os.Rename(path, moved); err != nil {
+			s.log().warnf("failed to move core dump: %s", err)
+		} else {
+			path = moved
+		}
+	}
+
+	record.CorePath = path
+	if info, err := os.Stat(path); err == nil {
+		record.CoreSize = info.Size()
+	}
+
+	s.log().infof("core dumped to %s (%d bytes)", record.CorePath, record.CoreSize)
+
+	if s.CoreDir != "" {
+		s.pruneCores()
+	}
+}
+
+// findCore looks for the dump core_pattern names for the process in its working
+// directory, dumps piped to a handler ("|...") are not files the supervisor sees
+func findCore(p *process, pid int) (string, error) {
+	data, err := ioutil.ReadFile(procPath(CORE_PATTERN_PATH))
+	if err != nil {
+		return "", err
+	}
+
+	pattern := strings.TrimSpace(string(data))
+	if strings.HasPrefix(pattern, "|") {
+		return "", fmt.Errorf("dumps are piped to %s", strings.Fields(pattern[1:])[0])
+	}
+
+	glob := coreGlob(pattern, pid, filepath.Base(p.cmd.Path))
+	if !filepath.IsAbs(glob) {
+		dir := p.cmd.Dir
+		if dir == "" {
+			dir, _ = os.Getwd()
+		}
+		glob = filepath.Join(dir, glob)
+	}
+
+	matches, _ := filepath.Glob(glob)
+
+	// the newest dump written since the process started is the one
+	var found string
+	var newest time.Time
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil || info.IsDir() || info.ModTime().Before(p.Created) {
+			continue
+		}
+
+		if info.ModTime().After(newest) {
+			found, newest = match, info.ModTime()
+		}
+	}
+
+	if found == "" {
+		return "", fmt.Errorf("no file matches %s", glob)
+	}
+
+	return found, nil
+}
+
+// coreGlob turns core_pattern into a glob for the process, specifiers that are
+// not known beforehand (time, hostname) match anything
+func coreGlob(pattern string, pid int, exe string) string {
+	// the kernel truncates the command name to 15 bytes
+	if len(exe) > 15 {
+		exe = exe[:15]
+	}
+
+	var b strings.Builder
+	hasPid := false
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' || i+1 == len(pattern) {
+			b.WriteByte(pattern[i])
+			continue
+		}
+
+		i += 1
+		switch pattern[i] {
+		case '%':
+			b.WriteByte('%')
+		case 'p', 'P', 'i', 'I':
+			hasPid = true
+			b.WriteString(strconv.Itoa(pid))
+		case 'e':
+			b.WriteString(exe)
+		default:
+			b.WriteByte('*')
+		}
+	}
+
+	// with core_uses_pid the pid is appended to patterns without %p
+	if !hasPid {
+		if usesPid, err := ioutil.ReadFile(procPath("sys/kernel/core_uses_pid")); err == nil && strings.TrimSpace(string(usesPid)) == "1" {
+			b.WriteString("." + strconv.Itoa(pid))
+		}
+	}
+
+	return b.String()
+}
+
+// pruneCores removes the oldest dumps of the service in CoreDir over CoreKeep
+// or over CoreMaxBytes in total
+func (s *Service) pruneCores() {
+	matches, err := filepath.Glob(filepath.Join(s.CoreDir, s.Name+".*"+CORE_SUFFIX))
+	if err != nil {
+		return
+	}
+
+	type dump struct {
+		path string
+		size int64
+		time time.Time
+	}
+
+	var dumps []dump
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil {
+			dumps = append(dumps, dump{path: match, size: info.Size(), time: info.ModTime()})
+		}
+	}
+
+	// newest first, the ones past the limits are removed
+	sort.Slice(dumps, func(i, j int) bool { return dumps[i].time.After(dumps[j].time) })
+
+	var total int64
+	for i, dump := range dumps {
+		total += dump.size
+		if i < s.getCoreKeep() && (s.CoreMaxBytes <= 0 || total <= s.CoreMaxBytes || i == 0) {
+			continue
+		}
+
+		if err := os.Remove(dump.path); err != nil {
+			s.log().warnf("failed to remove core dump: %s", err)
+			continue
+		}
+
+		s.log().infof("removed core dump %s", dump.path)
+	}
+}
@@ -0,0 +1,162 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+)
+
+// UMASK_INHERIT starts a child with the umask of the supervisor
+const UMASK_INHERIT = -1
+
+var (
+	ErrInvalidCredential = errors.New("invalid credential")
+	ErrNotPermitted      = errors.New("not permitted")
+)
+
+// credential is the user and groups a process runs as
+type credential struct {
+	uid, gid uint32
+	groups   []uint32
+}
+
+// ValidateCredential checks the umask, the user and group are looked up at
+// every start as they may be created after the configuration is read
+func ValidateCredential(config ServiceConfig) error {
+	if _, err := parseUmask(config.Umask); err != nil {
+		return err
+	}
+
+	if config.Umask != "" && runtime.GOOS == "windows" {
+		return fmt.Errorf("%w: umask %q", ErrUnsupportedPlatform, config.Umask)
+	}
+
+	return nil
+}
+
+// parseUmask reads an octal umask like "027", UMASK_INHERIT if empty
+func parseUmask(value string) (int, error) {
+	if value == "" {
+		return UMASK_INHERIT, nil
+	}
+
+	umask, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || umask > 0777 {
+		return UMASK_INHERIT, fmt.Errorf("%w: umask %q, an octal mode like \"027\" is expected", ErrInvalidCredential, value)
+	}
+
+	return int(umask), nil
+}
+
+// getUmask is the umask of the process of the service, it is validated
+func (s *Service) getUmask() int {
+	umask, _ := parseUmask(s.Umask)
+
+	return umask
+}
+
+// credential looks up User and Group by name or id, a user without Group runs
+// with its primary group and its supplementary groups, a Group without User
+// runs as the user of the supervisor. It is nil if neither is set
+func (s *Service) credential() (*credential, error) {
+	if s.User == "" && s.Group == "" {
+		return nil, nil
+	}
+
+	if runtime.GOOS == "windows" {
+		return nil, fmt.Errorf("%w: running as %s", ErrUnsupportedPlatform, s.describeCredential())
+	}
+
+	cred := &credential{uid: uint32(os.Getuid()), gid: uint32(os.Getgid())}
+	if s.User != "" {
+		found, err := lookupUser(s.User)
+		if err != nil {
+			return nil, err
+		}
+
+		uid, _ := strconv.ParseUint(found.Uid, 10, 32)
+		gid, _ := strconv.ParseUint(found.Gid, 10, 32)
+		cred.uid, cred.gid = uint32(uid), uint32(gid)
+
+		if ids, err := found.GroupIds(); err == nil {
+			for _, id := range ids {
+				if group, err := strconv.ParseUint(id, 10, 32); err == nil {
+					cred.groups = append(cred.groups, uint32(group))
+				}
+			}
+		}
+	}
+
+	if s.Group != "" {
+		found, err := lookupGroup(s.Group)
+		if err != nil {
+			return nil, err
+		}
+
+		gid, _ := strconv.ParseUint(found.Gid, 10, 32)
+		cred.gid, cred.groups = uint32(gid), nil
+	}
+
+	// only root changes the user or group of its children
+	if euid := os.Geteuid(); euid != 0 && (int(cred.uid) != euid || int(cred.gid) != os.Getegid()) {
+		return nil, fmt.Errorf("%w: running as %s needs the supervisor to run as root, it runs as uid %d", ErrNotPermitted, s.describeCredential(), euid)
+	}
+
+	return cred, nil
+}
+
+func (s *Service) describeCredential() string {
+	switch {
+	case s.User != "" && s.Group != "":
+		return fmt.Sprintf("user %s and group %s", s.User, s.Group)
+	case s.User != "":
+		return "user " + s.User
+	}
+
+	return "group " + s.Group
+}
+
+// lookupUser finds the user by name, or by id if the name is a number
+func lookupUser(name string) (*user.User, error) {
+	found, err := user.Lookup(name)
+	if _, isId := err.(user.UnknownUserError); isId {
+		if _, numErr := strconv.ParseUint(name, 10, 32); numErr == nil {
+			found, err = user.LookupId(name)
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: user %s: %s", ErrInvalidCredential, name, err)
+	}
+
+	return found, nil
+}
+
+// lookupGroup finds the group by name, or by id if the name is a number
+func lookupGroup(name string) (*user.Group, error) {
+	found, err := user.LookupGroup(name)
+	if _, isId := err.(user.UnknownGroupError); isId {
+		if _, numErr := strconv.ParseUint(name, 10, 32); numErr == nil {
+			found, err = user.LookupGroupId(name)
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: group %s: %s", ErrInvalidCredential, name, err)
+	}
+
+	return found, nil
+}
+
+// owner is the user and group owning the directories of the process, the
+// supervisor's without a credential
+func (c *credential) owner() (int, int) {
+	if c == nil {
+		return os.Getuid(), os.Getgid()
+	}
+
+	return int(c.uid), int(c.gid)
+}
@@ -0,0 +1,24 @@
+//go:build unix
+
+package system
+
+import (
+	"os"
+	"syscall"
+)
+
+// apply sets the credential on the command, a credential the factory set is kept
+func (c *credential) apply(running *process) {
+	if c == nil {
+		return
+	}
+
+	if running.cmd.SysProcAttr == nil {
+		running.cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+
+	// without root the supplementary groups can not be set, they are kept
+	if running.cmd.SysProcAttr.Credential == nil {
+		running.cmd.SysProcAttr.Credential = &syscall.Credential{Uid: c.uid, Gid: c.gid, Groups: c.groups, NoSetGroups: os.Geteuid() != 0}
+	}
+}
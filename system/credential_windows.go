@@ -0,0 +1,4 @@
+package system
+
+// apply has no credential to set, finding one fails on windows
+func (c *credential) apply(running *process) {}
@@ -0,0 +1,249 @@
+package system
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ManagerDefaults are settings of the manager a service gets unless it sets them
+// itself: a field that is not zero or is zero and listed in Explicit is kept, maps
+// are merged with the keys of the service winning and variables of Env come before
+// the ones of the service, so the service overrides them
+type ManagerDefaults struct {
+	RestartDelay   time.Duration
+	RestartPolicy  string
+	StartTimeout   time.Duration
+	StartRetries   int
+	StopTimeout    time.Duration
+	StopSignal     string
+	SampleInterval time.Duration
+	MaxHistory     int
+	JournalSize    int
+	StderrTailSize int
+	OutputPrefix   map[string]string
+	Sanitize       OutputSanitize
+	MemoryMetric   string
+	Env            []string
+	EnvFile        string
+	PassEnv        []string
+	CleanEnv       bool
+	Labels         map[string]string
+	LogForward     LogForward
+}
+
+func (d *ManagerDefaults) UnmarshalJSON(data []byte) error {
+	type defaults ManagerDefaults
+
+	aux := struct {
+		*defaults
+		RestartDelay   duration
+		StartTimeout   duration
+		StopTimeout    duration
+		SampleInterval duration
+	}{defaults: (*defaults)(d)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	d.RestartDelay = time.Duration(aux.RestartDelay)
+	d.StartTimeout = time.Duration(aux.StartTimeout)
+	d.StopTimeout = time.Duration(aux.StopTimeout)
+	d.SampleInterval = time.Duration(aux.SampleInterval)
+
+	return nil
+}
+
+// apply returns the configuration with the defaults it does not set, the keys
+// taken from the defaults are recorded in it
+func (d ManagerDefaults) apply(config ServiceConfig) ServiceConfig {
+	defaults := reflect.ValueOf(d)
+	target := reflect.ValueOf(&config).Elem()
+
+	var defaulted []string
+	for i := 0; i < defaults.NumField(); i++ {
+		name := defaults.Type().Field(i).Name
+		value, field := defaults.Field(i), target.FieldByName(name)
+
+		// a zero value set on purpose keeps defaults out, lists and maps included
+		if value.IsZero() || (isEmpty(field) && config.isExplicit(configKey(name))) {
+			continue
+		}
+
+		switch value.Kind() {
+		case reflect.Map:
+			merged := reflect.MakeMap(value.Type())
+			taken := false
+			for _, key := range value.MapKeys() {
+				merged.SetMapIndex(key, value.MapIndex(key))
+				taken = taken || !field.MapIndex(key).IsValid()
+			}
+			for _, key := range field.MapKeys() {
+				merged.SetMapIndex(key, field.MapIndex(key))
+			}
+
+			field.Set(merged)
+			if !taken {
+				continue
+			}
+
+		case reflect.Slice:
+			joined := reflect.MakeSlice(value.Type(), 0, value.Len()+field.Len())
+			field.Set(reflect.AppendSlice(reflect.AppendSlice(joined, value), field))
+
+		default:
+			// the deprecated Restart sets the restart delay too
+			if !field.IsZero() || (name == "RestartDelay" && config.Restart > 0) {
+				continue
+			}
+
+			field.Set(value)
+		}
+
+		defaulted = append(defaulted, configKey(name))
+	}
+
+	config.defaulted = append(config.defaulted, defaulted...)
+
+	return config
+}
+
+// isEmpty reports whether the value is zero, or an empty list or map as a file
+// writes "env: []"
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Map, reflect.Slice:
+		return value.Len() == 0
+	}
+
+	return value.IsZero()
+}
+
+// isExplicit reports whether the key is listed in Explicit
+func (c ServiceConfig) isExplicit(key string) bool {
+	for _, explicit := range c.Explicit {
+		if strings.EqualFold(explicit, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// explicitKeys returns the ServiceConfig keys of a configuration document, sorted
+func explicitKeys(document configDocument) []string {
+	fields := reflect.TypeOf(ServiceConfig{})
+
+	var keys []string
+	for key := range document {
+		for i := 0; i < fields.NumField(); i++ {
+			field := fields.Field(i)
+			if field.PkgPath == "" && field.Name != "Explicit" && strings.EqualFold(key, field.Name) {
+				keys = append(keys, configKey(field.Name))
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// decodeDefaults converts a "defaults" document into ManagerDefaults, keys
+// without a default are refused
+func decodeDefaults(document configDocument) (ManagerDefaults, error) {
+	var defaults ManagerDefaults
+
+	fields := reflect.TypeOf(defaults)
+	for key := range document {
+		if _, ok := fields.FieldByNameFunc(func(name string) bool { return strings.EqualFold(name, key) }); !ok {
+			return defaults, fmt.Errorf("defaults: %s can not have a default", key)
+		}
+	}
+
+	data, err := json.Marshal(map[string]interface{}(document))
+	if err != nil {
+		return defaults, err
+	}
+
+	if err := json.Unmarshal(data, &defaults); err != nil {
+		return defaults, fmt.Errorf("defaults: %s", err)
+	}
+
+	return defaults, nil
+}
+
+// SetDefaults applies the defaults to the services and to the services added
+// later, it must be called before Run
+func (m *Manager) SetDefaults(defaults ManagerDefaults) error {
+	if err := ValidateLabels(defaults.Labels); err != nil {
+		return fmt.Errorf("defaults: %w", err)
+	}
+
+	if err := validateLogForward(defaults.LogForward); err != nil {
+		return fmt.Errorf("defaults: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.defaults = defaults
+	for _, service := range m.services {
+		service.ServiceConfig = defaults.apply(service.ServiceConfig)
+	}
+
+	for i := range m.groups {
+		m.groups[i] = defaults.apply(m.groups[i])
+	}
+
+	return nil
+}
+
+func (m *Manager) GetDefaults() ManagerDefaults {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.defaults
+}
+
+// EffectiveConfig is the configuration a service runs with: the keys set by it
+// or by defaults, Defaulted lists the ones taken from manager or file defaults
+type EffectiveConfig struct {
+	Name      string                 `json:"name"`
+	Settings  map[string]interface{} `json:"settings"`
+	Defaulted []string               `json:"defaulted,omitempty"`
+}
+
+// EffectiveConfig returns the settings of the service that are not zero or are
+// set explicitly, durations are written as "1m30s"
+func (s *Service) EffectiveConfig() EffectiveConfig {
+	config := EffectiveConfig{Name: s.Name, Settings: make(map[string]interface{})}
+
+	value := reflect.ValueOf(s.ServiceConfig)
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		key := configKey(field.Name)
+		if field.PkgPath != "" || field.Name == "Explicit" {
+			continue
+		}
+
+		if value.Field(i).IsZero() && !s.isExplicit(key) {
+			continue
+		}
+
+		if d, ok := value.Field(i).Interface().(time.Duration); ok {
+			config.Settings[key] = d.String()
+			continue
+		}
+
+		config.Settings[key] = value.Field(i).Interface()
+	}
+
+	config.Defaulted = append(config.Defaulted, s.defaulted...)
+	sort.Strings(config.Defaulted)
+
+	return config
+}
@@ -0,0 +1,235 @@
+package system
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sample returns a value of the type that is not zero, n tells apart values of
+// the defaults and of the service
+func sample(typ reflect.Type, n int) reflect.Value {
+	value := reflect.New(typ).Elem()
+
+	switch {
+	case typ == durationType:
+		value.SetInt(int64(time.Duration(n) * time.Second))
+	case typ.Kind() == reflect.Int:
+		value.SetInt(int64(n))
+	case typ.Kind() == reflect.String:
+		value.SetString(fmt.Sprintf("v%d", n))
+	case typ.Kind() == reflect.Bool:
+		value.SetBool(true)
+	case typ.Kind() == reflect.Map:
+		value.Set(reflect.MakeMap(typ))
+		value.SetMapIndex(reflect.ValueOf("k"), sample(typ.Elem(), n))
+	case typ.Kind() == reflect.Slice:
+		value.Set(reflect.Append(reflect.MakeSlice(typ, 0, 1), sample(typ.Elem(), n)))
+	case typ.Kind() == reflect.Struct:
+		value.Field(0).Set(sample(typ.Field(0).Type, n))
+	default:
+		panic("no sample of " + typ.String())
+	}
+
+	return value
+}
+
+func isDefaulted(config ServiceConfig, key string) bool {
+	for _, defaulted := range config.defaulted {
+		if defaulted == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TestDefaultsOfEveryField applies a default of every ManagerDefaults field to a
+// service not setting it, setting it and setting it to zero on purpose
+func TestDefaultsOfEveryField(t *testing.T) {
+	fields := reflect.TypeOf(ManagerDefaults{})
+
+	for i := 0; i < fields.NumField(); i++ {
+		field := fields.Field(i)
+		key := configKey(field.Name)
+
+		t.Run(key, func(t *testing.T) {
+			if _, ok := reflect.TypeOf(ServiceConfig{}).FieldByName(field.Name); !ok {
+				t.Fatalf("the default %s is no setting of a service", field.Name)
+			}
+
+			var defaults ManagerDefaults
+			defaultValue := sample(field.Type, 1)
+			reflect.ValueOf(&defaults).Elem().Field(i).Set(defaultValue)
+			serviceValue := sample(field.Type, 2)
+
+			get := func(config ServiceConfig) interface{} {
+				return reflect.ValueOf(config).FieldByName(field.Name).Interface()
+			}
+
+			// unset: the default applies
+			config := defaults.apply(ServiceConfig{Name: "web"})
+			if got := get(config); !reflect.DeepEqual(got, defaultValue.Interface()) {
+				t.Errorf("unset: %v, want the default %v", got, defaultValue)
+			}
+			if !isDefaulted(config, key) {
+				t.Errorf("unset: not listed as defaulted in %v", config.defaulted)
+			}
+
+			// set: the service wins, lists are joined with the default first
+			set := ServiceConfig{Name: "web"}
+			reflect.ValueOf(&set).Elem().FieldByName(field.Name).Set(serviceValue)
+			config = defaults.apply(set)
+
+			want := serviceValue.Interface()
+			if field.Type.Kind() == reflect.Slice {
+				want = reflect.AppendSlice(reflect.AppendSlice(reflect.MakeSlice(field.Type, 0, 2), defaultValue), serviceValue).Interface()
+			}
+			if got := get(config); !reflect.DeepEqual(got, want) {
+				t.Errorf("set: %v, want %v", got, want)
+			}
+			if defaulted := isDefaulted(config, key); defaulted != (field.Type.Kind() == reflect.Slice) {
+				t.Errorf("set: listed as defaulted %t in %v", defaulted, config.defaulted)
+			}
+
+			// zero on purpose: the default is kept out
+			config = defaults.apply(ServiceConfig{Name: "web", Explicit: []string{key}})
+			if value := reflect.ValueOf(config).FieldByName(field.Name); !value.IsZero() {
+				t.Errorf("explicit zero: %v, want it kept", value)
+			}
+			if isDefaulted(config, key) {
+				t.Errorf("explicit zero: listed as defaulted")
+			}
+		})
+	}
+}
+
+func TestDefaultsMerge(t *testing.T) {
+	defaults := ManagerDefaults{
+		RestartDelay: 5 * time.Second,
+		Env:          []string{"LOG=info", "REGION=eu"},
+		Labels:       map[string]string{"team": "ops", "tier": "backend"},
+	}
+
+	for _, test := range []struct {
+		name      string
+		config    ServiceConfig
+		delay     time.Duration
+		env       []string
+		labels    map[string]string
+		defaulted []string
+	}{
+		{
+			name:      "nothing set",
+			config:    ServiceConfig{Name: "web"},
+			delay:     5 * time.Second,
+			env:       []string{"LOG=info", "REGION=eu"},
+			labels:    map[string]string{"team": "ops", "tier": "backend"},
+			defaulted: []string{"restartDelay", "env", "labels"},
+		},
+		{
+			name:      "the variables of the service come last",
+			config:    ServiceConfig{Name: "web", Env: []string{"LOG=debug"}},
+			delay:     5 * time.Second,
+			env:       []string{"LOG=info", "REGION=eu", "LOG=debug"},
+			labels:    map[string]string{"team": "ops", "tier": "backend"},
+			defaulted: []string{"restartDelay", "env", "labels"},
+		},
+		{
+			name:      "labels of the service win",
+			config:    ServiceConfig{Name: "web", Labels: map[string]string{"tier": "edge", "app": "web"}},
+			delay:     5 * time.Second,
+			env:       []string{"LOG=info", "REGION=eu"},
+			labels:    map[string]string{"team": "ops", "tier": "edge", "app": "web"},
+			defaulted: []string{"restartDelay", "env", "labels"},
+		},
+		{
+			name:      "labels of the service cover the defaults",
+			config:    ServiceConfig{Name: "web", Labels: map[string]string{"team": "web", "tier": "edge"}},
+			delay:     5 * time.Second,
+			env:       []string{"LOG=info", "REGION=eu"},
+			labels:    map[string]string{"team": "web", "tier": "edge"},
+			defaulted: []string{"restartDelay", "env"},
+		},
+		{
+			name:      "the deprecated restart sets the delay",
+			config:    ServiceConfig{Name: "web", Restart: 3},
+			env:       []string{"LOG=info", "REGION=eu"},
+			labels:    map[string]string{"team": "ops", "tier": "backend"},
+			defaulted: []string{"env", "labels"},
+		},
+		{
+			name:   "zero on purpose",
+			config: ServiceConfig{Name: "web", Explicit: []string{"restartDelay", "env", "labels"}},
+		},
+	} {
+		config := defaults.apply(test.config)
+
+		if config.RestartDelay != test.delay {
+			t.Errorf("%s: restart delay %s, want %s", test.name, config.RestartDelay, test.delay)
+		}
+		if !reflect.DeepEqual(config.Env, test.env) {
+			t.Errorf("%s: env %v, want %v", test.name, config.Env, test.env)
+		}
+		if !reflect.DeepEqual(config.Labels, test.labels) {
+			t.Errorf("%s: labels %v, want %v", test.name, config.Labels, test.labels)
+		}
+		if !reflect.DeepEqual(config.defaulted, test.defaulted) {
+			t.Errorf("%s: defaulted %v, want %v", test.name, config.defaulted, test.defaulted)
+		}
+	}
+}
+
+func TestDefaultsOfAFile(t *testing.T) {
+	dir := writeConfigDir(t, map[string]string{
+		"services.yaml": `
+defaults:
+  stopTimeout: 10s
+  env: ["LOG=info"]
+  labels: {team: ops}
+services:
+  - {name: web, exec: /bin/web}
+  - {name: worker, exec: /bin/worker, stopTimeout: 0, env: [], labels: {}}
+`,
+	})
+
+	configs, err := LoadConfigDir(dir)
+	if err != nil {
+		t.Fatalf("load: %s", err)
+	}
+
+	web, worker := configs[0], configs[1]
+	if web.StopTimeout != 10*time.Second || !reflect.DeepEqual(web.Env, []string{"LOG=info"}) {
+		t.Errorf("web stops within %s with %v, want the defaults", web.StopTimeout, web.Env)
+	}
+	if worker.StopTimeout != 0 || len(worker.Env) != 0 || len(worker.Labels) != 0 {
+		t.Errorf("worker stops within %s with %v %v, want the zero values it sets", worker.StopTimeout, worker.Env, worker.Labels)
+	}
+
+	effective := NewService(worker).EffectiveConfig()
+	if _, ok := effective.Settings["stopTimeout"]; !ok || len(effective.Defaulted) != 0 {
+		t.Errorf("effective config of worker %+v, want stopTimeout set by it", effective)
+	}
+	effective = NewService(web).EffectiveConfig()
+	if !reflect.DeepEqual(effective.Defaulted, []string{"env", "labels", "stopTimeout"}) || effective.Settings["stopTimeout"] != "10s" {
+		t.Errorf("effective config of web %+v, want stopTimeout, env and labels defaulted", effective)
+	}
+
+	_, err = decodeDefaults(configDocument{"exec": "/bin/sh"})
+	if err == nil || !strings.Contains(err.Error(), "exec can not have a default") {
+		t.Errorf("a default of exec: %v", err)
+	}
+}
+
+func TestDefaultsConfigDurations(t *testing.T) {
+	var defaults ManagerDefaults
+	if err := json.Unmarshal([]byte(`{"restartDelay": "1m30s", "stopTimeout": 2}`), &defaults); err != nil {
+		t.Fatalf("unmarshal: %s", err)
+	}
+	if defaults.RestartDelay != 90*time.Second || defaults.StopTimeout != 2*time.Second {
+		t.Errorf("restart delay %s and stop timeout %s, want 1m30s and 2s", defaults.RestartDelay, defaults.StopTimeout)
+	}
+}
@@ -0,0 +1,189 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// default roots of RuntimeDir and StateDir
+const (
+	RUNTIME_ROOT = "/run/systemgo"
+	STATE_ROOT   = "/var/lib/systemgo"
+)
+
+// variables with the paths of RuntimeDir and StateDir, exported if they are set
+const (
+	RUNTIME_DIR_ENV = "SYSTEMGO_RUNTIME_DIR"
+	STATE_DIR_ENV   = "SYSTEMGO_STATE_DIR"
+)
+
+// DIR_MODE of runtime and state directories, only the owner gets in
+const DIR_MODE = 0700
+
+var ErrInvalidDir = errors.New("invalid directory")
+
+// ValidateDir accepts relative paths that stay below their root, like "web" or
+// "web/cache", and an empty path for no directory
+func ValidateDir(dir string) error {
+	if dir == "" {
+		return nil
+	}
+
+	if filepath.IsAbs(dir) || filepath.Clean(dir) != dir || dir == ".." || strings.HasPrefix(dir, "../") {
+		return fmt.Errorf("%q: %w: must be a clean path relative to its root", dir, ErrInvalidDir)
+	}
+
+	return nil
+}
+
+func validateDirs(config ServiceConfig) error {
+	if err := ValidateDir(config.RuntimeDir); err != nil {
+		return fmt.Errorf("runtimeDir %w", err)
+	}
+
+	if err := ValidateDir(config.StateDir); err != nil {
+		return fmt.Errorf("stateDir %w", err)
+	}
+
+	return nil
+}
+
+// SetRuntimeRoot sets the directory RuntimeDir of the services is created in,
+// RUNTIME_ROOT if not set, it must be called before Run
+func (m *Manager) SetRuntimeRoot(dir string) {
+	m.runtimeRoot = dir
+}
+
+// SetStateRoot sets the directory StateDir of the services is created in,
+// STATE_ROOT if not set, it must be called before Run
+func (m *Manager) SetStateRoot(dir string) {
+	m.stateRoot = dir
+}
+
+// runtimePath is the path of RuntimeDir, empty if the service has none
+func (s *Service) runtimePath() string {
+	if s.RuntimeDir == "" {
+		return ""
+	}
+
+	root := s.runtimeRoot
+	if root == "" {
+		root = RUNTIME_ROOT
+	}
+
+	return filepath.Join(root, s.RuntimeDir)
+}
+
+// statePath is the path of StateDir, empty if the service has none
+func (s *Service) statePath() string {
+	if s.StateDir == "" {
+		return ""
+	}
+
+	root := s.stateRoot
+	if root == "" {
+		root = STATE_ROOT
+	}
+
+	return filepath.Join(root, s.StateDir)
+}
+
+// dirsEnv returns the variables with the paths of the directories of the service
+func (s *Service) dirsEnv() []string {
+	var env []string
+	if path := s.runtimePath(); path != "" {
+		env = append(env, RUNTIME_DIR_ENV+"="+path)
+	}
+
+	if path := s.statePath(); path != "" {
+		env = append(env, STATE_DIR_ENV+"="+path)
+	}
+
+	return env
+}
+
+// checkWorkingDir fails the start if WorkingDir is set and is not a directory,
+// it is not created
+func (s *Service) checkWorkingDir() error {
+	if s.WorkingDir == "" {
+		return nil
+	}
+
+	info, err := os.Stat(s.WorkingDir)
+	if err != nil {
+		return fmt.Errorf("working directory: %w", err)
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("working directory %s is not a directory", s.WorkingDir)
+	}
+
+	return nil
+}
+
+// makeDirs creates RuntimeDir and StateDir before a start, owned by the user the
+// process runs as with DIR_MODE, existing ones are taken over
+func (s *Service) makeDirs(cred *credential) error {
+	uid, gid := cred.owner()
+	for _, path := range []string{s.runtimePath(), s.statePath()} {
+		if path == "" {
+			continue
+		}
+
+		if err := makeDir(path, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeDir(path string, uid, gid int) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	if err := os.Mkdir(path, DIR_MODE); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("%s: not a directory", path)
+	}
+
+	if owner, group, ok := fileOwner(info); ok && (owner != uid || group != gid) {
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	if info.Mode().Perm() != DIR_MODE {
+		return os.Chmod(path, DIR_MODE)
+	}
+
+	return nil
+}
+
+// wipeRuntimeDir removes RuntimeDir with everything the process left in it, like
+// sockets and pid files, once the service is stopped if WipeRuntimeDir is set
+func (s *Service) wipeRuntimeDir() {
+	path := s.runtimePath()
+	if path == "" || !s.WipeRuntimeDir {
+		return
+	}
+
+	if err := os.RemoveAll(path); err != nil {
+		s.log().warnf("failed to wipe runtime directory: %s", err)
+		return
+	}
+
+	s.log().infof("wiped runtime directory %s", path)
+}
@@ -0,0 +1,283 @@
+//go:build unix
+
+package system
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// SOCKET_CHILD makes TestSocketChild the supervised process, it listens on a
+// unix socket in its runtime directory and leaves it behind when killed
+const SOCKET_CHILD = "SYSTEMGO_TEST_SOCKET_CHILD"
+
+func TestSocketChild(t *testing.T) {
+	dir := os.Getenv(RUNTIME_DIR_ENV)
+	if os.Getenv(SOCKET_CHILD) == "" || dir == "" {
+		t.Skip("run by the directory tests as the supervised process")
+	}
+
+	if _, err := net.Listen("unix", filepath.Join(dir, "app.sock")); err != nil {
+		os.Exit(2)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "app.pid"), []byte(strconv.Itoa(os.Getpid())), 0600)
+
+	select {}
+}
+
+// withDirs is a service of config with its runtime and state roots in a
+// directory of the test
+func withDirs(t *testing.T, config ServiceConfig) *Service {
+	t.Helper()
+
+	root := t.TempDir()
+	service := NewService(config)
+	service.runtimeRoot = filepath.Join(root, "run")
+	service.stateRoot = filepath.Join(root, "lib")
+
+	return service
+}
+
+// checkDir fails unless path is a directory of DIR_MODE owned by uid
+func checkDir(t *testing.T, path string, uid int) {
+	t.Helper()
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatalf("directory: %s", err)
+	}
+	if !info.IsDir() || info.Mode().Perm() != DIR_MODE {
+		t.Fatalf("%s has mode %s, want a directory of %o", path, info.Mode(), DIR_MODE)
+	}
+	if owner, _, _ := fileOwner(info); owner != uid {
+		t.Fatalf("%s is owned by %d, want %d", path, owner, uid)
+	}
+}
+
+func TestValidateDir(t *testing.T) {
+	for _, dir := range []string{"", "web", "web/cache", "web..cache"} {
+		if err := ValidateDir(dir); err != nil {
+			t.Errorf("%q: %s", dir, err)
+		}
+	}
+
+	for _, dir := range []string{"/web", "..", "../web", "web/../..", "web/", "./web", "web//cache"} {
+		if err := ValidateDir(dir); !errors.Is(err, ErrInvalidDir) {
+			t.Errorf("%q: %v, want ErrInvalidDir", dir, err)
+		}
+	}
+}
+
+func TestDirsOfAStart(t *testing.T) {
+	service := withDirs(t, ServiceConfig{
+		Name:        "dirs",
+		Exec:        "/bin/sh",
+		Params:      []string{"-c", `echo "$SYSTEMGO_RUNTIME_DIR $SYSTEMGO_STATE_DIR"; sleep 30`},
+		RuntimeDir:  "web/run",
+		StateDir:    "web",
+		StopTimeout: time.Second,
+	})
+
+	// an existing state directory keeps its content and loses the open mode
+	state := filepath.Join(service.stateRoot, "web")
+	if err := os.MkdirAll(state, 0755); err != nil {
+		t.Fatalf("state: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(state, "db"), []byte("kept"), 0600); err != nil {
+		t.Fatalf("state: %s", err)
+	}
+
+	out := make(chan string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer service.Wait()
+	defer cancel()
+	if err := service.Start(ctx, out, nil); err != nil {
+		t.Fatalf("start: %s", err)
+	}
+
+	runtime := filepath.Join(service.runtimeRoot, "web", "run")
+	select {
+	case line := <-out:
+		if !strings.HasSuffix(line, "] "+runtime+" "+state) {
+			t.Fatalf("line %q, want the paths of the directories", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no paths printed")
+	}
+
+	checkDir(t, runtime, os.Getuid())
+	checkDir(t, state, os.Getuid())
+	if data, _ := ioutil.ReadFile(filepath.Join(state, "db")); string(data) != "kept" {
+		t.Fatalf("state content %q, want it kept", data)
+	}
+}
+
+func TestDirsOwnedByTheUser(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("the owner is changed by root only")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("no user nobody")
+	}
+	uid, _ := strconv.Atoi(nobody.Uid)
+
+	service := withDirs(t, ServiceConfig{
+		Name:        "owned",
+		Exec:        "sleep",
+		Params:      []string{"30"},
+		User:        "nobody",
+		RuntimeDir:  "owned",
+		StateDir:    "owned",
+		StopTimeout: time.Second,
+	})
+
+	// a directory of another owner, left by an earlier run as root, is taken over
+	if err := os.MkdirAll(filepath.Join(service.runtimeRoot, "owned"), 0777); err != nil {
+		t.Fatalf("runtime: %s", err)
+	}
+
+	startService(t, service)
+	checkDir(t, filepath.Join(service.runtimeRoot, "owned"), uid)
+	checkDir(t, filepath.Join(service.stateRoot, "owned"), uid)
+}
+
+func TestDirCreationFailsTheStart(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		block func(service *Service) error
+	}{
+		{"root is a file", func(service *Service) error {
+			return ioutil.WriteFile(service.runtimeRoot, nil, 0644)
+		}},
+		{"directory is a file", func(service *Service) error {
+			if err := os.MkdirAll(service.runtimeRoot, 0755); err != nil {
+				return err
+			}
+			return ioutil.WriteFile(filepath.Join(service.runtimeRoot, "web"), nil, 0644)
+		}},
+		{"directory is a symlink", func(service *Service) error {
+			if err := os.MkdirAll(service.runtimeRoot, 0755); err != nil {
+				return err
+			}
+			return os.Symlink(os.TempDir(), filepath.Join(service.runtimeRoot, "web"))
+		}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			service := withDirs(t, ServiceConfig{Name: "blocked", Exec: "sleep", Params: []string{"30"}, RuntimeDir: "web", StartRetries: -1})
+			if err := c.block(service); err != nil {
+				t.Fatalf("block: %s", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer service.Wait()
+			defer cancel()
+
+			if err := service.Start(ctx, nil, nil); err == nil {
+				t.Fatal("started without its runtime directory")
+			}
+			if status := service.Status(); status.PID != 0 {
+				t.Fatalf("pid %d without its runtime directory", status.PID)
+			}
+		})
+	}
+}
+
+func TestWipeRuntimeDirOnStop(t *testing.T) {
+	for _, wipe := range []bool{true, false} {
+		service := withDirs(t, ServiceConfig{
+			Name:           "socket",
+			Exec:           os.Args[0],
+			Params:         []string{"-test.run=^TestSocketChild$"},
+			Env:            []string{SOCKET_CHILD + "=1"},
+			RuntimeDir:     "socket",
+			StateDir:       "socket",
+			WipeRuntimeDir: wipe,
+			StopTimeout:    time.Second,
+		})
+		runtime := filepath.Join(service.runtimeRoot, "socket")
+		socket := filepath.Join(runtime, "app.sock")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		if err := service.Start(ctx, nil, nil); err != nil {
+			t.Fatalf("start: %s", err)
+		}
+		eventually(t, 10*time.Second, "the socket", func() bool {
+			_, err := os.Stat(filepath.Join(runtime, "app.pid"))
+			return err == nil
+		})
+
+		if info, err := os.Lstat(socket); err != nil || info.Mode()&os.ModeSocket == 0 {
+			t.Fatalf("wipe %v: socket %v, %v", wipe, info, err)
+		}
+
+		if err := service.send(commandStop); err != nil {
+			t.Fatalf("stop: %s", err)
+		}
+		eventually(t, 5*time.Second, "the stop", func() bool { return service.Status().State == StateStopped })
+
+		// the child died on the signal, its socket is still bound to the path
+		_, err := os.Lstat(runtime)
+		if wipe && !os.IsNotExist(err) {
+			t.Fatalf("runtime directory left after the stop: %v", err)
+		}
+		if !wipe {
+			if info, err := os.Lstat(socket); err != nil || info.Mode()&os.ModeSocket == 0 {
+				t.Fatalf("socket %v, %v, want it kept without WipeRuntimeDir", info, err)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(service.stateRoot, "socket")); err != nil {
+			t.Fatalf("wipe %v: state directory: %s", wipe, err)
+		}
+
+		cancel()
+		service.Wait()
+	}
+}
+
+// TestWipeRuntimeDirAtShutdown stops the child with the supervision loop, the
+// directory is wiped after the process has exited and closed nothing
+func TestWipeRuntimeDirAtShutdown(t *testing.T) {
+	service := withDirs(t, ServiceConfig{
+		Name:           "socket",
+		Exec:           os.Args[0],
+		Params:         []string{"-test.run=^TestSocketChild$"},
+		Env:            []string{SOCKET_CHILD + "=1"},
+		RuntimeDir:     "socket",
+		WipeRuntimeDir: true,
+		StopTimeout:    time.Second,
+	})
+	runtime := filepath.Join(service.runtimeRoot, "socket")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := service.Start(ctx, nil, nil); err != nil {
+		t.Fatalf("start: %s", err)
+	}
+
+	var pid int
+	eventually(t, 10*time.Second, "the socket", func() bool {
+		data, err := ioutil.ReadFile(filepath.Join(runtime, "app.pid"))
+		pid, _ = strconv.Atoi(string(data))
+		return err == nil && pid > 0
+	})
+
+	cancel()
+	service.Wait()
+
+	if syscall.Kill(pid, 0) == nil {
+		t.Fatalf("child %d alive after the shutdown", pid)
+	}
+	if _, err := os.Lstat(runtime); !os.IsNotExist(err) {
+		t.Fatalf("runtime directory left after the shutdown: %v", err)
+	}
+}
@@ -0,0 +1,8 @@
+package system
+
+import "os"
+
+// fileOwner has no user and group ids on windows, the owner is left as is
+func fileOwner(info os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,72 @@
+package system
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// duration accepts either a Go duration string ("250ms", "1m30s") or a number of seconds
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case nil:
+		*d = 0
+	case float64:
+		*d = duration(v * float64(time.Second))
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration: %s", data)
+	}
+
+	return nil
+}
+
+func (c *ServiceConfig) UnmarshalJSON(data []byte) error {
+	type config ServiceConfig
+
+	aux := struct {
+		*config
+		RestartDelay duration
+		StartTimeout duration
+		StopTimeout  duration
+		IdleTimeout  duration
+
+		SlowStartThreshold duration
+		HealthHysteresis   duration
+
+		SampleInterval  duration
+		SampleRetention duration
+
+		RestartMaxDelay    duration
+		StartLimitInterval duration
+	}{config: (*config)(c)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	c.RestartDelay = time.Duration(aux.RestartDelay)
+	c.StartTimeout = time.Duration(aux.StartTimeout)
+	c.StopTimeout = time.Duration(aux.StopTimeout)
+	c.IdleTimeout = time.Duration(aux.IdleTimeout)
+	c.SlowStartThreshold = time.Duration(aux.SlowStartThreshold)
+	c.HealthHysteresis = time.Duration(aux.HealthHysteresis)
+	c.SampleInterval = time.Duration(aux.SampleInterval)
+	c.SampleRetention = time.Duration(aux.SampleRetention)
+	c.RestartMaxDelay = time.Duration(aux.RestartMaxDelay)
+	c.StartLimitInterval = time.Duration(aux.StartLimitInterval)
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package system
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidEnv = errors.New("invalid env")
+
+// ValidateEnv checks the patterns of PassEnv
+func ValidateEnv(config ServiceConfig) error {
+	for _, pattern := range config.PassEnv {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return fmt.Errorf("%w: passEnv pattern %q, a name or a pattern like \"LC_*\" is expected", ErrInvalidEnv, pattern)
+		}
+	}
+
+	return nil
+}
+
+// inheritedEnv returns the variables of the supervisor passed to the process:
+// all of them, none with CleanEnv or only the ones matching PassEnv
+func (s *Service) inheritedEnv() []string {
+	environ := os.Environ()
+	if !s.CleanEnv && len(s.PassEnv) == 0 {
+		return environ
+	}
+
+	var env []string
+	for _, variable := range environ {
+		name := strings.SplitN(variable, "=", 2)[0]
+		for _, pattern := range s.PassEnv {
+			if matched, _ := path.Match(pattern, name); matched {
+				env = append(env, variable)
+				break
+			}
+		}
+	}
+
+	return env
+}
+
+// fileEnv reads the variables of EnvFile at the start, a missing file fails it
+func (s *Service) fileEnv() ([]string, error) {
+	if s.EnvFile == "" {
+		return nil, nil
+	}
+
+	env, err := readEnvFile(s.EnvFile)
+	if err != nil {
+		return nil, fmt.Errorf("env file: %w", err)
+	}
+
+	return env, nil
+}
+
+// readEnvFile reads "KEY=value" lines, blank lines and lines starting with "#"
+// are skipped, "export " before the name is dropped and a value in double
+// quotes is unquoted, in single quotes taken as it is
+func readEnvFile(name string) ([]string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var env []string
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(strings.TrimPrefix(line, "export "), "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%s:%d: %w: KEY=value is expected", name, number, ErrInvalidEnv)
+		}
+
+		value := strings.TrimSpace(parts[1])
+		switch {
+		case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+			if value, err = strconv.Unquote(value); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w: %s", name, number, ErrInvalidEnv, err)
+			}
+		case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		}
+
+		env = append(env, key+"="+value)
+	}
+
+	return env, scanner.Err()
+}
@@ -0,0 +1,127 @@
+package system
+
+import (
+	"sync"
+	"time"
+)
+
+const EVENT_BUFFER_SIZE = 64
+
+// EVENT_LEVEL_WARN marks events that need attention, state changes have no level
+const EVENT_LEVEL_WARN = "warn"
+
+// types of the events: a state change, a message, or what happened to a run of
+// the service, published along with the state changes it comes with
+const (
+	EVENT_STATE   = "state"
+	EVENT_MESSAGE = "message"
+
+	// EVENT_STARTED is a process started or adopted, with its pid
+	EVENT_STARTED = "started"
+	// EVENT_EXITED is a process that ended, with its exit code and stop reason
+	EVENT_EXITED = "exited"
+	// EVENT_RESTARTING is a restart scheduled after the delay
+	EVENT_RESTARTING = "restarting"
+	// EVENT_FAILED is a failed run or start that is not restarted, the message
+	// tells why
+	EVENT_FAILED = "failed"
+	// EVENT_PROBE_FAILED is a probe failing its threshold, the message is the
+	// error of the probe
+	EVENT_PROBE_FAILED = "probe-failed"
+)
+
+type Event struct {
+	Service string `json:"service"`
+	Type    string `json:"type"`
+	// Incarnation is the run of the service the event is about
+	Incarnation int               `json:"incarnation,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	State       State             `json:"state"`
+	PID         int               `json:"pid,omitempty"`
+	ExitCode    int               `json:"exitCode"`
+	Time        time.Time         `json:"time"`
+
+	// Stderr is the end of stderr of the failed or finished process
+	Stderr string `json:"stderr,omitempty"`
+
+	StopReason StopReason `json:"stopReason,omitempty"`
+
+	// CorePath is the core dump of the finished process
+	CorePath string `json:"corePath,omitempty"`
+
+	// Delay is the time until the restart of EVENT_RESTARTING
+	Delay time.Duration `json:"delay,omitempty"`
+
+	Level   string `json:"level,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// lifecycle reports whether the event is about a run of the service, not its
+// state or a message
+func (e Event) lifecycle() bool {
+	return e.Type != EVENT_STATE && e.Type != EVENT_MESSAGE
+}
+
+// eventBus fans events out to subscribers, a subscriber that does not keep up
+// misses events instead of blocking the services
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+func newEventBus() *eventBus {
+	bus := new(eventBus)
+	bus.subscribers = make(map[chan Event]struct{})
+
+	return bus
+}
+
+func (b *eventBus) Subscribe() <-chan Event {
+	ch := make(chan Event, EVENT_BUFFER_SIZE)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch
+}
+
+func (b *eventBus) Unsubscribe(sub <-chan Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		if ch == sub {
+			delete(b.subscribers, ch)
+			close(ch)
+			return
+		}
+	}
+}
+
+func (b *eventBus) Publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			componentLog(LOG_EVENTS, FIELD_SERVICE, event.Service).warnf("subscriber is full, dropping %s event", event.describe())
+		}
+	}
+}
+
+func (e Event) describe() string {
+	if e.Type == EVENT_STATE {
+		return e.State.String()
+	}
+
+	return e.Type
+}
+
+// warn logs the message and publishes it as a warn event of the manager
+func (m *Manager) warn(message string) {
+	managerLog.warnf("%s", message)
+	m.events.Publish(Event{Type: EVENT_MESSAGE, Time: m.Clock().Now(), Level: EVENT_LEVEL_WARN, Message: message})
+}
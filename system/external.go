@@ -0,0 +1,298 @@
+package system
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// SERVICE_EXTERNAL is the type in status of the dependencies only probed
+const SERVICE_EXTERNAL = "external"
+
+// an external dependency is probed every PROBE_INTERVAL, a probe taking longer
+// than PROBE_TIMEOUT fails
+const (
+	PROBE_INTERVAL = 10 * time.Second
+	PROBE_TIMEOUT  = 5 * time.Second
+)
+
+var (
+	ErrExternal        = errors.New("external dependency, it is only probed")
+	ErrInvalidExternal = errors.New("invalid external dependency")
+	ErrInvalidProbe    = errors.New("invalid probe")
+)
+
+// Probe tells whether a dependency not managed by the supervisor, a remote
+// database or a mount, is usable. A service with a Probe and no Exec is such an
+// external dependency: it is Ready while the probe passes and NotReady while it
+// fails, services bound to it are stopped and started again as with any other.
+// Readiness and Liveness of a service that is run are probes too. One of TCP,
+// HTTP, Exec or Path is set
+type Probe struct {
+	// TCP is an address accepting connections, "db.internal:5432"
+	TCP string
+
+	// HTTP is a url answering GET with Status, any status below 400 if not set
+	HTTP   string
+	Status int
+
+	// Exec with Params exits with 0, it is looked up in PATH
+	Exec   string
+	Params []string
+
+	// Path exists, like a file on a mounted share
+	Path string
+
+	// Interval between probes, PROBE_INTERVAL if not set, and Timeout of a probe,
+	// PROBE_TIMEOUT if not set
+	Interval time.Duration
+	Timeout  time.Duration
+
+	// FailureThreshold is the number of failures in a row the probe fails at,
+	// the first one if not set
+	FailureThreshold int
+}
+
+func (p *Probe) UnmarshalJSON(data []byte) error {
+	type probe Probe
+
+	aux := struct {
+		*probe
+		Interval duration
+		Timeout  duration
+	}{probe: (*probe)(p)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	p.Interval = time.Duration(aux.Interval)
+	p.Timeout = time.Duration(aux.Timeout)
+
+	return nil
+}
+
+func (p Probe) GetInterval() time.Duration {
+	if p.Interval > 0 {
+		return p.Interval
+	}
+
+	return PROBE_INTERVAL
+}
+
+func (p Probe) GetTimeout() time.Duration {
+	if p.Timeout > 0 {
+		return p.Timeout
+	}
+
+	return PROBE_TIMEOUT
+}
+
+func (p Probe) GetFailureThreshold() int {
+	if p.FailureThreshold > 0 {
+		return p.FailureThreshold
+	}
+
+	return 1
+}
+
+// kinds returns the checks set, "tcp", "http", "exec" or "path"
+func (p Probe) kinds() []string {
+	var kinds []string
+	for _, kind := range []struct {
+		name string
+		set  bool
+	}{{"tcp", p.TCP != ""}, {"http", p.HTTP != ""}, {"exec", p.Exec != ""}, {"path", p.Path != ""}} {
+		if kind.set {
+			kinds = append(kinds, kind.name)
+		}
+	}
+
+	return kinds
+}
+
+// run probes once within the timeout
+func (p Probe) run(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, p.GetTimeout())
+	defer cancel()
+
+	switch {
+	case p.TCP != "":
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", p.TCP)
+		if err != nil {
+			return err
+		}
+
+		return conn.Close()
+
+	case p.HTTP != "":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.HTTP, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		if (p.Status != 0 && resp.StatusCode != p.Status) || (p.Status == 0 && resp.StatusCode >= 400) {
+			return fmt.Errorf("%s answered %s", p.HTTP, resp.Status)
+		}
+
+		return nil
+
+	case p.Exec != "":
+		return exec.CommandContext(ctx, p.Exec, p.Params...).Run()
+	}
+
+	// a stat of a hung network mount does not return, it is not waited for
+	done := make(chan error, 1)
+	go func() {
+		_, err := os.Stat(p.Path)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("stat %s: %w", p.Path, ctx.Err())
+	}
+}
+
+// ValidateProbe checks the probe of an external dependency, which has nothing
+// to run or to depend on
+func ValidateProbe(config ServiceConfig) error {
+	kinds := config.Probe.kinds()
+	switch {
+	case len(kinds) == 0:
+		return nil
+	case len(kinds) > 1:
+		return fmt.Errorf("%w: the probe sets %v, only one of tcp, http, exec and path is used", ErrInvalidExternal, kinds)
+	case config.Exec != "" || config.Script != "":
+		return fmt.Errorf("%w: a service with a probe has no exec or script, it is not run", ErrInvalidExternal)
+	case IsTemplate(config.Name) || config.Replicas > 0:
+		return fmt.Errorf("%w: templates and replicas are run", ErrInvalidExternal)
+	case len(config.BindsTo) > 0 || len(config.PartOf) > 0 || len(config.After) > 0 || config.StandbyOf != "" || config.PipeTo != "":
+		return fmt.Errorf("%w: it depends on nothing, bindsTo, partOf, after, standbyOf and pipeTo are not used", ErrInvalidExternal)
+	}
+
+	return validateProbeSettings(config.Probe)
+}
+
+// validateProbeSettings checks the interval, timeout, threshold and status of a probe
+func validateProbeSettings(probe Probe) error {
+	switch {
+	case probe.Interval < 0 || probe.Timeout < 0 || probe.FailureThreshold < 0:
+		return fmt.Errorf("%w: the interval, timeout and failureThreshold of the probe must not be negative", ErrInvalidProbe)
+	case probe.Status != 0 && probe.HTTP == "":
+		return fmt.Errorf("%w: status is the one expected of an http probe", ErrInvalidProbe)
+	case probe.Status != 0 && (probe.Status < 100 || probe.Status > 599):
+		return fmt.Errorf("%w: status %d is not an http status", ErrInvalidProbe, probe.Status)
+	}
+
+	return nil
+}
+
+// validateExternals refuses services passing operator stops to an external
+// dependency or standing in for it, the operator does not stop or run it
+func validateExternals(configs []ServiceConfig) error {
+	externals := make(map[string]bool)
+	for _, config := range configs {
+		if len(config.Probe.kinds()) > 0 {
+			externals[config.Name] = true
+		}
+	}
+
+	for _, config := range configs {
+		for _, name := range config.PartOf {
+			if externals[name] {
+				return fmt.Errorf("%s: %w: %s is never stopped or restarted, bind to it instead", config.Name, ErrInvalidExternal, name)
+			}
+		}
+
+		if externals[config.StandbyOf] || externals[config.PipeTo] {
+			return fmt.Errorf("%s: %w: an external dependency has no process", config.Name, ErrInvalidExternal)
+		}
+	}
+
+	return nil
+}
+
+// isExternal reports whether the service is a dependency only probed
+func (s *Service) isExternal() bool {
+	return len(s.Probe.kinds()) > 0
+}
+
+// probeLoop probes the external dependency until ctx is done, it is Ready while
+// the probe passes and NotReady while it fails. firstStart is closed once the
+// first probe is done. Requests are refused, there is nothing to start or stop
+func (s *Service) probeLoop(ctx context.Context, firstStart chan struct{}) {
+	clock := s.getClock()
+	results := make(chan error, 1)
+	probing := false
+	var probeAt time.Duration
+
+	for {
+		ticked(&s.tick, clock)
+
+		if !probing && clock.Monotonic() >= probeAt {
+			probing = true
+			go func() { results <- s.Probe.run(ctx) }()
+		}
+
+		check := clock.NewTimer(time.Second)
+		select {
+		case <-ctx.Done():
+			check.Stop()
+			s.setIntent(intentEnded)
+			if firstStart != nil {
+				close(firstStart)
+			}
+			return
+		case req := <-s.requests:
+			req.result <- ErrExternal
+		case err := <-results:
+			probing = false
+			probeAt = clock.Monotonic() + s.Probe.GetInterval()
+			s.handleProbe(err)
+			if firstStart != nil {
+				close(firstStart)
+				firstStart = nil
+			}
+		case <-check.C():
+		}
+		check.Stop()
+	}
+}
+
+// handleProbe sets the state by the result of a probe, NotReady once it failed
+// FailureThreshold times in a row, a change is logged
+func (s *Service) handleProbe(err error) {
+	s.setProbeErr(err)
+	state := s.getState()
+
+	if err == nil {
+		s.probeFailures = 0
+	} else {
+		s.probeFailures += 1
+	}
+
+	switch {
+	case err == nil && state != StateReady:
+		s.log().infof("probe passed, ready")
+		s.setState(StateReady)
+	case err != nil && state != StateNotReady && (state == StateNew || s.probeFailures >= s.Probe.GetFailureThreshold()):
+		s.log().warnf("probe failed: %s", err)
+		s.setState(StateNotReady)
+	}
+}
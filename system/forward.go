@@ -0,0 +1,582 @@
+package system
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FORWARD_BUFFER is the default number of lines kept for a collector that is not
+// reachable, the oldest ones are dropped beyond it
+const FORWARD_BUFFER = 10000
+
+// reconnects to a collector back off from FORWARD_BACKOFF_MIN to FORWARD_BACKOFF_MAX,
+// writes taking longer than FORWARD_WRITE_TIMEOUT count as failed
+const (
+	FORWARD_BACKOFF_MIN   = 100 * time.Millisecond
+	FORWARD_BACKOFF_MAX   = 30 * time.Second
+	FORWARD_WRITE_TIMEOUT = 5 * time.Second
+	FORWARD_BATCH         = 256
+)
+
+// formats of forwarded lines
+const (
+	FORWARD_TEXT = "text"
+	FORWARD_JSON = "json"
+)
+
+var ErrInvalidLogForward = errors.New("invalid logForward")
+
+// LogForward sends the captured lines of a service to a collector, set in the
+// manager defaults it applies to every service not setting its own
+type LogForward struct {
+	// Address of the collector: "tcp://host:port", "tls://host:port",
+	// "udp://host:port" or "unix:///path", or a file the lines are appended to,
+	// "file:///var/log/app.log", "{service}" in it is the name of the service
+	Address string
+
+	// Format of the lines, "text" (time, service, stream and text) or "json"
+	// (a LogLine), text if not set
+	Format string
+
+	// Buffer is the number of lines kept while the collector is unreachable,
+	// FORWARD_BUFFER if not set
+	Buffer int
+
+	// CAFile verifies the certificate of a tls collector, the system roots if not
+	// set, CertFile and KeyFile are the client certificate. ServerName is verified
+	// instead of the host of the address if set
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+
+	// MinLevel is the lowest level of the lines sent, all of them if not set
+	MinLevel string
+
+	// Fallback of a file that can not be written: "stderr" of the supervisor or
+	// "memory", the lines are then only kept in the output ring of the service,
+	// stderr if not set
+	Fallback string
+
+	// MaxSize and MaxAge rotate a file that would grow over MaxSize bytes or was
+	// written to for MaxAge to "<path>.<time>", gzipped if Compress, keeping the
+	// last Keep of them, FORWARD_FILE_KEEP if not set
+	MaxSize  int64
+	MaxAge   time.Duration
+	Compress bool
+	Keep     int
+}
+
+func (f *LogForward) UnmarshalJSON(data []byte) error {
+	type forward LogForward
+
+	aux := struct {
+		*forward
+		MaxAge duration
+	}{forward: (*forward)(f)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	f.MaxAge = time.Duration(aux.MaxAge)
+
+	return nil
+}
+
+// ForwardStats are the counters of a collector, Dropped lines did not fit the
+// buffer or the output budget, or went to the fallback of a file that is Failing
+type ForwardStats struct {
+	Address   string `json:"address"`
+	Connected bool   `json:"connected"`
+	Failing   bool   `json:"failing"`
+	Buffered  int    `json:"buffered"`
+	// BufferedBytes count against the output budget of the manager
+	BufferedBytes int   `json:"bufferedBytes"`
+	Sent          int64 `json:"sent"`
+	Dropped       int64 `json:"dropped"`
+	DroppedBytes  int64 `json:"droppedBytes"`
+}
+
+func validateLogForward(forward LogForward) error {
+	if forward.Address == "" {
+		return nil
+	}
+
+	if _, _, err := forward.network(); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidLogForward, err)
+	}
+
+	switch forward.Format {
+	case "", FORWARD_TEXT, FORWARD_JSON:
+	default:
+		return fmt.Errorf("%w: unknown format %q", ErrInvalidLogForward, forward.Format)
+	}
+
+	if (forward.CertFile == "") != (forward.KeyFile == "") {
+		return fmt.Errorf("%w: certFile and keyFile go together", ErrInvalidLogForward)
+	}
+
+	if _, ok := ParseLevel(forward.MinLevel); forward.MinLevel != "" && !ok {
+		return fmt.Errorf("%w: unknown minLevel %q, expected one of %s", ErrInvalidLogForward, forward.MinLevel, strings.Join(levels, ", "))
+	}
+
+	switch forward.Fallback {
+	case "", FORWARD_FALLBACK_STDERR, FORWARD_FALLBACK_MEMORY:
+	default:
+		return fmt.Errorf("%w: unknown fallback %q, expected stderr or memory", ErrInvalidLogForward, forward.Fallback)
+	}
+
+	if forward.MaxSize < 0 || forward.MaxAge < 0 || forward.Keep < 0 {
+		return fmt.Errorf("%w: negative rotation settings", ErrInvalidLogForward)
+	}
+
+	network, _, _ := forward.network()
+	if network != "file" && (forward.MaxSize > 0 || forward.MaxAge > 0 || forward.Compress || forward.Keep > 0) {
+		return fmt.Errorf("%w: maxSize, maxAge, compress and keep rotate file:// addresses only", ErrInvalidLogForward)
+	}
+
+	return nil
+}
+
+// forService returns the settings of the service, "{service}" of the address
+// replaced by its name so that each service writes a file of its own
+func (f LogForward) forService(name string) LogForward {
+	f.Address = strings.ReplaceAll(f.Address, "{service}", name)
+
+	return f
+}
+
+// network returns the network and the address to dial of the collector
+func (f LogForward) network() (string, string, error) {
+	u, err := url.Parse(f.Address)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch u.Scheme {
+	case "tcp", "tls", "udp":
+		if u.Host == "" {
+			return "", "", fmt.Errorf("no host in %s", f.Address)
+		}
+		return u.Scheme, u.Host, nil
+	case "unix", "file":
+		if u.Path == "" {
+			return "", "", fmt.Errorf("no path in %s", f.Address)
+		}
+		return u.Scheme, u.Path, nil
+	}
+
+	return "", "", fmt.Errorf("unknown scheme of %s", f.Address)
+}
+
+func (f LogForward) getBuffer() int {
+	if f.Buffer > 0 {
+		return f.Buffer
+	}
+
+	return FORWARD_BUFFER
+}
+
+func (f LogForward) encode(line LogLine) []byte {
+	if f.Format == FORWARD_JSON {
+		data, _ := json.Marshal(line)
+		return append(data, '\n')
+	}
+
+	return []byte(fmt.Sprintf("%s %s %s: %s\n", line.Time.Format(time.RFC3339Nano), line.Service, line.Stream, line.Text))
+}
+
+func (f LogForward) dial() (net.Conn, error) {
+	network, address, err := f.network()
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: FORWARD_WRITE_TIMEOUT}
+	if network != "tls" {
+		return dialer.Dial(network, address)
+	}
+
+	config, err := f.tlsConfig(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return tls.DialWithDialer(dialer, "tcp", address, config)
+}
+
+func (f LogForward) tlsConfig(address string) (*tls.Config, error) {
+	config := &tls.Config{ServerName: f.ServerName}
+	if config.ServerName == "" {
+		host, _, _ := net.SplitHostPort(address)
+		config.ServerName = host
+	}
+
+	if f.CAFile != "" {
+		pem, err := ioutil.ReadFile(f.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in %s", f.CAFile)
+		}
+	}
+
+	if f.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(f.CertFile, f.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// forwarder sends buffered lines to a collector, reconnecting with backoff. Lines
+// of a failed write are sent again, a full buffer drops the oldest
+type forwarder struct {
+	config LogForward
+
+	// warn publishes a warning of the manager, a file switching to its fallback
+	warn func(message string)
+
+	// openFile opens the file of a file address, fileRetry and fileCheck are
+	// FORWARD_FILE_RETRY and FORWARD_FILE_CHECK
+	openFile  func(path string) (logFile, error)
+	fileRetry time.Duration
+	fileCheck time.Duration
+
+	// budget counts the lines buffered, it drops the oldest of them too
+	budget *outputBudget
+	usage  OutputUsage
+
+	mu           sync.Mutex
+	lines        [][]byte
+	connected    bool
+	failing      bool
+	sent         int64
+	dropped      int64
+	droppedBytes int64
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newForwarder(config LogForward, warn func(message string), budget *outputBudget) *forwarder {
+	f := makeForwarder(config, warn)
+	f.budget = budget
+	budget.join(f, &f.usage)
+	go f.run()
+
+	return f
+}
+
+// makeForwarder returns a forwarder not running yet
+func makeForwarder(config LogForward, warn func(message string)) *forwarder {
+	f := new(forwarder)
+	f.config = config
+	f.warn = warn
+	f.openFile = openLogFile
+	f.fileRetry, f.fileCheck = FORWARD_FILE_RETRY, FORWARD_FILE_CHECK
+	f.wake = make(chan struct{}, 1)
+	f.stop = make(chan struct{})
+	f.done = make(chan struct{})
+
+	return f
+}
+
+// send buffers the line, it never waits for the collector, the caller keeps the
+// budget
+func (f *forwarder) send(line LogLine) {
+	if f == nil || !LevelAtLeast(line.Level, f.config.MinLevel) {
+		return
+	}
+
+	data := f.config.encode(line)
+
+	f.mu.Lock()
+	f.push(data)
+	f.mu.Unlock()
+
+	wake(f.wake)
+}
+
+// push appends lines dropping the oldest ones over the buffer, must be called
+// holding f.mu
+func (f *forwarder) push(lines ...[]byte) {
+	f.lines = append(f.lines, lines...)
+	f.budget.charge(f, linesSize(lines))
+	if over := len(f.lines) - f.config.getBuffer(); over > 0 {
+		f.drop(f.lines[:over])
+		f.budget.charge(f, -linesSize(f.lines[:over]))
+		f.lines = append(f.lines[:0], f.lines[over:]...)
+	}
+}
+
+// drop counts the lines as dropped, must be called holding f.mu
+func (f *forwarder) drop(lines [][]byte) {
+	f.dropped += int64(len(lines))
+	for _, line := range lines {
+		f.droppedBytes += int64(len(line))
+	}
+}
+
+// linesSize is the size of encoded lines counted against the budget
+func linesSize(lines [][]byte) int {
+	size := 0
+	for _, line := range lines {
+		size += len(line) + OUTPUT_LINE_OVERHEAD
+	}
+
+	return size
+}
+
+// trim drops the oldest line buffered
+func (f *forwarder) trim() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.lines) == 0 {
+		return false
+	}
+
+	f.drop(f.lines[:1])
+	f.budget.dropped(f, linesSize(f.lines[:1]))
+	f.lines[0] = nil
+	f.lines = f.lines[1:]
+
+	return true
+}
+
+// take returns the next lines to send, waiting for them, none once stopped
+// with nothing left
+func (f *forwarder) take() [][]byte {
+	for {
+		f.mu.Lock()
+		if n := len(f.lines); n > 0 {
+			if n > FORWARD_BATCH {
+				n = FORWARD_BATCH
+			}
+
+			batch := append([][]byte(nil), f.lines[:n]...)
+			f.lines = append(f.lines[:0], f.lines[n:]...)
+			f.budget.charge(f, -linesSize(batch))
+			f.mu.Unlock()
+
+			return batch
+		}
+		f.mu.Unlock()
+
+		select {
+		case <-f.wake:
+		case <-f.stop:
+			return nil
+		}
+	}
+}
+
+// requeue puts the lines of a failed write back in front of the buffer
+func (f *forwarder) requeue(batch [][]byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rest := f.lines
+	f.lines = nil
+	f.push(append(batch, rest...)...)
+}
+
+func (f *forwarder) setConnected(connected bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.connected = connected
+}
+
+func (f *forwarder) run() {
+	defer close(f.done)
+
+	if network, path, _ := f.config.network(); network == "file" {
+		f.runFile(path)
+		return
+	}
+
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	backoff := FORWARD_BACKOFF_MIN
+	for {
+		if conn == nil {
+			dialed, err := f.config.dial()
+			if err != nil {
+				if backoff == FORWARD_BACKOFF_MIN {
+					managerLog.warnf("failed to connect to %s: %s", f.config.Address, err)
+				}
+
+				select {
+				case <-time.After(backoff):
+				case <-f.stop:
+					return
+				}
+
+				if backoff *= 2; backoff > FORWARD_BACKOFF_MAX {
+					backoff = FORWARD_BACKOFF_MAX
+				}
+				continue
+			}
+
+			managerLog.infof("forwarding logs to %s", f.config.Address)
+			conn = dialed
+			backoff = FORWARD_BACKOFF_MIN
+			f.setConnected(true)
+		}
+
+		batch := f.take()
+		if batch == nil {
+			return
+		}
+
+		if err := f.write(conn, batch); err != nil {
+			managerLog.warnf("failed to forward logs to %s: %s", f.config.Address, err)
+			f.requeue(batch)
+			f.setConnected(false)
+			conn.Close()
+			conn = nil
+			continue
+		}
+
+		f.mu.Lock()
+		f.sent += int64(len(batch))
+		f.mu.Unlock()
+	}
+}
+
+// write sends the lines, a datagram each over udp
+func (f *forwarder) write(conn net.Conn, batch [][]byte) error {
+	conn.SetWriteDeadline(time.Now().Add(FORWARD_WRITE_TIMEOUT))
+
+	if _, ok := conn.(*net.UDPConn); ok {
+		for _, line := range batch {
+			if _, err := conn.Write(line); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	var data []byte
+	for _, line := range batch {
+		data = append(data, line...)
+	}
+	_, err := conn.Write(data)
+
+	return err
+}
+
+// close stops the forwarder, the lines not sent yet are dropped
+func (f *forwarder) close() {
+	close(f.stop)
+	<-f.done
+
+	f.mu.Lock()
+	f.drop(f.lines)
+	f.lines = nil
+	f.connected = false
+	f.mu.Unlock()
+
+	f.budget.leave(f)
+}
+
+func (f *forwarder) stats() ForwardStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return ForwardStats{
+		Address:       f.config.Address,
+		Connected:     f.connected,
+		Failing:       f.failing,
+		Buffered:      len(f.lines),
+		BufferedBytes: f.budget.usage(&f.usage).BufferedBytes,
+		Sent:          f.sent,
+		Dropped:       f.dropped,
+		DroppedBytes:  f.droppedBytes,
+	}
+}
+
+// forwarders are the collectors of the manager, services with the same LogForward
+// share one
+type forwarders struct {
+	mu       sync.Mutex
+	byConfig map[LogForward]*forwarder
+
+	// warn and budget are passed to the forwarders created
+	warn   func(message string)
+	budget *outputBudget
+}
+
+func (r *forwarders) get(config LogForward) *forwarder {
+	if config.Address == "" {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.byConfig == nil {
+		r.byConfig = make(map[LogForward]*forwarder)
+	}
+
+	f := r.byConfig[config]
+	if f == nil {
+		f = newForwarder(config, r.warn, r.budget)
+		r.byConfig[config] = f
+	}
+
+	return f
+}
+
+func (r *forwarders) closeAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for config, f := range r.byConfig {
+		f.close()
+		delete(r.byConfig, config)
+	}
+}
+
+// ForwardStats returns the counters of the collectors logs are forwarded to
+func (m *Manager) ForwardStats() []ForwardStats {
+	m.forwarders.mu.Lock()
+	defer m.forwarders.mu.Unlock()
+
+	stats := make([]ForwardStats, 0, len(m.forwarders.byConfig))
+	for _, f := range m.forwarders.byConfig {
+		stats = append(stats, f.stats())
+	}
+
+	sort.SliceStable(stats, func(i, j int) bool {
+		return stats[i].Address < stats[j].Address
+	})
+
+	return stats
+}
@@ -0,0 +1,385 @@
+package system
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector accepts connections of forwarders and reads their lines
+type collector struct {
+	listener net.Listener
+	lines    chan string
+	wg       sync.WaitGroup
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+// collect listens on the address until close, a listener of tls if config is set
+func collect(t *testing.T, network, address string, config *tls.Config) *collector {
+	t.Helper()
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	if config != nil {
+		listener = tls.NewListener(listener, config)
+	}
+
+	c := &collector{listener: listener, lines: make(chan string, 10000)}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			c.mu.Lock()
+			c.conns = append(c.conns, conn)
+			c.mu.Unlock()
+
+			c.wg.Add(1)
+			go func() {
+				defer c.wg.Done()
+
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					c.lines <- scanner.Text()
+				}
+			}()
+		}
+	}()
+	t.Cleanup(c.close)
+
+	return c
+}
+
+// close stops listening and drops the connections, the collector goes away
+func (c *collector) close() {
+	c.listener.Close()
+
+	c.mu.Lock()
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+	c.conns = nil
+	c.mu.Unlock()
+
+	c.wg.Wait()
+}
+
+// receive returns the next n lines
+func (c *collector) receive(t *testing.T, n int) []string {
+	t.Helper()
+
+	var lines []string
+	for len(lines) < n {
+		select {
+		case line := <-c.lines:
+			lines = append(lines, line)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d lines, want %d", len(lines), n)
+		}
+	}
+
+	return lines
+}
+
+// forwarding runs a forwarder of the config until the test ends
+func forwarding(t *testing.T, config LogForward) *forwarder {
+	t.Helper()
+
+	f := makeForwarder(config, func(string) {})
+	go f.run()
+	t.Cleanup(f.close)
+
+	return f
+}
+
+func numbered(f *forwarder, from, to int) {
+	for i := from; i < to; i++ {
+		f.send(LogLine{Service: "web", Stream: "stdout", Text: fmt.Sprintf("line %d", i), Time: time.Now()})
+	}
+}
+
+func TestValidateLogForward(t *testing.T) {
+	for _, forward := range []LogForward{
+		{},
+		{Address: "tcp://127.0.0.1:514"},
+		{Address: "tls://logs:6514", CertFile: "client.pem", KeyFile: "client.key"},
+		{Address: "udp://logs:514", Format: FORWARD_JSON},
+		{Address: "unix:///run/vector.sock", MinLevel: "warn"},
+	} {
+		if err := validateLogForward(forward); err != nil {
+			t.Errorf("%+v: %s", forward, err)
+		}
+	}
+
+	for _, forward := range []LogForward{
+		{Address: "http://logs:80"},
+		{Address: "tcp://"},
+		{Address: "unix://"},
+		{Address: "tcp://logs:514", Format: "xml"},
+		{Address: "tls://logs:6514", CertFile: "client.pem"},
+		{Address: "tcp://logs:514", MinLevel: "loud"},
+		{Address: "tcp://logs:514", MaxSize: 1 << 20},
+	} {
+		if err := validateLogForward(forward); !errors.Is(err, ErrInvalidLogForward) {
+			t.Errorf("%+v: %v, want ErrInvalidLogForward", forward, err)
+		}
+	}
+}
+
+func TestEncodeForwardedLine(t *testing.T) {
+	at := time.Date(2026, 10, 14, 8, 30, 0, 5, time.UTC)
+	line := LogLine{Service: "web", Stream: "stderr", Text: "failed", Time: at, Level: "error"}
+
+	if got := (LogForward{}).encode(line); string(got) != "2026-10-14T08:30:00.000000005Z web stderr: failed\n" {
+		t.Fatalf("text line %q", got)
+	}
+
+	var decoded LogLine
+	data := (LogForward{Format: FORWARD_JSON}).encode(line)
+	if err := json.Unmarshal(data, &decoded); err != nil || !decoded.Time.Equal(at) || decoded.Text != "failed" || decoded.Level != "error" {
+		t.Fatalf("json line %q: %v", data, err)
+	}
+}
+
+// TestForwardAcrossACollectorRestart takes the collector away while lines are
+// sent, the buffer keeps the newest of them for its return
+func TestForwardAcrossACollectorRestart(t *testing.T) {
+	goroutines, fds := baseline(t)
+	address := freePort(t)
+	c := collect(t, "tcp", address, nil)
+
+	f := makeForwarder(LogForward{Address: "tcp://" + address, Buffer: 100}, func(string) {})
+	go f.run()
+	numbered(f, 0, 50)
+	if lines := c.receive(t, 50); !strings.HasSuffix(lines[0], ": line 0") || !strings.HasSuffix(lines[49], ": line 49") {
+		t.Fatalf("lines %q ... %q", lines[0], lines[49])
+	}
+
+	// a write into the closed connection may still pass, the next ones fail
+	c.close()
+	total := 50
+	eventually(t, 5*time.Second, "the collector gone", func() bool {
+		numbered(f, total, total+1)
+		total++
+		return !f.stats().Connected
+	})
+
+	numbered(f, total, total+300)
+	total += 300
+
+	stats := f.stats()
+	if stats.Buffered != 100 || stats.Sent+stats.Dropped+int64(stats.Buffered) != int64(total) {
+		t.Fatalf("stats %+v of %d lines, want the buffer full and every line counted", stats, total)
+	}
+	if stats.Dropped < 300-100 {
+		t.Fatalf("%d lines dropped, want at least the 200 beyond the buffer", stats.Dropped)
+	}
+
+	// back on the same address the buffer is sent in order, the newest lines
+	c = collect(t, "tcp", address, nil)
+	lines := c.receive(t, 100)
+	for i, line := range lines {
+		if want := fmt.Sprintf("web stdout: line %d", total-100+i); !strings.HasSuffix(line, want) {
+			t.Fatalf("line %d after the restart %q, want %q", i, line, want)
+		}
+	}
+	eventually(t, 5*time.Second, "the buffer sent", func() bool {
+		stats := f.stats()
+		return stats.Connected && stats.Buffered == 0 && stats.Sent+stats.Dropped == int64(total)
+	})
+
+	f.close()
+	c.close()
+	if !settles(goroutines, fds, t) {
+		t.Fatal("goroutines or descriptors left after the forwarder closed")
+	}
+}
+
+func TestForwardNeverBlocks(t *testing.T) {
+	// the collector accepts and never reads, the writes stall
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	f := forwarding(t, LogForward{Address: "tcp://" + listener.Addr().String(), Buffer: 1000})
+	line := LogLine{Service: "web", Stream: "stdout", Text: strings.Repeat("x", 1024), Time: time.Now()}
+
+	// a send waiting for a write would wait for its timeout
+	started := time.Now()
+	for i := 0; i < 5000; i++ {
+		f.send(line)
+	}
+	if took := time.Since(started); took > FORWARD_WRITE_TIMEOUT {
+		t.Fatalf("5000 sends took %s with a stalled collector", took)
+	}
+
+	if stats := f.stats(); stats.Buffered > 1000 || stats.Dropped == 0 {
+		t.Fatalf("stats %+v, want the buffer bounded and lines dropped", stats)
+	}
+
+	// closed, the stalled write fails and the forwarder ends
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("the forwarder did not connect")
+	}
+}
+
+func TestForwardOverUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer conn.Close()
+
+	f := forwarding(t, LogForward{Address: "udp://" + conn.LocalAddr().String()})
+	numbered(f, 0, 3)
+
+	// a datagram a line
+	buffer := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < 3; i++ {
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			t.Fatalf("datagram %d: %s", i, err)
+		}
+		if got := string(buffer[:n]); !strings.HasSuffix(got, fmt.Sprintf("web stdout: line %d\n", i)) || strings.Count(got, "\n") != 1 {
+			t.Fatalf("datagram %d %q", i, got)
+		}
+	}
+}
+
+func TestForwardJSONToUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "collector.sock")
+	c := collect(t, "unix", path, nil)
+
+	f := forwarding(t, LogForward{Address: "unix://" + path, Format: FORWARD_JSON, MinLevel: "warn"})
+	f.send(LogLine{Service: "web", Stream: "stdout", Text: "chatty", Level: "info", Time: time.Now()})
+	f.send(LogLine{Service: "web", Stream: "stderr", Text: "disk full", Level: "error", Time: time.Now()})
+
+	// the line below MinLevel is not sent
+	var line LogLine
+	if err := json.Unmarshal([]byte(c.receive(t, 1)[0]), &line); err != nil || line.Text != "disk full" || line.Stream != "stderr" {
+		t.Fatalf("line %+v: %v", line, err)
+	}
+}
+
+// certificates writes a CA, a server certificate of 127.0.0.1 and a client one
+// signed by it as ca.pem, server.pem, server.key, client.pem and client.key
+func certificates(t *testing.T, dir string) *x509.CertPool {
+	t.Helper()
+
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "collectors"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("ca: %s", err)
+	}
+	ca, _ = x509.ParseCertificate(caDER)
+
+	write := func(name, kind string, der []byte) {
+		data := pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der})
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+	}
+	write("ca.pem", "CERTIFICATE", caDER)
+
+	for i, name := range []string{"server", "client"} {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		cert := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 2)),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, cert, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		keyDER, _ := x509.MarshalECPrivateKey(key)
+		write(name+".pem", "CERTIFICATE", der)
+		write(name+".key", "EC PRIVATE KEY", keyDER)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	return pool
+}
+
+func TestForwardOverTLS(t *testing.T) {
+	dir := t.TempDir()
+	pool := certificates(t, dir)
+	server, err := tls.LoadX509KeyPair(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"))
+	if err != nil {
+		t.Fatalf("server certificate: %s", err)
+	}
+
+	// the collector wants a client certificate of its CA
+	address := freePort(t)
+	c := collect(t, "tcp", address, &tls.Config{Certificates: []tls.Certificate{server}, ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert})
+
+	f := forwarding(t, LogForward{
+		Address:  "tls://" + address,
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		CertFile: filepath.Join(dir, "client.pem"),
+		KeyFile:  filepath.Join(dir, "client.key"),
+	})
+	numbered(f, 0, 1)
+	if line := c.receive(t, 1)[0]; !strings.HasSuffix(line, "web stdout: line 0") {
+		t.Fatalf("line %q", line)
+	}
+
+	// a collector the CA does not vouch for is not sent to
+	untrusted := forwarding(t, LogForward{Address: "tls://" + address, ServerName: "logs.example.com", CAFile: filepath.Join(dir, "ca.pem")})
+	numbered(untrusted, 0, 1)
+	time.Sleep(200 * time.Millisecond)
+	if stats := untrusted.stats(); stats.Connected || stats.Sent != 0 || stats.Buffered != 1 {
+		t.Fatalf("stats %+v of a collector of another name", stats)
+	}
+}
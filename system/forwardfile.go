@@ -0,0 +1,298 @@
+package system
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fallbacks of LogForward files that can not be written
+const (
+	FORWARD_FALLBACK_STDERR = "stderr"
+	FORWARD_FALLBACK_MEMORY = "memory"
+)
+
+// a file that can not be written is opened again every FORWARD_FILE_RETRY, one
+// written to is checked every FORWARD_FILE_CHECK to still be the file at its path
+const (
+	FORWARD_FILE_RETRY = 5 * time.Second
+	FORWARD_FILE_CHECK = time.Second
+)
+
+// FORWARD_FILE_KEEP is the default number of rotated files kept, named after
+// their rotation time in FORWARD_ROTATED_TIME
+const (
+	FORWARD_FILE_KEEP    = 5
+	FORWARD_ROTATED_TIME = "20060102-150405.000"
+)
+
+// logFile is a file lines are appended to, an *os.File
+type logFile interface {
+	io.WriteCloser
+	Stat() (os.FileInfo, error)
+}
+
+func openLogFile(path string) (logFile, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+}
+
+func (f LogForward) getFallback() string {
+	if f.Fallback != "" {
+		return f.Fallback
+	}
+
+	return FORWARD_FALLBACK_STDERR
+}
+
+func (f LogForward) getKeep() int {
+	if f.Keep > 0 {
+		return f.Keep
+	}
+
+	return FORWARD_FILE_KEEP
+}
+
+// runFile appends the lines to the file at path. A failed open or write (a full
+// disk, a read-only or removed directory) switches to the fallback with a single
+// warning until the file opens again, tried every FORWARD_FILE_RETRY. A file
+// removed or replaced meanwhile is opened again by its path. The file is rotated
+// before the batch that would take it over MaxSize, or once it was written to
+// for MaxAge since it was opened
+func (f *forwarder) runFile(path string) {
+	var file logFile
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	var size int64
+	var openedAt, retryAt time.Time
+	checkAt := time.Now().Add(f.fileCheck)
+	for {
+		batch := f.take()
+		if batch == nil {
+			return
+		}
+
+		now := time.Now()
+		if file != nil && !now.Before(checkAt) {
+			checkAt = now.Add(f.fileCheck)
+			if !isSameFile(file, path) {
+				managerLog.warnf("%s was removed or replaced, opening it again", path)
+				file.Close()
+				file, retryAt = nil, time.Time{}
+			}
+		}
+
+		if file != nil && f.config.rotationDue(size, batchSize(batch), now.Sub(openedAt)) {
+			file.Close()
+			file = nil
+			if err := f.config.rotate(path, now); err != nil {
+				managerLog.warnf("failed to rotate %s: %s", path, err)
+			}
+		}
+
+		if file == nil && !now.Before(retryAt) {
+			opened, err := f.openFile(path)
+			if err != nil {
+				f.failover(path, err)
+				retryAt = now.Add(f.fileRetry)
+			} else {
+				file, size, openedAt = opened, 0, now
+				if info, err := opened.Stat(); err == nil {
+					size = info.Size()
+				}
+				f.resume(path)
+			}
+		}
+
+		if file != nil {
+			err := writeLines(file, batch)
+			if err == nil {
+				size += batchSize(batch)
+				f.mu.Lock()
+				f.sent += int64(len(batch))
+				f.mu.Unlock()
+
+				continue
+			}
+
+			file.Close()
+			file = nil
+			f.failover(path, err)
+			retryAt = now.Add(f.fileRetry)
+		}
+
+		f.fallback(batch)
+	}
+}
+
+func writeLines(file io.Writer, batch [][]byte) error {
+	var data []byte
+	for _, line := range batch {
+		data = append(data, line...)
+	}
+	_, err := file.Write(data)
+
+	return err
+}
+
+func batchSize(batch [][]byte) int64 {
+	var size int64
+	for _, line := range batch {
+		size += int64(len(line))
+	}
+
+	return size
+}
+
+// rotationDue reports whether a file of size written to for age is rotated
+// before adding next bytes, a file too small to fit a single batch is not
+func (f LogForward) rotationDue(size, next int64, age time.Duration) bool {
+	return (f.MaxSize > 0 && size > 0 && size+next > f.MaxSize) || (f.MaxAge > 0 && size > 0 && age >= f.MaxAge)
+}
+
+// rotate renames the file after the time, gzips it if Compress and removes the
+// rotated files beyond Keep, the oldest first
+func (f LogForward) rotate(path string, now time.Time) error {
+	rotated := path + "." + now.Format(FORWARD_ROTATED_TIME)
+	if err := os.Rename(path, rotated); err != nil {
+		return err
+	}
+
+	if f.Compress {
+		if err := gzipFile(rotated); err != nil {
+			managerLog.warnf("failed to compress %s: %s", rotated, err)
+		}
+	}
+
+	return pruneRotated(path, f.getKeep())
+}
+
+// gzipFile replaces the file by its gzipped copy "<path>.gz"
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// pruneRotated removes the rotated files of path beyond the keep last ones,
+// gzipped or not
+func pruneRotated(path string, keep int) error {
+	entries, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	prefix := filepath.Base(path) + "."
+	var rotated []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".gz")
+		if _, err := time.Parse(FORWARD_ROTATED_TIME, stamp); err == nil {
+			rotated = append(rotated, name)
+		}
+	}
+
+	// the names sort by their time
+	sort.Strings(rotated)
+	for len(rotated) > keep {
+		if err := os.Remove(filepath.Join(filepath.Dir(path), rotated[0])); err != nil {
+			return err
+		}
+		rotated = rotated[1:]
+	}
+
+	return nil
+}
+
+// isSameFile reports whether path still names the opened file
+func isSameFile(file logFile, path string) bool {
+	opened, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	current, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(opened, current)
+}
+
+// failover marks the file failing, only the first failure of an outage is reported
+func (f *forwarder) failover(path string, err error) {
+	f.mu.Lock()
+	failing := f.failing
+	f.failing, f.connected = true, false
+	f.mu.Unlock()
+
+	if failing {
+		return
+	}
+
+	message := fmt.Sprintf("failed to write logs to %s: %s, writing them to %s until it works again", path, err, f.config.getFallback())
+	if f.warn != nil {
+		f.warn(message)
+	} else {
+		managerLog.warnf("%s", message)
+	}
+}
+
+func (f *forwarder) resume(path string) {
+	f.mu.Lock()
+	failing := f.failing
+	f.failing, f.connected = false, true
+	f.mu.Unlock()
+
+	if failing {
+		managerLog.infof("writing logs to %s again", path)
+	}
+}
+
+// fallback writes the lines the file did not take to stderr, they are counted as
+// dropped either way
+func (f *forwarder) fallback(batch [][]byte) {
+	if f.config.getFallback() == FORWARD_FALLBACK_STDERR {
+		writeLines(os.Stderr, batch)
+	}
+
+	f.mu.Lock()
+	f.drop(batch)
+	f.mu.Unlock()
+}
@@ -0,0 +1,213 @@
+package system
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fileForwarder runs a forwarder to the file in memory fallback, retrying and
+// checking the file every 20ms, with the warnings it publishes
+func fileForwarder(t *testing.T, path string, open func(path string) (logFile, error)) (*forwarder, func() []string) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var warnings []string
+	f := makeForwarder(LogForward{Address: "file://" + path, Fallback: FORWARD_FALLBACK_MEMORY}, func(message string) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		warnings = append(warnings, message)
+	})
+	if open != nil {
+		f.openFile = open
+	}
+	f.fileRetry, f.fileCheck = 20*time.Millisecond, 20*time.Millisecond
+
+	go f.run()
+	t.Cleanup(f.close)
+
+	return f, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return append([]string(nil), warnings...)
+	}
+}
+
+// forward sends a line and waits for it to be written or dropped
+func forward(t *testing.T, f *forwarder, text string) {
+	t.Helper()
+
+	before := f.stats()
+	f.send(LogLine{Service: "web", Stream: "stdout", Text: text, Time: time.Now()})
+	eventually(t, 5*time.Second, "the line "+text, func() bool {
+		stats := f.stats()
+		return stats.Sent+stats.Dropped > before.Sent+before.Dropped
+	})
+}
+
+func contents(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %s", path, err)
+	}
+
+	return string(data)
+}
+
+// failingFile fails its writes with the error of its disk while set
+type failingFile struct {
+	*os.File
+	disk *failingDisk
+}
+
+type failingDisk struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (d *failingDisk) fail(err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.err = err
+}
+
+func (d *failingDisk) failure() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.err
+}
+
+func (d *failingDisk) open(path string) (logFile, error) {
+	if err := d.failure(); err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+
+	file, err := openLogFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return failingFile{file.(*os.File), d}, nil
+}
+
+func (f failingFile) Write(data []byte) (int, error) {
+	if err := f.disk.failure(); err != nil {
+		return 0, &os.PathError{Op: "write", Path: f.Name(), Err: err}
+	}
+
+	return f.File.Write(data)
+}
+
+// TestFileForwardFailover fills the disk of the file and remounts it read-only,
+// the lines go to the fallback with a single warning until the file works again
+func TestFileForwardFailover(t *testing.T) {
+	for _, errno := range []syscall.Errno{syscall.ENOSPC, syscall.EROFS} {
+		t.Run(errno.Error(), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "web.log")
+			disk := new(failingDisk)
+			f, warnings := fileForwarder(t, path, disk.open)
+
+			forward(t, f, "before")
+
+			disk.fail(errno)
+			for _, text := range []string{"lost 1", "lost 2", "lost 3"} {
+				forward(t, f, text)
+			}
+
+			stats := f.stats()
+			if !stats.Failing || stats.Connected || stats.Dropped != 3 || stats.DroppedBytes == 0 {
+				t.Errorf("stats %+v, want the file failing with 3 lines dropped", stats)
+			}
+			if warned := warnings(); len(warned) != 1 || !strings.Contains(warned[0], errno.Error()) || !strings.Contains(warned[0], "writing them to memory") {
+				t.Errorf("warnings %q, want a single one of %s", warned, errno)
+			}
+
+			// the file is opened again once it can be written
+			disk.fail(nil)
+			eventually(t, 5*time.Second, "the resumed file", func() bool {
+				forward(t, f, "after")
+				return !f.stats().Failing
+			})
+
+			stats = f.stats()
+			if !stats.Connected || stats.Dropped < 3 {
+				t.Errorf("stats %+v, want the file written to again and the drops kept", stats)
+			}
+			if content := contents(t, path); !strings.Contains(content, "before") || !strings.Contains(content, "after") || strings.Contains(content, "lost") {
+				t.Errorf("file %q, want the lines before and after the outage alone", content)
+			}
+			if warned := warnings(); len(warned) != 1 {
+				t.Errorf("warnings %q, want the one of the outage", warned)
+			}
+		})
+	}
+}
+
+// TestFileForwardFullDevice writes to /dev/full, a real ENOSPC
+func TestFileForwardFullDevice(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skipf("no /dev/full: %s", err)
+	}
+
+	f, warnings := fileForwarder(t, "/dev/full", nil)
+	forward(t, f, "lost 1")
+	forward(t, f, "lost 2")
+
+	if stats := f.stats(); !stats.Failing || stats.Sent != 0 || stats.Dropped != 2 {
+		t.Errorf("stats %+v, want 2 lines dropped", stats)
+	}
+	if warned := warnings(); len(warned) != 1 || !strings.Contains(warned[0], syscall.ENOSPC.Error()) {
+		t.Errorf("warnings %q, want a single one of %s", warned, syscall.ENOSPC)
+	}
+}
+
+// TestFileForwardRecreatedDirectory removes the directory of the file and makes
+// it again, the file is created again in it
+func TestFileForwardRecreatedDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatalf("mkdir: %s", err)
+	}
+	path := filepath.Join(dir, "web.log")
+	f, warnings := fileForwarder(t, path, nil)
+
+	forward(t, f, "before")
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatalf("remove: %s", err)
+	}
+
+	// the removal is noticed at the next check of the file
+	eventually(t, 5*time.Second, "the failing file", func() bool {
+		forward(t, f, "lost")
+		return f.stats().Failing
+	})
+	if warned := warnings(); len(warned) != 1 || !strings.Contains(warned[0], path) {
+		t.Errorf("warnings %q, want a single one of %s", warned, path)
+	}
+
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatalf("mkdir: %s", err)
+	}
+	eventually(t, 5*time.Second, "the resumed file", func() bool {
+		forward(t, f, "after")
+		return !f.stats().Failing
+	})
+
+	if content := contents(t, path); !strings.Contains(content, "after") || strings.Contains(content, "before") {
+		t.Errorf("file %q, want the lines written since it was created again", content)
+	}
+	if warned := warnings(); len(warned) != 1 {
+		t.Errorf("warnings %q, want the one of the outage", warned)
+	}
+}
@@ -0,0 +1,74 @@
+package system
+
+import "errors"
+
+var (
+	ErrFrozen    = errors.New("service is frozen")
+	ErrNotFrozen = errors.New("service is not frozen")
+	ErrNoProcess = errors.New("service has no running process")
+)
+
+// Freeze pauses the process of the service and its children with SIGSTOP, a
+// group name freezes all of its members. A frozen service keeps its process and
+// memory, its readiness probe, idle timeout and output triggers wait for Thaw
+func (m *Manager) Freeze(name string) error {
+	return m.each(name, func(service *Service) error {
+		return service.send(commandFreeze)
+	})
+}
+
+// Thaw resumes the frozen process of the service with SIGCONT, a group name thaws
+// all of its members
+func (m *Manager) Thaw(name string) error {
+	return m.each(name, func(service *Service) error {
+		return service.send(commandThaw)
+	})
+}
+
+// freeze stops the running process tree, the state it was frozen in is taken
+// back by thaw
+func (s *Service) freeze() error {
+	switch {
+	case s.frozen:
+		return ErrFrozen
+	case !s.IsRunning() || s.state == StateStopping:
+		return ErrNoProcess
+	}
+
+	if err := s.freezeTree(); err != nil {
+		return err
+	}
+
+	s.log().infof("frozen")
+	s.frozen = true
+	s.frozenFrom = s.state
+	s.setState(StateFrozen)
+
+	return nil
+}
+
+func (s *Service) thaw() error {
+	if !s.frozen {
+		return ErrNotFrozen
+	}
+
+	s.resume()
+	s.log().infof("thawed")
+	s.setState(s.frozenFrom)
+
+	// a readiness probe that failed while frozen starts over
+	if s.probeOnThaw {
+		s.probeOnThaw = false
+		s.waitReady(s.running)
+	}
+
+	return nil
+}
+
+// resume continues the process tree of a frozen service, before it is stopped too
+func (s *Service) resume() {
+	if err := s.thawTree(); err != nil {
+		s.log().warnf("failed to thaw: %s", err)
+	}
+	s.frozen = false
+}
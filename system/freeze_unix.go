@@ -0,0 +1,40 @@
+//go:build unix
+
+package system
+
+import "syscall"
+
+// freezeTree stops the process tree with SIGSTOP
+func (s *Service) freezeTree() error {
+	return s.signalTree(syscall.SIGSTOP)
+}
+
+// thawTree continues the process tree with SIGCONT
+func (s *Service) thawTree() error {
+	return s.signalTree(syscall.SIGCONT)
+}
+
+// signalTree signals the process group of the process, or without a group of its
+// own the process and its descendants found in /proc, parents first so a
+// stopped parent forks no more children meanwhile. Without /proc only the
+// process of an ungrouped service is signaled
+func (s *Service) signalTree(sig syscall.Signal) error {
+	if s.running.grouped {
+		return s.running.signal(sig)
+	}
+
+	pids, err := processTree(s.running.GetPid())
+	if err != nil {
+		pids = []int{s.running.GetPid()}
+	}
+
+	if err := syscall.Kill(pids[0], sig); err != nil {
+		return err
+	}
+
+	for _, pid := range pids[1:] {
+		syscall.Kill(pid, sig)
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+//go:build unix
+
+package system
+
+import (
+	"testing"
+	"time"
+)
+
+// groupCPU sums the cpu time of the processes of the process group pgid
+func groupCPU(t *testing.T, pgid int) time.Duration {
+	t.Helper()
+
+	processes, err := listProcesses()
+	if err != nil {
+		t.Fatalf("processes: %s", err)
+	}
+
+	var cpu time.Duration
+	for _, process := range processes {
+		if process.PGID == pgid {
+			cpu += process.UserCPU + process.SystemCPU
+		}
+	}
+
+	return cpu
+}
+
+// burning spins two children of a shell waiting for them
+func burning(name string) ServiceConfig {
+	return ServiceConfig{
+		Name:        name,
+		Exec:        "/bin/sh",
+		Params:      []string{"-c", "while :; do :; done & while :; do :; done & wait"},
+		StopTimeout: time.Second,
+	}
+}
+
+// TestFreezeStopsTheTree freezes a shell burning cpu in two children, the cpu
+// time of the tree stands still until it is thawed, also where /proc is hidden
+func TestFreezeStopsTheTree(t *testing.T) {
+	if !procCapabilities().Processes {
+		t.Skip("the cpu time of the processes is read from /proc")
+	}
+
+	for _, hidden := range []bool{false, true} {
+		m := runningManager(t, burning("batch"))
+		pid := status(t, m, "batch").PID
+
+		eventually(t, 5*time.Second, "the burning children", func() bool {
+			tree, _ := processTree(pid)
+			return len(tree) == 3 && groupCPU(t, pid) > 100*time.Millisecond
+		})
+
+		if hidden {
+			SetProcRoot(t.TempDir())
+		}
+		err := m.Freeze("batch")
+		SetProcRoot("")
+		if err != nil {
+			t.Fatalf("freeze: %s", err)
+		}
+		if state := status(t, m, "batch").State; state != StateFrozen {
+			t.Fatalf("%s after the freeze, want %s", state, StateFrozen)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		frozen := groupCPU(t, pid)
+		time.Sleep(300 * time.Millisecond)
+		if cpu := groupCPU(t, pid); cpu != frozen {
+			t.Errorf("hidden /proc %t: the cpu time went from %s to %s while frozen", hidden, frozen, cpu)
+		}
+
+		if err := m.Thaw("batch"); err != nil {
+			t.Fatalf("thaw: %s", err)
+		}
+		eventually(t, 5*time.Second, "the thawed children", func() bool { return groupCPU(t, pid) > frozen+100*time.Millisecond })
+		if state := status(t, m, "batch").State; state != StateRunning {
+			t.Errorf("%s after the thaw, want %s", state, StateRunning)
+		}
+
+		if err := m.Stop("batch"); err != nil {
+			t.Fatalf("stop: %s", err)
+		}
+	}
+}
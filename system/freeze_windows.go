@@ -0,0 +1,10 @@
+package system
+
+// freezeTree has no SIGSTOP to pause a process with on windows
+func (s *Service) freezeTree() error {
+	return ErrUnsupportedPlatform
+}
+
+func (s *Service) thawTree() error {
+	return ErrUnsupportedPlatform
+}
@@ -0,0 +1,187 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// aggregated health of a group with MinHealthy: all members up, at least
+// MinHealthy of them up, or fewer
+const (
+	GROUP_HEALTHY   = "healthy"
+	GROUP_DEGRADED  = "degraded"
+	GROUP_UNHEALTHY = "unhealthy"
+)
+
+var ErrInvalidMinHealthy = errors.New("invalid minHealthy")
+
+// groupHealth is the aggregated health of a group, a change is committed once
+// it held for the hysteresis of the group
+type groupHealth struct {
+	state   string
+	pending string
+	timer   Timer
+}
+
+type groupHealths struct {
+	mu     sync.Mutex
+	groups map[string]*groupHealth
+}
+
+func validateMinHealthy(config ServiceConfig) error {
+	switch {
+	case config.MinHealthy < 0:
+		return fmt.Errorf("%w: %d", ErrInvalidMinHealthy, config.MinHealthy)
+	case config.MinHealthy > 0 && !IsTemplate(config.Name) && config.Replicas == 0:
+		return fmt.Errorf("%w: only templates and replicated services have members", ErrInvalidMinHealthy)
+	}
+
+	return nil
+}
+
+func aggregateHealth(up, size, minHealthy int) string {
+	switch {
+	case up < minHealthy:
+		return GROUP_UNHEALTHY
+	case up < size:
+		return GROUP_DEGRADED
+	}
+
+	return GROUP_HEALTHY
+}
+
+// evaluateGroup aggregates the health of the members of a group with MinHealthy,
+// fired tells the hysteresis of a pending change has passed
+func (m *Manager) evaluateGroup(name string, fired bool) {
+	// evaluations of the group count the members one at a time, an older count
+	// does not overwrite a newer one
+	h := &m.groupHealth
+	h.mu.Lock()
+
+	m.mu.Lock()
+	config := m.findGroup(name)
+	if config == nil || config.MinHealthy <= 0 {
+		m.mu.Unlock()
+		h.mu.Unlock()
+		m.groupHealth.forget(name)
+		return
+	}
+	minHealthy, hysteresis := config.MinHealthy, config.HealthHysteresis
+	members := m.members(name)
+	m.mu.Unlock()
+
+	up := 0
+	for _, member := range members {
+		if member.isUp() {
+			up += 1
+		}
+	}
+	state := aggregateHealth(up, len(members), minHealthy)
+
+	if h.groups == nil {
+		h.groups = make(map[string]*groupHealth)
+	}
+
+	group := h.groups[name]
+	switch {
+	case group == nil:
+		// the first aggregate is taken as it is
+		h.groups[name] = &groupHealth{state: state}
+		h.mu.Unlock()
+		return
+
+	case state == group.state:
+		group.pending = ""
+		if group.timer != nil {
+			group.timer.Stop()
+			group.timer = nil
+		}
+		h.mu.Unlock()
+		return
+
+	case hysteresis > 0 && !(fired && state == group.pending):
+		if state != group.pending {
+			group.pending = state
+			if group.timer != nil {
+				group.timer.Stop()
+			}
+			group.timer = m.Clock().AfterFunc(hysteresis, func() { m.evaluateGroup(name, true) })
+		}
+		h.mu.Unlock()
+		return
+	}
+
+	previous := group.state
+	group.state = state
+	group.pending, group.timer = "", nil
+	h.mu.Unlock()
+
+	message := fmt.Sprintf("%s, %d of %d members up, %d needed", state, up, len(members), minHealthy)
+	managerLog.with(FIELD_SERVICE, name).infof("%s (was %s)", message, previous)
+
+	event := Event{Service: name, Time: time.Now(), Message: message}
+	if state != GROUP_HEALTHY {
+		event.Level = EVENT_LEVEL_WARN
+	}
+	m.events.Publish(event)
+}
+
+func (h *groupHealths) get(name string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if group := h.groups[name]; group != nil {
+		return group.state
+	}
+
+	return ""
+}
+
+func (h *groupHealths) forget(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if group := h.groups[name]; group != nil && group.timer != nil {
+		group.timer.Stop()
+	}
+	delete(h.groups, name)
+}
+
+// states returns the aggregated health of each group, nil without groups
+func (h *groupHealths) states() map[string]string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.groups) == 0 {
+		return nil
+	}
+
+	states := make(map[string]string, len(h.groups))
+	for name, group := range h.groups {
+		states[name] = group.state
+	}
+
+	return states
+}
+
+// unhealthyGroups returns the groups below MinHealthy and the ones degraded
+func (h *groupHealths) unhealthyGroups() (unhealthy, degraded []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for name, group := range h.groups {
+		switch group.state {
+		case GROUP_UNHEALTHY:
+			unhealthy = append(unhealthy, name)
+		case GROUP_DEGRADED:
+			degraded = append(degraded, name)
+		}
+	}
+	sort.Strings(unhealthy)
+	sort.Strings(degraded)
+
+	return unhealthy, degraded
+}
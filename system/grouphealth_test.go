@@ -0,0 +1,212 @@
+package system
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// workers is a sleeping task of four replicas, two of them have to be up
+func workers(hysteresis time.Duration) ServiceConfig {
+	config := sleeper("worker")
+	config.Replicas = 4
+	config.MinHealthy = 2
+	config.HealthHysteresis = hysteresis
+	config.RestartPolicy = RESTART_NEVER
+
+	return config
+}
+
+// groupHealthIs waits for the health of the group in its status and in Healthz
+func groupHealthIs(t *testing.T, m *Manager, group, want string) {
+	t.Helper()
+
+	eventually(t, 5*time.Second, group+" "+want, func() bool {
+		status, err := m.GetGroupStatus(group)
+		if err != nil {
+			t.Fatalf("group status: %s", err)
+		}
+
+		return status.Health == want && m.Healthz().Groups[group] == want
+	})
+}
+
+// pendingIs waits for a change of the group health to want to be timed, the
+// clock moved earlier would not bring it closer
+func pendingIs(t *testing.T, m *Manager, group, want string) {
+	t.Helper()
+
+	eventually(t, 5*time.Second, group+" pending "+want, func() bool {
+		m.groupHealth.mu.Lock()
+		defer m.groupHealth.mu.Unlock()
+
+		health := m.groupHealth.groups[group]
+		return health != nil && health.pending == want && health.timer != nil
+	})
+}
+
+// stopMembers stops the named members and waits for them to be down
+func stopMembers(t *testing.T, m *Manager, names ...string) {
+	t.Helper()
+
+	for _, name := range names {
+		if err := m.Stop(name); err != nil {
+			t.Fatalf("stop %s: %s", name, err)
+		}
+		eventually(t, 5*time.Second, name+" stopped", func() bool { return status(t, m, name).State == StateStopped })
+	}
+}
+
+// startMembers starts the named members and waits for them to run
+func startMembers(t *testing.T, m *Manager, names ...string) {
+	t.Helper()
+
+	for _, name := range names {
+		if err := m.Start(name); err != nil {
+			t.Fatalf("start %s: %s", name, err)
+		}
+	}
+	running(t, m, names...)
+}
+
+func TestAggregateHealth(t *testing.T) {
+	for _, c := range []struct {
+		up, size, minHealthy int
+		want                 string
+	}{
+		{4, 4, 2, GROUP_HEALTHY},
+		{3, 4, 2, GROUP_DEGRADED},
+		{2, 4, 2, GROUP_DEGRADED},
+		{1, 4, 2, GROUP_UNHEALTHY},
+		{0, 4, 2, GROUP_UNHEALTHY},
+		{2, 2, 2, GROUP_HEALTHY},
+		// a group scaled below MinHealthy is unhealthy with all of its members up
+		{1, 1, 2, GROUP_UNHEALTHY},
+		{0, 0, 1, GROUP_UNHEALTHY},
+	} {
+		if got := aggregateHealth(c.up, c.size, c.minHealthy); got != c.want {
+			t.Errorf("%d of %d up, %d needed: %s, want %s", c.up, c.size, c.minHealthy, got, c.want)
+		}
+	}
+}
+
+func TestValidateMinHealthy(t *testing.T) {
+	for _, config := range []ServiceConfig{
+		{Name: "plain"},
+		{Name: "worker", Replicas: 4, MinHealthy: 2},
+		{Name: "worker@", MinHealthy: 1},
+	} {
+		if err := validateMinHealthy(config); err != nil {
+			t.Errorf("%s: %s", config.Name, err)
+		}
+	}
+
+	for _, config := range []ServiceConfig{
+		{Name: "worker", Replicas: 4, MinHealthy: -1},
+		{Name: "plain", MinHealthy: 1},
+	} {
+		if err := validateMinHealthy(config); !errors.Is(err, ErrInvalidMinHealthy) {
+			t.Errorf("%s with %d needed: %v, want ErrInvalidMinHealthy", config.Name, config.MinHealthy, err)
+		}
+	}
+}
+
+func TestGroupHealthAcrossMinHealthy(t *testing.T) {
+	m, _ := runManager(t, workers(0))
+	running(t, m, "worker.0", "worker.1", "worker.2", "worker.3")
+	groupHealthIs(t, m, "worker", GROUP_HEALTHY)
+
+	// down to the threshold the group is degraded and the manager healthy
+	stopMembers(t, m, "worker.0")
+	groupHealthIs(t, m, "worker", GROUP_DEGRADED)
+	stopMembers(t, m, "worker.1")
+	groupHealthIs(t, m, "worker", GROUP_DEGRADED)
+	if health := m.Healthz(); !health.Healthy || !reflect.DeepEqual(health.Degraded, []string{"worker"}) {
+		t.Fatalf("health %+v with 2 of 4 up, want healthy with worker degraded", health)
+	}
+
+	// a crash below it fails the manager health
+	killed(t, m, "worker.2")
+	groupHealthIs(t, m, "worker", GROUP_UNHEALTHY)
+	if health := m.Healthz(); health.Healthy || !reflect.DeepEqual(health.Unhealthy, []string{"worker"}) {
+		t.Fatalf("health %+v with 1 of 4 up, want unhealthy with worker", health)
+	}
+
+	startMembers(t, m, "worker.2")
+	groupHealthIs(t, m, "worker", GROUP_DEGRADED)
+	startMembers(t, m, "worker.0", "worker.1")
+	groupHealthIs(t, m, "worker", GROUP_HEALTHY)
+	if health := m.Healthz(); !health.Healthy || len(health.Degraded) != 0 || len(health.Unhealthy) != 0 {
+		t.Fatalf("health %+v with all up", health)
+	}
+
+	// scaled below MinHealthy the group is short of members, scaled back it is
+	// healthy once the new replicas run
+	if err := m.Scale("worker", 1); err != nil {
+		t.Fatalf("scale: %s", err)
+	}
+	groupHealthIs(t, m, "worker", GROUP_UNHEALTHY)
+	if err := m.Scale("worker", 3); err != nil {
+		t.Fatalf("scale: %s", err)
+	}
+	running(t, m, "worker.1", "worker.2")
+	groupHealthIs(t, m, "worker", GROUP_HEALTHY)
+	if status, _ := m.GetGroupStatus("worker"); status.Size != 3 || status.MinHealthy != 2 {
+		t.Fatalf("group status %+v, want 3 members and 2 needed", status)
+	}
+}
+
+func TestGroupHealthHysteresis(t *testing.T) {
+	m, clock := runManager(t, workers(10*time.Second))
+	events := m.Subscribe()
+	defer m.Unsubscribe(events)
+
+	// the first aggregate is taken before the members start, their starts count
+	// once they held for the hysteresis
+	running(t, m, "worker.0", "worker.1", "worker.2", "worker.3")
+	pendingIs(t, m, "worker", GROUP_HEALTHY)
+	groupHealthIs(t, m, "worker", GROUP_UNHEALTHY)
+	clock.Advance(10 * time.Second)
+	groupHealthIs(t, m, "worker", GROUP_HEALTHY)
+
+	// members down for less than the hysteresis do not flap the health
+	stopMembers(t, m, "worker.1", "worker.2", "worker.3")
+	pendingIs(t, m, "worker", GROUP_UNHEALTHY)
+	clock.Advance(5 * time.Second)
+	startMembers(t, m, "worker.1", "worker.2", "worker.3")
+	eventually(t, 5*time.Second, "the change dropped", func() bool {
+		m.groupHealth.mu.Lock()
+		defer m.groupHealth.mu.Unlock()
+
+		return m.groupHealth.groups["worker"].pending == ""
+	})
+	clock.Advance(time.Minute)
+	groupHealthIs(t, m, "worker", GROUP_HEALTHY)
+
+	// a change held for the hysteresis is committed with a warning
+	stopMembers(t, m, "worker.1", "worker.2", "worker.3")
+	pendingIs(t, m, "worker", GROUP_UNHEALTHY)
+	clock.Advance(9 * time.Second)
+	groupHealthIs(t, m, "worker", GROUP_HEALTHY)
+	clock.Advance(time.Second)
+	groupHealthIs(t, m, "worker", GROUP_UNHEALTHY)
+
+	var changes []Event
+	for len(changes) < 2 {
+		select {
+		case event := <-events:
+			if event.Service == "worker" {
+				changes = append(changes, event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("group events %+v, want the recovery and the failure", changes)
+		}
+	}
+	if changes[0].Level != "" || changes[0].Message != "healthy, 4 of 4 members up, 2 needed" {
+		t.Fatalf("event %+v, want the recovery", changes[0])
+	}
+	if changes[1].Level != EVENT_LEVEL_WARN || changes[1].Message != "unhealthy, 1 of 4 members up, 2 needed" {
+		t.Fatalf("event %+v, want a warning of the failure", changes[1])
+	}
+}
@@ -0,0 +1,361 @@
+package system
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HANDOFF_ENV passes the descriptors of Handoff to the new supervisor, a comma
+// separated list of name=fd
+const HANDOFF_ENV = "SYSTEMGO_HANDOFF"
+
+// HANDOFF_TIMEOUT is the time the new supervisor of Handoff gets to report it
+// took over, unless set otherwise with SetHandoffTimeout
+const HANDOFF_TIMEOUT = 30 * time.Second
+
+// HANDOFF_READY is the line the new supervisor writes to the readiness pipe once
+// every task is adopted or started
+const HANDOFF_READY = "ready"
+
+// the descriptors Handoff passes besides the listeners
+const (
+	handoffReadyFile = "ready"
+	handoffLockFile  = "lock"
+	// the lock file of the state of a service is passed as "lock:<name>"
+	handoffStateLockPrefix = "lock:"
+)
+
+func handoffStateLock(name string) string {
+	return handoffStateLockPrefix + name
+}
+
+var ErrHandoff = errors.New("handoff failed")
+var ErrHandingOff = errors.New("handing off to a new supervisor")
+
+// filer is a listener passed on by its descriptor
+type filer interface {
+	File() (*os.File, error)
+}
+
+// PassOnHandoff makes Handoff pass the listener, like the one of a control or
+// metrics API, to the new supervisor under name, which takes it with
+// InheritedListener instead of listening again
+func (m *Manager) PassOnHandoff(name string, listener net.Listener) error {
+	if name == "" || name == handoffReadyFile || name == handoffLockFile || strings.HasPrefix(name, handoffStateLockPrefix) || strings.ContainsAny(name, ",=") {
+		return fmt.Errorf("%w: invalid listener name %q", ErrHandoff, name)
+	}
+
+	file, ok := listener.(filer)
+	if !ok {
+		return fmt.Errorf("%w: the %s listener %T has no descriptor", ErrHandoff, name, listener)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.handoffFiles == nil {
+		m.handoffFiles = make(map[string]filer)
+	}
+	m.handoffFiles[name] = file
+
+	return nil
+}
+
+// SetHandoffTimeout is the time the new supervisor of Handoff gets to report it
+// took over, HANDOFF_TIMEOUT if zero
+func (m *Manager) SetHandoffTimeout(timeout time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.handoffTimeout = timeout
+}
+
+func (m *Manager) GetHandoffTimeout() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.handoffTimeout > 0 {
+		return m.handoffTimeout
+	}
+
+	return HANDOFF_TIMEOUT
+}
+
+// Handoff replaces this supervisor with the binary without stopping any task:
+// the binary runs with the arguments of this supervisor and inherits the lock
+// file, the listeners of PassOnHandoff and a pipe it reports on, it adopts the
+// running processes from their state files as after a crash and starts the
+// others. Meanwhile this supervisor starts and restarts nothing. Once the new
+// one reported it took over this process exits, leaving the tasks running, so
+// Handoff returns only if the handoff failed: the new supervisor is killed if it
+// did not report within the handoff timeout, and this one supervises on. The
+// pipes of the tasks stay with this process, a handoff is refused while a task
+// runs with its output read or its input fed by the supervisor
+func (m *Manager) Handoff(binary string) error {
+	if err := m.beginHandoff(); err != nil {
+		return err
+	}
+	defer atomic.StoreInt32(&m.handingOff, 0)
+
+	managerLog.infof("handing off to %s", binary)
+	successor, ready, err := m.execHandoff(binary)
+	if err != nil {
+		return m.failHandoff(err)
+	}
+
+	if err := waitHandoff(ready, m.GetHandoffTimeout()); err != nil {
+		killHandoff(successor)
+		successor.Wait()
+
+		return m.failHandoff(err)
+	}
+
+	managerLog.with(FIELD_PID, successor.Process.Pid).infof("handed off, exiting without stopping the tasks")
+	m.forwarders.closeAll()
+	os.Exit(0)
+
+	return nil
+}
+
+// beginHandoff checks the tasks can be handed off and stops starting them
+func (m *Manager) beginHandoff() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case !m.isRunning:
+		return ErrManagerNotStarted
+	case m.isShuttingDown():
+		return ErrShuttingDown
+	case runtime.GOOS == "windows" || startTicks(os.Getpid()) == 0:
+		// processes are adopted by their start time
+		return fmt.Errorf("%w: %s", ErrHandoff, ErrUnsupportedPlatform)
+	case m.Init:
+		return fmt.Errorf("%w: the init of a container ends it by exiting", ErrHandoff)
+	case m.historyDir == "":
+		return fmt.Errorf("%w: the running processes are handed off by their state files, no history directory is set", ErrHandoff)
+	}
+
+	for _, service := range m.services {
+		if service.Activation != "" {
+			return fmt.Errorf("%w: %s: the sockets of activated tasks are not handed off", ErrHandoff, service.Name)
+		}
+		if service.usesPipes() {
+			return fmt.Errorf("%w: %s: the output and input pipes of running tasks are not handed off, discard the output", ErrHandoff, service.Name)
+		}
+	}
+
+	if !atomic.CompareAndSwapInt32(&m.handingOff, 0, 1) {
+		return ErrHandingOff
+	}
+
+	return nil
+}
+
+// execHandoff starts the new supervisor, the returned pipe is read for its report
+func (m *Manager) execHandoff(binary string) (*exec.Cmd, *os.File, error) {
+	ready, report, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer report.Close()
+
+	names := []string{handoffReadyFile}
+	files := []*os.File{report}
+	if m.lock != nil {
+		names = append(names, handoffLockFile)
+		files = append(files, m.lock)
+	}
+
+	m.mu.Lock()
+	for _, service := range m.services {
+		service.mu.RLock()
+		if service.stateLock != nil {
+			names = append(names, handoffStateLock(service.Name))
+			files = append(files, service.stateLock)
+		}
+		service.mu.RUnlock()
+	}
+
+	listeners := make([]string, 0, len(m.handoffFiles))
+	for name := range m.handoffFiles {
+		listeners = append(listeners, name)
+	}
+	sort.Strings(listeners)
+
+	for _, name := range listeners {
+		file, err := m.handoffFiles[name].File()
+		if err != nil {
+			m.mu.Unlock()
+			ready.Close()
+			return nil, nil, fmt.Errorf("%w: the %s listener: %s", ErrHandoff, name, err)
+		}
+		defer file.Close()
+
+		names = append(names, name)
+		files = append(files, file)
+	}
+	m.mu.Unlock()
+
+	// the extra files are the descriptors from 3 on
+	fds := make([]string, len(names))
+	for i, name := range names {
+		fds[i] = name + "=" + strconv.Itoa(3+i)
+	}
+
+	successor := exec.Command(binary, os.Args[1:]...)
+	successor.Env = append(withoutEnv(os.Environ(), HANDOFF_ENV), HANDOFF_ENV+"="+strings.Join(fds, ","))
+	successor.Stdin, successor.Stdout, successor.Stderr = os.Stdin, os.Stdout, os.Stderr
+	successor.ExtraFiles = files
+	handoffGroup(successor)
+
+	if err := successor.Start(); err != nil {
+		ready.Close()
+		return nil, nil, fmt.Errorf("%w: %s", ErrHandoff, err)
+	}
+
+	return successor, ready, nil
+}
+
+// waitHandoff reads the report of the new supervisor within timeout
+func waitHandoff(ready *os.File, timeout time.Duration) error {
+	defer ready.Close()
+
+	if err := ready.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return fmt.Errorf("%w: %s", ErrHandoff, err)
+	}
+
+	line, err := bufio.NewReader(ready).ReadString('\n')
+	switch {
+	case strings.TrimSpace(line) == HANDOFF_READY:
+		return nil
+	case os.IsTimeout(err):
+		return fmt.Errorf("%w: the new supervisor did not report within %s", ErrHandoff, timeout)
+	case err == io.EOF:
+		return fmt.Errorf("%w: the new supervisor exited before it took over", ErrHandoff)
+	case err != nil:
+		return fmt.Errorf("%w: %s", ErrHandoff, err)
+	}
+
+	return fmt.Errorf("%w: unexpected report %q", ErrHandoff, line)
+}
+
+// failHandoff reports the failed handoff, this supervisor goes on
+func (m *Manager) failHandoff(err error) error {
+	m.warn(fmt.Sprintf("%s, supervising on", err))
+	return err
+}
+
+// reportHandoff tells the supervisor that handed off to this one that every task
+// is adopted or started, it exits then
+func (m *Manager) reportHandoff() {
+	ready := inheritedFile(handoffReadyFile)
+	if ready == nil {
+		return
+	}
+	defer ready.Close()
+
+	if _, err := ready.WriteString(HANDOFF_READY + "\n"); err != nil {
+		managerLog.warnf("failed to report the handoff: %s", err)
+		return
+	}
+
+	managerLog.infof("took over from the supervisor that handed off")
+}
+
+// usesPipes reports whether the running process writes its output to pipes read
+// by this supervisor or reads a pipe it writes, they are closed once it exits
+func (s *Service) usesPipes() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.running != nil && !s.running.adopted && (!s.DiscardOutput || s.running.stdin != nil)
+}
+
+// isHandingOff reports whether the supervisor hands off, it starts nothing then
+func (s *Service) isHandingOff() bool {
+	return s.handingOff != nil && atomic.LoadInt32(s.handingOff) == 1
+}
+
+var inherited struct {
+	once  sync.Once
+	mu    sync.Mutex
+	files map[string]*os.File
+}
+
+// InheritedListener returns the listener passed under name by the supervisor
+// that handed off to this one, nil if there is none
+func InheritedListener(name string) (net.Listener, error) {
+	file := inheritedFile(name)
+	if file == nil {
+		return nil, nil
+	}
+	defer file.Close()
+
+	return net.FileListener(file)
+}
+
+// inheritedFile takes the descriptor passed by Handoff under name, nil if none
+// was or it was taken already
+func inheritedFile(name string) *os.File {
+	inherited.once.Do(readInherited)
+
+	inherited.mu.Lock()
+	defer inherited.mu.Unlock()
+
+	file := inherited.files[name]
+	delete(inherited.files, name)
+
+	return file
+}
+
+// readInherited takes the descriptors of HANDOFF_ENV, neither the variable nor
+// the descriptors are passed on to the tasks
+func readInherited() {
+	inherited.files = make(map[string]*os.File)
+
+	value := os.Getenv(HANDOFF_ENV)
+	os.Unsetenv(HANDOFF_ENV)
+	if value == "" {
+		return
+	}
+
+	for _, field := range strings.Split(value, ",") {
+		parts := strings.SplitN(field, "=", 2)
+		fd := -1
+		if len(parts) == 2 {
+			fd, _ = strconv.Atoi(parts[1])
+		}
+		if fd < 3 {
+			managerLog.warnf("ignoring the handed off descriptor %q", field)
+			continue
+		}
+
+		closeOnExec(fd)
+		inherited.files[parts[0]] = os.NewFile(uintptr(fd), "handoff-"+parts[0])
+	}
+}
+
+// withoutEnv drops the variable from env
+func withoutEnv(env []string, name string) []string {
+	kept := make([]string, 0, len(env))
+	for _, variable := range env {
+		if !strings.HasPrefix(variable, name+"=") {
+			kept = append(kept, variable)
+		}
+	}
+
+	return kept
+}
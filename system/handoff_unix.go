@@ -0,0 +1,22 @@
+//go:build unix
+
+package system
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// handoffGroup starts the new supervisor in a process group of its own, a failed
+// one is killed with its group, the tasks it started have groups of their own
+func handoffGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killHandoff(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+func closeOnExec(fd int) {
+	syscall.CloseOnExec(fd)
+}
@@ -0,0 +1,320 @@
+//go:build unix
+
+package system
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// the test binary runs as a child supervisor in TestHandoffChild with the
+// directory of SUPERVISOR_DIR, one with SUPERVISOR_STALL set never reports a
+// handoff, one with SUPERVISOR_CAPTURE set reads the output of its task
+const (
+	SUPERVISOR_DIR     = "SYSTEMGO_TEST_SUPERVISOR_DIR"
+	SUPERVISOR_STALL   = "SYSTEMGO_TEST_SUPERVISOR_STALL"
+	SUPERVISOR_CAPTURE = "SYSTEMGO_TEST_SUPERVISOR_CAPTURE"
+)
+
+// supervisorReport is written by a child supervisor to <dir>/<name>.json
+type supervisorReport struct {
+	PID     int    `json:"pid"`
+	TaskPID int    `json:"taskPid"`
+	Runs    int    `json:"runs"`
+	Error   string `json:"error,omitempty"`
+}
+
+func TestHandoffChild(t *testing.T) {
+	dir := os.Getenv(SUPERVISOR_DIR)
+	if dir == "" {
+		t.Skip("run by the handoff tests as a child supervisor")
+	}
+
+	os.Exit(childSupervisor(dir))
+}
+
+// childSupervisor supervises a task writing a line every 100ms until SIGTERM,
+// the first one hands off to the test binary once its task runs
+func childSupervisor(dir string) int {
+	handedOff := os.Getenv(HANDOFF_ENV) != ""
+	if handedOff && os.Getenv(SUPERVISOR_STALL) != "" {
+		select {}
+	}
+
+	m, err := NewServiceManager([]Service{{ServiceConfig: ServiceConfig{
+		Name:          "ticker",
+		Exec:          "/bin/sh",
+		Params:        []string{"-c", "while true; do echo tick; sleep 0.1; done"},
+		DiscardOutput: os.Getenv(SUPERVISOR_CAPTURE) == "",
+		StopTimeout:   time.Second,
+	}}})
+	if err != nil {
+		return 2
+	}
+	m.SetHistoryDir(dir)
+	m.SetLockFile(filepath.Join(dir, "lock"))
+	m.SetHandoffTimeout(time.Second)
+
+	control, err := InheritedListener("control")
+	if control == nil && err == nil {
+		control, err = net.Listen("unix", filepath.Join(dir, "control.sock"))
+	}
+	if err != nil {
+		return 2
+	}
+	if err := m.PassOnHandoff("control", control); err != nil {
+		return 2
+	}
+	go servePid(control)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM)
+	defer stop()
+
+	go m.Run(ctx)
+
+	var status ServiceStatus
+	for status.PID == 0 || status.State != StateRunning {
+		time.Sleep(10 * time.Millisecond)
+		status, _ = m.GetStatus("ticker")
+	}
+
+	report := supervisorReport{PID: os.Getpid(), TaskPID: status.PID, Runs: status.Runs}
+	if handedOff {
+		writeReport(dir, "new", report)
+	} else {
+		writeReport(dir, "old", report)
+
+		binary, _ := os.Executable()
+		report.Error = m.Handoff(binary).Error()
+		status, _ = m.GetStatus("ticker")
+		report.TaskPID, report.Runs = status.PID, status.Runs
+		writeReport(dir, "failed", report)
+	}
+
+	<-ctx.Done()
+	m.Wait()
+
+	return 0
+}
+
+// servePid answers every connection with the pid of the supervisor serving it
+func servePid(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		conn.Write([]byte(strconv.Itoa(os.Getpid())))
+		conn.Close()
+	}
+}
+
+func writeReport(dir, name string, report supervisorReport) {
+	data, _ := json.Marshal(report)
+	ioutil.WriteFile(filepath.Join(dir, name+".json.tmp"), data, 0644)
+	os.Rename(filepath.Join(dir, name+".json.tmp"), filepath.Join(dir, name+".json"))
+}
+
+func readReport(t *testing.T, dir, name string) supervisorReport {
+	t.Helper()
+
+	var report supervisorReport
+	eventually(t, 10*time.Second, "the "+name+" report", func() bool {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name+".json"))
+		return err == nil && json.Unmarshal(data, &report) == nil
+	})
+
+	return report
+}
+
+// startSupervisor runs the test binary as a child supervisor in dir
+func startSupervisor(t *testing.T, dir string, env ...string) *exec.Cmd {
+	t.Helper()
+
+	if startTicks(os.Getpid()) == 0 {
+		t.Skip("processes are adopted by their start time read from /proc")
+	}
+
+	log, err := os.Create(filepath.Join(dir, "supervisor.log"))
+	if err != nil {
+		t.Fatalf("log: %s", err)
+	}
+	defer log.Close()
+
+	supervisor := exec.Command(os.Args[0], "-test.run=^TestHandoffChild$", "-test.count=1")
+	supervisor.Env = append(os.Environ(), append(env, SUPERVISOR_DIR+"="+dir)...)
+	supervisor.Stdout, supervisor.Stderr = log, log
+	if err := supervisor.Start(); err != nil {
+		t.Fatalf("start the supervisor: %s", err)
+	}
+
+	return supervisor
+}
+
+// stopPid ends the process and waits until it is gone
+func stopPid(t *testing.T, pid int) {
+	t.Helper()
+
+	syscall.Kill(pid, syscall.SIGTERM)
+	eventually(t, 10*time.Second, "the end of "+strconv.Itoa(pid), func() bool {
+		return !alive(pid)
+	})
+}
+
+// alive reports whether the process runs, a zombie does not
+func alive(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+
+	data, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(data[strings.LastIndexByte(string(data), ')')+1:]))
+
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+// dialPid reads the pid of the supervisor serving the control socket
+func dialPid(t *testing.T, dir string) int {
+	t.Helper()
+
+	conn, err := net.Dial("unix", filepath.Join(dir, "control.sock"))
+	if err != nil {
+		t.Fatalf("dial the control socket: %s", err)
+	}
+	defer conn.Close()
+
+	data, _ := ioutil.ReadAll(conn)
+	pid, _ := strconv.Atoi(string(data))
+
+	return pid
+}
+
+func TestHandoff(t *testing.T) {
+	dir := t.TempDir()
+	old := startSupervisor(t, dir)
+
+	before := readReport(t, dir, "old")
+	exited := make(chan error, 1)
+	go func() { exited <- old.Wait() }()
+
+	select {
+	case err := <-exited:
+		if err != nil {
+			t.Fatalf("the old supervisor exited with %s", err)
+		}
+	case <-time.After(10 * time.Second):
+		old.Process.Kill()
+		t.Fatalf("the old supervisor did not exit after the handoff")
+	}
+
+	after := readReport(t, dir, "new")
+	defer stopPid(t, after.PID)
+
+	if after.PID == before.PID {
+		t.Fatalf("the new supervisor has the pid of the old one")
+	}
+	if after.TaskPID != before.TaskPID || !alive(before.TaskPID) {
+		t.Fatalf("task pid %d after the handoff, want the running %d adopted", after.TaskPID, before.TaskPID)
+	}
+	if after.Runs != 1 {
+		t.Errorf("%d runs after the handoff, want the adopted one counted", after.Runs)
+	}
+
+	// the task writes on without the old supervisor
+	time.Sleep(time.Second)
+	if !alive(before.TaskPID) {
+		t.Fatalf("the adopted task died writing after the handoff")
+	}
+	if pid := dialPid(t, dir); pid != after.PID {
+		t.Errorf("the control socket is served by %d, want the new supervisor %d", pid, after.PID)
+	}
+	for _, path := range []string{filepath.Join(dir, "lock"), filepath.Join(dir, "ticker"+STATE_LOCK_SUFFIX)} {
+		if holder := lockHolder(path); holder != strconv.Itoa(after.PID) {
+			t.Errorf("%s is held by %s, want the new supervisor %d", path, holder, after.PID)
+		}
+		if f, err := lock(path); !errors.Is(err, ErrLocked) {
+			f.Close()
+			t.Errorf("%s is not locked: %v", path, err)
+		}
+	}
+
+	stopPid(t, after.PID)
+	if alive(before.TaskPID) {
+		t.Errorf("the adopted task outlived the supervisor stopping it")
+	}
+}
+
+func TestHandoffWithoutReport(t *testing.T) {
+	dir := t.TempDir()
+	old := startSupervisor(t, dir, SUPERVISOR_STALL+"=1")
+	defer func() {
+		old.Process.Signal(syscall.SIGTERM)
+		old.Wait()
+	}()
+
+	before := readReport(t, dir, "old")
+	failed := readReport(t, dir, "failed")
+
+	if failed.PID != before.PID || !strings.Contains(failed.Error, ErrHandoff.Error()) || !strings.Contains(failed.Error, "did not report") {
+		t.Fatalf("handoff of %d failed with %q, want the timeout of the report", failed.PID, failed.Error)
+	}
+	if failed.TaskPID != before.TaskPID || failed.Runs != before.Runs || !alive(before.TaskPID) {
+		t.Fatalf("task pid %d with %d runs after the failed handoff, want %d untouched", failed.TaskPID, failed.Runs, before.TaskPID)
+	}
+	if pid := dialPid(t, dir); pid != before.PID {
+		t.Errorf("the control socket is served by %d, want the old supervisor %d", pid, before.PID)
+	}
+}
+
+// TestHandoffOfCapturedOutput hands off a task whose output the supervisor
+// reads, it stays with the old one
+func TestHandoffOfCapturedOutput(t *testing.T) {
+	dir := t.TempDir()
+	old := startSupervisor(t, dir, SUPERVISOR_CAPTURE+"=1")
+	defer func() {
+		old.Process.Signal(syscall.SIGTERM)
+		old.Wait()
+	}()
+
+	before := readReport(t, dir, "old")
+	failed := readReport(t, dir, "failed")
+
+	if failed.PID != before.PID || !strings.Contains(failed.Error, ErrHandoff.Error()) || !strings.Contains(failed.Error, "ticker: the output and input pipes") {
+		t.Fatalf("handoff of %d failed with %q, want it refused for the output of ticker", failed.PID, failed.Error)
+	}
+
+	time.Sleep(time.Second)
+	if _, err := os.Stat(filepath.Join(dir, "new.json")); err == nil {
+		t.Errorf("a new supervisor took over")
+	}
+	if failed.TaskPID != before.TaskPID || failed.Runs != before.Runs || !alive(before.TaskPID) {
+		t.Fatalf("task pid %d with %d runs after the refused handoff, want %d writing on", failed.TaskPID, failed.Runs, before.TaskPID)
+	}
+}
+
+func TestHandoffRefused(t *testing.T) {
+	m, err := NewServiceManager(nil)
+	if err != nil {
+		t.Fatalf("manager: %s", err)
+	}
+
+	if err := m.Handoff(os.Args[0]); !errors.Is(err, ErrManagerNotStarted) {
+		t.Errorf("handoff of a manager not running: %v, want %v", err, ErrManagerNotStarted)
+	}
+}
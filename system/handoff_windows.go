@@ -0,0 +1,15 @@
+package system
+
+import (
+	"os/exec"
+)
+
+// handoffGroup, killHandoff and closeOnExec are not needed, Handoff is not
+// supported on windows
+func handoffGroup(cmd *exec.Cmd) {}
+
+func killHandoff(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
+
+func closeOnExec(fd int) {}
@@ -0,0 +1,38 @@
+package system
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// CONFIG_HASH_LENGTH is the number of hex digits of a configuration hash
+const CONFIG_HASH_LENGTH = 16
+
+// Hash returns the hash of the effective configuration: defaults expanded and
+// lists whose order does not matter sorted as by canonical, so the same
+// configuration hashes the same whatever order its Env, Ports or labels are
+// written in, encoded with the fields in declaration order and map keys sorted.
+// Params, Exec and Script are taken as they are, whitespace included, the
+// process sees them so. Manager defaults are taken in only once applied. A
+// configuration JSON can not encode, like a NaN the validation did not see, is
+// hashed by its Go syntax instead, which differs from every JSON encoding
+func (c ServiceConfig) Hash() string {
+	canonical := c.canonical()
+	data, err := json.Marshal(canonical)
+	if err != nil {
+		data = []byte(fmt.Sprintf("%#v", canonical))
+	}
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])[:CONFIG_HASH_LENGTH]
+}
+
+// ConfigHash returns the hash of the configuration the service runs with, equal
+// to the Hash of the definition in a configuration file once the manager
+// defaults are applied to it
+func (s *Service) ConfigHash() string {
+	return s.ServiceConfig.Hash()
+}
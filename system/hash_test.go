@@ -0,0 +1,86 @@
+package system
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestHashOfUnencodableConfig(t *testing.T) {
+	config := ServiceConfig{Name: "web", Exec: "./web", RestartBackoff: math.NaN()}
+
+	first, second := config.Hash(), (ServiceConfig{Name: "web", Exec: "./web", RestartBackoff: math.Inf(1)}).Hash()
+	if len(first) != CONFIG_HASH_LENGTH || first == second {
+		t.Fatalf("hashes %q and %q, want two different hashes of %d digits", first, second, CONFIG_HASH_LENGTH)
+	}
+
+	if first != config.Hash() {
+		t.Fatalf("hash of the same configuration changed")
+	}
+}
+
+func TestValidateRejectsNonFiniteNumbers(t *testing.T) {
+	cases := []struct {
+		config ServiceConfig
+		want   error
+	}{
+		{ServiceConfig{Name: "a", Exec: "true", RestartBackoff: math.NaN()}, ErrInvalidRestartPolicy},
+		{ServiceConfig{Name: "a", Exec: "true", RestartJitter: math.NaN()}, ErrInvalidRestartPolicy},
+		{ServiceConfig{Name: "a", Exec: "true", RestartBackoff: math.Inf(1)}, ErrInvalidRestartPolicy},
+		{ServiceConfig{Name: "a", Exec: "true", CPUQuota: math.NaN()}, ErrInvalidLimit},
+		{ServiceConfig{Name: "a", Exec: "true", CPUQuota: math.Inf(1)}, ErrInvalidLimit},
+	}
+
+	for _, c := range cases {
+		if err := validateConfig(c.config); !errors.Is(err, c.want) {
+			t.Errorf("%+v: %v, want %v", c.config, err, c.want)
+		}
+	}
+}
+
+func TestHashIgnoresOrderOfUnorderedLists(t *testing.T) {
+	config := ServiceConfig{
+		Name:   "web",
+		Exec:   "./web",
+		Env:    []string{"A=1", "B=2"},
+		Ports:  []string{":80", ":443"},
+		Labels: map[string]string{"team": "web", "tier": "front"},
+	}
+
+	reordered := config
+	reordered.Env = []string{"B=2", "A=1"}
+	reordered.Ports = []string{":443", ":80"}
+	reordered.Labels = map[string]string{"tier": "front", "team": "web"}
+
+	if config.Hash() != reordered.Hash() {
+		t.Fatalf("reordered env, ports and labels changed the hash")
+	}
+
+	defaulted := config
+	defaulted.RestartPolicy = RESTART_NEVER
+	if config.Hash() != defaulted.Hash() {
+		t.Fatalf("the default restart policy set explicitly changed the hash")
+	}
+}
+
+func TestHashTakesParamsAsTheyAre(t *testing.T) {
+	config := ServiceConfig{Name: "web", Exec: "./web", Params: []string{"-port", "80"}}
+
+	spaced := config
+	spaced.Params = []string{"-port", " 80"}
+	if config.Hash() == spaced.Hash() {
+		t.Fatalf("whitespace in params did not change the hash, the process sees it")
+	}
+
+	swapped := config
+	swapped.Params = []string{"80", "-port"}
+	if config.Hash() == swapped.Hash() {
+		t.Fatalf("the order of params did not change the hash")
+	}
+
+	env := config
+	env.Env = []string{"A=1"}
+	if config.Hash() == env.Hash() {
+		t.Fatalf("a new variable did not change the hash")
+	}
+}
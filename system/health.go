@@ -0,0 +1,133 @@
+package system
+
+import (
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// HEALTH_STALE_AFTER is the time after which a supervision loop that did not tick is considered hung
+const HEALTH_STALE_AFTER = 3 * UNIT_START_TIMEOUT * time.Second
+
+// SUPERVISION_HEARTBEAT is the longest a supervision loop waits for an event
+// before ticking again
+const SUPERVISION_HEARTBEAT = HEALTH_STALE_AFTER / 3
+
+// Health summarizes the state of the supervisor itself
+type Health struct {
+	Healthy  bool `json:"healthy"`
+	Services int  `json:"services"`
+	Failed   int  `json:"failed"`
+
+	// Stale are loops that did not tick for HEALTH_STALE_AFTER, "manager" for the manager one
+	Stale []string `json:"stale,omitempty"`
+
+	// LastTick is the oldest last tick of the running loops
+	LastTick time.Time `json:"lastTick"`
+
+	// Unhealthy are groups with fewer members up than MinHealthy, Degraded ones
+	// have enough of them but not all
+	Unhealthy []string `json:"unhealthy,omitempty"`
+	Degraded  []string `json:"degraded,omitempty"`
+
+	// Groups is the aggregated health of each group with MinHealthy
+	Groups map[string]string `json:"groups,omitempty"`
+}
+
+// ticked records that the loop is alive at the Monotonic reading of the clock,
+// the tick is read by Healthz
+func ticked(tick *int64, clock Clock) {
+	atomic.StoreInt64(tick, int64(clock.Monotonic()))
+}
+
+// tickAge returns the time passed since the last tick
+func tickAge(tick *int64, clock Clock) time.Duration {
+	return clock.Monotonic() - time.Duration(atomic.LoadInt64(tick))
+}
+
+// Healthz reports the manager as healthy while it runs, none of the supervision
+// loops got stuck and no group is below MinHealthy, failed services are counted
+// but do not make it unhealthy
+func (m *Manager) Healthz() Health {
+	m.mu.Lock()
+	services := make([]*Service, len(m.services))
+	copy(services, m.services)
+	isRunning := m.isRunning
+	m.mu.Unlock()
+
+	health := Health{Services: len(services)}
+	oldest := tickAge(&m.tick, m.Clock())
+	if isRunning && oldest > HEALTH_STALE_AFTER {
+		health.Stale = append(health.Stale, "manager")
+	}
+
+	for _, service := range services {
+		if service.Status().State == StateFailed {
+			health.Failed += 1
+		}
+
+		if !service.isSupervised() {
+			continue
+		}
+
+		age := tickAge(&service.tick, service.getClock())
+		if age > HEALTH_STALE_AFTER {
+			health.Stale = append(health.Stale, service.Name)
+		}
+
+		if age > oldest {
+			oldest = age
+		}
+	}
+
+	if isRunning {
+		health.LastTick = m.Clock().Now().Add(-oldest)
+	}
+
+	health.Unhealthy, health.Degraded = m.groupHealth.unhealthyGroups()
+	health.Groups = m.groupHealth.states()
+	health.Healthy = isRunning && len(health.Stale) == 0 && len(health.Unhealthy) == 0
+
+	return health
+}
+
+// SetHeartbeat makes the manager touch the file every interval while it is healthy,
+// so that an external watchdog notices a hung supervisor
+func (m *Manager) SetHeartbeat(path string, interval time.Duration) {
+	m.heartbeatPath = path
+	m.heartbeatInterval = interval
+}
+
+func (m *Manager) heartbeat(done <-chan struct{}) {
+	ticker := time.NewTicker(m.heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		if health := m.Healthz(); !health.Healthy {
+			managerLog.warnf("unhealthy, stale loops: %v", health.Stale)
+			continue
+		}
+
+		if err := touch(m.heartbeatPath); err != nil {
+			managerLog.warnf("failed to touch heartbeat file: %s", err)
+		}
+	}
+}
+
+func touch(path string) error {
+	now := time.Now()
+
+	err := os.Chtimes(path, now, now)
+	if os.IsNotExist(err) {
+		return ioutil.WriteFile(path, nil, 0644)
+	}
+
+	return err
+}
@@ -0,0 +1,195 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// kinds of the health checks of a running service
+const (
+	PROBE_READINESS = "readiness"
+	PROBE_LIVENESS  = "liveness"
+)
+
+// healthResult is the result of a probe of the process of a run
+type healthResult struct {
+	kind    string
+	running *process
+	err     error
+}
+
+// ValidateHealthChecks checks the readiness and liveness probes of a service
+// that is run, an external dependency has its Probe only
+func ValidateHealthChecks(config ServiceConfig) error {
+	for _, check := range []struct {
+		kind  string
+		probe Probe
+	}{{PROBE_READINESS, config.Readiness}, {PROBE_LIVENESS, config.Liveness}} {
+		kinds := check.probe.kinds()
+		switch {
+		case len(kinds) == 0:
+			continue
+		case len(kinds) > 1:
+			return fmt.Errorf("%w: the %s probe sets %v, only one of tcp, http, exec and path is used", ErrInvalidProbe, check.kind, kinds)
+		case len(config.Probe.kinds()) > 0:
+			return fmt.Errorf("%w: an external dependency is not run, it has no %s probe", ErrInvalidProbe, check.kind)
+		}
+
+		if err := validateProbeSettings(check.probe); err != nil {
+			return fmt.Errorf("%s: %w", check.kind, err)
+		}
+	}
+
+	if config.ReadyWhenListening != "" && len(config.Readiness.kinds()) > 0 {
+		return fmt.Errorf("%w: readyWhenListening and a readiness probe are both set, use one of them", ErrInvalidProbe)
+	}
+
+	return nil
+}
+
+// hasReadinessCheck reports whether the service is Ready only once a check passes
+func (s *Service) hasReadinessCheck() bool {
+	return s.ReadyWhenListening != "" || len(s.Readiness.kinds()) > 0
+}
+
+// probeReadiness runs the readiness probe every READY_POLL_INTERVAL, or the
+// interval of the probe if set, until it passes, the process exits or timeout
+// passes, sending the result once
+func probeReadiness(sched *scheduler, probe Probe, timeout time.Duration, exited <-chan struct{}, readiness chan<- error) {
+	clock := sched.getClock()
+	deadline := clock.Monotonic() + timeout
+
+	interval := READY_POLL_INTERVAL
+	if probe.Interval > 0 {
+		interval = probe.Interval
+	}
+
+	sched.every(interval, probe.GetTimeout(), nil, func(ctx context.Context) bool {
+		select {
+		case <-exited:
+			readiness <- errProcessExited
+			return false
+		default:
+		}
+
+		err := probe.run(ctx)
+		switch {
+		case err == nil:
+			readiness <- nil
+		case clock.Monotonic() > deadline:
+			readiness <- fmt.Errorf("readiness probe failing after %s: %w", timeout, err)
+		default:
+			return true
+		}
+
+		return false
+	})
+}
+
+// watchHealth probes the ready process every interval of its probes: a failing
+// readiness probe makes it NotReady until it passes again, a failing liveness
+// probe stops it to be restarted by the restart policy. A process is watched once
+func (s *Service) watchHealth(running *process) {
+	if s.healthWatched == running {
+		return
+	}
+	s.healthWatched = running
+	s.readinessFailures, s.livenessFailures = 0, 0
+	s.setProbeErr(nil)
+
+	health := s.healthResults()
+	for _, check := range []struct {
+		kind  string
+		probe Probe
+	}{{PROBE_READINESS, s.Readiness}, {PROBE_LIVENESS, s.Liveness}} {
+		if len(check.probe.kinds()) == 0 {
+			continue
+		}
+
+		kind, probe := check.kind, check.probe
+		s.getScheduler().every(probe.GetInterval(), probe.GetTimeout(), running.Done(), func(ctx context.Context) bool {
+			err := probe.run(ctx)
+			select {
+			case health <- healthResult{kind: kind, running: running, err: err}:
+				return true
+			case <-running.Done():
+				return false
+			}
+		})
+	}
+}
+
+// healthResults is the channel the checks of the processes send their results to
+func (s *Service) healthResults() chan healthResult {
+	if s.health == nil {
+		s.health = make(chan healthResult)
+	}
+
+	return s.health
+}
+
+// handleHealth counts the failures of a probe in a row, the FailureThreshold one
+// acts on them. A frozen process does not answer, its results are dropped
+func (s *Service) handleHealth(result healthResult) {
+	if result.running != s.running || !s.IsRunning() || s.frozen {
+		return
+	}
+
+	if result.kind == HEALTH_MEMORY_LIMIT {
+		s.handleMemoryLimit(result)
+		return
+	}
+
+	s.setProbeErr(result.err)
+	state := s.getState()
+
+	switch {
+	case result.kind == PROBE_READINESS && result.err == nil:
+		s.readinessFailures = 0
+		if state == StateNotReady {
+			s.log().infof("readiness probe passed, ready")
+			s.note(JournalEntry{Type: JOURNAL_READY, PID: s.running.GetPid()})
+			s.setState(StateReady)
+		}
+
+	case result.kind == PROBE_READINESS:
+		s.readinessFailures += 1
+		s.log().warnf("readiness probe failed (%d/%d): %s", s.readinessFailures, s.Readiness.GetFailureThreshold(), result.err)
+		if state == StateReady && s.readinessFailures >= s.Readiness.GetFailureThreshold() {
+			s.note(JournalEntry{Type: JOURNAL_PROBE_FAILED, PID: s.running.GetPid(), Message: result.err.Error()})
+			s.publish(Event{Type: EVENT_PROBE_FAILED, Message: result.err.Error()})
+			s.setState(StateNotReady)
+			s.warn(fmt.Sprintf("readiness probe failed %d times: %s, not ready", s.readinessFailures, result.err))
+		}
+
+	case result.err == nil:
+		s.livenessFailures = 0
+
+	default:
+		s.livenessFailures += 1
+		s.log().warnf("liveness probe failed (%d/%d): %s", s.livenessFailures, s.Liveness.GetFailureThreshold(), result.err)
+		if s.livenessFailures < s.Liveness.GetFailureThreshold() {
+			return
+		}
+
+		s.note(JournalEntry{Type: JOURNAL_PROBE_FAILED, PID: s.running.GetPid(), Message: result.err.Error()})
+		s.publish(Event{Type: EVENT_PROBE_FAILED, Message: result.err.Error()})
+		s.warn(fmt.Sprintf("liveness probe failed %d times: %s, stopping", s.livenessFailures, result.err))
+		if err := s.stopRunning(StopReasonLivenessFailed); err != nil {
+			s.log().errorf("%s", err)
+		}
+	}
+}
+
+// setProbeErr keeps the error of the last probe for the status
+func (s *Service) setProbeErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		s.probeErr = err.Error()
+	} else {
+		s.probeErr = ""
+	}
+}
@@ -0,0 +1,93 @@
+package system
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// eventually fails the test unless cond holds within timeout
+func eventually(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// openFds counts the descriptors of the test process
+func openFds(t *testing.T) int {
+	t.Helper()
+
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("descriptors are not counted without /proc: %s", err)
+	}
+
+	return len(fds)
+}
+
+// baseline counts the goroutines and descriptors once a service has run, the
+// shared scheduler and output workers start with the first one
+func baseline(t *testing.T) (int, int) {
+	t.Helper()
+
+	service := NewService(ServiceConfig{Name: "warmup", Exec: "true"})
+	service.Run(context.Background(), nil, nil)
+
+	return runtime.NumGoroutine(), openFds(t)
+}
+
+// settles reports whether the goroutines and descriptors get back to the
+// baseline within a few seconds, the runtime takes a moment to end goroutines
+func settles(goroutines, fds int, t *testing.T) bool {
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if runtime.NumGoroutine() <= goroutines && openFds(t) <= fds {
+			return true
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Logf("goroutines %d (baseline %d), descriptors %d (baseline %d)", runtime.NumGoroutine(), goroutines, openFds(t), fds)
+
+	return false
+}
+
+// advanceUntil moves the clock by d, then by a millisecond at a time until cond
+// holds: a loop reading the clock just before a move waits its timer from after it
+func advanceUntil(t *testing.T, clock *FakeClock, d time.Duration, what string, cond func() bool) {
+	t.Helper()
+
+	clock.Advance(d)
+	eventually(t, 5*time.Second, what, func() bool {
+		if cond() {
+			return true
+		}
+		clock.Advance(time.Millisecond)
+
+		return false
+	})
+}
+
+// onFakeClock runs the service on a fake clock of its own scheduler
+func onFakeClock(service *Service) *FakeClock {
+	clock := NewFakeClock(time.Now())
+	service.scheduler = newScheduler(1)
+	service.scheduler.setClock(clock)
+
+	return clock
+}
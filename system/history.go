@@ -0,0 +1,250 @@
+package system
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const HISTORY_MAX_RECORDS = 100
+const HISTORY_MAX_FILE_SIZE = 1 << 20
+
+// ProcessRecord is what is kept of a run once it is reaped, a copy of the values
+// of the process that holds none of its pipes, files or goroutines
+type ProcessRecord struct {
+	PID         int       `json:"pid"`
+	Incarnation int       `json:"incarnation,omitempty"`
+	StartedAt   time.Time `json:"startedAt"`
+	StoppedAt   time.Time `json:"stoppedAt"`
+	// Duration of the run on the monotonic clock, changes of the system time
+	// between StartedAt and StoppedAt do not count
+	Duration time.Duration `json:"duration,omitempty"`
+	ExitCode int           `json:"exitCode"`
+	Signal   int           `json:"signal,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Stderr   string        `json:"stderr,omitempty"`
+
+	StopReason StopReason `json:"stopReason"`
+
+	// stop timeline, zero unless the supervisor signaled the process
+	TermSentAt time.Time `json:"termSentAt,omitempty"`
+	KillSentAt time.Time `json:"killSentAt,omitempty"`
+	Forced     bool      `json:"forced,omitempty"`
+
+	// OOMKilled tells the process was killed by the out of memory killer: by a
+	// SIGKILL the supervisor did not send while the oom kills of the kernel grew
+	OOMKilled bool `json:"oomKilled,omitempty"`
+
+	// Adopted tells the process was started by an earlier supervisor, its exit
+	// status is not known, ExitCode is -1
+	Adopted bool `json:"adopted,omitempty"`
+
+	// CoreDumped is read from the wait status, CorePath and CoreSize are of the
+	// dump if it was found
+	CoreDumped bool   `json:"coreDumped,omitempty"`
+	CorePath   string `json:"corePath,omitempty"`
+	CoreSize   int64  `json:"coreSize,omitempty"`
+
+	// StartLatency is the time from exec to Running, ReadyLatency to Ready
+	StartLatency time.Duration `json:"startLatency,omitempty"`
+	ReadyLatency time.Duration `json:"readyLatency,omitempty"`
+
+	// UserCPU, SystemCPU and MaxRSSKB are the rusage of the wait, the process
+	// and the children it waited for. MaxRSSKB is at least the resident memory of
+	// the supervisor when it started the process, the exec keeps that high mark
+	UserCPU   time.Duration `json:"userCpu,omitempty"`
+	SystemCPU time.Duration `json:"systemCpu,omitempty"`
+	MaxRSSKB  uint64        `json:"maxRssKb,omitempty"`
+}
+
+func newProcessRecord(p *process) ProcessRecord {
+	record := ProcessRecord{
+		Incarnation: p.incarnation,
+		StartedAt:   p.Created,
+		StoppedAt:   p.Stopped,
+		ExitCode:    -1,
+	}
+
+	if p.cmd.Process != nil {
+		record.PID = p.cmd.Process.Pid
+	}
+
+	if p.cmd.ProcessState != nil {
+		record.ExitCode = p.cmd.ProcessState.ExitCode()
+
+		if status, ok := p.cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			record.Signal = int(status.Signal())
+			record.CoreDumped = status.CoreDump()
+		}
+
+		record.UserCPU = p.cmd.ProcessState.UserTime()
+		record.SystemCPU = p.cmd.ProcessState.SystemTime()
+		record.MaxRSSKB = maxRSSKB(p.cmd.ProcessState)
+	}
+
+	if !p.Created.IsZero() {
+		record.Duration = p.stoppedAt - p.createdAt
+	}
+
+	if record.Signal == int(syscall.SIGKILL) && p.killSentAt.IsZero() && p.oomCounted {
+		if count, ok := oomKills(); ok && count > p.oomKills {
+			record.OOMKilled = true
+		}
+	}
+
+	record.TermSentAt = p.termSentAt
+	record.KillSentAt = p.killSentAt
+	record.Forced = !p.killSentAt.IsZero()
+	record.Adopted = p.adopted
+	record.StartLatency = p.startLatency
+	record.ReadyLatency = p.readyLatency
+
+	if p.stderrTail != nil {
+		record.Stderr = p.stderrTail.String()
+	}
+
+	return record
+}
+
+// historyStore keeps finished process records of a single service as JSON lines,
+// the file is rotated once it grows over maxSize, keeping one rotated file
+type historyStore struct {
+	path    string
+	maxSize int64
+}
+
+func newHistoryStore(dir, name string) (*historyStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	store := new(historyStore)
+	store.path = filepath.Join(dir, name+".jsonl")
+	store.maxSize = HISTORY_MAX_FILE_SIZE
+
+	return store, nil
+}
+
+// Load returns up to limit of the most recent records, skipping corrupt lines
+func (h *historyStore) Load(limit int) ([]ProcessRecord, error) {
+	var records []ProcessRecord
+
+	for _, path := range []string{h.rotatedPath(), h.path} {
+		loaded, err := h.loadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, loaded...)
+	}
+
+	if len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+
+	return records, nil
+}
+
+func (h *historyStore) Append(record ProcessRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if info, err := os.Stat(h.path); err == nil && info.Size()+int64(len(line)) > h.maxSize {
+		if err := os.Rename(h.path, h.rotatedPath()); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(line); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+func (h *historyStore) rotatedPath() string {
+	return h.path + ".1"
+}
+
+func (h *historyStore) loadFile(path string) ([]ProcessRecord, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// a crash in the middle of a write leaves an unterminated line, cut it off
+	// so the next appended record starts on a line of its own
+	if end := bytes.LastIndexByte(data, '\n') + 1; end < len(data) {
+		componentLog(LOG_HISTORY, FIELD_FILE, path).warnf("dropping incomplete record")
+		if err := os.Truncate(path, int64(end)); err != nil {
+			return nil, err
+		}
+		data = data[:end]
+	}
+
+	var records []ProcessRecord
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+
+		var record ProcessRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			componentLog(LOG_HISTORY, FIELD_FILE, path).warnf("skipping corrupt record on line %d: %s", i+1, err)
+			continue
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func (s *Service) restoreHistory(dir string) error {
+	store, err := newHistoryStore(dir, s.Name)
+	if err != nil {
+		return err
+	}
+
+	records, err := store.Load(s.getMaxHistory())
+	if err != nil {
+		return fmt.Errorf("failed to load history: %s", err)
+	}
+
+	s.store = store
+	s.history = append(records, s.history...)
+	s.trimHistory()
+	if len(records) > 0 && s.lastExit == nil {
+		last := records[len(records)-1]
+		s.lastExit, s.lastExitRestored = &last, true
+	}
+	s.restoreLatency(records)
+
+	// runs of this supervisor continue the numbering
+	for _, record := range records {
+		if record.Incarnation > s.incarnation {
+			s.incarnation = record.Incarnation
+		}
+	}
+
+	s.log().infof("restored %d history records", len(records))
+
+	return nil
+}
@@ -0,0 +1,345 @@
+package system
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRestartWithoutHistory(t *testing.T) {
+	service := NewService(ServiceConfig{
+		Name:          "forgetful",
+		Exec:          "false",
+		RestartPolicy: RESTART_ALWAYS,
+		RestartDelay:  10 * time.Second,
+	})
+	clock := onFakeClock(service)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go service.Run(ctx, nil, nil)
+	eventually(t, 5*time.Second, "the restart delay", func() bool {
+		status := service.Status()
+		return status.Runs == 1 && status.State == StateRestarting
+	})
+
+	// the records are gone as if trimmed, the last exit and restart are not
+	service.mu.Lock()
+	service.history = nil
+	service.mu.Unlock()
+
+	status := service.Status()
+	if status.LastExitCode != 1 || status.LastStopReason != StopReasonCrashed {
+		t.Errorf("last exit %d %s without history, want 1 %s", status.LastExitCode, status.LastStopReason, StopReasonCrashed)
+	}
+
+	clock.Advance(5 * time.Second)
+	time.Sleep(50 * time.Millisecond)
+	if runs := service.Status().Runs; runs != 1 {
+		t.Fatalf("restarted halfway through the delay without history")
+	}
+	advanceUntil(t, clock, 5*time.Second, "the restart", func() bool { return service.Status().Runs == 2 })
+
+	cancel()
+	service.Wait()
+}
+
+func TestMaxHistory(t *testing.T) {
+	for _, test := range []struct {
+		maxHistory int
+		kept       int
+	}{
+		{0, HISTORY_MAX_RECORDS},
+		{2, 2},
+	} {
+		service := restartService(t, ServiceConfig{Name: "ringed", Exec: "false", MaxHistory: test.maxHistory}, 5)
+
+		status := service.Status()
+		want := test.kept
+		if status.Runs < want {
+			want = status.Runs
+		}
+		history := service.History()
+		if len(history) != want {
+			t.Fatalf("maxHistory %d: %d records after %d runs, want %d", test.maxHistory, len(history), status.Runs, want)
+		}
+		// the last run may be the one cancelled
+		if last := history[len(history)-1]; status.LastExitCode != last.ExitCode || status.LastStopReason != last.StopReason {
+			t.Errorf("maxHistory %d: last exit %d %s, want the last record %d %s", test.maxHistory, status.LastExitCode, status.LastStopReason, last.ExitCode, last.StopReason)
+		}
+	}
+}
+
+// appendRecords writes a crashed run for each of the pids to the store
+func appendRecords(t *testing.T, store *historyStore, pids ...int) {
+	t.Helper()
+
+	for _, pid := range pids {
+		if err := store.Append(ProcessRecord{PID: pid, Incarnation: pid, ExitCode: 1, StopReason: StopReasonCrashed}); err != nil {
+			t.Fatalf("append: %s", err)
+		}
+	}
+}
+
+func pidsOf(records []ProcessRecord) []int {
+	pids := make([]int, 0, len(records))
+	for _, record := range records {
+		pids = append(pids, record.PID)
+	}
+
+	return pids
+}
+
+func TestHistoryRecoveryFromTruncatedRecord(t *testing.T) {
+	dir := t.TempDir()
+	store, err := newHistoryStore(dir, "crasher")
+	if err != nil {
+		t.Fatalf("store: %s", err)
+	}
+	appendRecords(t, store, 1, 2, 3)
+
+	// a crash in the middle of writing the third record
+	info, err := os.Stat(store.path)
+	if err != nil {
+		t.Fatalf("stat: %s", err)
+	}
+	if err := os.Truncate(store.path, info.Size()-10); err != nil {
+		t.Fatalf("truncate: %s", err)
+	}
+
+	service := NewService(ServiceConfig{Name: "crasher", Exec: "false"})
+	if err := service.restoreHistory(dir); err != nil {
+		t.Fatalf("restore: %s", err)
+	}
+	if pids := pidsOf(service.History()); !reflect.DeepEqual(pids, []int{1, 2}) {
+		t.Fatalf("restored pids %v, want the complete records [1 2]", pids)
+	}
+	if status := service.Status(); status.LastExitCode != 1 || status.LastStopReason != StopReasonCrashed {
+		t.Errorf("last exit %d %s, want the last complete record", status.LastExitCode, status.LastStopReason)
+	}
+
+	// the next record starts on a line of its own
+	appendRecords(t, store, 4)
+	records, err := store.Load(HISTORY_MAX_RECORDS)
+	if err != nil {
+		t.Fatalf("load: %s", err)
+	}
+	if pids := pidsOf(records); !reflect.DeepEqual(pids, []int{1, 2, 4}) {
+		t.Errorf("pids %v after the next append, want [1 2 4]", pids)
+	}
+}
+
+func TestHistorySkipsCorruptRecords(t *testing.T) {
+	dir := t.TempDir()
+	store, err := newHistoryStore(dir, "crasher")
+	if err != nil {
+		t.Fatalf("store: %s", err)
+	}
+	appendRecords(t, store, 1)
+
+	f, err := os.OpenFile(store.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	f.WriteString("{\"pid\": \x00garbage\n\n")
+	f.Close()
+	appendRecords(t, store, 2)
+
+	records, err := store.Load(HISTORY_MAX_RECORDS)
+	if err != nil {
+		t.Fatalf("load: %s", err)
+	}
+	if pids := pidsOf(records); !reflect.DeepEqual(pids, []int{1, 2}) {
+		t.Errorf("pids %v, want the corrupt line skipped [1 2]", pids)
+	}
+}
+
+func TestHistoryRotation(t *testing.T) {
+	dir := t.TempDir()
+	store, err := newHistoryStore(dir, "crasher")
+	if err != nil {
+		t.Fatalf("store: %s", err)
+	}
+	// two records to a file
+	line, _ := json.Marshal(ProcessRecord{PID: 1, Incarnation: 1, ExitCode: 1, StopReason: StopReasonCrashed})
+	store.maxSize = int64(2*(len(line)+1) + 1)
+	appendRecords(t, store, 1, 2, 3, 4, 5, 6, 7, 8)
+
+	for _, path := range []string{store.path, store.rotatedPath()} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("stat: %s", err)
+		}
+		if info.Size() > store.maxSize {
+			t.Errorf("%s has %d bytes, want at most %d", filepath.Base(path), info.Size(), store.maxSize)
+		}
+	}
+
+	records, err := store.Load(3)
+	if err != nil {
+		t.Fatalf("load: %s", err)
+	}
+	if pids := pidsOf(records); !reflect.DeepEqual(pids, []int{6, 7, 8}) {
+		t.Errorf("pids %v, want the most recent [6 7 8]", pids)
+	}
+}
+
+func TestHistoryPersistsAcrossManagers(t *testing.T) {
+	dir := t.TempDir()
+	config := ServiceConfig{Name: "crasher", Exec: "false", RestartPolicy: RESTART_NEVER}
+
+	for i := 1; i <= 2; i++ {
+		m, err := NewServiceManager([]Service{{ServiceConfig: config}})
+		if err != nil {
+			t.Fatalf("manager: %s", err)
+		}
+		m.SetHistoryDir(dir)
+
+		if err := m.Run(context.Background()); err != nil {
+			t.Fatalf("run %d: %s", i, err)
+		}
+
+		service, err := m.GetService(config.Name)
+		if err != nil {
+			t.Fatalf("service: %s", err)
+		}
+		history := service.History()
+		if len(history) != i || history[i-1].Incarnation != i {
+			t.Fatalf("run %d: history %+v, want %d records, the last of incarnation %d", i, history, i, i)
+		}
+	}
+}
+
+func TestStateRecoveryFromTruncatedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "crasher"+STATE_SUFFIX)
+	if err := ioutil.WriteFile(path, []byte(`{"incarnation": 7, "failures": 2, "runn`), 0644); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+
+	service := NewService(ServiceConfig{Name: "crasher", Exec: "false"})
+	if err := service.restoreState(dir); err != nil {
+		t.Fatalf("a truncated state failed the restore: %s", err)
+	}
+	if service.restored != nil || service.incarnation != 0 {
+		t.Errorf("restored %+v incarnation %d from a truncated state", service.restored, service.incarnation)
+	}
+}
+
+func TestProcessRecordOfAnExit(t *testing.T) {
+	service := restartService(t, ServiceConfig{
+		Name:   "failing",
+		Exec:   "/bin/sh",
+		Params: []string{"-c", "echo boom >&2; exit 3"},
+	}, 1)
+
+	record := service.History()[0]
+	if record.PID <= 0 || record.Incarnation != 1 || record.ExitCode != 3 || record.Signal != 0 || record.StopReason != StopReasonCrashed {
+		t.Errorf("record %+v, want pid, incarnation 1 and exit code 3 of a crash", record)
+	}
+	if record.StartedAt.IsZero() || record.StoppedAt.Before(record.StartedAt) || record.Duration <= 0 {
+		t.Errorf("run from %s to %s for %s, want its times", record.StartedAt, record.StoppedAt, record.Duration)
+	}
+	if record.Stderr != "boom\n" || record.Forced || record.Adopted || record.OOMKilled || record.CoreDumped {
+		t.Errorf("record %+v, want the stderr tail of a plain exit", record)
+	}
+}
+
+func TestProcessRecordOfASignal(t *testing.T) {
+	service := restartService(t, ServiceConfig{
+		Name:   "killed",
+		Exec:   "/bin/sh",
+		Params: []string{"-c", "kill -KILL $$"},
+	}, 1)
+
+	record := service.History()[0]
+	if record.ExitCode != -1 || record.Signal != 9 || record.Forced || !record.KillSentAt.IsZero() {
+		t.Errorf("record %+v, want signal 9 the supervisor did not send", record)
+	}
+}
+
+func TestProcessRecordOfAProcessNotStarted(t *testing.T) {
+	record := newProcessRecord(&process{cmd: exec.Command("true")})
+	if record.PID != 0 || record.ExitCode != -1 || record.Duration != 0 || !record.StartedAt.IsZero() {
+		t.Errorf("record %+v, want no pid, exit code or times", record)
+	}
+}
+
+// TestProcessRecordHoldsNoReferences checks the fields of records are plain
+// values, a record keeps no process, pipe or descriptor alive
+func TestProcessRecordHoldsNoReferences(t *testing.T) {
+	typ := reflect.TypeOf(ProcessRecord{})
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		switch field.Type.Kind() {
+		case reflect.Bool, reflect.String, reflect.Int, reflect.Int64, reflect.Uint64:
+		case reflect.Struct:
+			if field.Type != reflect.TypeOf(time.Time{}) {
+				t.Errorf("%s is a %s", field.Name, field.Type)
+			}
+		default:
+			t.Errorf("%s is a %s, records are to hold values", field.Name, field.Type)
+		}
+	}
+}
+
+// TestProcessRecordsReleaseTheProcess keeps the records of a service while its
+// archived processes are collected
+func TestProcessRecordsReleaseTheProcess(t *testing.T) {
+	goroutines, fds := baseline(t)
+
+	service := NewService(ServiceConfig{
+		Name:          "flapping",
+		Exec:          "/bin/sh",
+		Params:        []string{"-c", "echo out; echo err >&2; sleep 0.05"},
+		RestartPolicy: RESTART_ALWAYS,
+		RestartDelay:  time.Millisecond,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go service.Run(ctx, nil, nil)
+	// the process is dropped between two runs
+	var first *process
+	eventually(t, 5*time.Second, "the first run", func() bool {
+		service.mu.RLock()
+		defer service.mu.RUnlock()
+
+		first = service.running
+		return first != nil
+	})
+
+	collected := make(chan struct{})
+	runtime.SetFinalizer(first, func(*process) { close(collected) })
+	first = nil
+
+	eventually(t, 10*time.Second, "the restarts", func() bool { return service.Status().Runs >= 5 })
+	cancel()
+	service.Wait()
+
+	eventually(t, 5*time.Second, "the collection of the first process", func() bool {
+		runtime.GC()
+		select {
+		case <-collected:
+			return true
+		default:
+			return false
+		}
+	})
+
+	if records := service.History(); len(records) < 5 {
+		t.Fatalf("%d records kept, want every run", len(records))
+	}
+	if !settles(goroutines, fds, t) {
+		t.Errorf("goroutines or descriptors left with the records")
+	}
+}
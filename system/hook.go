@@ -0,0 +1,278 @@
+package system
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const HOOK_TIMEOUT = UNIT_START_TIMEOUT * time.Second
+
+// stages of the hooks of a service
+const (
+	HOOK_START_PRE  = "execStartPre"
+	HOOK_START_POST = "execStartPost"
+	HOOK_STOP_POST  = "execStopPost"
+)
+
+// variables of the ExecStopPost commands telling how the run ended
+const (
+	EXIT_CODE_ENV   = "SYSTEMGO_EXIT_CODE"
+	STOP_REASON_ENV = "SYSTEMGO_STOP_REASON"
+)
+
+var ErrInvalidHook = errors.New("invalid hook")
+
+// Hook is a command or a function run by the manager on startup or shutdown
+type Hook struct {
+	Name   string
+	Exec   string
+	Params []string
+	Func   func(ctx context.Context) error
+
+	// Env holds "KEY=value" variables added to the environment of Exec
+	Env []string
+
+	// Timeout of the hook, HOOK_TIMEOUT if not set
+	Timeout time.Duration
+}
+
+func (h Hook) GetTimeout() time.Duration {
+	if h.Timeout > 0 {
+		return h.Timeout
+	}
+
+	return HOOK_TIMEOUT
+}
+
+func (h Hook) run(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, h.GetTimeout())
+	defer cancel()
+
+	if h.Func != nil {
+		return h.Func(ctx)
+	}
+
+	if h.Exec == "" {
+		return errors.New("neither exec nor func is set")
+	}
+
+	cmd := exec.CommandContext(ctx, h.Exec, h.Params...)
+	cmd.WaitDelay = OUTPUT_WAIT_DELAY
+	if len(h.Env) > 0 {
+		cmd.Env = append(os.Environ(), h.Env...)
+	}
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := startChild(cmd, UMASK_INHERIT)
+	if err == nil {
+		err = waitChild(cmd)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", h.GetTimeout())
+	}
+	if err != nil && output.Len() > 0 {
+		return fmt.Errorf("%s: %s", err, bytes.TrimSpace(output.Bytes()))
+	}
+
+	return err
+}
+
+// HookCommand is a command run around the process of a service, like the
+// process: with its environment, working directory, user and group, its output
+// captured as the output of the service
+type HookCommand struct {
+	Exec   string
+	Params []string
+
+	// Timeout of the command, HOOK_TIMEOUT if not set, it is killed after it
+	Timeout time.Duration
+
+	// IgnoreFailure goes on after the command failed, the failure is logged
+	IgnoreFailure bool
+}
+
+func (h *HookCommand) UnmarshalJSON(data []byte) error {
+	type hook HookCommand
+
+	aux := struct {
+		*hook
+		Timeout duration
+	}{hook: (*hook)(h)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	h.Timeout = time.Duration(aux.Timeout)
+
+	return nil
+}
+
+func (h HookCommand) GetTimeout() time.Duration {
+	if h.Timeout > 0 {
+		return h.Timeout
+	}
+
+	return HOOK_TIMEOUT
+}
+
+// ValidateServiceHooks checks the commands of ExecStartPre, ExecStartPost and
+// ExecStopPost of a service that is run
+func ValidateServiceHooks(config ServiceConfig) error {
+	for _, stage := range []struct {
+		name  string
+		hooks []HookCommand
+	}{{HOOK_START_PRE, config.ExecStartPre}, {HOOK_START_POST, config.ExecStartPost}, {HOOK_STOP_POST, config.ExecStopPost}} {
+		for i, hook := range stage.hooks {
+			switch {
+			case len(config.Probe.kinds()) > 0:
+				return fmt.Errorf("%w: an external dependency is not run, it has no %s", ErrInvalidHook, stage.name)
+			case hook.Exec == "":
+				return fmt.Errorf("%w: %s #%d has no exec", ErrInvalidHook, stage.name, i)
+			case hook.Timeout < 0:
+				return fmt.Errorf("%w: %s #%d has a negative timeout", ErrInvalidHook, stage.name, i)
+			}
+		}
+	}
+
+	return nil
+}
+
+// runServiceHooks runs the commands of a stage of the service in order, a
+// failing one fails the stage unless it has IgnoreFailure. They belong to the
+// run incarnation, env is added to their environment
+func (s *Service) runServiceHooks(stage string, hooks []HookCommand, incarnation int, env ...string) error {
+	for _, hook := range hooks {
+		err := s.runServiceHook(stage, hook, incarnation, env)
+		if err == nil {
+			continue
+		}
+
+		err = fmt.Errorf("%s %s failed: %w", stage, hook.Exec, err)
+		if !hook.IgnoreFailure {
+			return err
+		}
+
+		s.log().warnf("%s, ignored", err)
+	}
+
+	return nil
+}
+
+func (s *Service) runServiceHook(stage string, hook HookCommand, incarnation int, env []string) error {
+	cred, err := s.credential()
+	if err != nil {
+		return err
+	}
+
+	if err := s.makeDirs(cred); err != nil {
+		return err
+	}
+
+	base, secrets, err := s.processEnv(nil)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(hook.Exec, hook.Params...)
+	cmd.Dir = s.WorkingDir
+	cmd.Env = append(append(base, fmt.Sprintf("%s=%d", INCARNATION_ENV, incarnation)), env...)
+
+	running := newCmdProcess(s.Name, cmd)
+	running.hook = stage
+	running.incarnation = incarnation
+	running.secrets = secrets
+	running.clock = s.getClock()
+	running.stderrTail = newTailBuffer(s.getStderrTailSize())
+	cred.apply(running)
+	running.ownGroup()
+	running.umask = s.getUmask()
+
+	// the lines go where the ones of the process go
+	out, errs := s.consoleOut, s.consoleErr
+	stdout := STREAM_STDOUT
+	if s.CombineOutput && !s.DiscardOutput {
+		running.combine()
+		stdout = STREAM_COMBINED
+	} else {
+		running.discard(s.DiscardOutput, s.DiscardOutput)
+	}
+
+	started := make(chan error)
+	if running.Out != nil {
+		s.scanProcessStd(stdout, running, started, running.Out, out)
+	}
+	if running.Err != nil {
+		s.scanProcessStd(STREAM_STDERR, running, started, running.Err, errs)
+	}
+
+	s.log().infof("running %s %s", stage, hook.Exec)
+	go running.Start(started)
+	<-started
+	if running.startErr != nil {
+		<-running.Done()
+		return running.startErr
+	}
+
+	select {
+	case <-running.Done():
+	case <-running.clock.After(hook.GetTimeout()):
+		running.kill(UNIT_KILL_TIMEOUT * time.Second)
+		return fmt.Errorf("timed out after %s", hook.GetTimeout())
+	}
+
+	if state := running.cmd.ProcessState; !state.Success() {
+		lines := strings.Split(strings.TrimSpace(running.stderrTail.String()), "\n")
+		if last := lines[len(lines)-1]; last != "" {
+			return fmt.Errorf("%s: %s", state, last)
+		}
+
+		return errors.New(state.String())
+	}
+
+	return nil
+}
+
+// runStopPost runs ExecStopPost after a run, telling how it ended, a failure is warned about
+func (s *Service) runStopPost(record ProcessRecord) {
+	err := s.runServiceHooks(HOOK_STOP_POST, s.ExecStopPost, record.Incarnation,
+		fmt.Sprintf("%s=%d", EXIT_CODE_ENV, record.ExitCode), fmt.Sprintf("%s=%s", STOP_REASON_ENV, record.StopReason))
+	if err != nil {
+		s.warn(err.Error())
+	}
+}
+
+// runHooks runs hooks in order on the scheduler, a failed hook stops the rest if abort is set
+func runHooks(ctx context.Context, sched *scheduler, stage string, hooks []Hook, abort bool) error {
+	for i, hook := range hooks {
+		name := hook.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+
+		managerLog.infof("running %s hook %s", stage, name)
+		err := sched.do(ctx, hook.GetTimeout(), hook.run)
+		if err == nil {
+			continue
+		}
+
+		err = fmt.Errorf("%s hook %s failed: %w", stage, name, err)
+		if abort {
+			return err
+		}
+
+		managerLog.errorf("%s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,154 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chatty prints many lines tagged with its pid and exits at once, so the lines
+// of a run are still read while the next one starts
+func chatty(name string) ServiceConfig {
+	return ServiceConfig{
+		Name:          name,
+		Exec:          "/bin/sh",
+		Params:        []string{"-c", `i=0; while [ $i -lt 200 ]; do echo "$$ $i"; i=$((i+1)); done`},
+		RestartPolicy: RESTART_ALWAYS,
+		RestartDelay:  time.Millisecond,
+		RecentLines:   1 << 16,
+		OutputPrefix:  map[string]string{"stdout": "[{run}] "},
+	}
+}
+
+// incarnations maps the pids of the runs recorded to their incarnations
+func incarnations(t *testing.T, history []ProcessRecord) map[int]int {
+	t.Helper()
+
+	runs := make(map[int]int)
+	for i, record := range history {
+		if record.Incarnation != i+1 {
+			t.Fatalf("record %d of incarnation %d, want %d", i, record.Incarnation, i+1)
+		}
+		runs[record.PID] = record.Incarnation
+	}
+
+	return runs
+}
+
+func TestLinesCarryTheirIncarnation(t *testing.T) {
+	service := NewService(chatty("chatty"))
+
+	printed := make(chan string, 1<<16)
+	ctx, cancel := context.WithCancel(context.Background())
+	go service.Run(ctx, printed, nil)
+	eventually(t, 30*time.Second, "the restarts", func() bool { return service.Status().Runs >= 5 })
+	cancel()
+	service.Wait()
+
+	runs := incarnations(t, service.History())
+
+	lines := service.RecentOutput(0)
+	if len(lines) == 0 {
+		t.Fatalf("no lines captured")
+	}
+	for _, line := range lines {
+		pid, _ := strconv.Atoi(strings.Fields(line.Text)[0])
+		if line.Incarnation != runs[pid] {
+			t.Fatalf("line %q of pid %d carries incarnation %d, the pid ran as %d", line.Text, pid, line.Incarnation, runs[pid])
+		}
+	}
+
+	close(printed)
+	for text := range printed {
+		var incarnation, pid, i int
+		if _, err := fmt.Sscanf(text, "[%d] %d %d", &incarnation, &pid, &i); err != nil {
+			t.Fatalf("printed %q: %s", text, err)
+		}
+		if incarnation != runs[pid] {
+			t.Fatalf("printed %q of pid %d with the run of incarnation %d", text, pid, runs[pid])
+		}
+	}
+}
+
+func TestFollowOutputOfAnIncarnation(t *testing.T) {
+	service := NewService(chatty("followed"))
+
+	// the second run is followed before the first one starts
+	lines, stop := service.FollowOutput(2)
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer service.Wait()
+	defer cancel()
+	go service.Run(ctx, nil, nil)
+
+	eventually(t, 30*time.Second, "the second run", func() bool { return len(service.History()) >= 2 })
+
+	second := service.History()[1]
+	for i := 0; i < 200; i++ {
+		select {
+		case line := <-lines:
+			if want := fmt.Sprintf("%d %d", second.PID, i); line.Incarnation != 2 || line.Text != want {
+				t.Fatalf("line %d: %+v, want %q of the second run", i, line, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d lines of the second run followed, want 200", i)
+		}
+	}
+
+	// the lines of the third run are not followed
+	select {
+	case line, ok := <-lines:
+		if ok {
+			t.Fatalf("line %+v after the second run", line)
+		}
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestEventsCarryTheirIncarnation(t *testing.T) {
+	config := ServiceConfig{Name: "evented", Exec: "true", RestartPolicy: RESTART_ALWAYS, RestartDelay: time.Millisecond}
+
+	services := []Service{{ServiceConfig: config}}
+	m, err := NewServiceManager(services)
+	if err != nil {
+		t.Fatalf("manager: %s", err)
+	}
+	events := m.Subscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	started := make(map[int]int)
+	for exits := 0; exits < 3; {
+		select {
+		case event := <-events:
+			switch event.Type {
+			case EVENT_STARTED:
+				started[event.Incarnation] = event.PID
+			case EVENT_EXITED:
+				if pid, ok := started[event.Incarnation]; !ok || pid != event.PID {
+					t.Fatalf("exit of pid %d as incarnation %d, started %v", event.PID, event.Incarnation, started)
+				}
+				exits++
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("no events, %d exits", exits)
+		}
+	}
+
+	service, err := m.GetService(config.Name)
+	if err != nil {
+		t.Fatalf("service: %s", err)
+	}
+	runs := incarnations(t, service.History())
+	for incarnation, pid := range started {
+		if len(runs) >= incarnation && runs[pid] != incarnation {
+			t.Errorf("pid %d started as incarnation %d, recorded as %d", pid, incarnation, runs[pid])
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package system
+
+import (
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// INIT_SHUTDOWN_TIMEOUT is the time services get to stop when an init manager is
+// signaled, below the 10s container runtimes wait before SIGKILL
+const INIT_SHUTDOWN_TIMEOUT = 8 * time.Second
+
+// children are the pids started by the supervisor and not yet waited for, the
+// orphan reaper must leave them to their exec.Cmd
+var children = struct {
+	sync.Mutex
+	pids map[int]bool
+}{pids: make(map[int]bool)}
+
+// waitChild waits for cmd started with startChild
+func waitChild(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+
+	children.Lock()
+	delete(children.pids, cmd.Process.Pid)
+	children.Unlock()
+
+	return err
+}
+
+// SetMainService makes the manager shut down once the service is no longer
+// supervised, the exit code of the result is then the one of its last run
+func (m *Manager) SetMainService(name string) {
+	m.mainService = name
+}
+
+// SetShutdownTimeout limits the time services get to stop after Run is asked to
+// return, processes still running then are killed, no limit if not positive
+func (m *Manager) SetShutdownTimeout(timeout time.Duration) {
+	m.shutdownTimeout = timeout
+}
+
+func (m *Manager) GetShutdownTimeout() time.Duration {
+	if m.shutdownTimeout <= 0 && m.Init {
+		return INIT_SHUTDOWN_TIMEOUT
+	}
+
+	return m.shutdownTimeout
+}
@@ -0,0 +1,166 @@
+package system
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// the test binary runs as an init supervisor in TestInitChild, writing to
+// INIT_DIR, its main task exits with 7 on SIGTERM or, with INIT_MAIN_EXIT set,
+// with 5 on its own
+const (
+	INIT_DIR       = "SYSTEMGO_TEST_INIT_DIR"
+	INIT_MAIN_EXIT = "SYSTEMGO_TEST_INIT_MAIN_EXIT"
+)
+
+func TestInitChild(t *testing.T) {
+	dir := os.Getenv(INIT_DIR)
+	if dir == "" {
+		t.Skip("run by the init tests as the supervisor")
+	}
+
+	main := `trap "exit 7" TERM; sleep 30 & wait`
+	if os.Getenv(INIT_MAIN_EXIT) != "" {
+		main = "sleep 1; exit 5"
+	}
+
+	m, err := NewServiceManager([]Service{
+		{ServiceConfig: ServiceConfig{Name: "main", Exec: "/bin/sh", Params: []string{"-c", main}}},
+		// leaves a child behind, reparented to the supervisor
+		{ServiceConfig: ServiceConfig{
+			Name:          "spawner",
+			Exec:          "/bin/sh",
+			Params:        []string{"-c", "sh -c 'sleep 0.5' & echo $! > " + filepath.Join(dir, "orphan")},
+			RestartPolicy: RESTART_NEVER,
+		}},
+		// holds the shutdown up to the shutdown timeout
+		{ServiceConfig: ServiceConfig{
+			Name:        "stubborn",
+			Exec:        "/bin/sh",
+			Params:      []string{"-c", `trap "" TERM; while true; do sleep 0.1; done`},
+			StopTimeout: time.Minute,
+		}},
+	})
+	if err != nil {
+		os.Exit(2)
+	}
+	m.Init = true
+	m.SetMainService("main")
+	m.SetShutdownTimeout(500 * time.Millisecond)
+
+	go m.Run(context.Background())
+	os.Exit(m.Wait().ExitCode())
+}
+
+// startInit runs the test binary as an init supervisor and returns it with its
+// directory
+func startInit(t *testing.T, env ...string) (*exec.Cmd, string) {
+	t.Helper()
+
+	if !procCapabilities().Processes {
+		t.Skip("orphans are found in /proc")
+	}
+
+	dir := t.TempDir()
+	log, err := os.Create(filepath.Join(dir, "supervisor.log"))
+	if err != nil {
+		t.Fatalf("log: %s", err)
+	}
+	defer log.Close()
+
+	supervisor := exec.Command(os.Args[0], "-test.run=^TestInitChild$", "-test.count=1")
+	supervisor.Env = append(os.Environ(), append(env, INIT_DIR+"="+dir)...)
+	supervisor.Stdout, supervisor.Stderr = log, log
+	if err := supervisor.Start(); err != nil {
+		t.Fatalf("start the supervisor: %s", err)
+	}
+	t.Cleanup(func() {
+		supervisor.Process.Kill()
+		supervisor.Wait()
+	})
+
+	return supervisor, dir
+}
+
+// exitOf waits for the supervisor to exit and returns its exit code
+func exitOf(t *testing.T, supervisor *exec.Cmd, timeout time.Duration) int {
+	t.Helper()
+
+	exited := make(chan error, 1)
+	go func() { exited <- supervisor.Wait() }()
+
+	select {
+	case err := <-exited:
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode()
+		}
+		if err != nil {
+			t.Fatalf("wait: %s", err)
+		}
+		return 0
+	case <-time.After(timeout):
+		t.Fatalf("the supervisor did not exit within %s", timeout)
+	}
+
+	return 0
+}
+
+// reapedOrphan checks that the child the spawner left was reparented to the
+// supervisor and reaped by it once it exited
+func reapedOrphan(t *testing.T, supervisor *exec.Cmd, dir string) {
+	t.Helper()
+
+	var orphan int
+	eventually(t, 10*time.Second, "the orphan pid", func() bool {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "orphan"))
+		orphan, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+		return err == nil && orphan > 0
+	})
+
+	eventually(t, 5*time.Second, "the orphan reparented", func() bool {
+		ppid, err := parentPid(orphan)
+		return err != nil || ppid == supervisor.Process.Pid
+	})
+	if ppid, err := parentPid(orphan); err == nil && ppid != supervisor.Process.Pid {
+		t.Fatalf("orphan %d reparented to %d", orphan, ppid)
+	}
+
+	// a zombie still has its /proc entry
+	eventually(t, 5*time.Second, "the orphan reaped", func() bool {
+		_, err := os.Stat(procPath("%d", orphan))
+		return os.IsNotExist(err)
+	})
+}
+
+func TestInitStopsOnSIGTERM(t *testing.T) {
+	supervisor, dir := startInit(t)
+	reapedOrphan(t, supervisor, dir)
+
+	stopped := time.Now()
+	supervisor.Process.Signal(syscall.SIGTERM)
+
+	// the stubborn task would hold the shutdown for its minute of StopTimeout
+	if code := exitOf(t, supervisor, 10*time.Second); code != 7 {
+		t.Fatalf("exit code %d, want the 7 of the main task", code)
+	}
+	if elapsed := time.Since(stopped); elapsed > 5*time.Second {
+		t.Errorf("shutdown took %s past its timeout", elapsed)
+	}
+}
+
+func TestInitExitsWithTheMainTask(t *testing.T) {
+	supervisor, dir := startInit(t, INIT_MAIN_EXIT+"=1")
+	reapedOrphan(t, supervisor, dir)
+
+	if code := exitOf(t, supervisor, 10*time.Second); code != 5 {
+		t.Fatalf("exit code %d, want the 5 of the main task", code)
+	}
+}
@@ -0,0 +1,104 @@
+//go:build unix
+
+package system
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// startChild starts cmd, the pid is registered before the reaper can see the child.
+// The umask is inherited by the child, so the one of the supervisor is swapped
+// for the time of the fork, files the supervisor creates meanwhile get it too
+func startChild(cmd *exec.Cmd, umask int) error {
+	children.Lock()
+	defer children.Unlock()
+
+	if umask != UMASK_INHERIT {
+		defer syscall.Umask(syscall.Umask(umask))
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	children.pids[cmd.Process.Pid] = true
+
+	return nil
+}
+
+// reapOrphans waits for exited children the supervisor did not start, processes
+// reparented to it as the init or a subreaper
+func reapOrphans() {
+	children.Lock()
+	defer children.Unlock()
+
+	for _, pid := range childPids(os.Getpid()) {
+		if children.pids[pid] {
+			continue
+		}
+
+		var status syscall.WaitStatus
+		if reaped, err := syscall.Wait4(pid, &status, syscall.WNOHANG, nil); err == nil && reaped == pid {
+			managerLog.with(FIELD_PID, pid).infof("reaped orphan, exit code %d", status.ExitStatus())
+		}
+	}
+}
+
+// childPids returns the children of the parent pid
+func childPids(parent int) []int {
+	if !procCapabilities().Processes {
+		return nil
+	}
+
+	processes, err := listProcesses()
+	if err != nil {
+		return nil
+	}
+
+	var pids []int
+	for _, stats := range processes {
+		if stats.PPID == parent {
+			pids = append(pids, stats.PID)
+		}
+	}
+
+	return pids
+}
+
+// becomeInit makes the manager reap orphans and shut down on SIGTERM, SIGINT
+// and SIGQUIT, which have no default action for pid 1, until done is closed
+func (m *Manager) becomeInit(shutdown context.CancelFunc, done <-chan struct{}) {
+	if os.Getpid() != 1 {
+		// orphans of the services are reparented to the supervisor instead of pid 1
+		if err := becomeSubreaper(); err != nil {
+			managerLog.warnf("failed to become a subreaper: %s", err)
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGCHLD, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+
+	go func() {
+		defer signal.Stop(signals)
+
+		for {
+			select {
+			case sig := <-signals:
+				if sig == syscall.SIGCHLD {
+					reapOrphans()
+					continue
+				}
+
+				managerLog.infof("%s received, stopping services within %s", sig, m.GetShutdownTimeout())
+				shutdown()
+			case <-done:
+				reapOrphans()
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,44 @@
+package system
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// startChild starts cmd, there is no umask on windows
+func startChild(cmd *exec.Cmd, umask int) error {
+	children.Lock()
+	defer children.Unlock()
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	children.pids[cmd.Process.Pid] = true
+
+	return nil
+}
+
+// becomeInit makes the manager shut down on a console ctrl-c or ctrl-break,
+// windows has no orphans to reap
+func (m *Manager) becomeInit(shutdown context.CancelFunc, done <-chan struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		defer signal.Stop(signals)
+
+		for {
+			select {
+			case sig := <-signals:
+				managerLog.infof("%s received, stopping services within %s", sig, m.GetShutdownTimeout())
+				shutdown()
+			case <-done:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,59 @@
+package system
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIntentTransitions(t *testing.T) {
+	service := NewService(ServiceConfig{
+		Name:          "sleeper",
+		Exec:          "sleep",
+		Params:        []string{"30"},
+		RestartPolicy: RESTART_ALWAYS,
+		RestartDelay:  time.Millisecond,
+		StopTimeout:   time.Second,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := service.Start(ctx, nil, nil); err != nil {
+		t.Fatalf("start: %s", err)
+	}
+	eventually(t, 5*time.Second, "the first run", service.IsRunning)
+	if intent := service.intentOf(); intent != intentSupervise {
+		t.Fatalf("intent %s once started, want %s", intent, intentSupervise)
+	}
+
+	if err := service.send(commandStop); err != nil {
+		t.Fatalf("stop: %s", err)
+	}
+	if intent := service.intentOf(); intent != intentHeld {
+		t.Fatalf("intent %s once stopped, want %s", intent, intentHeld)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if service.IsRunning() {
+		t.Fatalf("a held service was restarted")
+	}
+
+	if err := service.send(commandStart); err != nil {
+		t.Fatalf("start again: %s", err)
+	}
+	eventually(t, 5*time.Second, "the run after the start", service.IsRunning)
+	if intent := service.intentOf(); intent != intentSupervise {
+		t.Fatalf("intent %s once started again, want %s", intent, intentSupervise)
+	}
+
+	cancel()
+	service.Wait()
+	if intent := service.intentOf(); intent != intentEnded {
+		t.Fatalf("intent %s once cancelled, want %s", intent, intentEnded)
+	}
+
+	service.setIntent(intentStartNow)
+	if intent := service.intentOf(); intent != intentEnded {
+		t.Errorf("an ended supervision moved to %s", intent)
+	}
+}
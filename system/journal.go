@@ -0,0 +1,101 @@
+package system
+
+import (
+	"sync"
+	"time"
+)
+
+const JOURNAL_MAX_ENTRIES = 200
+
+// types of journal entries
+const (
+	JOURNAL_STARTED           = "started"
+	JOURNAL_START_FAILED      = "start-failed"
+	JOURNAL_READY             = "ready"
+	JOURNAL_PROBE_FAILED      = "probe-failed"
+	JOURNAL_EXITED            = "exited"
+	JOURNAL_RESTART_SCHEDULED = "restart-scheduled"
+	JOURNAL_RESTART_PENDING   = "restart-pending"
+	JOURNAL_START_LIMIT       = "start-limit"
+	JOURNAL_TRIGGERED         = "triggered"
+	JOURNAL_PROMOTED          = "promoted"
+	JOURNAL_RUN_SKIPPED       = "run-skipped"
+	JOURNAL_ADOPTED           = "adopted"
+)
+
+// JournalEntry is a supervisor level event of a service, Count is the number of
+// times it repeated in a row, Time is the time of the last one
+type JournalEntry struct {
+	Time     time.Time     `json:"time"`
+	Type     string        `json:"type"`
+	PID      int           `json:"pid,omitempty"`
+	ExitCode int           `json:"exitCode,omitempty"`
+	Reason   StopReason    `json:"reason,omitempty"`
+	Delay    time.Duration `json:"delay,omitempty"`
+	Message  string        `json:"message,omitempty"`
+	Count    int           `json:"count"`
+}
+
+// sameAs reports whether the entries differ only in time and count
+func (e JournalEntry) sameAs(other JournalEntry) bool {
+	e.Time, e.Count = other.Time, other.Count
+
+	return e == other
+}
+
+// journal is a bounded log of entries, repeated entries are folded into the last one
+type journal struct {
+	mu      sync.Mutex
+	entries []JournalEntry
+}
+
+func (j *journal) add(entry JournalEntry, size int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entry.Time = time.Now()
+	entry.Count = 1
+
+	if last := len(j.entries) - 1; last >= 0 && j.entries[last].sameAs(entry) {
+		j.entries[last].Time = entry.Time
+		j.entries[last].Count += 1
+		return
+	}
+
+	j.entries = append(j.entries, entry)
+	if len(j.entries) > size {
+		j.entries = append(j.entries[:0], j.entries[len(j.entries)-size:]...)
+	}
+}
+
+// last returns up to n most recent entries oldest first, all of them if n is not positive
+func (j *journal) last(n int) []JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if n <= 0 || n > len(j.entries) {
+		n = len(j.entries)
+	}
+
+	entries := make([]JournalEntry, n)
+	copy(entries, j.entries[len(j.entries)-n:])
+
+	return entries
+}
+
+// Journal returns up to n most recent supervisor events of the service, all if n is not positive
+func (s *Service) Journal(n int) []JournalEntry {
+	return s.journal.last(n)
+}
+
+func (s *Service) note(entry JournalEntry) {
+	s.journal.add(entry, s.getJournalSize())
+}
+
+func (s *Service) getJournalSize() int {
+	if s.JournalSize > 0 {
+		return s.JournalSize
+	}
+
+	return JOURNAL_MAX_ENTRIES
+}
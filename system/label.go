@@ -0,0 +1,86 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// variables exported to every process, see also REPLICA_ENV
+const (
+	SERVICE_ENV        = "SYSTEMGO_SERVICE"
+	INCARNATION_ENV    = "SYSTEMGO_INCARNATION"
+	SUPERVISOR_PID_ENV = "SYSTEMGO_SUPERVISOR_PID"
+
+	// LABEL_ENV_PREFIX is followed by the upper cased label key
+	LABEL_ENV_PREFIX = "SYSTEMGO_LABEL_"
+)
+
+var ErrInvalidLabel = errors.New("invalid label")
+
+// ValidateLabels accepts keys of ascii letters, digits and "_" not starting with
+// a digit, keys must stay distinct once upper cased for the environment
+func ValidateLabels(labels map[string]string) error {
+	exported := make(map[string]string)
+
+	for key := range labels {
+		if key == "" {
+			return fmt.Errorf("%q: %w: empty key", key, ErrInvalidLabel)
+		}
+
+		for i, c := range key {
+			switch {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+			case i > 0 && c >= '0' && c <= '9':
+			default:
+				return fmt.Errorf("%q: %w: unexpected %q", key, ErrInvalidLabel, c)
+			}
+		}
+
+		name := strings.ToUpper(key)
+		if other, ok := exported[name]; ok {
+			return fmt.Errorf("%q: %w: same variable as %q", key, ErrInvalidLabel, other)
+		}
+
+		exported[name] = key
+	}
+
+	return nil
+}
+
+// supervisorEnv returns the variables describing the service to its process,
+// the incarnation is added once the run is numbered
+func (s *Service) supervisorEnv() []string {
+	env := []string{
+		fmt.Sprintf("%s=%s", SERVICE_ENV, s.Name),
+		fmt.Sprintf("%s=%d", SUPERVISOR_PID_ENV, os.Getpid()),
+	}
+
+	keys := make([]string, 0, len(s.Labels))
+	for key := range s.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		env = append(env, LABEL_ENV_PREFIX+strings.ToUpper(key)+"="+s.Labels[key])
+	}
+
+	return env
+}
+
+// labels returns a copy of the labels, nil if there are none
+func (s *Service) labels() map[string]string {
+	if len(s.Labels) == 0 {
+		return nil
+	}
+
+	labels := make(map[string]string, len(s.Labels))
+	for key, value := range s.Labels {
+		labels[key] = value
+	}
+
+	return labels
+}
@@ -0,0 +1,114 @@
+package system
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// LATENCY_WINDOW is the number of last starts the latency percentiles are of
+const LATENCY_WINDOW = 64
+
+// LatencyStats are the latency of the last start and the percentiles of the
+// last LATENCY_WINDOW starts
+type LatencyStats struct {
+	Last time.Duration `json:"last"`
+	P50  time.Duration `json:"p50"`
+	P95  time.Duration `json:"p95"`
+}
+
+// latencyWindow keeps the last LATENCY_WINDOW latencies in a ring
+type latencyWindow struct {
+	values []time.Duration
+	next   int
+	last   time.Duration
+}
+
+func (w *latencyWindow) add(d time.Duration) {
+	w.last = d
+
+	if len(w.values) < LATENCY_WINDOW {
+		w.values = append(w.values, d)
+		return
+	}
+
+	w.values[w.next] = d
+	w.next = (w.next + 1) % LATENCY_WINDOW
+}
+
+func (w *latencyWindow) stats() LatencyStats {
+	if len(w.values) == 0 {
+		return LatencyStats{}
+	}
+
+	sorted := make([]time.Duration, len(w.values))
+	copy(sorted, w.values)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	return LatencyStats{Last: w.last, P50: percentile(sorted, 50), P95: percentile(sorted, 95)}
+}
+
+func (w *latencyWindow) clone() latencyWindow {
+	values := make([]time.Duration, len(w.values))
+	copy(values, w.values)
+
+	return latencyWindow{values: values, next: w.next, last: w.last}
+}
+
+// percentile of sorted values, nearest rank
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+// noteStarted measures the time from exec to the started process, the start is
+// slow already if the service has no readiness to wait for
+func (s *Service) noteStarted(p *process) {
+	p.startLatency = p.createdAt - p.execAt
+
+	s.mu.Lock()
+	s.startLatency.add(p.startLatency)
+	s.mu.Unlock()
+
+	if !s.hasReadinessCheck() {
+		s.checkSlowStart(p.startLatency, "running")
+	}
+}
+
+// noteReady measures the time from exec to the ready process
+func (s *Service) noteReady(p *process) {
+	// an adopted process was not started by this supervisor
+	if p.adopted {
+		return
+	}
+
+	p.readyLatency = p.clock.Monotonic() - p.execAt
+
+	s.mu.Lock()
+	s.readyLatency.add(p.readyLatency)
+	s.mu.Unlock()
+
+	s.checkSlowStart(p.readyLatency, "ready")
+}
+
+func (s *Service) checkSlowStart(latency time.Duration, state string) {
+	if s.SlowStartThreshold > 0 && latency > s.SlowStartThreshold {
+		s.warn(fmt.Sprintf("slow start, %s after %s (threshold %s)", state, latency.Round(time.Millisecond), s.SlowStartThreshold))
+	}
+}
+
+// restoreLatency fills the latency windows from restored history records, oldest first
+func (s *Service) restoreLatency(records []ProcessRecord) {
+	for _, record := range records {
+		if record.StartLatency > 0 {
+			s.startLatency.add(record.StartLatency)
+		}
+		if record.ReadyLatency > 0 {
+			s.readyLatency.add(record.ReadyLatency)
+		}
+	}
+}
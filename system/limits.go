@@ -0,0 +1,143 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// actions of a service using more memory than its MemoryLimit
+const (
+	MEMORY_LIMIT_STOP = "stop"
+	MEMORY_LIMIT_WARN = "warn"
+)
+
+// MEMORY_LIMIT_INTERVAL is the interval the memory of a process with a
+// MemoryLimit is read at
+const MEMORY_LIMIT_INTERVAL = time.Second
+
+// CGROUP_CPU_PERIOD is the period of cpu.max in microseconds, CPUQuota is the
+// share of it the service runs in
+const CGROUP_CPU_PERIOD = 100000
+
+// HEALTH_MEMORY_LIMIT is the kind of the health result of a process above its
+// MemoryLimit
+const HEALTH_MEMORY_LIMIT = "memory-limit"
+
+var ErrInvalidLimit = errors.New("invalid limit")
+
+// ValidateLimits checks the resource limits of a service that is run
+func ValidateLimits(config ServiceConfig) error {
+	switch {
+	case config.CPUQuota < 0 || !isFinite(config.CPUQuota):
+		return fmt.Errorf("%w: cpuQuota %v, a positive number of CPUs is expected", ErrInvalidLimit, config.CPUQuota)
+	case config.MemoryLimit < 0:
+		return fmt.Errorf("%w: memoryLimit %d, a positive number of bytes is expected", ErrInvalidLimit, config.MemoryLimit)
+	case config.MemoryLimitAction != "" && config.MemoryLimitAction != MEMORY_LIMIT_STOP && config.MemoryLimitAction != MEMORY_LIMIT_WARN:
+		return fmt.Errorf("%w: memoryLimitAction %q, expected %s or %s", ErrInvalidLimit, config.MemoryLimitAction, MEMORY_LIMIT_STOP, MEMORY_LIMIT_WARN)
+	case config.MemoryLimitAction != "" && config.MemoryLimit == 0:
+		return fmt.Errorf("%w: memoryLimitAction is set without a memoryLimit", ErrInvalidLimit)
+	case config.hasLimits() && len(config.Probe.kinds()) > 0:
+		return fmt.Errorf("%w: an external dependency is not run, it has no limits", ErrInvalidLimit)
+	}
+
+	return nil
+}
+
+func (c ServiceConfig) hasLimits() bool {
+	return c.LimitNOFILE > 0 || c.LimitNPROC > 0 || c.CPUQuota > 0 || c.MemoryLimit > 0
+}
+
+func (s *Service) getMemoryLimitAction() string {
+	if s.MemoryLimitAction == "" {
+		return MEMORY_LIMIT_STOP
+	}
+
+	return s.MemoryLimitAction
+}
+
+// SetCgroupRoot sets the cgroup v2 directory the cgroups of the services with
+// CPUQuota or MemoryLimit are created in, delegated to the supervisor like
+// "/sys/fs/cgroup/systemgo". It must be called before Run
+func (m *Manager) SetCgroupRoot(dir string) {
+	m.cgroupRoot = dir
+}
+
+// cgroupDir is the cgroup of the service, empty without a cgroup root or
+// limits it enforces
+func (s *Service) cgroupDir() string {
+	if s.cgroupRoot == "" || (s.CPUQuota == 0 && s.MemoryLimit == 0) {
+		return ""
+	}
+
+	return filepath.Join(s.cgroupRoot, s.Name)
+}
+
+func writeCgroup(dir, file, value string) error {
+	return ioutil.WriteFile(filepath.Join(dir, file), []byte(value), 0644)
+}
+
+// usedMemory is the memory of the process in bytes, of its cgroup if it has one
+func (s *Service) usedMemory(running *process) (uint64, error) {
+	if running.cgroup != "" {
+		current, err := ioutil.ReadFile(filepath.Join(running.cgroup, "memory.current"))
+		if err != nil {
+			return 0, err
+		}
+
+		return strconv.ParseUint(strings.TrimSpace(string(current)), 10, 64)
+	}
+
+	if running.Finished() {
+		return 0, os.ErrProcessDone
+	}
+
+	kb, _, err := s.memoryUsage(running.pid(), MEMORY_LIMIT_INTERVAL)
+
+	return kb * 1024, err
+}
+
+// watchMemory reads the memory of the process every MEMORY_LIMIT_INTERVAL and
+// reports it once above MemoryLimit, the kernel stops a process of a cgroup
+// before that with the default action
+func (s *Service) watchMemory(running *process) {
+	if s.MemoryLimit == 0 {
+		return
+	}
+
+	limit := uint64(s.MemoryLimit)
+	health := s.healthResults()
+	s.getScheduler().every(MEMORY_LIMIT_INTERVAL, MEMORY_LIMIT_INTERVAL, running.Done(), func(ctx context.Context) bool {
+		used, err := s.usedMemory(running)
+		if err != nil || used <= limit {
+			return true
+		}
+
+		select {
+		case health <- healthResult{kind: HEALTH_MEMORY_LIMIT, running: running, err: fmt.Errorf("using %d bytes, above the memory limit of %d", used, limit)}:
+		case <-running.Done():
+		}
+
+		return false
+	})
+}
+
+// handleMemoryLimit stops the process above its MemoryLimit to be restarted by
+// the restart policy, or warns about it
+func (s *Service) handleMemoryLimit(result healthResult) {
+	if s.getMemoryLimitAction() == MEMORY_LIMIT_WARN {
+		s.warn(result.err.Error())
+		return
+	}
+
+	s.warn(result.err.Error() + ", stopping")
+	if err := s.stopRunning(StopReasonMemoryLimit); err != nil {
+		s.log().errorf("%s", err)
+	}
+}
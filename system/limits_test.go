@@ -0,0 +1,24 @@
+package system
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestUsedMemoryOfFinishedProcess(t *testing.T) {
+	running := newCmdProcess("done", exec.Command("true"))
+	running.discard(true, true)
+
+	started := make(chan error)
+	go running.Start(started)
+	<-started
+	<-running.Done()
+
+	// the sample of a process exiting meanwhile runs on a scheduler worker
+	service := NewService(ServiceConfig{Name: "done", MemoryLimit: 1 << 20})
+	if _, err := service.usedMemory(running); !errors.Is(err, os.ErrProcessDone) {
+		t.Fatalf("usedMemory of an exited process: %v, want %v", err, os.ErrProcessDone)
+	}
+}
@@ -0,0 +1,271 @@
+package system
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const READY_POLL_INTERVAL = 100 * time.Millisecond
+
+var errProcessExited = errors.New("process exited")
+
+// listenNetwork returns "unix" for socket paths (or abstract "@name" sockets) and "tcp" otherwise
+func listenNetwork(address string) string {
+	if strings.HasPrefix(address, "/") || strings.HasPrefix(address, "@") {
+		return "unix"
+	}
+
+	return "tcp"
+}
+
+// listeningInodes returns inodes of listening sockets bound to the address, tcp
+// sockets are matched by port
+func listeningInodes(address string) (map[uint64]bool, error) {
+	if !procCapabilities().Sockets {
+		return nil, ErrProcUnavailable
+	}
+
+	if listenNetwork(address) == "unix" {
+		return scanProcNet([]string{procPath("net/unix")}, 6, func(fields []string) bool {
+			// flags 00010000 is __SO_ACCEPTCON, a listening socket
+			return len(fields) > 7 && fields[3] == "00010000" && fields[7] == address
+		})
+	}
+
+	suffix, err := tcpPortSuffix(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanProcNet([]string{procPath("net/tcp"), procPath("net/tcp6")}, 9, func(fields []string) bool {
+		// state 0A is TCP_LISTEN
+		return len(fields) > 9 && fields[3] == "0A" && strings.HasSuffix(fields[1], suffix)
+	})
+}
+
+// connectionInodes returns inodes of connections accepted, or waiting to be
+// accepted, on the address
+func connectionInodes(address string) (map[uint64]bool, error) {
+	if !procCapabilities().Sockets {
+		return nil, ErrProcUnavailable
+	}
+
+	if listenNetwork(address) == "unix" {
+		return scanProcNet([]string{procPath("net/unix")}, 6, func(fields []string) bool {
+			// accepted sockets carry the path of the listening one, state 03 is SS_CONNECTED
+			return len(fields) > 7 && fields[3] != "00010000" && fields[5] == "03" && fields[7] == address
+		})
+	}
+
+	suffix, err := tcpPortSuffix(address)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanProcNet([]string{procPath("net/tcp"), procPath("net/tcp6")}, 9, func(fields []string) bool {
+		// state 01 is TCP_ESTABLISHED, matched by the local address
+		return len(fields) > 9 && fields[3] == "01" && strings.HasSuffix(fields[1], suffix)
+	})
+}
+
+// tcpPortSuffix returns the port of the address as it ends local addresses in /proc/net/tcp
+func tcpPortSuffix(address string) (string, error) {
+	_, portValue, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+
+	port, err := strconv.ParseUint(portValue, 10, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid port: %s", portValue)
+	}
+
+	return fmt.Sprintf(":%04X", port), nil
+}
+
+// scanProcNet collects the inode column of matching lines from /proc/net tables
+func scanProcNet(paths []string, inodeField int, match func([]string) bool) (map[uint64]bool, error) {
+	inodes := make(map[uint64]bool)
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Scan() // header
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if !match(fields) {
+				continue
+			}
+
+			if inode, err := strconv.ParseUint(fields[inodeField], 10, 64); err == nil {
+				inodes[inode] = true
+			}
+		}
+
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return inodes, nil
+}
+
+// isListeningOwner reports whether a socket listening on the address is held by pid or its descendants
+func isListeningOwner(address string, pid int) (bool, error) {
+	if !procCapabilities().FileDescriptors {
+		return false, ErrProcUnavailable
+	}
+
+	listening, err := listeningInodes(address)
+	if err != nil {
+		return false, err
+	}
+
+	tree, err := processTree(pid)
+	if err != nil {
+		return false, err
+	}
+
+	for inode := range socketInodes(tree) {
+		if listening[inode] {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// PortOwner identifies the process holding a listening socket
+type PortOwner struct {
+	PID     int
+	Command string
+}
+
+func (o PortOwner) String() string {
+	if o.PID == 0 {
+		return "unknown process"
+	}
+
+	return fmt.Sprintf("pid %d (%s)", o.PID, o.Command)
+}
+
+// FindPortOwner returns the process listening on the address, nil if nothing
+// listens on it, or an owner with zero PID if the socket is not visible in /proc of any process
+func FindPortOwner(address string) (*PortOwner, error) {
+	listening, err := listeningInodes(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(listening) == 0 {
+		return nil, nil
+	}
+
+	if capabilities := procCapabilities(); !capabilities.Processes || !capabilities.FileDescriptors {
+		return &PortOwner{}, nil
+	}
+
+	entries, err := ioutil.ReadDir(procPath(""))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		for inode := range socketInodes([]int{pid}) {
+			if listening[inode] {
+				return &PortOwner{PID: pid, Command: processCommand(pid)}, nil
+			}
+		}
+	}
+
+	return &PortOwner{}, nil
+}
+
+// checkPorts fails if a declared port is already bound by some other process
+func (s *Service) checkPorts() error {
+	// sockets of an on-demand service are held by the supervisor once open
+	if s.SkipPortCheck || s.activation != nil {
+		return nil
+	}
+
+	ports := s.Ports
+	if s.ReadyWhenListening != "" {
+		ports = append([]string{s.ReadyWhenListening}, ports...)
+	}
+
+	for _, port := range ports {
+		owner, err := FindPortOwner(port)
+		if err == ErrProcUnavailable {
+			return nil
+		}
+		if err != nil {
+			s.log().warnf("unable to check port %s: %s", port, err)
+			continue
+		}
+
+		if owner != nil {
+			return fmt.Errorf("port %s is already in use by %s", port, owner)
+		}
+	}
+
+	return nil
+}
+
+// probeReady polls the address on the scheduler until it accepts connections on
+// a socket owned by pid, until timeout or until the process exits, the result is
+// sent to readiness
+func probeReady(sched *scheduler, address string, pid int, timeout time.Duration, exited <-chan struct{}, readiness chan<- error) {
+	clock := sched.getClock()
+	deadline := clock.Monotonic() + timeout
+	network := listenNetwork(address)
+
+	sched.every(READY_POLL_INTERVAL, READY_POLL_INTERVAL, nil, func(ctx context.Context) bool {
+		select {
+		case <-exited:
+			readiness <- errProcessExited
+			return false
+		default:
+		}
+
+		var dialer net.Dialer
+		if conn, err := dialer.DialContext(ctx, network, address); err == nil {
+			conn.Close()
+
+			// /proc not being usable, the connection is trusted
+			owned, err := isListeningOwner(address, pid)
+			if err != nil || owned {
+				readiness <- nil
+				return false
+			}
+		}
+
+		if clock.Monotonic() > deadline {
+			readiness <- fmt.Errorf("not listening on %s after %s", address, timeout)
+			return false
+		}
+
+		return true
+	})
+}
@@ -0,0 +1,111 @@
+//go:build !darwin && !windows
+
+package system
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// socketFixture adds the socket tables of /proc/net and the descriptors of the
+// fixture processes holding them to the proc fixture
+func socketFixture(t *testing.T) {
+	t.Helper()
+
+	root := procFixture(t)
+	tables := map[string]string{
+		"net/tcp": "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n" +
+			// 127.0.0.1:8080 listening, a connection accepted on it and
+			// 127.0.0.1:9090 listening in no process of the tree
+			"   0: 0100007F:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 777 1 0 100 0 0 10 0\n" +
+			"   1: 0100007F:1F90 0100007F:C350 01 00000000:00000000 00:00000000 00000000  1000        0 778 1 0 20 4 30 10 -1\n" +
+			"   2: 0100007F:2382 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 779 1 0 100 0 0 10 0\n",
+		"net/tcp6": "  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n" +
+			"   0: 00000000000000000000000000000000:20FB 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 780 1 0 100 0 0 10 0\n",
+		"net/unix": "Num       RefCount Protocol Flags    Type St Inode Path\n" +
+			"0000000000000000: 00000002 00000000 00010000 0001 01 781 /run/app.sock\n" +
+			"0000000000000000: 00000003 00000000 00000000 0001 03 782 /run/app.sock\n",
+	}
+	for name, content := range tables {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("fixture: %s", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("fixture: %s", err)
+		}
+	}
+
+	fds := map[string]string{
+		"4242/fd/0": "/dev/null",
+		"4242/fd/3": "socket:[778]",
+		"4242/fd/4": "socket:[777]",
+		"4242/fd/6": "socket:[781]",
+		"1/fd/5":    "socket:[780]",
+	}
+	for name, link := range fds {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("fixture: %s", err)
+		}
+		if err := os.Symlink(link, path); err != nil {
+			t.Fatalf("fixture: %s", err)
+		}
+	}
+
+	procState.mu.Lock()
+	procState.capabilities = &ProcCapabilities{Root: root, Processes: true, FileDescriptors: true, Sockets: true}
+	procState.mu.Unlock()
+}
+
+func TestFindPortOwnerOfFixture(t *testing.T) {
+	socketFixture(t)
+
+	for _, test := range []struct {
+		address string
+		owner   string
+	}{
+		{"127.0.0.1:8080", "pid 4242 (my (weird) app)"},
+		{"[::]:8443", "pid 1 (init)"},
+		{"/run/app.sock", "pid 4242 (my (weird) app)"},
+		{"127.0.0.1:9090", "unknown process"},
+		{"127.0.0.1:50000", ""},
+		{"/run/other.sock", ""},
+	} {
+		owner, err := FindPortOwner(test.address)
+		if err != nil {
+			t.Errorf("owner of %s: %s", test.address, err)
+			continue
+		}
+
+		switch {
+		case test.owner == "" && owner != nil:
+			t.Errorf("owner of %s is %s, nothing listens on it", test.address, owner)
+		case test.owner != "" && owner == nil:
+			t.Errorf("no owner of %s, want %s", test.address, test.owner)
+		case owner != nil && owner.String() != test.owner:
+			t.Errorf("owner of %s is %s, want %s", test.address, owner, test.owner)
+		}
+	}
+
+	if _, err := FindPortOwner("127.0.0.1:http-alt"); err == nil {
+		t.Errorf("owner of a named port")
+	}
+}
+
+func TestListeningOwnerOfFixture(t *testing.T) {
+	socketFixture(t)
+
+	// init holds 8080 through its child, kthreadd has no children there
+	for pid, want := range map[int]bool{4242: true, 1: true, 2: false} {
+		owned, err := isListeningOwner("127.0.0.1:8080", pid)
+		if err != nil {
+			t.Fatalf("owner: %s", err)
+		}
+		if owned != want {
+			t.Errorf("pid %d owns 8080: %t, want %t", pid, owned, want)
+		}
+	}
+}
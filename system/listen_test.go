@@ -0,0 +1,191 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the test binary run with LISTENER_ADDRESS listens on it after LISTENER_DELAY
+const (
+	LISTENER_ADDRESS = "SYSTEMGO_TEST_LISTENER_ADDRESS"
+	LISTENER_DELAY   = "SYSTEMGO_TEST_LISTENER_DELAY"
+)
+
+func TestListenerChild(t *testing.T) {
+	address := os.Getenv(LISTENER_ADDRESS)
+	if address == "" {
+		t.Skip("run by the readiness tests as the supervised process")
+	}
+
+	delay, _ := time.ParseDuration(os.Getenv(LISTENER_DELAY))
+	time.Sleep(delay)
+
+	listener, err := net.Listen(listenNetwork(address), address)
+	if err != nil {
+		os.Exit(2)
+	}
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			os.Exit(2)
+		}
+		conn.Close()
+	}
+}
+
+// freePort returns a tcp address nothing listens on
+func freePort(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer listener.Close()
+
+	return listener.Addr().String()
+}
+
+// startService starts the supervision of the service until the test ends
+func startService(t *testing.T, service *Service) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := service.Start(ctx, nil, nil); err != nil {
+		cancel()
+		t.Fatalf("start: %s", err)
+	}
+
+	t.Cleanup(func() {
+		cancel()
+		service.Wait()
+	})
+}
+
+func TestReadyWhenListening(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		address string
+	}{
+		{"tcp", freePort(t)},
+		{"unix", filepath.Join(t.TempDir(), "ready.sock")},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			service := NewService(ServiceConfig{
+				Name:               "listener",
+				Exec:               os.Args[0],
+				Params:             []string{"-test.run=^TestListenerChild$"},
+				Env:                []string{LISTENER_ADDRESS + "=" + test.address, LISTENER_DELAY + "=500ms"},
+				ReadyWhenListening: test.address,
+				StartTimeout:       10 * time.Second,
+				StopTimeout:        time.Second,
+			})
+			startService(t, service)
+
+			time.Sleep(200 * time.Millisecond)
+			if state := service.Status().State; state != StateRunning {
+				t.Fatalf("%s before listening, want %s", state, StateRunning)
+			}
+
+			eventually(t, 5*time.Second, "ready", func() bool { return service.Status().State == StateReady })
+		})
+	}
+}
+
+func TestNeverListening(t *testing.T) {
+	service := NewService(ServiceConfig{
+		Name:               "mute",
+		Exec:               "sleep",
+		Params:             []string{"30"},
+		ReadyWhenListening: freePort(t),
+		StartTimeout:       300 * time.Millisecond,
+		StopTimeout:        time.Second,
+	})
+	startService(t, service)
+
+	eventually(t, 5*time.Second, "the failed start", func() bool { return len(service.History()) == 1 })
+	if state := service.Status().State; state == StateReady {
+		t.Fatalf("ready without listening")
+	}
+	if record := service.History()[0]; record.StopReason != StopReasonLivenessFailed {
+		t.Errorf("stop reason %s, want %s", record.StopReason, StopReasonLivenessFailed)
+	}
+}
+
+func TestListenerOfAnotherProcess(t *testing.T) {
+	if !procCapabilities().FileDescriptors {
+		t.Skip("the owner of a listener is read from /proc")
+	}
+
+	// a stale instance still bound to the address
+	stale, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer stale.Close()
+
+	service := NewService(ServiceConfig{
+		Name:               "late",
+		Exec:               "sleep",
+		Params:             []string{"30"},
+		ReadyWhenListening: stale.Addr().String(),
+		SkipPortCheck:      true,
+		StartTimeout:       500 * time.Millisecond,
+		StopTimeout:        time.Second,
+	})
+	startService(t, service)
+
+	eventually(t, 5*time.Second, "the failed start", func() bool { return len(service.History()) == 1 })
+	if record := service.History()[0]; record.StopReason != StopReasonLivenessFailed {
+		t.Errorf("stop reason %s, want %s: the connection to another process made it ready", record.StopReason, StopReasonLivenessFailed)
+	}
+}
+
+func TestPortInUseFailsTheStart(t *testing.T) {
+	if c := procCapabilities(); !c.Sockets || !c.Processes || !c.FileDescriptors {
+		t.Skip("the owner of a port is read from /proc")
+	}
+
+	held, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer held.Close()
+
+	config := ServiceConfig{
+		Name:        "bound",
+		Exec:        "sleep",
+		Params:      []string{"30"},
+		Ports:       []string{freePort(t), held.Addr().String()},
+		StopTimeout: time.Second,
+	}
+
+	service := NewService(config)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err = service.Start(ctx, nil, nil)
+	want := fmt.Sprintf("port %s is already in use by pid %d (", held.Addr(), os.Getpid())
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("start: %v, want %q", err, want)
+	}
+	if status := service.Status(); status.PID != 0 {
+		t.Fatalf("pid %d on a port in use", status.PID)
+	}
+	cancel()
+	service.Wait()
+
+	// SO_REUSEPORT programs share the port
+	config.SkipPortCheck = true
+	service = NewService(config)
+	startService(t, service)
+	eventually(t, 5*time.Second, "the run", func() bool { return service.Status().State == StateRunning })
+}
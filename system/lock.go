@@ -0,0 +1,70 @@
+package system
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+var ErrLocked = errors.New("another supervisor holds the lock")
+
+// SetLockFile makes Run fail if another supervisor holds the lock file, the lock
+// is an flock released by the kernel when its holder exits, a file left by a
+// dead supervisor is taken over
+func (m *Manager) SetLockFile(path string) {
+	m.lockPath = path
+}
+
+func (m *Manager) GetLockFile() string {
+	return m.lockPath
+}
+
+// lock takes the lock file and writes the supervisor pid to it, the returned
+// file holds the lock until it is closed
+func lock(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(f); err != nil {
+		f.Close()
+
+		if err == ErrLocked {
+			return nil, fmt.Errorf("%s: %w, pid %s", path, ErrLocked, lockHolder(path))
+		}
+
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	if err := writeLockHolder(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// writeLockHolder writes the supervisor pid to the lock file it holds
+func writeLockHolder(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+
+	_, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+
+	return err
+}
+
+// lockHolder returns the pid written to the lock file by its holder
+func lockHolder(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		return "unknown"
+	}
+
+	return string(bytes.TrimSpace(data))
+}
@@ -0,0 +1,130 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lockedManager runs a manager of the services with the lock file and history
+// directory set, if not empty, and returns the error of Run, which the cleanup
+// waits for
+func lockedManager(t *testing.T, lockPath, historyDir string, configs ...ServiceConfig) (*Manager, <-chan error) {
+	t.Helper()
+
+	services := make([]Service, len(configs))
+	for i, config := range configs {
+		services[i] = Service{ServiceConfig: config}
+	}
+
+	m, err := NewServiceManager(services)
+	if err != nil {
+		t.Fatalf("manager: %s", err)
+	}
+	if lockPath != "" {
+		m.SetLockFile(lockPath)
+	}
+	if historyDir != "" {
+		m.SetHistoryDir(historyDir)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	returned := make(chan error, 1)
+	go func() {
+		err := m.Run(ctx)
+		done <- err
+		returned <- err
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return m, returned
+}
+
+func TestLockFileRefusesASecondManager(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "supervisor.lock")
+	first, _ := lockedManager(t, path, "", sleeper("first"))
+	eventually(t, 5*time.Second, "the first manager", func() bool { return status(t, first, "first").State == StateRunning })
+
+	_, second := lockedManager(t, path, "", sleeper("second"))
+	select {
+	case err := <-second:
+		if !errors.Is(err, ErrLocked) || !strings.Contains(err.Error(), "pid "+strconv.Itoa(os.Getpid())) {
+			t.Fatalf("second run: %v, want %s naming the holder", err, ErrLocked)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the second manager runs on a lock held")
+	}
+
+	if err := first.Shutdown(); err != nil {
+		t.Fatalf("shutdown: %s", err)
+	}
+
+	third, _ := lockedManager(t, path, "", sleeper("third"))
+	eventually(t, 5*time.Second, "a manager on the released lock", func() bool { return status(t, third, "third").State == StateRunning })
+}
+
+func TestLockFileLeftByADeadSupervisor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "supervisor.lock")
+	if err := ioutil.WriteFile(path, []byte("999999\n"), 0644); err != nil {
+		t.Fatalf("lock: %s", err)
+	}
+
+	m, _ := lockedManager(t, path, "", sleeper("taker"))
+	eventually(t, 5*time.Second, "the run", func() bool { return status(t, m, "taker").State == StateRunning })
+
+	if holder := lockHolder(path); holder != strconv.Itoa(os.Getpid()) {
+		t.Errorf("the lock names %s, want this supervisor", holder)
+	}
+}
+
+func TestStateLockRefusesAdoption(t *testing.T) {
+	dir := t.TempDir()
+	config := sleeper("adoptable")
+	first, _ := lockedManager(t, "", dir, config)
+	eventually(t, 5*time.Second, "the first run", func() bool { return status(t, first, config.Name).State == StateRunning })
+	pid := status(t, first, config.Name).PID
+
+	// a second supervisor of the directory, without the supervisor lock, reads
+	// the same process left running in the state file
+	second, _ := lockedManager(t, "", dir, config, sleeper("unlocked"))
+	eventually(t, 5*time.Second, "the second manager", func() bool { return status(t, second, "unlocked").State == StateRunning })
+
+	time.Sleep(100 * time.Millisecond)
+	if got := status(t, second, config.Name); got.State != StateNew || got.PID != 0 {
+		t.Fatalf("%s with pid %d, want the locked task left alone", got.State, got.PID)
+	}
+	if err := second.Start(config.Name); !errors.Is(err, ErrLocked) {
+		t.Fatalf("start: %v, want %s", err, ErrLocked)
+	}
+	if got := status(t, first, config.Name); got.PID != pid || got.State != StateRunning {
+		t.Fatalf("the first supervisor runs %d as %s, want %d", got.PID, got.State, pid)
+	}
+
+	// replacing the task keeps the lock, removing it releases it
+	reload(t, first, config)
+	eventually(t, 5*time.Second, "the replaced run", func() bool { return status(t, first, config.Name).State == StateRunning })
+	path := filepath.Join(dir, config.Name+STATE_LOCK_SUFFIX)
+	if f, err := lock(path); !errors.Is(err, ErrLocked) {
+		f.Close()
+		t.Fatalf("the lock of the replaced task is not held: %v", err)
+	}
+
+	if _, err := first.Apply(nil); err != nil {
+		t.Fatalf("apply: %s", err)
+	}
+	f, err := lock(path)
+	if err != nil {
+		t.Fatalf("the lock of the removed task is held: %s", err)
+	}
+	f.Close()
+}
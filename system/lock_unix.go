@@ -0,0 +1,18 @@
+//go:build unix
+
+package system
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an flock on the file, ErrLocked if another process holds it
+func lockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return ErrLocked
+	}
+
+	return err
+}
@@ -0,0 +1,20 @@
+package system
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFile locks a byte far past the end of the file, a lock on the pid the
+// file holds would keep others from reading it. ErrLocked if another process
+// holds it
+func lockFile(f *os.File) error {
+	overlapped := &windows.Overlapped{OffsetHigh: 0x7fffffff}
+	err := windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, overlapped)
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return ErrLocked
+	}
+
+	return err
+}
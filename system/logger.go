@@ -0,0 +1,234 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// components of the supervisor logging, the prefix letter of the lines of the
+// standard logger
+const (
+	LOG_MANAGER = "manager"
+	LOG_SERVICE = "service"
+	LOG_PROCESS = "process"
+	LOG_HISTORY = "history"
+	LOG_EVENTS  = "events"
+)
+
+// keys of the fields of the log lines
+const (
+	FIELD_COMPONENT = "component"
+	FIELD_SERVICE   = "service"
+	FIELD_FILE      = "file"
+	FIELD_PID       = "pid"
+	FIELD_FROM      = "from"
+	FIELD_TO        = "to"
+)
+
+// Logger receives the log of the supervisor: level is one of LEVEL_DEBUG,
+// LEVEL_INFO, LEVEL_WARN and LEVEL_ERROR, fields are key and value pairs like
+// "component", "service", "service", "web", "pid", 42. Log is called from the
+// loops of the services, it does not block
+type Logger interface {
+	Log(level, msg string, fields ...interface{})
+}
+
+var currentLogger = struct {
+	mu     sync.RWMutex
+	logger Logger
+}{logger: NewStdLogger(LEVEL_INFO)}
+
+// SetLogger sends the log of the supervisor to logger, the standard logger
+// logging from LEVEL_INFO if nil
+func SetLogger(logger Logger) {
+	if logger == nil {
+		logger = NewStdLogger(LEVEL_INFO)
+	}
+
+	currentLogger.mu.Lock()
+	defer currentLogger.mu.Unlock()
+
+	currentLogger.logger = logger
+}
+
+func getLogger() Logger {
+	currentLogger.mu.RLock()
+	defer currentLogger.mu.RUnlock()
+
+	return currentLogger.logger
+}
+
+// stdLogger writes the lines of level and above to the log package as
+// "[S][web] message key=value", the prefix is the component and the service
+type stdLogger struct {
+	level string
+}
+
+// NewStdLogger logs the lines of level and above with the log package, like
+// "[P][web] started pid=42"
+func NewStdLogger(level string) Logger {
+	return stdLogger{level: level}
+}
+
+func (l stdLogger) Log(level, msg string, fields ...interface{}) {
+	if !LevelAtLeast(level, l.level) {
+		return
+	}
+
+	var prefix, rest strings.Builder
+	for i := 0; i+1 < len(fields); i += 2 {
+		key := fmt.Sprint(fields[i])
+		switch key {
+		case FIELD_COMPONENT:
+			prefix.WriteString("[" + componentLetter(fmt.Sprint(fields[i+1])) + "]")
+		case FIELD_SERVICE, FIELD_FILE:
+			prefix.WriteString(fmt.Sprintf("[%v]", fields[i+1]))
+		default:
+			rest.WriteString(fmt.Sprintf(" %s=%v", key, fields[i+1]))
+		}
+	}
+
+	if prefix.Len() > 0 {
+		prefix.WriteString(" ")
+	}
+
+	log.Print(prefix.String() + msg + rest.String())
+}
+
+func componentLetter(component string) string {
+	switch component {
+	case LOG_SERVICE:
+		return "S"
+	case LOG_PROCESS:
+		return "P"
+	case LOG_HISTORY:
+		return "H"
+	case LOG_EVENTS:
+		return "E"
+	}
+
+	return "M"
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// SlogLogger logs with a log/slog logger, the fields become its attributes
+func SlogLogger(logger *slog.Logger) Logger {
+	return slogLogger{logger: logger}
+}
+
+func (l slogLogger) Log(level, msg string, fields ...interface{}) {
+	slogLevel := slog.LevelInfo
+	switch level {
+	case LEVEL_DEBUG:
+		slogLevel = slog.LevelDebug
+	case LEVEL_WARN:
+		slogLevel = slog.LevelWarn
+	case LEVEL_ERROR, LEVEL_FATAL:
+		slogLevel = slog.LevelError
+	}
+
+	l.logger.Log(context.Background(), slogLevel, msg, fields...)
+}
+
+// SugaredLogger is the structured logging of zap's *zap.SugaredLogger, which
+// satisfies it without systemgo depending on zap
+type SugaredLogger interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+type zapLogger struct {
+	logger SugaredLogger
+}
+
+// ZapLogger logs with a zap logger, zapLogger.Sugar()
+func ZapLogger(logger SugaredLogger) Logger {
+	return zapLogger{logger: logger}
+}
+
+func (l zapLogger) Log(level, msg string, fields ...interface{}) {
+	switch level {
+	case LEVEL_DEBUG:
+		l.logger.Debugw(msg, fields...)
+	case LEVEL_WARN:
+		l.logger.Warnw(msg, fields...)
+	case LEVEL_ERROR, LEVEL_FATAL:
+		l.logger.Errorw(msg, fields...)
+	default:
+		l.logger.Infow(msg, fields...)
+	}
+}
+
+// FieldsLogger logs with a function taking the fields as a map, like
+// logrus.WithFields(fields).Log(level, msg) after logrus.ParseLevel(level)
+type FieldsLogger func(level, msg string, fields map[string]interface{})
+
+func (f FieldsLogger) Log(level, msg string, fields ...interface{}) {
+	mapped := make(map[string]interface{}, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		mapped[fmt.Sprint(fields[i])] = fields[i+1]
+	}
+
+	f(level, msg, mapped)
+}
+
+// logger is a component of the supervisor logging with its fields
+type logger struct {
+	fields []interface{}
+}
+
+func componentLog(component string, fields ...interface{}) logger {
+	return logger{fields: append([]interface{}{FIELD_COMPONENT, component}, fields...)}
+}
+
+// with returns the logger with more fields
+func (l logger) with(fields ...interface{}) logger {
+	return logger{fields: append(append([]interface{}{}, l.fields...), fields...)}
+}
+
+func (l logger) logf(level, format string, args ...interface{}) {
+	msg := format
+	if len(args) > 0 {
+		msg = fmt.Sprintf(format, args...)
+	}
+
+	getLogger().Log(level, msg, l.fields...)
+}
+
+func (l logger) debugf(format string, args ...interface{}) {
+	l.logf(LEVEL_DEBUG, format, args...)
+}
+
+func (l logger) infof(format string, args ...interface{}) {
+	l.logf(LEVEL_INFO, format, args...)
+}
+
+func (l logger) warnf(format string, args ...interface{}) {
+	l.logf(LEVEL_WARN, format, args...)
+}
+
+func (l logger) errorf(format string, args ...interface{}) {
+	l.logf(LEVEL_ERROR, format, args...)
+}
+
+// managerLog logs for the manager, managerLog.with(FIELD_SERVICE, name) about a service
+var managerLog = componentLog(LOG_MANAGER)
+
+// log logs for the service
+func (s *Service) log() logger {
+	return componentLog(LOG_SERVICE, FIELD_SERVICE, s.Name)
+}
+
+// log logs for the process
+func (p *process) log() logger {
+	return componentLog(LOG_PROCESS, FIELD_SERVICE, p.name)
+}
@@ -2,79 +2,823 @@ package system
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-type manager struct {
-	serviceList *[]Service
+var (
+	ErrServiceNotFound   = errors.New("service not found")
+	ErrServiceExists     = errors.New("service already exists")
+	ErrManagerNotStarted = errors.New("manager is not running")
+	ErrShuttingDown      = errors.New("manager is shutting down")
+)
+
+type Manager struct {
+	// mu guards the service list and the running state
+	mu       sync.Mutex
+	services []*Service
+	// groups are templates and replicated services, their members are in services
+	groups  []ServiceConfig
+	scaling sync.Mutex
 
 	outPipe chan string
 	errPipe chan string
 
+	historyDir   string
+	runtimeRoot  string
+	cgroupRoot   string
+	stateRoot    string
+	lockPath     string
+	configPath   string
+	events       *eventBus
+	outputBudget *outputBudget
+	scheduler    *scheduler
+	defaults     ManagerDefaults
+	binds        binder
+	pipes        pipes
+	groupHealth  groupHealths
+	audit        auditLog
+	standbys     standbys
+	forwarders   forwarders
+
+	ctx       context.Context
 	isRunning bool
+	// loopCount counts the supervision loops and the holds on the manager, Run
+	// finishes once it drops to zero and idle is signalled
+	loopCount int
+	idle      chan struct{}
+	// startedAt is when Run started, usage counts the runs from then
+	startedAt time.Time
+
+	// loops is the context of the supervision loops, done after ctx once the
+	// services have been stopped in order
+	loops     context.Context
+	stopLoops context.CancelFunc
+
+	// shuttingDown is set by Shutdown before the services are stopped, from then
+	// on starts are refused, as they are once ctx is done
+	shuttingDown int32
+
+	// tick of the manager loop, unix nanoseconds
+	tick              int64
+	clockWatch        clockWatch
+	heartbeatPath     string
+	heartbeatInterval time.Duration
+
+	// OnStartup hooks run in order before any service starts, a failure aborts Run
+	OnStartup []Hook
+	// OnShutdown hooks run in order once all services have stopped, failures are logged
+	OnShutdown []Hook
+
+	// Init runs the manager as the init of a container: orphans are reaped, SIGTERM,
+	// SIGINT and SIGQUIT stop the services within INIT_SHUTDOWN_TIMEOUT, unless
+	// set otherwise with SetShutdownTimeout
+	Init bool
+
+	// FailFast stops all services once a service that is not Optional fails
+	FailFast bool
+
+	// finished is closed once Run has returned with runErr
+	finished chan struct{}
+	runErr   error
+
+	mainService     string
+	shutdownTimeout time.Duration
+	shutdown        context.CancelFunc
+
+	// lock is the lock file held while Run runs, passed on by Handoff with the
+	// listeners of handoffFiles. handingOff is set while Handoff waits for the
+	// new supervisor, the services start nothing meanwhile
+	lock           *os.File
+	handoffFiles   map[string]filer
+	handoffTimeout time.Duration
+	handingOff     int32
 }
 
-func NewServiceManager(services []Service) *manager {
-	m := new(manager)
-	m.serviceList = &services
+func NewServiceManager(services []Service) (*Manager, error) {
+	m := new(Manager)
+	m.events = newEventBus()
+	m.outputBudget = newOutputBudget(OUTPUT_BUDGET)
+	m.scheduler = newScheduler(SCHEDULER_WORKERS)
+	m.finished = make(chan struct{})
+	m.idle = make(chan struct{}, 1)
+	m.forwarders.warn = m.warn
+	m.forwarders.budget = m.outputBudget
+
+	for i := range services {
+		service := &services[i]
+
+		if err := ValidateName(service.Name); err != nil {
+			return nil, err
+		}
+
+		if err := validateConfig(service.ServiceConfig); err != nil {
+			return nil, fmt.Errorf("%s: %w", service.Name, err)
+		}
+
+		if IsTemplate(service.Name) {
+			m.groups = append(m.groups, service.ServiceConfig)
+			for _, instance := range service.Instances {
+				instance := newInstance(service.ServiceConfig, instance)
+				if err := ValidateName(instance.Name); err != nil {
+					return nil, err
+				}
+
+				m.services = append(m.services, instance)
+			}
+
+			continue
+		}
+
+		if service.Replicas > 0 {
+			m.groups = append(m.groups, service.ServiceConfig)
+			for i := 0; i < service.Replicas; i++ {
+				m.services = append(m.services, newReplica(service.ServiceConfig, i))
+			}
+
+			continue
+		}
+
+		m.services = append(m.services, service)
+	}
+
+	configs := make([]ServiceConfig, 0, len(services))
+	for i := range services {
+		configs = append(configs, services[i].ServiceConfig)
+	}
+
+	if err := validateDependencies(configs); err != nil {
+		return nil, err
+	}
+
+	if err := validatePipes(configs); err != nil {
+		return nil, err
+	}
+
+	if err := validateStandbys(configs); err != nil {
+		return nil, err
+	}
+
+	if err := validateExternals(configs); err != nil {
+		return nil, err
+	}
+
+	if err := validateCmdFactories(services); err != nil {
+		return nil, err
+	}
+
+	// the status reads the role of a service before Run prepares it
+	for _, service := range m.services {
+		service.outputBudget = m.outputBudget
+		service.scheduler = m.scheduler
+		service.standbys = &m.standbys
+	}
 
 	m.isRunning = false
 
-	bufSize := len(*m.serviceList)
-	log.Printf("[M] buffer size: %d", bufSize)
+	bufSize := len(m.services)
+	managerLog.infof("buffer size: %d", bufSize)
 
 	m.outPipe = make(chan string, bufSize)
 	m.errPipe = make(chan string, bufSize)
 
-	return m
+	return m, nil
+}
+
+// SetHistoryDir enables persisting process history of every service to dir,
+// history is restored from it when the manager starts
+func (m *Manager) SetHistoryDir(dir string) {
+	m.historyDir = dir
 }
 
-func (m *manager) Run(ctx context.Context) {
+func (m *Manager) GetHistoryDir() string {
+	return m.historyDir
+}
+
+// Run starts all services and supervises them until ctx is done and every one
+// of them has stopped, it fails only if a startup hook fails
+func (m *Manager) Run(ctx context.Context) (err error) {
+	ctx, shutdown := context.WithCancel(ctx)
+	defer shutdown()
+	defer m.finish(&err)
+
+	if m.lockPath != "" {
+		// a supervisor handing off to this one passes the lock it holds
+		lockFile := inheritedFile(handoffLockFile)
+		if lockFile == nil {
+			lockFile, err = lock(m.lockPath)
+			if err != nil {
+				return err
+			}
+		} else if err := writeLockHolder(lockFile); err != nil {
+			return err
+		}
+		defer lockFile.Close()
+
+		m.mu.Lock()
+		m.lock = lockFile
+		m.mu.Unlock()
+	}
+
+	m.detectCapabilities()
+
+	if m.Init {
+		done := make(chan struct{})
+		defer close(done)
+
+		m.becomeInit(shutdown, done)
+	}
+
+	if err := runHooks(ctx, m.scheduler, "startup", m.OnStartup, true); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
 	if m.isRunning {
-		log.Fatal("[M] already running")
+		managerLog.errorf("already running")
+		os.Exit(1)
 	}
 
 	m.isRunning = true
-	log.Println("[M] starting services")
+	m.startedAt = time.Now()
+	m.ctx = ctx
+	m.shutdown = shutdown
+	ticked(&m.tick, m.Clock())
+	m.watchClock()
+	managerLog.infof("starting services")
+
+	// the loops are stopped in order once ctx is done
+	m.loops, m.stopLoops = context.WithCancel(context.Background())
+	defer m.stopLoops()
+	go m.stopInOrder(ctx)
+
+	// all pipes are connected before the first consumer starts
+	for _, service := range m.services {
+		m.prepare(service)
+	}
+	steps := startSteps(m.services)
+	groups := append([]ServiceConfig(nil), m.groups...)
+	m.mu.Unlock()
+
+	for _, group := range groups {
+		m.evaluateGroup(group.Name, false)
+	}
+
+	m.startInOrder(steps)
+	if ctx.Err() == nil {
+		m.reportHandoff()
+	}
+
+	if m.heartbeatPath != "" && m.heartbeatInterval > 0 {
+		done := make(chan struct{})
+		defer close(done)
+
+		go m.heartbeat(done)
+	}
+
+	m.wait()
+	m.logUsage()
+	m.forwarders.closeAll()
 
-	var wg sync.WaitGroup
-	for i := range *m.serviceList {
-		service := (*m.serviceList)[i]
+	m.mu.Lock()
+	for _, service := range m.services {
+		service.unlockState()
+	}
+	m.mu.Unlock()
+
+	// ctx is done already, the hooks are limited by their own timeouts
+	runHooks(context.Background(), m.scheduler, "shutdown", m.OnShutdown, false)
+
+	return nil
+}
+
+// Shutdown refuses new starts with ErrShuttingDown, stops all services and waits
+// for Run to return with its error
+func (m *Manager) Shutdown() error {
+	atomic.StoreInt32(&m.shuttingDown, 1)
 
-		wg.Add(1)
-		go func(){
-			service.Run(ctx, m.outPipe, m.errPipe)
-			wg.Done()
-		}()
+	m.mu.Lock()
+	shutdown := m.shutdown
+	m.mu.Unlock()
+
+	if shutdown == nil {
+		return ErrManagerNotStarted
 	}
 
-	m.wait(&wg)
+	managerLog.infof("shutting down")
+	shutdown()
+
+	return m.Wait().Err
 }
 
-func (m manager) wait(wg *sync.WaitGroup) {
-	if !m.isRunning {
-		log.Fatal("[M] not started")
+// isShuttingDown reports whether the services are being stopped for good, must
+// be called holding m.mu
+func (m *Manager) isShuttingDown() bool {
+	return atomic.LoadInt32(&m.shuttingDown) == 1 || (m.ctx != nil && m.ctx.Err() != nil)
+}
+
+// finish records the error Run returns with and releases Wait
+func (m *Manager) finish(err *error) {
+	m.mu.Lock()
+	m.runErr = *err
+	m.mu.Unlock()
+
+	close(m.finished)
+}
+
+func (m *Manager) prepare(service *Service) {
+	service.notify = func(event Event) {
+		m.events.Publish(event)
+		if event.lifecycle() {
+			return
+		}
+
+		go m.propagate(service.Name)
+		if service.group != "" {
+			go m.evaluateGroup(service.group, false)
+		}
+		if service.role() != "" {
+			go m.failover(service.primary())
+		}
+
+		if event.State == StateFailed {
+			m.failed(service)
+		}
+	}
+	service.shutdownTimeout = m.GetShutdownTimeout()
+	service.handingOff = &m.handingOff
+	service.runtimeRoot = m.runtimeRoot
+	service.cgroupRoot = m.cgroupRoot
+	service.stateRoot = m.stateRoot
+	service.pipes = &m.pipes
+	m.pipes.connect(service.ServiceConfig)
+	m.standbys.connect(service.ServiceConfig)
+	service.forwarder = m.forwarders.get(service.LogForward.forService(service.Name))
+
+	// a service replaced by Apply has inherited its history
+	if m.historyDir != "" && service.store == nil {
+		if err := service.restoreHistory(m.historyDir); err != nil {
+			managerLog.with(FIELD_SERVICE, service.Name).errorf("%s", err)
+		}
+		if err := service.restoreState(m.historyDir); err != nil {
+			managerLog.with(FIELD_SERVICE, service.Name).errorf("%s", err)
+		}
+	}
+}
+
+// launch starts the supervision loop of the service unless one runs already,
+// must be called holding m.mu
+func (m *Manager) launch(service *Service, intent runIntent) bool {
+	if err := service.begin(intent); err != nil {
+		service.logBegin(err)
+		return false
 	}
 
-	finished := make(chan struct{})
+	ctx, cancel := context.WithCancel(m.loops)
+	service.cancel = cancel
+
+	m.holdRun()
 	go func() {
-		wg.Wait()
-		close(finished)
+		service.loop(ctx, m.outPipe, m.errPipe)
+		cancel()
+
+		if service.Name == m.mainService {
+			managerLog.infof("main service %s is done, stopping services", service.Name)
+			m.shutdown()
+		}
+
+		m.releaseRun()
 	}()
 
+	return true
+}
+
+// holdRun keeps Run from finishing as if all services were done, must be called
+// holding m.mu
+func (m *Manager) holdRun() {
+	m.loopCount++
+}
+
+// releaseRun ends a hold, the last one signals idle
+func (m *Manager) releaseRun() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.loopCount--
+	if m.loopCount == 0 {
+		m.signalIdle()
+	}
+}
+
+// signalIdle wakes wait, must be called holding m.mu
+func (m *Manager) signalIdle() {
+	select {
+	case m.idle <- struct{}{}:
+	default:
+	}
+}
+
+// retire stops the service within stopTimeout, its own if zero, and ends its
+// supervision loop, the service must already be removed from the service list
+func (m *Manager) retire(service *Service, stopTimeout time.Duration) {
+	m.binds.forget(service.Name)
+	if err := service.sendStop(stopTimeout); err != nil && err != ErrNotRunning && err != ErrExternal {
+		managerLog.with(FIELD_SERVICE, service.Name).errorf("%s", err)
+	}
+
+	m.mu.Lock()
+	cancel := service.cancel
+	m.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+
+	service.mu.RLock()
+	loopDone := service.loopDone
+	service.mu.RUnlock()
+
+	if loopDone != nil {
+		<-loopDone
+	}
+}
+
+// insert adds the service after the other members of its group, must be called holding m.mu
+func (m *Manager) insert(service *Service) {
+	at := len(m.services)
+	for i, s := range m.services {
+		if s.group == service.group {
+			at = i + 1
+		}
+	}
+
+	m.services = append(m.services, nil)
+	copy(m.services[at+1:], m.services[at:])
+	m.services[at] = service
+	service.outputBudget = m.outputBudget
+	service.scheduler = m.scheduler
+	service.standbys = &m.standbys
+
+	if m.isRunning && !m.isShuttingDown() {
+		m.prepare(service)
+		if !service.Disabled {
+			m.launch(service, intentSupervise)
+		}
+	}
+}
+
+func (m *Manager) findGroup(name string) *ServiceConfig {
+	for i := range m.groups {
+		if m.groups[i].Name == name {
+			return &m.groups[i]
+		}
+	}
+
+	return nil
+}
+
+func (m *Manager) members(group string) []*Service {
+	var members []*Service
+	for _, service := range m.services {
+		if service.group == group {
+			members = append(members, service)
+		}
+	}
+
+	return members
+}
+
+func (m *Manager) wait() {
+	m.mu.Lock()
+	if m.loopCount == 0 {
+		m.signalIdle()
+	}
+	m.mu.Unlock()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
 	for {
+		ticked(&m.tick, m.Clock())
+
 		select {
+		case <-ticker.C:
+			m.watchClock()
 		case out := <-m.outPipe:
 			fmt.Println(out)
 		case err := <-m.errPipe:
 			fmt.Println(err)
-		case <-finished:
-			log.Println("[M] finished")
+		case <-m.idle:
+			// a service added since the last loop ended keeps the manager running
+			m.mu.Lock()
+			if m.loopCount > 0 {
+				m.mu.Unlock()
+				continue
+			}
+			m.isRunning = false
+			m.mu.Unlock()
+
+			m.drain()
+			managerLog.infof("finished")
+
 			return
 		}
 	}
+}
 
-	m.isRunning = false
+// drain prints lines left in the pipes once every service has stopped
+func (m *Manager) drain() {
+	for {
+		select {
+		case out := <-m.outPipe:
+			fmt.Println(out)
+		case err := <-m.errPipe:
+			fmt.Println(err)
+		default:
+			return
+		}
+	}
+}
+
+// Instantiate creates a new instance of a template service, the instance is
+// started right away if the manager is running
+func (m *Manager) Instantiate(template, instance string) (*Service, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	template = templateName(template)
+
+	config := m.findGroup(template)
+	if config == nil {
+		return nil, fmt.Errorf("%s: %w", template, ErrServiceNotFound)
+	}
+
+	service := newInstance(*config, instance)
+	if err := ValidateName(service.Name); err != nil {
+		return nil, err
+	}
+
+	if m.findService(service.Name) != nil {
+		return nil, fmt.Errorf("%s: %w", service.Name, ErrServiceExists)
+	}
+
+	m.insert(service)
+
+	return service, nil
+}
+
+// GetJournal returns up to n most recent journal entries of a service, see Service.Journal
+func (m *Manager) GetJournal(name string, n int) ([]JournalEntry, error) {
+	service, err := m.GetService(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.Journal(n), nil
+}
+
+// GetRecentOutput returns up to n of the last captured lines of a service, see Service.RecentOutput
+func (m *Manager) GetRecentOutput(name string, n int) ([]LogLine, error) {
+	service, err := m.GetService(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.RecentOutput(n), nil
+}
+
+// GetConfig returns the configuration a service runs with
+func (m *Manager) GetConfig(name string) (EffectiveConfig, error) {
+	service, err := m.GetService(name)
+	if err != nil {
+		return EffectiveConfig{}, err
+	}
+
+	return service.EffectiveConfig(), nil
+}
+
+// GetSamples returns resource usage samples of a service taken after since
+func (m *Manager) GetSamples(name string, since time.Time) ([]Sample, error) {
+	service, err := m.GetService(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.Samples(since), nil
+}
+
+// GetProcessTree returns the processes of a running service, see Service.ProcessTree
+func (m *Manager) GetProcessTree(name string) ([]ProcInfo, error) {
+	service, err := m.GetService(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.ProcessTree()
+}
+
+func (m *Manager) GetService(name string) (*Service, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if service := m.findService(name); service != nil {
+		return service, nil
+	}
+
+	return nil, fmt.Errorf("%s: %w", name, ErrServiceNotFound)
+}
+
+func (m *Manager) findService(name string) *Service {
+	for _, service := range m.services {
+		if service.Name == name {
+			return service
+		}
+	}
+
+	return nil
+}
+
+// GetServices returns the named service, or all members if name is a template
+// or a replicated service
+func (m *Manager) GetServices(name string) ([]*Service, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.findGroup(name) != nil {
+		return m.members(name), nil
+	}
+
+	if service := m.findService(name); service != nil {
+		return []*Service{service}, nil
+	}
+
+	return nil, fmt.Errorf("%s: %w", name, ErrServiceNotFound)
+}
+
+func (m *Manager) ListServices() []ServiceStatus {
+	m.mu.Lock()
+	services := make([]*Service, len(m.services))
+	copy(services, m.services)
+	m.mu.Unlock()
+
+	statuses := make([]ServiceStatus, 0, len(services))
+	for _, service := range services {
+		statuses = append(statuses, service.Status())
+	}
+
+	return statuses
+}
+
+func (m *Manager) GetStatus(name string) (ServiceStatus, error) {
+	service, err := m.GetService(name)
+	if err != nil {
+		return ServiceStatus{}, err
+	}
+
+	return service.Status(), nil
+}
+
+// Start starts a stopped service, or a finished one that is no longer supervised,
+// a group name starts all of its members
+func (m *Manager) Start(name string) error {
+	return m.each(name, func(service *Service) error {
+		m.binds.forget(service.Name)
+		return m.start(service)
+	})
+}
+
+// Stop stops the service process and keeps it stopped until started again,
+// a group name stops all of its members, the services PartOf it are stopped too
+func (m *Manager) Stop(name string) error {
+	stop := func(service *Service) error {
+		m.binds.forget(service.Name)
+		return service.send(commandStop)
+	}
+
+	err := m.each(name, stop)
+	m.eachPart(name, stop)
+
+	return err
+}
+
+// Restart restarts the service process, a group name restarts all of its
+// members, the services PartOf it are restarted after it
+func (m *Manager) Restart(name string) error {
+	restart := func(service *Service) error {
+		if err := service.send(commandRestart); err != ErrNotRunning {
+			return err
+		}
+
+		return m.start(service)
+	}
+
+	err := m.each(name, restart)
+	m.eachPart(name, restart)
+
+	return err
+}
+
+// eachPart applies fn to the services PartOf the service or group, the errors
+// are logged only
+func (m *Manager) eachPart(name string, fn func(*Service) error) {
+	for _, service := range m.partOf(name) {
+		if err := fn(service); err != nil {
+			managerLog.with(FIELD_SERVICE, service.Name).warnf("part of %s: %s", name, err)
+		}
+	}
+}
+
+// each applies fn to the named service or to every member of a group,
+// returning the first error, an external dependency is refused with ErrExternal
+func (m *Manager) each(name string, fn func(*Service) error) error {
+	services, err := m.GetServices(name)
+	if err != nil {
+		return err
+	}
+
+	var first error
+	for _, service := range services {
+		err := ErrExternal
+		if !service.isExternal() {
+			err = fn(service)
+		}
+
+		if err != nil {
+			managerLog.with(FIELD_SERVICE, service.Name).errorf("%s", err)
+			if first == nil {
+				first = fmt.Errorf("%s: %w", service.Name, err)
+			}
+		}
+	}
+
+	return first
+}
+
+func (m *Manager) start(service *Service) error {
+	if service.isSupervised() {
+		if err := service.send(commandStart); err != ErrNotRunning {
+			return err
+		}
+	}
+
+	// another supervisor holds the lock of its state
+	if err := service.stateLocked(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	if !m.isRunning {
+		m.mu.Unlock()
+		return ErrManagerNotStarted
+	}
+
+	if m.isShuttingDown() {
+		m.mu.Unlock()
+		return ErrShuttingDown
+	}
+
+	if atomic.LoadInt32(&m.handingOff) == 1 {
+		m.mu.Unlock()
+		return ErrHandingOff
+	}
+
+	// a service removed meanwhile is not brought back
+	if m.find(service.Name) != service {
+		m.mu.Unlock()
+		return ErrServiceNotFound
+	}
+
+	launched := m.launch(service, intentStartNow)
+	m.mu.Unlock()
+
+	// a loop launched since the first check takes the request
+	if !launched {
+		return service.send(commandStart)
+	}
+
+	return nil
+}
+
+// Subscribe returns a channel receiving state changes of all services
+func (m *Manager) Subscribe() <-chan Event {
+	return m.events.Subscribe()
+}
+
+func (m *Manager) Unsubscribe(events <-chan Event) {
+	m.events.Unsubscribe(events)
+}
+
+func (m *Manager) FollowOutput(name string, incarnation int) (<-chan LogLine, func(), error) {
+	service, err := m.GetService(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lines, stop := service.FollowOutput(incarnation)
+
+	return lines, stop, nil
 }
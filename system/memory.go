@@ -1,38 +1,120 @@
 package system
 
 import (
-	"bufio"
-	"bytes"
 	"fmt"
-	"os"
+	"sync"
+	"time"
 )
 
-func memoryUsage(pid int) (uint64, error) {
-	f, err := os.Open(fmt.Sprintf("/proc/%d/smaps", pid))
+// memory metrics of MemoryMetric: resident pages, pages shared counted in
+// proportion to their sharers and pages not shared at all
+const (
+	MEMORY_RSS = "rss"
+	MEMORY_PSS = "pss"
+	MEMORY_USS = "uss"
+)
+
+var memoryMetrics = map[string]bool{MEMORY_RSS: true, MEMORY_PSS: true, MEMORY_USS: true}
+
+// ValidateMemoryMetric accepts "rss", "pss", "uss" and an empty metric for pss
+func ValidateMemoryMetric(metric string) error {
+	if metric != "" && !memoryMetrics[metric] {
+		return fmt.Errorf("unknown memory metric %q, expected rss, pss or uss", metric)
+	}
+
+	return nil
+}
+
+func (s *Service) GetMemoryMetric() string {
+	if s.MemoryMetric != "" {
+		return s.MemoryMetric
+	}
+
+	return MEMORY_PSS
+}
+
+// memoryReading is the last memory reading of a service, taken at the Monotonic
+// reading of the clock
+type memoryReading struct {
+	mu     sync.Mutex
+	at     time.Duration
+	pid    int
+	kb     uint64
+	metric string
+	warned bool
+}
+
+// memoryUsage returns the memory of the process tree of pid in kB, the metric
+// summed over its processes, and the metric it was read in: rss on kernels
+// without smaps_rollup. A reading younger than maxAge is reused, the status
+// reuses one of the sample interval. Without memory in /proc the metric is
+// MEMORY_UNAVAILABLE
+func (s *Service) memoryUsage(pid int, maxAge time.Duration) (uint64, string, error) {
+	if !procCapabilities().Memory {
+		return 0, MEMORY_UNAVAILABLE, ErrProcUnavailable
+	}
+
+	r := &s.memory
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.pid == pid && s.getClock().Monotonic()-r.at < maxAge {
+		return r.kb, r.metric, nil
+	}
+
+	kb, metric, err := treeMemory(pid, s.GetMemoryMetric())
+	if err != nil {
+		return 0, metric, err
+	}
+
+	if metric != s.GetMemoryMetric() && !r.warned {
+		r.warned = true
+		s.log().warnf("%s needs /proc/<pid>/smaps_rollup, reading %s instead", s.GetMemoryMetric(), metric)
+	}
+
+	r.at, r.pid, r.kb, r.metric = s.getClock().Monotonic(), pid, kb, metric
+
+	return kb, metric, nil
+}
+
+// treeMemory sums the memory of pid and its descendants in the metric, of pid
+// alone if the tree is not known, processes exiting meanwhile are left out
+func treeMemory(pid int, metric string) (uint64, string, error) {
+	tree, err := processTree(pid)
+	if err != nil {
+		tree = []int{pid}
+	}
+
+	kb, read, err := readMemory(pid, metric)
 	if err != nil {
-		return 0, err
-	}
-	defer f.Close()
-
-	res := uint64(0)
-	pfx := []byte("Pss:")
-	r := bufio.NewScanner(f)
-
-	for r.Scan() {
-		line := r.Bytes()
-		if bytes.HasPrefix(line, pfx) {
-			var size uint64
-			_, err := fmt.Sscanf(string(line[4:]), "%d", &size)
-			if err != nil {
-				return 0, err
-			}
-			res += size
+		return 0, read, err
+	}
+
+	for _, child := range tree[1:] {
+		if childKB, _, err := readMemory(child, metric); err == nil {
+			kb += childKB
 		}
 	}
 
-	if err := r.Err(); err != nil {
-		return 0, err
+	return kb, read, nil
+}
+
+// readMemory returns the memory of pid in kB in the metric, or in rss if the
+// kernel has no smaps_rollup
+func readMemory(pid int, metric string) (uint64, string, error) {
+	if metric == MEMORY_RSS || !procCapabilities().SmapsRollup {
+		kb, err := residentMemory(pid)
+		return kb, MEMORY_RSS, err
+	}
+
+	rollup, err := procFS().SmapsRollup(pid)
+	if err != nil {
+		return 0, metric, err
+	}
+
+	if metric == MEMORY_PSS {
+		return rollup.Pss, metric, nil
 	}
 
-	return res, nil
+	return rollup.USS(), metric, nil
 }
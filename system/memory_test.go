@@ -0,0 +1,194 @@
+package system
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// treeOf is a task of a shell waiting on two sleeping children
+func treeOf(name, metric string) ServiceConfig {
+	return ServiceConfig{
+		Name:           name,
+		Exec:           "/bin/sh",
+		Params:         []string{"-c", "sleep 30 & sleep 30 & wait"},
+		MemoryMetric:   metric,
+		SampleInterval: time.Minute,
+		StopTimeout:    time.Second,
+	}
+}
+
+// bothChildren waits for the children of the shell of treeOf and returns its
+// tree, the memory is not read meanwhile
+func bothChildren(t *testing.T, m *Manager, name string) []int {
+	t.Helper()
+
+	var tree []int
+	eventually(t, 5*time.Second, "both children", func() bool {
+		infos, err := m.GetProcessTree(name)
+		if err != nil || len(infos) != 3 {
+			return false
+		}
+
+		tree = tree[:0]
+		for _, info := range infos {
+			tree = append(tree, info.PID)
+		}
+		return true
+	})
+
+	return tree
+}
+
+// summed reads the memory of every process of the tree of pid one by one
+func summed(t *testing.T, pid int, metric string) uint64 {
+	t.Helper()
+
+	tree, err := processTree(pid)
+	if err != nil {
+		t.Fatalf("tree of %d: %s", pid, err)
+	}
+
+	var kb uint64
+	for _, p := range tree {
+		pkb, _, err := readMemory(p, metric)
+		if err != nil {
+			t.Fatalf("memory of %d: %s", p, err)
+		}
+		kb += pkb
+	}
+
+	return kb
+}
+
+func TestMemorySumsTheTree(t *testing.T) {
+	c := procCapabilities()
+	if !c.Memory || !c.Processes {
+		t.Skip("no memory or processes in /proc")
+	}
+
+	for _, metric := range []string{MEMORY_RSS, MEMORY_PSS, MEMORY_USS} {
+		t.Run(metric, func(t *testing.T) {
+			if metric != MEMORY_RSS && !c.SmapsRollup {
+				t.Skip("no smaps_rollup")
+			}
+
+			config := treeOf("tree-"+metric, metric)
+			m, _ := runManager(t, config)
+
+			tree := bothChildren(t, m, config.Name)
+			want := summed(t, tree[0], metric)
+			main, _, _ := readMemory(tree[0], metric)
+
+			got := status(t, m, config.Name)
+			if got.MemoryMetric != metric {
+				t.Fatalf("metric %q, want %q", got.MemoryMetric, metric)
+			}
+			// pss and uss move with the processes sharing the libraries
+			slack := uint64(0)
+			if metric != MEMORY_RSS {
+				slack = want / 4
+			}
+			if got.MemoryKB+slack < want || got.MemoryKB > want+slack {
+				t.Fatalf("memory %d kB, want the %d kB of the tree", got.MemoryKB, want)
+			}
+			if got.MemoryKB <= main {
+				t.Fatalf("memory %d kB is not above the %d kB of the shell alone", got.MemoryKB, main)
+			}
+		})
+	}
+}
+
+func TestMemoryReadingReusedForTheSampleInterval(t *testing.T) {
+	c := procCapabilities()
+	if !c.Memory || !c.Processes {
+		t.Skip("no memory or processes in /proc")
+	}
+
+	config := treeOf("reused", MEMORY_RSS)
+	m, clock := runManager(t, config)
+
+	tree := bothChildren(t, m, config.Name)
+	first := status(t, m, config.Name).MemoryKB
+
+	child, err := os.FindProcess(tree[2])
+	if err != nil {
+		t.Fatalf("child: %s", err)
+	}
+	if err := child.Kill(); err != nil {
+		t.Fatalf("kill: %s", err)
+	}
+	eventually(t, 5*time.Second, "the child exit", func() bool {
+		tree, _ := processTree(tree[0])
+		return len(tree) == 2
+	})
+
+	if got := status(t, m, config.Name).MemoryKB; got != first {
+		t.Fatalf("memory %d kB within the sample interval, want the reading of %d kB", got, first)
+	}
+
+	clock.Advance(config.SampleInterval)
+	eventually(t, 5*time.Second, "a fresh reading", func() bool {
+		return status(t, m, config.Name).MemoryKB < first
+	})
+}
+
+func TestMemoryFallsBackToRSS(t *testing.T) {
+	c := procCapabilities()
+	if !c.Memory {
+		t.Skip("no memory in /proc")
+	}
+
+	without := c
+	without.SmapsRollup = false
+
+	procState.mu.Lock()
+	procState.capabilities = &without
+	procState.mu.Unlock()
+	t.Cleanup(func() { SetProcRoot("") })
+
+	s := &Service{ServiceConfig: ServiceConfig{Name: "fallback", MemoryMetric: MEMORY_PSS}}
+
+	for i := 0; i < 2; i++ {
+		kb, metric, err := s.memoryUsage(os.Getpid(), 0)
+		if err != nil {
+			t.Fatalf("memory: %s", err)
+		}
+		if metric != MEMORY_RSS || kb == 0 {
+			t.Fatalf("read %d kB in %q, want rss", kb, metric)
+		}
+	}
+
+	if !s.memory.warned {
+		t.Fatal("the fallback was not warned of")
+	}
+}
+
+// benchmarkMemory reads the memory of the test binary, a process with the
+// mappings of the go runtime, in the metric
+func benchmarkMemory(b *testing.B, metric string) {
+	if !procCapabilities().Memory {
+		b.Skip("no memory in /proc")
+	}
+	if metric != MEMORY_RSS && !procCapabilities().SmapsRollup {
+		b.Skip("no smaps_rollup")
+	}
+
+	pid := os.Getpid()
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, _, err := readMemory(pid, metric); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMemoryRSS(b *testing.B) {
+	benchmarkMemory(b, MEMORY_RSS)
+}
+
+func BenchmarkMemoryPSS(b *testing.B) {
+	benchmarkMemory(b, MEMORY_PSS)
+}
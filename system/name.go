@@ -0,0 +1,33 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+)
+
+const SERVICE_NAME_MAX_LENGTH = 128
+
+var ErrInvalidName = errors.New("invalid service name")
+
+// ValidateName accepts names of ascii letters, digits, "-", "_", "." and "@" starting
+// with a letter or a digit, names end up in log lines and file paths
+func ValidateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("%q: %w: empty", name, ErrInvalidName)
+	}
+
+	if len(name) > SERVICE_NAME_MAX_LENGTH {
+		return fmt.Errorf("%q: %w: longer than %d", name, ErrInvalidName, SERVICE_NAME_MAX_LENGTH)
+	}
+
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case i > 0 && (c == '-' || c == '_' || c == '.' || c == '@'):
+		default:
+			return fmt.Errorf("%q: %w: unexpected %q", name, ErrInvalidName, c)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateName(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		valid bool
+	}{
+		{"web", true},
+		{"web-1.api_v2", true},
+		{"web@eu", true},
+		{"Worker9", true},
+		{strings.Repeat("a", SERVICE_NAME_MAX_LENGTH), true},
+
+		{"", false},
+		{strings.Repeat("a", SERVICE_NAME_MAX_LENGTH+1), false},
+		{"50%done", false},
+		{"%s%d", false},
+		{"my app", false},
+		{"tab\tname", false},
+		{"new\nline", false},
+		{"café", false},
+		{"服务", false},
+		{"a/b", false},
+		{"..", false},
+		{"../etc", false},
+		{`a\b`, false},
+		{"-web", false},
+		{".hidden", false},
+		{"@eu", false},
+	} {
+		err := ValidateName(test.name)
+		if test.valid && err != nil {
+			t.Errorf("%q refused: %s", test.name, err)
+		}
+		if !test.valid && !errors.Is(err, ErrInvalidName) {
+			t.Errorf("%q: %v, want %v", test.name, err, ErrInvalidName)
+		}
+
+		// the name is quoted in the error, not spliced into a format
+		if err != nil && strings.Contains(err.Error(), "%!") {
+			t.Errorf("%q: the error %q is formatted with the name", test.name, err)
+		}
+	}
+}
+
+func TestInvalidNamesAreRefused(t *testing.T) {
+	invalid := ServiceConfig{Name: "50%done", Exec: "true"}
+
+	if _, err := NewServiceManager([]Service{{ServiceConfig: invalid}}); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("manager of %q: %v, want %v", invalid.Name, err, ErrInvalidName)
+	}
+
+	m, err := NewServiceManager([]Service{{ServiceConfig: ServiceConfig{Name: "web@", Exec: "true"}}})
+	if err != nil {
+		t.Fatalf("manager: %s", err)
+	}
+	if _, err := m.Add(invalid); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("add of %q: %v, want %v", invalid.Name, err, ErrInvalidName)
+	}
+	if _, err := m.Instantiate("web@", "../etc"); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("instance ../etc: %v, want %v", err, ErrInvalidName)
+	}
+
+	service := NewService(ServiceConfig{Name: "a/b", Exec: "true"})
+	if err := service.Start(context.Background(), nil, nil); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("start of %q: %v, want %v", service.Name, err, ErrInvalidName)
+	}
+}
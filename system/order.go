@@ -0,0 +1,187 @@
+package system
+
+import (
+	"context"
+	"sort"
+)
+
+// startSteps orders the services for startup: a service comes after the ones it
+// is bound to, part of or After, services of the same dependency level by StartOrder,
+// then by name. Services of a level sharing StartOrder form a step, shutdown
+// stops the steps in reverse
+func startSteps(services []*Service) [][]*Service {
+	members := make(map[string][]*Service)
+	for _, service := range services {
+		members[service.Name] = append(members[service.Name], service)
+		if service.group != "" {
+			members[service.group] = append(members[service.group], service)
+		}
+	}
+
+	levels := make(map[*Service]int)
+	visiting := make(map[*Service]bool)
+
+	var level func(service *Service) int
+	level = func(service *Service) int {
+		if l, ok := levels[service]; ok {
+			return l
+		}
+
+		// cycles are refused by validation, one here ends the walk
+		if visiting[service] {
+			return 0
+		}
+		visiting[service] = true
+
+		l := 0
+		for _, name := range service.orderedAfter() {
+			for _, dependency := range members[name] {
+				if d := level(dependency) + 1; d > l {
+					l = d
+				}
+			}
+		}
+		levels[service] = l
+
+		return l
+	}
+
+	sorted := append([]*Service(nil), services...)
+	for _, service := range sorted {
+		level(service)
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		switch {
+		case levels[a] != levels[b]:
+			return levels[a] < levels[b]
+		case a.StartOrder != b.StartOrder:
+			return a.StartOrder < b.StartOrder
+		}
+
+		return a.Name < b.Name
+	})
+
+	var steps [][]*Service
+	for i, service := range sorted {
+		if i == 0 || levels[service] != levels[sorted[i-1]] || service.StartOrder != sorted[i-1].StartOrder {
+			steps = append(steps, nil)
+		}
+		steps[len(steps)-1] = append(steps[len(steps)-1], service)
+	}
+
+	return steps
+}
+
+// orderedAfter names the services and groups started before this one
+func (c ServiceConfig) orderedAfter() []string {
+	names := append(append([]string{}, c.BindsTo...), c.PartOf...)
+
+	return append(names, c.After...)
+}
+
+// StartSequence returns the names of the services in the order they are started
+// in, they are stopped in reverse
+func (m *Manager) StartSequence() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return sequenceNames(startSteps(m.services))
+}
+
+func sequenceNames(steps [][]*Service) []string {
+	var names []string
+	for _, step := range steps {
+		names = append(names, serviceNames(step)...)
+	}
+
+	return names
+}
+
+// startInOrder launches the services step by step, a step begins once every
+// service of the one before has made its first start. A service bound to one
+// down is held until its dependencies are up
+func (m *Manager) startInOrder(steps [][]*Service) {
+	for _, step := range steps {
+		var launched []*Service
+
+		held := make(map[*Service]bool)
+		for _, service := range step {
+			held[service] = !service.Disabled && m.holdIfDown(service)
+		}
+
+		m.mu.Lock()
+		for _, service := range step {
+			if service.Disabled || held[service] || m.find(service.Name) != service || m.isShuttingDown() {
+				continue
+			}
+
+			if m.launch(service, intentSupervise) {
+				launched = append(launched, service)
+			}
+		}
+		m.mu.Unlock()
+
+		for _, service := range launched {
+			select {
+			case <-service.firstStarted():
+			case <-m.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// stopInOrder stops the services once ctx is done, step by step in the reverse
+// of the start sequence, each step once the one after it has stopped. The
+// shutdown timeout of the services counts from the start of it
+func (m *Manager) stopInOrder(ctx context.Context) {
+	<-ctx.Done()
+	at := m.Clock().Monotonic()
+
+	m.mu.Lock()
+	steps := startSteps(m.services)
+	m.mu.Unlock()
+
+	if len(steps) > 1 {
+		managerLog.infof("stopping services in %d steps", len(steps))
+	}
+
+	for i := len(steps) - 1; i >= 0; i-- {
+		var stopping []*Service
+
+		m.mu.Lock()
+		for _, service := range steps[i] {
+			if service.cancel == nil {
+				continue
+			}
+
+			// read by the loop once cancel is seen
+			service.shutdownBy = at + service.shutdownTimeout
+			service.cancel()
+			stopping = append(stopping, service)
+		}
+		m.mu.Unlock()
+
+		for _, service := range stopping {
+			service.mu.RLock()
+			loopDone := service.loopDone
+			service.mu.RUnlock()
+
+			if loopDone != nil {
+				<-loopDone
+			}
+		}
+	}
+
+	// services launched meanwhile, by Apply
+	m.stopLoops()
+}
+
+func (s *Service) firstStarted() <-chan struct{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.firstStart
+}
@@ -0,0 +1,157 @@
+package system
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ORDER_SEQUENCE is the start sequence of orderFixture: by dependency level,
+// then by StartOrder, then by name
+var ORDER_SEQUENCE = []string{"metrics", "cache", "db", "zz-last", "api", "worker", "warmer", "web", "admin"}
+
+// orderFixture is a config of services in four dependency levels, each one a
+// sleeping task of the script
+func orderFixture(script string) []ServiceConfig {
+	task := func(name string, order int) ServiceConfig {
+		return ServiceConfig{
+			Name:        name,
+			Exec:        "/bin/sh",
+			Params:      []string{"-c", script, name},
+			StartOrder:  order,
+			StopTimeout: time.Second,
+		}
+	}
+
+	api := task("api", 0)
+	api.After, api.BindsTo = []string{"db"}, []string{"cache"}
+	worker := task("worker", 5)
+	worker.BindsTo = []string{"db"}
+	warmer := task("warmer", 100)
+	warmer.After = []string{"cache"}
+	web := task("web", 0)
+	web.After = []string{"api"}
+	admin := task("admin", -100)
+	admin.PartOf = []string{"web"}
+
+	return []ServiceConfig{admin, web, warmer, worker, api, task("zz-last", 1000), task("db", 0), task("cache", 0), task("metrics", -10)}
+}
+
+// managerOf is a manager of the configs that is not run
+func managerOf(t *testing.T, configs []ServiceConfig) *Manager {
+	t.Helper()
+
+	services := make([]Service, len(configs))
+	for i, config := range configs {
+		services[i] = Service{ServiceConfig: config}
+	}
+
+	m, err := NewServiceManager(services)
+	if err != nil {
+		t.Fatalf("manager: %s", err)
+	}
+
+	return m
+}
+
+func TestStartSequenceOfFixture(t *testing.T) {
+	configs := orderFixture("sleep 30")
+	m := managerOf(t, configs)
+
+	if got := m.StartSequence(); !reflect.DeepEqual(got, ORDER_SEQUENCE) {
+		t.Fatalf("start sequence %v, want %v", got, ORDER_SEQUENCE)
+	}
+
+	// the order of the definitions does not matter, a reload keeps the sequence
+	reversed := make([]ServiceConfig, len(configs))
+	for i, config := range configs {
+		reversed[len(configs)-1-i] = config
+	}
+	if got := managerOf(t, reversed).StartSequence(); !reflect.DeepEqual(got, ORDER_SEQUENCE) {
+		t.Fatalf("start sequence %v of the reversed config, want %v", got, ORDER_SEQUENCE)
+	}
+
+	plan, err := m.Plan(reversed)
+	if err != nil {
+		t.Fatalf("plan: %s", err)
+	}
+	if !reflect.DeepEqual(plan.StartSequence, ORDER_SEQUENCE) {
+		t.Fatalf("start sequence %v of the plan, want %v", plan.StartSequence, ORDER_SEQUENCE)
+	}
+	if want := "start sequence: " + strings.Join(ORDER_SEQUENCE, ", ") + "\n"; !strings.Contains(plan.String(), want) {
+		t.Fatalf("plan %q, want %q", plan.String(), want)
+	}
+}
+
+func TestStartSteps(t *testing.T) {
+	m := managerOf(t, orderFixture("sleep 30"))
+
+	var steps [][]string
+	for _, step := range startSteps(m.services) {
+		steps = append(steps, serviceNames(step))
+	}
+
+	// services of a level sharing StartOrder start together
+	want := [][]string{{"metrics"}, {"cache", "db"}, {"zz-last"}, {"api"}, {"worker"}, {"warmer"}, {"web"}, {"admin"}}
+	if !reflect.DeepEqual(steps, want) {
+		t.Fatalf("steps %v, want %v", steps, want)
+	}
+}
+
+func TestStartAndStopInOrder(t *testing.T) {
+	stopLog := filepath.Join(t.TempDir(), "stopped")
+
+	// each task appends its name to the log once it is told to stop
+	script := `trap 'echo "$0" >> "$STOP_LOG"; exit 0' TERM; sleep 30 & wait`
+	configs := orderFixture(script)
+	for i := range configs {
+		configs[i].Env = []string{"STOP_LOG=" + stopLog}
+	}
+	m := runManagerOn(t, SystemClock, configs...)
+
+	eventually(t, 10*time.Second, "every task running", func() bool {
+		for _, name := range ORDER_SEQUENCE {
+			if status(t, m, name).State != StateRunning {
+				return false
+			}
+		}
+		return true
+	})
+
+	// a step starts once the one before has, cache and db start together in
+	// either order
+	for i := 1; i < len(ORDER_SEQUENCE); i++ {
+		before, after := status(t, m, ORDER_SEQUENCE[i-1]), status(t, m, ORDER_SEQUENCE[i])
+		if after.Name == "db" {
+			continue
+		}
+		if before.Name == "db" && status(t, m, "cache").StartedAt.After(before.StartedAt) {
+			before = status(t, m, "cache")
+		}
+		if after.StartedAt.Before(before.StartedAt) {
+			t.Errorf("%s started at %s, before %s at %s", after.Name, after.StartedAt, before.Name, before.StartedAt)
+		}
+	}
+
+	if err := m.Shutdown(); err != nil {
+		t.Fatalf("shutdown: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(stopLog)
+	if err != nil {
+		t.Fatalf("stop log: %s", err)
+	}
+	stopped := strings.Fields(string(data))
+
+	// the steps stop in reverse, cache and db together
+	if len(stopped) == len(ORDER_SEQUENCE) && stopped[6] == "db" {
+		stopped[6], stopped[7] = stopped[7], stopped[6]
+	}
+	want := []string{"admin", "web", "warmer", "worker", "api", "zz-last", "cache", "db", "metrics"}
+	if !reflect.DeepEqual(stopped, want) {
+		t.Fatalf("stop sequence %v, want %v", stopped, want)
+	}
+}
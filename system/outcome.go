@@ -0,0 +1,85 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+var ErrInvalidOutcome = errors.New("invalid run outcome")
+
+// ValidateOutcome checks the success exit codes and compiles the failure patterns
+func ValidateOutcome(config ServiceConfig) error {
+	for _, code := range config.SuccessExitCodes {
+		if code < 0 || code > 255 {
+			return fmt.Errorf("%w: successExitCodes %d is not within 0 and 255", ErrInvalidOutcome, code)
+		}
+	}
+
+	for i, pattern := range config.FailurePatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("%w: failurePatterns[%d]: %s", ErrInvalidOutcome, i, err)
+		}
+	}
+
+	return nil
+}
+
+// exitedSuccessfully reports whether the run exited with 0 or one of the
+// SuccessExitCodes, a run killed by a signal counts as 128 plus the signal
+func (s *Service) exitedSuccessfully(record ProcessRecord) bool {
+	code := record.ExitCode
+	if record.Signal > 0 {
+		code = 128 + record.Signal
+	}
+
+	if code == 0 && record.Signal == 0 {
+		return true
+	}
+
+	for _, success := range s.SuccessExitCodes {
+		if code == success && code > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// failurePatterns compiles the FailurePatterns of the service once
+func (s *Service) failurePatterns() []*regexp.Regexp {
+	s.failureOnce.Do(func() {
+		for _, pattern := range s.FailurePatterns {
+			compiled, err := regexp.Compile(pattern)
+			if err != nil {
+				s.log().warnf("invalid failure pattern: %s", err)
+				continue
+			}
+
+			s.failureMatchers = append(s.failureMatchers, compiled)
+		}
+	})
+
+	return s.failureMatchers
+}
+
+// matchFailure keeps the first stderr line of the run matching a failure
+// pattern, the run fails with it however it exits
+func (s *Service) matchFailure(patterns []*regexp.Regexp, running *process, line string) {
+	if running.failedLine != "" {
+		return
+	}
+
+	text := line
+	if len(text) > TRIGGER_MAX_LINE {
+		text = text[:TRIGGER_MAX_LINE]
+	}
+
+	for _, pattern := range patterns {
+		if pattern.MatchString(text) {
+			s.log().infof("output matched failure pattern %q", pattern.String())
+			running.failedLine = text
+			return
+		}
+	}
+}
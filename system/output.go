@@ -0,0 +1,310 @@
+package system
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const OUTPUT_FOLLOW_BUFFER = 256
+
+// OUTPUT_RECENT_LINES is the number of last captured lines kept per service, see Service.RecentOutput
+const OUTPUT_RECENT_LINES = 100
+const STDERR_TAIL_SIZE = 8 << 10
+
+const (
+	STREAM_STDOUT = "stdout"
+	STREAM_STDERR = "stderr"
+	// STREAM_COMBINED is the stream of both outputs written to a single pipe
+	STREAM_COMBINED = "combined"
+)
+
+// default per stream prefixes of the printed lines, see ServiceConfig.OutputPrefix
+var defaultOutputPrefix = map[string]string{
+	STREAM_STDOUT:   "[{service}] ",
+	STREAM_STDERR:   "[{service}] error: ",
+	STREAM_COMBINED: "[{service}] ",
+}
+
+type LogLine struct {
+	Service     string `json:"service"`
+	Incarnation int    `json:"incarnation"`
+	// Labels of the service, shared by its lines and not to be modified
+	Labels map[string]string `json:"labels,omitempty"`
+	Stream string            `json:"stream"`
+	// Hook is the stage of the hook command writing the line, empty for the process
+	Hook string `json:"hook,omitempty"`
+	// Level of the line, see OutputSeverity
+	Level string    `json:"level,omitempty"`
+	Text  string    `json:"text"`
+	Time  time.Time `json:"time"`
+}
+
+// outputFollowers receive a copy of every captured line, slow followers lose the
+// oldest lines rather than stall the process output
+type outputFollowers struct {
+	mu sync.Mutex
+	// queues of the followers with the incarnation they follow, zero for all of them
+	followers map[*lineQueue]int
+
+	// recent are the last keep lines sent, oldest first, OUTPUT_RECENT_LINES if
+	// not set. They count against budget, which drops the oldest of them too
+	recent []LogLine
+	keep   int
+	budget *outputBudget
+}
+
+// setKeep sets the number of recent lines kept, dropping the oldest beyond it
+func (o *outputFollowers) setKeep(keep int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.keep = keep
+	for len(o.recent) > o.getKeep() {
+		o.shift()
+	}
+}
+
+func (o *outputFollowers) getKeep() int {
+	if o.keep > 0 {
+		return o.keep
+	}
+
+	return OUTPUT_RECENT_LINES
+}
+
+// count counts the recent lines against budget for usage, from then on
+func (o *outputFollowers) count(budget *outputBudget, usage *OutputUsage) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.budget == budget {
+		return
+	}
+
+	o.budget.leave(o)
+	o.budget = budget
+	budget.join(o, usage)
+
+	bytes := 0
+	for _, line := range o.recent {
+		bytes += lineSize(line)
+	}
+	budget.charge(o, bytes)
+}
+
+// release stops counting the recent lines, of a removed service
+func (o *outputFollowers) release() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.budget.leave(o)
+	o.budget = nil
+}
+
+// shift drops the oldest recent line, must be called holding o.mu
+func (o *outputFollowers) shift() {
+	line := o.recent[0]
+	o.recent[0] = LogLine{}
+	o.recent = o.recent[1:]
+	o.budget.charge(o, -lineSize(line))
+}
+
+func (o *outputFollowers) trim() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.recent) == 0 {
+		return false
+	}
+
+	line := o.recent[0]
+	o.recent[0] = LogLine{}
+	o.recent = o.recent[1:]
+	o.budget.dropped(o, lineSize(line))
+
+	return true
+}
+
+func (o *outputFollowers) Follow(incarnation int, queue *lineQueue) (<-chan LogLine, func()) {
+	ch := make(chan LogLine)
+	stopped := make(chan struct{})
+
+	o.mu.Lock()
+	if o.followers == nil {
+		o.followers = make(map[*lineQueue]int)
+	}
+	o.followers[queue] = incarnation
+	o.mu.Unlock()
+
+	go func() {
+		defer close(ch)
+
+		for {
+			line, ok := queue.pop(stopped)
+			if !ok {
+				return
+			}
+
+			select {
+			case ch <- line:
+			case <-stopped:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			o.mu.Lock()
+			delete(o.followers, queue)
+			o.mu.Unlock()
+
+			queue.discard()
+			close(stopped)
+		})
+	}
+
+	return ch, stop
+}
+
+// closeAll ends every follower once it received the lines already sent
+func (o *outputFollowers) closeAll() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for queue := range o.followers {
+		delete(o.followers, queue)
+		queue.close()
+	}
+}
+
+// Send copies the line to the recent lines and the followers, the caller keeps
+// the budget
+func (o *outputFollowers) Send(line LogLine) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.recent = append(o.recent, line)
+	o.budget.charge(o, lineSize(line))
+	if len(o.recent) > o.getKeep() {
+		o.shift()
+	}
+
+	for queue, incarnation := range o.followers {
+		if incarnation > 0 && incarnation != line.Incarnation {
+			continue
+		}
+
+		queue.push(line)
+	}
+}
+
+// Recent returns up to n of the last lines sent, oldest first, all of them if n
+// is not positive
+func (o *outputFollowers) Recent(n int) []LogLine {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	lines := o.recent
+	if n > 0 && n < len(lines) {
+		lines = lines[len(lines)-n:]
+	}
+
+	return append([]LogLine(nil), lines...)
+}
+
+// tailBuffer keeps the last size bytes written to it, counted against budget
+// once set by count, which drops its oldest lines too
+type tailBuffer struct {
+	mu     sync.Mutex
+	size   int
+	data   []byte
+	budget *outputBudget
+}
+
+func newTailBuffer(size int) *tailBuffer {
+	tail := new(tailBuffer)
+	tail.size = size
+
+	return tail
+}
+
+// count counts the tail against budget for usage until release
+func (t *tailBuffer) count(budget *outputBudget, usage *OutputUsage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.budget = budget
+	budget.join(t, usage)
+	budget.charge(t, len(t.data))
+}
+
+// release stops counting the tail, it is kept as it is
+func (t *tailBuffer) release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.budget.leave(t)
+	t.budget = nil
+}
+
+// Write keeps the end of p, the caller keeps the budget
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	before := len(t.data)
+	t.data = append(t.data, p...)
+	if over := len(t.data) - t.size; over > 0 {
+		t.data = append(t.data[:0], t.data[over:]...)
+	}
+	t.budget.charge(t, len(t.data)-before)
+
+	return len(p), nil
+}
+
+// trim drops the first line of the tail
+func (t *tailBuffer) trim() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.data) == 0 {
+		return false
+	}
+
+	n := bytes.IndexByte(t.data, '\n') + 1
+	if n == 0 {
+		n = len(t.data)
+	}
+	t.data = t.data[n:]
+	t.budget.dropped(t, n)
+
+	return true
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return string(t.data)
+}
+
+// outputPrefix renders the prefix of printed lines of the stream, replacing
+// {service}, {stream}, {pid} and {run}
+func (s *Service) outputPrefix(stream string, pid, incarnation int) string {
+	prefix, ok := s.OutputPrefix[stream]
+	if !ok {
+		prefix = defaultOutputPrefix[stream]
+	}
+
+	return strings.NewReplacer(
+		"{service}", s.Name,
+		"{stream}", stream,
+		"{pid}", strconv.Itoa(pid),
+		"{run}", strconv.Itoa(incarnation),
+	).Replace(prefix)
+}
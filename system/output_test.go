@@ -0,0 +1,123 @@
+package system
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ALTERNATING writes 500 pairs of lines, to stdout then to stderr
+const ALTERNATING = `i=0; while [ $i -lt 500 ]; do echo "out $i"; echo "err $i" >&2; i=$((i+1)); done`
+
+// alternating checks that the lines are the pairs of ALTERNATING in the order
+// they were written, all of them on the combined stream
+func alternating(t *testing.T, lines []LogLine) {
+	t.Helper()
+
+	if len(lines) != 1000 {
+		t.Fatalf("%d lines, want 1000", len(lines))
+	}
+
+	for i, line := range lines {
+		want := fmt.Sprintf("out %d", i/2)
+		if i%2 == 1 {
+			want = fmt.Sprintf("err %d", i/2)
+		}
+
+		if line.Text != want || line.Stream != STREAM_COMBINED {
+			t.Fatalf("line %d: %q on %s, want %q on the combined stream", i, line.Text, line.Stream, want)
+		}
+	}
+}
+
+func TestCombinedOutputKeepsTheOrder(t *testing.T) {
+	service := NewService(ServiceConfig{
+		Name:          "alternating",
+		Exec:          "/bin/sh",
+		Params:        []string{"-c", ALTERNATING},
+		CombineOutput: true,
+		RecentLines:   2000,
+		RestartPolicy: RESTART_NEVER,
+	})
+
+	out, errs := make(chan string, 2000), make(chan string, 2000)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go service.Run(ctx, out, errs)
+	eventually(t, 10*time.Second, "the run", func() bool { return len(service.History()) == 1 })
+	cancel()
+	service.Wait()
+
+	alternating(t, service.RecentOutput(0))
+
+	// printed to the channel of stdout only
+	if len(out) != 1000 || len(errs) != 0 {
+		t.Errorf("%d lines printed to out and %d to err, want 1000 and none", len(out), len(errs))
+	}
+
+	// stderr is no longer told apart, the tail keeps the end of both
+	stderr := strings.TrimSpace(service.History()[0].Stderr)
+	if !strings.HasSuffix(stderr, "out 499\nerr 499") {
+		t.Errorf("stderr tail %q, want the last lines of both streams", stderr)
+	}
+}
+
+func TestCombinedOutputOfHooks(t *testing.T) {
+	service := NewService(ServiceConfig{
+		Name:          "prepared",
+		Exec:          "true",
+		ExecStartPre:  []HookCommand{{Exec: "/bin/sh", Params: []string{"-c", ALTERNATING}}},
+		CombineOutput: true,
+		RecentLines:   2000,
+		RestartPolicy: RESTART_NEVER,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go service.Run(ctx, nil, nil)
+	eventually(t, 10*time.Second, "the run", func() bool { return len(service.History()) == 1 })
+	cancel()
+	service.Wait()
+
+	var hooked []LogLine
+	for _, line := range service.RecentOutput(0) {
+		if line.Hook != "" {
+			hooked = append(hooked, line)
+		}
+	}
+	alternating(t, hooked)
+}
+
+func TestCombinedOutputHasOnePipe(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		combine  bool
+		discard  bool
+		out, err bool
+	}{
+		{"separate", false, false, true, true},
+		{"combined", true, false, true, false},
+		{"discarded", true, true, false, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			config := sleeper(test.name)
+			config.CombineOutput, config.DiscardOutput = test.combine, test.discard
+			m, _ := runManager(t, config)
+			eventually(t, 5*time.Second, "the run", func() bool { return status(t, m, config.Name).State == StateRunning })
+
+			service, err := m.GetService(config.Name)
+			if err != nil {
+				t.Fatalf("service: %s", err)
+			}
+
+			service.mu.RLock()
+			out, errs := service.running.Out != nil, service.running.Err != nil
+			service.mu.RUnlock()
+			if out != test.out || errs != test.err {
+				t.Errorf("stdout pipe %t and stderr pipe %t, want %t and %t", out, errs, test.out, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package system
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// STATE_SUFFIX ends the name of the state file of a service in the history
+// directory, "<name>.state.json" next to its history
+const STATE_SUFFIX = ".state.json"
+
+// STATE_LOCK_SUFFIX ends the name of the lock file of a service in the history
+// directory, held by the supervisor of its processes so no other one adopts them
+const STATE_LOCK_SUFFIX = ".state.lock"
+
+// serviceState is what is kept of a service across restarts of the supervisor
+// besides its history: the counters the records do not tell and the process
+// left running, if the supervisor ended without stopping it
+type serviceState struct {
+	Incarnation int           `json:"incarnation"`
+	Failures    int           `json:"failures,omitempty"`
+	ForcedKills int           `json:"forcedKills,omitempty"`
+	Running     *runningState `json:"running,omitempty"`
+}
+
+// runningState is the running process of a service, the start time tells it
+// from a later process given the same pid
+type runningState struct {
+	PID         int       `json:"pid"`
+	StartTicks  uint64    `json:"startTicks"`
+	Grouped     bool      `json:"grouped,omitempty"`
+	Incarnation int       `json:"incarnation"`
+	StartedAt   time.Time `json:"startedAt"`
+	ConfigHash  string    `json:"configHash"`
+}
+
+// restoreState reads the state file of the service from dir, the process left
+// running is adopted by the supervision loop. It fails if another supervisor
+// holds the lock file of the service, which is then not started
+func (s *Service) restoreState(dir string) error {
+	s.stateFile = filepath.Join(dir, s.Name+STATE_SUFFIX)
+	if err := s.lockState(filepath.Join(dir, s.Name+STATE_LOCK_SUFFIX)); err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(s.stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load state: %s", err)
+	}
+
+	state := new(serviceState)
+	if err := json.Unmarshal(data, state); err != nil {
+		componentLog(LOG_HISTORY, FIELD_FILE, s.stateFile).warnf("skipping corrupt state: %s", err)
+		return nil
+	}
+
+	s.mu.Lock()
+	if state.Incarnation > s.incarnation {
+		s.incarnation = state.Incarnation
+	}
+	s.forcedKills = state.ForcedKills
+	s.mu.Unlock()
+	s.restored = state
+
+	return nil
+}
+
+// lockState takes the lock file of the service, passed on by the supervisor
+// handing off to this one or left by a dead one
+func (s *Service) lockState(path string) error {
+	file := inheritedFile(handoffStateLock(s.Name))
+	if file != nil {
+		if err := writeLockHolder(file); err != nil {
+			file.Close()
+			return err
+		}
+	} else {
+		var err error
+		if file, err = lock(path); err != nil {
+			s.mu.Lock()
+			s.stateLockErr = err
+			s.mu.Unlock()
+
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	s.stateLock, s.stateLockErr = file, nil
+	s.mu.Unlock()
+
+	return nil
+}
+
+// stateLocked returns why the lock file of the service is not held, nil if it is
+// or there is none
+func (s *Service) stateLocked() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.stateLockErr
+}
+
+// unlockState releases the lock file of the service once it is no longer
+// supervised
+func (s *Service) unlockState() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stateLock != nil {
+		s.stateLock.Close()
+		s.stateLock = nil
+	}
+}
+
+// saveState writes the state file of the service once a process started or
+// ended, the file is replaced as a whole
+func (s *Service) saveState() {
+	if s.stateFile == "" {
+		return
+	}
+
+	state := serviceState{Failures: s.failures}
+	s.mu.RLock()
+	state.Incarnation, state.ForcedKills = s.incarnation, s.forcedKills
+	s.mu.RUnlock()
+
+	if running := s.running; running != nil && running.Running() {
+		state.Running = &runningState{
+			PID:         running.GetPid(),
+			StartTicks:  running.startTicks,
+			Grouped:     running.grouped,
+			Incarnation: running.incarnation,
+			StartedAt:   running.Created,
+			ConfigHash:  s.ConfigHash(),
+		}
+	}
+
+	if err := writeState(s.stateFile, state); err != nil {
+		s.log().warnf("failed to persist state: %s", err)
+	}
+}
+
+func writeState(path string, state serviceState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	temp := path + ".tmp"
+	f, err := os.OpenFile(temp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp, path)
+}
+
+// adopt takes over the process an earlier supervisor left running instead of
+// starting the first run, if its pid is still that process. It continues the
+// failed runs in a row of the restored state either way. A process started
+// with another configuration is restarted
+func (s *Service) adopt() bool {
+	restored := s.restored
+	s.restored = nil
+	if restored == nil {
+		return false
+	}
+
+	s.failures = restored.Failures
+	left := restored.Running
+	if left == nil {
+		return false
+	}
+
+	if left.StartTicks == 0 || startTicks(left.PID) != left.StartTicks {
+		s.log().with(FIELD_PID, left.PID).infof("the process left running is gone")
+		return false
+	}
+
+	running, err := adoptProcess(s.Name, left, s.getClock())
+	if err != nil {
+		s.log().with(FIELD_PID, left.PID).warnf("failed to adopt the process left running: %s", err)
+		return false
+	}
+
+	s.mu.Lock()
+	s.running = running
+	s.runs += 1
+	s.standbyReady = false
+	s.startErr = nil
+	s.mu.Unlock()
+	s.memoryLogAt = running.execAt + MEMORY_LOG_INTERVAL
+
+	s.log().with(FIELD_PID, left.PID).infof("adopted the process left running")
+	s.note(JournalEntry{Type: JOURNAL_ADOPTED, PID: left.PID})
+	s.setState(StateRunning)
+	s.publish(Event{Type: EVENT_STARTED, Incarnation: running.incarnation, Message: "adopted"})
+	s.watchProcess(running)
+
+	if left.ConfigHash != s.ConfigHash() {
+		s.log().infof("the configuration changed since it was started, restarting")
+		s.setIntent(intentStartNow)
+		if err := s.stopRunning(StopReasonOperatorStop); err != nil {
+			s.log().errorf("%s", err)
+		}
+
+		return true
+	}
+
+	s.saveState()
+
+	return true
+}
+
+// adoptProcess wraps a process left running by an earlier supervisor, it is
+// not a child: it is done once it has exited, its output stays where it goes
+func adoptProcess(name string, left *runningState, clock Clock) (*process, error) {
+	found, err := os.FindProcess(left.PID)
+	if err != nil {
+		return nil, err
+	}
+
+	p := new(process)
+	p.name = name
+	p.cmd = &exec.Cmd{Process: found}
+	p.clock = clock
+	p.done = make(chan struct{})
+	p.abort = make(chan struct{})
+	p.umask = UMASK_INHERIT
+	p.adopted = true
+	p.grouped = left.Grouped
+	p.startTicks = left.StartTicks
+	p.incarnation = left.Incarnation
+
+	age := clock.Now().Sub(left.StartedAt)
+	if age < 0 {
+		age = 0
+	}
+	p.Created, p.createdAt = left.StartedAt, clock.Monotonic()-age
+	p.execAt = p.createdAt
+
+	go p.waitAdopted()
+
+	return p, nil
+}
+
+// waitAdopted waits for the exit of an adopted process, by polling a pidfd of
+// it, or its start time without pidfds
+func (p *process) waitAdopted() {
+	pid := p.cmd.Process.Pid
+	if !waitPidfd(pid) {
+		for startTicks(pid) == p.startTicks {
+			time.Sleep(GROUP_POLL_INTERVAL)
+		}
+	}
+
+	p.log().with(FIELD_PID, pid).infof("finished")
+	p.stop()
+	close(p.done)
+}
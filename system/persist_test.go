@@ -0,0 +1,95 @@
+package system
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestAdoptedProcessExit(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("processes are adopted by their start time read from /proc")
+	}
+
+	left := exec.Command("sleep", "30")
+	if err := left.Start(); err != nil {
+		t.Fatalf("start the process left running: %s", err)
+	}
+	defer left.Process.Kill()
+
+	pid := left.Process.Pid
+	ticks := startTicks(pid)
+	if ticks == 0 {
+		t.Skip("no start time of the process")
+	}
+
+	service := NewService(ServiceConfig{
+		Name:          "adoptee",
+		Exec:          "sleep",
+		Params:        []string{"30"},
+		RestartPolicy: RESTART_ON_FAILURE,
+		RestartDelay:  10 * time.Second,
+		StopTimeout:   time.Second,
+	})
+	clock := onFakeClock(service)
+
+	dir := t.TempDir()
+	state := serviceState{
+		Incarnation: 3,
+		Failures:    1,
+		Running: &runningState{
+			PID:         pid,
+			StartTicks:  ticks,
+			Incarnation: 3,
+			StartedAt:   time.Now(),
+			ConfigHash:  service.ConfigHash(),
+		},
+	}
+	if err := writeState(filepath.Join(dir, service.Name+STATE_SUFFIX), state); err != nil {
+		t.Fatalf("write the state: %s", err)
+	}
+	if err := service.restoreState(dir); err != nil {
+		t.Fatalf("restore the state: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := service.Start(ctx, nil, nil); err != nil {
+		t.Fatalf("start: %s", err)
+	}
+	if status := service.Status(); status.PID != pid || status.State != StateRunning {
+		t.Fatalf("pid %d %s, want the adopted %d running", status.PID, status.State, pid)
+	}
+	if result := service.result(); result.last.PID != 0 {
+		t.Errorf("a result of the adopted process before it exited: %+v", result)
+	}
+
+	left.Process.Kill()
+	left.Wait()
+	eventually(t, 5*time.Second, "the exit of the adopted process", func() bool {
+		return service.Status().State == StateRestarting
+	})
+
+	history := service.History()
+	if len(history) != 1 || !history[0].Adopted || history[0].ExitCode != -1 || history[0].StopReason != StopReasonUnknown {
+		t.Fatalf("history %+v, want the adopted run with an unknown exit", history)
+	}
+	if status := service.Status(); status.LastExitCode != -1 || status.LastStopReason != StopReasonUnknown {
+		t.Errorf("last exit %d %s, want -1 %s", status.LastExitCode, status.LastStopReason, StopReasonUnknown)
+	}
+
+	// the restart delay counts from the exit seen, not from a local wait status
+	clock.Advance(5 * time.Second)
+	time.Sleep(50 * time.Millisecond)
+	if runs := service.Status().Runs; runs != 1 {
+		t.Fatalf("restarted halfway through the delay")
+	}
+	advanceUntil(t, clock, 5*time.Second, "the restart", func() bool { return service.Status().Runs == 2 })
+
+	cancel()
+	service.Wait()
+}
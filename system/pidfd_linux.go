@@ -0,0 +1,20 @@
+package system
+
+import "golang.org/x/sys/unix"
+
+// waitPidfd waits for the exit of pid by polling a pidfd of it, false if the
+// kernel has no pidfds
+func waitPidfd(pid int) bool {
+	fd, err := unix.PidfdOpen(pid, 0)
+	if err != nil {
+		return false
+	}
+	defer unix.Close(fd)
+
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	for {
+		if _, err := unix.Poll(fds, -1); err != unix.EINTR {
+			return true
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package system
+
+// waitPidfd has no pidfds to wait with, the pid is polled
+func waitPidfd(pid int) bool {
+	return false
+}
@@ -0,0 +1,381 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// PIPE_BUFFER is the default number of bytes buffered between a producer and its consumer
+const PIPE_BUFFER = 64 << 10
+
+// PIPE_CHUNK is the most read from a producer or written to a consumer at once
+const PIPE_CHUNK = 32 << 10
+
+var ErrInvalidPipe = errors.New("invalid pipe")
+
+var errPipeAborted = errors.New("pipe write aborted")
+
+// pipe carries the stdout of a producer to the stdin of its consumer through the
+// supervisor, it outlives the processes on both ends. Writes wait for room in the
+// buffer, a consumer not keeping up or not running blocks the producer
+type pipe struct {
+	mu       sync.Mutex
+	from, to string
+	size     int
+	pending  []byte
+	// replay keeps the last bytes written for a restarted consumer, nil drops
+	// the buffered bytes instead
+	replay *tailBuffer
+	feeds  int
+
+	data   chan struct{}
+	space  chan struct{}
+	closed chan struct{}
+}
+
+func newPipe(from, to string) *pipe {
+	p := new(pipe)
+	p.from, p.to = from, to
+	p.size = PIPE_BUFFER
+	p.data = make(chan struct{}, 1)
+	p.space = make(chan struct{}, 1)
+	p.closed = make(chan struct{})
+
+	return p
+}
+
+func wake(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+func (p *pipe) configure(size, replay int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.size = PIPE_BUFFER
+	if size > 0 {
+		p.size = size
+	}
+
+	switch {
+	case replay <= 0:
+		p.replay = nil
+	case p.replay == nil || p.replay.size != replay:
+		p.replay = newTailBuffer(replay)
+	}
+
+	wake(p.space)
+}
+
+func (p *pipe) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	select {
+	case <-p.closed:
+	default:
+		close(p.closed)
+	}
+}
+
+// write buffers b, waiting for room until abort is closed, a closed pipe
+// discards the bytes
+func (p *pipe) write(b []byte, abort <-chan struct{}) error {
+	for len(b) > 0 {
+		p.mu.Lock()
+		n := p.size - len(p.pending)
+		if n > len(b) {
+			n = len(b)
+		}
+		if n > 0 {
+			p.pending = append(p.pending, b[:n]...)
+			if p.replay != nil {
+				p.replay.Write(b[:n])
+			}
+			b = b[n:]
+			wake(p.data)
+		}
+		p.mu.Unlock()
+
+		if len(b) == 0 {
+			return nil
+		}
+
+		select {
+		case <-p.space:
+		case <-p.closed:
+			return nil
+		case <-abort:
+			return errPipeAborted
+		}
+	}
+
+	return nil
+}
+
+// take returns the next buffered bytes, waiting for them until done is closed
+func (p *pipe) take(done <-chan struct{}) ([]byte, bool) {
+	for {
+		p.mu.Lock()
+		if n := len(p.pending); n > 0 {
+			if n > PIPE_CHUNK {
+				n = PIPE_CHUNK
+			}
+
+			chunk := append([]byte(nil), p.pending[:n]...)
+			p.pending = append(p.pending[:0], p.pending[n:]...)
+			wake(p.space)
+			p.mu.Unlock()
+
+			return chunk, true
+		}
+		p.mu.Unlock()
+
+		select {
+		case <-p.data:
+		case <-p.closed:
+			return nil, false
+		case <-done:
+			return nil, false
+		}
+	}
+}
+
+// pump moves the stdout of the producer process into the pipe, once the output
+// is aborted after the exit the rest of it is dropped
+func (p *pipe) pump(running *process) {
+	running.readers.Add(1)
+
+	go func() {
+		defer running.readers.Done()
+		defer running.Out.Close()
+
+		buf := make([]byte, PIPE_CHUNK)
+		for {
+			n, err := running.Out.Read(buf)
+			if n > 0 && p.write(buf[:n], running.Aborted()) != nil {
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// feed writes the pipe to the stdin of the consumer process until it is done, a
+// consumer connecting again gets the last replayed bytes or only the new ones
+func (p *pipe) feed(stdin *os.File, running *process) {
+	p.mu.Lock()
+	if p.feeds > 0 {
+		p.pending = p.pending[:0]
+		if p.replay != nil {
+			p.pending = append(p.pending, p.replay.String()...)
+		}
+		wake(p.space)
+	}
+	p.feeds += 1
+	p.mu.Unlock()
+
+	// a pending write fails once stdin is closed
+	go func() {
+		<-running.Done()
+		stdin.Close()
+	}()
+
+	go func() {
+		for {
+			chunk, ok := p.take(running.Done())
+			if !ok {
+				return
+			}
+
+			// the bytes of the failed write are lost, unless replayed
+			if _, err := stdin.Write(chunk); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// pipes are the pipes of the manager by the name of their consumer
+type pipes struct {
+	mu         sync.Mutex
+	byConsumer map[string]*pipe
+}
+
+// connect pipes the producer to the consumer, keeping the buffer of a pipe the
+// consumer has already
+func (r *pipes) connect(config ServiceConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for to, p := range r.byConsumer {
+		if p.from == config.Name && to != config.PipeTo {
+			p.close()
+			delete(r.byConsumer, to)
+		}
+	}
+
+	if config.PipeTo == "" {
+		return
+	}
+
+	if r.byConsumer == nil {
+		r.byConsumer = make(map[string]*pipe)
+	}
+
+	p := r.byConsumer[config.PipeTo]
+	if p == nil || p.from != config.Name {
+		if p != nil {
+			p.close()
+		}
+
+		p = newPipe(config.Name, config.PipeTo)
+		r.byConsumer[config.PipeTo] = p
+	}
+
+	p.configure(config.PipeBuffer, config.PipeReplay)
+}
+
+// forget closes the pipes of the service, from it and to it
+func (r *pipes) forget(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for to, p := range r.byConsumer {
+		if p.from == name || to == name {
+			p.close()
+			delete(r.byConsumer, to)
+		}
+	}
+}
+
+func (r *pipes) input(name string) *pipe {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.byConsumer[name]
+}
+
+func (r *pipes) output(name string) *pipe {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, p := range r.byConsumer {
+		if p.from == name {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// connectPipes makes the pipe of a consumer its stdin and the stdout of a
+// producer an os pipe, the process then exits without waiting for its output to
+// be taken by the consumer. The returned files are the ends of the process, to
+// be closed once it has started, and the pipe stdout is to go to
+func (s *Service) connectPipes(running *process) (*pipe, []*os.File, error) {
+	var files []*os.File
+
+	if s.pipes.input(s.Name) != nil {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to pipe stdin: %s", err)
+		}
+
+		running.cmd.Stdin = r
+		running.stdin = w
+		files = append(files, r)
+	}
+
+	output := s.pipes.output(s.Name)
+	if output == nil {
+		return nil, files, nil
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		closeFiles(append(files, running.stdin))
+		return nil, nil, fmt.Errorf("failed to pipe stdout: %s", err)
+	}
+
+	running.outWriter.Close()
+	running.cmd.Stdout = w
+	running.Out = r
+
+	return output, append(files, w), nil
+}
+
+// feedInput starts writing the pipe of a started consumer to its stdin
+func (s *Service) feedInput(running *process) {
+	if running.stdin == nil {
+		return
+	}
+
+	if input := s.pipes.input(s.Name); input != nil {
+		input.feed(running.stdin, running)
+		return
+	}
+
+	running.stdin.Close()
+}
+
+// validatePipes refuses PipeTo of unknown services, groups, consumers with more
+// than one producer and pipes forming a cycle
+func validatePipes(configs []ServiceConfig) error {
+	byName := make(map[string]ServiceConfig, len(configs))
+	for _, config := range configs {
+		byName[config.Name] = config
+	}
+
+	producers := make(map[string]string)
+	for _, config := range configs {
+		if config.PipeTo == "" {
+			continue
+		}
+
+		consumer, ok := byName[config.PipeTo]
+		switch {
+		case !ok:
+			return fmt.Errorf("%s: %w: no service %s", config.Name, ErrInvalidPipe, config.PipeTo)
+		case IsTemplate(config.Name) || config.Replicas > 0 || IsTemplate(consumer.Name) || consumer.Replicas > 0:
+			return fmt.Errorf("%s: %w: templates and replicas can not be piped", config.Name, ErrInvalidPipe)
+		case config.CombineOutput || config.DiscardOutput:
+			return fmt.Errorf("%s: %w: stdout is combined or discarded", config.Name, ErrInvalidPipe)
+		case producers[config.PipeTo] != "":
+			return fmt.Errorf("%s: %w: %s is piped from %s already", config.Name, ErrInvalidPipe, config.PipeTo, producers[config.PipeTo])
+		}
+
+		producers[config.PipeTo] = config.Name
+	}
+
+	// every service has one consumer at most, a cycle is a chain coming back
+	for _, config := range configs {
+		path := []string{config.Name}
+		for next := byName[config.Name].PipeTo; next != ""; next = byName[next].PipeTo {
+			path = append(path, next)
+			if next == config.Name {
+				return fmt.Errorf("%w: cycle %v", ErrInvalidPipe, path)
+			}
+			if len(path) > len(configs) {
+				break
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// COUNTER reads 500 lines of numbers on stdin and prints the first and last of
+// them, the line before is skipped as a restart may cut it. Reading builtins
+// take no more of stdin than their line
+const COUNTER = `read skip; read first; n=1; while [ $n -lt 500 ] && read last; do n=$((n+1)); done; echo "$first $last"; exit 3`
+
+// pipeline is a producer of numbers piped into the counter, restarted each
+// time it has read its lines
+func pipeline(buffer, replay int) (ServiceConfig, ServiceConfig) {
+	producer := ServiceConfig{
+		Name:        "numbers",
+		Exec:        "seq",
+		Params:      []string{"1", "10000000"},
+		PipeTo:      "counter",
+		PipeBuffer:  buffer,
+		PipeReplay:  replay,
+		StopTimeout: time.Second,
+	}
+	consumer := ServiceConfig{
+		Name:          "counter",
+		Exec:          "/bin/sh",
+		Params:        []string{"-c", COUNTER},
+		RestartPolicy: RESTART_ALWAYS,
+		RestartDelay:  10 * time.Millisecond,
+		StopTimeout:   time.Second,
+	}
+
+	return producer, consumer
+}
+
+// counted returns the first and last numbers the first two runs of the
+// counter read
+func counted(t *testing.T, m *Manager) [2][2]int {
+	t.Helper()
+
+	service, err := m.GetService("counter")
+	if err != nil {
+		t.Fatalf("counter: %s", err)
+	}
+
+	var runs [2][2]int
+	eventually(t, 10*time.Second, "two runs of the counter", func() bool {
+		seen := 0
+		for _, line := range service.RecentOutput(0) {
+			if line.Incarnation < 1 || line.Incarnation > 2 {
+				continue
+			}
+
+			run := &runs[line.Incarnation-1]
+			if _, err := fmt.Sscanf(strings.TrimSpace(line.Text), "%d %d", &run[0], &run[1]); err == nil {
+				seen++
+			}
+		}
+		return seen == 2
+	})
+
+	return runs
+}
+
+func TestPipeAcrossConsumerRestarts(t *testing.T) {
+	for _, c := range []struct {
+		name           string
+		buffer, replay int
+	}{
+		{"dropped", 4 << 10, 0},
+		// the replay holds all of the output written before the restart, the
+		// producer is held back by the buffer and the stdin of the counter
+		{"replayed", 4 << 10, 1 << 20},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			producer, consumer := pipeline(c.buffer, c.replay)
+			m := runManagerOn(t, SystemClock, producer, consumer)
+			runs := counted(t, m)
+
+			if runs[0] != [2]int{2, 501} {
+				t.Fatalf("first run read %d to %d, want 2 to 501", runs[0][0], runs[0][1])
+			}
+
+			second := runs[1]
+			switch {
+			case second[1]-second[0] != 499:
+				t.Fatalf("second run read %d to %d, want 500 lines in order", second[0], second[1])
+			case c.replay == 0 && second[0] <= 502:
+				t.Fatalf("second run read from %d, want the buffered output dropped", second[0])
+			case c.replay > 0 && second != runs[0]:
+				t.Fatalf("second run read %d to %d, want the output replayed from 2", second[0], second[1])
+			}
+
+			// seq is blocked on the pipe, it would have printed all of its numbers
+			// in a moment otherwise
+			if state := status(t, m, "numbers").State; state != StateRunning {
+				t.Fatalf("producer %s, want it held back by the counter", state)
+			}
+		})
+	}
+}
+
+func TestPipeWriteWaitsForRoom(t *testing.T) {
+	p := newPipe("producer", "consumer")
+	p.configure(4, 0)
+
+	written := make(chan error, 1)
+	go func() { written <- p.write([]byte("0123456789"), nil) }()
+
+	var read []byte
+	for len(read) < 10 {
+		select {
+		case err := <-written:
+			t.Fatalf("write of 10 bytes to a buffer of 4 returned %v with %q read", err, read)
+		default:
+		}
+
+		chunk, ok := p.take(nil)
+		if !ok || len(chunk) > 4 {
+			t.Fatalf("chunk %q, want 4 bytes at most", chunk)
+		}
+		read = append(read, chunk...)
+	}
+
+	if err := <-written; err != nil || string(read) != "0123456789" {
+		t.Fatalf("write %v, read %q", err, read)
+	}
+
+	// a producer exiting with a full buffer gives up its write
+	abort := make(chan struct{})
+	go func() { written <- p.write([]byte("0123456789"), abort) }()
+	close(abort)
+	if err := <-written; !errors.Is(err, errPipeAborted) {
+		t.Fatalf("aborted write: %v", err)
+	}
+
+	// the pipe of a removed consumer does not hold the producer back
+	p.close()
+	if err := p.write([]byte("0123456789"), nil); err != nil {
+		t.Fatalf("write to a closed pipe: %s", err)
+	}
+}
+
+func TestValidatePipes(t *testing.T) {
+	piped := func(name, to string) ServiceConfig {
+		return ServiceConfig{Name: name, Exec: "cat", PipeTo: to}
+	}
+
+	if err := validatePipes([]ServiceConfig{piped("a", "b"), piped("b", "c"), piped("c", "")}); err != nil {
+		t.Fatalf("chain: %s", err)
+	}
+
+	combined := piped("a", "b")
+	combined.CombineOutput = true
+	for name, configs := range map[string][]ServiceConfig{
+		"self":          {piped("a", "a")},
+		"cycle":         {piped("a", "b"), piped("b", "a")},
+		"longer cycle":  {piped("a", "b"), piped("b", "c"), piped("c", "a")},
+		"unknown":       {piped("a", "b")},
+		"two producers": {piped("a", "c"), piped("b", "c"), piped("c", "")},
+		"combined":      {combined, piped("b", "")},
+	} {
+		if err := validatePipes(configs); !errors.Is(err, ErrInvalidPipe) {
+			t.Errorf("%s: %v, want ErrInvalidPipe", name, err)
+		}
+	}
+}
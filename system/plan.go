@@ -0,0 +1,339 @@
+package system
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PlanAction tells what applying a configuration would do to a service
+type PlanAction int
+
+const (
+	PlanUnchanged PlanAction = iota
+	PlanAdded
+	PlanRemoved
+	PlanRestart
+	PlanUpdate
+)
+
+var planActionNames = map[PlanAction]string{
+	PlanUnchanged: "unchanged",
+	PlanAdded:     "added",
+	PlanRemoved:   "removed",
+	PlanRestart:   "restart-required",
+	PlanUpdate:    "update",
+}
+
+// liveFields are the configuration keys applied to a service in place, they
+// tell how it is started, restarted and stopped rather than what its process
+// runs, a change of any other key replaces the service
+var liveFields = map[string]bool{
+	"restartDelay":       true,
+	"restart":            true,
+	"restartPolicy":      true,
+	"restartBackoff":     true,
+	"restartMaxDelay":    true,
+	"restartJitter":      true,
+	"startLimitBurst":    true,
+	"startLimitInterval": true,
+	"startTimeout":       true,
+	"startRetries":       true,
+	"slowStartThreshold": true,
+	"stopTimeout":        true,
+	"execStartPre":       true,
+	"execStartPost":      true,
+	"execStopPost":       true,
+	"stopSignal":         true,
+	"maxHistory":         true,
+	"successExitCodes":   true,
+}
+
+func (a PlanAction) String() string {
+	if name, ok := planActionNames[a]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("action(%d)", int(a))
+}
+
+func (a PlanAction) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// PlanChange is the planned action of a single service definition, Fields are
+// the changed configuration keys of a restart or an update. RunningHash is the Hash of the
+// definition running, Hash the one of the configuration, a restart is required
+// when they differ. Dependents of a removal are the definitions of the
+// configuration naming the service in BindsTo, PartOf or After
+type PlanChange struct {
+	Name        string     `json:"name"`
+	Action      PlanAction `json:"action"`
+	Fields      []string   `json:"fields,omitempty"`
+	RunningHash string     `json:"runningHash,omitempty"`
+	Hash        string     `json:"hash,omitempty"`
+	Dependents  []string   `json:"dependents,omitempty"`
+}
+
+// Plan lists what applying a configuration would do, definitions of the new
+// configuration come first in their order, removed ones last
+type Plan struct {
+	Changes []PlanChange `json:"changes"`
+
+	// StartSequence is the order the definitions would start in
+	StartSequence []string `json:"startSequence"`
+}
+
+// HasChanges reports whether applying the configuration would touch any service
+func (p Plan) HasChanges() bool {
+	for _, change := range p.Changes {
+		if change.Action != PlanUnchanged {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p Plan) String() string {
+	var b strings.Builder
+	for _, change := range p.Changes {
+		fmt.Fprintf(&b, "%s: %s", change.Name, change.Action)
+		if len(change.Fields) > 0 {
+			fmt.Fprintf(&b, " (%s)", strings.Join(change.Fields, ", "))
+		}
+		if len(change.Dependents) > 0 {
+			fmt.Fprintf(&b, " (needed by %s)", strings.Join(change.Dependents, ", "))
+		}
+		b.WriteString("\n")
+	}
+
+	if len(p.StartSequence) > 0 {
+		fmt.Fprintf(&b, "start sequence: %s\n", strings.Join(p.StartSequence, ", "))
+	}
+
+	return b.String()
+}
+
+// Plan compares the configuration with the one services run with, without
+// touching any of them. Manager defaults are applied to the configuration first,
+// templates and replicated services are compared as defined, not per instance or replica
+func (m *Manager) Plan(configs []ServiceConfig) (Plan, error) {
+	planned := make(map[string]bool)
+	for _, config := range configs {
+		if err := ValidateName(config.Name); err != nil {
+			return Plan{}, err
+		}
+
+		if planned[config.Name] {
+			return Plan{}, fmt.Errorf("%s: %w", config.Name, ErrServiceExists)
+		}
+
+		planned[config.Name] = true
+	}
+
+	defaults := m.GetDefaults()
+	configs = append([]ServiceConfig(nil), configs...)
+	for i := range configs {
+		configs[i] = defaults.apply(configs[i])
+	}
+
+	current := m.definitions()
+	byName := make(map[string]ServiceConfig)
+	for _, config := range current {
+		byName[config.Name] = config
+	}
+
+	var plan Plan
+	for _, config := range configs {
+		running, ok := byName[config.Name]
+		if !ok {
+			plan.Changes = append(plan.Changes, PlanChange{Name: config.Name, Action: PlanAdded, Hash: config.Hash()})
+			continue
+		}
+
+		change := PlanChange{Name: config.Name, Action: PlanUnchanged, RunningHash: running.Hash(), Hash: config.Hash()}
+		if change.RunningHash != change.Hash {
+			change.Action = PlanRestart
+			change.Fields = changedFields(running.canonical(), config.canonical())
+			if onlyLive(change.Fields) {
+				change.Action = PlanUpdate
+			}
+		}
+
+		plan.Changes = append(plan.Changes, change)
+	}
+
+	for _, config := range current {
+		if !planned[config.Name] {
+			plan.Changes = append(plan.Changes, PlanChange{
+				Name:        config.Name,
+				Action:      PlanRemoved,
+				RunningHash: config.Hash(),
+				Dependents:  dependents(configs, config.Name),
+			})
+		}
+	}
+
+	definitions := make([]*Service, 0, len(configs))
+	for _, config := range configs {
+		definitions = append(definitions, NewService(config))
+	}
+	plan.StartSequence = sequenceNames(startSteps(definitions))
+
+	return plan, nil
+}
+
+// dependents returns the names of the configurations naming the service among
+// the ones they start after, sorted
+func dependents(configs []ServiceConfig, name string) []string {
+	var names []string
+	for _, config := range configs {
+		for _, dependency := range config.orderedAfter() {
+			if dependency == name {
+				names = append(names, config.Name)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// definitions returns configurations as defined: groups and services not belonging to one
+func (m *Manager) definitions() []ServiceConfig {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var configs []ServiceConfig
+	for _, service := range m.services {
+		if service.group == "" {
+			configs = append(configs, service.config())
+		}
+	}
+
+	return append(configs, m.groups...)
+}
+
+// canonical returns the comparable form of the configuration, defaults are
+// expanded and lists whose order does not matter are sorted
+func (c ServiceConfig) canonical() ServiceConfig {
+	service := NewService(c)
+
+	config := c
+	config.RestartDelay = service.GetRestartDelay()
+	config.Restart = 0
+	config.RestartPolicy = service.getRestartPolicy()
+	config.MaxHistory = service.getMaxHistory()
+	config.StartTimeout = service.GetStartTimeout()
+	config.StartRetries = service.getStartRetries()
+	config.StopTimeout = service.GetStopTimeout()
+	config.StderrTailSize = service.getStderrTailSize()
+	config.JournalSize = service.getJournalSize()
+	config.SampleInterval = service.GetSampleInterval()
+	config.SampleRetention = service.GetSampleRetention()
+	config.MemoryMetric = service.GetMemoryMetric()
+	config.CoreKeep = service.getCoreKeep()
+	config.Probe.Interval = c.Probe.GetInterval()
+	config.Probe.Timeout = c.Probe.GetTimeout()
+	config.Probe.Params = nonEmpty(c.Probe.Params)
+
+	// explicit keys matter through the values they set
+	config.Explicit = nil
+	config.defaulted = nil
+
+	config.Params = nonEmpty(c.Params)
+	config.Env = canonicalEnv(c.Env)
+	config.Ports = sortedCopy(c.Ports)
+	config.Instances = sortedCopy(c.Instances)
+	config.BindsTo = sortedCopy(c.BindsTo)
+	config.PartOf = sortedCopy(c.PartOf)
+	config.After = sortedCopy(c.After)
+	if len(c.Labels) == 0 {
+		config.Labels = nil
+	}
+
+	config.OutputPrefix = make(map[string]string)
+	for stream := range defaultOutputPrefix {
+		config.OutputPrefix[stream] = service.outputPrefix(stream, 0, 0)
+	}
+	for stream := range c.OutputPrefix {
+		config.OutputPrefix[stream] = service.outputPrefix(stream, 0, 0)
+	}
+
+	return config
+}
+
+// canonicalEnv sorts the variables by name, a variable set more than once keeps
+// its last value as the process would see it
+func canonicalEnv(env []string) []string {
+	values := make(map[string]string)
+	for _, variable := range env {
+		name := strings.SplitN(variable, "=", 2)[0]
+		values[name] = variable
+	}
+
+	canonical := make([]string, 0, len(values))
+	for _, variable := range values {
+		canonical = append(canonical, variable)
+	}
+	sort.Strings(canonical)
+
+	return nonEmpty(canonical)
+}
+
+func sortedCopy(list []string) []string {
+	sorted := append([]string{}, list...)
+	sort.Strings(sorted)
+
+	return nonEmpty(sorted)
+}
+
+// nonEmpty returns nil for empty lists, so an empty list equals a missing one
+func nonEmpty(list []string) []string {
+	if len(list) == 0 {
+		return nil
+	}
+
+	return list
+}
+
+// onlyLive reports whether the changed keys are all applied in place
+func onlyLive(fields []string) bool {
+	for _, field := range fields {
+		if !liveFields[field] {
+			return false
+		}
+	}
+
+	return len(fields) > 0
+}
+
+// changedFields lists configuration keys differing between the configurations
+func changedFields(a, b ServiceConfig) []string {
+	var fields []string
+
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := 0; i < va.NumField(); i++ {
+		if va.Type().Field(i).PkgPath != "" {
+			continue
+		}
+
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			fields = append(fields, configKey(va.Type().Field(i).Name))
+		}
+	}
+
+	return fields
+}
+
+// configKey returns the field name as written in the configuration, "restartDelay"
+func configKey(field string) string {
+	first, size := utf8.DecodeRuneInString(field)
+
+	return string(unicode.ToLower(first)) + field[size:]
+}
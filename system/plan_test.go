@@ -0,0 +1,111 @@
+package system
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// changeOf returns the planned change of the named definition
+func changeOf(t *testing.T, plan Plan, name string) PlanChange {
+	t.Helper()
+
+	for _, change := range plan.Changes {
+		if change.Name == name {
+			return change
+		}
+	}
+	t.Fatalf("no change of %s in %+v", name, plan.Changes)
+
+	return PlanChange{}
+}
+
+func TestPlan(t *testing.T) {
+	db := sleeper("db")
+	web := sleeper("web")
+	web.Env = []string{"A=1", "B=2"}
+	web.After = []string{"db"}
+	worker := sleeper("worker")
+	worker.Restart = 3
+	m, _ := runManager(t, db, web, worker)
+	eventually(t, 5*time.Second, "the first run of web", func() bool { return status(t, m, "web").State == StateRunning })
+	before := status(t, m, "web")
+
+	web.Env = []string{"B=2", "A=1"}
+	worker.Restart, worker.RestartDelay = 0, 3*time.Second
+	for _, test := range []struct {
+		name    string
+		configs []ServiceConfig
+		want    PlanAction
+		fields  []string
+	}{
+		{"web", []ServiceConfig{db, web, worker}, PlanUnchanged, nil},
+		{"worker", []ServiceConfig{db, web, worker}, PlanUnchanged, nil},
+		{"web", []ServiceConfig{db, withExec(web, "/bin/web"), worker}, PlanRestart, []string{"exec"}},
+		{"web", []ServiceConfig{db, withEnv(web, "A=2"), worker}, PlanRestart, []string{"env"}},
+		{"worker", []ServiceConfig{db, web, withRestartDelay(worker, time.Second)}, PlanUpdate, []string{"restartDelay"}},
+		{"cron", []ServiceConfig{db, web, worker, sleeper("cron")}, PlanAdded, nil},
+	} {
+		plan, err := m.Plan(test.configs)
+		if err != nil {
+			t.Fatalf("plan: %s", err)
+		}
+
+		change := changeOf(t, plan, test.name)
+		if change.Action != test.want || !reflect.DeepEqual(change.Fields, test.fields) {
+			t.Errorf("%s: %s %v, want %s %v", test.name, change.Action, change.Fields, test.want, test.fields)
+		}
+	}
+
+	// planning touches no process
+	if after := status(t, m, "web"); after.PID != before.PID || after.Runs != before.Runs || after.State != StateRunning {
+		t.Errorf("web %s pid %d with %d runs after planning, want pid %d untouched", after.State, after.PID, after.Runs, before.PID)
+	}
+}
+
+func TestPlanRemovalOfADependency(t *testing.T) {
+	db := sleeper("db")
+	web := sleeper("web")
+	web.After = []string{"db"}
+	worker := sleeper("worker")
+	worker.BindsTo = []string{"db"}
+	m, _ := runManager(t, db, web, worker)
+
+	plan, err := m.Plan([]ServiceConfig{web, worker})
+	if err != nil {
+		t.Fatalf("plan: %s", err)
+	}
+
+	change := changeOf(t, plan, "db")
+	if change.Action != PlanRemoved || !reflect.DeepEqual(change.Dependents, []string{"web", "worker"}) {
+		t.Errorf("db: %s needed by %v, want %s needed by [web worker]", change.Action, change.Dependents, PlanRemoved)
+	}
+	if rendered := plan.String(); !strings.Contains(rendered, "db: removed (needed by web, worker)") {
+		t.Errorf("plan %q, want the dependents of db", rendered)
+	}
+
+	// dependents removed with it are not impacted
+	plan, err = m.Plan([]ServiceConfig{worker})
+	if err != nil {
+		t.Fatalf("plan: %s", err)
+	}
+	if dependents := changeOf(t, plan, "db").Dependents; !reflect.DeepEqual(dependents, []string{"worker"}) {
+		t.Errorf("db needed by %v, want [worker]", dependents)
+	}
+}
+
+func withExec(config ServiceConfig, exec string) ServiceConfig {
+	config.Exec = exec
+	return config
+}
+
+func withEnv(config ServiceConfig, env ...string) ServiceConfig {
+	config.Env = append(append([]string(nil), config.Env...), env...)
+	return config
+}
+
+func withRestartDelay(config ServiceConfig, delay time.Duration) ServiceConfig {
+	config.RestartDelay = delay
+	return config
+}
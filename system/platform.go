@@ -0,0 +1,61 @@
+package system
+
+import (
+	"errors"
+	"runtime"
+	"time"
+)
+
+// ErrUnsupportedPlatform is a feature the platform the supervisor runs on does
+// not have, like process groups or cgroups on windows
+var ErrUnsupportedPlatform = errors.New("not supported on " + runtime.GOOS)
+
+// ProcessStats is what the platform tells of a process: /proc on linux, sysctl
+// and proc_info on darwin, the toolhelp snapshot and the process times on
+// windows. A field the platform does not give is zero
+type ProcessStats struct {
+	PID  int
+	PPID int
+	// PGID is the process group, zero on windows
+	PGID int
+	// Name is the name of the executable, the command line on linux is read apart
+	Name string
+
+	// Start is the start time of the process in units of the platform, telling
+	// it from a later process given the same pid
+	Start uint64
+	// Exited tells a zombie waiting to be reaped by its parent
+	Exited bool
+
+	UserCPU   time.Duration
+	SystemCPU time.Duration
+
+	// RSSKB and PeakRSSKB are the resident memory in kB, read only if asked for
+	RSSKB     uint64
+	PeakRSSKB uint64
+}
+
+// parentPid returns the parent pid of pid
+func parentPid(pid int) (int, error) {
+	stats, err := processStats(pid, false)
+
+	return stats.PPID, err
+}
+
+// startTicks returns the start time of pid, 0 if it is gone or exited
+func startTicks(pid int) uint64 {
+	stats, err := processStats(pid, false)
+	if err != nil || stats.Exited {
+		return 0
+	}
+
+	return stats.Start
+}
+
+// residentMemory returns the resident memory of pid in kB, zero for kernel
+// threads and zombies
+func residentMemory(pid int) (uint64, error) {
+	stats, err := processStats(pid, true)
+
+	return stats.RSSKB, err
+}
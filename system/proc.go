@@ -0,0 +1,153 @@
+package system
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// processTree returns pid followed by all of its descendants, processes
+// exiting while /proc is walked are skipped
+func processTree(pid int) ([]int, error) {
+	if !procCapabilities().Processes {
+		return nil, ErrProcUnavailable
+	}
+
+	processes, err := listProcesses()
+	if err != nil {
+		return nil, err
+	}
+
+	children := make(map[int][]int)
+	for _, child := range processes {
+		children[child.PPID] = append(children[child.PPID], child.PID)
+	}
+
+	// breadth first, deep trees do not grow the stack
+	tree := []int{pid}
+	for i := 0; i < len(tree); i++ {
+		tree = append(tree, children[tree[i]]...)
+	}
+
+	return tree, nil
+}
+
+// socketInodes returns inodes of sockets opened by the given processes
+func socketInodes(pids []int) map[uint64]bool {
+	inodes := make(map[uint64]bool)
+
+	for _, pid := range pids {
+		dir := procPath("%d/fd", pid)
+		fds, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, fd := range fds {
+			link, err := os.Readlink(dir + "/" + fd.Name())
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+
+			inode, err := strconv.ParseUint(strings.TrimSuffix(link[len("socket:["):], "]"), 10, 64)
+			if err == nil {
+				inodes[inode] = true
+			}
+		}
+	}
+
+	return inodes
+}
+
+// processCommand returns the command line of pid, or its name if the command line is empty
+func processCommand(pid int) string {
+	cmdline, err := ioutil.ReadFile(procPath("%d/cmdline", pid))
+	if err == nil && len(cmdline) > 0 {
+		return strings.TrimSpace(string(bytes.ReplaceAll(cmdline, []byte{0}, []byte{' '})))
+	}
+
+	comm, err := ioutil.ReadFile(procPath("%d/comm", pid))
+	if err == nil {
+		return strings.TrimSpace(string(comm))
+	}
+
+	// the platform has no /proc
+	stats, err := processStats(pid, false)
+	if err != nil {
+		return ""
+	}
+
+	return stats.Name
+}
+
+// ProcInfo describes a process of a service process tree
+type ProcInfo struct {
+	PID     int    `json:"pid"`
+	PPID    int    `json:"ppid"`
+	Command string `json:"command"`
+	RssKB   uint64 `json:"rssKb"`
+	// MemoryKB in the MemoryMetric of the service, MEMORY_UNAVAILABLE if /proc
+	// does not tell
+	MemoryKB     uint64 `json:"memoryKb"`
+	MemoryMetric string `json:"memoryMetric"`
+}
+
+func procInfo(pid int, metric string) (ProcInfo, error) {
+	ppid, err := parentPid(pid)
+	if err != nil {
+		return ProcInfo{}, err
+	}
+
+	info := ProcInfo{PID: pid, PPID: ppid, Command: processCommand(pid), MemoryMetric: MEMORY_UNAVAILABLE}
+	if !procCapabilities().Memory {
+		return info, nil
+	}
+
+	rss, err := residentMemory(pid)
+	if err != nil {
+		return ProcInfo{}, err
+	}
+
+	info.RssKB, info.MemoryKB, info.MemoryMetric = rss, rss, MEMORY_RSS
+	if metric != MEMORY_RSS {
+		if info.MemoryKB, info.MemoryMetric, err = readMemory(pid, metric); err != nil {
+			return ProcInfo{}, err
+		}
+	}
+
+	return info, nil
+}
+
+// ProcessTree returns the running process followed by all of its descendants,
+// empty if no process is running, processes exiting meanwhile are left out
+func (s *Service) ProcessTree() ([]ProcInfo, error) {
+	s.mu.RLock()
+	var pid int
+	if s.running != nil && s.running.Running() {
+		pid = s.running.cmd.Process.Pid
+	}
+	s.mu.RUnlock()
+
+	if pid == 0 {
+		return []ProcInfo{}, nil
+	}
+
+	tree, err := processTree(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]ProcInfo, 0, len(tree))
+	for _, child := range tree {
+		info, err := procInfo(child, s.GetMemoryMetric())
+		if err != nil {
+			continue
+		}
+
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
@@ -0,0 +1,196 @@
+//go:build !darwin && !windows
+
+package system
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// procFixture copies the fixture tree of procfs to a directory of the test and
+// reads /proc there, as if the test process had processes and memory in it
+func procFixture(t *testing.T) string {
+	t.Helper()
+
+	root := t.TempDir()
+	err := filepath.Walk("../procfs/testdata/proc", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(root, strings.TrimPrefix(path, "../procfs/testdata/proc"))
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		return ioutil.WriteFile(target, data, 0644)
+	})
+	if err != nil {
+		t.Fatalf("fixture: %s", err)
+	}
+
+	SetProcRoot(root)
+	procState.mu.Lock()
+	procState.capabilities = &ProcCapabilities{Root: root, Processes: true, Memory: true}
+	procState.mu.Unlock()
+	t.Cleanup(func() { SetProcRoot("") })
+
+	return root
+}
+
+// addProcess writes the stat, status and command line of a sleeping process
+// to the proc tree at root
+func addProcess(t *testing.T, root string, pid, ppid int, command string) {
+	t.Helper()
+
+	dir := filepath.Join(root, fmt.Sprint(pid))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("process %d: %s", pid, err)
+	}
+
+	files := map[string]string{
+		"stat":    fmt.Sprintf("%d (%s) S %d %d %d 0 -1 4194304 0 0 0 0 1 1 0 0 20 0 1 0 100 1000 25 0\n", pid, command, ppid, ppid, ppid),
+		"status":  fmt.Sprintf("Name:\t%s\nPPid:\t%d\nVmHWM:\t%d kB\nVmRSS:\t%d kB\n", command, ppid, 100+pid, 100+pid),
+		"cmdline": command + "\x00--pid\x00" + fmt.Sprint(pid) + "\x00",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("process %d: %s", pid, err)
+		}
+	}
+}
+
+func TestProcessTreeOfFixture(t *testing.T) {
+	procFixture(t)
+
+	// 999 has a truncated stat, kthreadd is a child of the kernel
+	tree, err := processTree(1)
+	if err != nil {
+		t.Fatalf("tree: %s", err)
+	}
+	if !reflect.DeepEqual(tree, []int{1, 4242}) {
+		t.Fatalf("tree %v, want init and my (weird) app", tree)
+	}
+
+	info, err := procInfo(4242, MEMORY_RSS)
+	if err != nil {
+		t.Fatalf("info: %s", err)
+	}
+	if info.PPID != 1 || info.Command != "my (weird) app" || info.MemoryMetric != MEMORY_RSS {
+		t.Errorf("info %+v, want the name of the stat without a command line", info)
+	}
+}
+
+func TestProcessTreeSkipsVanishedProcesses(t *testing.T) {
+	root := procFixture(t)
+
+	addProcess(t, root, 5000, 1, "worker")
+	addProcess(t, root, 5001, 5000, "child")
+	addProcess(t, root, 5002, 5000, "child")
+	addProcess(t, root, 5003, 5002, "grandchild")
+
+	// 5002 exits after /proc is listed, its stat is gone: its children are
+	// reparented by the kernel and left out
+	os.Remove(filepath.Join(root, "5002", "stat"))
+
+	tree, err := processTree(5000)
+	if err != nil {
+		t.Fatalf("tree: %s", err)
+	}
+	if !reflect.DeepEqual(tree, []int{5000, 5001}) {
+		t.Fatalf("tree %v, want the worker and the child left", tree)
+	}
+
+	// 5001 exits after the tree is walked and before its memory is read
+	os.Remove(filepath.Join(root, "5001", "status"))
+	if _, err := procInfo(5001, MEMORY_RSS); err == nil {
+		t.Fatalf("info of a process without a status")
+	}
+
+	info, err := procInfo(5000, MEMORY_RSS)
+	if err != nil {
+		t.Fatalf("info: %s", err)
+	}
+	if info.Command != "worker --pid 5000" || info.RssKB != 5100 {
+		t.Errorf("info %+v, want the command line and rss of the worker", info)
+	}
+}
+
+func TestProcessTreeOfDeepTree(t *testing.T) {
+	root := procFixture(t)
+
+	// a chain deeper than a recursive walk would like, each process the child
+	// of the one before
+	const DEPTH = 5000
+	for pid := 10000; pid < 10000+DEPTH; pid++ {
+		addProcess(t, root, pid, pid-1, "nested")
+	}
+
+	tree, err := processTree(10000)
+	if err != nil {
+		t.Fatalf("tree: %s", err)
+	}
+	if len(tree) != DEPTH {
+		t.Fatalf("tree of %d processes, want %d", len(tree), DEPTH)
+	}
+	for i, pid := range tree {
+		if pid != 10000+i {
+			t.Fatalf("process %d of the tree is %d, want %d", i, pid, 10000+i)
+		}
+	}
+}
+
+func TestProcessTreeDuringRestarts(t *testing.T) {
+	if !procCapabilities().Processes {
+		t.Skip("no processes in /proc")
+	}
+
+	service := NewService(ServiceConfig{
+		Name:          "restarting",
+		Exec:          "/bin/sh",
+		Params:        []string{"-c", "sleep 0.05 & wait"},
+		RestartPolicy: RESTART_ALWAYS,
+		RestartDelay:  time.Millisecond,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer service.Wait()
+	defer cancel()
+	go service.Run(ctx, nil, nil)
+
+	var trees, empty int
+	for service.Status().Runs < 20 {
+		tree, err := service.ProcessTree()
+		if err != nil {
+			t.Fatalf("tree after %d runs: %s", service.Status().Runs, err)
+		}
+
+		if len(tree) == 0 {
+			empty++
+			continue
+		}
+		trees++
+
+		for _, info := range tree[1:] {
+			if info.Command != "sleep 0.05" {
+				t.Fatalf("child %+v of the shell", info)
+			}
+		}
+	}
+
+	if trees == 0 {
+		t.Fatalf("no tree read over the restarts, %d empty", empty)
+	}
+}
@@ -1,51 +1,150 @@
 package system
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"sync"
+	"syscall"
 	"time"
 )
 
 const UNIT_START_TIMEOUT = 10
 
+// UNIT_KILL_TIMEOUT is the time in seconds a killed process is waited for to be reaped
+const UNIT_KILL_TIMEOUT = 5
+
+// GROUP_POLL_INTERVAL is how often the group of a stopped process is checked for the processes left
+const GROUP_POLL_INTERVAL = 100 * time.Millisecond
+
+var ErrStopFailed = errors.New("process is still running after SIGKILL")
+
+// OUTPUT_WAIT_DELAY is how long the output of an exited process is waited for
+const OUTPUT_WAIT_DELAY = time.Second
+
 type process struct {
 	name    string
 	cmd     *exec.Cmd
+	clock   Clock
 	Created time.Time
 	Stopped time.Time
 	Out     io.ReadCloser
 	Err     io.ReadCloser
+
+	// writers of Out and Err, closed after the process has exited
+	outWriter *io.PipeWriter
+	errWriter *io.PipeWriter
+	readers   sync.WaitGroup
+
+	// abort is closed when the output is not handled in time after the exit
+	abort chan struct{}
+
+	// stderrTail keeps the end of stderr for the process record
+	stderrTail *tailBuffer
+
+	// secrets resolved into the environment, redacted from the output
+	secrets []string
+
+	incarnation int
+	// startErr is the error the process failed to start with, set once started is closed
+	startErr   error
+	termSentAt time.Time
+	killSentAt time.Time
+
+	// execAt is the Monotonic reading taken before the exec, the latencies are
+	// from it to the started and to the ready process. stoppedAt is the reading
+	// of the exit, Stopped and Created are for the records
+	execAt       time.Duration
+	createdAt    time.Duration
+	stoppedAt    time.Duration
+	startLatency time.Duration
+	readyLatency time.Duration
+
+	// stdin is the write end of the stdin of a pipe consumer
+	stdin *os.File
+
+	// oomKills is the count of the oom killer before the start, if the kernel counts
+	oomKills   uint64
+	oomCounted bool
+
+	// startTicks is the start time of the process in clock ticks after boot,
+	// telling it from a later process with the same pid
+	startTicks uint64
+
+	// hook is the stage of a hook command of the service, empty for its process
+	hook string
+
+	// adopted tells the process was started by an earlier supervisor, it is not
+	// a child, its exit status and output are not known
+	adopted bool
+
+	// cgroup is the directory of the cgroup the process runs in, if any
+	cgroup string
+
+	// umask of the process, UMASK_INHERIT for the one of the supervisor
+	umask int
+
+	// failedLine is the first stderr line matching a failure pattern, set by the
+	// scanner of stderr and read once the output is handled
+	failedLine string
+
+	// grouped tells the process leads a process group of its own, signalled as a whole
+	grouped bool
+	// group holds what the platform keeps of the process group
+	group processGroup
+
+	done chan struct{}
 }
 
-func NewProcess(name, target string, params []string) *process {
+// newCmdProcess wraps cmd, its output is read from pipes
+func newCmdProcess(name string, cmd *exec.Cmd) *process {
 	process := new(process)
 
 	process.name = name
-	process.cmd = exec.Command(target, params...)
-
-	var err error
-	process.Out, err = process.cmd.StdoutPipe()
-	if err != nil {
-		panic(err)
-	}
-
-	process.Err, err = process.cmd.StderrPipe()
-	if err != nil {
-		panic(err)
-	}
+	process.cmd = cmd
+	process.clock = SystemClock
+	process.done = make(chan struct{})
+	process.abort = make(chan struct{})
+	process.umask = UMASK_INHERIT
+
+	// exec copies the output into the pipes and lets Wait return only once it is
+	// all read, nothing written right before the exit is lost
+	process.Out, process.outWriter = io.Pipe()
+	process.Err, process.errWriter = io.Pipe()
+	process.cmd.Stdout = process.outWriter
+	process.cmd.Stderr = process.errWriter
+
+	// children keeping the output open do not hold up the exit
+	process.cmd.WaitDelay = OUTPUT_WAIT_DELAY
 
 	return process
 }
 
+// Start runs the process and waits for it, started is closed once it has started
+// or has failed to start with startErr
 func (p *process) Start(started chan<- error) {
-	log.Printf("[P][%s] starting...", p.name)
+	p.log().infof("starting...")
 
-	if err := p.cmd.Start(); err != nil {
-		log.Fatal(err)
+	p.oomKills, p.oomCounted = oomKills()
+	if err := startChild(p.cmd, p.umask); err != nil {
+		p.startErr = err
+
+		// nothing is written to the pipes, the readers end right away
+		p.outWriter.Close()
+		p.errWriter.Close()
+		close(started)
+
+		p.readers.Wait()
+		p.stop()
+		close(p.done)
+
+		return
 	}
+	p.joinGroup()
 
 	var count int
 	for p.cmd.Process == nil {
@@ -57,52 +156,82 @@ func (p *process) Start(started chan<- error) {
 		}
 	}
 
-	p.Created = time.Now()
+	p.Created, p.createdAt = p.clock.Now(), p.clock.Monotonic()
 	close(started)
 
-	log.Printf("[P][%s] PID: %d", p.name, p.GetPid())
+	p.log().with(FIELD_PID, p.GetPid()).infof("started")
 
 	p.wait()
 }
 
-func (p process) Stop() error {
+// Stop sends sig and SIGKILL once grace has passed, it fails with ErrStopFailed
+// if the process is not reaped killTimeout after SIGKILL. The signals go to the
+// process group of the process, what is left of it once the process exited is
+// given the rest of grace before it is killed too
+func (p *process) Stop(sig syscall.Signal, grace, killTimeout time.Duration) error {
 	if p.Finished() && !p.Running() {
-		log.Printf("[P][%s] not running", p.name)
-	} else {
-		log.Printf("[P][%s] killing..", p.name)
-
-		var stopped = make(chan error)
-		go func() { stopped <- p.cmd.Wait() }()
+		p.log().infof("not running")
+		return nil
+	}
 
-		// 10 seconds given to end process, or kill it
+	p.log().infof("stopping with %s..", sig)
+	p.termSentAt = p.clock.Now()
+	deadline := p.clock.After(grace)
+	if err := p.signal(sig); err == nil {
 		select {
-		case <-stopped:
+		case <-p.done:
+			p.stopGroup(deadline)
 			return nil
-		case <-time.After(UNIT_START_TIMEOUT * time.Second):
-			return p.kill()
+		case <-deadline:
 		}
 	}
 
-	return nil
+	return p.kill(killTimeout)
 }
 
-func (p process) Running() bool {
-	return p.cmd != nil && p.cmd.Process != nil && p.cmd.ProcessState == nil
+func (p *process) Running() bool {
+	return p.cmd != nil && p.cmd.Process != nil && !p.Finished()
 }
 
-func (p process) Finished() bool {
-	return p.cmd == nil || p.cmd.ProcessState != nil
+func (p *process) Finished() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return p.cmd == nil
+	}
+}
+
+// Aborted is closed once the process has exited and its output is not read in
+// time, handlers blocked on the output must give up
+func (p *process) Aborted() <-chan struct{} {
+	return p.abort
+}
+
+// Done is closed once the process has exited
+func (p *process) Done() <-chan struct{} {
+	return p.done
 }
 
-func (p process) GetName() string {
+func (p *process) GetName() string {
 	return p.name
 }
 
-func (p process) GetPid() int {
+func (p *process) GetPid() int {
 	return p.GetCmd().Process.Pid
 }
 
-func (p process) GetCmd() *exec.Cmd {
+// pid is the pid the process was started with, also once it has exited, zero
+// before the start. Unlike GetPid it is safe off the supervision loop
+func (p *process) pid() int {
+	if p.cmd == nil || p.cmd.Process == nil {
+		return 0
+	}
+
+	return p.cmd.Process.Pid
+}
+
+func (p *process) GetCmd() *exec.Cmd {
 	if !p.Running() {
 		panic("Error in getting Command, exec is empty")
 	}
@@ -112,32 +241,117 @@ func (p process) GetCmd() *exec.Cmd {
 
 func (p *process) wait() {
 	var stopped = make(chan error)
-	go func() { stopped <- p.cmd.Wait() }()
+	go func() { stopped <- waitChild(p.cmd) }()
 
 	if err := <-stopped; err != nil {
-		log.Printf("[P][%s] finished with message: %s", p.name, err)
+		p.log().infof("finished with message: %s", err)
 	} else {
-		log.Printf("[P][%s] finished", p.name)
+		p.log().infof("finished")
+	}
+	p.releaseGroup()
+
+	p.outWriter.Close()
+	p.errWriter.Close()
+
+	handled := make(chan struct{})
+	go func() {
+		p.readers.Wait()
+		close(handled)
+	}()
+
+	select {
+	case <-handled:
+	case <-time.After(OUTPUT_WAIT_DELAY):
+		p.log().warnf("output is not read, dropping the rest of it")
+		close(p.abort)
+		<-handled
+	}
+
+	p.stop()
+	close(p.done)
+}
+
+// stop takes the time of the exit
+func (p *process) stop() {
+	p.Stopped, p.stoppedAt = p.clock.Now(), p.clock.Monotonic()
+}
+
+// discard sends stdout and/or stderr of the process to /dev/null instead of the
+// pipes, streams not read from a pipe are left alone, it must be called before Start
+func (p *process) discard(stdout, stderr bool) {
+	if stdout && p.Out != nil {
+		p.cmd.Stdout = nil
+		p.outWriter.Close()
+		p.Out = nil
+	}
+
+	if stderr && p.Err != nil {
+		p.cmd.Stderr = nil
+		p.errWriter.Close()
+		p.Err = nil
+	}
+}
+
+// combine writes stderr of the process into the stdout pipe, the kernel keeps
+// the order of the writes to the single descriptor, it must be called before Start
+func (p *process) combine() {
+	if p.Err == nil {
+		return
 	}
 
-	p.Stopped = time.Now()
+	p.cmd.Stderr = p.cmd.Stdout
+	p.errWriter.Close()
+	p.Err = nil
 }
 
-func (p *process) kill() error {
+// scan passes every line of src to handle until the process output is closed, then
+// calls end, it must be called before Start, the process is not done before all of its output is handled
+func (p *process) scan(src io.ReadCloser, handle func(line string), end func()) {
+	p.readers.Add(1)
+
+	go func() {
+		defer p.readers.Done()
+		defer end()
+		defer src.Close()
+
+		scanner := bufio.NewScanner(src)
+		for scanner.Scan() {
+			handle(scanner.Text())
+		}
+
+		// a line over the scanner limit ends the scan, the rest still has to be
+		// read for the process to finish
+		io.Copy(ioutil.Discard, src)
+	}()
+}
+
+func (p *process) kill(timeout time.Duration) error {
 	if p.Finished() {
-		log.Printf("[P][%s] nothing to kill", p.name)
+		p.log().infof("nothing to kill")
 		return nil
 	}
 
-	var killErr string
+	p.log().with(FIELD_PID, p.cmd.Process.Pid).warnf("still running, killing")
+	p.killSentAt = p.clock.Now()
+	if err := p.signal(syscall.SIGKILL); err != nil {
+		// exited after SIGTERM but before it was waited for
+		if errors.Is(err, os.ErrProcessDone) {
+			p.killSentAt = time.Time{}
+			select {
+			case <-p.done:
+				return nil
+			case <-p.clock.After(timeout):
+				return fmt.Errorf("[P][%s] PID [%d]: %w", p.name, p.cmd.Process.Pid, ErrStopFailed)
+			}
+		}
 
-	//if err := m.cmd.Process.Signal(syscall.SIGINT); err != nil {
-	if err := p.cmd.Process.Kill(); err != nil {
-		killErr = fmt.Sprintf("[P][%s] failed to kill PID [%d]: %s", p.name, p.GetPid(), err)
-	} else {
-		killErr = fmt.Sprintf("[P][%s] killed by timeout", p.name)
-		p.Stopped = time.Now()
+		return fmt.Errorf("[P][%s] failed to kill PID [%d]: %s", p.name, p.cmd.Process.Pid, err)
 	}
 
-	return errors.New(killErr)
+	select {
+	case <-p.done:
+		return fmt.Errorf("[P][%s] killed by timeout", p.name)
+	case <-p.clock.After(timeout):
+		return fmt.Errorf("[P][%s] PID [%d]: %w", p.name, p.cmd.Process.Pid, ErrStopFailed)
+	}
 }
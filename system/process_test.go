@@ -0,0 +1,157 @@
+package system
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// restartService runs cmd until its run n has ended, the output goes to nil
+// channels, and returns once the supervision loop has ended
+func restartService(t *testing.T, config ServiceConfig, n int) *Service {
+	t.Helper()
+
+	config.RestartPolicy = RESTART_ALWAYS
+	config.RestartDelay = time.Millisecond
+	service := NewService(config)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go service.Run(ctx, nil, nil)
+	// a run counts once started, the cancel would stop the last one
+	eventually(t, 30*time.Second, "the restarts", func() bool {
+		history := service.History()
+		return len(history) > 0 && history[len(history)-1].Incarnation >= n
+	})
+	cancel()
+	service.Wait()
+
+	return service
+}
+
+func TestNilChannelsKeepCapturing(t *testing.T) {
+	service := restartService(t, ServiceConfig{
+		Name:            "quiet",
+		Exec:            "/bin/sh",
+		Params:          []string{"-c", "echo out; echo 'FATAL: gone' >&2"},
+		FailurePatterns: []string{"^FATAL"},
+	}, 1)
+
+	history := service.History()
+	if len(history) == 0 {
+		t.Fatalf("no run recorded")
+	}
+
+	last := history[0]
+	if !strings.Contains(last.Stderr, "FATAL: gone") {
+		t.Errorf("stderr tail %q, want the line written to stderr", last.Stderr)
+	}
+	if last.StopReason != StopReasonFailurePattern {
+		t.Errorf("stop reason %s, want %s", last.StopReason, StopReasonFailurePattern)
+	}
+
+	var texts []string
+	for _, line := range service.RecentOutput(10) {
+		texts = append(texts, line.Text)
+	}
+	if joined := strings.Join(texts, "\n"); !strings.Contains(joined, "out") {
+		t.Errorf("recent output %q, want the stdout line", joined)
+	}
+}
+
+func TestDiscardOutputCapturesNothing(t *testing.T) {
+	service := restartService(t, ServiceConfig{
+		Name:          "discarded",
+		Exec:          "/bin/sh",
+		Params:        []string{"-c", "echo out; echo err >&2"},
+		DiscardOutput: true,
+	}, 1)
+
+	if lines := service.RecentOutput(10); len(lines) != 0 {
+		t.Errorf("recent output %v, want none", lines)
+	}
+	if stderr := service.History()[0].Stderr; stderr != "" {
+		t.Errorf("stderr tail %q, want none", stderr)
+	}
+}
+
+func TestRestartsLeakNothing(t *testing.T) {
+	for _, discard := range []bool{false, true} {
+		goroutines, fds := baseline(t)
+
+		restartService(t, ServiceConfig{
+			Name:          "flapping",
+			Exec:          "/bin/sh",
+			Params:        []string{"-c", "echo out; echo err >&2"},
+			DiscardOutput: discard,
+		}, 100)
+
+		if !settles(goroutines, fds, t) {
+			t.Fatalf("discardOutput %v: goroutines or descriptors left after 100 restarts", discard)
+		}
+	}
+}
+
+func TestRestartsReleaseThePipes(t *testing.T) {
+	goroutines, fds := baseline(t)
+
+	// the output is printed, the printers end with the pipes of every run
+	out, errs := make(chan string, 1000), make(chan string, 1000)
+	config := ServiceConfig{Name: "printed", Exec: "/bin/sh", Params: []string{"-c", "echo out; echo err >&2"}, RestartPolicy: RESTART_ALWAYS, RestartDelay: time.Millisecond}
+	service := NewService(config)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go service.Run(ctx, out, errs)
+	go func() {
+		for {
+			select {
+			case <-out:
+			case <-errs:
+			case <-service.Done():
+				return
+			}
+		}
+	}()
+
+	eventually(t, 30*time.Second, "50 restarts", func() bool { return service.Status().Runs >= 50 })
+	cancel()
+	service.Wait()
+
+	if !settles(goroutines, fds, t) {
+		t.Fatalf("goroutines or descriptors left after 50 restarts")
+	}
+}
+
+func TestPipeTeardownWithBlockedReader(t *testing.T) {
+	goroutines, fds := baseline(t)
+
+	// the child keeps stdout open after the process exits, the read of the
+	// scanner would block until it exits, the wait delay closes the pipe instead
+	running := newCmdProcess("blocked", exec.Command("/bin/sh", "-c", "sleep 2 & echo started"))
+	lines := make(chan string)
+	running.scan(running.Out, func(line string) { lines <- line }, func() {})
+	running.discard(false, true)
+
+	started := make(chan error)
+	go running.Start(started)
+	<-started
+
+	select {
+	case <-lines:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no output line")
+	}
+
+	select {
+	case <-running.Done():
+	case <-time.After(OUTPUT_WAIT_DELAY + time.Second):
+		t.Fatalf("process not done while its child holds the output open")
+	}
+
+	if !settles(goroutines, fds, t) {
+		t.Fatalf("goroutines or descriptors left after the teardown")
+	}
+}
@@ -0,0 +1,96 @@
+//go:build unix
+
+package system
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// processGroup is kept by the kernel, the process group is its pid
+type processGroup struct{}
+
+// ownGroup starts the process in a process group of its own, so that its
+// children are stopped with it. A factory command with a session or a group of
+// its own keeps them, one joining another group is signalled alone
+func (p *process) ownGroup() {
+	if p.cmd.SysProcAttr == nil {
+		p.cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+
+	attr := p.cmd.SysProcAttr
+	if !attr.Setsid && !attr.Setpgid && !attr.Foreground {
+		attr.Setpgid, attr.Pgid = true, 0
+	}
+
+	p.grouped = attr.Setsid || (attr.Setpgid && attr.Pgid == 0)
+}
+
+// signal sends sig to the process group, or to the process if it has none, a
+// group with no process left is done
+func (p *process) signal(sig syscall.Signal) error {
+	if !p.grouped {
+		return p.cmd.Process.Signal(sig)
+	}
+
+	err := syscall.Kill(-p.cmd.Process.Pid, sig)
+	if errors.Is(err, syscall.ESRCH) {
+		return os.ErrProcessDone
+	}
+
+	return err
+}
+
+// stopGroup waits for the processes left in the group of the exited process until
+// deadline, then kills them
+func (p *process) stopGroup(deadline <-chan time.Time) {
+	if !p.grouped {
+		return
+	}
+
+	for groupAlive(p.cmd.Process.Pid) {
+		select {
+		case <-deadline:
+			p.log().with(FIELD_PID, p.cmd.Process.Pid).warnf("killing the processes left in its group")
+			syscall.Kill(-p.cmd.Process.Pid, syscall.SIGKILL)
+			return
+		case <-p.clock.After(GROUP_POLL_INTERVAL):
+		}
+	}
+}
+
+// joinGroup has nothing to do once the process started, it was started in its
+// group
+func (p *process) joinGroup() {}
+
+// releaseGroup leaves the group to stopGroup, the processes left in it are
+// waited for
+func (p *process) releaseGroup() {}
+
+// groupAlive reports whether a process of the group pgid is left that is not a
+// zombie, zombies wait for their parent to be reaped and are signalled in vain.
+// Without the processes of the platform any process of the group counts
+func groupAlive(pgid int) bool {
+	if syscall.Kill(-pgid, 0) != nil {
+		return false
+	}
+
+	if !procCapabilities().Processes {
+		return true
+	}
+
+	processes, err := listProcesses()
+	if err != nil {
+		return true
+	}
+
+	for _, stats := range processes {
+		if stats.PGID == pgid && !stats.Exited {
+			return true
+		}
+	}
+
+	return false
+}
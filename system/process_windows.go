@@ -0,0 +1,105 @@
+package system
+
+import (
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// processGroup is the job object the process and its children are assigned
+// to, closing it terminates what is left in it
+type processGroup struct {
+	mu  sync.Mutex
+	job windows.Handle
+}
+
+// ownGroup starts the process in a console process group of its own, it
+// receives the ctrl-break of Stop without the supervisor
+func (p *process) ownGroup() {
+	if p.cmd.SysProcAttr == nil {
+		p.cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+
+	p.cmd.SysProcAttr.CreationFlags |= windows.CREATE_NEW_PROCESS_GROUP
+	p.grouped = true
+}
+
+// joinGroup assigns the started process to a job object, the children it
+// starts later join it too. Children started before it is assigned are left out
+func (p *process) joinGroup() {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		p.log().warnf("failed to create a job object: %s", err)
+		return
+	}
+
+	info := windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION{}
+	info.BasicLimitInformation.LimitFlags = windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE
+	if _, err := windows.SetInformationJobObject(job, windows.JobObjectExtendedLimitInformation, uintptr(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info))); err != nil {
+		p.log().warnf("failed to set up the job object: %s", err)
+		windows.CloseHandle(job)
+		return
+	}
+
+	handle, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(p.cmd.Process.Pid))
+	if err == nil {
+		err = windows.AssignProcessToJobObject(job, handle)
+		windows.CloseHandle(handle)
+	}
+
+	if err != nil {
+		p.log().warnf("failed to assign the process to a job object: %s", err)
+		windows.CloseHandle(job)
+		return
+	}
+
+	p.group.mu.Lock()
+	p.group.job = job
+	p.group.mu.Unlock()
+}
+
+// signal terminates the job on SIGKILL, any other signal is a ctrl-break to
+// the console process group. A process without one can only be killed
+func (p *process) signal(sig syscall.Signal) error {
+	if sig == syscall.SIGKILL {
+		p.group.mu.Lock()
+		defer p.group.mu.Unlock()
+
+		if p.group.job != 0 {
+			return windows.TerminateJobObject(p.group.job, 1)
+		}
+
+		return p.cmd.Process.Kill()
+	}
+
+	if !p.grouped {
+		return ErrUnsupportedPlatform
+	}
+
+	err := windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(p.cmd.Process.Pid))
+	if p.Finished() {
+		return os.ErrProcessDone
+	}
+
+	return err
+}
+
+// stopGroup has no group left to wait for, releaseGroup terminated it once the
+// process exited
+func (p *process) stopGroup(deadline <-chan time.Time) {}
+
+// releaseGroup closes the job object of the exited process, the processes left
+// in it are terminated
+func (p *process) releaseGroup() {
+	p.group.mu.Lock()
+	defer p.group.mu.Unlock()
+
+	if p.group.job != 0 {
+		windows.CloseHandle(p.group.job)
+		p.group.job = 0
+	}
+}
@@ -0,0 +1,198 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/imunhatep/systemgo/procfs"
+)
+
+// PROC_ROOT is where procfs is mounted, SetProcRoot reads it elsewhere
+const PROC_ROOT = procfs.DEFAULT_ROOT
+
+// MEMORY_UNAVAILABLE is the memory metric of readings /proc can not give
+const MEMORY_UNAVAILABLE = "unavailable"
+
+var ErrProcUnavailable = errors.New("not available, /proc is not usable")
+
+// ProcCapabilities are the features of /proc the supervisor can use. In
+// containers mounting /proc with hidepid, without the net tables or on systems
+// without procfs the features depending on a missing one are turned off rather
+// than failing on every use
+type ProcCapabilities struct {
+	Root string `json:"root"`
+
+	// Processes are listed and their stat read: process trees, signals to the
+	// children of a service, orphan reaping and cpu samples
+	Processes bool `json:"processes"`
+
+	// Memory of processes is read from status and statm: memory usage and samples
+	Memory bool `json:"memory"`
+
+	// SmapsRollup gives the pss and uss memory metrics, rss is read without it
+	SmapsRollup bool `json:"smapsRollup"`
+
+	// FileDescriptors of processes are listed: the owners of listening sockets
+	// are checked by readiness probes and port checks
+	FileDescriptors bool `json:"fileDescriptors"`
+
+	// Sockets are listed from the net tables: port checks, readiness probes of
+	// ReadyWhenListening and connection counting of IdleTimeout
+	Sockets bool `json:"sockets"`
+}
+
+// Unavailable describes the features turned off, empty if none is
+func (c ProcCapabilities) Unavailable() []string {
+	var missing []string
+	if !c.Processes {
+		missing = append(missing, "process trees and cpu samples")
+	}
+	if !c.Memory {
+		missing = append(missing, "memory usage")
+	} else if !c.SmapsRollup {
+		missing = append(missing, "pss and uss memory metrics")
+	}
+	if !c.FileDescriptors {
+		missing = append(missing, "socket owners")
+	}
+	if !c.Sockets {
+		missing = append(missing, "port checks and idle timeouts")
+	}
+
+	return missing
+}
+
+// procState is the root read and the capabilities found in it, probed on first
+// use and again by each Manager.Run
+var procState = struct {
+	mu           sync.RWMutex
+	root         string
+	capabilities *ProcCapabilities
+}{root: PROC_ROOT}
+
+// SetProcRoot reads /proc at root, to run on a copy of it, PROC_ROOT if empty,
+// the capabilities are probed again
+func SetProcRoot(root string) {
+	procState.mu.Lock()
+	defer procState.mu.Unlock()
+
+	procState.root = procfs.NewFS(root).Root
+	procState.capabilities = nil
+}
+
+// procPath returns the path of a file under the proc root, like
+// procPath("%d/stat", pid)
+func procPath(format string, args ...interface{}) string {
+	procState.mu.RLock()
+	defer procState.mu.RUnlock()
+
+	return filepath.Join(procState.root, fmt.Sprintf(format, args...))
+}
+
+// procFS returns the proc filesystem at the proc root
+func procFS() procfs.FS {
+	procState.mu.RLock()
+	defer procState.mu.RUnlock()
+
+	return procfs.NewFS(procState.root)
+}
+
+// procCapabilities returns the capabilities of the proc root, probing it once
+func procCapabilities() ProcCapabilities {
+	procState.mu.RLock()
+	capabilities := procState.capabilities
+	procState.mu.RUnlock()
+
+	if capabilities != nil {
+		return *capabilities
+	}
+
+	return DetectProcCapabilities()
+}
+
+// DetectProcCapabilities probes the proc root on the supervisor process itself,
+// its services run as the same user and are shown the same way. A platform
+// without /proc tells processes and their memory on its own
+func DetectProcCapabilities() ProcCapabilities {
+	fs := procFS()
+	self := os.Getpid()
+	c := ProcCapabilities{Root: fs.Root}
+
+	if entries, err := ioutil.ReadDir(fs.Root); err == nil {
+		for _, entry := range entries {
+			if entry.Name() == strconv.Itoa(self) {
+				stat, err := fs.Stat(self)
+				c.Processes = err == nil && stat.PPID == os.Getppid()
+				break
+			}
+		}
+	}
+
+	if status, err := fs.Status(self); err == nil && status.VmRSS > 0 {
+		_, err := fs.Statm(self)
+		c.Memory = err == nil
+	}
+
+	if c.Memory {
+		_, err := fs.SmapsRollup(self)
+		c.SmapsRollup = err == nil
+	}
+
+	fdDir := fs.Path(strconv.Itoa(self), "fd")
+	if fds, err := ioutil.ReadDir(fdDir); err == nil && len(fds) > 0 {
+		_, err := os.Readlink(filepath.Join(fdDir, fds[0].Name()))
+		c.FileDescriptors = err == nil
+	}
+
+	for _, table := range []string{"net/unix", "net/tcp", "net/tcp6"} {
+		if f, err := os.Open(fs.Path(table)); err == nil {
+			f.Close()
+			c.Sockets = true
+			break
+		}
+	}
+
+	platformCapabilities(&c)
+
+	procState.mu.Lock()
+	if procState.root == fs.Root {
+		procState.capabilities = &c
+	}
+	procState.mu.Unlock()
+
+	return c
+}
+
+// Capabilities returns the features of /proc the manager found usable when it
+// started
+func (m *Manager) Capabilities() ProcCapabilities {
+	return procCapabilities()
+}
+
+// detectCapabilities probes /proc before services start, the features turned
+// off are logged once
+func (m *Manager) detectCapabilities() {
+	missing := DetectProcCapabilities().Unavailable()
+	if len(missing) == 0 {
+		return
+	}
+
+	managerLog.warnf("%s is not fully usable, unavailable: %s", procPath(""), strings.Join(missing, ", "))
+}
+
+// oomKills returns the count of processes killed by the oom killer, ok is false
+// if the kernel does not count them or /proc/vmstat can not be read
+func oomKills() (uint64, bool) {
+	vmstat, err := procFS().VMStat()
+	if err != nil || !vmstat.HasOOMKill {
+		return 0, false
+	}
+
+	return vmstat.OOMKill, true
+}
@@ -0,0 +1,6 @@
+//go:build race
+
+package system
+
+// raceEnabled tells the race detector runs, it multiplies the memory of the test process
+const raceEnabled = true
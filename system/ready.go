@@ -0,0 +1,160 @@
+package system
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// READY_RECHECK_INTERVAL is how often readiness is checked besides on events
+const READY_RECHECK_INTERVAL = time.Second
+
+var ErrServiceFailed = errors.New("service failed")
+
+// ReadyReport is the line written once all services are ready, or once waiting for them failed
+type ReadyReport struct {
+	Ready    bool             `json:"ready"`
+	Time     time.Time        `json:"time"`
+	Services map[string]State `json:"services"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// WaitReady blocks until every service has reached its target state: ready if it
+// has ReadyWhenListening or a readiness probe, listening if on-demand and running otherwise. Services
+// that completed or were stopped count as ready, it fails naming the first service
+// that is not Optional and failed, or the services still pending once ctx is done
+func (m *Manager) WaitReady(ctx context.Context) error {
+	events := m.Subscribe()
+	defer m.Unsubscribe(events)
+
+	ticker := time.NewTicker(READY_RECHECK_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		pending, err := m.readiness()
+		if err != nil {
+			return err
+		}
+
+		if len(pending) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w, waiting for %s", ctx.Err(), strings.Join(pending, ", "))
+		case <-events:
+		case <-ticker.C:
+		}
+	}
+}
+
+// readiness returns the services not ready yet, or the error of a failed one
+func (m *Manager) readiness() ([]string, error) {
+	m.mu.Lock()
+	services := make([]*Service, len(m.services))
+	copy(services, m.services)
+	isRunning := m.isRunning
+	m.mu.Unlock()
+
+	if !isRunning {
+		return []string{"manager"}, nil
+	}
+
+	var pending []string
+	for _, service := range services {
+		status := service.Status()
+
+		// a passive standby is as ready as it gets
+		if status.Role == ROLE_PASSIVE && service.isStandbyReady() {
+			continue
+		}
+
+		switch status.State {
+		case StateReady, StateListening, StateStopped, StateScheduled:
+			continue
+		case StateRunning:
+			if !service.hasReadinessCheck() {
+				continue
+			}
+		case StateFinished:
+			if status.LastStopReason == StopReasonCompleted {
+				continue
+			}
+		}
+
+		failed := status.State == StateFailed || status.State == StateStopFailed ||
+			(status.Runs > 0 && status.LastStopReason.IsInvoluntary() && status.State != StateRunning)
+		if failed {
+			if service.Optional {
+				continue
+			}
+
+			return nil, fmt.Errorf("%s: %w: %s", service.Name, ErrServiceFailed, status.LastStopReason)
+		}
+
+		pending = append(pending, service.Name)
+	}
+
+	return pending, nil
+}
+
+// AnnounceReady waits for the services with WaitReady, then writes a ReadyReport
+// line to w if it is not nil and notifies systemd of Type=notify units
+func (m *Manager) AnnounceReady(ctx context.Context, w io.Writer) error {
+	err := m.WaitReady(ctx)
+
+	report := ReadyReport{Ready: err == nil, Time: time.Now(), Services: make(map[string]State)}
+	for _, status := range m.ListServices() {
+		report.Services[status.Name] = status.State
+	}
+
+	if err != nil {
+		report.Error = err.Error()
+	}
+
+	if w != nil {
+		line, _ := json.Marshal(report)
+		fmt.Fprintln(w, string(line))
+	}
+
+	state := "READY=1"
+	if err != nil {
+		state = "STATUS=" + err.Error()
+	}
+
+	if notifyErr := SdNotify(state); notifyErr != nil {
+		managerLog.warnf("failed to notify systemd: %s", notifyErr)
+	}
+
+	return err
+}
+
+// SdNotify sends the state to the socket of NOTIFY_SOCKET, nothing if it is not set
+func SdNotify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+
+	// abstract sockets are given with a leading "@"
+	if strings.HasPrefix(path, "@") {
+		path = "\x00" + path[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+
+	return err
+}
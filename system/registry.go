@@ -0,0 +1,259 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrGroupAdd        = errors.New("templates and replicated services are added by configuration")
+	ErrReplicaRemove   = errors.New("replicas are removed by Scale")
+	ErrServiceRequired = errors.New("service is required")
+)
+
+// validateConfig checks the settings of a service that can be wrong on their own
+func validateConfig(config ServiceConfig) error {
+	if err := ValidateLabels(config.Labels); err != nil {
+		return err
+	}
+
+	if err := ValidateMemoryMetric(config.MemoryMetric); err != nil {
+		return err
+	}
+
+	if err := validateDirs(config); err != nil {
+		return err
+	}
+
+	if err := validateMinHealthy(config); err != nil {
+		return err
+	}
+
+	if err := validateLogForward(config.LogForward); err != nil {
+		return err
+	}
+
+	if err := ValidateOutputSampling(config.Sampling); err != nil {
+		return err
+	}
+
+	if err := ValidateSeverity(config.Severity); err != nil {
+		return err
+	}
+
+	if err := ValidateProbe(config); err != nil {
+		return err
+	}
+
+	if err := ValidateSecrets(config.Env); err != nil {
+		return err
+	}
+
+	if err := ValidateEnv(config); err != nil {
+		return err
+	}
+
+	if err := ValidateInterpreter(config); err != nil {
+		return err
+	}
+
+	if err := ValidateCredential(config); err != nil {
+		return err
+	}
+
+	if err := ValidateLimits(config); err != nil {
+		return err
+	}
+
+	if err := ValidateServiceHooks(config); err != nil {
+		return err
+	}
+
+	if err := ValidateHealthChecks(config); err != nil {
+		return err
+	}
+
+	if err := ValidateStopSignal(config); err != nil {
+		return err
+	}
+
+	if err := ValidateRestartWindows(config); err != nil {
+		return err
+	}
+
+	if err := ValidateRestartPolicy(config); err != nil {
+		return err
+	}
+
+	if err := ValidateSchedule(config); err != nil {
+		return err
+	}
+
+	if err := ValidateActivation(config); err != nil {
+		return err
+	}
+
+	if err := ValidateOutcome(config); err != nil {
+		return err
+	}
+
+	return ValidateOutputTriggers(config.OutputTriggers)
+}
+
+// Add supervises a new service with the manager defaults applied, it starts right
+// away if the manager runs and the service is not Disabled
+func (m *Manager) Add(config ServiceConfig) (*Service, error) {
+	if err := ValidateName(config.Name); err != nil {
+		return nil, err
+	}
+
+	if IsTemplate(config.Name) || config.Replicas > 0 {
+		return nil, fmt.Errorf("%s: %w", config.Name, ErrGroupAdd)
+	}
+
+	config = m.GetDefaults().apply(config)
+	if err := validateConfig(config); err != nil {
+		return nil, fmt.Errorf("%s: %w", config.Name, err)
+	}
+
+	m.scaling.Lock()
+	defer m.scaling.Unlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.findService(config.Name) != nil || m.findGroup(config.Name) != nil {
+		return nil, fmt.Errorf("%s: %w", config.Name, ErrServiceExists)
+	}
+
+	configs := append(m.configs(), config)
+	if err := validateDependencies(configs); err != nil {
+		return nil, err
+	}
+
+	if err := validatePipes(configs); err != nil {
+		return nil, err
+	}
+
+	if err := validateStandbys(configs); err != nil {
+		return nil, err
+	}
+
+	if err := validateExternals(configs); err != nil {
+		return nil, err
+	}
+
+	service := NewService(config)
+	m.insert(service)
+
+	return service, nil
+}
+
+// Remove stops the service within stopTimeout, its StopTimeout if zero, and drops
+// it with its followers and dependency bindings, a service others are bound to or
+// part of is not removed, see RemoveCascade
+func (m *Manager) Remove(name string, stopTimeout time.Duration) error {
+	_, err := m.removeService(name, stopTimeout, false)
+
+	return err
+}
+
+// RemoveCascade removes the service like Remove together with the services bound
+// to it or part of it, directly or through others, and returns the removed names
+// in the order they were stopped, the dependents first
+func (m *Manager) RemoveCascade(name string, stopTimeout time.Duration) ([]string, error) {
+	return m.removeService(name, stopTimeout, true)
+}
+
+func (m *Manager) removeService(name string, stopTimeout time.Duration, cascade bool) ([]string, error) {
+	m.scaling.Lock()
+	defer m.scaling.Unlock()
+
+	m.mu.Lock()
+	service := m.find(name)
+	if service == nil {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("%s: %w", name, ErrServiceNotFound)
+	}
+
+	if service.group != "" && !IsTemplate(service.group) {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("%s: %w", name, ErrReplicaRemove)
+	}
+
+	dependents := m.dependentsOf(service)
+	if len(dependents) > 0 && !cascade {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("%s: %w by %s", name, ErrServiceRequired, strings.Join(serviceNames(dependents), ", "))
+	}
+
+	// the farthest dependents stop first
+	removed := []*Service{service}
+	for _, dependent := range dependents {
+		removed = append([]*Service{dependent}, removed...)
+	}
+	m.remove(removed...)
+	for _, service := range removed {
+		m.pipes.forget(service.Name)
+		m.standbys.forget(service.Name)
+	}
+	m.mu.Unlock()
+
+	for _, service := range removed {
+		m.retire(service, stopTimeout)
+		service.output.closeAll()
+		service.output.release()
+		service.unlockState()
+	}
+
+	return serviceNames(removed), nil
+}
+
+// dependentsOf returns the services bound to or part of the service, directly or
+// through others, nearest first, must be called holding m.mu
+func (m *Manager) dependentsOf(service *Service) []*Service {
+	seen := map[*Service]bool{service: true}
+	queue := []*Service{service}
+
+	var dependents []*Service
+	for len(queue) > 0 {
+		dependency := queue[0]
+		queue = queue[1:]
+
+		for _, s := range m.services {
+			if seen[s] || !(contains(s.BindsTo, dependency.Name) || contains(s.PartOf, dependency.Name)) {
+				continue
+			}
+
+			seen[s] = true
+			dependents = append(dependents, s)
+			queue = append(queue, s)
+		}
+	}
+
+	return dependents
+}
+
+// configs returns the configurations of the services and groups but group
+// members, must be called holding m.mu
+func (m *Manager) configs() []ServiceConfig {
+	configs := append([]ServiceConfig{}, m.groups...)
+	for _, service := range m.services {
+		if service.group == "" {
+			configs = append(configs, service.config())
+		}
+	}
+
+	return configs
+}
+
+func serviceNames(services []*Service) []string {
+	names := make([]string, 0, len(services))
+	for _, service := range services {
+		names = append(names, service.Name)
+	}
+
+	return names
+}
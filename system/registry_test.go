@@ -0,0 +1,167 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// runningManager runs the services on the real clock until the test ends, once
+// they started
+func runningManager(t *testing.T, configs ...ServiceConfig) *Manager {
+	t.Helper()
+
+	m := runManagerOn(t, SystemClock, configs...)
+	for _, config := range configs {
+		eventually(t, 5*time.Second, "the start of "+config.Name, func() bool {
+			status, err := m.GetStatus(config.Name)
+			return err == nil && status.State == StateRunning
+		})
+	}
+
+	return m
+}
+
+func TestAdd(t *testing.T) {
+	m := runningManager(t, sleeper("web"))
+
+	if _, err := m.Add(sleeper("worker")); err != nil {
+		t.Fatalf("add: %s", err)
+	}
+	eventually(t, 5*time.Second, "the start of the added service", func() bool {
+		return status(t, m, "worker").State == StateRunning
+	})
+
+	disabled := sleeper("cron")
+	disabled.Disabled = true
+	if _, err := m.Add(disabled); err != nil {
+		t.Fatalf("add: %s", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if status := status(t, m, "cron"); status.State != StateNew || status.Runs != 0 {
+		t.Errorf("a disabled service added is %s with %d runs", status.State, status.Runs)
+	}
+
+	if _, err := m.Add(sleeper("web")); !errors.Is(err, ErrServiceExists) {
+		t.Errorf("add of web again: %v, want %v", err, ErrServiceExists)
+	}
+	if _, err := m.Add(ServiceConfig{Name: "web@", Exec: "true"}); !errors.Is(err, ErrGroupAdd) {
+		t.Errorf("add of a template: %v, want %v", err, ErrGroupAdd)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	m := runningManager(t, sleeper("web"))
+	service, err := m.GetService("web")
+	if err != nil {
+		t.Fatalf("web: %s", err)
+	}
+
+	lines, stop, err := m.FollowOutput("web", 0)
+	if err != nil {
+		t.Fatalf("follow: %s", err)
+	}
+	defer stop()
+
+	if err := m.Remove("web", time.Second); err != nil {
+		t.Fatalf("remove: %s", err)
+	}
+
+	if _, err := m.GetService("web"); !errors.Is(err, ErrServiceNotFound) {
+		t.Errorf("web after its removal: %v, want %v", err, ErrServiceNotFound)
+	}
+	if status := service.Status(); status.State == StateRunning || status.PID != 0 {
+		t.Errorf("the removed service is %s with pid %d", status.State, status.PID)
+	}
+
+	select {
+	case _, ok := <-lines:
+		if ok {
+			t.Errorf("a line followed after the removal")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("the follower of the removed service was not closed")
+	}
+
+	if err := m.Remove("web", time.Second); !errors.Is(err, ErrServiceNotFound) {
+		t.Errorf("second removal: %v, want %v", err, ErrServiceNotFound)
+	}
+	if _, err := m.Add(sleeper("web")); err != nil {
+		t.Errorf("add after the removal: %s", err)
+	}
+}
+
+func TestRemoveRequired(t *testing.T) {
+	web := sleeper("web")
+	web.BindsTo = []string{"db"}
+	worker := sleeper("worker")
+	worker.PartOf = []string{"web"}
+	m := runningManager(t, sleeper("db"), web, worker)
+
+	if err := m.Remove("db", time.Second); !errors.Is(err, ErrServiceRequired) {
+		t.Fatalf("removal of db: %v, want %v", err, ErrServiceRequired)
+	}
+	if status := status(t, m, "db"); status.State != StateRunning {
+		t.Errorf("db %s after the refused removal", status.State)
+	}
+
+	removed, err := m.RemoveCascade("db", time.Second)
+	if err != nil {
+		t.Fatalf("cascade: %s", err)
+	}
+	if !reflect.DeepEqual(removed, []string{"worker", "web", "db"}) {
+		t.Errorf("removed %v, want the dependents first [worker web db]", removed)
+	}
+	if services := m.ListServices(); len(services) != 0 {
+		t.Errorf("%d services left", len(services))
+	}
+}
+
+// TestRegistryConcurrency adds, removes, starts, stops and reads services from
+// several goroutines, run it with -race
+func TestRegistryConcurrency(t *testing.T) {
+	m := runningManager(t, sleeper("base"))
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+
+			random := rand.New(rand.NewSource(int64(g)))
+			for i := 0; i < 40; i++ {
+				name := fmt.Sprintf("task-%d", random.Intn(6))
+
+				switch random.Intn(6) {
+				case 0:
+					m.Add(sleeper(name))
+				case 1:
+					m.Remove(name, time.Second)
+				case 2:
+					m.Start(name)
+				case 3:
+					m.Stop(name)
+				case 4:
+					m.GetStatus(name)
+				case 5:
+					m.ListServices()
+					m.Plan([]ServiceConfig{sleeper("base"), sleeper(name)})
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	for _, status := range m.ListServices() {
+		if err := m.Remove(status.Name, time.Second); err != nil {
+			t.Errorf("removal of %s: %s", status.Name, err)
+		}
+	}
+	if services := m.ListServices(); len(services) != 0 {
+		t.Errorf("%d services left after removing all", len(services))
+	}
+}
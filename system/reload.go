@@ -0,0 +1,34 @@
+package system
+
+import (
+	"errors"
+)
+
+var ErrNoConfigPath = errors.New("no configuration path to reload")
+
+// SetConfigPath is the configuration file, or directory of them, Reload reads
+func (m *Manager) SetConfigPath(path string) {
+	m.configPath = path
+}
+
+func (m *Manager) GetConfigPath() string {
+	return m.configPath
+}
+
+// Reload reads the configuration of SetConfigPath again and applies it, see
+// Apply: added services start, removed ones stop, changed ones are replaced and
+// the others keep running untouched
+func (m *Manager) Reload() (Plan, error) {
+	if m.configPath == "" {
+		return Plan{}, ErrNoConfigPath
+	}
+
+	configs, err := LoadConfig(m.configPath)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	managerLog.infof("reloading %s", m.configPath)
+
+	return m.Apply(configs)
+}
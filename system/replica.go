@@ -0,0 +1,175 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+const REPLICA_SEPARATOR = "."
+const REPLICA_ENV = "SYSTEMGO_REPLICA"
+
+var ErrNotScalable = errors.New("service is neither replicated nor a template")
+
+// GroupStatus aggregates the members of a template or of a replicated service
+type GroupStatus struct {
+	Name    string          `json:"name"`
+	Size    int             `json:"size"`
+	Running int             `json:"running"`
+	Members []ServiceStatus `json:"members"`
+
+	// Health is aggregated from the members of groups with MinHealthy
+	Health     string `json:"health,omitempty"`
+	MinHealthy int    `json:"minHealthy,omitempty"`
+}
+
+func newReplica(config ServiceConfig, replica int) *Service {
+	replicaConfig := config
+	replicaConfig.Name = fmt.Sprintf("%s%s%d", config.Name, REPLICA_SEPARATOR, replica)
+	replicaConfig.Replicas = 0
+
+	service := NewService(replicaConfig)
+	service.group = config.Name
+	service.replica = replica
+	service.environ = []string{fmt.Sprintf("%s=%d", REPLICA_ENV, replica)}
+
+	return service
+}
+
+// Scale starts or stops replicas of a replicated service, or instances of a
+// template, until it runs the given number of them, the replicas with the
+// highest indexes are stopped first
+func (m *Manager) Scale(name string, replicas int) error {
+	if replicas < 0 {
+		return fmt.Errorf("%s: invalid number of replicas: %d", name, replicas)
+	}
+
+	m.scaling.Lock()
+	defer m.scaling.Unlock()
+
+	m.mu.Lock()
+	config := m.findGroup(name)
+	if config == nil {
+		m.mu.Unlock()
+		if _, err := m.GetService(name); err == nil {
+			return fmt.Errorf("%s: %w", name, ErrNotScalable)
+		}
+
+		return fmt.Errorf("%s: %w", name, ErrServiceNotFound)
+	}
+
+	var removed []*Service
+	if IsTemplate(name) {
+		removed = m.scaleInstances(*config, replicas)
+	} else {
+		removed = m.scaleReplicas(config, replicas)
+	}
+	m.mu.Unlock()
+
+	for _, service := range removed {
+		m.retire(service, 0)
+	}
+	m.evaluateGroup(name, false)
+
+	return nil
+}
+
+// scaleReplicas adds or removes the replicas with the highest indexes, must be
+// called holding m.mu
+func (m *Manager) scaleReplicas(config *ServiceConfig, replicas int) []*Service {
+	members := m.members(config.Name)
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].replica < members[j].replica
+	})
+
+	config.Replicas = replicas
+	for i := len(members); i < replicas; i++ {
+		m.insert(newReplica(*config, i))
+	}
+
+	if replicas >= len(members) {
+		return nil
+	}
+
+	removed := members[replicas:]
+	m.remove(removed...)
+
+	return removed
+}
+
+// scaleInstances adds instances of a template numbered from 1 on, "worker@1",
+// or removes the numbered ones with the highest numbers first and the named
+// ones last, must be called holding m.mu
+func (m *Manager) scaleInstances(template ServiceConfig, instances int) []*Service {
+	members := m.members(template.Name)
+	for i := 1; len(members) < instances; i++ {
+		instance := strconv.Itoa(i)
+		if m.findService(template.Name+instance) != nil {
+			continue
+		}
+
+		service := newInstance(template, instance)
+		m.insert(service)
+		members = append(members, service)
+	}
+
+	if instances >= len(members) {
+		return nil
+	}
+
+	sort.SliceStable(members, func(i, j int) bool {
+		return instanceNumber(members[i].Name) < instanceNumber(members[j].Name)
+	})
+
+	removed := members[instances:]
+	m.remove(removed...)
+
+	return removed
+}
+
+// remove drops services from the service list, must be called holding m.mu
+func (m *Manager) remove(services ...*Service) {
+	removed := make(map[*Service]bool)
+	for _, service := range services {
+		removed[service] = true
+	}
+
+	kept := m.services[:0]
+	for _, service := range m.services {
+		if !removed[service] {
+			kept = append(kept, service)
+		}
+	}
+
+	m.services = kept
+}
+
+func (m *Manager) GetGroupStatus(name string) (GroupStatus, error) {
+	m.mu.Lock()
+	config := m.findGroup(name)
+	if config == nil {
+		m.mu.Unlock()
+		return GroupStatus{}, fmt.Errorf("%s: %w", name, ErrServiceNotFound)
+	}
+
+	status := GroupStatus{Name: name, Size: config.Replicas, MinHealthy: config.MinHealthy}
+	members := m.members(name)
+	m.mu.Unlock()
+
+	if IsTemplate(name) {
+		status.Size = len(members)
+	}
+
+	for _, member := range members {
+		memberStatus := member.Status()
+		if memberStatus.State == StateRunning || memberStatus.State == StateReady {
+			status.Running += 1
+		}
+
+		status.Members = append(status.Members, memberStatus)
+	}
+	status.Health = m.groupHealth.get(name)
+
+	return status, nil
+}
@@ -0,0 +1,184 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// restart policies, the exits a service is restarted after
+const (
+	RESTART_ALWAYS     = "always"
+	RESTART_ON_FAILURE = "on-failure"
+	RESTART_NEVER      = "never"
+)
+
+// RESTART_DEFAULT_DELAY is the delay of a service with a RestartPolicy and no RestartDelay
+const RESTART_DEFAULT_DELAY = 100 * time.Millisecond
+
+// RESTART_BACKOFF_RESET is the run starting the backoff over without a RestartMaxDelay
+const RESTART_BACKOFF_RESET = time.Minute
+
+var ErrInvalidRestartPolicy = errors.New("invalid restart policy")
+
+// ValidateRestartPolicy checks the policy, the start limit and the backoff
+func ValidateRestartPolicy(config ServiceConfig) error {
+	switch config.RestartPolicy {
+	case "", RESTART_ALWAYS, RESTART_ON_FAILURE, RESTART_NEVER:
+	default:
+		return fmt.Errorf("%w: %q, expected %s, %s or %s", ErrInvalidRestartPolicy, config.RestartPolicy, RESTART_ALWAYS, RESTART_ON_FAILURE, RESTART_NEVER)
+	}
+
+	switch {
+	case config.StartLimitBurst < 0 || config.StartLimitInterval < 0:
+		return fmt.Errorf("%w: negative start limit", ErrInvalidRestartPolicy)
+	case config.StartLimitBurst > 0 && config.StartLimitInterval == 0:
+		return fmt.Errorf("%w: startLimitBurst is set without startLimitInterval", ErrInvalidRestartPolicy)
+	case !isFinite(config.RestartBackoff) || !isFinite(config.RestartJitter):
+		return fmt.Errorf("%w: restartBackoff and restartJitter must be finite numbers", ErrInvalidRestartPolicy)
+	case config.RestartBackoff != 0 && config.RestartBackoff < 1:
+		return fmt.Errorf("%w: restartBackoff %g would shorten the delay, 1 or more is expected", ErrInvalidRestartPolicy, config.RestartBackoff)
+	case config.RestartJitter < 0 || config.RestartJitter > 1:
+		return fmt.Errorf("%w: restartJitter %g is not within 0 and 1", ErrInvalidRestartPolicy, config.RestartJitter)
+	case config.RestartMaxDelay < 0:
+		return fmt.Errorf("%w: negative restartMaxDelay", ErrInvalidRestartPolicy)
+	}
+
+	return nil
+}
+
+// isFinite tells a number that is neither NaN nor infinite, NaN fails every
+// comparison and gets past range checks
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// getRestartPolicy returns the policy of the service, always with a restart
+// delay and never without one if not set
+func (s *Service) getRestartPolicy() string {
+	switch {
+	case s.RestartPolicy != "":
+		return s.RestartPolicy
+	case s.GetRestartDelay() > 0:
+		return RESTART_ALWAYS
+	}
+
+	return RESTART_NEVER
+}
+
+// restarts reports whether the policy restarts the service after its last run
+func (s *Service) restarts() bool {
+	return s.getRestartPolicy() != RESTART_NEVER && s.restartRefused == ""
+}
+
+// planRestart decides at the end of a run whether the policy restarts the
+// service and after which delay: failed runs in a row multiply the delay by
+// RestartBackoff up to RestartMaxDelay, a run lasting that long, or
+// RESTART_BACKOFF_RESET without one, or exiting successfully starts it over
+func (s *Service) planRestart(record ProcessRecord) {
+	s.restartRefused = ""
+
+	failed := record.StopReason.IsInvoluntary() || !s.exitedSuccessfully(record)
+	reset := RESTART_BACKOFF_RESET
+	if s.RestartMaxDelay > 0 {
+		reset = s.RestartMaxDelay
+	}
+
+	if !failed || record.Duration >= reset {
+		s.failures = 0
+	}
+	if failed {
+		s.failures += 1
+	}
+
+	switch policy := s.getRestartPolicy(); {
+	case policy == RESTART_NEVER:
+		return
+	case policy == RESTART_ON_FAILURE && !failed:
+		s.restartRefused = fmt.Sprintf("exited with %d, the restart policy is %s", record.ExitCode, policy)
+		s.log().warnf("not restarting, %s", s.restartRefused)
+		return
+	}
+
+	s.restartDelay = s.backoffDelay()
+	if s.startLimitHit() {
+		s.restartRefused = fmt.Sprintf("restarted %d times within %s", len(s.restartTimes), s.StartLimitInterval)
+		s.log().warnf("not restarting, %s", s.restartRefused)
+		s.note(JournalEntry{Type: JOURNAL_START_LIMIT, ExitCode: record.ExitCode, Message: s.restartRefused})
+		s.warn("start limit hit, " + s.restartRefused + ", not restarting until started")
+	}
+}
+
+// gaveUp publishes EVENT_FAILED for a failed run or start the service is not
+// restarted after
+func (s *Service) gaveUp(record ProcessRecord) {
+	reason := s.restartRefused
+	switch {
+	case reason != "":
+	case s.intent == intentHeld:
+		reason = "the start error is permanent"
+	default:
+		reason = fmt.Sprintf("the restart policy is %s", s.getRestartPolicy())
+	}
+
+	s.publish(Event{Type: EVENT_FAILED, Incarnation: record.Incarnation, PID: record.PID, ExitCode: record.ExitCode, StopReason: record.StopReason, Message: reason})
+}
+
+// backoffDelay is the delay of the next restart with its backoff and jitter
+func (s *Service) backoffDelay() time.Duration {
+	delay := s.GetRestartDelay()
+	if delay <= 0 {
+		delay = RESTART_DEFAULT_DELAY
+	}
+
+	if s.RestartBackoff > 1 && s.failures > 1 {
+		delay = time.Duration(float64(delay) * math.Pow(s.RestartBackoff, float64(s.failures-1)))
+	}
+
+	// the power overflows into negative durations long before the loop could wait them
+	if s.RestartMaxDelay > 0 && (delay > s.RestartMaxDelay || delay <= 0) {
+		delay = s.RestartMaxDelay
+	}
+
+	if s.RestartJitter > 0 {
+		delay += time.Duration(rand.Float64() * s.RestartJitter * float64(delay))
+	}
+
+	return delay
+}
+
+// startLimitHit reports whether StartLimitBurst restarts were made within
+// StartLimitInterval, the restarts older than it are dropped
+func (s *Service) startLimitHit() bool {
+	if s.StartLimitBurst <= 0 {
+		return false
+	}
+
+	now := s.getClock().Monotonic()
+	kept := s.restartTimes[:0]
+	for _, at := range s.restartTimes {
+		if now-at < s.StartLimitInterval {
+			kept = append(kept, at)
+		}
+	}
+	s.restartTimes = kept
+
+	return len(s.restartTimes) >= s.StartLimitBurst
+}
+
+// countRestart records a scheduled restart for the start limit
+func (s *Service) countRestart() {
+	if s.StartLimitBurst > 0 {
+		s.restartTimes = append(s.restartTimes, s.getClock().Monotonic())
+	}
+}
+
+// resetRestarts forgets the failed runs and restarts of the service, for an
+// operator start or a new supervision loop
+func (s *Service) resetRestarts() {
+	s.failures = 0
+	s.restartTimes = nil
+	s.restartRefused = ""
+}
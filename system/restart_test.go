@@ -0,0 +1,93 @@
+package system
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRestartDelay(t *testing.T) {
+	for _, delay := range []time.Duration{100 * time.Millisecond, 90 * time.Second} {
+		t.Run(delay.String(), func(t *testing.T) {
+			service := NewService(ServiceConfig{
+				Name:          "crasher",
+				Exec:          "false",
+				RestartPolicy: RESTART_ALWAYS,
+				RestartDelay:  delay,
+			})
+			clock := onFakeClock(service)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			go service.Run(ctx, nil, nil)
+			eventually(t, 5*time.Second, "the restart delay", func() bool {
+				status := service.Status()
+				return status.Runs == 1 && status.State == StateRestarting
+			})
+
+			clock.Advance(delay - delay/10)
+			time.Sleep(50 * time.Millisecond)
+			if runs := service.Status().Runs; runs != 1 {
+				t.Fatalf("restarted before the delay of %s", delay)
+			}
+			advanceUntil(t, clock, delay/10, "the restart", func() bool { return service.Status().Runs == 2 })
+
+			cancel()
+			service.Wait()
+		})
+	}
+}
+
+func TestSubSecondRestartDelay(t *testing.T) {
+	service := NewService(ServiceConfig{
+		Name:          "crasher",
+		Exec:          "false",
+		RestartPolicy: RESTART_ALWAYS,
+		RestartDelay:  100 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go service.Run(ctx, nil, nil)
+	eventually(t, 5*time.Second, "the first run", func() bool { return service.Status().Runs == 1 })
+
+	// the supervision loop does not poll by the second
+	started := time.Now()
+	eventually(t, 5*time.Second, "the restart", func() bool { return service.Status().Runs == 2 })
+	if waited := time.Since(started); waited > 900*time.Millisecond {
+		t.Errorf("restarted after %s, want about 100ms", waited)
+	}
+
+	cancel()
+	service.Wait()
+}
+
+func TestRestartDelayConfig(t *testing.T) {
+	for _, test := range []struct {
+		config string
+		want   time.Duration
+	}{
+		{`{"name": "a", "exec": "true", "restartDelay": "250ms"}`, 250 * time.Millisecond},
+		{`{"name": "a", "exec": "true", "restartDelay": "1m30s"}`, 90 * time.Second},
+		{`{"name": "a", "exec": "true", "restartDelay": 0.5}`, 500 * time.Millisecond},
+		{`{"name": "a", "exec": "true", "restart": 3}`, 3 * time.Second},
+		{`{"name": "a", "exec": "true", "restart": 3, "restartDelay": "1s"}`, time.Second},
+	} {
+		var config ServiceConfig
+		if err := json.Unmarshal([]byte(test.config), &config); err != nil {
+			t.Fatalf("%s: %s", test.config, err)
+		}
+
+		if delay := NewService(config).GetRestartDelay(); delay != test.want {
+			t.Errorf("%s: restart delay %s, want %s", test.config, delay, test.want)
+		}
+	}
+
+	var config ServiceConfig
+	if err := json.Unmarshal([]byte(`{"name": "a", "restartDelay": "soon"}`), &config); err == nil {
+		t.Errorf("an invalid restart delay was accepted")
+	}
+}
@@ -0,0 +1,288 @@
+package system
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// actions of a RestartTree plan
+const (
+	TREE_STOP    = "stop"
+	TREE_RESTART = "restart"
+	TREE_START   = "start"
+)
+
+// TreeAction is a step of a RestartTree plan, Error is set once it ran and failed
+type TreeAction struct {
+	Action  string `json:"action"`
+	Service string `json:"service"`
+	Error   string `json:"error,omitempty"`
+}
+
+func (a TreeAction) String() string {
+	return a.Action + " " + a.Service
+}
+
+// treeRestart is what RestartTree does: the dependents in start steps, stopped
+// in reverse and started again once the services restarted are up
+type treeRestart struct {
+	restart    []*Service
+	dependents [][]*Service
+}
+
+// plan lists the dependents to stop leaf-first, the services to restart, then
+// the dependents to start root-first
+func (t treeRestart) plan() []TreeAction {
+	var plan []TreeAction
+	names := sequenceNames(t.dependents)
+	for i := len(names) - 1; i >= 0; i-- {
+		plan = append(plan, TreeAction{Action: TREE_STOP, Service: names[i]})
+	}
+
+	for _, service := range t.restart {
+		plan = append(plan, TreeAction{Action: TREE_RESTART, Service: service.Name})
+	}
+
+	for _, name := range names {
+		plan = append(plan, TreeAction{Action: TREE_START, Service: name})
+	}
+
+	return plan
+}
+
+// PlanRestartTree returns the actions RestartTree would take, without taking them
+func (m *Manager) PlanRestartTree(name string, includeDependents bool) ([]TreeAction, error) {
+	tree, err := m.restartTree(name, includeDependents)
+	if err != nil {
+		return nil, err
+	}
+
+	return tree.plan(), nil
+}
+
+// RestartTree restarts the service or the members of a group. With
+// includeDependents the services bound to or part of it, directly or through
+// others, are stopped first leaf-first and started again root-first once the
+// services they depend on are up, so none of them is left holding connections
+// to processes gone. Each service is stopped and started once, dependents
+// already stopped are left alone. Without includeDependents it restarts like
+// Restart. The plan is returned with the error of every failed action, the
+// first one is returned too
+func (m *Manager) RestartTree(name string, includeDependents bool) ([]TreeAction, error) {
+	m.mu.Lock()
+	isRunning := m.isRunning
+	m.mu.Unlock()
+
+	if !isRunning {
+		return nil, ErrManagerNotStarted
+	}
+
+	tree, err := m.restartTree(name, includeDependents)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := tree.plan()
+	if len(tree.dependents) > 0 {
+		managerLog.infof("restarting %s: %s", name, strings.Join(actionNames(plan), ", "))
+	}
+
+	var first error
+	i := 0
+	run := func(fn func() error) {
+		if err := fn(); err != nil {
+			plan[i].Error = err.Error()
+			managerLog.with(FIELD_SERVICE, plan[i].Service).warnf("%s: %s", plan[i].Action, err)
+			if first == nil {
+				first = fmt.Errorf("%s: %w", plan[i].Service, err)
+			}
+		}
+		i += 1
+	}
+
+	for s := len(tree.dependents) - 1; s >= 0; s-- {
+		step := tree.dependents[s]
+		for j := len(step) - 1; j >= 0; j-- {
+			service := step[j]
+			run(func() error {
+				m.binds.forget(service.Name)
+				if err := service.send(commandStop); err != ErrNotRunning {
+					return err
+				}
+
+				return nil
+			})
+		}
+	}
+
+	for _, service := range tree.restart {
+		run(func() error {
+			if err := service.send(commandRestart); err != ErrNotRunning {
+				return err
+			}
+
+			return m.start(service)
+		})
+	}
+
+	up := tree.restart
+	for _, step := range tree.dependents {
+		m.waitUp(up)
+
+		for _, service := range step {
+			run(func() error {
+				m.binds.forget(service.Name)
+				return m.start(service)
+			})
+		}
+		up = step
+	}
+
+	return plan, first
+}
+
+// restartTree returns the services to restart and the running dependents of the
+// service or group, without dependents the services PartOf it are restarted
+// after it as by Restart
+func (m *Manager) restartTree(name string, includeDependents bool) (treeRestart, error) {
+	services, err := m.GetServices(name)
+	if err != nil {
+		return treeRestart{}, err
+	}
+
+	for _, service := range services {
+		if service.isExternal() {
+			return treeRestart{}, fmt.Errorf("%s: %w", service.Name, ErrExternal)
+		}
+	}
+
+	var tree treeRestart
+	for _, step := range startSteps(services) {
+		tree.restart = append(tree.restart, step...)
+	}
+
+	if !includeDependents {
+		tree.restart = append(tree.restart, m.partOf(name)...)
+		return tree, nil
+	}
+
+	m.mu.Lock()
+	var running []*Service
+	for _, dependent := range m.treeDependents(services) {
+		if dependent.Disabled || dependent.Status().State == StateStopped {
+			continue
+		}
+		running = append(running, dependent)
+	}
+	m.mu.Unlock()
+
+	tree.dependents = startSteps(running)
+
+	return tree, nil
+}
+
+// waitUp waits for the services to be up, or done trying, for their longest
+// StartTimeout at most, a late one is logged and waited for no more
+func (m *Manager) waitUp(services []*Service) {
+	events := m.Subscribe()
+	defer m.Unsubscribe(events)
+
+	var timeout time.Duration
+	for _, service := range services {
+		config := service.config()
+		if t := (&Service{ServiceConfig: config}).GetStartTimeout(); t > timeout {
+			timeout = t
+		}
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	ticker := time.NewTicker(READY_RECHECK_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		var pending []string
+		for _, service := range services {
+			if !service.isSettled() {
+				pending = append(pending, service.Name)
+			}
+		}
+
+		if len(pending) == 0 {
+			return
+		}
+
+		select {
+		case <-deadline.C:
+			managerLog.warnf("%s not up after %s, starting the dependents anyway", strings.Join(pending, ", "), timeout)
+			return
+		case <-m.ctx.Done():
+			return
+		case <-events:
+		case <-ticker.C:
+		}
+	}
+}
+
+// isSettled reports whether the service is up, or no longer trying to be: a
+// passive standby, a stopped, finished or failed one
+func (s *Service) isSettled() bool {
+	if s.isUp() {
+		return true
+	}
+
+	status := s.Status()
+	if status.Role == ROLE_PASSIVE {
+		return true
+	}
+
+	switch status.State {
+	case StateStopped, StateFinished, StateFailed, StateStopFailed:
+		return true
+	}
+
+	return false
+}
+
+// treeDependents returns the services bound to or part of the services or of
+// their groups, directly or through others, must be called holding m.mu
+func (m *Manager) treeDependents(services []*Service) []*Service {
+	seen := make(map[*Service]bool)
+	for _, service := range services {
+		seen[service] = true
+	}
+
+	dependsOn := func(s *Service, name string) bool {
+		return contains(s.BindsTo, name) || contains(s.PartOf, name)
+	}
+
+	queue := append([]*Service(nil), services...)
+	var dependents []*Service
+	for len(queue) > 0 {
+		dependency := queue[0]
+		queue = queue[1:]
+
+		for _, s := range m.services {
+			if seen[s] || !(dependsOn(s, dependency.Name) || (dependency.group != "" && dependsOn(s, dependency.group))) {
+				continue
+			}
+
+			seen[s] = true
+			dependents = append(dependents, s)
+			queue = append(queue, s)
+		}
+	}
+
+	return dependents
+}
+
+func actionNames(plan []TreeAction) []string {
+	names := make([]string, 0, len(plan))
+	for _, action := range plan {
+		names = append(names, action.String())
+	}
+
+	return names
+}
@@ -0,0 +1,190 @@
+package system
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// diamond is db with api bound to it and worker part of it, web needs both,
+// other depends on nothing
+func diamond() []ServiceConfig {
+	worker := sleeper("worker")
+	worker.PartOf = []string{"db"}
+
+	return []ServiceConfig{sleeper("db"), bound("api", "db"), worker, bound("web", "api", "worker"), sleeper("other")}
+}
+
+// treeEvents records the processes started and exited while it runs, the
+// returned func stops it and returns them as "started db", "exited web"
+func treeEvents(m *Manager) func() []string {
+	events := m.Subscribe()
+
+	var mu sync.Mutex
+	var seen []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range events {
+			if event.Type == EVENT_STARTED || event.Type == EVENT_EXITED {
+				mu.Lock()
+				seen = append(seen, event.Type+" "+event.Service)
+				mu.Unlock()
+			}
+		}
+	}()
+
+	return func() []string {
+		// the last start is published right after the state change
+		time.Sleep(50 * time.Millisecond)
+		m.Unsubscribe(events)
+		<-done
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		return seen
+	}
+}
+
+func TestPlanRestartTree(t *testing.T) {
+	m, _ := runManager(t, diamond()...)
+	running(t, m, "db", "api", "worker", "web", "other")
+
+	plan, err := m.PlanRestartTree("db", true)
+	if err != nil {
+		t.Fatalf("plan: %s", err)
+	}
+	want := []string{"stop web", "stop worker", "stop api", "restart db", "start api", "start worker", "start web"}
+	if got := actionNames(plan); !reflect.DeepEqual(got, want) {
+		t.Fatalf("plan %v, want %v", got, want)
+	}
+
+	// a plan is not taken
+	if runs := runsOf(t, m, "db", "api", "worker", "web"); !reflect.DeepEqual(runs, []int{1, 1, 1, 1}) {
+		t.Fatalf("runs %v after a plan", runs)
+	}
+
+	// restarted without its dependents, db takes the services part of it along
+	plan, err = m.PlanRestartTree("db", false)
+	if want := []string{"restart db", "restart worker"}; err != nil || !reflect.DeepEqual(actionNames(plan), want) {
+		t.Fatalf("plan %v, %v without dependents, want %v", actionNames(plan), err, want)
+	}
+
+	// from the middle of the diamond only what depends on it is touched
+	plan, _ = m.PlanRestartTree("api", true)
+	if want := []string{"stop web", "restart api", "start web"}; !reflect.DeepEqual(actionNames(plan), want) {
+		t.Fatalf("plan of api %v, want %v", actionNames(plan), want)
+	}
+
+	if _, err := m.PlanRestartTree("nope", true); !errors.Is(err, ErrServiceNotFound) {
+		t.Fatalf("plan of an unknown service: %v, want ErrServiceNotFound", err)
+	}
+}
+
+func TestRestartTreeOfDiamond(t *testing.T) {
+	m, _ := runManager(t, diamond()...)
+	running(t, m, "db", "api", "worker", "web", "other")
+
+	stop := treeEvents(m)
+	plan, err := m.RestartTree("db", true)
+	if err != nil {
+		t.Fatalf("restart tree: %s", err)
+	}
+	for _, action := range plan {
+		if action.Error != "" {
+			t.Fatalf("action %s failed: %s", action, action.Error)
+		}
+	}
+	running(t, m, "db", "api", "worker", "web")
+	seen := stop()
+
+	// each one stopped and started once, other left alone
+	at := make(map[string]int)
+	for i, event := range seen {
+		if _, twice := at[event]; twice {
+			t.Fatalf("events %v, %s twice", seen, event)
+		}
+		at[event] = i
+	}
+	if len(seen) != 8 {
+		t.Fatalf("events %v, want each of the four exited and started once", seen)
+	}
+	if runs := runsOf(t, m, "db", "api", "worker", "web", "other"); !reflect.DeepEqual(runs, []int{2, 2, 2, 2, 1}) {
+		t.Fatalf("runs %v, want one restart of the tree", runs)
+	}
+
+	// a dependent is stopped before what it depends on, and started after it
+	for _, edge := range [][2]string{{"api", "db"}, {"worker", "db"}, {"web", "api"}, {"web", "worker"}} {
+		dependent, dependency := edge[0], edge[1]
+		if at["exited "+dependent] > at["exited "+dependency] {
+			t.Errorf("events %v, %s exited after %s", seen, dependent, dependency)
+		}
+		if at["started "+dependent] < at["started "+dependency] {
+			t.Errorf("events %v, %s started before %s", seen, dependent, dependency)
+		}
+	}
+	if at["started db"] < at["exited api"] || at["started db"] < at["exited worker"] {
+		t.Errorf("events %v, db started before its dependents were down", seen)
+	}
+}
+
+func TestRestartTreeLeavesStoppedDependents(t *testing.T) {
+	m, clock := runManager(t, diamond()...)
+	running(t, m, "db", "api", "worker", "web", "other")
+
+	if err := m.Stop("web"); err != nil {
+		t.Fatalf("stop: %s", err)
+	}
+	eventually(t, 5*time.Second, "web stopped", func() bool { return status(t, m, "web").State == StateStopped })
+
+	plan, err := m.RestartTree("db", true)
+	if want := []string{"stop worker", "stop api", "restart db", "start api", "start worker"}; err != nil || !reflect.DeepEqual(actionNames(plan), want) {
+		t.Fatalf("plan %v, %v, want %v", actionNames(plan), err, want)
+	}
+	running(t, m, "db", "api", "worker")
+
+	// stopped by the operator it stays stopped, the binds do not start it either
+	clock.Advance(10 * BIND_WINDOW)
+	stays(t, m, "web", ServiceStatus{State: StateStopped, Runs: 1})
+}
+
+func TestRestartTreeWithoutDependents(t *testing.T) {
+	m, clock := runManager(t, diamond()...)
+	running(t, m, "db", "api", "worker", "web", "other")
+
+	plan, err := m.RestartTree("db", false)
+	if want := []string{"restart db", "restart worker"}; err != nil || !reflect.DeepEqual(actionNames(plan), want) {
+		t.Fatalf("plan %v, %v, want %v", actionNames(plan), err, want)
+	}
+	eventually(t, 5*time.Second, "db and worker restarted", func() bool {
+		runs := runsOf(t, m, "db", "worker")
+		return runs[0] == 2 && runs[1] == 2
+	})
+
+	// like Restart, the services bound to db are left to the binds, stopped
+	// with it and started once it has been up for the window
+	windowUntil(t, clock, "api and web started again", func() bool {
+		return status(t, m, "api").State == StateRunning && status(t, m, "web").State == StateRunning
+	})
+	if runs := runsOf(t, m, "api", "web", "other"); !reflect.DeepEqual(runs, []int{2, 2, 1}) {
+		t.Fatalf("runs %v, want api and web restarted by the binds", runs)
+	}
+}
+
+func TestRestartTreeRefused(t *testing.T) {
+	m, err := NewServiceManager([]Service{{ServiceConfig: sleeper("db")}})
+	if err != nil {
+		t.Fatalf("manager: %s", err)
+	}
+	if _, err := m.RestartTree("db", true); !errors.Is(err, ErrManagerNotStarted) {
+		t.Fatalf("restart tree of a manager not started: %v", err)
+	}
+
+	m, _ = runManager(t, diamond()...)
+	if _, err := m.RestartTree("nope", true); !errors.Is(err, ErrServiceNotFound) {
+		t.Fatalf("restart tree of an unknown service: %v, want ErrServiceNotFound", err)
+	}
+}
@@ -0,0 +1,149 @@
+package system
+
+import (
+	"sort"
+)
+
+// EXIT_SUPERVISOR_ERROR is the exit code of a supervisor that failed itself, EX_SOFTWARE
+const EXIT_SUPERVISOR_ERROR = 70
+
+// ServiceResult is the outcome of the last run of a service
+type ServiceResult struct {
+	Name       string     `json:"name"`
+	Runs       int        `json:"runs"`
+	ExitCode   int        `json:"exitCode"`
+	Signal     int        `json:"signal,omitempty"`
+	StopReason StopReason `json:"stopReason"`
+	Error      string     `json:"error,omitempty"`
+	Optional   bool       `json:"optional,omitempty"`
+
+	last ProcessRecord
+}
+
+// Failed reports whether the last run ended involuntarily
+func (r ServiceResult) Failed() bool {
+	return r.Runs > 0 && r.StopReason.IsInvoluntary()
+}
+
+// Code returns the exit code of the last run, 128 plus the signal if it was
+// killed by one and 1 if it did not exit on its own
+func (r ServiceResult) Code() int {
+	switch {
+	case r.Signal > 0:
+		return 128 + r.Signal
+	case r.ExitCode < 0:
+		return 1
+	case r.ExitCode == 0 && r.Failed():
+		return 1
+	}
+
+	return r.ExitCode
+}
+
+// Result summarizes services once the manager has stopped
+type Result struct {
+	Services []ServiceResult `json:"services"`
+	// Main is the service the exit code is taken from, see SetMainService
+	Main string `json:"main,omitempty"`
+	// Err is the error Run failed with
+	Err error `json:"-"`
+}
+
+// ExitCode is EXIT_SUPERVISOR_ERROR if Run failed, the code of the main service
+// if there is one, otherwise the code of the first required service that failed,
+// zero if none did
+func (r Result) ExitCode() int {
+	if r.Err != nil {
+		return EXIT_SUPERVISOR_ERROR
+	}
+
+	if r.Main != "" {
+		for _, service := range r.Services {
+			if service.Name == r.Main && service.Runs > 0 {
+				return service.Code()
+			}
+		}
+
+		return 1
+	}
+
+	var failed []ServiceResult
+	for _, service := range r.Services {
+		if service.Failed() && !service.Optional {
+			failed = append(failed, service)
+		}
+	}
+
+	if len(failed) == 0 {
+		return 0
+	}
+
+	sort.SliceStable(failed, func(i, j int) bool {
+		return failed[i].last.StoppedAt.Before(failed[j].last.StoppedAt)
+	})
+
+	return failed[0].Code()
+}
+
+// Wait blocks until Run has returned and summarizes the last runs of the services
+func (m *Manager) Wait() Result {
+	<-m.finished
+
+	return m.Result()
+}
+
+// Result summarizes the last runs of the services so far
+func (m *Manager) Result() Result {
+	m.mu.Lock()
+	services := make([]*Service, len(m.services))
+	copy(services, m.services)
+	result := Result{Main: m.mainService, Err: m.runErr}
+	m.mu.Unlock()
+
+	for _, service := range services {
+		result.Services = append(result.Services, service.result())
+	}
+
+	return result
+}
+
+// ExitCode is the exit code of the result so far, see Result.ExitCode
+func (m *Manager) ExitCode() int {
+	return m.Result().ExitCode()
+}
+
+func (s *Service) result() ServiceResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := ServiceResult{Name: s.Name, Runs: s.runs, Optional: s.Optional}
+	// history restored from a previous supervisor is not a result of this one
+	if s.runs == 0 || s.lastExit == nil || s.lastExitRestored {
+		return result
+	}
+
+	last := *s.lastExit
+	result.ExitCode = last.ExitCode
+	result.Signal = last.Signal
+	result.StopReason = last.StopReason
+	result.Error = last.Error
+	result.last = last
+
+	return result
+}
+
+// failed stops all services if the manager fails fast and the service is required
+func (m *Manager) failed(service *Service) {
+	if !m.FailFast || service.Optional {
+		return
+	}
+
+	m.mu.Lock()
+	shutdown := m.shutdown
+	m.mu.Unlock()
+
+	if shutdown != nil {
+		managerLog.warnf("%s failed, stopping services", service.Name)
+		shutdown()
+	}
+}
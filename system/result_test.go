@@ -0,0 +1,148 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// oneshot runs the script once
+func oneshot(name, script string) ServiceConfig {
+	return ServiceConfig{
+		Name:          name,
+		Exec:          "/bin/sh",
+		Params:        []string{"-c", script},
+		RestartPolicy: RESTART_NEVER,
+		StopTimeout:   time.Second,
+	}
+}
+
+// batch runs the services until all of them are done and returns the result
+func batch(t *testing.T, failFast bool, configs ...ServiceConfig) Result {
+	t.Helper()
+
+	services := make([]Service, len(configs))
+	for i, config := range configs {
+		services[i] = Service{ServiceConfig: config}
+	}
+
+	m, err := NewServiceManager(services)
+	if err != nil {
+		t.Fatalf("manager: %s", err)
+	}
+	m.FailFast = failFast
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx)
+
+	done := make(chan Result, 1)
+	go func() { done <- m.Wait() }()
+
+	select {
+	case result := <-done:
+		return result
+	case <-time.After(20 * time.Second):
+		t.Fatalf("the batch did not end")
+	}
+
+	return Result{}
+}
+
+// resultOf returns the result of the service in the result
+func resultOf(t *testing.T, result Result, name string) ServiceResult {
+	t.Helper()
+
+	for _, service := range result.Services {
+		if service.Name == name {
+			return service
+		}
+	}
+	t.Fatalf("no result of %s in %+v", name, result)
+
+	return ServiceResult{}
+}
+
+func TestBatchExitCode(t *testing.T) {
+	mixed := func() []ServiceConfig {
+		return []ServiceConfig{
+			oneshot("fine", "exit 0"),
+			oneshot("first", "sleep 0.1; exit 3"),
+			oneshot("second", "sleep 0.4; exit 4"),
+			oneshot("slow", "sleep 1.5"),
+		}
+	}
+
+	for _, test := range []struct {
+		name     string
+		failFast bool
+		services []ServiceConfig
+		code     int
+		// slow is the stop reason of the slow service, unknown if it is absent
+		slow StopReason
+	}{
+		{"succeeded", false, []ServiceConfig{oneshot("fine", "exit 0"), oneshot("slow", "sleep 0.2")}, 0, StopReasonCompleted},
+		{"mixed", false, mixed(), 3, StopReasonCompleted},
+		{"mixed fail fast", true, mixed(), 3, StopReasonSupervisorShutdown},
+		{"signaled", false, []ServiceConfig{oneshot("killed", "kill -9 $$")}, 128 + 9, StopReasonUnknown},
+		{"optional failure", true, func() []ServiceConfig {
+			optional := oneshot("optional", "exit 9")
+			optional.Optional = true
+			return []ServiceConfig{optional, oneshot("slow", "sleep 0.5")}
+		}(), 0, StopReasonCompleted},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			started := time.Now()
+			result := batch(t, test.failFast, test.services...)
+			if result.Err != nil {
+				t.Fatalf("run: %s", result.Err)
+			}
+			if code := result.ExitCode(); code != test.code {
+				t.Fatalf("exit code %d of %+v, want %d", code, result.Services, test.code)
+			}
+
+			for _, service := range result.Services {
+				if service.Runs != 1 {
+					t.Errorf("%s ran %d times", service.Name, service.Runs)
+				}
+			}
+
+			if test.slow == StopReasonUnknown {
+				return
+			}
+			if slow := resultOf(t, result, "slow"); slow.StopReason != test.slow {
+				t.Errorf("slow stopped for %s, want %s", slow.StopReason, test.slow)
+			}
+			if test.slow == StopReasonSupervisorShutdown && time.Since(started) > time.Second {
+				t.Errorf("the batch failing fast took %s", time.Since(started))
+			}
+		})
+	}
+}
+
+func TestExitCodeOfResult(t *testing.T) {
+	earlier, later := time.Now(), time.Now().Add(time.Second)
+	failed := func(name string, code int, at time.Time) ServiceResult {
+		return ServiceResult{Name: name, Runs: 1, ExitCode: code, StopReason: StopReasonCrashed, last: ProcessRecord{StoppedAt: at}}
+	}
+
+	for _, test := range []struct {
+		name   string
+		result Result
+		code   int
+	}{
+		{"nothing ran", Result{Services: []ServiceResult{{Name: "never"}}}, 0},
+		{"supervisor error", Result{Err: errors.New("startup hook"), Services: []ServiceResult{failed("a", 3, earlier)}}, EXIT_SUPERVISOR_ERROR},
+		{"first failure", Result{Services: []ServiceResult{failed("late", 4, later), failed("early", 3, earlier)}}, 3},
+		{"stopped by the operator", Result{Services: []ServiceResult{{Name: "a", Runs: 1, ExitCode: -1, StopReason: StopReasonOperatorStop}}}, 0},
+		{"optional failure", Result{Services: []ServiceResult{{Name: "a", Runs: 1, ExitCode: 2, StopReason: StopReasonCrashed, Optional: true}}}, 0},
+		{"crashed with zero", Result{Services: []ServiceResult{failed("a", 0, earlier)}}, 1},
+		{"main", Result{Main: "main", Services: []ServiceResult{failed("other", 3, earlier), {Name: "main", Runs: 1, StopReason: StopReasonCompleted}}}, 0},
+		{"main never ran", Result{Main: "main", Services: []ServiceResult{{Name: "main"}}}, 1},
+	} {
+		if code := test.result.ExitCode(); code != test.code {
+			t.Errorf("%s: exit code %d, want %d", test.name, code, test.code)
+		}
+	}
+}
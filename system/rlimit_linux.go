@@ -0,0 +1,54 @@
+package system
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// raiseCoreLimit lifts RLIMIT_CORE of the started process to its hard limit
+func raiseCoreLimit(pid int) error {
+	var limit syscall.Rlimit
+	if err := prlimit(pid, syscall.RLIMIT_CORE, nil, &limit); err != nil {
+		return err
+	}
+
+	limit.Cur = limit.Max
+
+	return prlimit(pid, syscall.RLIMIT_CORE, &limit, nil)
+}
+
+func prlimit(pid, resource int, limit, old *syscall.Rlimit) error {
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource),
+		uintptr(unsafe.Pointer(limit)), uintptr(unsafe.Pointer(old)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// applyRlimits sets LimitNOFILE and LimitNPROC of the started process, as
+// raiseCoreLimit does, its very first instructions may run with the inherited ones
+func (s *Service) applyRlimits(pid int) error {
+	for _, limit := range []struct {
+		name     string
+		resource int
+		value    uint64
+	}{
+		{"LimitNOFILE", syscall.RLIMIT_NOFILE, s.LimitNOFILE},
+		{"LimitNPROC", unix.RLIMIT_NPROC, s.LimitNPROC},
+	} {
+		if limit.value == 0 {
+			continue
+		}
+
+		if err := prlimit(pid, limit.resource, &syscall.Rlimit{Cur: limit.value, Max: limit.value}, nil); err != nil {
+			return fmt.Errorf("%s %d: %w", limit.name, limit.value, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package system
+
+// raiseCoreLimit fails without prlimit, the process keeps the core limit of
+// the supervisor
+func raiseCoreLimit(pid int) error {
+	return ErrUnsupportedPlatform
+}
+
+// applyRlimits fails without prlimit if the service has LimitNOFILE or LimitNPROC
+func (s *Service) applyRlimits(pid int) error {
+	if s.LimitNOFILE == 0 && s.LimitNPROC == 0 {
+		return nil
+	}
+
+	return ErrUnsupportedPlatform
+}
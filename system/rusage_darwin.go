@@ -0,0 +1,16 @@
+package system
+
+import (
+	"os"
+	"syscall"
+)
+
+// maxRSSKB is the peak resident memory of an exited process, darwin counts
+// ru_maxrss in bytes
+func maxRSSKB(state *os.ProcessState) uint64 {
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok && usage != nil && usage.Maxrss > 0 {
+		return uint64(usage.Maxrss) / 1024
+	}
+
+	return 0
+}
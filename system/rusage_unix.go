@@ -0,0 +1,17 @@
+//go:build unix && !darwin
+
+package system
+
+import (
+	"os"
+	"syscall"
+)
+
+// maxRSSKB is the peak resident memory of an exited process, in kB
+func maxRSSKB(state *os.ProcessState) uint64 {
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok && usage != nil && usage.Maxrss > 0 {
+		return uint64(usage.Maxrss)
+	}
+
+	return 0
+}
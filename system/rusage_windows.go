@@ -0,0 +1,9 @@
+package system
+
+import "os"
+
+// maxRSSKB is not in the exit status on windows, the samples taken while the
+// process ran tell its peak
+func maxRSSKB(state *os.ProcessState) uint64 {
+	return 0
+}
@@ -0,0 +1,122 @@
+package system
+
+import (
+	"sync"
+	"time"
+)
+
+const SAMPLE_INTERVAL = 10 * time.Second
+const SAMPLE_RETENTION = time.Hour
+
+// Sample is the resource usage of the service process at a time
+type Sample struct {
+	Time        time.Time `json:"time"`
+	Incarnation int       `json:"incarnation"`
+	RSSBytes    uint64    `json:"rssBytes"`
+	// MemoryBytes of the process tree in the MemoryMetric of the service,
+	// RSSBytes of the process
+	MemoryBytes uint64  `json:"memoryBytes"`
+	CPUPercent  float64 `json:"cpuPercent"`
+}
+
+// samples is a ring of the last samples, allocated once and written in place
+type samples struct {
+	mu    sync.Mutex
+	ring  []Sample
+	next  int
+	count int
+
+	// cpu time of the previous sample, to get the usage between samples
+	lastIncarnation int
+	lastCPU         time.Duration
+	lastTime        time.Time
+
+	// buffer /proc files are read into
+	buf []byte
+}
+
+func (s *Service) GetSampleInterval() time.Duration {
+	if s.SampleInterval > 0 {
+		return s.SampleInterval
+	}
+
+	return SAMPLE_INTERVAL
+}
+
+func (s *Service) GetSampleRetention() time.Duration {
+	if s.SampleRetention > 0 {
+		return s.SampleRetention
+	}
+
+	return SAMPLE_RETENTION
+}
+
+// Samples returns the samples taken after since, oldest first
+func (s *Service) Samples(since time.Time) []Sample {
+	r := &s.samples
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var taken []Sample
+	for i := 0; i < r.count; i++ {
+		sample := r.ring[(r.next-r.count+i+len(r.ring))%len(r.ring)]
+		if sample.Time.After(since) {
+			taken = append(taken, sample)
+		}
+	}
+
+	return taken
+}
+
+// sample records the usage of the process, it is run by the scheduler every sample interval,
+// none are taken without the stats and the memory of processes
+func (s *Service) sample(running *process) {
+	if capabilities := procCapabilities(); !capabilities.Processes || !capabilities.Memory {
+		return
+	}
+
+	r := &s.samples
+	now := time.Now()
+	pid, incarnation := running.cmd.Process.Pid, running.incarnation
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.ring == nil {
+		size := int(s.GetSampleRetention() / s.GetSampleInterval())
+		if size < 1 {
+			size = 1
+		}
+
+		r.ring = make([]Sample, size)
+		r.buf = make([]byte, 4096)
+	}
+
+	cpu, rss, err := r.read(pid)
+	if err != nil {
+		return
+	}
+
+	slot := &r.ring[r.next]
+	slot.Time = now
+	slot.Incarnation = incarnation
+	slot.RSSBytes = rss
+	slot.MemoryBytes = rss
+	if kb, _, err := s.memoryUsage(pid, 0); err == nil {
+		slot.MemoryBytes = kb * 1024
+	}
+	slot.CPUPercent = 0
+
+	// the first sample of a run has nothing to compare with
+	if incarnation == r.lastIncarnation && cpu >= r.lastCPU {
+		elapsed := now.Sub(r.lastTime).Seconds()
+		slot.CPUPercent = (cpu - r.lastCPU).Seconds() / elapsed * 100
+	}
+
+	r.next = (r.next + 1) % len(r.ring)
+	if r.count < len(r.ring) {
+		r.count += 1
+	}
+
+	r.lastIncarnation, r.lastCPU, r.lastTime = incarnation, cpu, now
+}
@@ -0,0 +1,51 @@
+//go:build !darwin && !windows
+
+package system
+
+import (
+	"os"
+	"time"
+
+	"github.com/imunhatep/systemgo/procfs"
+)
+
+// read returns cpu time and resident memory of pid from its stat and statm
+func (r *samples) read(pid int) (cpu time.Duration, rss uint64, err error) {
+	data, err := r.readFile(procPath("%d/stat", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	stat, err := procfs.ParseStat(data)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	data, err = r.readFile(procPath("%d/statm", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	statm, err := procfs.ParseStatm(data)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return ticksDuration(stat.UTime + stat.STime), statm.ResidentBytes(os.Getpagesize()), nil
+}
+
+// readFile reads a small /proc file into the shared buffer
+func (r *samples) readFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	n, err := f.Read(r.buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.buf[:n], nil
+}
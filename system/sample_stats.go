@@ -0,0 +1,15 @@
+//go:build darwin || windows
+
+package system
+
+import "time"
+
+// read returns cpu time and resident memory of pid from the platform
+func (r *samples) read(pid int) (cpu time.Duration, rss uint64, err error) {
+	stats, err := processStats(pid, true)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return stats.UserCPU + stats.SystemCPU, stats.RSSKB * 1024, nil
+}
@@ -0,0 +1,178 @@
+package system
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// OUTPUT_SAMPLING_REPORT is the default time between two reports of the lines
+// sampled away
+const OUTPUT_SAMPLING_REPORT = 10 * time.Second
+
+// OutputSampling thins the captured lines of a chatty service before anything
+// else is done with them, lines sampled away are not printed, followed, forwarded
+// or matched by triggers, their count is reported as a line of its own
+type OutputSampling struct {
+	// Rules are matched in order, the first one matching a line decides, lines
+	// no rule matches are kept
+	Rules []OutputSamplingRule
+
+	// ReportInterval is the minimum time between two "… N lines sampled" lines,
+	// OUTPUT_SAMPLING_REPORT if not set
+	ReportInterval time.Duration
+}
+
+// OutputSamplingRule keeps 1 in KeepEvery lines matching Pattern, all of them
+// if KeepEvery is 0 or 1, like an "ERROR" rule before a "DEBUG" one
+type OutputSamplingRule struct {
+	// Pattern is a regexp, an empty one matches every line
+	Pattern   string
+	KeepEvery int
+}
+
+func (o *OutputSampling) UnmarshalJSON(data []byte) error {
+	type sampling OutputSampling
+
+	aux := struct {
+		*sampling
+		ReportInterval duration
+	}{sampling: (*sampling)(o)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	o.ReportInterval = time.Duration(aux.ReportInterval)
+
+	return nil
+}
+
+func (o OutputSampling) GetReportInterval() time.Duration {
+	if o.ReportInterval > 0 {
+		return o.ReportInterval
+	}
+
+	return OUTPUT_SAMPLING_REPORT
+}
+
+// ValidateOutputSampling compiles the patterns and checks the rates of the rules
+func ValidateOutputSampling(sampling OutputSampling) error {
+	for i, rule := range sampling.Rules {
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return fmt.Errorf("sampling.rules[%d]: %s", i, err)
+		}
+
+		if rule.KeepEvery < 0 {
+			return fmt.Errorf("sampling.rules[%d]: keepEvery must not be negative", i)
+		}
+	}
+
+	return nil
+}
+
+// samplingRule is an OutputSamplingRule with its compiled pattern
+type samplingRule struct {
+	pattern   *regexp.Regexp
+	keepEvery int
+}
+
+// outputSampling compiles the rules of the service once
+func (s *Service) outputSampling() []samplingRule {
+	s.samplingOnce.Do(func() {
+		for _, config := range s.Sampling.Rules {
+			pattern, err := regexp.Compile(config.Pattern)
+			if err != nil {
+				s.log().warnf("invalid sampling rule: %s", err)
+				continue
+			}
+
+			s.samplingRules = append(s.samplingRules, samplingRule{pattern: pattern, keepEvery: config.KeepEvery})
+		}
+	})
+
+	return s.samplingRules
+}
+
+// sampler decides the lines of a stream to keep, a scanner has its own
+type sampler struct {
+	rules   []samplingRule
+	seen    []int
+	clock   Clock
+	every   time.Duration
+	dropped int
+	// reported is the Monotonic reading of the last report
+	reported time.Duration
+}
+
+// newSampler returns the sampler of a stream, nil without rules so lines are
+// kept without a look
+func (s *Service) newSampler() *sampler {
+	rules := s.outputSampling()
+	if len(rules) == 0 {
+		return nil
+	}
+
+	clock := s.getClock()
+
+	return &sampler{rules: rules, seen: make([]int, len(rules)), clock: clock, every: s.Sampling.GetReportInterval(), reported: clock.Monotonic()}
+}
+
+// keep reports whether the line is kept by the first rule matching it
+func (r *sampler) keep(line string) bool {
+	for i, rule := range r.rules {
+		if !rule.pattern.MatchString(line) {
+			continue
+		}
+
+		if rule.keepEvery <= 1 {
+			return true
+		}
+
+		r.seen[i] += 1
+		if r.seen[i]%rule.keepEvery == 1 {
+			return true
+		}
+
+		r.dropped += 1
+
+		return false
+	}
+
+	return true
+}
+
+// report returns the line telling the count of the lines sampled away since the
+// last one, once the report interval has passed or at the end of the output
+func (r *sampler) report(end bool) (string, bool) {
+	if r.dropped == 0 {
+		return "", false
+	}
+
+	now := r.clock.Monotonic()
+	if !end && now-r.reported < r.every {
+		return "", false
+	}
+
+	line := fmt.Sprintf("… %s lines sampled", groupDigits(r.dropped))
+	r.dropped, r.reported = 0, now
+
+	return line, true
+}
+
+// groupDigits writes n with thousands separated by commas, 18321 is "18,321"
+func groupDigits(n int) string {
+	digits := strconv.Itoa(n)
+
+	grouped := make([]byte, 0, len(digits)+len(digits)/3)
+	for i := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			grouped = append(grouped, ',')
+		}
+		grouped = append(grouped, digits[i])
+	}
+
+	return string(grouped)
+}
@@ -0,0 +1,65 @@
+package system
+
+import "testing"
+
+// DEBUG_LINE is the noise of a chatty service, sampled away by a rule
+const DEBUG_LINE = `2026-10-14T05:33:06Z DEBUG cache lookup key=services/api/v1 hit=true took=12µs`
+
+// samplerOf returns the sampler of a service with the rules, nil without any
+func samplerOf(rules ...OutputSamplingRule) *sampler {
+	return NewService(ServiceConfig{Name: "chatty", Exec: "true", Sampling: OutputSampling{Rules: rules}}).newSampler()
+}
+
+// errorsThenDebug keeps every error and 1 in 100 debug lines
+var errorsThenDebug = []OutputSamplingRule{{Pattern: `ERROR|FATAL`}, {Pattern: `DEBUG`, KeepEvery: 100}}
+
+func TestSamplingKeepDoesNotAllocate(t *testing.T) {
+	if raceEnabled {
+		// the regexp machines are not pooled under the race detector
+		t.Skip("allocations counted with the race detector")
+	}
+
+	sampler := samplerOf(errorsThenDebug...)
+
+	if n := testing.AllocsPerRun(100, func() { sampler.keep(DEBUG_LINE) }); n != 0 {
+		t.Fatalf("%.0f allocations to sample a line", n)
+	}
+}
+
+// benchmarkSampling is the step of the scanner before a line is formatted
+func benchmarkSampling(b *testing.B, line string, rules ...OutputSamplingRule) {
+	sampler := samplerOf(rules...)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(line)))
+	b.ResetTimer()
+
+	kept := 0
+	for i := 0; i < b.N; i++ {
+		if sampler == nil || sampler.keep(line) {
+			kept += 1
+		}
+	}
+
+	b.ReportMetric(float64(kept)/float64(b.N), "kept/line")
+}
+
+// BenchmarkSamplingNoRules is the fast path of every service not sampled, the
+// line is kept without a look
+func BenchmarkSamplingNoRules(b *testing.B) {
+	benchmarkSampling(b, DEBUG_LINE)
+}
+
+func BenchmarkSamplingDropped(b *testing.B) {
+	benchmarkSampling(b, DEBUG_LINE, errorsThenDebug...)
+}
+
+// BenchmarkSamplingErrorKept matches the first rule, the error pattern
+func BenchmarkSamplingErrorKept(b *testing.B) {
+	benchmarkSampling(b, `2026-10-14T05:33:06Z ERROR cache lookup failed: connection reset`, errorsThenDebug...)
+}
+
+// BenchmarkSamplingNoMatch goes through every rule and keeps the line
+func BenchmarkSamplingNoMatch(b *testing.B) {
+	benchmarkSampling(b, ASCII_LINE, errorsThenDebug...)
+}
@@ -0,0 +1,178 @@
+package system
+
+import (
+	"encoding/hex"
+	"strings"
+	"unicode/utf8"
+)
+
+// SANITIZE_HEX_PREFIX starts the lines hex-encoded by HexBinary
+const SANITIZE_HEX_PREFIX = "hex:"
+
+// OutputSanitize cleans captured lines before they are printed, followed or
+// kept in the stderr tail, lines of printable ascii are left as they are
+type OutputSanitize struct {
+	// Latin1 decodes lines as ISO-8859-1 instead of UTF-8
+	Latin1 bool
+
+	// InvalidUTF8 replaces bytes that are not valid UTF-8 with U+FFFD
+	InvalidUTF8 bool
+
+	// StripANSI removes terminal escape sequences and control characters but tabs
+	StripANSI bool
+
+	// KeepColors keeps the color sequences (SGR, "ESC[...m") StripANSI would remove
+	KeepColors bool
+
+	// HexBinary writes lines with more than half of the bytes not printable as
+	// SANITIZE_HEX_PREFIX followed by their bytes in hex
+	HexBinary bool
+}
+
+func (o OutputSanitize) enabled() bool {
+	return o.Latin1 || o.InvalidUTF8 || o.StripANSI || o.HexBinary
+}
+
+// clean returns the line as set by the options
+func (o OutputSanitize) clean(line string) string {
+	if isPrintableASCII(line) {
+		return line
+	}
+
+	if o.HexBinary && isBinary(line, o.Latin1) {
+		return SANITIZE_HEX_PREFIX + hex.EncodeToString([]byte(line))
+	}
+
+	switch {
+	case o.Latin1:
+		line = decodeLatin1(line)
+	case o.InvalidUTF8 && !utf8.ValidString(line):
+		line = strings.ToValidUTF8(line, string(utf8.RuneError))
+	}
+
+	if o.StripANSI {
+		line = stripANSI(line, o.KeepColors)
+	}
+
+	return line
+}
+
+func isPrintableASCII(line string) bool {
+	for i := 0; i < len(line); i++ {
+		if c := line[i]; (c < 0x20 && c != '\t') || c > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isBinary reports whether most bytes of the line are control characters or not
+// valid UTF-8, every byte is a character of Latin1
+func isBinary(line string, latin1 bool) bool {
+	unprintable := 0
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == '\t' || c == 0x1b:
+			i += 1
+		case c < 0x20 || c == 0x7f:
+			unprintable += 1
+			i += 1
+		case c < utf8.RuneSelf || latin1:
+			i += 1
+		default:
+			r, size := utf8.DecodeRuneInString(line[i:])
+			if r == utf8.RuneError && size == 1 {
+				unprintable += 1
+			}
+			i += size
+		}
+	}
+
+	return unprintable*2 > len(line)
+}
+
+func decodeLatin1(line string) string {
+	var b strings.Builder
+	b.Grow(len(line) + len(line)/2)
+	for i := 0; i < len(line); i++ {
+		b.WriteRune(rune(line[i]))
+	}
+
+	return b.String()
+}
+
+// stripANSI removes escape sequences and control characters, color sequences are
+// kept if keepColors is set
+func stripANSI(line string, keepColors bool) string {
+	var b strings.Builder
+	b.Grow(len(line))
+
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == 0x1b:
+			end, isColor := escapeEnd(line, i)
+			if keepColors && isColor {
+				b.WriteString(line[i:end])
+			}
+			i = end
+		case (c < 0x20 && c != '\t') || c == 0x7f:
+			i += 1
+		default:
+			b.WriteByte(c)
+			i += 1
+		}
+	}
+
+	return b.String()
+}
+
+// escapeEnd returns the end of the escape sequence starting at i and whether it
+// sets colors only
+func escapeEnd(line string, i int) (int, bool) {
+	i += 1
+	if i >= len(line) {
+		return i, false
+	}
+
+	switch line[i] {
+	case '[':
+		// CSI: parameter bytes, intermediate bytes, then a final byte
+		i += 1
+		start := i
+		for i < len(line) && line[i] >= 0x30 && line[i] <= 0x3f {
+			i += 1
+		}
+		params := line[start:i]
+
+		for i < len(line) && line[i] >= 0x20 && line[i] <= 0x2f {
+			i += 1
+		}
+
+		if i < len(line) && line[i] >= 0x40 && line[i] <= 0x7e {
+			isColor := line[i] == 'm' && strings.Trim(params, "0123456789;") == ""
+			return i + 1, isColor
+		}
+
+		return i, false
+
+	case ']', 'P', '_', '^':
+		// OSC and other strings end with BEL or ESC \
+		for i += 1; i < len(line); i++ {
+			if line[i] == 0x07 {
+				return i + 1, false
+			}
+
+			if line[i] == 0x1b && i+1 < len(line) && line[i+1] == '\\' {
+				return i + 2, false
+			}
+		}
+
+		return i, false
+	}
+
+	// two byte sequences like "ESC c"
+	return i + 1, false
+}
@@ -0,0 +1,70 @@
+package system
+
+import (
+	"strings"
+	"testing"
+)
+
+// ASCII_LINE is the common line of a request log, printable ascii only
+const ASCII_LINE = `127.0.0.1 - - [14/Oct/2026:05:33:06 +0000] "GET /api/v1/services HTTP/1.1" 200 5316 "-" "curl/8.5.0"`
+
+var allOptions = OutputSanitize{InvalidUTF8: true, StripANSI: true, KeepColors: true, HexBinary: true}
+
+func TestSanitizeLines(t *testing.T) {
+	cases := []struct {
+		options OutputSanitize
+		line    string
+		want    string
+	}{
+		{allOptions, ASCII_LINE, ASCII_LINE},
+		{allOptions, "caf\xe9 ok", "caf� ok"},
+		{OutputSanitize{Latin1: true}, "caf\xe9 ok", "café ok"},
+		{OutputSanitize{StripANSI: true}, "\x1b[1;31merror\x1b[0m \x1b]0;title\x07done\x1b[2J", "error done"},
+		{allOptions, "\x1b[1;31merror\x1b[0m \x1b[2Jdone", "\x1b[1;31merror\x1b[0m done"},
+		{allOptions, "\x00\x01\x02ok", "hex:0001026f6b"},
+		{OutputSanitize{HexBinary: true}, "\x00\x01ok\tok", "\x00\x01ok\tok"},
+	}
+
+	for _, c := range cases {
+		if got := c.options.clean(c.line); got != c.want {
+			t.Errorf("clean %q with %+v is %q, want %q", c.line, c.options, got, c.want)
+		}
+	}
+}
+
+func TestSanitizeASCIIDoesNotAllocate(t *testing.T) {
+	if n := testing.AllocsPerRun(100, func() { allOptions.clean(ASCII_LINE) }); n != 0 {
+		t.Fatalf("%.0f allocations to clean a line of ascii", n)
+	}
+}
+
+func benchmarkSanitize(b *testing.B, options OutputSanitize, line string) {
+	b.ReportAllocs()
+	b.SetBytes(int64(len(line)))
+
+	for i := 0; i < b.N; i++ {
+		options.clean(line)
+	}
+}
+
+// BenchmarkSanitizeASCII is the line of almost every program, it is to be left
+// as it is without an allocation
+func BenchmarkSanitizeASCII(b *testing.B) {
+	benchmarkSanitize(b, allOptions, ASCII_LINE)
+}
+
+func BenchmarkSanitizeColors(b *testing.B) {
+	benchmarkSanitize(b, allOptions, "\x1b[32mINFO\x1b[0m "+ASCII_LINE+" \x1b[2K")
+}
+
+func BenchmarkSanitizeInvalidUTF8(b *testing.B) {
+	benchmarkSanitize(b, allOptions, strings.Replace(ASCII_LINE, "curl", "caf\xe9", 1))
+}
+
+func BenchmarkSanitizeLatin1(b *testing.B) {
+	benchmarkSanitize(b, OutputSanitize{Latin1: true, StripANSI: true}, strings.Replace(ASCII_LINE, "curl", "caf\xe9", 1))
+}
+
+func BenchmarkSanitizeBinary(b *testing.B) {
+	benchmarkSanitize(b, allOptions, strings.Repeat("\x00\x9c\x01", 40))
+}
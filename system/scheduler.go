@@ -0,0 +1,349 @@
+package system
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// SCHEDULER_WORKERS is the default number of jobs run at once, probes, samples and hooks
+const SCHEDULER_WORKERS = 8
+
+// SCHEDULER_JITTER is the part of the interval a repeated job is moved by at random
+const SCHEDULER_JITTER = 0.1
+
+var ErrDeadlineMissed = errors.New("job missed its deadline")
+
+var defaultScheduler struct {
+	once      sync.Once
+	scheduler *scheduler
+}
+
+// SchedulerStats tells how the shared scheduler keeps up
+type SchedulerStats struct {
+	Workers int `json:"workers"`
+	// Scheduled jobs wait for their time, Queued ones for a worker
+	Scheduled int   `json:"scheduled"`
+	Queued    int   `json:"queued"`
+	Running   int   `json:"running"`
+	Done      int64 `json:"done"`
+	// Missed jobs were skipped as no worker took them before their deadline
+	Missed int64 `json:"missed"`
+}
+
+type job struct {
+	// at is the Monotonic reading of the clock the job is due at
+	at      time.Duration
+	timeout time.Duration
+	// done stops a repeated job, nil if it stops on its own
+	done <-chan struct{}
+	// run returns whether the job is to be repeated after interval
+	run      func(ctx context.Context) bool
+	interval time.Duration
+	// missed is called instead of run if the deadline passed before a worker took the job
+	missed func()
+}
+
+// jobQueue is a heap of jobs by their time
+type jobQueue []*job
+
+func (q jobQueue) Len() int            { return len(q) }
+func (q jobQueue) Less(i, j int) bool  { return q[i].at < q[j].at }
+func (q jobQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *jobQueue) Push(x interface{}) { *q = append(*q, x.(*job)) }
+func (q *jobQueue) Pop() interface{} {
+	old := *q
+	last := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+
+	return last
+}
+
+// scheduler runs short jobs of all services on a bounded number of workers,
+// repeated jobs are spread by jitter so they do not run all at once
+type scheduler struct {
+	mu        sync.Mutex
+	ready     *sync.Cond
+	scheduled jobQueue
+	queued    []*job
+	wake      chan struct{}
+	workers   int
+	started   bool
+	clock     Clock
+
+	running int
+	done    int64
+	missed  int64
+}
+
+func newScheduler(workers int) *scheduler {
+	s := new(scheduler)
+	s.ready = sync.NewCond(&s.mu)
+	s.wake = make(chan struct{}, 1)
+	s.workers = workers
+	s.clock = SystemClock
+
+	return s
+}
+
+func getDefaultScheduler() *scheduler {
+	defaultScheduler.once.Do(func() {
+		defaultScheduler.scheduler = newScheduler(SCHEDULER_WORKERS)
+	})
+
+	return defaultScheduler.scheduler
+}
+
+// setWorkers changes the number of workers, it has no effect once a job was scheduled
+func (s *scheduler) setWorkers(workers int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.started && workers > 0 {
+		s.workers = workers
+	}
+}
+
+// setClock changes the clock the jobs are scheduled on, it has no effect once a job
+// was scheduled
+func (s *scheduler) setClock(clock Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.started && clock != nil {
+		s.clock = clock
+	}
+}
+
+func (s *scheduler) getClock() Clock {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.clock
+}
+
+// every runs fn after a random part of interval, then every interval give or take
+// the jitter, until fn returns false or done is closed, a run is limited by timeout
+func (s *scheduler) every(interval, timeout time.Duration, done <-chan struct{}, fn func(ctx context.Context) bool) {
+	at := s.getClock().Monotonic() + time.Duration(rand.Int63n(int64(interval)+1))
+	j := &job{at: at, timeout: timeout, done: done, run: fn, interval: interval}
+	s.add(j)
+
+	// the job is dropped once done rather than at its next time, fn does not keep
+	// what it refers to, an exited process, alive until then
+	if done != nil {
+		go func() {
+			<-done
+			s.remove(j)
+		}()
+	}
+}
+
+// do runs fn on a worker and waits for it, it fails with ErrDeadlineMissed if no
+// worker took it within timeout
+func (s *scheduler) do(ctx context.Context, timeout time.Duration, fn func(ctx context.Context) error) error {
+	result := make(chan error, 1)
+
+	s.add(&job{
+		at:      s.getClock().Monotonic(),
+		timeout: timeout,
+		done:    ctx.Done(),
+		run: func(context.Context) bool {
+			runCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			result <- fn(runCtx)
+			return false
+		},
+		missed: func() {
+			result <- ErrDeadlineMissed
+		},
+	})
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *scheduler) add(j *job) {
+	s.mu.Lock()
+	// a repeated job done while it ran is not scheduled again
+	if j.interval > 0 {
+		select {
+		case <-j.done:
+			s.mu.Unlock()
+			return
+		default:
+		}
+	}
+	if !s.started {
+		s.started = true
+
+		go s.dispatch()
+		for i := 0; i < s.workers; i++ {
+			go s.work()
+		}
+	}
+
+	heap.Push(&s.scheduled, j)
+	first := s.scheduled[0] == j
+	s.mu.Unlock()
+
+	if first {
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// remove drops the job if it waits for its time, a queued or running one is
+// dropped by its worker
+func (s *scheduler) remove(j *job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, scheduled := range s.scheduled {
+		if scheduled == j {
+			heap.Remove(&s.scheduled, i)
+			return
+		}
+	}
+}
+
+// dispatch queues jobs for the workers once their time has come
+func (s *scheduler) dispatch() {
+	for {
+		s.mu.Lock()
+		now := s.clock.Monotonic()
+		for len(s.scheduled) > 0 && s.scheduled[0].at <= now {
+			s.queued = append(s.queued, heap.Pop(&s.scheduled).(*job))
+			s.ready.Signal()
+		}
+
+		wait := time.Minute
+		if len(s.scheduled) > 0 {
+			wait = s.scheduled[0].at - now
+		}
+		s.mu.Unlock()
+
+		timer := s.clock.NewTimer(wait)
+		select {
+		case <-timer.C():
+		case <-s.wake:
+			timer.Stop()
+		}
+	}
+}
+
+func (s *scheduler) work() {
+	for {
+		s.mu.Lock()
+		for len(s.queued) == 0 {
+			s.ready.Wait()
+		}
+
+		j := s.queued[0]
+		s.queued[0] = nil
+		s.queued = s.queued[1:]
+		s.running += 1
+		s.mu.Unlock()
+
+		repeat := s.execute(j)
+
+		s.mu.Lock()
+		s.running -= 1
+		s.mu.Unlock()
+
+		if repeat {
+			j.at = s.clock.Monotonic() + jitter(j.interval)
+			s.add(j)
+		}
+	}
+}
+
+// execute runs the job unless it is done or late, it returns whether to repeat it
+func (s *scheduler) execute(j *job) bool {
+	select {
+	case <-j.done:
+		return false
+	default:
+	}
+
+	if s.clock.Monotonic()-j.at > j.timeout {
+		s.mu.Lock()
+		s.missed += 1
+		s.mu.Unlock()
+
+		if j.missed != nil {
+			j.missed()
+		}
+
+		return j.interval > 0
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), j.timeout)
+	repeat := j.run(ctx)
+	cancel()
+
+	s.mu.Lock()
+	s.done += 1
+	s.mu.Unlock()
+
+	return repeat && j.interval > 0
+}
+
+func (s *scheduler) stats() SchedulerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return SchedulerStats{
+		Workers:   s.workers,
+		Scheduled: len(s.scheduled),
+		Queued:    len(s.queued),
+		Running:   s.running,
+		Done:      s.done,
+		Missed:    s.missed,
+	}
+}
+
+// jitter returns interval moved by up to SCHEDULER_JITTER of it either way
+func jitter(interval time.Duration) time.Duration {
+	spread := int64(float64(interval) * SCHEDULER_JITTER)
+	if spread <= 0 {
+		return interval
+	}
+
+	return interval + time.Duration(rand.Int63n(2*spread+1)-spread)
+}
+
+// SetSchedulerWorkers sets the number of probes, samples and hooks run at once,
+// SCHEDULER_WORKERS if not set, it must be called before Run
+func (m *Manager) SetSchedulerWorkers(workers int) {
+	m.scheduler.setWorkers(workers)
+}
+
+// SchedulerStats returns the queue depth and the missed deadlines of the scheduler
+func (m *Manager) SchedulerStats() SchedulerStats {
+	return m.scheduler.stats()
+}
+
+// getScheduler returns the scheduler of the manager, services run on their own
+// share a default one
+func (s *Service) getScheduler() *scheduler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.scheduler == nil {
+		s.scheduler = getDefaultScheduler()
+	}
+
+	return s.scheduler
+}
@@ -0,0 +1,268 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeScheduler is a scheduler of workers on a fake clock of its own
+func fakeScheduler(workers int) (*scheduler, *FakeClock) {
+	clock := NewFakeClock(time.Now())
+	s := newScheduler(workers)
+	s.setClock(clock)
+
+	return s, clock
+}
+
+// blockWorkers takes every worker of s with a job waiting for the returned
+// release to be called
+func blockWorkers(t *testing.T, s *scheduler, workers int) func() {
+	t.Helper()
+
+	release := make(chan struct{})
+	for i := 0; i < workers; i++ {
+		go s.do(context.Background(), time.Hour, func(context.Context) error {
+			<-release
+			return nil
+		})
+	}
+	eventually(t, 5*time.Second, "the workers taken", func() bool { return s.stats().Running == workers })
+
+	var once sync.Once
+	return func() { once.Do(func() { close(release) }) }
+}
+
+func TestSchedulerBoundsTheGoroutines(t *testing.T) {
+	const (
+		WORKERS  = 4
+		PROBES   = 400
+		INTERVAL = 5 * time.Second
+		RUNS     = 3
+	)
+
+	goroutines := runtime.NumGoroutine()
+	s, clock := fakeScheduler(WORKERS)
+
+	var running, most, peak int32
+	runs := make([]int32, PROBES)
+	for i := 0; i < PROBES; i++ {
+		i := i
+		s.every(INTERVAL, time.Second, nil, func(context.Context) bool {
+			now := atomic.AddInt32(&running, 1)
+			for {
+				seen := atomic.LoadInt32(&most)
+				if now <= seen || atomic.CompareAndSwapInt32(&most, seen, now) {
+					break
+				}
+			}
+			if n := int32(runtime.NumGoroutine()); n > atomic.LoadInt32(&peak) {
+				atomic.StoreInt32(&peak, n)
+			}
+
+			time.Sleep(100 * time.Microsecond)
+			atomic.AddInt32(&running, -1)
+
+			return atomic.AddInt32(&runs[i], 1) < RUNS
+		})
+	}
+
+	eventually(t, 20*time.Second, "every probe run", func() bool {
+		for i := range runs {
+			if atomic.LoadInt32(&runs[i]) < RUNS {
+				clock.Advance(INTERVAL / 4)
+				return false
+			}
+		}
+		return true
+	})
+
+	if most > WORKERS {
+		t.Fatalf("%d probes ran at once on %d workers", most, WORKERS)
+	}
+	// the workers and the dispatcher, whatever the number of probes
+	if limit := int32(goroutines + WORKERS + 1 + 2); peak > limit {
+		t.Fatalf("%d goroutines for %d probes, want at most %d", peak, PROBES, limit)
+	}
+	if stats := s.stats(); stats.Scheduled != 0 || stats.Done != PROBES*RUNS {
+		t.Fatalf("stats %+v once every probe stopped, want %d done", stats, PROBES*RUNS)
+	}
+}
+
+func TestSchedulerSpreadsTheProbes(t *testing.T) {
+	const INTERVAL = 10 * time.Second
+	s, _ := fakeScheduler(1)
+
+	// the first runs fall anywhere within the interval
+	start := s.getClock().Monotonic()
+	for i := 0; i < 100; i++ {
+		s.every(INTERVAL, time.Second, nil, func(context.Context) bool { return false })
+	}
+
+	s.mu.Lock()
+	first, last := INTERVAL, time.Duration(0)
+	for _, j := range s.scheduled {
+		at := j.at - start
+		if at < 0 || at > INTERVAL {
+			s.mu.Unlock()
+			t.Fatalf("first run after %s, want within %s", at, INTERVAL)
+		}
+		if at < first {
+			first = at
+		}
+		if at > last {
+			last = at
+		}
+	}
+	s.mu.Unlock()
+	if last-first < INTERVAL/2 {
+		t.Fatalf("first runs within %s of each other, want spread over %s", last-first, INTERVAL)
+	}
+
+	// the later runs by up to the jitter either way
+	spread := time.Duration(float64(INTERVAL) * SCHEDULER_JITTER)
+	short, long := INTERVAL, INTERVAL
+	for i := 0; i < 1000; i++ {
+		d := jitter(INTERVAL)
+		if d < INTERVAL-spread || d > INTERVAL+spread {
+			t.Fatalf("jitter of %s is %s, want within %s", INTERVAL, d, spread)
+		}
+		if d < short {
+			short = d
+		}
+		if d > long {
+			long = d
+		}
+	}
+	if short > INTERVAL-spread/2 || long < INTERVAL+spread/2 {
+		t.Fatalf("jitter between %s and %s, want spread over %s either way", short, long, spread)
+	}
+
+	if d := jitter(time.Nanosecond); d != time.Nanosecond {
+		t.Fatalf("jitter of a nanosecond is %s", d)
+	}
+}
+
+func TestSchedulerQueueDepth(t *testing.T) {
+	s, _ := fakeScheduler(2)
+	release := blockWorkers(t, s, 2)
+	defer release()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.do(context.Background(), time.Hour, func(context.Context) error { return nil })
+		}()
+	}
+
+	eventually(t, 5*time.Second, "the queued jobs", func() bool {
+		stats := s.stats()
+		return stats.Queued == 3 && stats.Running == 2
+	})
+
+	release()
+	wg.Wait()
+	if stats := s.stats(); stats.Queued != 0 || stats.Running != 0 || stats.Done != 5 || stats.Missed != 0 {
+		t.Fatalf("stats %+v, want the 5 jobs done", stats)
+	}
+}
+
+func TestSchedulerMissedDeadline(t *testing.T) {
+	s, clock := fakeScheduler(1)
+	release := blockWorkers(t, s, 1)
+	defer release()
+
+	// a hook waits for a worker longer than its timeout
+	var ran int32
+	result := make(chan error, 1)
+	go func() {
+		result <- s.do(context.Background(), 10*time.Millisecond, func(context.Context) error {
+			atomic.StoreInt32(&ran, 1)
+			return nil
+		})
+	}()
+	eventually(t, 5*time.Second, "the hook queued", func() bool { return s.stats().Queued == 1 })
+
+	// and so does a probe, it is not dropped but run at its next time
+	var probes int32
+	s.every(20*time.Millisecond, 10*time.Millisecond, nil, func(context.Context) bool {
+		return atomic.AddInt32(&probes, 1) < 2
+	})
+	advanceUntil(t, clock, 20*time.Millisecond, "the probe queued", func() bool { return s.stats().Queued == 2 })
+
+	clock.Advance(time.Second)
+	release()
+
+	if err := <-result; !errors.Is(err, ErrDeadlineMissed) {
+		t.Fatalf("hook: %v, want ErrDeadlineMissed", err)
+	}
+	if atomic.LoadInt32(&ran) != 0 {
+		t.Fatal("a hook run after its deadline")
+	}
+
+	// a step of the clock past the deadline of a run would miss it again
+	advanceUntil(t, clock, 0, "the probe run twice", func() bool { return atomic.LoadInt32(&probes) == 2 })
+	if stats := s.stats(); stats.Missed != 2 {
+		t.Fatalf("stats %+v, want the hook and a run of the probe missed", stats)
+	}
+}
+
+func TestSchedulerTimeoutOfARun(t *testing.T) {
+	s, _ := fakeScheduler(1)
+
+	// the deadline of a run is on the wall clock, the fake one only schedules
+	err := s.do(context.Background(), 20*time.Millisecond, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("run: %v, want its deadline exceeded", err)
+	}
+
+	// a cancelled caller does not wait for the run
+	ctx, cancel := context.WithCancel(context.Background())
+	release := blockWorkers(t, s, 1)
+	defer release()
+
+	go func() {
+		for s.stats().Queued == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	if err := s.do(ctx, time.Hour, func(context.Context) error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Fatalf("run: %v, want the cancel", err)
+	}
+}
+
+func TestSchedulerDropsStoppedProbes(t *testing.T) {
+	s, clock := fakeScheduler(1)
+
+	var runs int32
+	done := make(chan struct{})
+	s.every(time.Second, time.Second, done, func(context.Context) bool {
+		atomic.AddInt32(&runs, 1)
+		return true
+	})
+	advanceUntil(t, clock, time.Second, "a run", func() bool { return atomic.LoadInt32(&runs) > 0 })
+
+	// the probe of an exited process is dropped at once, not at its next time
+	close(done)
+	eventually(t, 5*time.Second, "the probe dropped", func() bool {
+		stats := s.stats()
+		return stats.Scheduled == 0 && stats.Queued == 0 && stats.Running == 0
+	})
+
+	n := atomic.LoadInt32(&runs)
+	clock.Advance(10 * time.Second)
+	time.Sleep(20 * time.Millisecond)
+	if got := atomic.LoadInt32(&runs); got != n {
+		t.Fatalf("%d runs after the stop, want %d", got, n)
+	}
+}
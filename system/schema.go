@@ -0,0 +1,400 @@
+package system
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// CONFIG_SCHEMA_ID is the $id of the JSON Schema of configuration files
+const CONFIG_SCHEMA_ID = "https://github.com/imunhatep/systemgo/config.schema.json"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// configField returns the field of the struct a key sets, matched ignoring case
+// as json does
+func configField(t reflect.Type, key string) (reflect.StructField, bool) {
+	if field, ok := t.FieldByName(key); ok && field.PkgPath == "" && !field.Anonymous {
+		return field, true
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath == "" && !field.Anonymous && strings.EqualFold(field.Name, key) {
+			return field, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+// schemaKey is the key of a field as the documentation writes it, an initial
+// acronym in lower case: "CAFile" is "caFile"
+func schemaKey(field string) string {
+	runes := []rune(field)
+	for i := range runes {
+		if !unicode.IsUpper(runes[i]) {
+			break
+		}
+
+		if i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+			break
+		}
+		runes[i] = unicode.ToLower(runes[i])
+	}
+
+	return string(runes)
+}
+
+// configCheck collects the problems of the documents of a configuration by the
+// types they are decoded into
+type configCheck struct {
+	positions configPositions
+	service   string
+	errs      ConfigErrors
+}
+
+func (c *configCheck) fail(at configPath, err error) {
+	position := c.positions[at.raw]
+	c.errs = append(c.errs, &ConfigError{Line: position.line, Column: position.column, Service: c.service, Path: at.shown, Err: err})
+}
+
+// document checks the keys of a service or defaults document against the struct,
+// drop-ins may append to lists with "key+"
+func (c *configCheck) document(at configPath, document map[string]interface{}, t reflect.Type, dropin bool) {
+	for _, key := range sortedKeys(document) {
+		value := document[key]
+		name := key
+		if dropin && strings.HasSuffix(key, CONFIG_APPEND) {
+			name = strings.TrimSuffix(key, CONFIG_APPEND)
+		}
+
+		field, ok := configField(t, name)
+		if !ok {
+			c.fail(at.key(key), unknownKey(t, name))
+			continue
+		}
+
+		if name == key {
+			c.value(at.key(key), value, field.Type)
+			continue
+		}
+
+		items, isList := listItems(value)
+		if !isList || field.Type.Kind() != reflect.Slice {
+			c.fail(at.key(key), errors.New("only lists can be appended to"))
+			continue
+		}
+
+		for i, item := range items {
+			c.value(at.key(key).index(i), item, field.Type.Elem())
+		}
+	}
+}
+
+// value checks that the value can be decoded into the type, nil sets the zero value
+func (c *configCheck) value(at configPath, value interface{}, t reflect.Type) {
+	if value == nil {
+		return
+	}
+
+	if t == durationType {
+		switch v := value.(type) {
+		case string:
+			if _, err := time.ParseDuration(v); err != nil {
+				c.fail(at, fmt.Errorf("invalid duration %q", v))
+			}
+		case json.Number, int, int64, uint64, float64:
+		default:
+			c.fail(at, fmt.Errorf("expected a duration, got %s", describeValue(value)))
+		}
+
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			c.fail(at, fmt.Errorf("expected a string, got %s", describeValue(value)))
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			c.fail(at, fmt.Errorf("expected true or false, got %s", describeValue(value)))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n, ok := integerValue(value); !ok || reflect.Zero(t).OverflowInt(n) {
+			c.fail(at, fmt.Errorf("expected an integer, got %s", describeValue(value)))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n, ok := integerValue(value); !ok || n < 0 {
+			c.fail(at, fmt.Errorf("expected a positive integer, got %s", describeValue(value)))
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, ok := numberValue(value); !ok {
+			c.fail(at, fmt.Errorf("expected a number, got %s", describeValue(value)))
+		}
+	case reflect.Slice:
+		items, ok := listItems(value)
+		if !ok {
+			c.fail(at, fmt.Errorf("expected a list, got %s", describeValue(value)))
+			return
+		}
+
+		for i, item := range items {
+			c.value(at.index(i), item, t.Elem())
+		}
+	case reflect.Map:
+		document, ok := value.(map[string]interface{})
+		if !ok {
+			c.fail(at, fmt.Errorf("expected a map, got %s", describeValue(value)))
+			return
+		}
+
+		for _, key := range sortedKeys(document) {
+			c.value(at.key(key), document[key], t.Elem())
+		}
+	case reflect.Struct:
+		document, ok := value.(map[string]interface{})
+		if !ok {
+			c.fail(at, fmt.Errorf("expected a map, got %s", describeValue(value)))
+			return
+		}
+
+		c.document(at, document, t, false)
+	}
+}
+
+// unknownKey tells about a key no field has, naming the closest one
+func unknownKey(t reflect.Type, key string) error {
+	best, distance := "", len(key)/3+1
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Anonymous {
+			continue
+		}
+
+		if d := editDistance(strings.ToLower(key), strings.ToLower(field.Name)); d < distance {
+			best, distance = schemaKey(field.Name), d
+		}
+	}
+
+	// a key of services only, like Name, in defaults
+	if t == reflect.TypeOf(ManagerDefaults{}) {
+		if _, ok := configField(reflect.TypeOf(ServiceConfig{}), key); ok {
+			return errors.New("can not have a default")
+		}
+	}
+
+	if best == "" {
+		return errors.New("unknown key")
+	}
+
+	return fmt.Errorf("unknown key, did you mean %q?", best)
+}
+
+// editDistance is the number of runes to insert, delete or replace to turn a into b
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	row := make([]int, len(rb)+1)
+	for j := range row {
+		row[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		previous := row[0]
+		row[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			best := previous + cost
+			if row[j]+1 < best {
+				best = row[j] + 1
+			}
+			if row[j-1]+1 < best {
+				best = row[j-1] + 1
+			}
+
+			previous, row[j] = row[j], best
+		}
+	}
+
+	return row[len(rb)]
+}
+
+func sortedKeys(document map[string]interface{}) []string {
+	keys := make([]string, 0, len(document))
+	for key := range document {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// listItems returns the items of a list of any format, toml tables included
+func listItems(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case []interface{}:
+		return v, true
+	case []map[string]interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = item
+		}
+
+		return items, true
+	}
+
+	return nil, false
+}
+
+func integerValue(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		n, err := strconv.ParseInt(string(v), 10, 64)
+		return n, err == nil
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint64:
+		return int64(v), v <= 1<<63-1
+	case float64:
+		return int64(v), v == float64(int64(v))
+	}
+
+	return 0, false
+}
+
+func numberValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		n, err := v.Float64()
+		return n, err == nil
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+
+	return 0, false
+}
+
+// describeValue names the kind of a value, with the value itself if it is short
+func describeValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		if len(v) > 40 {
+			return "a string"
+		}
+		return strconv.Quote(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number, int, int64, uint64, float64:
+		return fmt.Sprintf("number %v", v)
+	case map[string]interface{}, map[interface{}]interface{}:
+		return "a map"
+	case []interface{}, []map[string]interface{}:
+		return "a list"
+	case time.Time:
+		return "a date"
+	}
+
+	return fmt.Sprintf("%T", value)
+}
+
+// ConfigSchema returns the JSON Schema of configuration files, built from the
+// configuration types: a service, a list of them or a "services" list with
+// "defaults". Keys are written as in the documentation, the loader matches them
+// ignoring case
+func ConfigSchema() []byte {
+	definitions := make(map[string]interface{})
+	service := schemaRef(reflect.TypeOf(ServiceConfig{}), definitions)
+	definitions["serviceConfig"].(map[string]interface{})["required"] = []string{"name"}
+	services := map[string]interface{}{"type": "array", "items": service}
+
+	schema := map[string]interface{}{
+		"$schema":     "http://json-schema.org/draft-07/schema#",
+		"$id":         CONFIG_SCHEMA_ID,
+		"title":       "systemgo configuration",
+		"definitions": definitions,
+		"oneOf": []interface{}{
+			service,
+			services,
+			map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"services": services,
+					"defaults": schemaRef(reflect.TypeOf(ManagerDefaults{}), definitions),
+				},
+				"additionalProperties": false,
+			},
+		},
+	}
+
+	data, _ := json.MarshalIndent(schema, "", "  ")
+
+	return append(data, '\n')
+}
+
+// schemaRef returns the schema of a type, structs are added to the definitions
+// and referred to
+func schemaRef(t reflect.Type, definitions map[string]interface{}) map[string]interface{} {
+	if t == durationType {
+		return map[string]interface{}{
+			"anyOf": []interface{}{
+				map[string]interface{}{"type": "string", "pattern": `^[-+]?(0|(([0-9]+(\.[0-9]*)?|\.[0-9]+)(ns|us|µs|μs|ms|s|m|h))+)$`},
+				map[string]interface{}{"type": "number"},
+			},
+			"description": `a duration like "1m30s" or a number of seconds`,
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaRef(t.Elem(), definitions)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaRef(t.Elem(), definitions)}
+	case reflect.Struct:
+		name := schemaKey(t.Name())
+		if _, ok := definitions[name]; !ok {
+			properties := make(map[string]interface{})
+			definition := map[string]interface{}{"type": "object", "properties": properties, "additionalProperties": false}
+			definitions[name] = definition
+
+			for i := 0; i < t.NumField(); i++ {
+				field := t.Field(i)
+				if field.PkgPath == "" && !field.Anonymous {
+					properties[schemaKey(field.Name)] = schemaRef(field.Type, definitions)
+				}
+			}
+		}
+
+		return map[string]interface{}{"$ref": "#/definitions/" + name}
+	}
+
+	return map[string]interface{}{}
+}
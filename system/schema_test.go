@@ -0,0 +1,171 @@
+package system
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of testdata")
+
+func TestConfigSchemaGolden(t *testing.T) {
+	golden := filepath.Join("testdata", "config_schema.json")
+	schema := ConfigSchema()
+
+	if *update {
+		if err := ioutil.WriteFile(golden, schema, 0644); err != nil {
+			t.Fatalf("write %s: %s", golden, err)
+		}
+	}
+
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("read %s: %s", golden, err)
+	}
+	if !bytes.Equal(schema, want) {
+		t.Errorf("the schema differs from %s, rerun with -update if the settings changed on purpose", golden)
+	}
+
+	var document map[string]interface{}
+	if err := json.Unmarshal(schema, &document); err != nil {
+		t.Fatalf("the schema is no JSON: %s", err)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		format string
+		data   string
+		want   string
+	}{
+		{
+			"invalid duration", "yaml",
+			"services:\n  - name: web\n    exec: /bin/web\n    stopTimeout: 5sec\n",
+			`4:5: services[0].web.stopTimeout: invalid duration "5sec"`,
+		},
+		{
+			"misspelled key", "yaml",
+			"services:\n  - name: web\n    exec: /bin/web\n  - name: worker\n    restartDelya: 1s\n",
+			`5:5: services[1].worker.restartDelya: unknown key, did you mean "restartDelay"?`,
+		},
+		{
+			"unknown key", "yaml",
+			"- name: a\n  bogus: 1\n",
+			`2:3: [0].a.bogus: unknown key`,
+		},
+		{
+			"scalar for a list", "yaml",
+			"name: web\nparams: -a\n",
+			`2:1: web.params: expected a list, got "-a"`,
+		},
+		{
+			"map for a list", "yaml",
+			"name: web\nenv: {A: 1}\n",
+			`2:1: web.env: expected a list, got a map`,
+		},
+		{
+			"list for a map", "yaml",
+			"name: web\nlabels: [a]\n",
+			`2:1: web.labels: expected a map, got a list`,
+		},
+		{
+			"string for an integer", "yaml",
+			"name: web\nreplicas: two\n",
+			`2:1: web.replicas: expected an integer, got "two"`,
+		},
+		{
+			"string for a bool", "yaml",
+			"name: web\ndisabled: yes please\n",
+			`2:1: web.disabled: expected true or false, got "yes please"`,
+		},
+		{
+			"nested duration", "yaml",
+			"name: web\nliveness:\n  interval: 5sec\n",
+			`3:3: web.liveness.interval: invalid duration "5sec"`,
+		},
+		{
+			"every problem of a probe", "yaml",
+			"name: web\nliveness: {tcp: 1, timout: 2s}\n",
+			"2:12: web.liveness.tcp: expected a string, got number 1\n" +
+				`2:20: web.liveness.timout: unknown key, did you mean "timeout"?`,
+		},
+		{
+			"every problem of every service", "yaml",
+			"- name: a\n  bogus: 1\n- name: b\n  stopTimeout: x\n",
+			"2:3: [0].a.bogus: unknown key\n" +
+				`4:3: [1].b.stopTimeout: invalid duration "x"`,
+		},
+		{
+			"defaults", "yaml",
+			"defaults:\n  stopTimeout: soon\nservices:\n  - name: web\n",
+			`2:3: defaults.stopTimeout: invalid duration "soon"`,
+		},
+		{
+			"yaml syntax", "yaml",
+			"name: web\n  exec: bad indent\n",
+			`2: mapping values are not allowed in this context`,
+		},
+		{
+			"bool for a duration", "json",
+			`{"name": "web", "stopTimeout": true}`,
+			`1:17: web.stopTimeout: expected a duration, got true`,
+		},
+		{
+			"number for a string", "json",
+			"{\n  \"name\": \"web\",\n  \"exec\": 5\n}",
+			`3:3: web.exec: expected a string, got number 5`,
+		},
+		{
+			"json syntax", "json",
+			`{"name": "web",}`,
+			`1:17: invalid character '}' looking for beginning of object key string`,
+		},
+		{
+			"toml without positions", "toml",
+			"name = \"web\"\nstopTimeout = \"5sec\"\n",
+			`web.stopTimeout: invalid duration "5sec"`,
+		},
+	} {
+		_, err := ParseConfig(test.format, []byte(test.data))
+
+		var errs ConfigErrors
+		if !errors.As(err, &errs) {
+			t.Errorf("%s: %v, want ConfigErrors", test.name, err)
+			continue
+		}
+		if err.Error() != test.want {
+			t.Errorf("%s:\n%s\nwant\n%s", test.name, err, test.want)
+		}
+	}
+}
+
+func TestConfigErrorsOfEveryFile(t *testing.T) {
+	dir := writeConfigDir(t, map[string]string{
+		"a.yaml": "name: web\nstopTimeout: 5sec\n",
+		"b.json": `{"name": "worker", "restartDelya": "1s"}`,
+	})
+
+	_, err := LoadConfigDir(dir)
+
+	var errs ConfigErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("load: %v, want a problem of each file", err)
+	}
+	for i, file := range []string{"a.yaml", "b.json"} {
+		if filepath.Base(errs[i].File) != file {
+			t.Errorf("problem %d in %s, want %s", i, errs[i].File, file)
+		}
+	}
+	if !strings.HasPrefix(errs[0].Error(), filepath.Join(dir, "a.yaml")+":2:1: web.stopTimeout") {
+		t.Errorf("%q, want the file, line and column of web.stopTimeout", errs[0])
+	}
+	if errs[1].Service != "worker" || errs[1].Path != "worker.restartDelya" {
+		t.Errorf("problem of %q at %q, want worker.restartDelya", errs[1].Service, errs[1].Path)
+	}
+}
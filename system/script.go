@@ -0,0 +1,116 @@
+package system
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+var elfMagic = []byte("\x7fELF")
+
+// command returns the program and its arguments, composed of Interpreter and
+// Script when they are set
+func (s *Service) command() (string, []string) {
+	script := s.Script
+	if script == "" {
+		script = s.Exec
+	}
+
+	if s.Interpreter != "" {
+		return s.Interpreter, append([]string{script}, s.Params...)
+	}
+
+	return script, s.Params
+}
+
+// ValidateInterpreter fails if the interpreter is set and is not found
+func ValidateInterpreter(config ServiceConfig) error {
+	if config.Interpreter == "" {
+		return nil
+	}
+
+	if _, err := exec.LookPath(config.Interpreter); err != nil {
+		return fmt.Errorf("interpreter %s not found: %w", config.Interpreter, err)
+	}
+
+	return nil
+}
+
+// checkInterpreter fails if the interpreter went missing since the validation
+func (s *Service) checkInterpreter() error {
+	return ValidateInterpreter(s.ServiceConfig)
+}
+
+// explainStart replaces errors of exec with the reason found in the program
+// file, scripts without a #! line or without the exec bit mostly
+func (s *Service) explainStart(err error) error {
+	// activated services are started through the shell, its errors are not about
+	// the program, the command of a factory is not found by its Exec
+	if s.passesSockets() || s.cmdFactory != nil {
+		return err
+	}
+
+	target, _ := s.command()
+
+	path, lookErr := exec.LookPath(target)
+	if lookErr != nil {
+		path = target
+	}
+
+	info, statErr := os.Stat(path)
+	if statErr != nil {
+		return err
+	}
+
+	head := make([]byte, 128)
+	if f, openErr := os.Open(path); openErr == nil {
+		n, _ := f.Read(head)
+		head = head[:n]
+		f.Close()
+	} else {
+		head = nil
+	}
+
+	isExecutable := info.Mode()&0111 != 0
+	interpreter, hasShebang := shebang(head)
+
+	switch {
+	case info.IsDir():
+		return fmt.Errorf("%s is a directory: %w", path, err)
+	case errors.Is(err, syscall.ENOEXEC) && !hasShebang && !bytes.HasPrefix(head, elfMagic):
+		return fmt.Errorf("%s is not a binary and has no #! line, add one or set Interpreter: %w", path, err)
+	case errors.Is(err, syscall.ENOEXEC):
+		return fmt.Errorf("%s is not a binary for this system: %w", path, err)
+	case !isExecutable && hasShebang:
+		return fmt.Errorf("%s is a script for %s without the exec bit, chmod +x it or set Interpreter: %w", path, interpreter, err)
+	case !isExecutable:
+		return fmt.Errorf("%s is not executable: %w", path, err)
+	case errors.Is(err, syscall.ENOENT) && hasShebang:
+		return fmt.Errorf("interpreter %s of the #! line of %s not found: %w", interpreter, path, err)
+	}
+
+	return err
+}
+
+// shebang returns the interpreter of a #! line
+func shebang(head []byte) (string, bool) {
+	if !bytes.HasPrefix(head, []byte("#!")) {
+		return "", false
+	}
+
+	line := string(head[2:])
+	if end := strings.IndexByte(line, '\n'); end >= 0 {
+		line = line[:end]
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", true
+	}
+
+	return fields[0], true
+}
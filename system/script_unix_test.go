@@ -0,0 +1,145 @@
+//go:build unix
+
+package system
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// script writes content to a file of the test with the mode
+func script(t *testing.T, name, content string, mode os.FileMode) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), mode); err != nil {
+		t.Fatalf("script: %s", err)
+	}
+
+	return path
+}
+
+func TestCommandOfScript(t *testing.T) {
+	cases := []struct {
+		config  ServiceConfig
+		program string
+		args    []string
+	}{
+		{ServiceConfig{Exec: "/bin/app", Params: []string{"-v"}}, "/bin/app", []string{"-v"}},
+		{ServiceConfig{Interpreter: "python3", Script: "app.py", Params: []string{"-v"}}, "python3", []string{"app.py", "-v"}},
+		{ServiceConfig{Interpreter: "python3", Exec: "app.py"}, "python3", []string{"app.py"}},
+		{ServiceConfig{Script: "./app.sh", Exec: "/bin/app", Params: []string{"-v"}}, "./app.sh", []string{"-v"}},
+	}
+
+	for _, c := range cases {
+		s := &Service{ServiceConfig: c.config}
+		program, args := s.command()
+		if program != c.program || !reflect.DeepEqual(args, c.args) {
+			t.Errorf("command of %+v is %s %q, want %s %q", c.config, program, args, c.program, c.args)
+		}
+	}
+}
+
+func TestMissingInterpreterFailsValidation(t *testing.T) {
+	config := ServiceConfig{Name: "script", Interpreter: "no-such-interpreter", Script: "app.py"}
+
+	err := validateConfig(config)
+	if err == nil || !strings.Contains(err.Error(), "interpreter no-such-interpreter not found") {
+		t.Fatalf("validation: %v, want the interpreter not found", err)
+	}
+	if !errors.Is(err, exec.ErrNotFound) {
+		t.Fatalf("validation: %v, want exec.ErrNotFound", err)
+	}
+
+	config.Interpreter = "sh"
+	if err := validateConfig(config); err != nil {
+		t.Fatalf("validation with sh: %s", err)
+	}
+}
+
+func TestStartOfBrokenScript(t *testing.T) {
+	const BODY = "echo started\n"
+
+	cases := []struct {
+		name    string
+		exec    func(t *testing.T) string
+		message string
+	}{
+		{"no shebang", func(t *testing.T) string {
+			return script(t, "plain.sh", BODY, 0755)
+		}, "is not a binary and has no #! line"},
+		{"no exec bit", func(t *testing.T) string {
+			return script(t, "script.sh", "#!/bin/sh\n"+BODY, 0644)
+		}, "is a script for /bin/sh without the exec bit"},
+		{"no exec bit nor shebang", func(t *testing.T) string {
+			return script(t, "data.sh", BODY, 0644)
+		}, "is not executable"},
+		{"missing shebang interpreter", func(t *testing.T) string {
+			return script(t, "script.sh", "#!/no/such/interpreter\n"+BODY, 0755)
+		}, "interpreter /no/such/interpreter of the #! line of"},
+		{"directory", func(t *testing.T) string {
+			return t.TempDir()
+		}, "is a directory"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := c.exec(t)
+			service := NewService(ServiceConfig{
+				Name:         "broken",
+				Exec:         path,
+				StartRetries: -1,
+			})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer service.Wait()
+			defer cancel()
+
+			err := service.Start(ctx, nil, nil)
+			if err == nil || !strings.Contains(err.Error(), c.message) || !strings.Contains(err.Error(), path) {
+				t.Fatalf("start: %v, want %q about %s", err, c.message, path)
+			}
+			if status := service.Status(); status.PID != 0 {
+				t.Fatalf("pid %d of a broken script", status.PID)
+			}
+		})
+	}
+}
+
+func TestScriptRunByInterpreter(t *testing.T) {
+	// the script that fails on its own, without a #! line nor the exec bit
+	path := script(t, "plain.sh", "echo \"$1 by sh\"\n", 0644)
+
+	out := make(chan string, 10)
+	service := NewService(ServiceConfig{
+		Name:        "interpreted",
+		Interpreter: "sh",
+		Script:      path,
+		Params:      []string{"run"},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer service.Wait()
+	defer cancel()
+
+	if err := service.Start(ctx, out, nil); err != nil {
+		t.Fatalf("start: %s", err)
+	}
+
+	select {
+	case line := <-out:
+		if !strings.HasSuffix(line, "] run by sh") {
+			t.Fatalf("line %q, want the argument echoed by sh", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no output of the script")
+	}
+}
@@ -0,0 +1,210 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SECRET_SCHEME starts the env values resolved by a SecretProvider at every
+// start, "DB_PASSWORD=secret://file/db-password"
+const SECRET_SCHEME = "secret://"
+
+// SECRET_REDACTED replaces resolved secrets in captured output
+const SECRET_REDACTED = "*****"
+
+// SECRET_PROVIDER_FILE is the provider of FileSecrets, reading SECRET_DIR unless
+// registered again with a directory of its own
+const (
+	SECRET_PROVIDER_FILE = "file"
+	SECRET_DIR           = "/run/secrets"
+)
+
+var (
+	ErrInvalidSecret   = errors.New("invalid secret reference")
+	ErrUnknownProvider = errors.New("unknown secret provider")
+)
+
+// SecretProvider resolves the key of "secret://<provider>/<key>" to its value,
+// like a Vault client. Resolve is called at every start of a service, a rotated
+// secret is picked up by the next one, within the start timeout of the service
+type SecretProvider interface {
+	Resolve(ctx context.Context, key string) (string, error)
+}
+
+var secretProviders = struct {
+	mu        sync.RWMutex
+	providers map[string]SecretProvider
+}{providers: map[string]SecretProvider{SECRET_PROVIDER_FILE: FileSecrets{Dir: SECRET_DIR}}}
+
+// RegisterSecretProvider makes the provider resolve "secret://<name>/..." values,
+// replacing the one of the name, nil removes it
+func RegisterSecretProvider(name string, provider SecretProvider) {
+	secretProviders.mu.Lock()
+	defer secretProviders.mu.Unlock()
+
+	if provider == nil {
+		delete(secretProviders.providers, name)
+		return
+	}
+
+	secretProviders.providers[name] = provider
+}
+
+func secretProvider(name string) (SecretProvider, error) {
+	secretProviders.mu.RLock()
+	defer secretProviders.mu.RUnlock()
+
+	provider, ok := secretProviders.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+	}
+
+	return provider, nil
+}
+
+// FileSecrets reads the secret of a key from the file of its name in Dir, like
+// /run/secrets/db-password. The file must not be readable by group or others, a
+// trailing newline is dropped
+type FileSecrets struct {
+	Dir string
+}
+
+func (f FileSecrets) Resolve(ctx context.Context, key string) (string, error) {
+	path := filepath.Join(f.Dir, filepath.FromSlash(key))
+	if rel, err := filepath.Rel(f.Dir, path); err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("%w: %s is not in %s", ErrInvalidSecret, key, f.Dir)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	if info.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("%s is accessible by group or others (%04o), 0600 is required", path, info.Mode().Perm())
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r"), nil
+}
+
+// parseSecret splits a "secret://<provider>/<key>" value, ok is false for other values
+func parseSecret(value string) (provider, key string, ok bool, err error) {
+	if !strings.HasPrefix(value, SECRET_SCHEME) {
+		return "", "", false, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(value, SECRET_SCHEME), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", true, fmt.Errorf("%w: %s, secret://<provider>/<key> is expected", ErrInvalidSecret, value)
+	}
+
+	return parts[0], parts[1], true, nil
+}
+
+// ValidateSecrets checks the secret references of the env variables, the
+// providers are looked up at start as they may be registered later
+func ValidateSecrets(env []string) error {
+	for _, variable := range env {
+		parts := strings.SplitN(variable, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		if _, _, _, err := parseSecret(parts[1]); err != nil {
+			return fmt.Errorf("env %s: %w", parts[0], err)
+		}
+	}
+
+	return nil
+}
+
+// resolveSecrets returns the env with its secret references replaced by their
+// values, and the values to redact. An error names the variable, never a value
+func (s *Service) resolveSecrets(env []string) ([]string, []string, error) {
+	var resolved, secrets []string
+	for _, variable := range env {
+		parts := strings.SplitN(variable, "=", 2)
+		if len(parts) != 2 {
+			resolved = append(resolved, variable)
+			continue
+		}
+
+		name, key, ok, err := parseSecret(parts[1])
+		if !ok {
+			resolved = append(resolved, variable)
+			continue
+		}
+
+		var value string
+		if err == nil {
+			value, err = s.resolveSecret(name, key)
+		}
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("env %s: failed to resolve %s: %w", parts[0], parts[1], err)
+		}
+
+		resolved = append(resolved, parts[0]+"="+value)
+		secrets = append(secrets, secretLines(value)...)
+	}
+
+	// a secret containing another is replaced first
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+
+	return resolved, secrets, nil
+}
+
+// resolveSecret asks the provider for the key within the start timeout
+func (s *Service) resolveSecret(name, key string) (string, error) {
+	provider, err := secretProvider(name)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.GetStartTimeout())
+	defer cancel()
+
+	return provider.Resolve(ctx, key)
+}
+
+// secretLines returns the secret to redact, and each of its lines as the output
+// is redacted by line, like the lines of a pem key
+func secretLines(value string) []string {
+	if value == "" {
+		return nil
+	}
+
+	lines := []string{value}
+	if strings.Contains(value, "\n") {
+		for _, line := range strings.Split(value, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				lines = append(lines, line)
+			}
+		}
+	}
+
+	return lines
+}
+
+// redactSecrets replaces the secrets in the line by SECRET_REDACTED
+func redactSecrets(line string, secrets []string) string {
+	for _, secret := range secrets {
+		if strings.Contains(line, secret) {
+			line = strings.ReplaceAll(line, secret, SECRET_REDACTED)
+		}
+	}
+
+	return line
+}
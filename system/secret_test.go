@@ -0,0 +1,264 @@
+package system
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// secretDir registers a file provider of the name on a directory with the
+// secret db until the test ends
+func secretDir(t *testing.T, name, value string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "db"), []byte(value+"\n"), 0600); err != nil {
+		t.Fatalf("secret: %s", err)
+	}
+
+	RegisterSecretProvider(name, FileSecrets{Dir: dir})
+	t.Cleanup(func() { RegisterSecretProvider(name, nil) })
+}
+
+// rotating is a provider returning the next value at every resolution
+type rotating struct {
+	mu     sync.Mutex
+	values []string
+	calls  int
+}
+
+func (r *rotating) Resolve(ctx context.Context, key string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.calls >= len(r.values) {
+		return "", errors.New("no more values")
+	}
+	r.calls += 1
+
+	return r.values[r.calls-1], nil
+}
+
+// crash kills the process of the service
+func crash(t *testing.T, service *Service) {
+	t.Helper()
+
+	process, err := os.FindProcess(service.Status().PID)
+	if err == nil {
+		err = process.Kill()
+	}
+	if err != nil {
+		t.Fatalf("kill: %s", err)
+	}
+}
+
+func TestValidateSecrets(t *testing.T) {
+	if err := ValidateSecrets([]string{"PLAIN=secret", "DB=secret://file/db", "TOKEN=secret://vault/api/token", "EMPTY"}); err != nil {
+		t.Fatalf("valid references: %s", err)
+	}
+
+	for _, value := range []string{"secret://", "secret://file", "secret://file/", "secret:///db"} {
+		if err := ValidateSecrets([]string{"DB=" + value}); !errors.Is(err, ErrInvalidSecret) || !strings.HasPrefix(err.Error(), "env DB: ") {
+			t.Errorf("%s: %v, want ErrInvalidSecret of env DB", value, err)
+		}
+	}
+}
+
+func TestFileSecrets(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string, mode os.FileMode) {
+		path := filepath.Join(dir, name)
+		// the umask does not widen the mode of a chmod
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil || os.Chmod(path, mode) != nil {
+			t.Fatalf("secret %s: %v", name, err)
+		}
+	}
+	write("db", "s3cr3t\r\n", 0600)
+	write("shared", "s3cr3t", 0640)
+
+	secrets := FileSecrets{Dir: dir}
+	if value, err := secrets.Resolve(context.Background(), "db"); err != nil || value != "s3cr3t" {
+		t.Fatalf("db: %q, %v, want the value without its line end", value, err)
+	}
+
+	if _, err := secrets.Resolve(context.Background(), "shared"); err == nil || !strings.Contains(err.Error(), "0600 is required") {
+		t.Fatalf("file readable by the group: %v, want it refused", err)
+	}
+	if _, err := secrets.Resolve(context.Background(), "../db"); !errors.Is(err, ErrInvalidSecret) {
+		t.Fatalf("key out of the dir: %v, want ErrInvalidSecret", err)
+	}
+	if _, err := secrets.Resolve(context.Background(), "missing"); err == nil {
+		t.Fatal("missing secret resolved")
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	key := "-----BEGIN KEY-----\nMIIEvQIBADANBgkq\n-----END KEY-----"
+	RegisterSecretProvider("rotating", &rotating{values: []string{"pass", key, "password"}})
+	defer RegisterSecretProvider("rotating", nil)
+
+	env, secrets, err := (&Service{}).resolveSecrets([]string{"SHORT=secret://rotating/a", "KEY=secret://rotating/b", "LONG=secret://rotating/c", "PLAIN=pass"})
+	if err != nil {
+		t.Fatalf("resolve: %s", err)
+	}
+	if want := []string{"SHORT=pass", "KEY=" + key, "LONG=password", "PLAIN=pass"}; strings.Join(env, "|") != strings.Join(want, "|") {
+		t.Fatalf("env %q, want %q", env, want)
+	}
+
+	// a secret containing another is replaced whole, a key line by line
+	for line, want := range map[string]string{
+		"login with password":   "login with " + SECRET_REDACTED,
+		"pass and password":     SECRET_REDACTED + " and " + SECRET_REDACTED,
+		"key MIIEvQIBADANBgkq":  "key " + SECRET_REDACTED,
+		"nothing secret here":   "nothing secret here",
+		"-----END KEY----- end": SECRET_REDACTED + " end",
+	} {
+		if got := redactSecrets(line, secrets); got != want {
+			t.Errorf("%q redacted %q, want %q", line, got, want)
+		}
+	}
+}
+
+func TestSecretsRedactedEverywhere(t *testing.T) {
+	const secret = "hunter2-0f9a"
+	secretDir(t, "test", secret)
+
+	seen := filepath.Join(t.TempDir(), "seen")
+	config := ServiceConfig{
+		Name:          "api",
+		Exec:          "/bin/sh",
+		Params:        []string{"-c", `printf %s "$DB_PASSWORD" > "$0"; echo "connecting with $DB_PASSWORD"; echo "denied for $DB_PASSWORD" >&2; exit 3`, seen},
+		Env:           []string{"DB_PASSWORD=secret://test/db"},
+		RestartPolicy: RESTART_NEVER,
+	}
+	m, _ := runManager(t, config, sleeper("web"))
+	var audit bytes.Buffer
+	m.SetAuditLog(&audit)
+	events := m.Subscribe()
+	defer m.Unsubscribe(events)
+
+	eventually(t, 5*time.Second, "api failed", func() bool { return status(t, m, "api").State == StateFailed })
+	if data, _ := ioutil.ReadFile(seen); string(data) != secret {
+		t.Fatalf("process saw %q, want the secret resolved", data)
+	}
+
+	// started again through the audited api, once more printing its secret
+	if err := m.Audit(Requester{}, "start", "api", func() error { return m.Start("api") }); err != nil {
+		t.Fatalf("start: %s", err)
+	}
+	eventually(t, 5*time.Second, "api ran twice", func() bool {
+		status := status(t, m, "api")
+		return status.Runs == 2 && status.State == StateFailed
+	})
+	time.Sleep(50 * time.Millisecond)
+
+	output, _ := m.GetRecentOutput("api", 0)
+	var printed []string
+	for _, line := range output {
+		printed = append(printed, line.Text)
+	}
+	if !strings.Contains(strings.Join(printed, "\n"), "connecting with "+SECRET_REDACTED) {
+		t.Fatalf("output %q, want the secret redacted", printed)
+	}
+
+	service, _ := m.GetService("api")
+	history := service.History()
+	if len(history) == 0 || !strings.Contains(history[len(history)-1].Stderr, "denied for "+SECRET_REDACTED) {
+		t.Fatalf("history %+v, want the stderr tail redacted", history)
+	}
+
+	var published []Event
+	for len(events) > 0 {
+		published = append(published, <-events)
+	}
+	status, _ := m.GetStatus("api")
+	effective, _ := m.GetConfig("api")
+	journal, _ := m.GetJournal("api", 0)
+
+	for surface, value := range map[string]interface{}{
+		"output":  output,
+		"history": history,
+		"events":  published,
+		"status":  status,
+		"config":  effective,
+		"journal": journal,
+	} {
+		data, err := json.Marshal(value)
+		if err != nil {
+			t.Fatalf("%s: %s", surface, err)
+		}
+		if strings.Contains(string(data), secret) {
+			t.Errorf("%s shows the secret: %s", surface, data)
+		}
+	}
+	if strings.Contains(audit.String(), secret) || !strings.Contains(audit.String(), `"operation":"start"`) {
+		t.Errorf("audit log %s, want the start without the secret", audit.String())
+	}
+
+	// the config shows the reference, not the value
+	if data, _ := json.Marshal(effective); !strings.Contains(string(data), "secret://test/db") {
+		t.Errorf("config %s, want the secret reference", data)
+	}
+}
+
+func TestSecretResolvedAtEveryStart(t *testing.T) {
+	provider := &rotating{values: []string{"first", "second"}}
+	RegisterSecretProvider("rotating", provider)
+	defer RegisterSecretProvider("rotating", nil)
+
+	seen := filepath.Join(t.TempDir(), "seen")
+	service := NewService(ServiceConfig{
+		Name:          "rotated",
+		Exec:          "/bin/sh",
+		Params:        []string{"-c", `printf %s "$TOKEN" > "$0"; exec sleep 30`, seen},
+		Env:           []string{"TOKEN=secret://rotating/api/token"},
+		RestartPolicy: RESTART_ALWAYS,
+		RestartDelay:  time.Second,
+		StopTimeout:   time.Second,
+	})
+	clock := onFakeClock(service)
+	if err := <-startAsync(t, service); err != nil {
+		t.Fatalf("start: %s", err)
+	}
+	eventually(t, 5*time.Second, "the first token", func() bool {
+		data, _ := ioutil.ReadFile(seen)
+		return string(data) == "first"
+	})
+
+	// the restart after a crash resolves the rotated token
+	crash(t, service)
+	eventually(t, 5*time.Second, "the restart planned", func() bool { return service.Status().State == StateRestarting })
+	advanceUntil(t, clock, time.Second, "the second token", func() bool {
+		data, _ := ioutil.ReadFile(seen)
+		return string(data) == "second"
+	})
+
+	// a secret that can not be resolved fails the start, naming the variable
+	eventually(t, 5*time.Second, "the restart running", func() bool { return service.Status().State == StateRunning })
+	crash(t, service)
+	eventually(t, 5*time.Second, "the restart planned", func() bool { return service.Status().State == StateRestarting })
+	advanceUntil(t, clock, time.Second, "the start failed", func() bool {
+		history := service.History()
+		return len(history) >= 2 && strings.Contains(history[len(history)-1].Error, "env TOKEN: failed to resolve secret://rotating/api/token: no more values")
+	})
+}
+
+func TestUnknownSecretProviderFailsTheStart(t *testing.T) {
+	service := NewService(ServiceConfig{Name: "vault", Exec: "sleep", Params: []string{"30"}, Env: []string{"TOKEN=secret://vault/api/token"}, RestartDelay: time.Hour})
+	onFakeClock(service)
+
+	if err := <-startAsync(t, service); !errors.Is(err, ErrUnknownProvider) || !strings.Contains(err.Error(), "env TOKEN") {
+		t.Fatalf("start: %v, want ErrUnknownProvider of env TOKEN", err)
+	}
+	if status := service.Status(); status.PID != 0 || status.State == StateRunning {
+		t.Fatalf("status %+v, want the start failed", status)
+	}
+}
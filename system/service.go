@@ -1,158 +1,2101 @@
 package system
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"sync"
+	"syscall"
 	"time"
 )
 
-type Service struct {
-	Name    string
-	Exec    string
-	Params  []string
+var (
+	ErrNotRunning        = errors.New("service is not supervised")
+	ErrAlreadyRunning    = errors.New("service is already running")
+	ErrAlreadyStarted    = errors.New("service is supervised already")
+	ErrInvalidStopSignal = errors.New("invalid stopSignal")
+)
+
+type command int
+
+const (
+	commandStart command = iota
+	commandStop
+	commandRestart
+	// commandTriggerRestart and commandUnready are sent by output triggers
+	commandTriggerRestart
+	commandUnready
+	// commandPromote is sent to a standby taking over from the failed primary
+	commandPromote
+	commandFreeze
+	commandThaw
+	// commandUpdate applies a configuration changing live fields only, see Apply
+	commandUpdate
+)
+
+// MEMORY_LOG_INTERVAL is the interval the memory of a running process is logged at
+const MEMORY_LOG_INTERVAL = 10 * time.Second
+
+type request struct {
+	command command
+	result  chan error
+	// stopTimeout replaces StopTimeout for a commandStop if set
+	stopTimeout time.Duration
+	// config is the configuration of a commandUpdate
+	config *ServiceConfig
+}
+
+type ServiceStatus struct {
+	Name         string    `json:"name"`
+	State        State     `json:"state"`
+	PID          int       `json:"pid,omitempty"`
+	StartedAt    time.Time `json:"startedAt,omitempty"`
+	Runs         int       `json:"runs"`
+	Incarnation  int       `json:"incarnation"`
+	LastExitCode int       `json:"lastExitCode"`
+	MemoryKB     uint64    `json:"memoryKb"`
+	// MemoryMetric MemoryKB is read in, "rss", "pss" or "uss"
+	MemoryMetric string `json:"memoryMetric,omitempty"`
+	Template     string `json:"template,omitempty"`
+	ReplicaOf    string `json:"replicaOf,omitempty"`
+	Replica      int    `json:"replica"`
+
+	LastStopReason StopReason `json:"lastStopReason"`
+	ForcedKills    int        `json:"forcedKills"`
+
+	// OutputBuffered is the bytes of output lines not yet printed or followed
+	OutputBuffered int   `json:"outputBuffered"`
+	OutputDropped  int64 `json:"outputDropped"`
+
+	// OutputLevels counts the captured lines per level, see OutputSeverity
+	OutputLevels map[string]int64 `json:"outputLevels,omitempty"`
+
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// LastCoreDumped tells the last run dumped core, LastCorePath is where it was found
+	LastCoreDumped bool   `json:"lastCoreDumped,omitempty"`
+	LastCorePath   string `json:"lastCorePath,omitempty"`
+
+	// StateDurations is the time spent in each state, see Service.StateDurations
+	StateDurations map[State]time.Duration `json:"stateDurations,omitempty"`
+
+	// StartLatency is the time from exec to Running, ReadyLatency to Ready
+	StartLatency LatencyStats `json:"startLatency"`
+	ReadyLatency LatencyStats `json:"readyLatency"`
+
+	// Role of a member of a failover pair, ROLE_ACTIVE or ROLE_PASSIVE
+	Role string `json:"role,omitempty"`
+
+	// ConfigHash is the Hash of the configuration the service runs with
+	ConfigHash string `json:"configHash"`
+
+	// Type is SERVICE_EXTERNAL for a dependency only probed, ProbeError is why its
+	// last probe, or the last health check of the process, failed
+	Type       string `json:"type,omitempty"`
+	ProbeError string `json:"probeError,omitempty"`
+
+	// RestartDeferred is a triggered restart waiting for a restart window
+	RestartDeferred bool `json:"restartDeferred,omitempty"`
+
+	// NextRun is when a scheduled service runs next
+	NextRun time.Time `json:"nextRun,omitempty"`
+}
+
+func NewService(config ServiceConfig) *Service {
+	service := new(Service)
+	service.ServiceConfig = config
+
+	return service
+}
+
+// ServiceConfig is the declarative part of a service, as read from the configuration
+type ServiceConfig struct {
+	Name         string
+	Exec         string
+	Params       []string
+	RestartDelay time.Duration
+
+	// Interpreter runs Script, or Exec if there is no Script, like "python3", it is
+	// looked up in PATH
+	Interpreter string
+
+	// Script is run by Interpreter, or on its own by its #! line, instead of Exec
+	Script string
+
+	// Env holds "KEY=value" variables added to the environment of the process
+	Env []string
+
+	// EnvFile is read at every start for "KEY=value" lines, Env overrides them
+	EnvFile string
+
+	// PassEnv are the names of the variables of the supervisor the process
+	// gets, patterns like "LC_*", all of them if not set. CleanEnv passes none
+	// but the ones of PassEnv
+	PassEnv  []string
+	CleanEnv bool
+
+	// User and Group the process runs as, names or ids, the supervisor must run
+	// as root to change them. Umask is the octal umask of the process, like "027"
+	User  string
+	Group string
+	Umask string
+
+	// LimitNOFILE and LimitNPROC set RLIMIT_NOFILE and RLIMIT_NPROC of the process,
+	// soft and hard, the limits of the supervisor are inherited if not set
+	LimitNOFILE uint64
+	LimitNPROC  uint64
+
+	// CPUQuota is the CPU time of the service in CPUs, 0.5 for half of one, and
+	// MemoryLimit its memory in bytes, enforced by a cgroup of the service below
+	// the cgroup root of the manager. MemoryLimitAction is "stop" (default) to
+	// stop the service for the restart policy above the limit, or "warn" to warn
+	// once per run. Without a cgroup root the memory is polled, the quota not kept
+	CPUQuota          float64
+	MemoryLimit       int64
+	MemoryLimitAction string
+
+	// ExecStartPre commands run in order before every start, a failing one fails
+	// the start. ExecStartPost commands run once the process is running, a failing
+	// one stops it with the hook-failed reason. ExecStopPost commands run after
+	// every run however it ended, a failed start as well, with SYSTEMGO_EXIT_CODE
+	// and SYSTEMGO_STOP_REASON. The supervision loop waits for them
+	ExecStartPre  []HookCommand
+	ExecStartPost []HookCommand
+	ExecStopPost  []HookCommand
+
+	// WorkingDir the process runs in, the one of the supervisor if not set,
+	// relative paths are below it
+	WorkingDir string
+
+	// Labels are reported with status, events and output lines, and exported to
+	// the process as SYSTEMGO_LABEL_<KEY>
+	Labels map[string]string
+
+	// Deprecated: Restart is the delay in seconds, use RestartDelay instead
 	Restart int64
 
-	running   *process
-	history   []*process
-	isStarted bool
-	isStopped bool
+	// RestartPolicy decides the exits restarting the service after RestartDelay,
+	// RESTART_DEFAULT_DELAY if not set: "always", "on-failure" for non-zero exit
+	// codes and involuntary stops, or "never". It is always with a restart delay
+	// and never without one if not set
+	RestartPolicy string
+
+	// RestartBackoff multiplies the delay after every failed run in a row, up to
+	// RestartMaxDelay, with up to RestartJitter (0 to 1) of it added at random
+	RestartBackoff  float64
+	RestartMaxDelay time.Duration
+	RestartJitter   float64
+
+	// StartLimitBurst restarts within StartLimitInterval are made at most, the
+	// service is then left failed until it is started again
+	StartLimitBurst    int
+	StartLimitInterval time.Duration
+
+	// MaxHistory limits kept process records, HISTORY_MAX_RECORDS if not set
+	MaxHistory int
+
+	// Instances of a template service (named "name@") to create
+	Instances []string
+
+	// Replicas of the service to run, each one gets its index in SYSTEMGO_REPLICA
+	Replicas int
+
+	// MinHealthy is the number of members of a replicated service or template that
+	// have to be up, the group is unhealthy below it and degraded above it with
+	// members down. A change counts once it held for HealthHysteresis
+	MinHealthy       int
+	HealthHysteresis time.Duration
+
+	// ReadyWhenListening is a tcp address (":5432") or a unix socket path, the
+	// service is Ready once the process accepts connections on it
+	ReadyWhenListening string
+
+	// StartTimeout limits the time to become ready, UNIT_START_TIMEOUT seconds if not set
+	StartTimeout time.Duration
+
+	// StartRetries is the number of times a start failing transiently, for the
+	// lack of processes, memory or file descriptors, is retried within StartTimeout
+	// before it counts as failed, START_RETRIES if not set and none if negative
+	StartRetries int
+
+	// SlowStartThreshold warns of starts taking longer to become ready, or to run
+	// without ReadyWhenListening
+	SlowStartThreshold time.Duration
+
+	// StopTimeout is the time given to exit after StopSignal before SIGKILL, UNIT_START_TIMEOUT seconds if not set
+	StopTimeout time.Duration
+
+	// StopSignal asks the process to exit, like "SIGINT" or "QUIT", SIGTERM if not set.
+	// It goes to the process group of the process, with the children it did not move out
+	StopSignal string
+
+	// Ports the service listens on, the start fails if any of them is in use already
+	Ports []string
+
+	// SkipPortCheck disables the port check, for programs using SO_REUSEPORT
+	SkipPortCheck bool
+
+	// StderrTailSize is the number of last stderr bytes kept per run, STDERR_TAIL_SIZE if not set
+	StderrTailSize int
+
+	// SuccessExitCodes are exit codes besides 0 a run completes with, like 143
+	// of a program exiting on SIGTERM. A FailurePatterns regexp matching a line
+	// of stderr fails the run even if it exits 0
+	SuccessExitCodes []int
+	FailurePatterns  []string
+
+	// RecentLines is the number of last captured lines kept for RecentOutput and
+	// the logs of the APIs, OUTPUT_RECENT_LINES if not set
+	RecentLines int
+
+	// Optional services do not count in the exit code and do not stop the others
+	// when they fail with FailFast
+	Optional bool
+
+	// DiscardOutput sends the process output to /dev/null, nothing is captured or followed
+	DiscardOutput bool
+
+	// CombineOutput writes stderr into the stdout pipe, lines keep the order they
+	// were written in and are reported as the "combined" stream
+	CombineOutput bool
+
+	// PipeTo names the service reading the stdout of this one on its stdin. The
+	// pipe buffers PipeBuffer bytes, PIPE_BUFFER if not set, and the consumer
+	// started again gets the last PipeReplay bytes, or only new output if not set
+	PipeTo     string
+	PipeBuffer int
+	PipeReplay int
+
+	// JournalSize limits kept journal entries, JOURNAL_MAX_ENTRIES if not set
+	JournalSize int
+
+	// LogForward sends the captured lines to a collector over the network
+	LogForward LogForward
+
+	// Activation "on-demand" listens on Ports and starts the process on the first
+	// connection, passing the sockets as LISTEN_FDS. "socket" starts it at once,
+	// connections made while it restarts wait for the next process
+	Activation string
+
+	// IdleTimeout stops an on-demand process after the time without connections, never if not set
+	IdleTimeout time.Duration
+
+	// Schedule runs the service as a one-shot at the minutes of a cron expression,
+	// "*/15 * * * *", a descriptor like "@daily" or every interval, "@every 10m",
+	// instead of restarting it. ScheduleOverlap is what a run due while the previous
+	// one runs does: "skip", the default, or "queue" to start once it exits
+	Schedule        string
+	ScheduleOverlap string
+
+	// SampleInterval between resource usage samples, SAMPLE_INTERVAL if not set
+	SampleInterval time.Duration
+
+	// SampleRetention is the time samples are kept for, SAMPLE_RETENTION if not set
+	SampleRetention time.Duration
+
+	// Sanitize cleans the lines of processes writing binary, Latin1 or terminal
+	// escape sequences
+	Sanitize OutputSanitize
+
+	// Sampling keeps 1 in N of the lines matching a pattern, for services writing
+	// lots of the same lines
+	Sampling OutputSampling
+
+	// Severity finds the level of the captured lines, like "error" for lines
+	// starting with "[ERROR]", and the lowest level printed
+	Severity OutputSeverity
+
+	// OutputPrefix of printed lines per stream ("stdout", "stderr"), templated with
+	// {service}, {stream} and {pid}, an empty prefix prints lines as they are
+	OutputPrefix map[string]string
+
+	// MemoryMetric of memory readings summed over the process tree: "pss"
+	// (default) or "uss" not counting pages shared with other processes in
+	// full, read from smaps_rollup, or "rss"
+	MemoryMetric string
+
+	// CoreDumps raises RLIMIT_CORE of the process to its hard limit, so it dumps
+	// core where /proc/sys/kernel/core_pattern says when it crashes
+	CoreDumps bool
+
+	// CoreDir the dumps of the service are moved to as "<name>.<run>.core", the
+	// last CoreKeep (CORE_KEEP if not set) of them are kept, within CoreMaxBytes
+	// in total if set, the last dump is kept anyway
+	CoreDir      string
+	CoreKeep     int
+	CoreMaxBytes int64
+
+	// RuntimeDir and StateDir are created below the runtime and state roots of the
+	// manager before every start and passed to the process as SYSTEMGO_RUNTIME_DIR
+	// and SYSTEMGO_STATE_DIR, relative paths like "web". WipeRuntimeDir removes
+	// RuntimeDir when the service is stopped
+	RuntimeDir     string
+	StateDir       string
+	WipeRuntimeDir bool
+
+	// Probe makes the service an external dependency without Exec, a database or
+	// a mount the supervisor does not run: it is Ready while the probe passes and
+	// NotReady while it fails, other services bind to it
+	Probe Probe
+
+	// Readiness of a service that is run makes it Ready once the probe passes,
+	// within StartTimeout, and NotReady while it fails afterwards. Liveness is
+	// probed once it is ready and stops it to be restarted when it fails
+	Readiness Probe
+	Liveness  Probe
+
+	// RestartWindows limit automatic restarts to maintenance windows like
+	// "Sat 02:00-04:00", "Mon-Fri 22:00-06:00" or "03:00-04:00" every day, read
+	// in the IANA RestartWindowZone, the local zone if not set. A restart wanted
+	// outside of them waits in PendingRestart for the next one, an operator start
+	// or restart runs it right away. Crashes and other involuntary stops restart
+	// as usual unless StrictWindow
+	RestartWindows    []string
+	RestartWindowZone string
+	StrictWindow      bool
+
+	// Disabled services are not started with the manager, only by Start
+	Disabled bool
+
+	// OutputTriggers act on captured lines matching their pattern, like a restart
+	// on "OutOfMemoryError"
+	OutputTriggers []OutputTrigger
+
+	// BindsTo names services or groups this one needs: it is stopped as soon as
+	// one of them stops or fails and started again once they are all up for
+	// BIND_WINDOW
+	BindsTo []string
+
+	// PartOf names services or groups an operator stop or restart is passed on
+	// from to this one
+	PartOf []string
+
+	// After names services or groups this one starts after and stops before,
+	// without needing them: it starts once they made their first start, whether
+	// they are up or not, and nothing of theirs is passed on to it
+	After []string
+
+	// StartOrder orders the services of a dependency level, lower ones start
+	// earlier and stop later, services of the same order by name
+	StartOrder int
+
+	// StandbyOf names the primary this service is a warm standby of: it runs
+	// passive, never Ready, until the primary fails and it is promoted with
+	// PromoteExec or PromoteSignal. DemoteOnRecovery hands back to the primary
+	// once it is ready again, otherwise the primary runs passive then
+	StandbyOf        string
+	PromoteExec      string
+	PromoteParams    []string
+	PromoteSignal    string
+	DemoteOnRecovery bool
+
+	// Explicit lists keys set on purpose even if zero, manager defaults do not
+	// apply to them and an explicit StopTimeout of 0 kills right after SIGTERM.
+	// Keys of configuration files are explicit
+	Explicit []string
+
+	// defaulted lists the keys taken from defaults
+	defaulted []string
+}
+
+type Service struct {
+	ServiceConfig
+
+	// group is the template or the replicated service this one belongs to
+	group   string
+	replica int
+	environ []string
+	cancel  context.CancelFunc
+
+	// mu guards fields read outside of the supervision loop
+	mu       sync.RWMutex
+	state    State
+	running  *process
+	history  []ProcessRecord
+	runs     int
+	requests chan request
+	loopDone chan struct{}
+
+	// firstStart is closed once the loop has made its first start, or has ended,
+	// startErr is the error the last start failed with
+	firstStart chan struct{}
+	startErr   error
+
+	// stopReason is set by the code path stopping the running process
+	stopReason  StopReason
+	forcedKills int
+
+	// incarnation is the number of the last run, it keeps growing across supervisor restarts
+	incarnation int
+
+	// roots of RuntimeDir and StateDir, RUNTIME_ROOT and STATE_ROOT if not set
+	runtimeRoot string
+	stateRoot   string
+
+	// stateFile is the file the state of the service is kept in, restored the
+	// state read from it at the start of the manager, to be adopted by the loop
+	stateFile string
+	restored  *serviceState
+
+	// stateLock is the lock file held while this supervisor supervises the
+	// processes of the state file, stateLockErr why it is not held
+	stateLock    *os.File
+	stateLockErr error
+
+	// consoleOut and consoleErr are the channels the loop prints the output to,
+	// the output of hooks goes there as well
+	consoleOut, consoleErr chan<- string
+
+	// cgroupRoot the cgroup of the service is created in, the limits are not
+	// enforced by a cgroup if not set
+	cgroupRoot string
+
+	// stateTime is guarded by mu
+	stateTime stateClock
+
+	// start latencies of the last runs, guarded by mu
+	startLatency latencyWindow
+	readyLatency latencyWindow
+
+	// shuttingDown is set once the loop stops the service for the supervisor, no
+	// process is started after that
+	shuttingDown bool
+
+	// frozen tells the process is stopped by Freeze in frozenFrom, probeOnThaw
+	// that its readiness probe failed meanwhile
+	frozen      bool
+	frozenFrom  State
+	probeOnThaw bool
+
+	// stopTimeout replaces StopTimeout for the stop in progress, set by sendStop
+	stopTimeout time.Duration
+
+	// shutdownTimeout limits the stop on supervisor shutdown, shutdownBy is the
+	// Monotonic reading it has to be done by, zero until the shutdown begins
+	shutdownTimeout time.Duration
+	shutdownBy      time.Duration
+
+	// stoppedAt is the Monotonic reading of the last stop, the restart delay
+	// counts from it, and lastExit is the record of the last run, guarded by mu.
+	// Both are kept apart from the history so trimming it never changes when the
+	// service restarts or what it reports of its last run. lastExitRestored
+	// tells lastExit was restored with the history, not ended in this supervisor
+	stoppedAt        time.Duration
+	lastExit         *ProcessRecord
+	lastExitRestored bool
+
+	// handingOff is set by the manager while it hands off to a new supervisor
+	handingOff *int32
+
+	// outputUsage is guarded by outputBudget
+	outputBudget *outputBudget
+	outputUsage  OutputUsage
+
+	// scheduler runs readiness probes and samples, shared by the services of a manager
+	scheduler *scheduler
+
+	// pipes of the manager, PipeTo of the services
+	pipes *pipes
+
+	// forwarder sends the captured lines to the collector of LogForward
+	forwarder *forwarder
+
+	// standbys of the manager, standbyReady tells a passive process passed its
+	// readiness probe, guarded by mu
+	standbys     *standbys
+	standbyReady bool
+
+	journal    journal
+	samples    samples
+	memory     memoryReading
+	activation *activation
+	store      *historyStore
+	output     outputFollowers
+	notify     func(Event)
+	readiness  chan error
+
+	// triggers are compiled from OutputTriggers once
+	triggers     []*trigger
+	triggersOnce sync.Once
+
+	// failureMatchers are compiled from FailurePatterns once
+	failureMatchers []*regexp.Regexp
+	failureOnce     sync.Once
+
+	// probeErr is the error of the last probe of an external dependency or of
+	// a health check, guarded by mu
+	probeErr string
+
+	// probeFailures, readinessFailures and livenessFailures count the failures
+	// of the probes in a row, health gets the results of the health checks of
+	// healthWatched, all of them are used by the loop only
+	probeFailures     int
+	readinessFailures int
+	livenessFailures  int
+	health            chan healthResult
+	healthWatched     *process
+
+	// cmdFactory builds the command of every run instead of Exec
+	cmdFactory CmdFactory
+
+	// windows are compiled from RestartWindows once, restartDeferred is a
+	// triggered restart waiting for one of them, written holding mu
+	windows         restartWindows
+	windowsOnce     sync.Once
+	restartDeferred bool
+
+	// severity is compiled from Severity once, lineLevels counts the lines sent
+	severity     []severityRule
+	severityOnce sync.Once
+	lineLevels   levelCounts
+
+	// samplingRules are compiled from Sampling once
+	samplingRules []samplingRule
+	samplingOnce  sync.Once
+
+	// tick of the supervision loop, unix nanoseconds
+	tick int64
+
+	// restartDelay is the delay of the restart planned at the end of the last run,
+	// failures are the failed runs in a row and restartTimes the Monotonic readings
+	// of the restarts counted for the start limit. restartRefused is why the
+	// policy does not restart after the last run. restartsKept keeps them
+	// through the next begin, they were inherited from a replaced service
+	restartDelay   time.Duration
+	failures       int
+	restartTimes   []time.Duration
+	restartRefused string
+	restartsKept   bool
+
+	// memoryLogAt is the Monotonic reading the memory of the running process is
+	// logged at next
+	memoryLogAt time.Duration
+
+	// nextRunAt is the Monotonic reading the next run of a scheduled service is
+	// due at, nextRun its wall time guarded by mu, runQueued a run due while the
+	// previous one ran
+	nextRunAt time.Duration
+	nextRun   time.Time
+	runQueued bool
+
+	// intent and running are only changed by the supervision loop, intent is
+	// written holding mu so others may read it with intentOf. isStarted is
+	// guarded by mu
+	isStarted bool
+	intent    runIntent
+}
+
+// runIntent is what the supervision loop does about the next run, it moves
+// only by the transitions of setIntent
+type runIntent int
+
+const (
+	// intentSupervise starts the first run and restarts by the restart policy
+	intentSupervise runIntent = iota
+	// intentStartNow starts a run as soon as no process runs, once
+	intentStartNow
+	// intentHeld starts nothing until an operator start: the service was
+	// stopped or failed to start for a permanent reason. The loop waits for
+	// the start
+	intentHeld
+	// intentEnded starts nothing and ends the loop once no process runs, only
+	// a new loop supervises the service again
+	intentEnded
+)
+
+var runIntentNames = map[runIntent]string{
+	intentSupervise: "supervise",
+	intentStartNow:  "start-now",
+	intentHeld:      "held",
+	intentEnded:     "ended",
+}
+
+func (i runIntent) String() string {
+	if name, ok := runIntentNames[i]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("intent(%d)", int(i))
+}
+
+// setIntent moves the loop to the intent, an ended loop stays ended. It is
+// called by the supervision loop only
+func (s *Service) setIntent(to runIntent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.intent == intentEnded && to != intentEnded {
+		s.log().warnf("not moving the ended supervision to %s", to)
+		return
+	}
+
+	s.intent = to
+}
+
+// intentOf reads the intent off the supervision loop
+func (s *Service) intentOf() runIntent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.intent
+}
+
+// stopped reports whether the service is not started on its own
+func (s *Service) stopped() bool {
+	return s.intent == intentHeld || s.intent == intentEnded
+}
+
+func (s *Service) IsNew() bool {
+	return s.runs == 0 && s.running == nil
+}
+
+func (s *Service) IsRestarting() bool {
+	return s.IsFinished() && s.running == nil && !s.stopped() && s.restarts()
+}
+
+func (s *Service) GetRestartDelay() time.Duration {
+	if s.RestartDelay > 0 {
+		return s.RestartDelay
+	}
+
+	return time.Duration(s.Restart) * time.Second
+}
+
+func (s *Service) IsRunning() bool {
+	return s.running != nil && s.running.Running()
+}
+
+func (s *Service) getState() State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.state
+}
+
+func (s *Service) IsFinished() bool {
+	// no running process, but have run before, or process have exited
+	return (s.runs > 0 && s.running == nil) || (s.running != nil && s.running.Finished())
+}
+
+// History returns records of finished processes, oldest first
+func (s *Service) History() []ProcessRecord {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	history := make([]ProcessRecord, len(s.history))
+	copy(history, s.history)
+
+	return history
+}
+
+func (s *Service) Status() ServiceStatus {
+	now := s.getClock().Monotonic()
+
+	s.mu.RLock()
+	status := ServiceStatus{
+		Name:         s.Name,
+		State:        s.state,
+		Runs:         s.runs,
+		Incarnation:  s.incarnation,
+		LastExitCode: -1,
+		ForcedKills:  s.forcedKills,
+		Labels:       s.labels(),
+
+		StateDurations: s.stateTime.read(s.state, now),
+		StartLatency:   s.startLatency.stats(),
+		ReadyLatency:   s.readyLatency.stats(),
+
+		Role:       s.role(),
+		ConfigHash: s.ConfigHash(),
+
+		RestartDeferred: s.restartDeferred,
+		NextRun:         s.nextRun,
+	}
+
+	if s.isExternal() {
+		status.Type = SERVICE_EXTERNAL
+	}
+	status.ProbeError = s.probeErr
+
+	switch {
+	case IsTemplate(s.group):
+		status.Template = s.group
+	case s.group != "":
+		status.ReplicaOf = s.group
+		status.Replica = s.replica
+	}
+
+	if s.running != nil && s.running.cmd.Process != nil {
+		status.PID = s.running.cmd.Process.Pid
+		status.StartedAt = s.running.Created
+	}
+
+	if last := s.lastExit; last != nil {
+		status.LastExitCode = last.ExitCode
+		status.LastStopReason = last.StopReason
+		status.LastCoreDumped = last.CoreDumped
+		status.LastCorePath = last.CorePath
+	}
+	s.mu.RUnlock()
+
+	usage := s.OutputUsage()
+	status.OutputBuffered = usage.BufferedBytes
+	status.OutputDropped = usage.DroppedLines
+	status.OutputLevels = s.lineLevels.read()
+
+	if status.PID > 0 && (status.State == StateRunning || status.State == StateReady) {
+		status.MemoryKB, status.MemoryMetric, _ = s.memoryUsage(status.PID, s.GetSampleInterval())
+	}
+
+	return status
+}
+
+// FollowOutput streams lines captured from the service processes until stop is called,
+// only lines of the given incarnation if it is positive
+func (s *Service) FollowOutput(incarnation int) (lines <-chan LogLine, stop func()) {
+	queue := s.getOutputBudget().newQueue(&s.outputUsage, OUTPUT_FOLLOW_BUFFER)
+
+	return s.output.Follow(incarnation, queue)
+}
+
+// RecentOutput returns up to n of the last RecentLines captured lines of the
+// service, oldest first, all of them if n is not positive
+func (s *Service) RecentOutput(n int) []LogLine {
+	return s.output.Recent(n)
+}
+
+// nextIncarnation numbers a new run of the service
+func (s *Service) nextIncarnation() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.incarnation += 1
+
+	return s.incarnation
+}
+
+func (s *Service) GetUsedMemory() uint64 {
+	if !s.IsRunning() {
+		return 0
+	}
+
+	mem, _, e := s.memoryUsage(s.running.GetPid(), s.GetSampleInterval())
+	if e != nil && e != ErrProcUnavailable {
+		s.log().warnf("%s", e)
+	}
+
+	return mem
+}
+
+// Run supervises the service until ctx is done, output lines are sent to out and
+// err, a nil channel leaves the stream unprinted while it is still captured for
+// the history and the followers, DiscardOutput drops it. It blocks until then, see Start
+func (s *Service) Run(ctx context.Context, out, err chan<- string) {
+	startErr := s.Start(ctx, out, err)
+	if errors.Is(startErr, ErrInvalidName) || errors.Is(startErr, ErrAlreadyStarted) {
+		s.logBegin(startErr)
+		return
+	}
+
+	s.Wait()
+}
+
+// Start launches the supervision loop of the service until ctx is done and
+// returns once the first process has started, or with the error its start failed
+// with while the loop goes on as configured. It fails with ErrAlreadyStarted if
+// a loop runs already, Done and Wait tell when the loop has ended. A service
+// that ran before starts again at once
+func (s *Service) Start(ctx context.Context, out, err chan<- string) error {
+	s.mu.RLock()
+	intent := intentSupervise
+	if !s.IsNew() {
+		intent = intentStartNow
+	}
+	s.mu.RUnlock()
+
+	if beginErr := s.begin(intent); beginErr != nil {
+		return beginErr
+	}
+
+	s.mu.RLock()
+	firstStart := s.firstStart
+	s.mu.RUnlock()
+
+	go s.loop(ctx, out, err)
+	<-firstStart
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	switch {
+	case s.startErr != nil:
+		return s.startErr
+	case s.state == StateNew && ctx.Err() != nil:
+		return ctx.Err()
+	}
+
+	return nil
+}
+
+// Done is closed once the supervision loop has ended, it is nil before the
+// first Start
+func (s *Service) Done() <-chan struct{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.loopDone
+}
+
+// Wait blocks until the supervision loop has ended
+func (s *Service) Wait() {
+	if done := s.Done(); done != nil {
+		<-done
+	}
+}
+
+// logBegin logs why begin failed
+func (s *Service) logBegin(err error) {
+	if errors.Is(err, ErrAlreadyStarted) {
+		s.log().infof("already running")
+		return
+	}
+
+	s.log().errorf("%s", err)
+}
+
+// begin marks the service supervised with the intent the loop starts with, it
+// fails if a supervision loop runs already
+func (s *Service) begin(intent runIntent) error {
+	if nameErr := ValidateName(s.Name); nameErr != nil {
+		return nameErr
+	}
+
+	now := s.getClock().Monotonic()
+
+	s.mu.Lock()
+	if s.isStarted {
+		s.mu.Unlock()
+		return ErrAlreadyStarted
+	}
+	if s.stateLockErr != nil {
+		s.mu.Unlock()
+		return s.stateLockErr
+	}
+
+	s.isStarted = true
+	s.intent = intent
+	if !s.restartsKept {
+		s.resetRestarts()
+	}
+	s.restartsKept = false
+	s.shuttingDown = false
+	s.stateTime.start(now)
+	s.requests = make(chan request)
+	s.loopDone = make(chan struct{})
+	s.firstStart = make(chan struct{})
+	s.startErr = nil
+	s.mu.Unlock()
+
+	return nil
+}
+
+// loop supervises the service until ctx is done, begin must have succeeded
+func (s *Service) loop(ctx context.Context, out, err chan<- string) {
+	// keep handling until the last process is archived and no restart is pending
+	done := ctx.Done()
+	firstStart := s.firstStart
+	clock := s.getClock()
+	s.mu.RLock()
+	from, runs := s.state, s.runs
+	s.mu.RUnlock()
+	s.consoleOut, s.consoleErr = out, err
+	s.output.setKeep(s.getRecentLines())
+	s.output.count(s.getOutputBudget(), &s.outputUsage)
+	if s.isExternal() {
+		s.probeLoop(ctx, firstStart)
+		firstStart = nil
+	}
+
+	for s.isActive() {
+		ticked(&s.tick, clock)
+
+		// the first start moves the state on, or fails in the state it started from
+		if firstStart != nil && (s.getState() != from || s.runs != runs) {
+			close(firstStart)
+			firstStart = nil
+		}
+
+		check := clock.NewTimer(s.nextCheck())
+		select {
+		case <-done:
+			s.stopProcess(ctx.Err())
+			done = nil
+		case req := <-s.requests:
+			req.result <- s.handleRequest(req, out, err)
+		case ready := <-s.readiness:
+			s.handleReadiness(ready)
+		case result := <-s.health:
+			s.handleHealth(result)
+		case <-s.activationTriggered():
+			s.handleActivation(out, err)
+		case <-s.processDone():
+			s.handleProcess(out, err)
+		case <-check.C():
+			s.handleProcess(out, err)
+		}
+		check.Stop()
+	}
+
+	s.mu.Lock()
+	s.isStarted = false
+	if firstStart != nil {
+		close(firstStart)
+	}
+	close(s.loopDone)
+	s.mu.Unlock()
+
+	s.log().infof("finished")
+}
+
+func (s *Service) isActive() bool {
+	switch s.intent {
+	case intentStartNow, intentHeld:
+		return true
+	case intentEnded:
+		return s.running != nil
+	}
+
+	return s.running != nil || s.isListening() || s.isWaitingRun() || s.IsNew() || s.IsRestarting()
+}
+
+// isSupervised reports whether the supervision loop is running
+func (s *Service) isSupervised() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.isStarted
+}
+
+// send passes a request to the supervision loop and waits for its result
+func (s *Service) send(cmd command) error {
+	return s.sendRequest(request{command: cmd})
+}
+
+// sendStop stops the service granting it stopTimeout to exit, StopTimeout if zero
+func (s *Service) sendStop(stopTimeout time.Duration) error {
+	return s.sendRequest(request{command: commandStop, stopTimeout: stopTimeout})
+}
+
+func (s *Service) sendRequest(req request) error {
+	s.mu.RLock()
+	requests, loopDone := s.requests, s.loopDone
+	s.mu.RUnlock()
+
+	if requests == nil {
+		return ErrNotRunning
+	}
+
+	req.result = make(chan error, 1)
+	select {
+	case requests <- req:
+		return <-req.result
+	case <-loopDone:
+		return ErrNotRunning
+	}
+}
+
+func (s *Service) handleRequest(req request, out, err chan<- string) error {
+	cmd := req.command
+	if s.shuttingDown && (cmd == commandStart || cmd == commandRestart || cmd == commandTriggerRestart) {
+		return ErrShuttingDown
+	}
+
+	if s.isHandingOff() && (cmd == commandStart || cmd == commandRestart || cmd == commandTriggerRestart) {
+		return ErrHandingOff
+	}
+
+	switch cmd {
+	case commandStart:
+		if s.IsRunning() {
+			return ErrAlreadyRunning
+		}
+
+		s.resetRestarts()
+		if s.running != nil {
+			s.setIntent(intentStartNow)
+			return nil
+		}
+
+		s.setIntent(intentSupervise)
+		return s.startProcess(out, err)
+
+	case commandStop:
+		s.setIntent(intentHeld)
+		s.deactivate()
+		s.stopRuns()
+		if !s.IsRunning() {
+			if s.running == nil {
+				s.setState(StateStopped)
+				s.wipeRuntimeDir()
+			}
+			return nil
+		}
+
+		s.stopTimeout = req.stopTimeout
+		defer func() { s.stopTimeout = 0 }()
+
+		return s.stopRunning(StopReasonOperatorStop)
+
+	case commandRestart, commandTriggerRestart:
+		reason := StopReasonOperatorStop
+		if cmd == commandTriggerRestart {
+			if !s.IsRunning() || s.frozen {
+				return nil
+			}
+			reason = StopReasonOutputTrigger
+
+			if !s.restartAllowed(reason) {
+				s.deferRestart()
+				return nil
+			}
+		}
+		s.mu.Lock()
+		s.restartDeferred = false
+		s.mu.Unlock()
+
+		s.setIntent(intentStartNow)
+		if !s.IsRunning() {
+			return nil
+		}
+
+		stopErr := s.stopRunning(reason)
+		if s.running == nil {
+			s.setIntent(intentSupervise)
+			if startErr := s.startProcess(out, err); startErr != nil {
+				return startErr
+			}
+		}
+
+		return stopErr
+
+	case commandUnready:
+		if s.frozen && s.frozenFrom == StateReady {
+			s.frozenFrom = StateRunning
+			return nil
+		}
+
+		if s.state == StateReady && s.IsRunning() {
+			s.log().infof("unready")
+			s.setState(StateRunning)
+		}
+
+		return nil
+
+	case commandPromote:
+		return s.promote()
+
+	case commandFreeze:
+		return s.freeze()
+
+	case commandThaw:
+		return s.thaw()
+
+	case commandUpdate:
+		s.setConfig(*req.config)
+		return nil
+	}
+
+	return fmt.Errorf("unknown command: %d", cmd)
+}
+
+func (s *Service) handleProcess(out, err chan<- string) {
+	// the new supervisor adopts or starts the process
+	if s.running == nil && s.isHandingOff() {
+		return
+	}
+
+	if s.running == nil && s.intent == intentStartNow {
+		s.setIntent(intentSupervise)
+		s.startProcess(out, err)
+
+		return
+	}
+
+	if s.isListening() {
+		return
+	}
+
+	if s.IsNew() && !s.isWaitingRun() {
+		if s.adopt() {
+			return
+		}
+
+		s.log().infof("new process")
+		s.activate(out, err)
+
+		return
+	}
+
+	if s.IsRestarting() {
+		if s.getClock().Monotonic() < s.restartAt() {
+			return
+		}
+
+		if !s.restartAllowed(s.lastStopReason()) {
+			s.holdRestart()
+			return
+		}
+
+		s.activate(out, err)
+
+		return
+	}
+
+	// an exited run is archived before the next one is started
+	if s.runDue() && (s.running == nil || !s.running.Finished()) {
+		s.handleRun(out, err)
+		return
+	}
+
+	if s.IsFinished() {
+		if s.running != nil {
+			s.finishProcess()
+		}
+
+		return
+	}
+
+	if s.restartDeferred && s.IsRunning() && !s.frozen && s.restartAllowed(StopReasonOutputTrigger) {
+		s.log().infof("restart window open, running the deferred restart")
+		if restartErr := s.handleRequest(request{command: commandTriggerRestart}, out, err); restartErr != nil {
+			s.log().warnf("deferred restart: %s", restartErr)
+		}
+	}
+
+	if s.IsRunning() && !s.frozen {
+		s.checkIdle()
+
+		if s.IsRunning() && procCapabilities().Memory && s.getClock().Monotonic() >= s.memoryLogAt {
+			s.memoryLogAt = s.getClock().Monotonic() + MEMORY_LOG_INTERVAL
+			mem := s.GetUsedMemory()
+			s.log().with(FIELD_PID, s.running.pid()).infof("memory usage: %.2d kb", mem/1024)
+		}
+	}
+}
+
+func (s *Service) GetStartTimeout() time.Duration {
+	if s.StartTimeout > 0 {
+		return s.StartTimeout
+	}
+
+	return UNIT_START_TIMEOUT * time.Second
+}
+
+func (s *Service) waitReady(running *process) {
+	s.readiness = make(chan error, 1)
+
+	if s.ReadyWhenListening != "" {
+		probeReady(s.getScheduler(), s.ReadyWhenListening, running.GetPid(), s.GetStartTimeout(), running.Done(), s.readiness)
+		return
+	}
+
+	probeReadiness(s.getScheduler(), s.Readiness, s.GetStartTimeout(), running.Done(), s.readiness)
+}
+
+func (s *Service) handleReadiness(err error) {
+	s.readiness = nil
+
+	if err == errProcessExited || !s.IsRunning() {
+		return
+	}
+
+	if err == nil {
+		s.watchHealth(s.running)
+	}
+
+	// a frozen process does not answer, it is probed again once thawed
+	if s.frozen {
+		if err != nil {
+			s.probeOnThaw = true
+		} else if s.role() != ROLE_PASSIVE {
+			s.note(JournalEntry{Type: JOURNAL_READY, PID: s.running.cmd.Process.Pid})
+			s.frozenFrom = StateReady
+			s.noteReady(s.running)
+		}
+		return
+	}
+
+	if err == nil && s.role() == ROLE_PASSIVE {
+		s.mu.Lock()
+		s.standbyReady = true
+		s.mu.Unlock()
+
+		s.note(JournalEntry{Type: JOURNAL_READY, PID: s.running.cmd.Process.Pid, Message: ROLE_PASSIVE})
+		s.inform("ready as standby")
+		return
+	}
+
+	if err == nil {
+		s.log().infof("ready")
+		s.note(JournalEntry{Type: JOURNAL_READY, PID: s.running.cmd.Process.Pid})
+		s.setState(StateReady)
+		s.noteReady(s.running)
+		return
+	}
+
+	s.log().warnf("failed to start: %s", err)
+	s.note(JournalEntry{Type: JOURNAL_PROBE_FAILED, PID: s.running.cmd.Process.Pid, Message: err.Error()})
+	s.publish(Event{Type: EVENT_PROBE_FAILED, Message: err.Error()})
+	if err := s.stopRunning(StopReasonLivenessFailed); err != nil {
+		s.log().errorf("%s", err)
+	}
+}
+
+// restartAt is the Monotonic reading the service is restarted at
+func (s *Service) restartAt() time.Duration {
+	return s.stoppedAt + s.restartDelay
+}
+
+// nextCheck is the time until the next transition due on the clock: a restart,
+// the opening of a restart window, an idle check or the memory log. Exits,
+// requests, readiness and activations wake the loop on their own, without any
+// of these it waits a SUPERVISION_HEARTBEAT
+func (s *Service) nextCheck() time.Duration {
+	if s.isListening() || (s.running == nil && s.isHandingOff()) {
+		return SUPERVISION_HEARTBEAT
+	}
+
+	if (s.IsNew() && !s.isWaitingRun()) || (s.running == nil && s.intent == intentStartNow) {
+		return 0
+	}
+
+	wait := SUPERVISION_HEARTBEAT
+	due := func(d time.Duration) {
+		if d < wait {
+			wait = d
+		}
+	}
+
+	now := s.getClock().Monotonic()
+	if s.isWaitingRun() {
+		due(s.nextRunAt - now)
+	}
+
+	switch {
+	case s.IsRestarting() && s.getState() == StatePendingRestart:
+		due(s.untilRestartWindow())
+	case s.IsRestarting():
+		due(s.restartAt() - now)
+	case s.IsRunning() && !s.frozen:
+		if s.restartDeferred {
+			due(s.untilRestartWindow())
+		}
+
+		if s.activation != nil && s.isOnDemand() && s.IdleTimeout > 0 {
+			due(IDLE_CHECK_INTERVAL)
+		}
+
+		if procCapabilities().Memory {
+			due(s.memoryLogAt - now)
+		}
+	}
+
+	if wait < 0 {
+		return 0
+	}
+
+	return wait
 }
 
-func (s Service) IsNew() bool {
-	return len(s.history) == 0 && s.running == nil
+func (s *Service) processDone() <-chan struct{} {
+	if s.running == nil {
+		return nil
+	}
+
+	return s.running.Done()
 }
 
-func (s Service) IsRestarting() bool {
-	return s.IsFinished() && s.running == nil && s.Restart > 0
+func (s *Service) setState(state State) {
+	now := time.Now()
+	monotonic := s.getClock().Monotonic()
+
+	s.mu.Lock()
+	s.stateTime.enter(s.state, monotonic)
+	previous := s.state
+	s.state = state
+
+	event := Event{Service: s.Name, Type: EVENT_STATE, State: state, Time: now, Incarnation: s.incarnation, Labels: s.labels()}
+	if s.running != nil && s.running.cmd.Process != nil {
+		event.PID = s.running.cmd.Process.Pid
+	}
+
+	switch {
+	case state == StateFailed && s.running != nil:
+		event.Stderr = s.running.stderrTail.String()
+	case (state == StateFinished || state == StateFailed) && s.lastExit != nil:
+		lastRun := s.lastExit
+		event.PID = lastRun.PID
+		event.Incarnation = lastRun.Incarnation
+		event.ExitCode = lastRun.ExitCode
+		event.Stderr = lastRun.Stderr
+		event.StopReason = lastRun.StopReason
+		event.CorePath = lastRun.CorePath
+	}
+
+	notify := s.notify
+	s.mu.Unlock()
+
+	s.log().with(FIELD_FROM, previous, FIELD_TO, state, FIELD_PID, event.PID).debugf("state changed")
+	if notify != nil {
+		notify(event)
+	}
 }
 
-func (s Service) IsRunning() bool {
-	return s.running != nil && s.running.Running()
+// newProcess prepares the process of the service, an activated one gets the sockets
+// passed, the files are to be closed once the process has started
+func (s *Service) newProcess() (*process, []*os.File, error) {
+	if err := s.checkInterpreter(); err != nil {
+		return nil, nil, err
+	}
+
+	cred, err := s.credential()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := s.makeDirs(cred); err != nil {
+		return nil, nil, err
+	}
+
+	if err := s.checkWorkingDir(); err != nil {
+		return nil, nil, err
+	}
+
+	running, files, err := s.buildProcess()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cred.apply(running)
+	running.ownGroup()
+	running.umask = s.getUmask()
+
+	cgroup, err := s.joinCgroup(running)
+	if err != nil {
+		closeFiles(files)
+		return nil, nil, err
+	}
+	if cgroup != nil {
+		files = append(files, cgroup)
+	}
+
+	return running, files, nil
 }
 
-func (s Service) IsFinished() bool {
-	// no running process, but have history, or process have exited
-	return (len(s.history) > 0 && s.running == nil) || (s.running != nil && s.running.Finished())
+// buildProcess builds the command of the process, an on-demand or socket
+// activated one gets the sockets passed
+func (s *Service) buildProcess() (*process, []*os.File, error) {
+	if !s.passesSockets() {
+		if err := s.checkPorts(); err != nil {
+			return nil, nil, err
+		}
+
+		if s.cmdFactory != nil {
+			running, err := s.newFactoryProcess()
+			return running, nil, err
+		}
+
+		env, secrets, err := s.processEnv(nil)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		target, params := s.command()
+		running := newCmdProcess(s.Name, exec.Command(target, params...))
+		running.cmd.Dir = s.WorkingDir
+		running.cmd.Env = env
+		running.secrets = secrets
+
+		return running, nil, nil
+	}
+
+	env, secrets, err := s.processEnv(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := s.listen(); err != nil {
+		return nil, nil, err
+	}
+
+	s.activation.disarm()
+	s.activation.lastActive = s.getClock().Monotonic()
+
+	files, err := s.activation.files()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// LISTEN_PID must be the pid of the service process, the shell sets it and execs
+	target, params := s.command()
+	params = append([]string{"-c", `LISTEN_PID=$$ exec "$0" "$@"`, target}, params...)
+
+	running := newCmdProcess(s.Name, exec.Command("/bin/sh", params...))
+	running.cmd.Dir = s.WorkingDir
+	running.cmd.Env = append(env, fmt.Sprintf("LISTEN_FDS=%d", len(files)))
+	running.cmd.ExtraFiles = files
+	running.secrets = secrets
+
+	return running, files, nil
 }
 
-func (s Service) GetUsedMemory() uint64 {
-	if !s.IsRunning() {
-		return 0
+// processEnv returns the environment of the process with its secrets resolved,
+// and the values of the secrets. Variables set by the supervisor come last so
+// the configured ones do not override them
+func (s *Service) processEnv(base []string) ([]string, []string, error) {
+	fromFile, err := s.fileEnv()
+	if err != nil {
+		return nil, nil, err
 	}
 
-	mem, e := memoryUsage(s.running.GetPid())
-	if e != nil {
-		log.Println(e)
+	configured, secrets, err := s.resolveSecrets(append(fromFile, s.Env...))
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return mem
+	if base == nil {
+		base = s.inheritedEnv()
+	}
+
+	env := append(base[:len(base):len(base)], configured...)
+	env = append(env, s.supervisorEnv()...)
+	env = append(env, s.dirsEnv()...)
+
+	return append(env, s.environ...), secrets, nil
 }
 
-func (s *Service) Run(ctx context.Context, out, err chan<- string) {
-	if s.isStarted {
-		log.Printf("[S][%s] already running", s.Name)
-		return
+// warn emits an event with a warning about the service, keeping its state
+func (s *Service) warn(message string) {
+	s.report(EVENT_LEVEL_WARN, message)
+}
+
+// inform emits an event with a message about the service, keeping its state
+func (s *Service) inform(message string) {
+	s.report("", message)
+}
+
+func (s *Service) report(level, message string) {
+	if level == EVENT_LEVEL_WARN {
+		s.log().warnf("%s", message)
+	} else {
+		s.log().infof("%s", message)
 	}
 
-	s.isStopped = false
-	s.isStarted = true
+	s.publish(Event{Type: EVENT_MESSAGE, Level: level, Message: message})
+}
 
-	// do not start process if Service is exit
-	for !s.IsFinished() || s.IsRestarting() {
-		select {
-		case <-ctx.Done():
-			s.stopProcess(ctx.Err())
-		case <-time.After(time.Second):
-			s.handleProcess(out, err)
-		}
+// publish emits the event in the current state of the service, the run and the
+// pid are those of the running process unless set
+func (s *Service) publish(event Event) {
+	s.mu.RLock()
+	event.Service, event.State, event.Time, event.Labels = s.Name, s.state, time.Now(), s.labels()
+	if event.Incarnation == 0 {
+		event.Incarnation = s.incarnation
+	}
+	if event.PID == 0 && s.running != nil && s.running.cmd.Process != nil {
+		event.PID = s.running.cmd.Process.Pid
 	}
 
-	log.Printf("[S][%s] finished", s.Name)
+	notify := s.notify
+	s.mu.RUnlock()
+
+	if notify != nil {
+		notify(event)
+	}
 }
 
-func (s *Service) handleProcess(out, err chan<- string) {
-	if s.IsNew() {
-		log.Printf("[S][%s] new process", s.Name)
-		s.startProcess(out, err)
+// execProcess creates the process and execs it, the returned process has
+// startErr set if the exec failed, an error is a failure of the setup before it
+func (s *Service) execProcess(out, err chan<- string) (*process, error) {
+	running, files, startErr := s.newProcess()
+	if startErr != nil {
+		return nil, startErr
+	}
 
-		return
+	output, pipeFiles, startErr := s.connectPipes(running)
+	if startErr != nil {
+		closeFiles(files)
+		return nil, startErr
 	}
+	files = append(files, pipeFiles...)
 
-	if s.IsRestarting() {
-		lastRun := s.history[len(s.history)-1]
+	s.stopReason = StopReasonUnknown
+	running.incarnation = s.nextIncarnation()
+	running.cmd.Env = append(running.cmd.Env, fmt.Sprintf("%s=%d", INCARNATION_ENV, running.incarnation))
+
+	running.stderrTail = newTailBuffer(s.getStderrTailSize())
+
+	// a nil channel is not printed to, the lines are still captured
+	stdout := STREAM_STDOUT
+	if s.CombineOutput && !s.DiscardOutput {
+		running.combine()
+		stdout = STREAM_COMBINED
+	} else {
+		running.discard(s.DiscardOutput, s.DiscardOutput)
+	}
+
+	started := make(chan error)
+
+	// listen for STD
+	// the stdout of a producer goes to its consumer instead
+	if output != nil {
+		output.pump(running)
+	} else if running.Out != nil {
+		s.scanProcessStd(stdout, running, started, running.Out, out)
+	}
+	if running.Err != nil {
+		s.scanProcessStd(STREAM_STDERR, running, started, running.Err, err)
+	}
+
+	running.clock = s.getClock()
+	running.execAt = running.clock.Monotonic()
+	go running.Start(started)
+	<-started
+	closeFiles(files)
+
+	return running, nil
+}
+
+func (s *Service) startProcess(out, err chan<- string) error {
+	if s.shuttingDown {
+		s.log().infof("shutting down, not starting")
+		return ErrShuttingDown
+	}
+
+	s.mu.RLock()
+	incarnation := s.incarnation + 1
+	s.mu.RUnlock()
+	if hookErr := s.runServiceHooks(HOOK_START_PRE, s.ExecStartPre, incarnation); hookErr != nil {
+		s.failStart(hookErr)
+		return hookErr
+	}
+
+	// transient failures of fork and exec are retried within the start timeout
+	deadline := s.getClock().Monotonic() + s.GetStartTimeout()
+	delay := START_RETRY_DELAY
 
-		if time.Now().After(lastRun.Stopped.Add(time.Second * time.Duration(s.Restart))) {
-			s.startProcess(out, err)
+	var running *process
+	for attempt := 1; ; attempt++ {
+		var startErr error
+		if running, startErr = s.execProcess(out, err); startErr != nil {
+			s.failStart(startErr)
+			return startErr
 		}
 
-		return
+		if running.startErr == nil {
+			break
+		}
+
+		if running.stdin != nil {
+			running.stdin.Close()
+		}
+
+		class := ClassifyStartError(running.startErr)
+		if class == StartErrorTransient && attempt <= s.getStartRetries() && s.getClock().Monotonic()+delay < deadline {
+			s.log().warnf("failed to start: %s, retrying in %s (%d/%d)", running.startErr, delay, attempt, s.getStartRetries())
+			<-s.getClock().After(delay)
+			delay *= 2
+
+			continue
+		}
+
+		// a permanent failure is not restarted until the service is started again
+		if class == StartErrorPermanent {
+			s.setIntent(intentHeld)
+		}
+
+		startErr = s.explainStart(running.startErr)
+		s.failStart(startErr)
+		if class == StartErrorPermanent {
+			s.log().warnf("not restarting, the error is permanent")
+		}
+
+		return startErr
 	}
 
-	if s.IsFinished() {
-		if s.running != nil {
-			s.history = append(s.history, s.running)
-			s.running = nil
+	s.feedInput(running)
+
+	s.mu.Lock()
+	s.running = running
+	s.runs += 1
+	s.standbyReady = false
+	s.startErr = nil
+	s.mu.Unlock()
+	s.memoryLogAt = running.execAt + MEMORY_LOG_INTERVAL
+
+	if s.CoreDumps {
+		if err := raiseCoreLimit(running.cmd.Process.Pid); err != nil {
+			s.log().warnf("failed to raise core limit: %s", err)
+		}
+	}
+
+	if err := s.applyRlimits(running.cmd.Process.Pid); err != nil {
+		s.log().warnf("failed to set limit: %s", err)
+	}
+
+	running.startTicks = startTicks(running.cmd.Process.Pid)
+	s.note(JournalEntry{Type: JOURNAL_STARTED, PID: running.cmd.Process.Pid})
+	s.setState(StateRunning)
+	s.publish(Event{Type: EVENT_STARTED, Incarnation: running.incarnation})
+	s.noteStarted(running)
+	s.watchProcess(running)
+	s.saveState()
+
+	if hookErr := s.runServiceHooks(HOOK_START_POST, s.ExecStartPost, running.incarnation); hookErr != nil {
+		s.warn(hookErr.Error() + ", stopping")
+		if stopErr := s.stopRunning(StopReasonHookFailed); stopErr != nil {
+			s.log().errorf("%s", stopErr)
+		}
+
+		return hookErr
+	}
+
+	return nil
+}
+
+// watchProcess samples the running process and watches its memory, readiness
+// and health
+func (s *Service) watchProcess(running *process) {
+	s.getScheduler().every(s.GetSampleInterval(), s.GetSampleInterval(), running.Done(), func(ctx context.Context) bool {
+		s.sample(running)
+		return true
+	})
+
+	s.watchMemory(running)
+	if s.hasReadinessCheck() {
+		s.waitReady(running)
+	} else {
+		s.watchHealth(running)
+	}
+}
+
+// stopRunning stops the running process for the reason and archives it once it has exited
+func (s *Service) stopRunning(reason StopReason) error {
+	// the stop signal is not handled by a stopped process
+	if s.frozen {
+		s.resume()
+	}
+
+	s.stopReason = reason
+	s.setState(StateStopping)
+
+	grace := s.stopGrace(reason)
+	err := s.running.Stop(s.getStopSignal(), grace, UNIT_KILL_TIMEOUT*time.Second)
+	if !s.running.killSentAt.IsZero() {
+		s.mu.Lock()
+		s.forcedKills += 1
+		s.mu.Unlock()
+
+		s.warn(fmt.Sprintf("did not stop within %s, killed", grace))
+	}
+
+	if errors.Is(err, ErrStopFailed) {
+		s.log().errorf("%s", err)
+		s.setState(StateStopFailed)
+	}
+
+	if s.running.Finished() {
+		s.finishProcess()
+	}
+
+	return err
+}
+
+// ValidateStopSignal checks the name of the stop signal
+func ValidateStopSignal(config ServiceConfig) error {
+	if _, err := ParseSignal(config.StopSignal); config.StopSignal != "" && err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidStopSignal, err)
+	}
+
+	return nil
+}
+
+// getStopSignal is the signal asking the process to exit, it is validated
+func (s *Service) getStopSignal() syscall.Signal {
+	if sig, err := ParseSignal(s.StopSignal); err == nil {
+		return sig
+	}
+
+	return syscall.SIGTERM
+}
+
+func (s *Service) GetStopTimeout() time.Duration {
+	if s.StopTimeout > 0 || s.isExplicit("stopTimeout") {
+		return s.StopTimeout
+	}
+
+	return UNIT_START_TIMEOUT * time.Second
+}
+
+// stopGrace is the time given to exit after the stop signal, a supervisor shutdown with
+// a timeout leaves what is left of it
+func (s *Service) stopGrace(reason StopReason) time.Duration {
+	grace := s.GetStopTimeout()
+	if s.stopTimeout > 0 {
+		grace = s.stopTimeout
+	}
+	if reason != StopReasonSupervisorShutdown || s.shutdownTimeout <= 0 {
+		return grace
+	}
+
+	left := s.shutdownBy - s.getClock().Monotonic()
+	if left < 0 {
+		left = 0
+	}
+
+	if left < grace {
+		return left
+	}
+
+	return grace
+}
+
+func (s *Service) finishProcess() {
+	s.frozen, s.probeOnThaw = false, false
+
+	record := s.archiveProcess()
+	s.note(JournalEntry{Type: JOURNAL_EXITED, PID: record.PID, ExitCode: record.ExitCode, Reason: record.StopReason})
+	s.runStopPost(record)
+	s.setState(StateFinished)
+
+	if record.StopReason.IsInvoluntary() {
+		s.setState(StateFailed)
+	}
+
+	s.publish(Event{Type: EVENT_EXITED, Incarnation: record.Incarnation, PID: record.PID, ExitCode: record.ExitCode, Stderr: record.Stderr, StopReason: record.StopReason, CorePath: record.CorePath})
+
+	if s.intent == intentSupervise && !s.isOnDemand() && !s.isTimed() {
+		s.planRestart(record)
+		if record.StopReason.IsInvoluntary() && !s.IsRestarting() {
+			s.gaveUp(record)
 		}
+	}
 
-		if s.Restart > 0 {
-			log.Printf("[S][%s] restarting in %d seconds", s.Name, s.Restart)
+	switch {
+	case s.intent == intentStartNow:
+		s.log().infof("restarting")
+	case s.stopped():
+		s.setState(StateStopped)
+		s.wipeRuntimeDir()
+	case s.isTimed():
+		s.finishRun()
+	case s.isOnDemand():
+		if err := s.arm(); err != nil {
+			s.failStart(err)
 		}
+	case s.IsRestarting():
+		s.scheduleRestart()
+	default:
+		// nothing starts a socket activated service again, connections are refused
+		// rather than left waiting in the backlog
+		s.deactivate()
+	}
+
+	s.saveState()
+}
 
+// activate starts the process, or waits for a connection to start it if the
+// service is on-demand, or for its first run if it is scheduled
+func (s *Service) activate(out, err chan<- string) {
+	if s.isTimed() {
+		s.waitRun()
 		return
 	}
 
-	if s.IsRunning() {
-		if time.Now().Second()%10 == 0 {
-			mem := s.GetUsedMemory()
-			log.Printf("[S][%s][%d] memory usage: %.2d kb", s.Name, s.running.GetPid(), mem/1024)
+	if !s.isOnDemand() {
+		s.startProcess(out, err)
+		return
+	}
+
+	if armErr := s.arm(); armErr != nil {
+		s.failStart(armErr)
+	}
+}
+
+func (s *Service) scheduleRestart() {
+	s.countRestart()
+	s.note(JournalEntry{Type: JOURNAL_RESTART_SCHEDULED, Delay: s.restartDelay})
+	s.setState(StateRestarting)
+	s.publish(Event{Type: EVENT_RESTARTING, Delay: s.restartDelay})
+	s.log().infof("restarting in %s", s.restartDelay)
+}
+
+// failStart records a start that failed before any process was run
+func (s *Service) failStart(err error) {
+	s.log().warnf("failed to start: %s", err)
+
+	now := s.getClock().Now()
+	s.stoppedAt = s.getClock().Monotonic()
+	record := ProcessRecord{
+		Incarnation: s.nextIncarnation(),
+		StartedAt:   now,
+		StoppedAt:   now,
+		ExitCode:    -1,
+		Error:       err.Error(),
+		StopReason:  StopReasonCrashed,
+	}
+
+	s.mu.Lock()
+	s.runs += 1
+	s.startErr = err
+	s.mu.Unlock()
+
+	s.archive(record)
+	s.runStopPost(record)
+	s.setState(StateFailed)
+	s.planRestart(record)
+
+	// the scheduled restart is a part of the entry, a start failing over and over
+	// is folded into a single one
+	entry := JournalEntry{Type: JOURNAL_START_FAILED, Message: record.Error}
+	if s.IsRestarting() {
+		s.countRestart()
+		entry.Delay = s.restartDelay
+		s.setState(StateRestarting)
+		s.publish(Event{Type: EVENT_RESTARTING, Delay: s.restartDelay})
+		s.log().infof("restarting in %s", s.restartDelay)
+	} else if !s.isOnDemand() && !s.isTimed() {
+		s.gaveUp(record)
+		s.deactivate()
+	}
+	s.note(entry)
+
+	if s.isTimed() && !s.stopped() {
+		s.finishRun()
+	}
+}
+
+func (s *Service) archiveProcess() ProcessRecord {
+	record := newProcessRecord(s.running)
+	if record.CoreDumped {
+		s.collectCore(&record, s.running)
+	}
+
+	record.StopReason = s.stopReason
+	switch {
+	case record.StopReason != StopReasonUnknown:
+	case record.Adopted && s.running.cmd.ProcessState == nil:
+		// an adopted process is not a child, how it exited is not known
+	case record.OOMKilled && s.running.cgroup != "" && s.MemoryLimit > 0:
+		// the kernel kept the MemoryLimit of the cgroup
+		record.StopReason = StopReasonMemoryLimit
+	case s.running.failedLine != "":
+		record.StopReason = StopReasonFailurePattern
+		if record.Error == "" {
+			record.Error = "stderr matched a failure pattern: " + s.running.failedLine
 		}
+	case s.exitedSuccessfully(record):
+		record.StopReason = StopReasonCompleted
+	default:
+		record.StopReason = StopReasonCrashed
+	}
+
+	s.readiness = nil
+	s.stoppedAt = s.running.stoppedAt
+	// a process not seen exiting has stopped by now
+	if s.running.Stopped.IsZero() {
+		s.stoppedAt = s.getClock().Monotonic()
 	}
+
+	s.mu.Lock()
+	s.running = nil
+	s.mu.Unlock()
+
+	s.archive(record)
+
+	return record
 }
 
-func (s *Service) startProcess(out, err chan<- string) {
-	running := NewProcess(s.Name, s.Exec, s.Params)
+func (s *Service) archive(record ProcessRecord) {
+	s.mu.Lock()
+	s.history = append(s.history, record)
+	s.trimHistory()
+	s.lastExit, s.lastExitRestored = &record, false
+	s.mu.Unlock()
 
-	started := make(chan error)
-	go running.Start(started)
-	<-started
+	if s.store != nil {
+		if err := s.store.Append(record); err != nil {
+			s.log().warnf("failed to persist history: %s", err)
+		}
+	}
+}
 
-	s.running = running
+func (s *Service) trimHistory() {
+	if max := s.getMaxHistory(); len(s.history) > max {
+		s.history = s.history[len(s.history)-max:]
+	}
+}
 
-	// listen for STD
-	s.scanProcessStd("%s", &s.running.Out, out)
-	s.scanProcessStd("error: %s", &s.running.Err, err)
+func (s *Service) getRecentLines() int {
+	if s.RecentLines > 0 {
+		return s.RecentLines
+	}
+
+	return OUTPUT_RECENT_LINES
+}
+
+func (s *Service) getStderrTailSize() int {
+	if s.StderrTailSize > 0 {
+		return s.StderrTailSize
+	}
+
+	return STDERR_TAIL_SIZE
+}
+
+func (s *Service) getMaxHistory() int {
+	if s.MaxHistory > 0 {
+		return s.MaxHistory
+	}
+
+	return HISTORY_MAX_RECORDS
 }
 
 func (s *Service) stopProcess(err error) error {
-	if s.isStopped {
-		log.Printf("[S][%s] service.Stop() already have been called", s.Name)
+	wasStopped := s.stopped()
+	s.setIntent(intentEnded)
+	s.shuttingDown = true
+	s.stopRuns()
+	if s.shutdownBy == 0 {
+		s.shutdownBy = s.getClock().Monotonic() + s.shutdownTimeout
+	}
+	if wasStopped && !s.IsRunning() {
+		s.log().infof("service.Stop() already have been called")
 		return nil
 	}
 
-	log.Printf("[S][%s] %s", s.Name, err)
-	time.Sleep(time.Second * 1)
-
-	// disable restarting
-	s.Restart = 0
+	s.log().infof("%s", err)
+	s.deactivate()
 
-	s.isStopped = true
 	if s.IsRunning() {
-		return s.running.Stop()
+		return s.stopRunning(StopReasonSupervisorShutdown)
+	}
+
+	if s.running == nil {
+		s.setState(StateStopped)
+		s.wipeRuntimeDir()
 	}
 
 	return nil
 }
 
-func (s Service) scanProcessStd(format string, src *io.ReadCloser, dst chan<- string) {
-	stdScanner := bufio.NewScanner(*src)
+func (s *Service) scanProcessStd(stream string, running *process, started <-chan error, src io.ReadCloser, dst chan<- string) {
+	budget := s.getOutputBudget()
+	queue := budget.newQueue(&s.outputUsage, 0)
+	sanitize := s.Sanitize.enabled()
+	triggers := s.outputTriggers()
+	failures := s.failurePatterns()
+	sampler := s.newSampler()
+	severity := s.severityRules()
+
+	send := func(logs, level string) {
+		line := LogLine{Service: s.Name, Incarnation: running.incarnation, Labels: s.Labels, Stream: stream, Hook: running.hook, Level: level, Text: logs, Time: time.Now()}
+		s.lineLevels.add(level)
+		s.output.Send(line)
+		s.forwarder.send(line)
+		if dst != nil && LevelAtLeast(level, s.Severity.Console) {
+			queue.push(line)
+		}
+		budget.keep()
+	}
+
+	// the stderr tail counts against the budget while it is written
+	tail := stream == STREAM_STDERR || stream == STREAM_COMBINED
+	if tail {
+		running.stderrTail.count(budget, &s.outputUsage)
+	}
+
+	end := func() {
+		if sampler != nil {
+			if report, ok := sampler.report(true); ok {
+				send(report, LEVEL_INFO)
+			}
+		}
+		if tail {
+			running.stderrTail.release()
+		}
+		queue.close()
+	}
+
+	running.scan(src, func(logs string) {
+		if sampler != nil {
+			if report, ok := sampler.report(false); ok {
+				send(report, LEVEL_INFO)
+			}
+
+			if !sampler.keep(logs) {
+				return
+			}
+		}
+
+		if sanitize {
+			logs = s.Sanitize.clean(logs)
+		}
+
+		if len(running.secrets) > 0 {
+			logs = redactSecrets(logs, running.secrets)
+		}
+
+		// lines of hooks do not act on the process
+		if len(triggers) > 0 && running.hook == "" {
+			s.matchTriggers(triggers, stream, running, logs)
+		}
+
+		if tail {
+			fmt.Fprintln(running.stderrTail, logs)
+
+			if len(failures) > 0 && running.hook == "" {
+				s.matchFailure(failures, running, logs)
+			}
+		}
+
+		send(logs, lineLevel(severity, stream, logs))
+	}, end)
 
+	// lines are printed apart from the scan, a slow dst drops the oldest of them
+	// instead of blocking the process output. Nothing is printed to a nil dst
+	if dst == nil {
+		queue.discard()
+		return
+	}
+
+	running.readers.Add(1)
 	go func() {
-		for s.IsRunning() && stdScanner.Scan() {
-			logs := stdScanner.Text()
-			dst <- fmt.Sprintf("[%s] "+format, s.Name, logs)
+		defer running.readers.Done()
+		defer queue.discard()
+
+		// the pid is known once the process has started
+		<-started
+		if running.startErr != nil {
+			return
+		}
+
+		prefix := s.outputPrefix(stream, running.cmd.Process.Pid, running.incarnation)
+
+		for {
+			line, ok := queue.pop(running.Aborted())
+			if !ok {
+				return
+			}
+
+			select {
+			case dst <- prefix + line.Text:
+			case <-running.Aborted():
+				return
+			}
 		}
 	}()
 }
@@ -0,0 +1,200 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// levels of captured lines, from the least to the most severe
+const (
+	LEVEL_DEBUG = "debug"
+	LEVEL_INFO  = "info"
+	LEVEL_WARN  = "warn"
+	LEVEL_ERROR = "error"
+	LEVEL_FATAL = "fatal"
+)
+
+var levels = []string{LEVEL_DEBUG, LEVEL_INFO, LEVEL_WARN, LEVEL_ERROR, LEVEL_FATAL}
+
+// levelAliases are the other names of the levels found in lines
+var levelAliases = map[string]string{
+	"trace":       LEVEL_DEBUG,
+	"dbg":         LEVEL_DEBUG,
+	"information": LEVEL_INFO,
+	"notice":      LEVEL_INFO,
+	"warning":     LEVEL_WARN,
+	"err":         LEVEL_ERROR,
+	"crit":        LEVEL_FATAL,
+	"critical":    LEVEL_FATAL,
+	"alert":       LEVEL_FATAL,
+	"emerg":       LEVEL_FATAL,
+	"panic":       LEVEL_FATAL,
+}
+
+var ErrInvalidSeverity = errors.New("invalid severity")
+
+// OutputSeverity finds the level of every captured line, attached to the line
+// and counted per level in the status. Lines no rule matches are of the level of
+// their stream, info for stdout and warn for stderr
+type OutputSeverity struct {
+	// Rules are matched in order, the first one matching a line decides
+	Rules []SeverityRule
+
+	// Console is the lowest level of the printed lines, all of them if not set,
+	// followers and LogForward get the lines of their own level
+	Console string
+}
+
+// SeverityRule gives the lines matching Pattern the Level, like `^\[ERROR\]`
+// and "error". Without a Level the first group of the pattern names it, like
+// `level=(\w+)`, a line naming no known level is left to the next rules
+type SeverityRule struct {
+	Pattern string
+	Level   string
+}
+
+type severityRule struct {
+	pattern *regexp.Regexp
+	level   string
+}
+
+// ParseLevel returns the level of the name or of one of its aliases, like
+// "WARNING" for warn, in any case
+func ParseLevel(name string) (string, bool) {
+	name = strings.ToLower(name)
+	if alias, ok := levelAliases[name]; ok {
+		return alias, true
+	}
+
+	return name, levelRank(name) >= 0
+}
+
+func levelRank(level string) int {
+	for rank, name := range levels {
+		if name == level {
+			return rank
+		}
+	}
+
+	return -1
+}
+
+// LevelAtLeast reports whether the level is min or more severe, any level is at
+// least an empty min
+func LevelAtLeast(level, min string) bool {
+	if min == "" {
+		return true
+	}
+
+	minimum, _ := ParseLevel(min)
+
+	return levelRank(level) >= levelRank(minimum)
+}
+
+// streamLevel is the level of the lines of a stream no rule matches
+func streamLevel(stream string) string {
+	if stream == STREAM_STDERR {
+		return LEVEL_WARN
+	}
+
+	return LEVEL_INFO
+}
+
+// ValidateSeverity compiles the rules and checks their levels and the console level
+func ValidateSeverity(severity OutputSeverity) error {
+	if _, err := compileSeverity(severity); err != nil {
+		return err
+	}
+
+	if _, ok := ParseLevel(severity.Console); severity.Console != "" && !ok {
+		return fmt.Errorf("%w: unknown console level %q, expected one of %s", ErrInvalidSeverity, severity.Console, strings.Join(levels, ", "))
+	}
+
+	return nil
+}
+
+func compileSeverity(severity OutputSeverity) ([]severityRule, error) {
+	rules := make([]severityRule, 0, len(severity.Rules))
+	for _, config := range severity.Rules {
+		pattern, err := regexp.Compile(config.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidSeverity, err)
+		}
+
+		rule := severityRule{pattern: pattern}
+		switch {
+		case config.Level != "":
+			level, ok := ParseLevel(config.Level)
+			if !ok {
+				return nil, fmt.Errorf("%w: unknown level %q of %s, expected one of %s", ErrInvalidSeverity, config.Level, config.Pattern, strings.Join(levels, ", "))
+			}
+			rule.level = level
+		case pattern.NumSubexp() == 0:
+			return nil, fmt.Errorf("%w: %s has no level and no group naming it", ErrInvalidSeverity, config.Pattern)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// severityRules compiles the rules of the service once, they are validated
+func (s *Service) severityRules() []severityRule {
+	s.severityOnce.Do(func() {
+		var err error
+		if s.severity, err = compileSeverity(s.Severity); err != nil {
+			s.log().errorf("%s", err)
+		}
+	})
+
+	return s.severity
+}
+
+// lineLevel returns the level of the first rule matching the line, or of its stream
+func lineLevel(rules []severityRule, stream, text string) string {
+	for _, rule := range rules {
+		if rule.level != "" {
+			if rule.pattern.MatchString(text) {
+				return rule.level
+			}
+
+			continue
+		}
+
+		if match := rule.pattern.FindStringSubmatch(text); match != nil {
+			if level, ok := ParseLevel(match[1]); ok {
+				return level
+			}
+		}
+	}
+
+	return streamLevel(stream)
+}
+
+// levelCounts are the lines captured per level, counted as they are sent
+type levelCounts [5]int64
+
+func (c *levelCounts) add(level string) {
+	if rank := levelRank(level); rank >= 0 {
+		atomic.AddInt64(&c[rank], 1)
+	}
+}
+
+// read returns the counts of the levels seen
+func (c *levelCounts) read() map[string]int64 {
+	var counts map[string]int64
+	for rank, level := range levels {
+		if n := atomic.LoadInt64(&c[rank]); n > 0 {
+			if counts == nil {
+				counts = make(map[string]int64)
+			}
+			counts[level] = n
+		}
+	}
+
+	return counts
+}
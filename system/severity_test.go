@@ -0,0 +1,90 @@
+package system
+
+import "testing"
+
+// ERROR_LINE is prefixed as the first of the classic rules expects
+const ERROR_LINE = `[ERROR] 2026-10-14T05:33:06Z upstream api.internal:8443 refused the connection, retrying in 2s`
+
+// classicSeverity finds the "[ERROR]" prefixes first, then "level=warn" fields
+var classicSeverity = OutputSeverity{Rules: []SeverityRule{
+	{Pattern: `^\[(?:ERROR|ERR)\]`, Level: LEVEL_ERROR},
+	{Pattern: `^\[WARN(?:ING)?\]`, Level: LEVEL_WARN},
+	{Pattern: `level=(\w+)`},
+}}
+
+func mustCompileSeverity(tb testing.TB, severity OutputSeverity) []severityRule {
+	tb.Helper()
+
+	rules, err := compileSeverity(severity)
+	if err != nil {
+		tb.Fatalf("rules: %s", err)
+	}
+
+	return rules
+}
+
+func TestLineLevels(t *testing.T) {
+	rules := mustCompileSeverity(t, classicSeverity)
+
+	for _, c := range []struct {
+		stream, line, want string
+	}{
+		{STREAM_STDOUT, ERROR_LINE, LEVEL_ERROR},
+		{STREAM_STDOUT, "[WARNING] disk 91% full", LEVEL_WARN},
+		{STREAM_STDOUT, `ts=05:33:06 level=DEBUG msg="cache hit"`, LEVEL_DEBUG},
+		{STREAM_STDOUT, `level=critical msg="out of disk"`, LEVEL_FATAL},
+		// a group naming no known level is left to the stream
+		{STREAM_STDERR, `level=verbose msg="dial"`, LEVEL_WARN},
+		{STREAM_STDOUT, ASCII_LINE, LEVEL_INFO},
+		{STREAM_STDERR, ASCII_LINE, LEVEL_WARN},
+	} {
+		if got := lineLevel(rules, c.stream, c.line); got != c.want {
+			t.Errorf("%s %q: %s, want %s", c.stream, c.line, got, c.want)
+		}
+	}
+}
+
+func TestFirstRuleMatchDoesNotAllocate(t *testing.T) {
+	if raceEnabled {
+		// the regexp machines are not pooled under the race detector
+		t.Skip("allocations counted with the race detector")
+	}
+
+	rules := mustCompileSeverity(t, classicSeverity)
+	if n := testing.AllocsPerRun(100, func() { lineLevel(rules, STREAM_STDOUT, ERROR_LINE) }); n != 0 {
+		t.Fatalf("%.0f allocations to find the level of a line matching the first rule", n)
+	}
+}
+
+// benchmarkSeverity finds the level of the line and filters it for a console
+// of warn and more, as the scanner does for every line
+func benchmarkSeverity(b *testing.B, severity OutputSeverity, stream, line string) {
+	rules := mustCompileSeverity(b, severity)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(line)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		LevelAtLeast(lineLevel(rules, stream, line), LEVEL_WARN)
+	}
+}
+
+// BenchmarkSeverityFirstRule is the fast path of a line matching the first rule
+func BenchmarkSeverityFirstRule(b *testing.B) {
+	benchmarkSeverity(b, classicSeverity, STREAM_STDOUT, ERROR_LINE)
+}
+
+// BenchmarkSeverityGroup names the level with the group of the last rule
+func BenchmarkSeverityGroup(b *testing.B) {
+	benchmarkSeverity(b, classicSeverity, STREAM_STDOUT, `ts=05:33:06 level=warn msg="slow query" took=2.1s`)
+}
+
+// BenchmarkSeverityNoMatch goes through every rule to the level of the stream
+func BenchmarkSeverityNoMatch(b *testing.B) {
+	benchmarkSeverity(b, classicSeverity, STREAM_STDERR, ASCII_LINE)
+}
+
+func BenchmarkSeverityNoRules(b *testing.B) {
+	benchmarkSeverity(b, OutputSeverity{}, STREAM_STDOUT, ASCII_LINE)
+}
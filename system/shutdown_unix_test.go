@@ -0,0 +1,111 @@
+//go:build unix
+
+package system
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flapping is a task failing right away, its pids appended to a file of dir, so
+// it is in its restart delay most of the time
+func flapping(dir, name string) ServiceConfig {
+	return ServiceConfig{
+		Name:          name,
+		Exec:          "/bin/sh",
+		Params:        []string{"-c", `echo $$ >> "$PIDS"; sleep 0.01; exit 1`},
+		Env:           []string{"PIDS=" + filepath.Join(dir, name)},
+		RestartPolicy: RESTART_ALWAYS,
+		RestartDelay:  20 * time.Millisecond,
+		StopTimeout:   time.Second,
+	}
+}
+
+func TestShutdownDuringRestartDelays(t *testing.T) {
+	const SERVICES = 40
+
+	dir := t.TempDir()
+	configs := make([]ServiceConfig, SERVICES)
+	for i := range configs {
+		configs[i] = flapping(dir, fmt.Sprintf("flapping-%d", i))
+	}
+	m := runManagerOn(t, SystemClock, configs...)
+
+	eventually(t, 10*time.Second, "every task restarted", func() bool {
+		for _, config := range configs {
+			if status(t, m, config.Name).Runs < 3 {
+				return false
+			}
+		}
+		return true
+	})
+
+	if err := m.Shutdown(); err != nil {
+		t.Fatalf("shutdown: %s", err)
+	}
+
+	// a restart timer firing meanwhile would have left a child after Run
+	time.Sleep(100 * time.Millisecond)
+	var pids int
+	for _, config := range configs {
+		data, err := ioutil.ReadFile(filepath.Join(dir, config.Name))
+		if err != nil {
+			t.Fatalf("pids of %s: %s", config.Name, err)
+		}
+
+		for _, field := range strings.Fields(string(data)) {
+			pid, err := strconv.Atoi(field)
+			if err != nil {
+				t.Fatalf("pid %q of %s", field, config.Name)
+			}
+			if alive(pid) {
+				t.Errorf("pid %d of %s survived the shutdown", pid, config.Name)
+			}
+			pids++
+		}
+	}
+	if pids < 3*SERVICES {
+		t.Fatalf("%d runs of %d services", pids, SERVICES)
+	}
+}
+
+func TestShutdownRefusesStarts(t *testing.T) {
+	stubborn := ServiceConfig{
+		Name:        "stubborn",
+		Exec:        "/bin/sh",
+		Params:      []string{"-c", "trap '' TERM; sleep 30 & wait; wait"},
+		StopTimeout: 2 * time.Second,
+	}
+	other := sleeper("other")
+	m := runManagerOn(t, SystemClock, stubborn, other)
+
+	eventually(t, 5*time.Second, "both running", func() bool {
+		return status(t, m, stubborn.Name).State == StateRunning && status(t, m, other.Name).State == StateRunning
+	})
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- m.Shutdown() }()
+
+	// the stubborn task holds the shutdown for its stop timeout
+	eventually(t, 5*time.Second, "the barrier", func() bool {
+		return errors.Is(m.Restart(other.Name), ErrShuttingDown)
+	})
+	for _, start := range []func(string) error{m.Start, m.Restart} {
+		if err := start(other.Name); !errors.Is(err, ErrShuttingDown) {
+			t.Fatalf("start during the shutdown: %v, want ErrShuttingDown", err)
+		}
+	}
+
+	if err := <-shutdown; err != nil {
+		t.Fatalf("shutdown: %s", err)
+	}
+	if err := m.Start(other.Name); err == nil {
+		t.Fatal("start after the shutdown")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build unix
+
+package system
+
+import "syscall"
+
+// signals are the names ParseSignal knows, of the stop and promote signals
+var signals = map[string]syscall.Signal{
+	"SIGHUP":   syscall.SIGHUP,
+	"SIGINT":   syscall.SIGINT,
+	"SIGQUIT":  syscall.SIGQUIT,
+	"SIGUSR1":  syscall.SIGUSR1,
+	"SIGUSR2":  syscall.SIGUSR2,
+	"SIGTERM":  syscall.SIGTERM,
+	"SIGWINCH": syscall.SIGWINCH,
+}
@@ -0,0 +1,12 @@
+package system
+
+import "syscall"
+
+// signals are the names ParseSignal knows, the signals windows has besides
+// SIGKILL. Any of them stops a process with a console ctrl-break
+var signals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGTERM": syscall.SIGTERM,
+}
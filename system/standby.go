@@ -0,0 +1,459 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// roles of the members of a failover pair, only the active one becomes Ready
+const (
+	ROLE_ACTIVE  = "active"
+	ROLE_PASSIVE = "passive"
+)
+
+// PRIMARY_ENV names the primary to the promote command of a standby, the pid of
+// the promoted process is in MAINPID
+const (
+	PRIMARY_ENV = "SYSTEMGO_PRIMARY"
+	MAINPID_ENV = "MAINPID"
+)
+
+var (
+	ErrInvalidStandby = errors.New("invalid standbyOf")
+	ErrNoStandby      = errors.New("no standby available")
+)
+
+// ParseSignal returns the signal of a name like "SIGUSR1" or "USR1"
+func ParseSignal(name string) (syscall.Signal, error) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	if sig, ok := signals[name]; ok {
+		return sig, nil
+	}
+
+	return 0, fmt.Errorf("unknown signal: %s", name)
+}
+
+// failover is a primary with its standbys, the active member is the one that is
+// Ready, the others run passive. busy is set while a failover is in progress,
+// again asks it to look once more when it is done. stranded tells the failed
+// active member had no standby to take over, it is reported once
+type failover struct {
+	primary  string
+	active   string
+	members  map[string]bool
+	busy     bool
+	again    bool
+	stranded bool
+}
+
+// standbys are the failover pairs of the manager by the name of their primary
+type standbys struct {
+	mu        sync.Mutex
+	byPrimary map[string]*failover
+}
+
+// connect makes the service a member of the pair of its StandbyOf, and only of it
+func (r *standbys) connect(config ServiceConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for primary, f := range r.byPrimary {
+		if primary != config.StandbyOf && f.members[config.Name] {
+			r.leave(f, config.Name)
+		}
+	}
+
+	if config.StandbyOf == "" {
+		return
+	}
+
+	if r.byPrimary == nil {
+		r.byPrimary = make(map[string]*failover)
+	}
+
+	f := r.byPrimary[config.StandbyOf]
+	if f == nil {
+		f = &failover{primary: config.StandbyOf, active: config.StandbyOf, members: make(map[string]bool)}
+		r.byPrimary[config.StandbyOf] = f
+	}
+	f.members[config.Name] = true
+}
+
+// leave drops the standby from the pair, the primary is active again if the
+// standby was, must be called holding r.mu
+func (r *standbys) leave(f *failover, name string) {
+	delete(f.members, name)
+	if f.active == name {
+		f.active = f.primary
+	}
+
+	if len(f.members) == 0 {
+		delete(r.byPrimary, f.primary)
+	}
+}
+
+// forget drops the pair of a primary, or the standby from its pair
+func (r *standbys) forget(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.byPrimary, name)
+	for _, f := range r.byPrimary {
+		if f.members[name] {
+			r.leave(f, name)
+		}
+	}
+}
+
+// role returns the role of the service in its pair, empty if it is not in one
+func (r *standbys) role(name, standbyOf string) string {
+	if r == nil {
+		return ""
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	primary := standbyOf
+	if primary == "" {
+		primary = name
+	}
+
+	f := r.byPrimary[primary]
+	if f == nil || (standbyOf != "" && !f.members[name]) {
+		return ""
+	}
+
+	if f.active == name {
+		return ROLE_ACTIVE
+	}
+
+	return ROLE_PASSIVE
+}
+
+func (r *standbys) setActive(primary, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f := r.byPrimary[primary]; f != nil {
+		f.active = name
+		f.stranded = false
+	}
+}
+
+// strand marks the pair without a standby for its failed active member, it
+// reports whether it was not marked already
+func (r *standbys) strand(primary string, stranded bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f := r.byPrimary[primary]
+	if f == nil || f.stranded == stranded {
+		return false
+	}
+	f.stranded = stranded
+
+	return true
+}
+
+// pair returns the active member and the others, the primary first, then the
+// standbys by name
+func (r *standbys) pair(primary string) (string, []string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f := r.byPrimary[primary]
+	if f == nil {
+		return "", nil, false
+	}
+
+	var standbys []string
+	for name := range f.members {
+		standbys = append(standbys, name)
+	}
+	sort.Strings(standbys)
+
+	var others []string
+	for _, name := range append([]string{f.primary}, standbys...) {
+		if name != f.active {
+			others = append(others, name)
+		}
+	}
+
+	return f.active, others, true
+}
+
+// claim marks a failover of the pair in progress, a failover claimed already is
+// asked to look again instead
+func (r *standbys) claim(primary string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f := r.byPrimary[primary]
+	switch {
+	case f == nil:
+		return false
+	case f.busy:
+		f.again = true
+		return false
+	}
+
+	f.busy = true
+
+	return true
+}
+
+// release ends a claimed failover, unless it is to look again
+func (r *standbys) release(primary string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f := r.byPrimary[primary]
+	if f == nil {
+		return true
+	}
+
+	if f.again {
+		f.again = false
+		return false
+	}
+	f.busy = false
+
+	return true
+}
+
+// validateStandbys refuses StandbyOf of unknown services, of standbys, of groups
+// and promotions set both ways
+func validateStandbys(configs []ServiceConfig) error {
+	byName := make(map[string]ServiceConfig, len(configs))
+	for _, config := range configs {
+		byName[config.Name] = config
+	}
+
+	for _, config := range configs {
+		if config.PromoteSignal != "" {
+			if _, err := ParseSignal(config.PromoteSignal); err != nil {
+				return fmt.Errorf("%s: %w: %s", config.Name, ErrInvalidStandby, err)
+			}
+		}
+
+		if config.StandbyOf == "" {
+			continue
+		}
+
+		primary, ok := byName[config.StandbyOf]
+		switch {
+		case !ok:
+			return fmt.Errorf("%s: %w: no service %s", config.Name, ErrInvalidStandby, config.StandbyOf)
+		case config.StandbyOf == config.Name:
+			return fmt.Errorf("%s: %w: a service is no standby of itself", config.Name, ErrInvalidStandby)
+		case primary.StandbyOf != "":
+			return fmt.Errorf("%s: %w: %s is a standby itself", config.Name, ErrInvalidStandby, config.StandbyOf)
+		case IsTemplate(config.Name) || config.Replicas > 0 || IsTemplate(primary.Name) || primary.Replicas > 0:
+			return fmt.Errorf("%s: %w: templates and replicas have no standbys", config.Name, ErrInvalidStandby)
+		case config.PromoteExec != "" && config.PromoteSignal != "":
+			return fmt.Errorf("%s: %w: promoteExec and promoteSignal are both set", config.Name, ErrInvalidStandby)
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) role() string {
+	return s.standbys.role(s.Name, s.StandbyOf)
+}
+
+// primary is the name of the failover pair of the service
+func (s *Service) primary() string {
+	if s.StandbyOf != "" {
+		return s.StandbyOf
+	}
+
+	return s.Name
+}
+
+// isStandbyReady reports whether the service can be promoted: running passive,
+// and past its readiness probe if it has one
+func (s *Service) isStandbyReady() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.state == StateRunning && s.running != nil && s.running.Running() &&
+		(!s.hasReadinessCheck() || s.standbyReady)
+}
+
+// hasFailed reports whether the last run of the service ended without anyone
+// asking for it and no run took its place yet
+func (s *Service) hasFailed() bool {
+	status := s.Status()
+
+	switch status.State {
+	case StateFailed, StateStopFailed:
+		return true
+	case StateFinished, StateRestarting, StatePendingRestart:
+		return status.LastStopReason.IsInvoluntary()
+	}
+
+	return false
+}
+
+// promote runs the promote command of the service or signals its process, then
+// marks it Ready, a readiness probe still pending does so once it passes
+func (s *Service) promote() error {
+	if !s.IsRunning() {
+		return fmt.Errorf("%s is not running", s.Name)
+	}
+
+	pid := s.running.GetPid()
+	switch {
+	case s.PromoteSignal != "":
+		sig, err := ParseSignal(s.PromoteSignal)
+		if err != nil {
+			return err
+		}
+
+		if err := s.running.cmd.Process.Signal(sig); err != nil {
+			return fmt.Errorf("failed to signal %s: %w", sig, err)
+		}
+
+	case s.PromoteExec != "":
+		hook := Hook{Name: "promote", Exec: s.PromoteExec, Params: s.PromoteParams}
+		hook.Env = []string{fmt.Sprintf("%s=%d", MAINPID_ENV, pid), PRIMARY_ENV + "=" + s.primary()}
+		if err := hook.run(context.Background()); err != nil {
+			return fmt.Errorf("promote failed: %w", err)
+		}
+	}
+
+	s.log().infof("promoted")
+	s.note(JournalEntry{Type: JOURNAL_PROMOTED, PID: pid})
+
+	s.mu.RLock()
+	probed := !s.hasReadinessCheck() || s.standbyReady
+	s.mu.RUnlock()
+
+	if probed && s.state != StateReady {
+		s.setState(StateReady)
+	}
+
+	return nil
+}
+
+// failover looks at the pair of the primary after a state change of a member:
+// a failed active member gives way to a passive one ready to take over, an
+// active standby with DemoteOnRecovery to the primary once it is ready again.
+// Failovers of a pair run one at a time, a change during one is looked at after
+func (m *Manager) failover(primary string) {
+	if !m.standbys.claim(primary) {
+		return
+	}
+
+	for {
+		m.failoverOnce(primary)
+
+		if m.standbys.release(primary) {
+			return
+		}
+	}
+}
+
+func (m *Manager) failoverOnce(primary string) {
+	active, others, ok := m.standbys.pair(primary)
+	if !ok {
+		return
+	}
+
+	m.mu.Lock()
+	if m.isShuttingDown() {
+		m.mu.Unlock()
+		return
+	}
+
+	current := m.find(active)
+	var candidates []*Service
+	for _, name := range others {
+		if service := m.find(name); service != nil {
+			candidates = append(candidates, service)
+		}
+	}
+	m.mu.Unlock()
+
+	if current == nil {
+		return
+	}
+
+	if current.hasFailed() {
+		m.promote(primary, current, candidates)
+		return
+	}
+	m.standbys.strand(primary, false)
+
+	// the primary taking over again from a standby that is not failed
+	if active == primary || !current.DemoteOnRecovery {
+		return
+	}
+
+	for _, candidate := range candidates {
+		if candidate.Name != primary || !candidate.isStandbyReady() {
+			continue
+		}
+
+		m.standbys.setActive(primary, primary)
+		if err := candidate.send(commandPromote); err != nil {
+			m.standbys.setActive(primary, active)
+			managerLog.with(FIELD_SERVICE, primary).warnf("failed to take over from %s: %s", active, err)
+			return
+		}
+
+		managerLog.with(FIELD_SERVICE, primary).infof("recovered, demoting %s", active)
+		m.events.Publish(Event{Service: primary, State: StateReady, Time: time.Now(), Message: fmt.Sprintf("took over from %s", active)})
+
+		// started again it runs passive
+		if err := current.send(commandRestart); err != nil && err != ErrNotRunning {
+			managerLog.with(FIELD_SERVICE, active).errorf("%s", err)
+		}
+
+		return
+	}
+}
+
+// promote makes the first candidate ready to take over the active one, the
+// failed member stays active if none of them is
+func (m *Manager) promote(primary string, failed *Service, candidates []*Service) {
+	for _, candidate := range candidates {
+		if !candidate.isStandbyReady() {
+			continue
+		}
+
+		// set first, a readiness probe passing meanwhile marks it Ready
+		m.standbys.setActive(primary, candidate.Name)
+		if err := candidate.send(commandPromote); err != nil {
+			m.standbys.setActive(primary, failed.Name)
+			managerLog.with(FIELD_SERVICE, primary).warnf("failed to promote %s: %s", candidate.Name, err)
+			continue
+		}
+
+		message := fmt.Sprintf("promoted, %s failed", failed.Name)
+		managerLog.with(FIELD_SERVICE, candidate.Name).warnf("%s", message)
+		m.events.Publish(Event{Service: candidate.Name, State: StateReady, Time: time.Now(), Level: EVENT_LEVEL_WARN, Message: message})
+
+		return
+	}
+
+	if !m.standbys.strand(primary, true) {
+		return
+	}
+
+	message := fmt.Sprintf("%s failed: %s", failed.Name, ErrNoStandby)
+	managerLog.with(FIELD_SERVICE, primary).warnf("%s", message)
+	m.events.Publish(Event{Service: primary, State: failed.getState(), Time: time.Now(), Level: EVENT_LEVEL_WARN, Message: message})
+}
@@ -0,0 +1,275 @@
+package system
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pairOf is a primary that is not restarted and its standbys, each one a
+// sleeping task
+func pairOf(standbys ...string) []ServiceConfig {
+	primary := sleeper("db")
+	primary.RestartPolicy = RESTART_NEVER
+
+	configs := []ServiceConfig{primary}
+	for _, name := range standbys {
+		standby := sleeper(name)
+		standby.StandbyOf = "db"
+		standby.RestartPolicy = RESTART_NEVER
+		configs = append(configs, standby)
+	}
+
+	return configs
+}
+
+// roleIs waits for the role and the state of the service
+func roleIs(t *testing.T, m *Manager, name, role string, state State) {
+	t.Helper()
+
+	eventually(t, 5*time.Second, name+" "+role+" "+state.String(), func() bool {
+		status := status(t, m, name)
+		return status.Role == role && status.State == state
+	})
+}
+
+// failoverEvent waits for the next event of the failover pair with a message
+// starting with prefix
+func failoverEvent(t *testing.T, events <-chan Event, prefix string) Event {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-events:
+			if strings.HasPrefix(event.Message, prefix) {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("no event %q", prefix)
+		}
+	}
+}
+
+func TestValidateStandbys(t *testing.T) {
+	standby := func(name, of string) ServiceConfig {
+		return ServiceConfig{Name: name, Exec: "sleep", StandbyOf: of}
+	}
+
+	if err := validateStandbys(append(pairOf("db-a", "db-b"), ServiceConfig{Name: "web", PromoteSignal: "usr1"})); err != nil {
+		t.Fatalf("two standbys: %s", err)
+	}
+
+	both := standby("db-standby", "db")
+	both.PromoteExec, both.PromoteSignal = "promote", "SIGUSR1"
+	signal := standby("db-standby", "db")
+	signal.PromoteSignal = "SIGNOPE"
+	replicas := standby("db-standby", "db")
+	replicas.Replicas = 2
+
+	for name, configs := range map[string][]ServiceConfig{
+		"unknown primary":    {standby("db-standby", "db")},
+		"itself":             {standby("db", "db")},
+		"standby of standby": {sleeper("db"), standby("db-a", "db"), standby("db-b", "db-a")},
+		"template":           {sleeper("db"), standby("db-standby@", "db")},
+		"replicas":           {sleeper("db"), replicas},
+		"both promotions":    {sleeper("db"), both},
+		"unknown signal":     {sleeper("db"), signal},
+	} {
+		if err := validateStandbys(configs); !errors.Is(err, ErrInvalidStandby) {
+			t.Errorf("%s: %v, want ErrInvalidStandby", name, err)
+		}
+	}
+}
+
+func TestStandbyPromotedByExec(t *testing.T) {
+	promoted := filepath.Join(t.TempDir(), "promoted")
+	configs := pairOf("db-standby")
+	configs[1].PromoteExec = "/bin/sh"
+	configs[1].PromoteParams = []string{"-c", `echo "$MAINPID $SYSTEMGO_PRIMARY" > "$0"`, promoted}
+
+	m, _ := runManager(t, configs...)
+	events := m.Subscribe()
+	defer m.Unsubscribe(events)
+
+	roleIs(t, m, "db", ROLE_ACTIVE, StateRunning)
+	roleIs(t, m, "db-standby", ROLE_PASSIVE, StateRunning)
+	standby, _ := m.GetService("db-standby")
+	if standby.isUp() {
+		t.Fatal("passive standby is up")
+	}
+
+	killed(t, m, "db")
+	event := failoverEvent(t, events, "promoted")
+	if event.Service != "db-standby" || event.Level != EVENT_LEVEL_WARN || event.Message != "promoted, db failed" {
+		t.Fatalf("event %+v, want a warning of the promotion", event)
+	}
+	roleIs(t, m, "db-standby", ROLE_ACTIVE, StateReady)
+
+	pid := status(t, m, "db-standby").PID
+	if data, _ := ioutil.ReadFile(promoted); strings.TrimSpace(string(data)) != strconv.Itoa(pid)+" db" {
+		t.Fatalf("promote command saw %q, want the pid %d of the standby and the primary", data, pid)
+	}
+	journal, _ := m.GetJournal("db-standby", 0)
+	if last := journal[len(journal)-1]; last.Type != JOURNAL_PROMOTED || last.PID != pid {
+		t.Fatalf("journal ends with %+v, want the promotion", last)
+	}
+
+	// the primary started again is the standby now
+	if err := m.Start("db"); err != nil {
+		t.Fatalf("start: %s", err)
+	}
+	roleIs(t, m, "db", ROLE_PASSIVE, StateRunning)
+	stays(t, m, "db-standby", ServiceStatus{State: StateReady, Runs: 1})
+}
+
+func TestStandbyPromotedBySignal(t *testing.T) {
+	configs := pairOf("db-standby")
+	configs[1].Exec = "/bin/sh"
+	configs[1].Params = []string{"-c", `trap 'echo promoted' USR1; echo passive; while true; do sleep 1 & wait $!; done`}
+	configs[1].PromoteSignal = "SIGUSR1"
+
+	m, _ := runManager(t, configs...)
+	standby, _ := m.GetService("db-standby")
+	printed := func(line string) bool {
+		for _, l := range standby.RecentOutput(0) {
+			if strings.HasSuffix(l.Text, line) {
+				return true
+			}
+		}
+		return false
+	}
+	running(t, m, "db")
+	eventually(t, 5*time.Second, "the trap set", func() bool { return printed("passive") })
+
+	killed(t, m, "db")
+	roleIs(t, m, "db-standby", ROLE_ACTIVE, StateReady)
+	eventually(t, 5*time.Second, "the signal handled", func() bool { return printed("promoted") })
+	if runs := status(t, m, "db-standby").Runs; runs != 1 {
+		t.Fatalf("standby ran %d times, want it promoted in place", runs)
+	}
+}
+
+func TestNoStandbyAvailable(t *testing.T) {
+	m, _ := runManager(t, pairOf("db-standby")...)
+	events := m.Subscribe()
+	defer m.Unsubscribe(events)
+	running(t, m, "db", "db-standby")
+
+	if err := m.Stop("db-standby"); err != nil {
+		t.Fatalf("stop: %s", err)
+	}
+	roleIs(t, m, "db-standby", ROLE_PASSIVE, StateStopped)
+
+	killed(t, m, "db")
+	event := failoverEvent(t, events, "db failed")
+	if event.Service != "db" || event.Level != EVENT_LEVEL_WARN || !strings.HasSuffix(event.Message, ErrNoStandby.Error()) {
+		t.Fatalf("event %+v, want a warning of no standby", event)
+	}
+	roleIs(t, m, "db", ROLE_ACTIVE, StateFailed)
+
+	// a standby started later takes over, the missing one was reported once
+	if err := m.Start("db-standby"); err != nil {
+		t.Fatalf("start: %s", err)
+	}
+	if event := failoverEvent(t, events, "promoted"); event.Service != "db-standby" {
+		t.Fatalf("event %+v, want the promotion of the late standby", event)
+	}
+	roleIs(t, m, "db-standby", ROLE_ACTIVE, StateReady)
+
+	time.Sleep(100 * time.Millisecond)
+	for len(events) > 0 {
+		if event := <-events; strings.HasPrefix(event.Message, "db failed") {
+			t.Fatalf("missing standby reported twice: %+v", event)
+		}
+	}
+}
+
+func TestStandbyPromotionFailure(t *testing.T) {
+	configs := pairOf("db-a", "db-b")
+	configs[1].PromoteExec = "false"
+	m, _ := runManager(t, configs...)
+	events := m.Subscribe()
+	defer m.Unsubscribe(events)
+	running(t, m, "db", "db-a", "db-b")
+
+	// the first standby by name fails to take over, the next one does
+	killed(t, m, "db")
+	if event := failoverEvent(t, events, "promoted"); event.Service != "db-b" {
+		t.Fatalf("event %+v, want db-b promoted", event)
+	}
+	roleIs(t, m, "db-b", ROLE_ACTIVE, StateReady)
+	roleIs(t, m, "db-a", ROLE_PASSIVE, StateRunning)
+}
+
+func TestFailoverWhenMembersFailTogether(t *testing.T) {
+	// web keeps the manager running once the whole pair failed
+	m, _ := runManager(t, append(pairOf("db-a", "db-b"), sleeper("web"))...)
+	events := m.Subscribe()
+	defer m.Unsubscribe(events)
+	running(t, m, "db", "db-a", "db-b")
+
+	// the primary and a standby fail at once, the other standby is promoted once
+	killed(t, m, "db")
+	killed(t, m, "db-a")
+	event := failoverEvent(t, events, "promoted")
+	roleIs(t, m, "db-b", ROLE_ACTIVE, StateReady)
+	if event.Service != "db-b" && event.Service != "db-a" {
+		t.Fatalf("event %+v, want a standby promoted", event)
+	}
+
+	// db-a may have been promoted before it failed, then db-b took over from it
+	if event.Service == "db-a" {
+		if event := failoverEvent(t, events, "promoted"); event.Service != "db-b" || event.Message != "promoted, db-a failed" {
+			t.Fatalf("event %+v, want db-b taking over from db-a", event)
+		}
+	}
+	stays(t, m, "db-b", ServiceStatus{State: StateReady, Runs: 1})
+
+	// the last one failing too leaves the pair without a standby
+	killed(t, m, "db-b")
+	if event := failoverEvent(t, events, "db-b failed"); !strings.HasSuffix(event.Message, ErrNoStandby.Error()) {
+		t.Fatalf("event %+v, want no standby", event)
+	}
+	for _, name := range []string{"db", "db-a", "db-b"} {
+		if state := status(t, m, name).State; state != StateFailed {
+			t.Fatalf("%s %s, want failed", name, state)
+		}
+	}
+
+	// the primary started again runs passive, then takes over from db-b
+	if err := m.Start("db"); err != nil {
+		t.Fatalf("start: %s", err)
+	}
+	roleIs(t, m, "db", ROLE_ACTIVE, StateReady)
+}
+
+func TestDemoteOnRecovery(t *testing.T) {
+	for _, demote := range []bool{true, false} {
+		configs := pairOf("db-standby")
+		configs[0].RestartPolicy = RESTART_ALWAYS
+		configs[0].RestartDelay = time.Second
+		configs[1].DemoteOnRecovery = demote
+		m, clock := runManager(t, configs...)
+		running(t, m, "db", "db-standby")
+
+		killed(t, m, "db")
+		roleIs(t, m, "db-standby", ROLE_ACTIVE, StateReady)
+		advanceUntil(t, clock, time.Second, "db restarted", func() bool { return status(t, m, "db").Runs == 2 })
+
+		if !demote {
+			roleIs(t, m, "db", ROLE_PASSIVE, StateRunning)
+			stays(t, m, "db-standby", ServiceStatus{State: StateReady, Runs: 1})
+			continue
+		}
+
+		// the primary takes over once it runs, the standby is restarted passive
+		roleIs(t, m, "db", ROLE_ACTIVE, StateReady)
+		eventually(t, 5*time.Second, "the standby restarted", func() bool { return status(t, m, "db-standby").Runs == 2 })
+		roleIs(t, m, "db-standby", ROLE_PASSIVE, StateRunning)
+	}
+}
@@ -0,0 +1,198 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// isDone reports whether the channel is closed
+func isDone(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestStartReturnsWithTheProcess(t *testing.T) {
+	service := NewService(sleeper("started"))
+	if service.Done() != nil {
+		t.Fatal("done before the first start")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := service.Start(ctx, nil, nil); err != nil {
+		t.Fatalf("start: %s", err)
+	}
+
+	// no waiting, the process runs once Start returned
+	if status := service.Status(); status.State != StateRunning || status.PID == 0 || status.Runs != 1 {
+		t.Fatalf("status %+v after Start, want the process running", status)
+	}
+	if isDone(service.Done()) {
+		t.Fatal("loop done while the process runs")
+	}
+
+	cancel()
+	service.Wait()
+	if !isDone(service.Done()) || service.Status().State != StateStopped {
+		t.Fatalf("state %s after Wait, want the loop done and the service stopped", service.Status().State)
+	}
+}
+
+func TestStartErrorReturnedAtOnce(t *testing.T) {
+	plain := filepath.Join(t.TempDir(), "plain")
+	if err := ioutil.WriteFile(plain, []byte("sleep 30\n"), 0644); err != nil {
+		t.Fatalf("file: %s", err)
+	}
+
+	for _, c := range []struct {
+		name string
+		exec string
+	}{
+		{"missing program", "/nonexistent/program"},
+		{"not executable", plain},
+		{"directory", t.TempDir()},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			service := NewService(ServiceConfig{Name: "broken", Exec: c.exec, RestartDelay: time.Hour})
+			clock := onFakeClock(service)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer service.Wait()
+			defer cancel()
+
+			// the clock does not move, Start returns without waiting for a retry
+			if err := service.Start(ctx, nil, nil); err == nil || ClassifyStartError(err) != StartErrorPermanent {
+				t.Fatalf("start: %v, want a permanent start error", err)
+			}
+
+			status := service.Status()
+			if status.State != StateFailed || status.PID != 0 || status.Runs != 1 {
+				t.Fatalf("status %+v after the failed start", status)
+			}
+
+			// the loop goes on as configured, holding the service until it is started again
+			if isDone(service.Done()) {
+				t.Fatal("loop ended with the failed start")
+			}
+			clock.Advance(2 * time.Hour)
+			stayed := service.Status()
+			if stayed.State != StateFailed || stayed.Runs != 1 {
+				t.Fatalf("status %+v, want a permanent failure not restarted", stayed)
+			}
+		})
+	}
+}
+
+func TestStartTwice(t *testing.T) {
+	service := NewService(sleeper("twice"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := service.Start(ctx, nil, nil); err != nil {
+		t.Fatalf("start: %s", err)
+	}
+	pid := service.Status().PID
+
+	if err := service.Start(ctx, nil, nil); !errors.Is(err, ErrAlreadyStarted) {
+		t.Fatalf("second start: %v, want ErrAlreadyStarted", err)
+	}
+
+	// Run of a supervised service returns at once
+	ran := make(chan struct{})
+	go func() {
+		service.Run(context.Background(), nil, nil)
+		close(ran)
+	}()
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run blocked on a supervised service")
+	}
+	if status := service.Status(); status.PID != pid || status.Runs != 1 {
+		t.Fatalf("status %+v, want the first process alone", status)
+	}
+
+	// once the loop ended the service starts again, not waiting for a restart
+	cancel()
+	service.Wait()
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer service.Wait()
+	defer cancel()
+	if err := service.Start(ctx, nil, nil); err != nil {
+		t.Fatalf("start after the loop ended: %s", err)
+	}
+	if status := service.Status(); status.State != StateRunning || status.Runs != 2 {
+		t.Fatalf("status %+v, want a second run", status)
+	}
+}
+
+func TestStartOfAnInvalidService(t *testing.T) {
+	service := NewService(ServiceConfig{Name: "in valid", Exec: "sleep", Params: []string{"30"}})
+
+	if err := service.Start(context.Background(), nil, nil); !errors.Is(err, ErrInvalidName) {
+		t.Fatalf("start: %v, want ErrInvalidName", err)
+	}
+	if service.Done() != nil {
+		t.Fatal("loop started for an invalid name")
+	}
+
+	// neither waits for a loop that never ran
+	service.Wait()
+	service.Run(context.Background(), nil, nil)
+}
+
+func TestStartWithADoneContext(t *testing.T) {
+	service := NewService(sleeper("cancelled"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := service.Start(ctx, nil, nil); err != nil && !errors.Is(err, context.Canceled) {
+		t.Fatalf("start: %v, want nil or the context error", err)
+	}
+
+	select {
+	case <-service.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("loop running with a done context")
+	}
+	if status := service.Status(); status.PID != 0 {
+		t.Fatalf("process %d left after the loop ended", status.PID)
+	}
+}
+
+func TestRunBlocksUntilTheEnd(t *testing.T) {
+	service := NewService(sleeper("blocking"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := make(chan struct{})
+	go func() {
+		service.Run(ctx, nil, nil)
+		close(ran)
+	}()
+	eventually(t, 5*time.Second, "the run", func() bool { return service.Status().State == StateRunning })
+
+	select {
+	case <-ran:
+		t.Fatal("Run returned while the process runs")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return once ctx was done")
+	}
+	if !isDone(service.Done()) {
+		t.Fatal("Run returned before the loop ended")
+	}
+}
@@ -0,0 +1,88 @@
+package system
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// START_RETRIES is the default number of times a start failing transiently is retried
+const START_RETRIES = 3
+
+// START_RETRY_DELAY is the delay before the first retry of a start, doubled for
+// every next one
+const START_RETRY_DELAY = 100 * time.Millisecond
+
+// StartErrorClass tells whether a start that failed to fork or exec may succeed
+// if tried again
+type StartErrorClass int
+
+const (
+	// StartErrorUnknown fails the start, the service restarts as configured
+	StartErrorUnknown StartErrorClass = iota
+
+	// StartErrorTransient is retried up to StartRetries times before the start fails
+	StartErrorTransient
+
+	// StartErrorPermanent fails the service right away, it is not restarted until
+	// it is started again
+	StartErrorPermanent
+)
+
+var startErrorClassNames = map[StartErrorClass]string{
+	StartErrorUnknown:   "unknown",
+	StartErrorTransient: "transient",
+	StartErrorPermanent: "permanent",
+}
+
+func (c StartErrorClass) String() string {
+	return startErrorClassNames[c]
+}
+
+// StartErrorClasses classifies the errors fork and exec fail with, errors not
+// listed are unknown. It may be changed before the manager runs
+var StartErrorClasses = map[syscall.Errno]StartErrorClass{
+	// out of processes, memory or file descriptors, or the program is being written
+	syscall.EAGAIN:  StartErrorTransient,
+	syscall.ENOMEM:  StartErrorTransient,
+	syscall.EMFILE:  StartErrorTransient,
+	syscall.ENFILE:  StartErrorTransient,
+	syscall.ETXTBSY: StartErrorTransient,
+	syscall.EINTR:   StartErrorTransient,
+
+	// the program is missing or can not be run
+	syscall.ENOENT:  StartErrorPermanent,
+	syscall.EACCES:  StartErrorPermanent,
+	syscall.EPERM:   StartErrorPermanent,
+	syscall.ENOEXEC: StartErrorPermanent,
+	syscall.ENOTDIR: StartErrorPermanent,
+	syscall.EISDIR:  StartErrorPermanent,
+	syscall.ELOOP:   StartErrorPermanent,
+}
+
+// ClassifyStartError returns the class of the error a process failed to start
+// with by the errno it wraps, a program not found in PATH is permanent
+func ClassifyStartError(err error) StartErrorClass {
+	if errors.Is(err, exec.ErrNotFound) {
+		return StartErrorPermanent
+	}
+
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return StartErrorClasses[errno]
+	}
+
+	return StartErrorUnknown
+}
+
+func (s *Service) getStartRetries() int {
+	switch {
+	case s.StartRetries > 0:
+		return s.StartRetries
+	case s.StartRetries < 0:
+		return 0
+	}
+
+	return START_RETRIES
+}
@@ -0,0 +1,177 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// failingExec is a factory of sleeping commands whose first fails starts fail
+// with errno, calls counts the starts tried
+func failingExec(errno syscall.Errno, fails int) (CmdFactory, *int32) {
+	var calls int32
+
+	return func() (*exec.Cmd, error) {
+		cmd := exec.Command("sleep", "30")
+		if int(atomic.AddInt32(&calls, 1)) <= fails {
+			// Start returns the error of the command without forking
+			cmd.Err = &os.SyscallError{Syscall: "fork/exec", Err: errno}
+		}
+		return cmd, nil
+	}, &calls
+}
+
+// startAsync starts the supervision of the service, the first start may wait
+// for the clock, and returns the error of that start
+func startAsync(t *testing.T, service *Service) <-chan error {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		service.Wait()
+	})
+
+	started := make(chan error, 1)
+	go func() { started <- service.Start(ctx, nil, nil) }()
+
+	return started
+}
+
+func TestClassifyStartError(t *testing.T) {
+	for err, want := range map[error]StartErrorClass{
+		&os.SyscallError{Syscall: "fork/exec", Err: syscall.EAGAIN}: StartErrorTransient,
+		&os.PathError{Op: "fork/exec", Path: "/bin/db", Err: syscall.ETXTBSY}: StartErrorTransient,
+		fmt.Errorf("start: %w", syscall.ENOMEM):                            StartErrorTransient,
+		&os.PathError{Op: "fork/exec", Path: "/bin/db", Err: syscall.ENOENT}:  StartErrorPermanent,
+		&os.PathError{Op: "fork/exec", Path: "/etc/db", Err: syscall.EACCES}:  StartErrorPermanent,
+		&exec.Error{Name: "db", Err: exec.ErrNotFound}:                        StartErrorPermanent,
+		syscall.ECONNREFUSED:                                                  StartErrorUnknown,
+		errors.New("command factory: no database"):                           StartErrorUnknown,
+	} {
+		if got := ClassifyStartError(err); got != want {
+			t.Errorf("%v: %s, want %s", err, got, want)
+		}
+	}
+}
+
+func TestStartErrorClassesOverridden(t *testing.T) {
+	defer func(class StartErrorClass) { StartErrorClasses[syscall.EPERM] = class }(StartErrorClasses[syscall.EPERM])
+
+	// an EPERM of a seccomp profile that goes away is worth a retry
+	StartErrorClasses[syscall.EPERM] = StartErrorTransient
+	if class := ClassifyStartError(&os.SyscallError{Syscall: "fork/exec", Err: syscall.EPERM}); class != StartErrorTransient {
+		t.Fatalf("EPERM %s, want it transient once overridden", class)
+	}
+}
+
+func TestTransientStartRetried(t *testing.T) {
+	factory, calls := failingExec(syscall.EAGAIN, 2)
+	service := NewServiceFromCmdFactory("retried", factory, WithConfig(ServiceConfig{StopTimeout: time.Second}))
+	clock := onFakeClock(service)
+	started := startAsync(t, service)
+
+	// the retries back off on the clock of the service, 100ms then 200ms
+	advanceUntil(t, clock, START_RETRY_DELAY, "the second try", func() bool { return atomic.LoadInt32(calls) == 2 })
+	select {
+	case err := <-started:
+		t.Fatalf("start returned %v before the retries", err)
+	default:
+	}
+	advanceUntil(t, clock, 2*START_RETRY_DELAY, "the third try", func() bool { return atomic.LoadInt32(calls) == 3 })
+
+	if err := <-started; err != nil {
+		t.Fatalf("start: %s", err)
+	}
+
+	// the retries are a single run, the crash loop budget is untouched
+	status := service.Status()
+	if status.State != StateRunning || status.Runs != 1 {
+		t.Fatalf("status %+v, want the first run started by the third try", status)
+	}
+	if history := service.History(); len(history) != 0 {
+		t.Fatalf("history %+v of a start retried, want no failed run", history)
+	}
+}
+
+func TestTransientStartGivesUp(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		retries int
+		timeout time.Duration
+		tries   int32
+	}{
+		{"retries", 2, 0, 3},
+		{"no retries", -1, 0, 1},
+		// the third try would start past the start timeout
+		{"start timeout", 10, 250 * time.Millisecond, 2},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			factory, calls := failingExec(syscall.EAGAIN, 1000)
+			service := NewServiceFromCmdFactory("exhausted", factory, WithConfig(ServiceConfig{
+				StartRetries:  c.retries,
+				StartTimeout:  c.timeout,
+				RestartPolicy: RESTART_ALWAYS,
+				RestartDelay:  time.Hour,
+			}))
+			clock := onFakeClock(service)
+			started := startAsync(t, service)
+
+			var err error
+			eventually(t, 5*time.Second, "the start given up", func() bool {
+				select {
+				case err = <-started:
+					return true
+				default:
+					clock.Advance(10 * time.Millisecond)
+					return false
+				}
+			})
+
+			if ClassifyStartError(err) != StartErrorTransient || atomic.LoadInt32(calls) != c.tries {
+				t.Fatalf("start %v after %d tries, want a transient error after %d", err, atomic.LoadInt32(calls), c.tries)
+			}
+
+			// a failed start like any other, restarted as configured
+			if status := service.Status(); status.State != StateRestarting || status.Runs != 1 {
+				t.Fatalf("status %+v, want a restart planned", status)
+			}
+		})
+	}
+}
+
+func TestPermanentStartNotRestarted(t *testing.T) {
+	factory, calls := failingExec(syscall.ENOENT, 1)
+	service := NewServiceFromCmdFactory("missing", factory, WithConfig(ServiceConfig{
+		RestartPolicy: RESTART_ALWAYS,
+		RestartDelay:  time.Second,
+		StopTimeout:   time.Second,
+	}))
+	clock := onFakeClock(service)
+
+	if err := <-startAsync(t, service); ClassifyStartError(err) != StartErrorPermanent {
+		t.Fatalf("start: %v, want a permanent error", err)
+	}
+
+	// no retry and no restart, however long it waits
+	clock.Advance(time.Hour)
+	time.Sleep(50 * time.Millisecond)
+	if status := service.Status(); status.State != StateFailed || atomic.LoadInt32(calls) != 1 {
+		t.Fatalf("status %+v after %d tries, want failed after one", status, atomic.LoadInt32(calls))
+	}
+
+	// started again by hand it runs, the program is back
+	if err := service.send(commandStart); err != nil {
+		t.Fatalf("start: %s", err)
+	}
+	eventually(t, 5*time.Second, "the second start", func() bool { return service.Status().State == StateRunning })
+	if tries := atomic.LoadInt32(calls); tries != 2 {
+		t.Fatalf("%d tries, want the one started by hand", tries)
+	}
+}
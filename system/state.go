@@ -0,0 +1,149 @@
+package system
+
+import (
+	"fmt"
+	"sort"
+)
+
+type State int
+
+const (
+	StateNew State = iota
+	StateRunning
+	StateStopping
+	StateFinished
+	StateRestarting
+	StateStopped
+	StateReady
+	StateFailed
+	StateListening
+	StateStopFailed
+	StateFrozen
+	// StateNotReady is an external dependency failing its probe, or a service
+	// failing its readiness probe once it was ready
+	StateNotReady
+	// StatePendingRestart is a service waiting for a restart window to restart
+	StatePendingRestart
+	// StateScheduled is a scheduled service waiting for its next run
+	StateScheduled
+)
+
+var stateNames = map[State]string{
+	StateNew:        "new",
+	StateRunning:    "running",
+	StateStopping:   "stopping",
+	StateFinished:   "finished",
+	StateRestarting: "restarting",
+	StateStopped:    "stopped",
+	StateReady:      "ready",
+	StateFailed:     "failed",
+	StateListening:  "listening",
+	StateStopFailed: "stop-failed",
+	StateFrozen:     "frozen",
+	StateNotReady:   "not-ready",
+
+	StatePendingRestart: "pending-restart",
+	StateScheduled:      "scheduled",
+}
+
+// States returns all the states in order
+func States() []State {
+	states := make([]State, 0, len(stateNames))
+	for state := range stateNames {
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i] < states[j] })
+
+	return states
+}
+
+func (s State) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("state(%d)", int(s))
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *State) UnmarshalText(text []byte) error {
+	for state, name := range stateNames {
+		if name == string(text) {
+			*s = state
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown state: %s", text)
+}
+
+// StopReason tells what ended a process run
+type StopReason int
+
+const (
+	StopReasonUnknown StopReason = iota
+	StopReasonCrashed
+	StopReasonCompleted
+	StopReasonOperatorStop
+	StopReasonSupervisorShutdown
+	StopReasonLivenessFailed
+	StopReasonMemoryLimit
+	StopReasonWatchdogTimeout
+	StopReasonFileChanged
+	StopReasonIdle
+	StopReasonOutputTrigger
+	StopReasonHookFailed
+	StopReasonFailurePattern
+)
+
+var stopReasonNames = map[StopReason]string{
+	StopReasonUnknown:            "unknown",
+	StopReasonCrashed:            "crashed",
+	StopReasonCompleted:          "completed",
+	StopReasonOperatorStop:       "operator-stop",
+	StopReasonSupervisorShutdown: "supervisor-shutdown",
+	StopReasonLivenessFailed:     "liveness-failed",
+	StopReasonMemoryLimit:        "memory-limit",
+	StopReasonWatchdogTimeout:    "watchdog-timeout",
+	StopReasonFileChanged:        "file-changed",
+	StopReasonIdle:               "idle",
+	StopReasonOutputTrigger:      "output-trigger",
+	StopReasonHookFailed:         "hook-failed",
+	StopReasonFailurePattern:     "failure-pattern",
+}
+
+// IsInvoluntary reports whether the run ended without anyone asking for it
+func (r StopReason) IsInvoluntary() bool {
+	switch r {
+	case StopReasonCrashed, StopReasonLivenessFailed, StopReasonMemoryLimit, StopReasonWatchdogTimeout, StopReasonHookFailed, StopReasonFailurePattern:
+		return true
+	}
+
+	return false
+}
+
+func (r StopReason) String() string {
+	if name, ok := stopReasonNames[r]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("reason(%d)", int(r))
+}
+
+func (r StopReason) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *StopReason) UnmarshalText(text []byte) error {
+	for reason, name := range stopReasonNames {
+		if name == string(text) {
+			*r = reason
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown stop reason: %s", text)
+}
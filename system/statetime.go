@@ -0,0 +1,57 @@
+package system
+
+import "time"
+
+// stateClock sums the time a service spent in each state on Monotonic readings of
+// the clock of the service, so wall clock jumps do not count
+type stateClock struct {
+	since     time.Duration
+	started   bool
+	durations map[State]time.Duration
+}
+
+// start counts from now unless the clock runs already
+func (c *stateClock) start(now time.Duration) {
+	if !c.started {
+		c.since, c.started = now, true
+	}
+}
+
+// enter counts the time since the last transition to state and starts counting
+// the next one, the first call only starts the clock
+func (c *stateClock) enter(state State, now time.Duration) {
+	if c.started {
+		if c.durations == nil {
+			c.durations = make(map[State]time.Duration)
+		}
+
+		c.durations[state] += now - c.since
+	}
+
+	c.since, c.started = now, true
+}
+
+// read returns the durations with the time spent in the current state so far
+func (c *stateClock) read(current State, now time.Duration) map[State]time.Duration {
+	durations := make(map[State]time.Duration, len(c.durations)+1)
+	for state, d := range c.durations {
+		durations[state] = d
+	}
+
+	if c.started {
+		durations[current] += now - c.since
+	}
+
+	return durations
+}
+
+// StateDurations returns the time spent in each state since the service was
+// first supervised, the current state included, states never entered are left out
+func (s *Service) StateDurations() map[State]time.Duration {
+	now := s.getClock().Monotonic()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.stateTime.read(s.state, now)
+}
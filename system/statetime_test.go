@@ -0,0 +1,149 @@
+package system
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// spent returns the durations of the states the service entered, without the
+// ones it passed through at once
+func spent(service *Service) map[State]time.Duration {
+	durations := service.StateDurations()
+	for state, d := range durations {
+		if d == 0 {
+			delete(durations, state)
+		}
+	}
+
+	return durations
+}
+
+// killRun kills the running process of the service and waits for the state
+// the supervision moves it to
+func killRun(t *testing.T, service *Service, state State) {
+	t.Helper()
+
+	process, err := os.FindProcess(service.Status().PID)
+	if err != nil {
+		t.Fatalf("process: %s", err)
+	}
+	if err := process.Kill(); err != nil {
+		t.Fatalf("kill: %s", err)
+	}
+	eventually(t, 5*time.Second, state.String(), func() bool { return service.Status().State == state })
+}
+
+func TestStateDurationsOfALifecycle(t *testing.T) {
+	service := NewService(ServiceConfig{
+		Name:   "lifecycle",
+		Exec:   "/bin/sh",
+		Params: []string{"-c", "trap '' TERM; exec sleep 30"},
+		// the stop is forced after the stop timeout
+		StopTimeout:   2 * time.Second,
+		RestartPolicy: RESTART_ALWAYS,
+		RestartDelay:  5 * time.Second,
+	})
+	clock := onFakeClock(service)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer service.Wait()
+	defer cancel()
+	go service.Run(ctx, nil, nil)
+
+	// the clock only moves here, a transition in between takes no time
+	eventually(t, 5*time.Second, "the first run", func() bool { return service.Status().State == StateRunning })
+	clock.Advance(10 * time.Second)
+
+	killRun(t, service, StateRestarting)
+	clock.Advance(5 * time.Second)
+	eventually(t, 5*time.Second, "the second run", func() bool {
+		status := service.Status()
+		return status.State == StateRunning && status.Runs == 2
+	})
+
+	// a step of the wall clock is not a time spent in a state
+	clock.Jump(time.Hour)
+	clock.Advance(3 * time.Second)
+
+	go service.send(commandStop)
+	eventually(t, 5*time.Second, "the stop", func() bool { return service.Status().State == StateStopping })
+	clock.Advance(2 * time.Second)
+	eventually(t, 5*time.Second, "the forced stop", func() bool { return service.Status().State == StateStopped })
+
+	// the current state counts up to the read
+	clock.Advance(4 * time.Second)
+
+	want := map[State]time.Duration{
+		StateRunning:    13 * time.Second,
+		StateRestarting: 5 * time.Second,
+		StateStopping:   2 * time.Second,
+		StateStopped:    4 * time.Second,
+	}
+	if got := spent(service); !reflect.DeepEqual(got, want) {
+		t.Fatalf("durations %v, want %v", got, want)
+	}
+	if got := service.Status().StateDurations; !reflect.DeepEqual(got, service.StateDurations()) {
+		t.Fatalf("durations of the status %v, want %v", got, service.StateDurations())
+	}
+}
+
+func TestStateDurationsOfAFailure(t *testing.T) {
+	service := NewService(ServiceConfig{
+		Name:          "failing",
+		Exec:          "sleep",
+		Params:        []string{"30"},
+		RestartPolicy: RESTART_NEVER,
+	})
+	clock := onFakeClock(service)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer service.Wait()
+	defer cancel()
+	go service.Run(ctx, nil, nil)
+
+	eventually(t, 5*time.Second, "the run", func() bool { return service.Status().State == StateRunning })
+	clock.Advance(4 * time.Second)
+
+	killRun(t, service, StateFailed)
+	clock.Advance(7 * time.Second)
+	want := map[State]time.Duration{StateRunning: 4 * time.Second, StateFailed: 7 * time.Second}
+	if got := spent(service); !reflect.DeepEqual(got, want) {
+		t.Fatalf("durations %v, want %v", got, want)
+	}
+
+	// the failed state goes on counting without a supervision loop
+	service.Wait()
+	clock.Advance(time.Minute)
+	want[StateFailed] += time.Minute
+	if got := spent(service); !reflect.DeepEqual(got, want) {
+		t.Fatalf("durations %v after the loop ended, want %v", got, want)
+	}
+}
+
+func TestStateClock(t *testing.T) {
+	var c stateClock
+	if got := c.read(StateNew, time.Hour); len(got) != 0 {
+		t.Fatalf("durations %v of a clock not started", got)
+	}
+
+	// a reading of zero is a start like any other
+	c.start(0)
+	c.start(time.Second)
+	c.enter(StateNew, 2*time.Second)
+	c.enter(StateRunning, 5*time.Second)
+	c.enter(StateRestarting, 5*time.Second)
+
+	want := map[State]time.Duration{StateNew: 2 * time.Second, StateRunning: 3 * time.Second, StateRestarting: 0, StateStopping: time.Second}
+	if got := c.read(StateStopping, 6*time.Second); !reflect.DeepEqual(got, want) {
+		t.Fatalf("durations %v, want %v", got, want)
+	}
+
+	// a read does not change the clock
+	want[StateStopping] = 2 * time.Second
+	if got := c.read(StateStopping, 7*time.Second); !reflect.DeepEqual(got, want) {
+		t.Fatalf("durations %v of a second read, want %v", got, want)
+	}
+}
@@ -0,0 +1,113 @@
+package system
+
+import (
+	"os"
+	"runtime"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// proc_pidinfo(pid, PROC_PIDTASKINFO) of libproc is the proc_info syscall with
+// PROC_INFO_CALL_PIDINFO, what ps reads the memory and cpu times with
+const (
+	PROC_INFO_CALL_PIDINFO = 2
+	PROC_PIDTASKINFO       = 4
+)
+
+// PROC_STATE_ZOMBIE is SZOMB, the p_stat of a process waiting to be reaped
+const PROC_STATE_ZOMBIE = 5
+
+// procTaskInfo is struct proc_taskinfo of <sys/proc_info.h>, the times are in
+// mach absolute time units
+type procTaskInfo struct {
+	VirtualSize      uint64
+	ResidentSize     uint64
+	TotalUser        uint64
+	TotalSystem      uint64
+	ThreadsUser      uint64
+	ThreadsSystem    uint64
+	Policy           int32
+	Faults           int32
+	Pageins          int32
+	CowFaults        int32
+	MessagesSent     int32
+	MessagesReceived int32
+	SyscallsMach     int32
+	SyscallsUnix     int32
+	Csw              int32
+	Threadnum        int32
+	Numrunning       int32
+	Priority         int32
+}
+
+// processStats reads the kinfo_proc of pid with sysctl and its task info, the
+// task info of a process of another user is not given without root and its
+// cpu times and memory are left zero
+func processStats(pid int, memory bool) (ProcessStats, error) {
+	kinfo, err := unix.SysctlKinfoProc("kern.proc.pid", pid)
+	if err != nil {
+		return ProcessStats{}, err
+	}
+
+	stats := kinfoStats(kinfo)
+
+	var info procTaskInfo
+	size := unsafe.Sizeof(info)
+	n, _, errno := unix.Syscall6(unix.SYS_PROC_INFO, PROC_INFO_CALL_PIDINFO, uintptr(pid), PROC_PIDTASKINFO, 0, uintptr(unsafe.Pointer(&info)), size)
+	if errno != 0 || n != size {
+		return stats, nil
+	}
+
+	stats.UserCPU, stats.SystemCPU = machDuration(info.TotalUser), machDuration(info.TotalSystem)
+	if memory {
+		stats.RSSKB = info.ResidentSize / 1024
+	}
+
+	return stats, nil
+}
+
+func kinfoStats(kinfo *unix.KinfoProc) ProcessStats {
+	return ProcessStats{
+		PID:    int(kinfo.Proc.P_pid),
+		PPID:   int(kinfo.Eproc.Ppid),
+		PGID:   int(kinfo.Eproc.Pgid),
+		Name:   unix.ByteSliceToString(kinfo.Proc.P_comm[:]),
+		Start:  uint64(kinfo.Proc.P_starttime.Sec)*1000000 + uint64(kinfo.Proc.P_starttime.Usec),
+		Exited: kinfo.Proc.P_stat == PROC_STATE_ZOMBIE,
+	}
+}
+
+// machDuration converts mach absolute time units, nanoseconds on intel and
+// 125/3 ns on apple silicon
+func machDuration(units uint64) time.Duration {
+	if runtime.GOARCH == "arm64" {
+		return time.Duration(units * 125 / 3)
+	}
+
+	return time.Duration(units)
+}
+
+// listProcesses returns every process with sysctl, without the task info
+func listProcesses() ([]ProcessStats, error) {
+	kinfos, err := unix.SysctlKinfoProcSlice("kern.proc.all")
+	if err != nil {
+		return nil, err
+	}
+
+	processes := make([]ProcessStats, 0, len(kinfos))
+	for i := range kinfos {
+		processes = append(processes, kinfoStats(&kinfos[i]))
+	}
+
+	return processes, nil
+}
+
+// platformCapabilities reads processes and their memory with sysctl and
+// proc_info, there is no /proc
+func platformCapabilities(c *ProcCapabilities) {
+	stats, err := processStats(os.Getpid(), true)
+	c.Processes = err == nil && stats.PPID == os.Getppid()
+	c.Memory = err == nil && stats.RSSKB > 0
+}
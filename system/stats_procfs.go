@@ -0,0 +1,75 @@
+//go:build !darwin && !windows
+
+package system
+
+import (
+	"io/ioutil"
+	"strconv"
+	"time"
+)
+
+// CLOCK_TICKS is USER_HZ, the unit of cpu times in /proc/<pid>/stat
+const CLOCK_TICKS = 100
+
+// processStats reads /proc/<pid>/stat, and /proc/<pid>/status for the memory
+func processStats(pid int, memory bool) (ProcessStats, error) {
+	fs := procFS()
+	stat, err := fs.Stat(pid)
+	if err != nil {
+		return ProcessStats{}, err
+	}
+
+	stats := ProcessStats{
+		PID:       pid,
+		PPID:      stat.PPID,
+		PGID:      stat.PGRP,
+		Name:      stat.Comm,
+		Start:     stat.StartTime,
+		Exited:    stat.State == 'Z',
+		UserCPU:   ticksDuration(stat.UTime),
+		SystemCPU: ticksDuration(stat.STime),
+	}
+
+	if !memory {
+		return stats, nil
+	}
+
+	status, err := fs.Status(pid)
+	if err != nil {
+		return ProcessStats{}, err
+	}
+	stats.RSSKB, stats.PeakRSSKB = status.VmRSS, status.VmHWM
+
+	return stats, nil
+}
+
+// listProcesses reads the stat of every process of /proc, processes exiting
+// meanwhile are left out
+func listProcesses() ([]ProcessStats, error) {
+	entries, err := ioutil.ReadDir(procPath(""))
+	if err != nil {
+		return nil, err
+	}
+
+	processes := make([]ProcessStats, 0, len(entries))
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		if stats, err := processStats(pid, false); err == nil {
+			processes = append(processes, stats)
+		}
+	}
+
+	return processes, nil
+}
+
+func ticksDuration(ticks uint64) time.Duration {
+	return time.Duration(ticks) * time.Second / CLOCK_TICKS
+}
+
+// platformCapabilities leaves the capabilities to /proc, the BSDs may mount
+// theirs as linprocfs
+func platformCapabilities(c *ProcCapabilities) {}
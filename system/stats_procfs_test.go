@@ -0,0 +1,40 @@
+//go:build !darwin && !windows
+
+package system
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// TestProcRootFixture reads the processes of the fixture tree of procfs
+func TestProcRootFixture(t *testing.T) {
+	SetProcRoot("../procfs/testdata/proc")
+	defer SetProcRoot("")
+
+	processes, err := listProcesses()
+	if err != nil {
+		t.Fatalf("list: %s", err)
+	}
+
+	names := make(map[int]string)
+	for _, process := range processes {
+		names[process.PID] = process.Name
+	}
+	if len(names) != 3 || names[1] != "init" || names[2] != "kthreadd" || names[4242] != "my (weird) app" {
+		t.Errorf("processes %v, want init, kthreadd and my (weird) app without the truncated one", names)
+	}
+
+	stats, err := processStats(4242, true)
+	if err != nil {
+		t.Fatalf("stats: %s", err)
+	}
+	if stats.PPID != 1 || stats.PGID != 4242 || stats.UserCPU != time.Second || stats.RSSKB != math.MaxUint64 {
+		t.Errorf("stats %+v, want the fields after the command name", stats)
+	}
+
+	if capabilities := DetectProcCapabilities(); capabilities.Processes || capabilities.Memory {
+		t.Errorf("capabilities %+v of a tree without the test process", capabilities)
+	}
+}
@@ -0,0 +1,111 @@
+package system
+
+import (
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// STILL_ACTIVE is the exit code GetExitCodeProcess gives while a process runs
+const STILL_ACTIVE = 259
+
+// K32GetProcessMemoryInfo is GetProcessMemoryInfo of psapi in kernel32
+var procGetProcessMemoryInfo = windows.NewLazySystemDLL("kernel32.dll").NewProc("K32GetProcessMemoryInfo")
+
+// processMemoryCounters is PROCESS_MEMORY_COUNTERS of psapi, the working set
+// is the resident memory
+type processMemoryCounters struct {
+	Cb                         uint32
+	PageFaultCount             uint32
+	PeakWorkingSetSize         uintptr
+	WorkingSetSize             uintptr
+	QuotaPeakPagedPoolUsage    uintptr
+	QuotaPagedPoolUsage        uintptr
+	QuotaPeakNonPagedPoolUsage uintptr
+	QuotaNonPagedPoolUsage     uintptr
+	PagefileUsage              uintptr
+	PeakPagefileUsage          uintptr
+}
+
+// processStats finds pid in a toolhelp snapshot and reads its times and
+// memory, a process the supervisor may not open is given without them
+func processStats(pid int, memory bool) (ProcessStats, error) {
+	processes, err := listProcesses()
+	if err != nil {
+		return ProcessStats{}, err
+	}
+
+	for _, stats := range processes {
+		if stats.PID != pid {
+			continue
+		}
+
+		handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+		if err != nil {
+			return stats, nil
+		}
+		defer windows.CloseHandle(handle)
+
+		var code uint32
+		if windows.GetExitCodeProcess(handle, &code) == nil && code != STILL_ACTIVE {
+			stats.Exited = true
+		}
+
+		var created, exited, kernel, user windows.Filetime
+		if windows.GetProcessTimes(handle, &created, &exited, &kernel, &user) == nil {
+			stats.Start = uint64(created.HighDateTime)<<32 | uint64(created.LowDateTime)
+			stats.UserCPU, stats.SystemCPU = filetimeDuration(user), filetimeDuration(kernel)
+		}
+
+		if memory {
+			var counters processMemoryCounters
+			counters.Cb = uint32(unsafe.Sizeof(counters))
+			if ok, _, _ := procGetProcessMemoryInfo.Call(uintptr(handle), uintptr(unsafe.Pointer(&counters)), uintptr(counters.Cb)); ok != 0 {
+				stats.RSSKB, stats.PeakRSSKB = uint64(counters.WorkingSetSize)/1024, uint64(counters.PeakWorkingSetSize)/1024
+			}
+		}
+
+		return stats, nil
+	}
+
+	return ProcessStats{}, os.ErrProcessDone
+}
+
+// filetimeDuration converts a FILETIME span, counted in 100ns
+func filetimeDuration(t windows.Filetime) time.Duration {
+	return time.Duration(uint64(t.HighDateTime)<<32|uint64(t.LowDateTime)) * 100
+}
+
+// listProcesses returns the pid, parent and executable of every process of a
+// toolhelp snapshot, without their times
+func listProcesses() ([]ProcessStats, error) {
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(snapshot)
+
+	var entry windows.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+
+	var processes []ProcessStats
+	for err = windows.Process32First(snapshot, &entry); err == nil; err = windows.Process32Next(snapshot, &entry) {
+		processes = append(processes, ProcessStats{
+			PID:  int(entry.ProcessID),
+			PPID: int(entry.ParentProcessID),
+			Name: windows.UTF16ToString(entry.ExeFile[:]),
+		})
+	}
+
+	return processes, nil
+}
+
+// platformCapabilities reads processes and their memory from a toolhelp
+// snapshot and the process handles, there is no /proc
+func platformCapabilities(c *ProcCapabilities) {
+	stats, err := processStats(os.Getpid(), true)
+	c.Processes = err == nil && stats.PPID == os.Getppid()
+	c.Memory = err == nil && stats.RSSKB > 0
+}
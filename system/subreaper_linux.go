@@ -0,0 +1,8 @@
+package system
+
+import "golang.org/x/sys/unix"
+
+// becomeSubreaper has the orphans of the services reparented to the supervisor
+func becomeSubreaper() error {
+	return unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package system
+
+// becomeSubreaper fails without PR_SET_CHILD_SUBREAPER, the orphans of the
+// services go to pid 1
+func becomeSubreaper() error {
+	return ErrUnsupportedPlatform
+}
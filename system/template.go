@@ -0,0 +1,70 @@
+package system
+
+import (
+	"strconv"
+	"strings"
+)
+
+const TEMPLATE_SEPARATOR = "@"
+
+// TEMPLATE_INSTANCE is replaced by the instance name in Exec, Params, Env, WorkingDir, EnvFile, RuntimeDir and StateDir of a template
+const TEMPLATE_INSTANCE = "%i"
+
+// IsTemplate reports whether name is a template name, like "worker@"
+func IsTemplate(name string) bool {
+	return strings.HasSuffix(name, TEMPLATE_SEPARATOR)
+}
+
+// templateName returns the template name of "worker", "worker@" or of an instance "worker@emails"
+func templateName(name string) string {
+	if i := strings.Index(name, TEMPLATE_SEPARATOR); i >= 0 {
+		return name[:i+1]
+	}
+
+	return name + TEMPLATE_SEPARATOR
+}
+
+func (c ServiceConfig) instantiate(instance string) ServiceConfig {
+	config := c
+	config.Name = templateName(c.Name) + instance
+	config.Exec = strings.ReplaceAll(c.Exec, TEMPLATE_INSTANCE, instance)
+	config.Script = strings.ReplaceAll(c.Script, TEMPLATE_INSTANCE, instance)
+	config.WorkingDir = strings.ReplaceAll(c.WorkingDir, TEMPLATE_INSTANCE, instance)
+	config.EnvFile = strings.ReplaceAll(c.EnvFile, TEMPLATE_INSTANCE, instance)
+	config.RuntimeDir = strings.ReplaceAll(c.RuntimeDir, TEMPLATE_INSTANCE, instance)
+	config.StateDir = strings.ReplaceAll(c.StateDir, TEMPLATE_INSTANCE, instance)
+	config.Instances = nil
+
+	config.Params = make([]string, len(c.Params))
+	for i, param := range c.Params {
+		config.Params[i] = strings.ReplaceAll(param, TEMPLATE_INSTANCE, instance)
+	}
+
+	// an explicit empty env stays empty
+	if c.Env != nil {
+		config.Env = make([]string, len(c.Env))
+		for i, variable := range c.Env {
+			config.Env[i] = strings.ReplaceAll(variable, TEMPLATE_INSTANCE, instance)
+		}
+	}
+
+	return config
+}
+
+// instanceNumber is the number of an instance named like "worker@2", -1 for a
+// named instance like "worker@emails"
+func instanceNumber(name string) int {
+	number, err := strconv.Atoi(name[strings.Index(name, TEMPLATE_SEPARATOR)+1:])
+	if err != nil || number < 1 {
+		return -1
+	}
+
+	return number
+}
+
+func newInstance(template ServiceConfig, instance string) *Service {
+	service := NewService(template.instantiate(instance))
+	service.group = template.Name
+
+	return service
+}
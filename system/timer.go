@@ -0,0 +1,367 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// policies of a run of a scheduled service due while the previous one runs
+const (
+	OVERLAP_SKIP  = "skip"
+	OVERLAP_QUEUE = "queue"
+)
+
+// SCHEDULE_EVERY starts a schedule of a fixed interval, "@every 10m"
+const SCHEDULE_EVERY = "@every "
+
+// SCHEDULE_HORIZON is how far ahead the next run of a cron expression is looked for
+const SCHEDULE_HORIZON = 5 * 366 * 24 * time.Hour
+
+var ErrInvalidSchedule = errors.New("invalid schedule")
+
+// cronDescriptors are the schedules named by a descriptor
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	dayNames   = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// schedule is when a scheduled service runs: every interval after the previous
+// run was due, or at the minutes a cron expression matches
+type schedule struct {
+	every time.Duration
+	cron  *cronSchedule
+}
+
+// cronSchedule holds a bit per value of each field of a cron expression, a day
+// matches either of dom and dow if both are restricted, as in cron
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+// ValidateSchedule checks the schedule and that the service is not restarted
+// or activated otherwise
+func ValidateSchedule(config ServiceConfig) error {
+	if config.Schedule == "" {
+		if config.ScheduleOverlap != "" {
+			return fmt.Errorf("%w: scheduleOverlap is set without a schedule", ErrInvalidSchedule)
+		}
+
+		return nil
+	}
+
+	sched, err := parseSchedule(config.Schedule)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case config.ScheduleOverlap != "" && config.ScheduleOverlap != OVERLAP_SKIP && config.ScheduleOverlap != OVERLAP_QUEUE:
+		return fmt.Errorf("%w: scheduleOverlap %q, expected %s or %s", ErrInvalidSchedule, config.ScheduleOverlap, OVERLAP_SKIP, OVERLAP_QUEUE)
+	case config.Activation != "":
+		return fmt.Errorf("%w: a scheduled service is not activated by its sockets", ErrInvalidSchedule)
+	case (config.RestartPolicy != "" && config.RestartPolicy != RESTART_NEVER) || config.RestartDelay > 0 || config.Restart > 0:
+		return fmt.Errorf("%w: a scheduled service is not restarted, its next run starts it again", ErrInvalidSchedule)
+	case len(config.Probe.kinds()) > 0:
+		return fmt.Errorf("%w: an external dependency is not run", ErrInvalidSchedule)
+	case sched.cron != nil && sched.cron.next(time.Now()).IsZero():
+		return fmt.Errorf("%w: %q never runs", ErrInvalidSchedule, config.Schedule)
+	}
+
+	return nil
+}
+
+// parseSchedule reads "@every <duration>", a descriptor like "@daily" or a cron
+// expression of five fields: minute, hour, day of month, month and day of week
+func parseSchedule(value string) (schedule, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, SCHEDULE_EVERY) {
+		every, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(value, SCHEDULE_EVERY)))
+		if err != nil || every <= 0 {
+			return schedule{}, fmt.Errorf("%w: %q, a positive duration like \"@every 10m\" is expected", ErrInvalidSchedule, value)
+		}
+
+		return schedule{every: every}, nil
+	}
+
+	expression := value
+	if descriptor, ok := cronDescriptors[strings.ToLower(value)]; ok {
+		expression = descriptor
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return schedule{}, fmt.Errorf("%w: %q, a cron expression of 5 fields, a descriptor or \"@every <duration>\" is expected", ErrInvalidSchedule, value)
+	}
+
+	cron := &cronSchedule{domAny: strings.HasPrefix(fields[2], "*"), dowAny: strings.HasPrefix(fields[4], "*")}
+	for _, field := range []struct {
+		bits     *uint64
+		min, max int
+		names    map[string]int
+	}{
+		{&cron.minute, 0, 59, nil},
+		{&cron.hour, 0, 23, nil},
+		{&cron.dom, 1, 31, nil},
+		{&cron.month, 1, 12, monthNames},
+		{&cron.dow, 0, 7, dayNames},
+	} {
+		bits, err := parseCronField(fields[0], field.min, field.max, field.names)
+		if err != nil {
+			return schedule{}, fmt.Errorf("%w: %q: %s", ErrInvalidSchedule, value, err)
+		}
+
+		*field.bits = bits
+		fields = fields[1:]
+	}
+
+	// 7 is sunday as well
+	if cron.dow&(1<<7) != 0 {
+		cron.dow |= 1
+	}
+
+	return schedule{cron: cron}, nil
+}
+
+// parseCronField reads a list of "*", values and ranges, each with a "/step"
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		span, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("step of %q is not a positive number", part)
+			}
+			span = part[:i]
+		}
+
+		low, high := min, max
+		switch {
+		case span == "*":
+		case strings.Contains(span, "-"):
+			bounds := strings.SplitN(span, "-", 2)
+			var err error
+			if low, err = cronValue(bounds[0], names); err != nil {
+				return 0, err
+			}
+			if high, err = cronValue(bounds[1], names); err != nil {
+				return 0, err
+			}
+		default:
+			value, err := cronValue(span, names)
+			if err != nil {
+				return 0, err
+			}
+
+			// "5/15" runs from 5 on
+			low = value
+			if step == 1 {
+				high = value
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q is not within %d and %d", part, min, max)
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+
+	return bits, nil
+}
+
+func cronValue(value string, names map[string]int) (int, error) {
+	if number, ok := names[strings.ToLower(value)]; ok {
+		return number, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", value)
+	}
+
+	return number, nil
+}
+
+// next returns the first minute after the time matching the expression, zero
+// if none does within SCHEDULE_HORIZON
+func (c *cronSchedule) next(after time.Time) time.Time {
+	loc := after.Location()
+	t := time.Date(after.Year(), after.Month(), after.Day(), after.Hour(), after.Minute()+1, 0, 0, loc)
+
+	for limit := after.Add(SCHEDULE_HORIZON); t.Before(limit); {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+
+	return dom || dow
+}
+
+// isTimed reports whether the service runs on its schedule
+func (s *Service) isTimed() bool {
+	return s.Schedule != ""
+}
+
+// isWaitingRun reports whether the service waits for its next run
+func (s *Service) isWaitingRun() bool {
+	return s.isTimed() && s.nextRunAt > 0
+}
+
+func (s *Service) getScheduleOverlap() string {
+	if s.ScheduleOverlap == "" {
+		return OVERLAP_SKIP
+	}
+
+	return s.ScheduleOverlap
+}
+
+// planRun sets the Monotonic reading of the next run: an interval is counted
+// from the run due before, runs missed meanwhile are dropped, a cron expression
+// is matched against the wall clock
+func (s *Service) planRun() {
+	sched, err := parseSchedule(s.Schedule)
+	if err != nil {
+		s.log().warnf("%s", err)
+		return
+	}
+
+	clock := s.getClock()
+	now := clock.Monotonic()
+
+	switch {
+	case sched.every > 0 && s.nextRunAt == 0:
+		s.nextRunAt = now + sched.every
+	case sched.every > 0:
+		for s.nextRunAt <= now {
+			s.nextRunAt += sched.every
+		}
+	default:
+		wall := clock.Now()
+		next := sched.cron.next(wall)
+		if next.IsZero() {
+			s.log().warnf("%s never runs again", s.Schedule)
+			s.setNextRun(0, time.Time{})
+			return
+		}
+
+		s.nextRunAt = now + next.Sub(wall)
+	}
+
+	s.setNextRun(s.nextRunAt, clock.Now().Add(s.nextRunAt-now))
+}
+
+func (s *Service) setNextRun(at time.Duration, wall time.Time) {
+	s.nextRunAt = at
+
+	s.mu.Lock()
+	s.nextRun = wall
+	s.mu.Unlock()
+}
+
+// waitRun waits for the next run of the service in StateScheduled
+func (s *Service) waitRun() {
+	if s.nextRunAt == 0 {
+		s.planRun()
+	}
+
+	if s.isWaitingRun() {
+		s.log().infof("next run at %s", s.getNextRun().Format(time.RFC3339))
+		s.setState(StateScheduled)
+	}
+}
+
+func (s *Service) getNextRun() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.nextRun
+}
+
+// runDue reports whether the next run of the service is due
+func (s *Service) runDue() bool {
+	return s.isWaitingRun() && !s.stopped() && s.getClock().Monotonic() >= s.nextRunAt
+}
+
+// handleRun starts the due run, or skips or queues it if the previous one still
+// runs, the run after it is planned either way
+func (s *Service) handleRun(out, err chan<- string) {
+	s.planRun()
+
+	if s.running == nil {
+		s.startProcess(out, err)
+		return
+	}
+
+	if s.getScheduleOverlap() == OVERLAP_QUEUE {
+		s.log().infof("previous run still running, queueing the run")
+		s.runQueued = true
+		return
+	}
+
+	s.log().warnf("previous run still running, skipping the run")
+	s.note(JournalEntry{Type: JOURNAL_RUN_SKIPPED, Message: "previous run still running"})
+}
+
+// finishRun starts a queued run once the previous one is archived, or waits
+// for the next one
+func (s *Service) finishRun() {
+	if s.runQueued {
+		s.runQueued = false
+		s.setIntent(intentStartNow)
+		return
+	}
+
+	s.waitRun()
+}
+
+// stopRuns drops the planned and queued runs of a stopped service
+func (s *Service) stopRuns() {
+	if !s.isTimed() {
+		return
+	}
+
+	s.runQueued = false
+	s.setNextRun(0, time.Time{})
+}
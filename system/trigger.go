@@ -0,0 +1,186 @@
+package system
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// actions of output triggers
+const (
+	TRIGGER_RESTART = "restart"
+	TRIGGER_UNREADY = "unready"
+	TRIGGER_EXEC    = "exec"
+	TRIGGER_ALERT   = "alert"
+)
+
+// TRIGGER_RATE_LIMIT is the default minimum time between two actions of a trigger
+const TRIGGER_RATE_LIMIT = time.Minute
+
+// TRIGGER_MAX_LINE bytes of a line are matched, the rest of a long line is not
+const TRIGGER_MAX_LINE = 4096
+
+var triggerActions = map[string]bool{TRIGGER_RESTART: true, TRIGGER_UNREADY: true, TRIGGER_EXEC: true, TRIGGER_ALERT: true}
+
+// OutputTrigger acts on the output lines of a service matching Pattern
+type OutputTrigger struct {
+	// Pattern is a regexp, like "OutOfMemoryError"
+	Pattern string
+
+	// Stream limits the trigger to "stdout" or "stderr" lines, combined output
+	// matches either, all lines if empty
+	Stream string
+
+	// Action is "restart" to restart the process, "unready" to turn a ready service
+	// running, "exec" to run Exec with Params or "alert" to emit a warn event
+	Action string
+
+	Exec   string
+	Params []string
+
+	// Timeout of Exec, HOOK_TIMEOUT if not set
+	Timeout time.Duration
+
+	// RateLimit is the minimum time between two actions, TRIGGER_RATE_LIMIT if not set,
+	// matches in between are not acted on
+	RateLimit time.Duration
+}
+
+func (t *OutputTrigger) UnmarshalJSON(data []byte) error {
+	type trigger OutputTrigger
+
+	aux := struct {
+		*trigger
+		Timeout   duration
+		RateLimit duration
+	}{trigger: (*trigger)(t)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	t.Timeout = time.Duration(aux.Timeout)
+	t.RateLimit = time.Duration(aux.RateLimit)
+
+	return nil
+}
+
+func (t OutputTrigger) GetRateLimit() time.Duration {
+	if t.RateLimit > 0 {
+		return t.RateLimit
+	}
+
+	return TRIGGER_RATE_LIMIT
+}
+
+// ValidateOutputTriggers compiles the patterns and checks actions and streams
+func ValidateOutputTriggers(triggers []OutputTrigger) error {
+	for i, t := range triggers {
+		if _, err := regexp.Compile(t.Pattern); err != nil {
+			return fmt.Errorf("outputTriggers[%d]: %s", i, err)
+		}
+
+		if !triggerActions[t.Action] {
+			return fmt.Errorf("outputTriggers[%d]: unknown action %q, expected restart, unready, exec or alert", i, t.Action)
+		}
+
+		if t.Action == TRIGGER_EXEC && t.Exec == "" {
+			return fmt.Errorf("outputTriggers[%d]: exec action without exec", i)
+		}
+
+		if t.Stream != "" && t.Stream != STREAM_STDOUT && t.Stream != STREAM_STDERR {
+			return fmt.Errorf("outputTriggers[%d]: unknown stream %q, expected stdout or stderr", i, t.Stream)
+		}
+	}
+
+	return nil
+}
+
+// trigger is an OutputTrigger with its compiled pattern and the time it last acted
+type trigger struct {
+	OutputTrigger
+	pattern *regexp.Regexp
+
+	mu sync.Mutex
+	// next is the Monotonic reading the trigger may act again from
+	next time.Duration
+}
+
+// allow reports whether the trigger may act at the Monotonic reading, once per RateLimit
+func (t *trigger) allow(now time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now < t.next {
+		return false
+	}
+
+	t.next = now + t.GetRateLimit()
+
+	return true
+}
+
+// outputTriggers compiles the triggers of the service once, the rate limits hold
+// across runs
+func (s *Service) outputTriggers() []*trigger {
+	s.triggersOnce.Do(func() {
+		for _, config := range s.OutputTriggers {
+			pattern, err := regexp.Compile(config.Pattern)
+			if err != nil {
+				s.log().warnf("invalid output trigger: %s", err)
+				continue
+			}
+
+			s.triggers = append(s.triggers, &trigger{OutputTrigger: config, pattern: pattern})
+		}
+	})
+
+	return s.triggers
+}
+
+// matchTriggers acts on the line for every trigger matching it, actions that
+// need the supervision loop do not hold up the scanner
+func (s *Service) matchTriggers(triggers []*trigger, stream string, running *process, line string) {
+	text := line
+	if len(text) > TRIGGER_MAX_LINE {
+		text = text[:TRIGGER_MAX_LINE]
+	}
+
+	for _, t := range triggers {
+		if t.Stream != "" && stream != STREAM_COMBINED && t.Stream != stream {
+			continue
+		}
+
+		if !t.pattern.MatchString(text) || !t.allow(s.getClock().Monotonic()) {
+			continue
+		}
+
+		s.log().infof("output matched %q, %s", t.Pattern, t.Action)
+		s.note(JournalEntry{Type: JOURNAL_TRIGGERED, PID: running.pid(), Message: fmt.Sprintf("%s on %q: %s", t.Action, t.Pattern, text)})
+
+		switch t.Action {
+		case TRIGGER_RESTART:
+			go s.sendTriggered(commandTriggerRestart)
+		case TRIGGER_UNREADY:
+			go s.sendTriggered(commandUnready)
+		case TRIGGER_EXEC:
+			hook := Hook{Name: s.Name, Exec: t.Exec, Params: t.Params, Timeout: t.Timeout}
+			go func() {
+				if err := hook.run(context.Background()); err != nil {
+					s.log().warnf("output trigger %s failed: %s", t.Exec, err)
+				}
+			}()
+		case TRIGGER_ALERT:
+			s.warn(fmt.Sprintf("output matched %q: %s", t.Pattern, text))
+		}
+	}
+}
+
+func (s *Service) sendTriggered(cmd command) {
+	if err := s.send(cmd); err != nil {
+		s.log().warnf("output trigger: %s", err)
+	}
+}
@@ -0,0 +1,153 @@
+package system
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ServiceUsage is the cpu time and the peak resident memory of the runs of a
+// service since the manager started. Finished runs count with the rusage of
+// their wait, exact even if samples missed a spike and covering the children
+// they waited for, the running one with its process stats and its samples
+type ServiceUsage struct {
+	Name      string        `json:"name"`
+	Runs      int           `json:"runs"`
+	UserCPU   time.Duration `json:"userCpu"`
+	SystemCPU time.Duration `json:"systemCpu"`
+	PeakRSSKB uint64        `json:"peakRssKb"`
+}
+
+// CPU is the user and system time
+func (u ServiceUsage) CPU() time.Duration {
+	return u.UserCPU + u.SystemCPU
+}
+
+// Usage returns the usage of the services since Run started, the most cpu first,
+// external dependencies have none
+func (m *Manager) Usage() []ServiceUsage {
+	m.mu.Lock()
+	services := append([]*Service(nil), m.services...)
+	since := m.startedAt
+	m.mu.Unlock()
+
+	usage := make([]ServiceUsage, 0, len(services))
+	for _, service := range services {
+		if !service.isExternal() {
+			usage = append(usage, service.usage(since))
+		}
+	}
+
+	sort.SliceStable(usage, func(i, j int) bool {
+		if usage[i].CPU() != usage[j].CPU() {
+			return usage[i].CPU() > usage[j].CPU()
+		}
+
+		return usage[i].Name < usage[j].Name
+	})
+
+	return usage
+}
+
+// usage sums the records of the runs started after since with the running process
+func (s *Service) usage(since time.Time) ServiceUsage {
+	u := ServiceUsage{Name: s.Name}
+	for _, record := range s.History() {
+		if record.StartedAt.Before(since) {
+			continue
+		}
+
+		u.Runs += 1
+		u.UserCPU += record.UserCPU
+		u.SystemCPU += record.SystemCPU
+		if record.MaxRSSKB > u.PeakRSSKB {
+			u.PeakRSSKB = record.MaxRSSKB
+		}
+	}
+
+	s.mu.RLock()
+	running := s.running
+	s.mu.RUnlock()
+
+	if running == nil || running.cmd.Process == nil || running.Finished() {
+		return u
+	}
+
+	u.Runs += 1
+	pid := running.cmd.Process.Pid
+	capabilities := procCapabilities()
+
+	if capabilities.Processes {
+		if stats, err := processStats(pid, capabilities.Memory); err == nil {
+			u.UserCPU += stats.UserCPU
+			u.SystemCPU += stats.SystemCPU
+			if stats.PeakRSSKB > u.PeakRSSKB {
+				u.PeakRSSKB = stats.PeakRSSKB
+			}
+		}
+	}
+
+	for _, sample := range s.Samples(since) {
+		if sample.Incarnation == running.incarnation && sample.RSSBytes/1024 > u.PeakRSSKB {
+			u.PeakRSSKB = sample.RSSBytes / 1024
+		}
+	}
+
+	return u
+}
+
+// WriteUsage renders the usage as a table with a total line, peaks of services
+// are apart in time and are not added up:
+//
+//	SERVICE  RUNS  CPU     USER    SYSTEM  PEAK RSS
+//	web      3     12.40s  10.10s  2.30s   120.5 MiB
+func WriteUsage(w io.Writer, usage []ServiceUsage) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVICE\tRUNS\tCPU\tUSER\tSYSTEM\tPEAK RSS")
+
+	total := ServiceUsage{Name: "total"}
+	for _, u := range usage {
+		writeUsageLine(tw, u, memorySize(u.PeakRSSKB))
+
+		total.Runs += u.Runs
+		total.UserCPU += u.UserCPU
+		total.SystemCPU += u.SystemCPU
+	}
+	writeUsageLine(tw, total, "-")
+
+	return tw.Flush()
+}
+
+func writeUsageLine(w io.Writer, u ServiceUsage, peak string) {
+	fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n", u.Name, u.Runs, cpuSeconds(u.CPU()), cpuSeconds(u.UserCPU), cpuSeconds(u.SystemCPU), peak)
+}
+
+func cpuSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.2fs", d.Seconds())
+}
+
+// memorySize writes kilobytes in the largest binary unit below them
+func memorySize(kb uint64) string {
+	switch {
+	case kb >= 1024*1024:
+		return fmt.Sprintf("%.1f GiB", float64(kb)/(1024*1024))
+	case kb >= 1024:
+		return fmt.Sprintf("%.1f MiB", float64(kb)/1024)
+	}
+
+	return fmt.Sprintf("%d KiB", kb)
+}
+
+// logUsage logs the usage table on shutdown, a line of the log per line of it
+func (m *Manager) logUsage() {
+	var b strings.Builder
+	WriteUsage(&b, m.Usage())
+
+	managerLog.infof("usage of the services since start:")
+	for _, line := range strings.Split(strings.TrimRight(b.String(), "\n"), "\n") {
+		managerLog.infof("%s", line)
+	}
+}
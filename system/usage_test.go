@@ -0,0 +1,126 @@
+package system
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// BUSY_LOOP burns a little cpu in the shell running it
+const BUSY_LOOP = `i=0; while [ $i -lt 100000 ]; do i=$((i+1)); done`
+
+func TestWriteUsageGolden(t *testing.T) {
+	golden := filepath.Join("testdata", "usage_table.txt")
+
+	var table bytes.Buffer
+	err := WriteUsage(&table, []ServiceUsage{
+		{Name: "indexer", Runs: 1, UserCPU: 3*time.Hour + 25*time.Minute, SystemCPU: 41 * time.Second, PeakRSSKB: 3 * 1024 * 1024},
+		{Name: "web", Runs: 3, UserCPU: 10100 * time.Millisecond, SystemCPU: 2300 * time.Millisecond, PeakRSSKB: 123392},
+		{Name: "cron-cleanup", Runs: 1440, UserCPU: 1440 * 4 * time.Millisecond, SystemCPU: 1440 * 2 * time.Millisecond, PeakRSSKB: 900},
+		{Name: "idle", Runs: 0},
+	})
+	if err != nil {
+		t.Fatalf("write: %s", err)
+	}
+
+	if *update {
+		if err := ioutil.WriteFile(golden, table.Bytes(), 0644); err != nil {
+			t.Fatalf("write %s: %s", golden, err)
+		}
+	}
+
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("read %s: %s", golden, err)
+	}
+	if !bytes.Equal(table.Bytes(), want) {
+		t.Errorf("the table differs from %s, rerun with -update if the format changed on purpose:\n%s", golden, table.String())
+	}
+}
+
+func TestMemorySize(t *testing.T) {
+	for kb, want := range map[uint64]string{
+		0:               "0 KiB",
+		1023:            "1023 KiB",
+		1024:            "1.0 MiB",
+		123392:          "120.5 MiB",
+		1024*1024 - 1:   "1024.0 MiB",
+		1024 * 1024:     "1.0 GiB",
+		5 * 1024 * 1024: "5.0 GiB",
+	} {
+		if got := memorySize(kb); got != want {
+			t.Errorf("%d kB: %s, want %s", kb, got, want)
+		}
+	}
+}
+
+func TestRusageOfARun(t *testing.T) {
+	// the loop runs in a child of the shell, the rusage of the wait counts it
+	service := restartService(t, ServiceConfig{
+		Name:   "busy",
+		Exec:   "/bin/sh",
+		Params: []string{"-c", `sh -c '` + BUSY_LOOP + `'; exit 0`},
+	}, 1)
+
+	record := service.History()[0]
+	if record.UserCPU+record.SystemCPU < 10*time.Millisecond {
+		t.Errorf("record %+v, want the cpu of the child counted", record)
+	}
+	if record.MaxRSSKB == 0 {
+		t.Errorf("record %+v, want the peak memory of the run", record)
+	}
+
+	// a process that did not start has no usage
+	if record := newProcessRecord(&process{cmd: exec.Command("true")}); record.UserCPU != 0 || record.SystemCPU != 0 || record.MaxRSSKB != 0 {
+		t.Errorf("record %+v of no process, want no usage", record)
+	}
+}
+
+func TestUsageSumsTheRuns(t *testing.T) {
+	service := restartService(t, ServiceConfig{Name: "flapping", Exec: "/bin/sh", Params: []string{"-c", BUSY_LOOP}}, 3)
+	history := service.History()
+
+	var want ServiceUsage
+	for _, record := range history[1:] {
+		want.UserCPU += record.UserCPU
+		want.SystemCPU += record.SystemCPU
+		if record.MaxRSSKB > want.PeakRSSKB {
+			want.PeakRSSKB = record.MaxRSSKB
+		}
+	}
+
+	// the runs before the manager started are not counted
+	usage := service.usage(history[1].StartedAt)
+	if usage.Name != "flapping" || usage.Runs != len(history)-1 || usage.UserCPU != want.UserCPU || usage.SystemCPU != want.SystemCPU || usage.PeakRSSKB != want.PeakRSSKB {
+		t.Fatalf("usage %+v of %d runs, want %+v", usage, len(history)-1, want)
+	}
+	if all := service.usage(time.Time{}); all.Runs != len(history) || all.CPU() < usage.CPU() {
+		t.Fatalf("usage %+v of every run, want %d runs", all, len(history))
+	}
+}
+
+func TestManagerUsage(t *testing.T) {
+	busy := sleeper("busy")
+	busy.Exec = "/bin/sh"
+	busy.Params = []string{"-c", BUSY_LOOP + `; exec sleep 30`}
+	m, _ := runManager(t, busy, sleeper("idle"))
+	running(t, m, "busy", "idle")
+
+	// the running processes count with their /proc stat
+	if !procCapabilities().Processes {
+		t.Skip("no /proc stat of the processes")
+	}
+	var usage []ServiceUsage
+	eventually(t, 5*time.Second, "the busy loop accounted", func() bool {
+		usage = m.Usage()
+		return len(usage) == 2 && usage[0].Name == "busy" && usage[0].CPU() >= 10*time.Millisecond
+	})
+	for _, u := range usage {
+		if u.Runs != 1 || u.PeakRSSKB == 0 {
+			t.Errorf("usage %+v, want the running process", u)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidRestartWindow = errors.New("invalid restart window")
+
+const minutesPerDay = 24 * 60
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// restartWindow is open from start to end, minutes of the day, on days. A window
+// ending at or before its start runs past midnight into the next day
+type restartWindow struct {
+	days       [7]bool
+	start, end int
+}
+
+// parseRestartWindow reads "[days] HH:MM-HH:MM", days like "Sat", "Mon-Fri" or
+// "Sat,Sun", every day if left out
+func parseRestartWindow(value string) (restartWindow, error) {
+	var window restartWindow
+
+	fields := strings.Fields(value)
+	switch len(fields) {
+	case 1:
+		for day := range window.days {
+			window.days[day] = true
+		}
+	case 2:
+		if err := window.parseDays(fields[0]); err != nil {
+			return window, fmt.Errorf("%w: %s: %s", ErrInvalidRestartWindow, value, err)
+		}
+		fields = fields[1:]
+	default:
+		return window, fmt.Errorf("%w: %s, \"Sat 02:00-04:00\" is expected", ErrInvalidRestartWindow, value)
+	}
+
+	times := strings.SplitN(fields[0], "-", 2)
+	if len(times) != 2 {
+		return window, fmt.Errorf("%w: %s, a range like 02:00-04:00 is expected", ErrInvalidRestartWindow, value)
+	}
+
+	var err error
+	if window.start, err = parseMinutes(times[0]); err == nil {
+		window.end, err = parseMinutes(times[1])
+	}
+
+	if err != nil {
+		return window, fmt.Errorf("%w: %s: %s", ErrInvalidRestartWindow, value, err)
+	}
+
+	if window.start == window.end {
+		return window, fmt.Errorf("%w: %s opens and closes at the same time", ErrInvalidRestartWindow, value)
+	}
+
+	return window, nil
+}
+
+// parseDays reads days separated by commas, each a day or a range of days that
+// may wrap around the week, like "Fri-Mon"
+func (w *restartWindow) parseDays(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+
+		from, ok := weekdays[strings.ToLower(bounds[0])]
+		if !ok {
+			return fmt.Errorf("unknown day %q", bounds[0])
+		}
+
+		to := from
+		if len(bounds) == 2 {
+			if to, ok = weekdays[strings.ToLower(bounds[1])]; !ok {
+				return fmt.Errorf("unknown day %q", bounds[1])
+			}
+		}
+
+		for day := from; ; day = (day + 1) % 7 {
+			w.days[day] = true
+			if day == to {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseMinutes reads "HH:MM" as minutes of the day, "24:00" is the end of it
+func parseMinutes(value string) (int, error) {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 || len(parts[1]) != 2 {
+		return 0, fmt.Errorf("time %q is not HH:MM", value)
+	}
+
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("time %q is not HH:MM", value)
+	}
+
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("time %q is not HH:MM", value)
+	}
+
+	if hours < 0 || minutes < 0 || minutes > 59 || hours*60+minutes > minutesPerDay {
+		return 0, fmt.Errorf("time %q is out of the day", value)
+	}
+
+	return hours*60 + minutes, nil
+}
+
+// contains reports whether the window is open at t, read in the zone of t
+func (w restartWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+
+	if w.start < w.end {
+		return w.days[day] && minute >= w.start && minute < w.end
+	}
+
+	// past midnight the window is the one of the day before
+	return (w.days[day] && minute >= w.start) || (w.days[(day+6)%7] && minute < w.end)
+}
+
+// next returns the first opening of the window after t
+func (w restartWindow) next(t time.Time) time.Time {
+	for i := 0; i <= 7; i++ {
+		day := time.Date(t.Year(), t.Month(), t.Day()+i, 0, 0, 0, 0, t.Location())
+		opening := time.Date(day.Year(), day.Month(), day.Day(), w.start/60, w.start%60, 0, 0, t.Location())
+		if w.days[day.Weekday()] && opening.After(t) {
+			return opening
+		}
+	}
+
+	return time.Time{}
+}
+
+// restartWindows are the windows of a service with their zone
+type restartWindows struct {
+	windows  []restartWindow
+	location *time.Location
+}
+
+// ValidateRestartWindows checks the windows and the IANA zone they are read in
+func ValidateRestartWindows(config ServiceConfig) error {
+	_, err := compileRestartWindows(config)
+
+	return err
+}
+
+func compileRestartWindows(config ServiceConfig) (restartWindows, error) {
+	compiled := restartWindows{location: time.Local}
+	if config.RestartWindowZone != "" {
+		location, err := time.LoadLocation(config.RestartWindowZone)
+		if err != nil {
+			return compiled, fmt.Errorf("%w: zone %s: %s", ErrInvalidRestartWindow, config.RestartWindowZone, err)
+		}
+		compiled.location = location
+	}
+
+	if config.StrictWindow && len(config.RestartWindows) == 0 {
+		return compiled, fmt.Errorf("%w: strictWindow is set without restartWindows", ErrInvalidRestartWindow)
+	}
+
+	for _, value := range config.RestartWindows {
+		window, err := parseRestartWindow(value)
+		if err != nil {
+			return compiled, err
+		}
+		compiled.windows = append(compiled.windows, window)
+	}
+
+	return compiled, nil
+}
+
+// open reports whether a window is open at t, any time if there are none
+func (w restartWindows) open(t time.Time) bool {
+	if len(w.windows) == 0 {
+		return true
+	}
+
+	t = t.In(w.location)
+	for _, window := range w.windows {
+		if window.contains(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// next returns the first opening of a window after t
+func (w restartWindows) next(t time.Time) time.Time {
+	t = t.In(w.location)
+
+	var next time.Time
+	for _, window := range w.windows {
+		if opening := window.next(t); !opening.IsZero() && (next.IsZero() || opening.Before(next)) {
+			next = opening
+		}
+	}
+
+	return next
+}
+
+// getRestartWindows compiles the windows of the service once, they are validated
+func (s *Service) getRestartWindows() restartWindows {
+	s.windowsOnce.Do(func() {
+		var err error
+		if s.windows, err = compileRestartWindows(s.ServiceConfig); err != nil {
+			s.log().errorf("%s", err)
+		}
+	})
+
+	return s.windows
+}
+
+// restartAllowed reports whether an automatic restart may run now. Crashes and
+// other involuntary stops restart regardless of the windows unless StrictWindow
+func (s *Service) restartAllowed(reason StopReason) bool {
+	if len(s.RestartWindows) == 0 || (reason.IsInvoluntary() && !s.StrictWindow) {
+		return true
+	}
+
+	return s.getRestartWindows().open(s.getClock().Now())
+}
+
+// holdRestart puts the service waiting for a restart window in PendingRestart,
+// warning once with the next opening
+func (s *Service) holdRestart() {
+	if s.getState() == StatePendingRestart {
+		return
+	}
+
+	next := s.getRestartWindows().next(s.getClock().Now())
+	s.note(JournalEntry{Type: JOURNAL_RESTART_PENDING, Message: "until " + next.Format(time.RFC3339)})
+	s.setState(StatePendingRestart)
+	s.warn(fmt.Sprintf("restart outside of the restart windows, pending until %s", next.Format(time.RFC3339)))
+}
+
+// deferRestart keeps a restart triggered while running for the next window,
+// warning once
+func (s *Service) deferRestart() {
+	if s.restartDeferred {
+		return
+	}
+
+	s.mu.Lock()
+	s.restartDeferred = true
+	s.mu.Unlock()
+
+	next := s.getRestartWindows().next(s.getClock().Now())
+	s.note(JournalEntry{Type: JOURNAL_RESTART_PENDING, Message: "until " + next.Format(time.RFC3339)})
+	s.warn(fmt.Sprintf("triggered restart outside of the restart windows, deferred until %s", next.Format(time.RFC3339)))
+}
+
+// untilRestartWindow is the time until the next restart window opens, the
+// wall clock may jump before, the loop checks again on its heartbeat
+func (s *Service) untilRestartWindow() time.Duration {
+	now := s.getClock().Now()
+	next := s.getRestartWindows().next(now)
+	if next.IsZero() {
+		return SUPERVISION_HEARTBEAT
+	}
+
+	return next.Sub(now)
+}
+
+// lastStopReason is the reason the last run stopped for, a failed start crashed
+func (s *Service) lastStopReason() StopReason {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.lastExit == nil {
+		return StopReasonUnknown
+	}
+
+	return s.lastExit.StopReason
+}
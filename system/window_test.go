@@ -0,0 +1,234 @@
+package system
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func berlin(t *testing.T) *time.Location {
+	t.Helper()
+
+	location, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no zone database: %s", err)
+	}
+
+	return location
+}
+
+func TestParseRestartWindow(t *testing.T) {
+	for _, test := range []struct {
+		window string
+		valid  bool
+	}{
+		{"Sat 02:00-04:00", true},
+		{"mon-fri 22:00-06:00", true},
+		{"Fri-Mon 03:00-04:00", true},
+		{"Sat,Sun 00:00-24:00", true},
+		{"03:00-04:00", true},
+
+		{"", false},
+		{"Sat", false},
+		{"Sat 02:00", false},
+		{"Sat 2:0-4:00", false},
+		{"Sat 02:00-25:00", false},
+		{"Sat 02:60-04:00", false},
+		{"Sat 02:00-02:00", false},
+		{"Caturday 02:00-04:00", false},
+		{"Sat-Someday 02:00-04:00", false},
+		{"Sat 02:00-04:00 UTC", false},
+		{"0 2 * * 6", false},
+	} {
+		_, err := parseRestartWindow(test.window)
+		if test.valid && err != nil {
+			t.Errorf("%q refused: %s", test.window, err)
+		}
+		if !test.valid && !errors.Is(err, ErrInvalidRestartWindow) {
+			t.Errorf("%q: %v, want %v", test.window, err, ErrInvalidRestartWindow)
+		}
+	}
+}
+
+func TestRestartWindowsOpen(t *testing.T) {
+	zone := berlin(t)
+	at := func(day, hour, minute int) time.Time {
+		// October 2026 in Berlin, the 17th is a Saturday
+		return time.Date(2026, 10, day, hour, minute, 0, 0, zone)
+	}
+
+	for _, test := range []struct {
+		windows string
+		zone    string
+		at      time.Time
+		open    bool
+		next    time.Time
+	}{
+		{"Sat 02:00-04:00", "Europe/Berlin", at(17, 1, 30), false, at(17, 2, 0)},
+		{"Sat 02:00-04:00", "Europe/Berlin", at(17, 2, 0), true, at(24, 2, 0)},
+		{"Sat 02:00-04:00", "Europe/Berlin", at(17, 3, 59), true, at(24, 2, 0)},
+		{"Sat 02:00-04:00", "Europe/Berlin", at(17, 4, 0), false, at(24, 2, 0)},
+
+		// past midnight the window of friday is open on saturday morning
+		{"Mon-Fri 22:00-06:00", "Europe/Berlin", at(17, 5, 0), true, at(19, 22, 0)},
+		{"Mon-Fri 22:00-06:00", "Europe/Berlin", at(17, 22, 30), false, at(19, 22, 0)},
+		{"Mon-Fri 22:00-06:00", "Europe/Berlin", at(19, 1, 0), false, at(19, 22, 0)},
+
+		// days wrapping around the week
+		{"Fri-Mon 03:00-04:00", "Europe/Berlin", at(18, 3, 30), true, at(19, 3, 0)},
+		{"Fri-Mon 03:00-04:00", "Europe/Berlin", at(21, 3, 30), false, at(23, 3, 0)},
+		{"22:00-24:00", "Europe/Berlin", at(21, 23, 59), true, at(22, 22, 0)},
+
+		// the windows are read in their zone: 02:30 in Berlin is 00:30 in UTC
+		{"Sat 02:00-04:00", "UTC", at(17, 2, 30), false, time.Date(2026, 10, 17, 2, 0, 0, 0, time.UTC)},
+		{"Sat 00:00-01:00", "UTC", at(17, 2, 30), true, time.Date(2026, 10, 24, 0, 0, 0, 0, time.UTC)},
+
+		// summer time ends on the 25th at 03:00, the opening is at 03:00 of winter time
+		{"03:00-04:00", "Europe/Berlin", at(24, 23, 0), false, time.Date(2026, 10, 25, 2, 0, 0, 0, time.UTC)},
+	} {
+		windows, err := compileRestartWindows(ServiceConfig{RestartWindows: []string{test.windows}, RestartWindowZone: test.zone})
+		if err != nil {
+			t.Fatalf("%s: %s", test.windows, err)
+		}
+
+		// the time of the clock is in UTC
+		now := test.at.UTC()
+		if open := windows.open(now); open != test.open {
+			t.Errorf("%s in %s at %s: open %t, want %t", test.windows, test.zone, test.at, open, test.open)
+		}
+		if next := windows.next(now); !next.Equal(test.next) {
+			t.Errorf("%s in %s at %s: next opening %s, want %s", test.windows, test.zone, test.at, next, test.next)
+		}
+	}
+
+	windows, err := compileRestartWindows(ServiceConfig{RestartWindows: []string{"Sat 02:00-04:00", "Wed 12:00-13:00"}, RestartWindowZone: "Europe/Berlin"})
+	if err != nil {
+		t.Fatalf("windows: %s", err)
+	}
+	if next := windows.next(at(19, 0, 0)); !next.Equal(at(21, 12, 0)) {
+		t.Errorf("next opening %s of two windows, want the wednesday one", next)
+	}
+}
+
+func TestCompileRestartWindowsErrors(t *testing.T) {
+	for _, config := range []ServiceConfig{
+		{RestartWindows: []string{"Sat 02:00-04:00"}, RestartWindowZone: "Europe/Atlantis"},
+		{RestartWindows: []string{"Sat 02:00-04:00", "Sun"}},
+		{StrictWindow: true},
+	} {
+		if err := ValidateRestartWindows(config); !errors.Is(err, ErrInvalidRestartWindow) {
+			t.Errorf("%+v: %v, want %v", config, err, ErrInvalidRestartWindow)
+		}
+	}
+}
+
+// windowed exits at once and restarts only on saturdays from 02:00 to 04:00 in
+// Berlin, its clock stands at 01:30 then
+func windowed(t *testing.T, exec string) (ServiceConfig, *FakeClock) {
+	t.Helper()
+
+	config := ServiceConfig{
+		Name:              "db",
+		Exec:              exec,
+		RestartPolicy:     RESTART_ALWAYS,
+		RestartDelay:      100 * time.Millisecond,
+		RestartWindows:    []string{"Sat 02:00-04:00"},
+		RestartWindowZone: "Europe/Berlin",
+	}
+
+	return config, NewFakeClock(time.Date(2026, 10, 17, 1, 30, 0, 0, berlin(t)))
+}
+
+func TestRestartPendingUntilTheWindow(t *testing.T) {
+	config, clock := windowed(t, "true")
+	service := NewService(config)
+	service.scheduler = newScheduler(1)
+	service.scheduler.setClock(clock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go service.Run(ctx, nil, nil)
+	eventually(t, 5*time.Second, "the first run", func() bool { return service.Status().Runs == 1 })
+	advanceUntil(t, clock, 100*time.Millisecond, "the pending restart", func() bool {
+		return service.Status().State == StatePendingRestart
+	})
+
+	var pending *JournalEntry
+	for _, entry := range service.Journal(0) {
+		if entry.Type == JOURNAL_RESTART_PENDING {
+			entry := entry
+			pending = &entry
+		}
+	}
+	if pending == nil || pending.Message != "until 2026-10-17T02:00:00+02:00" {
+		t.Errorf("journal entry %+v, want the pending restart until 02:00", pending)
+	}
+
+	clock.Advance(29 * time.Minute)
+	time.Sleep(50 * time.Millisecond)
+	if status := service.Status(); status.Runs != 1 || status.State != StatePendingRestart {
+		t.Fatalf("%s with %d runs before the window, want pending", status.State, status.Runs)
+	}
+
+	advanceUntil(t, clock, time.Minute, "the restart in the window", func() bool { return service.Status().Runs >= 2 })
+
+	cancel()
+	service.Wait()
+}
+
+func TestCrashRestartsOutsideTheWindow(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		config, clock := windowed(t, "false")
+		config.StrictWindow = strict
+		service := NewService(config)
+		service.scheduler = newScheduler(1)
+		service.scheduler.setClock(clock)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go service.Run(ctx, nil, nil)
+		eventually(t, 5*time.Second, "the first run", func() bool { return service.Status().Runs == 1 })
+
+		if strict {
+			advanceUntil(t, clock, 100*time.Millisecond, "the pending restart", func() bool {
+				return service.Status().State == StatePendingRestart
+			})
+		} else {
+			advanceUntil(t, clock, 100*time.Millisecond, "the restart of the crash", func() bool { return service.Status().Runs >= 2 })
+		}
+
+		cancel()
+		service.Wait()
+	}
+}
+
+// TestRestartPendingOverride starts a pending service through the manager, an
+// operator does not wait for the window
+func TestRestartPendingOverride(t *testing.T) {
+	config, clock := windowed(t, "true")
+	m := runManagerOn(t, clock, config)
+	events := m.Subscribe()
+	defer m.Unsubscribe(events)
+
+	eventually(t, 5*time.Second, "the first run", func() bool { return status(t, m, "db").Runs == 1 })
+	advanceUntil(t, clock, 100*time.Millisecond, "the pending restart", func() bool {
+		return status(t, m, "db").State == StatePendingRestart
+	})
+
+	warned := false
+	for !warned {
+		select {
+		case event := <-events:
+			warned = event.Service == "db" && event.Level == EVENT_LEVEL_WARN && strings.Contains(event.Message, "pending until 2026-10-17T02:00:00+02:00")
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no warning of the pending restart")
+		}
+	}
+
+	if err := m.Start("db"); err != nil {
+		t.Fatalf("start: %s", err)
+	}
+	eventually(t, 5*time.Second, "the started run", func() bool { return status(t, m, "db").Runs >= 2 })
+}
@@ -0,0 +1,281 @@
+package web
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/imunhatep/systemgo/system"
+)
+
+// AUTHENTICATED is the identity the audit log records for requests carrying the token
+const AUTHENTICATED = "authenticated"
+
+// MemoryUsage is the memory of a service as served by /services/<name>/memory,
+// PeakRSSKB is the peak of its runs since the manager started
+type MemoryUsage struct {
+	Name         string `json:"name"`
+	MemoryKB     uint64 `json:"memoryKb"`
+	MemoryMetric string `json:"memoryMetric,omitempty"`
+	PeakRSSKB    uint64 `json:"peakRssKb"`
+}
+
+type api struct {
+	manager *system.Manager
+	token   []byte
+}
+
+// APIHandler serves the control API of the manager as JSON, requests need
+// "Authorization: Bearer <token>" if token is set:
+//
+//	GET  /services                  statuses of all services
+//	GET  /services/<name>           status of the service
+//	POST /services/<name>/start     start, stop or restart it, audited
+//	GET  /services/<name>/output    its last captured lines, ?n=20&level=warn
+//	GET  /services/<name>/memory    its memory usage
+//	GET  /services/<name>/processes its processes with their children
+//	GET  /usage                     cpu time and peak memory of the services
+//	POST /reload                    read the configuration again and apply it, audited
+func APIHandler(manager *system.Manager, token string) http.Handler {
+	a := &api{manager: manager}
+	if token != "" {
+		a.token = []byte("Bearer " + token)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services", a.authorized(a.listServices))
+	mux.HandleFunc("/services/", a.authorized(a.service))
+	mux.HandleFunc("/usage", a.authorized(a.usage))
+	mux.HandleFunc("/reload", a.authorized(a.reload))
+
+	return mux
+}
+
+func (a *api) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.token != nil && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), a.token) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+func (a *api) listServices(w http.ResponseWriter, r *http.Request) {
+	if allowed(w, r, http.MethodGet) {
+		writeJSON(w, a.manager.ListServices())
+	}
+}
+
+func (a *api) usage(w http.ResponseWriter, r *http.Request) {
+	if allowed(w, r, http.MethodGet) {
+		writeJSON(w, a.manager.Usage())
+	}
+}
+
+func (a *api) reload(w http.ResponseWriter, r *http.Request) {
+	if !allowed(w, r, http.MethodPost) {
+		return
+	}
+
+	var plan system.Plan
+	err := a.manager.Audit(a.requester(r), "reload", a.manager.GetConfigPath(), func() error {
+		var err error
+		plan, err = a.manager.Reload()
+		return err
+	})
+
+	switch {
+	case errors.Is(err, system.ErrNoConfigPath):
+		writeError(w, http.StatusConflict, err)
+	case err != nil:
+		writeError(w, http.StatusBadRequest, err)
+	default:
+		writeJSON(w, plan)
+	}
+}
+
+// service routes /services/<name> and /services/<name>/<action>
+func (a *api) service(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/services/"), "/")
+	name, action := parts[0], ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+
+	if name == "" || len(parts) > 2 {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	switch action {
+	case "":
+		if allowed(w, r, http.MethodGet) {
+			a.writeStatus(w, name)
+		}
+	case "start", "stop", "restart":
+		if allowed(w, r, http.MethodPost) {
+			a.control(w, r, name, action)
+		}
+	case "output":
+		if allowed(w, r, http.MethodGet) {
+			a.output(w, r, name)
+		}
+	case "memory":
+		if allowed(w, r, http.MethodGet) {
+			a.memory(w, name)
+		}
+	case "processes":
+		if allowed(w, r, http.MethodGet) {
+			a.processes(w, name)
+		}
+	default:
+		writeError(w, http.StatusNotFound, errors.New("unknown action "+action))
+	}
+}
+
+func (a *api) writeStatus(w http.ResponseWriter, name string) {
+	status, err := a.manager.GetStatus(name)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+
+	writeJSON(w, status)
+}
+
+func (a *api) control(w http.ResponseWriter, r *http.Request, name, action string) {
+	run := map[string]func(string) error{
+		"start":   a.manager.Start,
+		"stop":    a.manager.Stop,
+		"restart": a.manager.Restart,
+	}[action]
+
+	err := a.manager.Audit(a.requester(r), action, name, func() error {
+		return run(name)
+	})
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+
+	a.writeStatus(w, name)
+}
+
+func (a *api) output(w http.ResponseWriter, r *http.Request, name string) {
+	n := system.OUTPUT_RECENT_LINES
+	if value := r.URL.Query().Get("n"); value != "" {
+		var err error
+		if n, err = strconv.Atoi(value); err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, errors.New("n is not a positive number"))
+			return
+		}
+	}
+
+	level := r.URL.Query().Get("level")
+	if _, ok := system.ParseLevel(level); level != "" && !ok {
+		writeError(w, http.StatusBadRequest, errors.New("unknown level "+strconv.Quote(level)))
+		return
+	}
+
+	lines, err := a.manager.GetRecentOutput(name, 0)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+
+	// the level is filtered before n, so n is the number of lines of the level
+	filtered := make([]system.LogLine, 0, len(lines))
+	for _, line := range lines {
+		if system.LevelAtLeast(line.Level, level) {
+			filtered = append(filtered, line)
+		}
+	}
+	if len(filtered) > n {
+		filtered = filtered[len(filtered)-n:]
+	}
+
+	writeJSON(w, filtered)
+}
+
+func (a *api) memory(w http.ResponseWriter, name string) {
+	status, err := a.manager.GetStatus(name)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+
+	memory := MemoryUsage{Name: name, MemoryKB: status.MemoryKB, MemoryMetric: status.MemoryMetric}
+	for _, usage := range a.manager.Usage() {
+		if usage.Name == name {
+			memory.PeakRSSKB = usage.PeakRSSKB
+		}
+	}
+
+	writeJSON(w, memory)
+}
+
+func (a *api) processes(w http.ResponseWriter, name string) {
+	tree, err := a.manager.GetProcessTree(name)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+
+	writeJSON(w, tree)
+}
+
+// requester is who made the request for the audit log, authenticated if the
+// token is required
+func (a *api) requester(r *http.Request) system.Requester {
+	requester := system.Requester{Source: r.RemoteAddr}
+	if a.token != nil {
+		requester.Identity = AUTHENTICATED
+	}
+
+	return requester
+}
+
+// allowed answers 405 to requests of other methods
+func allowed(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, errors.New(r.Method+" is not allowed"))
+
+	return false
+}
+
+// errorStatus maps the errors of the manager to the status codes of the API,
+// as rpc maps them to gRPC codes
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, system.ErrServiceNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, system.ErrAlreadyRunning), errors.Is(err, system.ErrNotRunning),
+		errors.Is(err, system.ErrFrozen), errors.Is(err, system.ErrNotFrozen), errors.Is(err, system.ErrNoProcess),
+		errors.Is(err, system.ErrProcUnavailable), errors.Is(err, system.ErrExternal), errors.Is(err, system.ErrLocked):
+		return http.StatusConflict
+	case errors.Is(err, system.ErrManagerNotStarted), errors.Is(err, system.ErrShuttingDown):
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
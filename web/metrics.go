@@ -0,0 +1,206 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/imunhatep/systemgo/system"
+)
+
+// METRICS_CONTENT_TYPE is the Prometheus text exposition format
+const METRICS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// MetricsHandler serves the state, runs, exit codes, uptime, memory and cpu
+// time of the services and the stats of the supervisor in the Prometheus text
+// format, requests need "Authorization: Bearer <token>" if token is set
+func MetricsHandler(manager *system.Manager, token string) http.Handler {
+	a := &api{manager: manager}
+	if token != "" {
+		a.token = []byte("Bearer " + token)
+	}
+
+	return a.authorized(func(w http.ResponseWriter, r *http.Request) {
+		if !allowed(w, r, http.MethodGet) {
+			return
+		}
+
+		var m metrics
+		m.services(manager)
+		m.supervisor(manager)
+
+		w.Header().Set("Content-Type", METRICS_CONTENT_TYPE)
+		w.Write(m.Bytes())
+	})
+}
+
+// metrics writes families of samples, each one once with its help and type
+type metrics struct {
+	bytes.Buffer
+}
+
+type sample struct {
+	labels []string
+	value  float64
+}
+
+// family writes the samples of a metric, labels are name and value pairs
+func (m *metrics) family(name, kind, help string, samples []sample) {
+	if len(samples) == 0 {
+		return
+	}
+
+	fmt.Fprintf(m, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	for _, sample := range samples {
+		m.WriteString(name)
+		if len(sample.labels) > 0 {
+			pairs := make([]string, 0, len(sample.labels)/2)
+			for i := 0; i+1 < len(sample.labels); i += 2 {
+				pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", sample.labels[i], escapeLabel(sample.labels[i+1])))
+			}
+			m.WriteString("{" + strings.Join(pairs, ",") + "}")
+		}
+		m.WriteString(" " + strconv.FormatFloat(sample.value, 'f', -1, 64) + "\n")
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func (m *metrics) services(manager *system.Manager) {
+	statuses := manager.ListServices()
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+
+	usage := make(map[string]system.ServiceUsage)
+	for _, u := range manager.Usage() {
+		usage[u.Name] = u
+	}
+
+	var state, durations, up, runs, exit, failed, started, uptime, memory, peak, cpu, kills, lines, dropped []sample
+	now := time.Now()
+	for _, status := range statuses {
+		name := []string{"service", status.Name}
+		for _, s := range system.States() {
+			state = append(state, sample{[]string{"service", status.Name, "state", s.String()}, boolValue(status.State == s)})
+			if d, ok := status.StateDurations[s]; ok {
+				durations = append(durations, sample{[]string{"service", status.Name, "state", s.String()}, d.Seconds()})
+			}
+		}
+
+		switch status.State {
+		case system.StateRunning, system.StateReady, system.StateListening, system.StateScheduled:
+			up = append(up, sample{name, 1})
+		default:
+			up = append(up, sample{name, 0})
+		}
+
+		runs = append(runs, sample{name, float64(status.Runs)})
+		kills = append(kills, sample{name, float64(status.ForcedKills)})
+		dropped = append(dropped, sample{name, float64(status.OutputDropped)})
+
+		// a service that never exited has no exit code yet
+		if status.LastExitCode >= 0 {
+			exit = append(exit, sample{name, float64(status.LastExitCode)})
+		}
+
+		if status.Runs > 0 {
+			failed = append(failed, sample{name, boolValue(status.LastStopReason.IsInvoluntary())})
+		}
+
+		if status.PID > 0 && !status.StartedAt.IsZero() {
+			started = append(started, sample{name, float64(status.StartedAt.UnixNano()) / 1e9})
+			uptime = append(uptime, sample{name, now.Sub(status.StartedAt).Seconds()})
+			memory = append(memory, sample{name, float64(status.MemoryKB * 1024)})
+		}
+
+		levels := make([]string, 0, len(status.OutputLevels))
+		for level := range status.OutputLevels {
+			levels = append(levels, level)
+		}
+		sort.Strings(levels)
+		for _, level := range levels {
+			lines = append(lines, sample{[]string{"service", status.Name, "level", level}, float64(status.OutputLevels[level])})
+		}
+
+		if u, ok := usage[status.Name]; ok {
+			peak = append(peak, sample{name, float64(u.PeakRSSKB * 1024)})
+			cpu = append(cpu,
+				sample{[]string{"service", status.Name, "mode", "user"}, u.UserCPU.Seconds()},
+				sample{[]string{"service", status.Name, "mode", "system"}, u.SystemCPU.Seconds()})
+		}
+	}
+
+	m.family("systemgo_service_state", "gauge", "State of the service, 1 for the current one.", state)
+	m.family("systemgo_service_state_seconds_total", "counter", "Time the service spent in each state.", durations)
+	m.family("systemgo_service_up", "gauge", "Whether the service is running, ready or listening.", up)
+	m.family("systemgo_service_runs_total", "counter", "Runs of the service, restarts included.", runs)
+	m.family("systemgo_service_last_exit_code", "gauge", "Exit code of the last run of the service.", exit)
+	m.family("systemgo_service_last_run_failed", "gauge", "Whether the last run of the service failed, by its stop reason.", failed)
+	m.family("systemgo_service_start_time_seconds", "gauge", "Start time of the running process since the epoch.", started)
+	m.family("systemgo_service_uptime_seconds", "gauge", "Time the running process has been up.", uptime)
+	m.family("systemgo_service_memory_bytes", "gauge", "Memory of the running process in its memory metric.", memory)
+	m.family("systemgo_service_peak_rss_bytes", "gauge", "Peak resident memory of the runs since the supervisor started.", peak)
+	m.family("systemgo_service_cpu_seconds_total", "counter", "CPU time of the runs since the supervisor started.", cpu)
+	m.family("systemgo_service_forced_kills_total", "counter", "Runs killed as they did not stop in time.", kills)
+	m.family("systemgo_service_output_lines_total", "counter", "Captured output lines per level.", lines)
+	m.family("systemgo_service_output_dropped_total", "counter", "Output lines dropped as the buffer was full.", dropped)
+}
+
+func (m *metrics) supervisor(manager *system.Manager) {
+	health := manager.Healthz()
+	m.family("systemgo_healthy", "gauge", "Whether the supervisor is healthy, see /healthz.", []sample{{nil, boolValue(health.Healthy)}})
+	m.family("systemgo_services", "gauge", "Services supervised.", []sample{{nil, float64(health.Services)}})
+	m.family("systemgo_services_failed", "gauge", "Services failed.", []sample{{nil, float64(health.Failed)}})
+
+	groups := make([]string, 0, len(health.Groups))
+	for group := range health.Groups {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	var groupHealth []sample
+	for _, group := range groups {
+		for _, state := range []string{system.GROUP_HEALTHY, system.GROUP_DEGRADED, system.GROUP_UNHEALTHY} {
+			groupHealth = append(groupHealth, sample{[]string{"group", group, "health", state}, boolValue(health.Groups[group] == state)})
+		}
+	}
+	m.family("systemgo_group_health", "gauge", "Aggregated health of the group, 1 for the current one.", groupHealth)
+
+	stats := manager.SchedulerStats()
+	m.family("systemgo_scheduler_jobs", "gauge", "Jobs of the scheduler of probes, samples and hooks.", []sample{
+		{[]string{"status", "scheduled"}, float64(stats.Scheduled)},
+		{[]string{"status", "queued"}, float64(stats.Queued)},
+		{[]string{"status", "running"}, float64(stats.Running)},
+	})
+	m.family("systemgo_scheduler_jobs_done_total", "counter", "Jobs run by the scheduler.", []sample{{nil, float64(stats.Done)}})
+	m.family("systemgo_scheduler_jobs_missed_total", "counter", "Jobs skipped as they missed their deadline.", []sample{{nil, float64(stats.Missed)}})
+
+	var connected, buffered, sent, dropped []sample
+	for _, forward := range manager.ForwardStats() {
+		address := []string{"address", forward.Address}
+		connected = append(connected, sample{address, boolValue(forward.Connected)})
+		buffered = append(buffered, sample{address, float64(forward.Buffered)})
+		sent = append(sent, sample{address, float64(forward.Sent)})
+		dropped = append(dropped, sample{address, float64(forward.Dropped)})
+	}
+
+	m.family("systemgo_forward_connected", "gauge", "Whether the log collector is connected.", connected)
+	m.family("systemgo_forward_buffered_lines", "gauge", "Lines kept for the log collector.", buffered)
+	m.family("systemgo_forward_sent_lines_total", "counter", "Lines sent to the log collector.", sent)
+	m.family("systemgo_forward_dropped_lines_total", "counter", "Lines dropped for the log collector.", dropped)
+}
@@ -1 +1,38 @@
 package web
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/imunhatep/systemgo/system"
+)
+
+// NewHandler returns the HTTP endpoints of the manager, /healthz, /metrics and
+// the APIHandler, the last two requiring token if it is set
+func NewHandler(manager *system.Manager, token string) http.Handler {
+	api := APIHandler(manager, token)
+
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", HealthHandler(manager))
+	mux.Handle("/metrics", MetricsHandler(manager, token))
+	mux.Handle("/services", api)
+	mux.Handle("/services/", api)
+	mux.Handle("/usage", api)
+	mux.Handle("/reload", api)
+
+	return mux
+}
+
+// HealthHandler serves Manager.Healthz as JSON, with status 503 when the manager is not healthy
+func HealthHandler(manager *system.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		health := manager.Healthz()
+
+		w.Header().Set("Content-Type", "application/json")
+		if !health.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		json.NewEncoder(w).Encode(health)
+	})
+}